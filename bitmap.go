@@ -0,0 +1,343 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/bitmap"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/idxfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+)
+
+// bitmapCommitInterval is the number of commits between the commits with a
+// bitmap, besides the ones of the references.
+const bitmapCommitInterval = 100
+
+// bitmapWriter computes the bitmaps of the commits of a pack.
+type bitmapWriter struct {
+	s    storer.EncodedObjectStorer
+	pack *bitmap.PackBitmap
+	idx  *bitmap.Index
+	// commits are the bitmaps computed so far.
+	commits map[plumbing.Hash]*bitmap.Bitmap
+}
+
+type bitmapWalkItem struct {
+	hash plumbing.Hash
+	typ  plumbing.ObjectType
+}
+
+// writePackBitmap writes the bitmap index of the given pack, which must hold
+// all the objects reachable from the references.
+func (r *Repository) writePackBitmap(s gcStorer, h plumbing.Hash) error {
+	fs := s.Filesystem()
+	pi, err := readPackIndex(fs, h)
+	if err != nil {
+		return err
+	}
+
+	idx := &bitmap.Index{
+		PackChecksum: pi.PackfileChecksum,
+		Commits:      bitmap.NewBitmap(),
+		Trees:        bitmap.NewBitmap(),
+		Blobs:        bitmap.NewBitmap(),
+		Tags:         bitmap.NewBitmap(),
+	}
+
+	pack, err := bitmap.NewPackBitmap(&bitmap.Index{}, pi)
+	if err != nil {
+		return err
+	}
+
+	tips, err := r.bitmapTips()
+	if err != nil {
+		return err
+	}
+
+	selected, err := r.selectBitmapCommits(tips)
+	if err != nil {
+		return err
+	}
+
+	w := &bitmapWriter{
+		s:       r.Storer,
+		pack:    pack,
+		idx:     idx,
+		commits: make(map[plumbing.Hash]*bitmap.Bitmap, len(selected)),
+	}
+
+	// The commits are sorted parents first, so the bitmaps of the parents
+	// are used to compute the ones of their children.
+	for _, c := range selected {
+		b, err := w.reachable(c, plumbing.CommitObject)
+		if err != nil {
+			return err
+		}
+
+		w.commits[c] = b
+	}
+
+	// The types of the objects which are not reachable from the commits are
+	// found walking from the references too.
+	for _, h := range tips {
+		if _, err := w.reachable(h, plumbing.AnyObject); err != nil {
+			return err
+		}
+	}
+
+	if err := w.typeUnreachable(); err != nil {
+		return err
+	}
+
+	if err := w.addEntries(pi, selected); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := bitmap.NewEncoder(&buf).Encode(idx); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(fs, packPath(h, "bitmap"), buf.Bytes())
+}
+
+// bitmapTips returns the objects of the references.
+func (r *Repository) bitmapTips() ([]plumbing.Hash, error) {
+	iter, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []plumbing.Hash
+	seen := make(map[plumbing.Hash]bool)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && !seen[ref.Hash()] {
+			seen[ref.Hash()] = true
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+
+	return tips, err
+}
+
+// selectBitmapCommits returns the commits of the references and one commit
+// every bitmapCommitInterval, sorted parents first.
+func (r *Repository) selectBitmapCommits(tips []plumbing.Hash) ([]plumbing.Hash, error) {
+	isTip := make(map[plumbing.Hash]bool)
+	var roots []*object.Commit
+	for _, h := range tips {
+		c, err := r.peelToCommit(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if c != nil && !isTip[c.Hash] {
+			isTip[c.Hash] = true
+			roots = append(roots, c)
+		}
+	}
+
+	type frame struct {
+		commit *object.Commit
+		parent int
+	}
+
+	var selected []plumbing.Hash
+	visited := make(map[plumbing.Hash]bool)
+	n := 0
+	for _, root := range roots {
+		if visited[root.Hash] {
+			continue
+		}
+
+		visited[root.Hash] = true
+		stack := []*frame{{commit: root}}
+		for len(stack) > 0 {
+			f := stack[len(stack)-1]
+			if f.parent < len(f.commit.ParentHashes) {
+				p := f.commit.ParentHashes[f.parent]
+				f.parent++
+				if visited[p] {
+					continue
+				}
+
+				visited[p] = true
+				c, err := object.GetCommit(r.Storer, p)
+				if err != nil {
+					return nil, err
+				}
+
+				stack = append(stack, &frame{commit: c})
+				continue
+			}
+
+			stack = stack[:len(stack)-1]
+			n++
+			if isTip[f.commit.Hash] || n%bitmapCommitInterval == 0 {
+				selected = append(selected, f.commit.Hash)
+			}
+		}
+	}
+
+	return selected, nil
+}
+
+// reachable returns the objects reachable from the given one, recording
+// their types.
+func (w *bitmapWriter) reachable(h plumbing.Hash, typ plumbing.ObjectType) (*bitmap.Bitmap, error) {
+	b := bitmap.NewBitmap()
+	pending := []bitmapWalkItem{{h, typ}}
+	for len(pending) > 0 {
+		item := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		pos, ok, err := w.pack.Position(item.hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("object %s is not in the pack", item.hash)
+		}
+
+		if b.Get(pos) {
+			continue
+		}
+
+		if reachable, ok := w.commits[item.hash]; ok {
+			b.Or(reachable)
+			continue
+		}
+
+		if item.typ == plumbing.AnyObject {
+			o, err := w.s.EncodedObject(plumbing.AnyObject, item.hash)
+			if err != nil {
+				return nil, err
+			}
+
+			item.typ = o.Type()
+		}
+
+		b.Set(pos)
+		w.typeBitmap(item.typ).Set(pos)
+		switch item.typ {
+		case plumbing.CommitObject:
+			c, err := object.GetCommit(w.s, item.hash)
+			if err != nil {
+				return nil, err
+			}
+
+			pending = append(pending, bitmapWalkItem{c.TreeHash, plumbing.TreeObject})
+			for _, p := range c.ParentHashes {
+				pending = append(pending, bitmapWalkItem{p, plumbing.CommitObject})
+			}
+		case plumbing.TreeObject:
+			t, err := object.GetTree(w.s, item.hash)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range t.Entries {
+				switch e.Mode {
+				case filemode.Submodule:
+				case filemode.Dir:
+					pending = append(pending, bitmapWalkItem{e.Hash, plumbing.TreeObject})
+				default:
+					pending = append(pending, bitmapWalkItem{e.Hash, plumbing.BlobObject})
+				}
+			}
+		case plumbing.TagObject:
+			t, err := object.GetTag(w.s, item.hash)
+			if err != nil {
+				return nil, err
+			}
+
+			pending = append(pending, bitmapWalkItem{t.Target, t.TargetType})
+		}
+	}
+
+	return b, nil
+}
+
+// typeUnreachable records the types of the objects of the pack which are not
+// reachable from the references.
+func (w *bitmapWriter) typeUnreachable() error {
+	for pos := 0; pos < w.pack.Len(); pos++ {
+		if w.idx.Commits.Get(pos) || w.idx.Trees.Get(pos) ||
+			w.idx.Blobs.Get(pos) || w.idx.Tags.Get(pos) {
+			continue
+		}
+
+		h, err := w.pack.Hash(pos)
+		if err != nil {
+			return err
+		}
+
+		o, err := w.s.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return err
+		}
+
+		w.typeBitmap(o.Type()).Set(pos)
+	}
+
+	return nil
+}
+
+func (w *bitmapWriter) typeBitmap(t plumbing.ObjectType) *bitmap.Bitmap {
+	switch t {
+	case plumbing.CommitObject:
+		return w.idx.Commits
+	case plumbing.TreeObject:
+		return w.idx.Trees
+	case plumbing.TagObject:
+		return w.idx.Tags
+	default:
+		return w.idx.Blobs
+	}
+}
+
+// addEntries adds the entries of the selected commits, with their position
+// in the pack index.
+func (w *bitmapWriter) addEntries(pi idxfile.Index, selected []plumbing.Hash) error {
+	iter, err := pi.Entries()
+	if err != nil {
+		return err
+	}
+
+	defer iter.Close()
+	positions := make(map[plumbing.Hash]uint32, len(selected))
+	for _, h := range selected {
+		positions[h] = 0
+	}
+
+	for pos := uint32(0); ; pos++ {
+		e, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, ok := positions[e.Hash]; ok {
+			positions[e.Hash] = pos
+		}
+	}
+
+	for _, h := range selected {
+		w.idx.Entries = append(w.idx.Entries, &bitmap.Entry{
+			Position: positions[h],
+			Bitmap:   w.commits[h],
+		})
+	}
+
+	return nil
+}
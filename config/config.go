@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/jesseduffield/go-git/v5/internal/url"
@@ -89,6 +90,12 @@ type Config struct {
 		// compression.  The default is 10.  A value of 0 turns off
 		// delta compression entirely.
 		Window uint
+		// Depth is the maximum length of the delta chains. The default is
+		// 50.
+		Depth uint
+		// WindowMemory limits the size of the objects in the sliding window
+		// for delta compression, in bytes. The default is 0, no limit.
+		WindowMemory uint64
 	}
 
 	Init struct {
@@ -107,6 +114,11 @@ type Config struct {
 		// This setting must not be changed after repository initialization
 		// (e.g. clone or init).
 		ObjectFormat format.ObjectFormat
+		// RefStorage specifies the storage of the references. The
+		// acceptable values are files and reftable. If not specified,
+		// files is assumed. It is an error to specify this key unless
+		// core.repositoryFormatVersion is 1.
+		RefStorage format.RefStorage
 	}
 
 	// Remotes list of repository remotes, the key of the map is the name
@@ -138,6 +150,7 @@ func NewConfig() *Config {
 	}
 
 	config.Pack.Window = DefaultPackWindow
+	config.Pack.Depth = DefaultPackDepth
 
 	return config
 }
@@ -257,6 +270,8 @@ const (
 	worktreeKey                = "worktree"
 	commentCharKey             = "commentChar"
 	windowKey                  = "window"
+	depthKey                   = "depth"
+	windowMemoryKey            = "windowMemory"
 	mergeKey                   = "merge"
 	rebaseKey                  = "rebase"
 	nameKey                    = "name"
@@ -265,11 +280,17 @@ const (
 	defaultBranchKey           = "defaultBranch"
 	repositoryFormatVersionKey = "repositoryformatversion"
 	objectFormat               = "objectformat"
+	refStorageKey              = "refstorage"
 	mirrorKey                  = "mirror"
+	promisorKey                = "promisor"
+	partialCloneFilterKey      = "partialclonefilter"
 
 	// DefaultPackWindow holds the number of previous objects used to
 	// generate deltas. The value 10 is the same used by git command.
 	DefaultPackWindow = uint(10)
+	// DefaultPackDepth holds the maximum length of the delta chains. The
+	// value 50 is the same used by git command.
+	DefaultPackDepth = uint(50)
 )
 
 // Unmarshal parses a git-config file and stores it.
@@ -283,6 +304,7 @@ func (c *Config) Unmarshal(b []byte) error {
 	}
 
 	c.unmarshalCore()
+	c.unmarshalExtensions()
 	c.unmarshalUser()
 	c.unmarshalInit()
 	if err := c.unmarshalPack(); err != nil {
@@ -309,6 +331,13 @@ func (c *Config) unmarshalCore() {
 
 	c.Core.Worktree = s.Options.Get(worktreeKey)
 	c.Core.CommentChar = s.Options.Get(commentCharKey)
+	c.Core.RepositoryFormatVersion = format.RepositoryFormatVersion(s.Options.Get(repositoryFormatVersionKey))
+}
+
+func (c *Config) unmarshalExtensions() {
+	s := c.Raw.Section(extensionsSection)
+	c.Extensions.ObjectFormat = format.ObjectFormat(s.Options.Get(objectFormat))
+	c.Extensions.RefStorage = format.RefStorage(s.Options.Get(refStorageKey))
 }
 
 func (c *Config) unmarshalUser() {
@@ -337,9 +366,54 @@ func (c *Config) unmarshalPack() error {
 		}
 		c.Pack.Window = uint(winUint)
 	}
+
+	depth := s.Options.Get(depthKey)
+	if depth == "" {
+		c.Pack.Depth = DefaultPackDepth
+	} else {
+		depthUint, err := strconv.ParseUint(depth, 10, 32)
+		if err != nil {
+			return err
+		}
+		c.Pack.Depth = uint(depthUint)
+	}
+
+	c.Pack.WindowMemory = 0
+	if windowMemory := s.Options.Get(windowMemoryKey); windowMemory != "" {
+		size, err := parseSize(windowMemory)
+		if err != nil {
+			return err
+		}
+		c.Pack.WindowMemory = size
+	}
+
 	return nil
 }
 
+// parseSize parses a size with an optional k, m or g unit, like git does.
+func parseSize(s string) (uint64, error) {
+	unit := uint64(1)
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		unit = 1 << 10
+	case "m":
+		unit = 1 << 20
+	case "g":
+		unit = 1 << 30
+	}
+
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return n * unit, nil
+}
+
 func (c *Config) unmarshalRemotes() error {
 	s := c.Raw.Section(remoteSection)
 	for _, sub := range s.Subsections {
@@ -443,7 +517,17 @@ func (c *Config) marshalExtensions() {
 	// ignore them otherwise.
 	if c.Core.RepositoryFormatVersion == format.Version_1 {
 		s := c.Raw.Section(extensionsSection)
-		s.SetOption(objectFormat, string(c.Extensions.ObjectFormat))
+		if c.Extensions.ObjectFormat == "" {
+			s.RemoveOption(objectFormat)
+		} else {
+			s.SetOption(objectFormat, string(c.Extensions.ObjectFormat))
+		}
+
+		if c.Extensions.RefStorage == "" {
+			s.RemoveOption(refStorageKey)
+		} else {
+			s.SetOption(refStorageKey, string(c.Extensions.RefStorage))
+		}
 	}
 }
 
@@ -481,6 +565,14 @@ func (c *Config) marshalPack() {
 	if c.Pack.Window != DefaultPackWindow {
 		s.SetOption(windowKey, fmt.Sprintf("%d", c.Pack.Window))
 	}
+
+	if c.Pack.Depth != DefaultPackDepth && c.Pack.Depth != 0 {
+		s.SetOption(depthKey, fmt.Sprintf("%d", c.Pack.Depth))
+	}
+
+	if c.Pack.WindowMemory != 0 {
+		s.SetOption(windowMemoryKey, fmt.Sprintf("%d", c.Pack.WindowMemory))
+	}
 }
 
 func (c *Config) marshalRemotes() {
@@ -590,6 +682,13 @@ type RemoteConfig struct {
 
 	// Fetch the default set of "refspec" for fetch operation
 	Fetch []RefSpec
+	// Promisor indicates that the remote is a promisor remote, from which
+	// the objects omitted by the filter of a partial clone are fetched
+	// lazily.
+	Promisor bool
+	// PartialCloneFilter is the filter of the partial clone from the remote,
+	// also used by the later fetches from it, see packp.Filter.
+	PartialCloneFilter string
 
 	// raw representation of the subsection, filled by marshal or unmarshal are
 	// called
@@ -637,6 +736,8 @@ func (c *RemoteConfig) unmarshal(s *format.Subsection) error {
 	c.URLs = append(c.URLs, c.raw.Options.GetAll(pushurlKey)...)
 	c.Fetch = fetch
 	c.Mirror = c.raw.Options.Get(mirrorKey) == "true"
+	c.Promisor = c.raw.Options.Get(promisorKey) == "true"
+	c.PartialCloneFilter = c.raw.Options.Get(partialCloneFilterKey)
 
 	return nil
 }
@@ -673,6 +774,18 @@ func (c *RemoteConfig) marshal() *format.Subsection {
 		c.raw.SetOption(mirrorKey, strconv.FormatBool(c.Mirror))
 	}
 
+	if c.Promisor {
+		c.raw.SetOption(promisorKey, strconv.FormatBool(c.Promisor))
+	} else {
+		c.raw.RemoveOption(promisorKey)
+	}
+
+	if c.PartialCloneFilter == "" {
+		c.raw.RemoveOption(partialCloneFilterKey)
+	} else {
+		c.raw.SetOption(partialCloneFilterKey, c.PartialCloneFilter)
+	}
+
 	return c.raw
 }
 
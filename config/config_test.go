@@ -8,6 +8,7 @@ import (
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/jesseduffield/go-git/v5/plumbing"
+	format "github.com/jesseduffield/go-git/v5/plumbing/format/config"
 	. "gopkg.in/check.v1"
 )
 
@@ -31,6 +32,8 @@ func (s *ConfigSuite) TestUnmarshal(c *C) {
 		email = richard@example.com
 [pack]
 		window = 20
+		depth = 10
+		windowMemory = 64m
 [remote "origin"]
 		url = git@github.com:mcuadros/go-git.git
 		fetch = +refs/heads/*:refs/remotes/origin/*
@@ -71,6 +74,8 @@ func (s *ConfigSuite) TestUnmarshal(c *C) {
 	c.Assert(cfg.Committer.Name, Equals, "Richard Roe")
 	c.Assert(cfg.Committer.Email, Equals, "richard@example.com")
 	c.Assert(cfg.Pack.Window, Equals, uint(20))
+	c.Assert(cfg.Pack.Depth, Equals, uint(10))
+	c.Assert(cfg.Pack.WindowMemory, Equals, uint64(64<<20))
 	c.Assert(cfg.Remotes, HasLen, 4)
 	c.Assert(cfg.Remotes["origin"].Name, Equals, "origin")
 	c.Assert(cfg.Remotes["origin"].URLs, DeepEquals, []string{"git@github.com:mcuadros/go-git.git"})
@@ -97,6 +102,8 @@ func (s *ConfigSuite) TestMarshal(c *C) {
 	worktree = bar
 [pack]
 	window = 20
+	depth = 10
+	windowMemory = 1024
 [remote "alt"]
 	url = git@github.com:mcuadros/go-git.git
 	url = git@github.com:src-d/go-git.git
@@ -124,6 +131,8 @@ func (s *ConfigSuite) TestMarshal(c *C) {
 	cfg.Core.IsBare = true
 	cfg.Core.Worktree = "bar"
 	cfg.Pack.Window = 20
+	cfg.Pack.Depth = 10
+	cfg.Pack.WindowMemory = 1024
 	cfg.Init.DefaultBranch = "main"
 	cfg.Remotes["origin"] = &RemoteConfig{
 		Name: "origin",
@@ -209,6 +218,37 @@ func (s *ConfigSuite) TestUnmarshalMarshal(c *C) {
 	c.Assert(string(output), DeepEquals, string(input))
 }
 
+func (s *ConfigSuite) TestUnmarshalMarshalExtensions(c *C) {
+	input := []byte(`[core]
+	repositoryformatversion = 1
+	bare = false
+[extensions]
+	refStorage = reftable
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Core.RepositoryFormatVersion, Equals, format.RepositoryFormatVersion(format.Version_1))
+	c.Assert(cfg.Extensions.ObjectFormat, Equals, format.ObjectFormat(""))
+	c.Assert(cfg.Extensions.RefStorage, Equals, format.ReftableRefStorage)
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+
+	cfg.Extensions.ObjectFormat = format.SHA256
+	cfg.Extensions.RefStorage = ""
+	output, err = cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, `[core]
+	repositoryformatversion = 1
+	bare = false
+[extensions]
+	objectformat = sha256
+`)
+}
+
 func (s *ConfigSuite) TestLoadConfigXDG(c *C) {
 	cfg := NewConfig()
 	cfg.User.Name = "foo"
@@ -342,6 +382,8 @@ func (s *ConfigSuite) TestRemoteConfigDefaultValues(c *C) {
 	c.Assert(config.Submodules, HasLen, 0)
 	c.Assert(config.Raw, NotNil)
 	c.Assert(config.Pack.Window, Equals, DefaultPackWindow)
+	c.Assert(config.Pack.Depth, Equals, DefaultPackDepth)
+	c.Assert(config.Pack.WindowMemory, Equals, uint64(0))
 }
 
 func (s *ConfigSuite) TestLoadConfigLocalScope(c *C) {
@@ -372,6 +414,33 @@ func (s *ConfigSuite) TestRemoveUrlOptions(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *ConfigSuite) TestUnmarshalMarshalPromisor(c *C) {
+	input := []byte(`[core]
+	bare = false
+[remote "origin"]
+	url = https://github.com/git-fixtures/basic.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	promisor = true
+	partialclonefilter = blob:none
+`)
+
+	cfg := NewConfig()
+	err := cfg.Unmarshal(input)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Remotes["origin"].Promisor, Equals, true)
+	c.Assert(cfg.Remotes["origin"].PartialCloneFilter, Equals, "blob:none")
+
+	output, err := cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, string(input))
+
+	cfg.Remotes["origin"].Promisor = false
+	cfg.Remotes["origin"].PartialCloneFilter = ""
+	output, err = cfg.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Not(Matches), "(?s).*(promisor|partialclonefilter).*")
+}
+
 func (s *ConfigSuite) TestUnmarshalRemotes(c *C) {
 	input := []byte(`[core]
 	bare = true
@@ -394,4 +463,3 @@ func (s *ConfigSuite) TestUnmarshalRemotes(c *C) {
 	c.Assert(cfg.Remotes["origin"].URLs[0], Equals, "https://git.sr.ht/~mcepl/go-git")
 	c.Assert(cfg.Remotes["origin"].URLs[1], Equals, "git@git.sr.ht:~mcepl/go-git.git")
 }
-
@@ -0,0 +1,486 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	commitgraphfmt "github.com/jesseduffield/go-git/v5/plumbing/format/commitgraph/v2"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/object/commitgraph"
+)
+
+// ErrNoTagFound is returned by Repository.Describe when no tag can describe
+// the commit.
+var ErrNoTagFound = errors.New("no tag can describe the commit")
+
+const (
+	// describeCandidates is the number of tags considered before the search
+	// of the nearest one stops, as the default of git describe.
+	describeCandidates = 10
+	// describeSeen flags the commits already queued while describing.
+	describeSeen = 1
+	// mergeTraversalWeight is the distance added when following a merged
+	// parent, favoring the names following the first parents.
+	mergeTraversalWeight = 65535
+)
+
+// Describe returns a name for a commit, like git describe: the nearest tag
+// reachable from it, followed by the number of commits since the tag and the
+// abbreviated hash of the commit, as in v1.0-3-g8c3f2a1. Only the tag is
+// returned for a tagged commit. With DescribeOptions.Contains the commit is
+// described by the oldest tag containing it instead, as in v1.0~2.
+//
+// The history is walked with the commit-graph when the repository has one.
+// ErrNoTagFound is returned if no tag can describe the commit.
+func (r *Repository) Describe(opts *DescribeOptions) (string, error) {
+	if err := opts.Validate(r); err != nil {
+		return "", err
+	}
+
+	tags, err := r.describeTags(opts)
+	if err != nil {
+		return "", err
+	}
+
+	idx, release := r.commitNodeIndex()
+	defer release()
+
+	var desc string
+	if opts.Contains {
+		desc, err = r.describeContains(idx, tags, opts)
+	} else {
+		desc, err = r.describe(idx, tags, opts)
+	}
+
+	if err != nil || !opts.Dirty {
+		return desc, err
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	clean, err := w.isClean()
+	if err != nil {
+		return "", err
+	}
+
+	if !clean {
+		desc += opts.DirtyMark
+	}
+
+	return desc, nil
+}
+
+// describeTag is a tag which can describe commits.
+type describeTag struct {
+	name      string
+	annotated bool
+	// date is the date of the tagger, or of the committer for lightweight
+	// tags.
+	date time.Time
+}
+
+// describeTags returns the tags matching the options by the commit they
+// point to. When several tags point to the same commit, annotated tags are
+// preferred, then the most recent ones, then the first in name order.
+func (r *Repository) describeTags(opts *DescribeOptions) (map[plumbing.Hash]*describeTag, error) {
+	iter, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		if matchAny(opts.Exclude, name) || (len(opts.Match) > 0 && !matchAny(opts.Match, name)) {
+			return nil
+		}
+
+		refs = append(refs, ref)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name() < refs[j].Name() })
+
+	tags := make(map[plumbing.Hash]*describeTag)
+	for _, ref := range refs {
+		h, t, err := r.peelDescribeTag(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if t == nil {
+			continue
+		}
+
+		if e, ok := tags[h]; ok && (e.annotated && !t.annotated ||
+			e.annotated == t.annotated && (!t.annotated || !t.date.After(e.date))) {
+			continue
+		}
+
+		tags[h] = t
+	}
+
+	return tags, nil
+}
+
+// peelDescribeTag returns the commit a tag points to, or nil if it does not
+// point to a commit.
+func (r *Repository) peelDescribeTag(ref *plumbing.Reference) (plumbing.Hash, *describeTag, error) {
+	t := &describeTag{name: ref.Name().Short()}
+	obj, err := r.Storer.EncodedObject(plumbing.AnyObject, ref.Hash())
+	if err == plumbing.ErrObjectNotFound {
+		return plumbing.ZeroHash, nil, nil
+	}
+
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	for obj.Type() == plumbing.TagObject {
+		tag, err := object.DecodeTag(r.Storer, obj)
+		if err != nil {
+			return plumbing.ZeroHash, nil, err
+		}
+
+		if !t.annotated {
+			t.annotated = true
+			t.date = tag.Tagger.When
+		}
+
+		if obj, err = r.Storer.EncodedObject(plumbing.AnyObject, tag.Target); err != nil {
+			return plumbing.ZeroHash, nil, err
+		}
+	}
+
+	if obj.Type() != plumbing.CommitObject {
+		return plumbing.ZeroHash, nil, nil
+	}
+
+	if !t.annotated {
+		c, err := object.DecodeCommit(r.Storer, obj)
+		if err != nil {
+			return plumbing.ZeroHash, nil, err
+		}
+
+		t.date = c.Committer.When
+	}
+
+	return obj.Hash(), t, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// describeCandidate is a tag reachable from the described commit.
+type describeCandidate struct {
+	tag *describeTag
+	// depth is the number of commits reachable from the described commit
+	// and not from the tag.
+	depth int
+	// flag marks the commits reachable from the tag.
+	flag  uint
+	order int
+}
+
+// describe finds the nearest tag reachable from the commit, with the
+// algorithm of git: the history is walked by date, the first tags found are
+// the candidates, and the one with the fewest commits not reachable from it
+// wins.
+func (r *Repository) describe(idx commitgraph.CommitNodeIndex, tags map[plumbing.Hash]*describeTag, opts *DescribeOptions) (string, error) {
+	if t, ok := tags[opts.Hash]; ok && (opts.Tags || t.annotated) {
+		if !opts.Long || opts.Abbrev < 0 {
+			return t.name, nil
+		}
+
+		return fmt.Sprintf("%s-0-g%s", t.name, r.abbreviateHash(opts.Hash, opts.Abbrev)), nil
+	}
+
+	start, err := idx.Get(opts.Hash)
+	if err != nil {
+		return "", err
+	}
+
+	flags := map[plumbing.Hash]uint{start.ID(): describeSeen}
+	queue := []commitgraph.CommitNode{start}
+
+	var candidates []*describeCandidate
+	var annotated, seen int
+	var gaveUp commitgraph.CommitNode
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		seen++
+
+		if t, ok := tags[c.ID()]; ok && (opts.Tags || t.annotated) {
+			if len(candidates) == describeCandidates {
+				gaveUp = c
+				break
+			}
+
+			candidate := &describeCandidate{
+				tag:   t,
+				depth: seen - 1,
+				flag:  1 << (len(candidates) + 1),
+				order: len(candidates),
+			}
+
+			candidates = append(candidates, candidate)
+			flags[c.ID()] |= candidate.flag
+			if t.annotated {
+				annotated++
+			}
+		}
+
+		for _, candidate := range candidates {
+			if flags[c.ID()]&candidate.flag == 0 {
+				candidate.depth++
+			}
+		}
+
+		// The remaining path is already covered by the candidates.
+		if annotated > 0 && len(queue) == 0 {
+			break
+		}
+
+		if queue, err = queueParents(queue, c, flags); err != nil {
+			return "", err
+		}
+	}
+
+	if len(candidates) == 0 {
+		if opts.Always {
+			return r.abbreviateHash(opts.Hash, opts.Abbrev), nil
+		}
+
+		return "", ErrNoTagFound
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].depth != candidates[j].depth {
+			return candidates[i].depth < candidates[j].depth
+		}
+
+		return candidates[i].order < candidates[j].order
+	})
+
+	if gaveUp != nil {
+		queue = insertByDate(queue, gaveUp)
+	}
+
+	best := candidates[0]
+	if err := finishDescribeDepth(queue, best, flags); err != nil {
+		return "", err
+	}
+
+	if opts.Abbrev < 0 {
+		return best.tag.name, nil
+	}
+
+	return fmt.Sprintf("%s-%d-g%s", best.tag.name, best.depth, r.abbreviateHash(opts.Hash, opts.Abbrev)), nil
+}
+
+// finishDescribeDepth walks the rest of the history, counting the commits
+// not reachable from the best candidate, until all the queued commits are.
+func finishDescribeDepth(queue []commitgraph.CommitNode, best *describeCandidate, flags map[plumbing.Hash]uint) error {
+	var err error
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+
+		if flags[c.ID()]&best.flag != 0 {
+			covered := true
+			for _, q := range queue {
+				if flags[q.ID()]&best.flag == 0 {
+					covered = false
+					break
+				}
+			}
+
+			if covered {
+				return nil
+			}
+		} else {
+			best.depth++
+		}
+
+		if queue, err = queueParents(queue, c, flags); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queueParents queues the parents of c not seen yet and propagates the flags
+// of c to them.
+func queueParents(queue []commitgraph.CommitNode, c commitgraph.CommitNode, flags map[plumbing.Hash]uint) ([]commitgraph.CommitNode, error) {
+	err := c.ParentNodes().ForEach(func(p commitgraph.CommitNode) error {
+		if flags[p.ID()]&describeSeen == 0 {
+			queue = insertByDate(queue, p)
+		}
+
+		flags[p.ID()] |= flags[c.ID()]
+		return nil
+	})
+
+	return queue, err
+}
+
+// insertByDate inserts c in the queue sorted by descending commit date,
+// after the commits with the same date.
+func insertByDate(queue []commitgraph.CommitNode, c commitgraph.CommitNode) []commitgraph.CommitNode {
+	i := sort.Search(len(queue), func(i int) bool {
+		return queue[i].CommitTime().Before(c.CommitTime())
+	})
+
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = c
+	return queue
+}
+
+// revName is the name of a commit relative to a tag containing it, as
+// computed by git name-rev.
+type revName struct {
+	tip        string
+	date       time.Time
+	generation int
+	distance   int
+}
+
+func (n *revName) String() string {
+	if n.generation == 0 {
+		return n.tip
+	}
+
+	return fmt.Sprintf("%s~%d", strings.TrimSuffix(n.tip, "^0"), n.generation)
+}
+
+// parentName returns the name of the i-th parent of the commit named n, with
+// i starting at 1.
+func (n *revName) parentName(i int) *revName {
+	if i == 1 {
+		return &revName{n.tip, n.date, n.generation + 1, n.distance + 1}
+	}
+
+	tip := strings.TrimSuffix(n.tip, "^0")
+	if n.generation > 0 {
+		tip = fmt.Sprintf("%s~%d^%d", tip, n.generation, i)
+	} else {
+		tip = fmt.Sprintf("%s^%d", tip, i)
+	}
+
+	return &revName{tip, n.date, 0, n.distance + mergeTraversalWeight}
+}
+
+// betterThan returns true if n is a better name than o: based on an older
+// tag, or on the same tag at a shorter distance.
+func (n *revName) betterThan(o *revName) bool {
+	return o.date.After(n.date) || o.date.Equal(n.date) && o.distance > n.distance
+}
+
+// describeContains names the commit after the oldest tag containing it, as
+// git describe --contains does with git name-rev.
+func (r *Repository) describeContains(idx commitgraph.CommitNodeIndex, tags map[plumbing.Hash]*describeTag, opts *DescribeOptions) (string, error) {
+	hashes := make([]plumbing.Hash, 0, len(tags))
+	for h := range tags {
+		hashes = append(hashes, h)
+	}
+
+	sort.Slice(hashes, func(i, j int) bool { return tags[hashes[i]].name < tags[hashes[j]].name })
+
+	names := make(map[plumbing.Hash]*revName)
+	for _, h := range hashes {
+		t := tags[h]
+		tip := t.name
+		if t.annotated {
+			tip += "^0"
+		}
+
+		type pending struct {
+			hash plumbing.Hash
+			name *revName
+		}
+
+		stack := []pending{{h, &revName{tip: tip, date: t.date}}}
+		for len(stack) > 0 {
+			p := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			if e, ok := names[p.hash]; ok && !p.name.betterThan(e) {
+				continue
+			}
+
+			names[p.hash] = p.name
+
+			c, err := idx.Get(p.hash)
+			if err != nil {
+				return "", err
+			}
+
+			parents := c.ParentHashes()
+			for i := len(parents) - 1; i >= 0; i-- {
+				stack = append(stack, pending{parents[i], p.name.parentName(i + 1)})
+			}
+		}
+	}
+
+	if n, ok := names[opts.Hash]; ok {
+		return n.String(), nil
+	}
+
+	if opts.Always {
+		return r.abbreviateHash(opts.Hash, opts.Abbrev), nil
+	}
+
+	return "", ErrNoTagFound
+}
+
+// abbreviateHash returns the shortest prefix of h, at least n digits long,
+// which is not ambiguous.
+func (r *Repository) abbreviateHash(h plumbing.Hash, n int) string {
+	s := h.String()
+	if n < 4 {
+		n = 4
+	}
+
+	for ; n < len(s); n++ {
+		if len(r.resolveHashPrefix(s[:n])) <= 1 {
+			break
+		}
+	}
+
+	return s[:n]
+}
+
+// commitNodeIndex returns an index of the commits, backed by the
+// commit-graph of the repository when it has one. The returned function
+// releases the index.
+func (r *Repository) commitNodeIndex() (commitgraph.CommitNodeIndex, func()) {
+	if s, ok := r.Storer.(interface{ Filesystem() billy.Filesystem }); ok {
+		if graph, err := commitgraphfmt.OpenChainOrFileIndex(s.Filesystem()); err == nil {
+			return commitgraph.NewGraphCommitNodeIndex(graph, r.Storer), func() { _ = graph.Close() }
+		}
+	}
+
+	return commitgraph.NewObjectCommitNodeIndex(r.Storer), func() {}
+}
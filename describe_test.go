@@ -0,0 +1,180 @@
+package git
+
+import (
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type DescribeSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&DescribeSuite{})
+
+var describeSignature = &object.Signature{
+	Name:  "T",
+	Email: "t@t",
+	When:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// setupDescribe creates the history c1 (v1) - c2 (light) - c3 - merge - c5
+// (v2), where merge also merges s1, a child of c1. v1 and v2 are annotated
+// tags, light is a lightweight one.
+func (s *DescribeSuite) setupDescribe(c *C) (*Repository, map[string]plumbing.Hash) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	commit := func(msg string, parents ...plumbing.Hash) plumbing.Hash {
+		w, err := r.Worktree()
+		c.Assert(err, IsNil)
+
+		h, err := w.Commit(msg, &CommitOptions{
+			Author:            describeSignature,
+			Parents:           parents,
+			AllowEmptyCommits: true,
+		})
+		c.Assert(err, IsNil)
+		return h
+	}
+
+	tag := func(name string, h plumbing.Hash, annotated bool) {
+		var opts *CreateTagOptions
+		if annotated {
+			opts = &CreateTagOptions{Tagger: describeSignature, Message: name}
+		}
+
+		_, err := r.CreateTag(name, h, opts)
+		c.Assert(err, IsNil)
+	}
+
+	commits := map[string]plumbing.Hash{}
+	commits["c1"] = commit("c1")
+	tag("v1", commits["c1"], true)
+	commits["c2"] = commit("c2")
+	tag("light", commits["c2"], false)
+	commits["c3"] = commit("c3")
+	commits["s1"] = commit("s1", commits["c1"])
+	commits["merge"] = commit("merge", commits["c3"], commits["s1"])
+	commits["c5"] = commit("c5", commits["merge"])
+	tag("v2", commits["c5"], true)
+
+	return r, commits
+}
+
+func (s *DescribeSuite) TestDescribe(c *C) {
+	r, commits := s.setupDescribe(c)
+	abbrev := func(name string, n int) string { return commits[name].String()[:n] }
+
+	// The expected descriptions are the ones of git describe.
+	for _, t := range []struct {
+		opts     DescribeOptions
+		expected string
+	}{
+		{DescribeOptions{}, "v2"},
+		{DescribeOptions{Hash: commits["merge"]}, "v1-4-g" + abbrev("merge", 7)},
+		{DescribeOptions{Hash: commits["merge"], Tags: true}, "light-3-g" + abbrev("merge", 7)},
+		{DescribeOptions{Hash: commits["merge"], Abbrev: 10}, "v1-4-g" + abbrev("merge", 10)},
+		{DescribeOptions{Hash: commits["merge"], Abbrev: -1}, "v1"},
+		{DescribeOptions{Hash: commits["c5"], Long: true}, "v2-0-g" + abbrev("c5", 7)},
+		{DescribeOptions{Exclude: []string{"v2"}}, "v1-5-g" + abbrev("c5", 7)},
+		{DescribeOptions{Match: []string{"x*"}, Always: true}, abbrev("c5", 7)},
+		{DescribeOptions{Hash: commits["c3"], Contains: true}, "v2~2"},
+		{DescribeOptions{Hash: commits["c2"], Contains: true}, "light"},
+		{DescribeOptions{Hash: commits["c2"], Contains: true, Exclude: []string{"light"}}, "v2~3"},
+		{DescribeOptions{Hash: commits["c1"], Contains: true}, "v1^0"},
+		{DescribeOptions{Hash: commits["s1"], Contains: true}, "v2~1^2"},
+		{DescribeOptions{Hash: commits["c5"], Contains: true}, "v2^0"},
+	} {
+		opts := t.opts
+		desc, err := r.Describe(&opts)
+		c.Assert(err, IsNil)
+		c.Assert(desc, Equals, t.expected, Commentf("with options %+v", t.opts))
+	}
+
+	_, err := r.Describe(&DescribeOptions{Match: []string{"x*"}})
+	c.Assert(err, Equals, ErrNoTagFound)
+
+	_, err = r.Describe(&DescribeOptions{Hash: commits["s1"], Contains: true, Match: []string{"v1"}})
+	c.Assert(err, Equals, ErrNoTagFound)
+}
+
+func (s *DescribeSuite) TestDescribeDirty(c *C) {
+	r, commits := s.setupDescribe(c)
+
+	desc, err := r.Describe(&DescribeOptions{Dirty: true})
+	c.Assert(err, IsNil)
+	c.Assert(desc, Equals, "v2")
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	// Untracked files do not make the worktree dirty.
+	err = util.WriteFile(w.Filesystem, "f", []byte("x\n"), 0644)
+	c.Assert(err, IsNil)
+
+	desc, err = r.Describe(&DescribeOptions{Dirty: true})
+	c.Assert(err, IsNil)
+	c.Assert(desc, Equals, "v2")
+
+	_, err = w.Add("f")
+	c.Assert(err, IsNil)
+
+	desc, err = r.Describe(&DescribeOptions{Dirty: true})
+	c.Assert(err, IsNil)
+	c.Assert(desc, Equals, "v2-dirty")
+
+	desc, err = r.Describe(&DescribeOptions{Dirty: true, DirtyMark: "-mod"})
+	c.Assert(err, IsNil)
+	c.Assert(desc, Equals, "v2-mod")
+
+	_, err = r.Describe(&DescribeOptions{Dirty: true, Hash: commits["c1"]})
+	c.Assert(err, NotNil)
+}
+
+func (s *DescribeSuite) TestDescribeCommitGraph(c *C) {
+	f := fixtures.ByTag("commit-graph").One()
+	st := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+	p := f.Packfile()
+	defer p.Close()
+	c.Assert(packfile.UpdateObjectStorage(st, p), IsNil)
+
+	r, err := Open(st, nil)
+	c.Assert(err, IsNil)
+
+	for name, h := range map[string]string{
+		"t1": "347c91919944a68e9413581a1bc15519550a3afe",
+		"t2": "e713b52d7e13807e87a002e812041f248db3f643",
+		"t4": "03d2c021ff68954cf3ef0a36825e194a4b98f981",
+		"t6": "c0edf780dd0da6a65a7a49a86032fcf8a0c2d467",
+	} {
+		_, err := r.CreateTag(name, plumbing.NewHash(h), nil)
+		c.Assert(err, IsNil)
+	}
+
+	// The expected descriptions are the ones of git describe.
+	for _, t := range []struct {
+		opts     DescribeOptions
+		expected string
+	}{
+		{DescribeOptions{Hash: plumbing.NewHash("b9d69064b190e7aedccf84731ca1d917871f8a1c"), Tags: true}, "t6-7-gb9d6906"},
+		{DescribeOptions{Hash: plumbing.NewHash("6f6c5d2be7852c782be1dd13e36496dd7ad39560"), Tags: true}, "t6-6-g6f6c5d2"},
+		{DescribeOptions{Hash: plumbing.NewHash("b9d69064b190e7aedccf84731ca1d917871f8a1c"), Tags: true, Match: []string{"t1"}}, "t1-8-gb9d6906"},
+		{DescribeOptions{Hash: plumbing.NewHash("347c91919944a68e9413581a1bc15519550a3afe"), Tags: true, Contains: true}, "t1"},
+	} {
+		opts := t.opts
+		desc, err := r.Describe(&opts)
+		c.Assert(err, IsNil)
+		c.Assert(desc, Equals, t.expected, Commentf("with options %+v", t.opts))
+	}
+}
@@ -0,0 +1,246 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+)
+
+// mboxDate is the date written in the separator line of the emails, the
+// same used by git, as mbox readers expect one.
+const mboxDate = "Mon Sep 17 00:00:00 2001"
+
+// FormatPatch writes the commits of the range as patch emails in mbox format,
+// like git format-patch --stdout, to be applied with Worktree.Am. Each email
+// holds the author, the date and the message of a commit, followed by the
+// diffstat and the diff against its parent. Merge commits are skipped.
+func (r *Repository) FormatPatch(w io.Writer, opts *FormatPatchOptions) error {
+	if err := opts.Validate(r); err != nil {
+		return err
+	}
+
+	commits, err := r.formatPatchCommits(opts.From, opts.To)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range commits {
+		prefix := opts.SubjectPrefix
+		if len(commits) > 1 {
+			prefix = fmt.Sprintf("%s %d/%d", prefix, i+1, len(commits))
+		}
+
+		// Like git, the emails are separated by an empty line.
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+
+		if err := formatPatch(w, c, prefix, opts.Signature); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// formatPatchCommits returns in order the commits reachable from to and not
+// from from, except merges.
+func (r *Repository) formatPatchCommits(from, to plumbing.Hash) ([]*object.Commit, error) {
+	tip, err := r.CommitObject(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var ignore []plumbing.Hash
+	if !from.IsZero() {
+		base, err := r.CommitObject(from)
+		if err != nil {
+			return nil, err
+		}
+
+		err = object.NewCommitPreorderIter(base, nil, nil).ForEach(func(c *object.Commit) error {
+			ignore = append(ignore, c.Hash)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var commits []*object.Commit
+	err = object.NewCommitPostorderIter(tip, ignore).ForEach(func(c *object.Commit) error {
+		if c.NumParents() <= 1 {
+			commits = append(commits, c)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+
+	return commits, nil
+}
+
+// formatPatch writes the email of the commit c.
+func formatPatch(w io.Writer, c *object.Commit, prefix, signature string) error {
+	patch, err := commitPatch(c)
+	if err != nil {
+		return err
+	}
+
+	subject, body := splitMessage(c.Message)
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "From %s %s\n", c.Hash, mboxDate)
+	fmt.Fprintf(buf, "From: %s\n", formatAddress(c.Author.Name, c.Author.Email))
+	fmt.Fprintf(buf, "Date: %s\n", c.Author.When.Format(emailDateFormat))
+	fmt.Fprintf(buf, "Subject: [%s] %s\n", prefix, encodeHeader(subject))
+	if !isASCII(body) {
+		buf.WriteString("MIME-Version: 1.0\n")
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\n")
+		buf.WriteString("Content-Transfer-Encoding: 8bit\n")
+	}
+
+	buf.WriteString("\n")
+	if body != "" {
+		buf.WriteString(body)
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString("---\n")
+	writeDiffstat(buf, patch)
+	buf.WriteString("\n")
+	if err := patch.Encode(buf); err != nil {
+		return err
+	}
+
+	if signature != "" {
+		fmt.Fprintf(buf, "-- \n%s\n\n", signature)
+	}
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// emailDateFormat is the format of the Date header, as in RFC 2822.
+const emailDateFormat = "Mon, 2 Jan 2006 15:04:05 -0700"
+
+// commitPatch returns the patch between the first parent of c, or the empty
+// tree for root commits, and c.
+func commitPatch(c *object.Commit) (*object.Patch, error) {
+	to, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	from := &object.Tree{}
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+
+		if from, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	return from.Patch(to)
+}
+
+// writeDiffstat writes the diffstat of the patch, followed like in git by
+// the summary of the changes and the created and deleted files.
+func writeDiffstat(buf *bytes.Buffer, patch *object.Patch) {
+	stats := patch.Stats()
+	buf.WriteString(stats.String())
+
+	var additions, deletions int
+	for _, s := range stats {
+		additions += s.Addition
+		deletions += s.Deletion
+	}
+
+	summary := []string{plural(len(stats), "file changed", "files changed")}
+	if additions > 0 || deletions == 0 {
+		summary = append(summary, plural(additions, "insertion(+)", "insertions(+)"))
+	}
+
+	if deletions > 0 || additions == 0 {
+		summary = append(summary, plural(deletions, "deletion(-)", "deletions(-)"))
+	}
+
+	fmt.Fprintf(buf, " %s\n", strings.Join(summary, ", "))
+
+	for _, fp := range patch.FilePatches() {
+		switch from, to := fp.Files(); {
+		case from == nil && to != nil:
+			fmt.Fprintf(buf, " create mode %o %s\n", to.Mode(), to.Path())
+		case to == nil && from != nil:
+			fmt.Fprintf(buf, " delete mode %o %s\n", from.Mode(), from.Path())
+		}
+	}
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, one)
+	}
+
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// splitMessage splits a commit message into its subject, the first paragraph
+// joined in a single line, and its body.
+func splitMessage(msg string) (subject, body string) {
+	msg = strings.TrimSpace(msg)
+	subject, body, _ = strings.Cut(msg, "\n\n")
+	subject = strings.Join(strings.Fields(subject), " ")
+	return subject, strings.TrimSpace(body)
+}
+
+// formatAddress formats a name and an email as an address header value,
+// encoding the name as in RFC 2047 when needed.
+func formatAddress(name, email string) string {
+	switch {
+	case name == "":
+		return email
+	case !isASCII(name):
+		name = mime.QEncoding.Encode("UTF-8", name)
+	case strings.ContainsAny(name, `()<>[]:;@\,."`):
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+
+	return fmt.Sprintf("%s <%s>", name, email)
+}
+
+// encodeHeader encodes the value of a header as in RFC 2047 if it is not
+// ASCII.
+func encodeHeader(s string) string {
+	if isASCII(s) {
+		return s
+	}
+
+	return mime.QEncoding.Encode("UTF-8", s)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
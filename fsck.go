@@ -0,0 +1,585 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/idxfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/objfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/fsck"
+	"github.com/jesseduffield/go-git/v5/plumbing/hash"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// FsckObject is an object reported by Fsck.
+type FsckObject struct {
+	Hash plumbing.Hash
+	// Type is the type of the object. For a missing object it is the type
+	// expected by the objects referencing it, plumbing.AnyObject when it
+	// is only referenced by the references, the reflogs or the index.
+	Type plumbing.ObjectType
+}
+
+// FsckResult is the result of a repository check.
+type FsckResult struct {
+	// Problems are the problems found in the packs, the index and the
+	// objects.
+	Problems []*fsck.Problem
+	// Missing are the objects referenced by the references, the reflogs,
+	// the index or the reachable objects which are not in the repository.
+	Missing []FsckObject
+	// Dangling are the objects which are not reachable, and not referenced
+	// by any other object.
+	Dangling []FsckObject
+}
+
+// HasErrors returns whether a problem with the fsck.Error severity, or a
+// missing object, was found.
+func (r *FsckResult) HasErrors() bool {
+	if len(r.Missing) > 0 {
+		return true
+	}
+
+	for _, p := range r.Problems {
+		if p.Severity == fsck.Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fsck checks the integrity of the repository, like git fsck:
+//
+//   - The checksums of the packs, of their indexes and of the index are
+//     verified.
+//   - Each object must hash to its name, and the syntax of the trees,
+//     commits and tags is checked, as well as the .gitmodules files, see the
+//     fsck package.
+//   - The objects reachable from the references, the reflogs and the index
+//     must be in the repository. The objects which are not reachable, and
+//     not referenced by another object, are dangling.
+//
+// The problems are reported in the result, an error is only returned when
+// the repository can not be read.
+func (r *Repository) Fsck(opts *FsckOptions) (*FsckResult, error) {
+	if opts == nil {
+		opts = &FsckOptions{}
+	}
+
+	c := &fsckCheck{
+		r: r,
+		opts: &fsck.Options{
+			Strict:     opts.Strict,
+			Severities: opts.Severities,
+		},
+		objects: make(map[plumbing.Hash]plumbing.ObjectType),
+		links:   make(map[plumbing.Hash][]FsckObject),
+		shallow: make(map[plumbing.Hash]bool),
+		result:  &FsckResult{},
+	}
+
+	c.checker = fsck.NewObjectChecker(c.opts)
+
+	shallow, err := r.Storer.Shallow()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range shallow {
+		c.shallow[h] = true
+	}
+
+	if s, ok := r.Storer.(gcStorer); ok {
+		err = c.checkStoredObjects(s)
+	} else {
+		err = c.checkObjects()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	problems, err := c.checker.Finish(r.Storer)
+	if err != nil {
+		return nil, err
+	}
+
+	c.result.Problems = append(c.result.Problems, problems...)
+
+	roots, err := c.roots()
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := c.walk(roots)
+	if !opts.NoDangling {
+		c.findDangling(reachable)
+	}
+
+	return c.result, nil
+}
+
+// fsckCheck is a running repository check.
+type fsckCheck struct {
+	r       *Repository
+	opts    *fsck.Options
+	checker *fsck.ObjectChecker
+	// objects are the valid objects of the repository, and links the
+	// objects they reference.
+	objects map[plumbing.Hash]plumbing.ObjectType
+	links   map[plumbing.Hash][]FsckObject
+	shallow map[plumbing.Hash]bool
+	result  *FsckResult
+}
+
+func (c *fsckCheck) report(id fsck.ID, h plumbing.Hash, t plumbing.ObjectType, format string, args ...interface{}) {
+	s := c.opts.Severity(id)
+	if s == fsck.Ignore {
+		return
+	}
+
+	c.result.Problems = append(c.result.Problems, &fsck.Problem{
+		ID:       id,
+		Severity: s,
+		Hash:     h,
+		Type:     t,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkStoredObjects checks the packs, the loose objects and the index of a
+// storer with a filesystem, reading the files directly to check that the
+// objects hash to their names.
+func (c *fsckCheck) checkStoredObjects(s gcStorer) error {
+	fs := s.Filesystem()
+
+	var loose []plumbing.Hash
+	err := s.ForEachObjectHash(func(h plumbing.Hash) error {
+		loose = append(loose, h)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Sort(plumbing.HashSlice(loose))
+	for _, h := range loose {
+		if err := c.checkLooseObject(fs, h); err != nil {
+			return err
+		}
+	}
+
+	packs, err := s.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	for _, h := range packs {
+		if err := c.checkPack(fs, h); err != nil {
+			return err
+		}
+	}
+
+	return c.checkIndex()
+}
+
+// checkObjects checks the objects of a storer without filesystem, which are
+// expected to hash to the hash they are stored with.
+func (c *fsckCheck) checkObjects() error {
+	iter, err := c.r.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return err
+	}
+
+	var objects []plumbing.EncodedObject
+	err = iter.ForEach(func(o plumbing.EncodedObject) error {
+		objects = append(objects, o)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Hash().String() < objects[j].Hash().String()
+	})
+
+	for _, o := range objects {
+		if err := c.checkEncodedObject(o.Hash(), o); err != nil {
+			return err
+		}
+	}
+
+	return c.checkIndex()
+}
+
+func (c *fsckCheck) checkIndex() error {
+	_, err := c.r.Storer.Index()
+	if errors.Is(err, index.ErrInvalidChecksum) {
+		c.report(fsck.BadIndexChecksum, plumbing.ZeroHash, plumbing.InvalidObject, "index checksum mismatch")
+		return nil
+	}
+
+	return err
+}
+
+func (c *fsckCheck) checkLooseObject(fs billy.Filesystem, h plumbing.Hash) (err error) {
+	hex := h.String()
+	f, err := fs.Open(path.Join("objects", hex[:2], hex[2:]))
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	r, err := objfile.NewReader(f)
+	if err != nil {
+		c.report(fsck.CorruptObject, h, plumbing.InvalidObject, "unable to read loose object: %s", err)
+		return nil
+	}
+
+	defer r.Close()
+
+	t, size, err := r.Header()
+	if err != nil {
+		c.report(fsck.CorruptObject, h, plumbing.InvalidObject, "unable to read loose object: %s", err)
+		return nil
+	}
+
+	return c.checkObject(h, t, size, r)
+}
+
+// checkPack checks the checksums of a pack and of its index, and the objects
+// of the pack when its index can be trusted.
+func (c *fsckCheck) checkPack(fs billy.Filesystem, h plumbing.Hash) (err error) {
+	packSum, ok, err := verifyFileChecksum(fs, packPath(h, "pack"))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		c.report(fsck.BadPackChecksum, h, plumbing.InvalidObject, "pack checksum mismatch")
+	}
+
+	_, ok, err = verifyFileChecksum(fs, packPath(h, "idx"))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		c.report(fsck.BadPackIndexChecksum, h, plumbing.InvalidObject, "pack index checksum mismatch")
+		return nil
+	}
+
+	idx, err := readPackIndex(fs, h)
+	if err != nil {
+		c.report(fsck.BadPackIndexChecksum, h, plumbing.InvalidObject, "unable to read pack index: %s", err)
+		return nil
+	}
+
+	if !bytes.Equal(idx.PackfileChecksum[:], packSum[:]) {
+		c.report(fsck.BadPackIndexChecksum, h, plumbing.InvalidObject, "pack index doesn't match its pack")
+		return nil
+	}
+
+	f, err := fs.Open(packPath(h, "pack"))
+	if err != nil {
+		return err
+	}
+
+	p := packfile.NewPackfile(idx, fs, f, 0)
+	defer ioutil.CheckClose(p, &err)
+
+	return forEachIndexEntry(idx, func(e *idxfile.Entry) error {
+		o, err := p.GetByOffset(int64(e.Offset))
+		if err != nil {
+			c.report(fsck.CorruptObject, e.Hash, plumbing.InvalidObject, "unable to read packed object: %s", err)
+			return nil
+		}
+
+		return c.checkEncodedObject(e.Hash, o)
+	})
+}
+
+// verifyFileChecksum returns the trailing checksum of a file, and whether it
+// is the checksum of the rest of the file.
+func verifyFileChecksum(fs billy.Filesystem, name string) (sum plumbing.Hash, ok bool, err error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return sum, false, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	fi, err := fs.Stat(name)
+	if err != nil {
+		return sum, false, err
+	}
+
+	if fi.Size() < hash.Size {
+		return sum, false, nil
+	}
+
+	h := hash.New(hash.CryptoType)
+	if _, err := io.CopyN(h, f, fi.Size()-hash.Size); err != nil {
+		return sum, false, err
+	}
+
+	if _, err := io.ReadFull(f, sum[:]); err != nil {
+		return sum, false, err
+	}
+
+	return sum, bytes.Equal(h.Sum(nil), sum[:]), nil
+}
+
+func (c *fsckCheck) checkEncodedObject(h plumbing.Hash, o plumbing.EncodedObject) (err error) {
+	r, err := o.Reader()
+	if err != nil {
+		c.report(fsck.CorruptObject, h, o.Type(), "unable to read object: %s", err)
+		return nil
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	return c.checkObject(h, o.Type(), o.Size(), r)
+}
+
+// checkObject checks that the content of an object hashes to its name, and
+// its syntax. The blobs are only hashed, their content is not kept.
+func (c *fsckCheck) checkObject(h plumbing.Hash, t plumbing.ObjectType, size int64, r io.Reader) error {
+	hasher := plumbing.NewHasher(t, size)
+	var data []byte
+	var err error
+	if t == plumbing.BlobObject {
+		_, err = io.Copy(hasher, r)
+	} else {
+		data, err = io.ReadAll(io.TeeReader(r, hasher))
+	}
+
+	if err != nil {
+		c.report(fsck.CorruptObject, h, t, "unable to read object: %s", err)
+		return nil
+	}
+
+	if got := hasher.Sum(); got != h {
+		c.report(fsck.HashMismatch, h, t, "object hashes to %s", got)
+		return nil
+	}
+
+	if _, ok := c.objects[h]; ok {
+		return nil
+	}
+
+	c.objects[h] = t
+	c.result.Problems = append(c.result.Problems, c.checker.Check(h, t, data)...)
+	if t != plumbing.BlobObject {
+		c.links[h] = c.objectLinks(h, t, data)
+	}
+
+	return nil
+}
+
+// objectLinks returns the objects referenced by an object. The parents of
+// the shallow commits are not in the repository, they are ignored.
+func (c *fsckCheck) objectLinks(h plumbing.Hash, t plumbing.ObjectType, data []byte) []FsckObject {
+	o := &plumbing.MemoryObject{}
+	o.SetType(t)
+	if _, err := o.Write(data); err != nil {
+		return nil
+	}
+
+	var links []FsckObject
+	switch t {
+	case plumbing.CommitObject:
+		commit := &object.Commit{}
+		if commit.Decode(o) != nil {
+			return nil
+		}
+
+		links = append(links, FsckObject{commit.TreeHash, plumbing.TreeObject})
+		if !c.shallow[h] {
+			for _, p := range commit.ParentHashes {
+				links = append(links, FsckObject{p, plumbing.CommitObject})
+			}
+		}
+	case plumbing.TreeObject:
+		tree := &object.Tree{}
+		if tree.Decode(o) != nil {
+			return nil
+		}
+
+		for _, e := range tree.Entries {
+			switch e.Mode {
+			case filemode.Submodule:
+			case filemode.Dir:
+				links = append(links, FsckObject{e.Hash, plumbing.TreeObject})
+			default:
+				links = append(links, FsckObject{e.Hash, plumbing.BlobObject})
+			}
+		}
+	case plumbing.TagObject:
+		tag := &object.Tag{}
+		if tag.Decode(o) != nil {
+			return nil
+		}
+
+		links = append(links, FsckObject{tag.Target, tag.TargetType})
+	}
+
+	return links
+}
+
+// roots returns the objects referenced by the references, the reflogs and
+// the index.
+func (c *fsckCheck) roots() ([]FsckObject, error) {
+	var roots []FsckObject
+	iter, err := c.r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			roots = append(roots, FsckObject{ref.Hash(), plumbing.AnyObject})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rs, ok := c.r.Storer.(storer.ReflogStorer); ok {
+		names, err := c.r.referenceNames()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			entries, err := rs.Reflog(name)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range entries {
+				for _, h := range []plumbing.Hash{e.Old, e.New} {
+					if !h.IsZero() {
+						roots = append(roots, FsckObject{h, plumbing.AnyObject})
+					}
+				}
+			}
+		}
+	}
+
+	idx, err := c.r.Storer.Index()
+	if errors.Is(err, index.ErrInvalidChecksum) {
+		return roots, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Mode != filemode.Submodule {
+			roots = append(roots, FsckObject{e.Hash, plumbing.BlobObject})
+		}
+	}
+
+	return roots, nil
+}
+
+// walk walks the objects reachable from the roots, reporting the missing
+// ones, and returns the reachable objects.
+func (c *fsckCheck) walk(roots []FsckObject) map[plumbing.Hash]bool {
+	reachable := make(map[plumbing.Hash]bool)
+	missing := make(map[plumbing.Hash]bool)
+	pending := roots
+	for len(pending) > 0 {
+		o := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[o.Hash] || missing[o.Hash] {
+			continue
+		}
+
+		links, ok := c.links[o.Hash], true
+		if _, valid := c.objects[o.Hash]; !valid {
+			links, ok = c.storedLinks(o.Hash)
+		}
+
+		if !ok {
+			missing[o.Hash] = true
+			c.result.Missing = append(c.result.Missing, o)
+			continue
+		}
+
+		reachable[o.Hash] = true
+		pending = append(pending, links...)
+	}
+
+	sortFsckObjects(c.result.Missing)
+	return reachable
+}
+
+// storedLinks returns the objects referenced by an object which was not
+// checked, such as the objects of an alternate, and whether it exists. The
+// corrupted objects, already reported, are not missing.
+func (c *fsckCheck) storedLinks(h plumbing.Hash) ([]FsckObject, bool) {
+	o, err := c.r.Storer.EncodedObject(plumbing.AnyObject, h)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, false
+	}
+
+	if err != nil || o.Type() == plumbing.BlobObject {
+		return nil, true
+	}
+
+	r, err := o.Reader()
+	if err != nil {
+		return nil, true
+	}
+
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, true
+	}
+
+	return c.objectLinks(h, o.Type(), data), true
+}
+
+// findDangling finds the objects which are not reachable, and not referenced
+// by another object.
+func (c *fsckCheck) findDangling(reachable map[plumbing.Hash]bool) {
+	referenced := make(map[plumbing.Hash]bool)
+	for _, links := range c.links {
+		for _, l := range links {
+			referenced[l.Hash] = true
+		}
+	}
+
+	for h, t := range c.objects {
+		if !reachable[h] && !referenced[h] {
+			c.result.Dangling = append(c.result.Dangling, FsckObject{h, t})
+		}
+	}
+
+	sortFsckObjects(c.result.Dangling)
+}
+
+func sortFsckObjects(objects []FsckObject) {
+	sort.Slice(objects, func(i, j int) bool {
+		return bytes.Compare(objects[i].Hash[:], objects[j].Hash[:]) < 0
+	})
+}
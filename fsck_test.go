@@ -0,0 +1,223 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/fsck"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type FsckSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&FsckSuite{})
+
+func (s *FsckSuite) storeObject(c *C, r *Repository, t plumbing.ObjectType, content string) plumbing.Hash {
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(t)
+	w, err := obj.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte(content))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	h, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+	return h
+}
+
+func problemIDs(result *FsckResult) []fsck.ID {
+	var ids []fsck.ID
+	for _, p := range result.Problems {
+		ids = append(ids, p.ID)
+	}
+
+	return ids
+}
+
+func problemsByID(result *FsckResult) map[fsck.ID]*fsck.Problem {
+	problems := make(map[fsck.ID]*fsck.Problem)
+	for _, p := range result.Problems {
+		problems[p.ID] = p
+	}
+
+	return problems
+}
+
+func (s *FsckSuite) TestFsck(c *C) {
+	r := s.NewRepository(fixtures.Basic().One())
+
+	result, err := r.Fsck(nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Problems, HasLen, 0)
+	c.Assert(result.Missing, HasLen, 0)
+	c.Assert(result.Dangling, HasLen, 0)
+	c.Assert(result.HasErrors(), Equals, false)
+}
+
+func (s *FsckSuite) TestFsckMemory(c *C) {
+	r := s.NewRepositoryFromPackfile(fixtures.Basic().One())
+
+	result, err := r.Fsck(nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.HasErrors(), Equals, false)
+}
+
+func (s *FsckSuite) TestFsckMissingAndDangling(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]*string{"a": str("a\n")})
+	commitFiles(c, w, map[string]*string{"b": str("b\n")})
+	dangling := s.storeObject(c, r, plumbing.BlobObject, "dangling\n")
+
+	// The tree of a dangling commit is not dangling.
+	tree := s.storeObject(c, r, plumbing.TreeObject, "")
+	commit := s.storeObject(c, r, plumbing.CommitObject, "tree "+tree.String()+"\n"+
+		"author John Doe <john@example.com> 1700000000 +0100\n"+
+		"committer John Doe <john@example.com> 1700000000 +0100\n\ncommit\n")
+
+	blob := plumbing.ComputeHash(plumbing.BlobObject, []byte("a\n"))
+	st := r.Storer.(*memory.Storage)
+	delete(st.Objects, blob)
+	delete(st.Blobs, blob)
+
+	result, err := r.Fsck(nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Problems, HasLen, 0)
+	c.Assert(result.Missing, DeepEquals, []FsckObject{{blob, plumbing.BlobObject}})
+	c.Assert(result.HasErrors(), Equals, true)
+
+	expected := []FsckObject{{dangling, plumbing.BlobObject}, {commit, plumbing.CommitObject}}
+	sortFsckObjects(expected)
+	c.Assert(result.Dangling, DeepEquals, expected)
+
+	result, err = r.Fsck(&FsckOptions{NoDangling: true})
+	c.Assert(err, IsNil)
+	c.Assert(result.Dangling, HasLen, 0)
+}
+
+func (s *FsckSuite) TestFsckMissingReference(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	missing := plumbing.NewHash("a8d315b2b1c615d43042c3a62402b8a54288cf5c")
+	err = r.Storer.SetReference(plumbing.NewHashReference("refs/heads/master", missing))
+	c.Assert(err, IsNil)
+
+	result, err := r.Fsck(nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Missing, DeepEquals, []FsckObject{{missing, plumbing.AnyObject}})
+}
+
+func (s *FsckSuite) TestFsckObjectSyntax(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	blob := s.storeObject(c, r, plumbing.BlobObject, "hook\n")
+	tree := s.storeObject(c, r, plumbing.TreeObject, "100644 .git\x00"+string(blob[:])+"100644 b\x00"+string(blob[:]))
+	commit := s.storeObject(c, r, plumbing.CommitObject, "tree "+tree.String()+"\n"+
+		"author John Doe 1700000000 +0100\n"+
+		"committer John Doe <john@example.com> 1700000000 +0100\n\ncommit\n")
+	c.Assert(r.Storer.SetReference(plumbing.NewHashReference("refs/heads/master", commit)), IsNil)
+
+	result, err := r.Fsck(nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Problems, HasLen, 2)
+	c.Assert(result.Missing, HasLen, 0)
+
+	problems := problemsByID(result)
+	c.Assert(problems[fsck.MissingEmail].Hash, Equals, commit)
+	c.Assert(problems[fsck.MissingEmail].Severity, Equals, fsck.Error)
+	c.Assert(problems[fsck.HasDotgit].Hash, Equals, tree)
+	c.Assert(problems[fsck.HasDotgit].Severity, Equals, fsck.Warning)
+
+	result, err = r.Fsck(&FsckOptions{Strict: true})
+	c.Assert(err, IsNil)
+	c.Assert(problemsByID(result)[fsck.HasDotgit].Severity, Equals, fsck.Error)
+
+	result, err = r.Fsck(&FsckOptions{Severities: map[fsck.ID]fsck.Severity{
+		fsck.MissingEmail: fsck.Ignore,
+	}})
+	c.Assert(err, IsNil)
+	c.Assert(problemIDs(result), DeepEquals, []fsck.ID{fsck.HasDotgit})
+}
+
+func (s *FsckSuite) TestFsckHashMismatch(c *C) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	a := s.storeObject(c, r, plumbing.BlobObject, "a\n")
+	b := s.storeObject(c, r, plumbing.BlobObject, "b\n")
+
+	objectPath := func(h plumbing.Hash) string {
+		return filepath.Join(dir, ".git", "objects", h.String()[:2], h.String()[2:])
+	}
+
+	content, err := os.ReadFile(objectPath(b))
+	c.Assert(err, IsNil)
+	c.Assert(os.Chmod(objectPath(a), 0644), IsNil)
+	c.Assert(os.WriteFile(objectPath(a), content, 0644), IsNil)
+
+	result, err := r.Fsck(&FsckOptions{NoDangling: true})
+	c.Assert(err, IsNil)
+	c.Assert(problemIDs(result), DeepEquals, []fsck.ID{fsck.HashMismatch})
+	c.Assert(result.Problems[0].Hash, Equals, a)
+	c.Assert(result.Problems[0].Message, Equals, "object hashes to "+b.String())
+
+	c.Assert(os.WriteFile(objectPath(a), []byte("garbage"), 0644), IsNil)
+
+	result, err = r.Fsck(&FsckOptions{NoDangling: true})
+	c.Assert(err, IsNil)
+	c.Assert(problemIDs(result), DeepEquals, []fsck.ID{fsck.CorruptObject})
+}
+
+func (s *FsckSuite) TestFsckChecksums(c *C) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]*string{"a": str("a\n")})
+	c.Assert(r.RepackObjects(&RepackConfig{}), IsNil)
+
+	packs, err := r.Storer.(gcStorer).ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+
+	corrupt := func(name string, offset int64) {
+		f, err := os.OpenFile(filepath.Join(dir, ".git", name), os.O_RDWR, 0)
+		c.Assert(err, IsNil)
+		b := make([]byte, 1)
+		_, err = f.ReadAt(b, offset)
+		c.Assert(err, IsNil)
+		b[0] ^= 0xff
+		_, err = f.WriteAt(b, offset)
+		c.Assert(err, IsNil)
+		c.Assert(f.Close(), IsNil)
+	}
+
+	corrupt(packPath(packs[0], "pack"), 12)
+	corrupt("index", 12)
+
+	result, err := r.Fsck(&FsckOptions{NoDangling: true})
+	c.Assert(err, IsNil)
+	c.Assert(result.HasErrors(), Equals, true)
+
+	ids := problemIDs(result)
+	c.Assert(len(ids) >= 2, Equals, true)
+	c.Assert(ids[0], Equals, fsck.BadPackChecksum)
+	c.Assert(result.Problems[0].Hash, Equals, packs[0])
+	c.Assert(ids[len(ids)-1], Equals, fsck.BadIndexChecksum)
+}
@@ -0,0 +1,930 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	commitgraphfmt "github.com/jesseduffield/go-git/v5/plumbing/format/commitgraph/v2"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/mtimes"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// ErrInvalidExpireDate is returned when an expiry date of the gc section of
+// the config can not be parsed.
+var ErrInvalidExpireDate = errors.New("invalid expire date")
+
+const (
+	packDir          = "objects/pack"
+	commitGraphFile  = "objects/info/commit-graph"
+	commitGraphsDir  = "objects/info/commit-graphs"
+	defaultGCAuto    = 6700
+	defaultPackLimit = 50
+)
+
+// gcStorer is a storer whose objects can be packed and pruned.
+type gcStorer interface {
+	storer.PackedObjectStorer
+	storer.LooseObjectStorer
+	storer.PackfileWriter
+	Filesystem() billy.Filesystem
+}
+
+// GC collects the garbage of the repository, like git gc:
+//
+//   - The loose references are packed, unless gc.packRefs is false.
+//   - The reflog entries older than gc.reflogExpire, or older than
+//     gc.reflogExpireUnreachable and not reachable from the reference, are
+//     removed.
+//   - The objects reachable from the references, the reflogs and the index
+//     are repacked into a single pack, without the ones missing from a
+//     shallow repository or a partial clone. The packs with a .keep file are
+//     kept as they are.
+//   - The unreachable objects older than gc.pruneExpire are deleted. The
+//     other ones are kept, in a cruft pack with GCOptions.Cruft or
+//     gc.cruftPacks, as loose objects otherwise. The objects loosened from
+//     a pack get the current time, they expire later than in the pack.
+//   - The commit-graph is written, unless gc.writeCommitGraph is false or
+//     the repository is shallow.
+//
+// With GCOptions.Auto nothing is done unless the repository needs it. Only
+// the storers with a filesystem, such as filesystem.Storage, are supported.
+func (r *Repository) GC(opts *GCOptions) error {
+	if opts == nil {
+		opts = &GCOptions{}
+	}
+
+	s, ok := r.Storer.(gcStorer)
+	if !ok {
+		return ErrPackedObjectsNotSupported
+	}
+
+	cfg, err := r.loadGCConfig(opts, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fs := s.Filesystem()
+	if opts.Auto {
+		needed, err := needsGC(s, fs, cfg)
+		if err != nil || !needed {
+			return err
+		}
+	}
+
+	if cfg.packRefs {
+		if err := r.Storer.PackRefs(); err != nil {
+			return err
+		}
+	}
+
+	if err := r.expireReflogs(cfg); err != nil {
+		return err
+	}
+
+	reachable, err := r.reachableObjects()
+	if err != nil {
+		return err
+	}
+
+	if err := r.repack(s, fs, cfg, reachable); err != nil {
+		return err
+	}
+
+	// Like git, no commit-graph is written in a shallow repository.
+	if cfg.writeCommitGraph && len(reachable.shallow) == 0 {
+		return r.writeCommitGraph(fs)
+	}
+
+	return nil
+}
+
+// gcConfig is the configuration of a garbage collection, from the gc
+// section of the config and the options. A zero expiry date never expires.
+type gcConfig struct {
+	auto                    int
+	autoPackLimit           int
+	pruneExpire             time.Time
+	reflogExpire            time.Time
+	reflogExpireUnreachable time.Time
+	cruft                   bool
+	packRefs                bool
+	writeCommitGraph        bool
+	writeBitmaps            bool
+}
+
+func (r *Repository) loadGCConfig(opts *GCOptions, now time.Time) (*gcConfig, error) {
+	c, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	s := c.Raw.Section("gc")
+	cfg := &gcConfig{
+		auto:             defaultGCAuto,
+		autoPackLimit:    defaultPackLimit,
+		cruft:            opts.Cruft || s.Option("cruftPacks") == "true",
+		packRefs:         s.Option("packRefs") != "false",
+		writeCommitGraph: s.Option("writeCommitGraph") != "false",
+		writeBitmaps:     c.Core.IsBare,
+	}
+
+	// The bitmaps are written by default for bare repositories only.
+	if repack := c.Raw.Section("repack"); repack.HasOption("writeBitmaps") {
+		cfg.writeBitmaps = repack.Option("writeBitmaps") == "true"
+	}
+
+	for key, v := range map[string]*int{"auto": &cfg.auto, "autoPackLimit": &cfg.autoPackLimit} {
+		if !s.HasOption(key) {
+			continue
+		}
+
+		if *v, err = strconv.Atoi(s.Option(key)); err != nil {
+			return nil, fmt.Errorf("invalid gc.%s: %w", key, err)
+		}
+	}
+
+	for _, e := range []struct {
+		key, def string
+		v        *time.Time
+	}{
+		{"pruneExpire", "2.weeks.ago", &cfg.pruneExpire},
+		{"reflogExpire", "90.days.ago", &cfg.reflogExpire},
+		{"reflogExpireUnreachable", "30.days.ago", &cfg.reflogExpireUnreachable},
+	} {
+		value := s.Option(e.key)
+		if value == "" {
+			value = e.def
+		}
+
+		if *e.v, err = parseExpireDate(value, now); err != nil {
+			return nil, fmt.Errorf("gc.%s: %w", e.key, err)
+		}
+	}
+
+	switch {
+	case opts.NoPrune:
+		cfg.pruneExpire = time.Time{}
+	case !opts.PruneExpire.IsZero():
+		cfg.pruneExpire = opts.PruneExpire
+	}
+
+	return cfg, nil
+}
+
+var relativeDateRegexp = regexp.MustCompile(`^(\d+)[. ]*(second|minute|hour|day|week|month|year)s?[. ]*ago$`)
+
+// parseExpireDate parses the expiry dates of the config: "now", "never",
+// relative dates such as "2.weeks.ago" and absolute dates. The zero time is
+// returned for "never".
+func parseExpireDate(s string, now time.Time) (time.Time, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "never", "false":
+		return time.Time{}, nil
+	case "now", "all":
+		return now, nil
+	}
+
+	if m := relativeDateRegexp.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidExpireDate, s)
+		}
+
+		switch m[2] {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		default:
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidExpireDate, s)
+}
+
+// needsGC returns true if there are more loose objects than gc.auto or more
+// packs than gc.autoPackLimit. Like git, the number of loose objects is
+// estimated from the objects/17 directory.
+func needsGC(s gcStorer, fs billy.Filesystem, cfg *gcConfig) (bool, error) {
+	if cfg.auto <= 0 {
+		return false, nil
+	}
+
+	entries, err := fs.ReadDir("objects/17")
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	loose := 0
+	for _, e := range entries {
+		if len(e.Name()) == 2*len(plumbing.ZeroHash)-2 && isHexString(e.Name()) {
+			loose++
+		}
+	}
+
+	if loose > (cfg.auto+255)/256 {
+		return true, nil
+	}
+
+	if cfg.autoPackLimit <= 0 {
+		return false, nil
+	}
+
+	packs, err := s.ObjectPacks()
+	if err != nil {
+		return false, err
+	}
+
+	n := 0
+	for _, h := range packs {
+		if !isKeptPack(fs, h) {
+			n++
+		}
+	}
+
+	return n > cfg.autoPackLimit, nil
+}
+
+func isHexString(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func packPath(h plumbing.Hash, ext string) string {
+	return path.Join(packDir, fmt.Sprintf("pack-%s.%s", h, ext))
+}
+
+func isKeptPack(fs billy.Filesystem, h plumbing.Hash) bool {
+	_, err := fs.Stat(packPath(h, "keep"))
+	return err == nil
+}
+
+// expireReflogs removes the expired entries of the reflogs.
+func (r *Repository) expireReflogs(cfg *gcConfig) error {
+	rs, ok := r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return nil
+	}
+
+	names, err := r.referenceNames()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		entries, err := rs.Reflog(name)
+		if err != nil {
+			return err
+		}
+
+		var reachable map[plumbing.Hash]bool
+		kept := make([]*reflog.Entry, 0, len(entries))
+		for _, e := range entries {
+			when := e.Committer.When
+			if !cfg.reflogExpire.IsZero() && when.Before(cfg.reflogExpire) {
+				continue
+			}
+
+			if !cfg.reflogExpireUnreachable.IsZero() && when.Before(cfg.reflogExpireUnreachable) {
+				if reachable == nil {
+					if reachable, err = r.reachableCommits(name); err != nil {
+						return err
+					}
+				}
+
+				if !reachable[e.New] {
+					continue
+				}
+			}
+
+			kept = append(kept, e)
+		}
+
+		if len(kept) != len(entries) {
+			if err := rs.SetReflog(name, kept); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// referenceNames returns the names of the references, including HEAD.
+func (r *Repository) referenceNames() ([]plumbing.ReferenceName, error) {
+	iter, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []plumbing.ReferenceName{plumbing.HEAD}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() != plumbing.HEAD {
+			names = append(names, ref.Name())
+		}
+
+		return nil
+	})
+
+	return names, err
+}
+
+// reachableCommits returns the commits reachable from the reference.
+func (r *Repository) reachableCommits(name plumbing.ReferenceName) (map[plumbing.Hash]bool, error) {
+	reachable := make(map[plumbing.Hash]bool)
+	ref, err := storer.ResolveReference(r.Storer, name)
+	if err == plumbing.ErrReferenceNotFound {
+		return reachable, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return reachable, nil
+	}
+
+	err = object.NewCommitPreorderIter(c, nil, nil).ForEach(func(c *object.Commit) error {
+		reachable[c.Hash] = true
+		return nil
+	})
+
+	return reachable, err
+}
+
+// reachableObjects walks the objects reachable from the references, the
+// reflogs and the index.
+func (r *Repository) reachableObjects() (*objectWalker, error) {
+	w := newObjectWalker(r.Storer)
+	shallows, err := r.Storer.Shallow()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(shallows) > 0 {
+		w.shallow = make(map[plumbing.Hash]bool, len(shallows))
+		for _, h := range shallows {
+			w.shallow[h] = true
+		}
+	}
+
+	remotes, err := r.promisorRemotes()
+	if err != nil {
+		return nil, err
+	}
+
+	w.promised = len(remotes) > 0
+	if err := w.walkAllRefs(); err != nil {
+		return nil, err
+	}
+
+	for _, h := range r.reflogHashes() {
+		if err := w.walkObjectTree(h); err != nil {
+			return nil, err
+		}
+	}
+
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Mode != filemode.Submodule && r.Storer.HasEncodedObject(e.Hash) == nil {
+			w.add(e.Hash)
+		}
+	}
+
+	return w, nil
+}
+
+// reflogHashes returns the existing objects the reflogs point to.
+func (r *Repository) reflogHashes() []plumbing.Hash {
+	rs, ok := r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return nil
+	}
+
+	names, err := r.referenceNames()
+	if err != nil {
+		return nil
+	}
+
+	var hashes []plumbing.Hash
+	for _, name := range names {
+		entries, err := rs.Reflog(name)
+		if err != nil {
+			continue
+		}
+
+		for _, e := range entries {
+			for _, h := range []plumbing.Hash{e.Old, e.New} {
+				if !h.IsZero() && r.Storer.HasEncodedObject(h) == nil {
+					hashes = append(hashes, h)
+				}
+			}
+		}
+	}
+
+	return hashes
+}
+
+// gcPack is an existing pack.
+type gcPack struct {
+	hash   plumbing.Hash
+	kept   bool
+	hashes []plumbing.Hash
+	// mtimes are the modification times of the objects of a cruft pack.
+	mtimes []uint32
+	mtime  time.Time
+}
+
+func readGCPack(fs billy.Filesystem, h plumbing.Hash) (p *gcPack, err error) {
+	p = &gcPack{hash: h, kept: isKeptPack(fs, h)}
+	fi, err := fs.Stat(packPath(h, "pack"))
+	if err != nil {
+		return nil, err
+	}
+
+	p.mtime = fi.ModTime()
+
+	idx, err := readPackIndex(fs, h)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := idx.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		e, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.hashes = append(p.hashes, e.Hash)
+	}
+
+	plumbing.HashesSort(p.hashes)
+
+	mf, err := fs.Open(packPath(h, "mtimes"))
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(mf, &err)
+
+	var m mtimes.MTimes
+	if err := mtimes.NewDecoder(mf).Decode(&m); err != nil {
+		return nil, err
+	}
+
+	if len(m.Times) == len(p.hashes) {
+		p.mtimes = m.Times
+	}
+
+	return p, nil
+}
+
+// objectTime returns the modification time of the i-th object of the pack.
+func (p *gcPack) objectTime(i int) time.Time {
+	if p.mtimes != nil {
+		return time.Unix(int64(p.mtimes[i]), 0)
+	}
+
+	return p.mtime
+}
+
+// repack packs the reachable objects into a single pack, and the unreachable
+// ones which are not expired into a cruft pack or loose objects, removing
+// the previous packs and loose objects.
+func (r *Repository) repack(s gcStorer, fs billy.Filesystem, cfg *gcConfig, reachable *objectWalker) error {
+	hashes, err := s.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	var packs []*gcPack
+	kept := make(map[plumbing.Hash]bool)
+	keptPacks := false
+	for _, h := range hashes {
+		p, err := readGCPack(fs, h)
+		if err != nil {
+			return err
+		}
+
+		packs = append(packs, p)
+		if p.kept {
+			keptPacks = true
+			for _, h := range p.hashes {
+				kept[h] = true
+			}
+		}
+	}
+
+	// The unreachable objects, with their most recent modification time.
+	unreachable := make(map[plumbing.Hash]time.Time)
+	addUnreachable := func(h plumbing.Hash, t time.Time) {
+		if reachable.isSeen(h) || kept[h] {
+			return
+		}
+
+		if u, ok := unreachable[h]; !ok || t.After(u) {
+			unreachable[h] = t
+		}
+	}
+
+	for _, p := range packs {
+		if p.kept {
+			continue
+		}
+
+		for i, h := range p.hashes {
+			addUnreachable(h, p.objectTime(i))
+		}
+	}
+
+	loose := make(map[plumbing.Hash]bool)
+	err = s.ForEachObjectHash(func(h plumbing.Hash) error {
+		loose[h] = true
+		t, err := s.LooseObjectTime(h)
+		if err != nil {
+			return err
+		}
+
+		addUnreachable(h, t)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var packed, cruft []plumbing.Hash
+	for h := range reachable.seen {
+		if !kept[h] {
+			packed = append(packed, h)
+		}
+	}
+
+	pruned := make(map[plumbing.Hash]bool)
+	for h, t := range unreachable {
+		switch {
+		case !cfg.pruneExpire.IsZero() && t.Before(cfg.pruneExpire):
+			pruned[h] = true
+		case cfg.cruft:
+			cruft = append(cruft, h)
+		case !loose[h]:
+			// Objects of the packs which are deleted are loosened.
+			if err := r.loosenObject(s, h); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The entries of the packs which are deleted are reused.
+	var reuse []plumbing.Hash
+	for _, p := range packs {
+		if !p.kept {
+			reuse = append(reuse, p.hash)
+		}
+	}
+
+	newPacks := make(map[plumbing.Hash]bool)
+	if len(packed) > 0 {
+		h, err := r.writeObjectPack(packed, false, reuse)
+		if err != nil {
+			return err
+		}
+
+		newPacks[h] = true
+
+		// The bitmaps need all the reachable objects in the pack, which is
+		// not the case with kept packs, shallow repositories and partial
+		// clones.
+		if cfg.writeBitmaps && !keptPacks && reachable.complete() {
+			if err := r.writePackBitmap(s, h); err != nil {
+				return err
+			}
+		}
+	}
+
+	inCruft := make(map[plumbing.Hash]bool)
+	if len(cruft) > 0 {
+		h, err := r.writeCruftPack(fs, cruft, unreachable, reuse)
+		if err != nil {
+			return err
+		}
+
+		newPacks[h] = true
+		for _, h := range cruft {
+			inCruft[h] = true
+		}
+	}
+
+	if rs, ok := s.(interface{ Reindex() }); ok {
+		defer rs.Reindex()
+	}
+
+	for _, p := range packs {
+		if p.kept || newPacks[p.hash] {
+			continue
+		}
+
+		if err := deleteObjectPack(s, fs, p.hash, time.Time{}); err != nil {
+			return err
+		}
+	}
+
+	if err := removeStaleMultiPackIndex(fs); err != nil {
+		return err
+	}
+
+	for h := range loose {
+		if reachable.isSeen(h) || kept[h] || inCruft[h] || pruned[h] {
+			if err := s.DeleteLooseObject(h); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// loosenObject writes a packed object as a loose object. Its modification
+// time is the current time, not the one of its pack, as the filesystems
+// can't change it: it expires gc.pruneExpire after this garbage collection.
+func (r *Repository) loosenObject(s gcStorer, h plumbing.Hash) error {
+	obj, err := r.Storer.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.(storer.EncodedObjectStorer).SetEncodedObject(obj)
+	return err
+}
+
+// writeCruftPack writes a cruft pack with the given objects and the .mtimes
+// file holding their modification times.
+func (r *Repository) writeCruftPack(fs billy.Filesystem, hashes []plumbing.Hash,
+	times map[plumbing.Hash]time.Time, reuse []plumbing.Hash) (plumbing.Hash, error) {
+	h, err := r.writeObjectPack(hashes, false, reuse)
+	if err != nil {
+		return h, err
+	}
+
+	sorted := append([]plumbing.Hash(nil), hashes...)
+	plumbing.HashesSort(sorted)
+
+	m := &mtimes.MTimes{PackChecksum: h, Times: make([]uint32, len(sorted))}
+	for i, o := range sorted {
+		m.Times[i] = uint32(times[o].Unix())
+	}
+
+	var buf bytes.Buffer
+	if err := mtimes.NewEncoder(&buf).Encode(m); err != nil {
+		return h, err
+	}
+
+	return h, writeFileAtomic(fs, packPath(h, "mtimes"), buf.Bytes())
+}
+
+// writeFileAtomic writes the file through a temporary file renamed once
+// written, replacing any existing file.
+func writeFileAtomic(fs billy.Filesystem, name string, data []byte) (err error) {
+	f, err := fs.TempFile(path.Dir(name), "tmp_")
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		_ = fs.Remove(f.Name())
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		_ = fs.Remove(f.Name())
+		return err
+	}
+
+	if err = fs.Rename(f.Name(), name); err != nil {
+		_ = fs.Remove(f.Name())
+	}
+
+	return err
+}
+
+// writeCommitGraph writes the commit-graph of the commits reachable from the
+// references and the reflogs, replacing any commit-graph chain.
+func (r *Repository) writeCommitGraph(fs billy.Filesystem) error {
+	iter, err := r.Storer.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	var tips []plumbing.Hash
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tips = append(tips, r.reflogHashes()...)
+
+	commits := make(map[plumbing.Hash]*object.Commit)
+	for _, h := range tips {
+		c, err := r.peelToCommit(h)
+		if err != nil || c == nil {
+			continue
+		}
+
+		err = object.NewCommitPreorderIter(c, nil, nil).ForEach(func(c *object.Commit) error {
+			if _, ok := commits[c.Hash]; ok {
+				return storer.ErrStop
+			}
+
+			commits[c.Hash] = c
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	idx, ok := commitGraphIndex(commits)
+	if !ok {
+		// The history is incomplete, as in shallow repositories.
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := commitgraphfmt.NewEncoder(&buf).Encode(idx); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(fs, commitGraphFile, buf.Bytes()); err != nil {
+		return err
+	}
+
+	return removeCommitGraphChain(fs)
+}
+
+// peelToCommit returns the commit an object points to, or nil if it does not
+// point to a commit.
+func (r *Repository) peelToCommit(h plumbing.Hash) (*object.Commit, error) {
+	obj, err := r.Object(plumbing.AnyObject, h)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch o := obj.(type) {
+		case *object.Commit:
+			return o, nil
+		case *object.Tag:
+			if obj, err = o.Object(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// commitGraphIndex computes the generation numbers of the commits, returning
+// false if a parent is missing.
+func commitGraphIndex(commits map[plumbing.Hash]*object.Commit) (*commitgraphfmt.MemoryIndex, bool) {
+	type generations struct{ topological, corrected uint64 }
+	gens := make(map[plumbing.Hash]generations, len(commits))
+
+	hashes := make([]plumbing.Hash, 0, len(commits))
+	for h := range commits {
+		hashes = append(hashes, h)
+	}
+
+	plumbing.HashesSort(hashes)
+
+	for _, h := range hashes {
+		stack := []plumbing.Hash{h}
+		for len(stack) > 0 {
+			c := commits[stack[len(stack)-1]]
+			if _, ok := gens[c.Hash]; ok {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			ready := true
+			g := generations{1, uint64(c.Committer.When.Unix())}
+			for _, p := range c.ParentHashes {
+				if _, ok := commits[p]; !ok {
+					return nil, false
+				}
+
+				pg, ok := gens[p]
+				if !ok {
+					ready = false
+					stack = append(stack, p)
+					continue
+				}
+
+				if pg.topological+1 > g.topological {
+					g.topological = pg.topological + 1
+				}
+
+				if pg.corrected+1 > g.corrected {
+					g.corrected = pg.corrected + 1
+				}
+			}
+
+			if ready {
+				gens[c.Hash] = g
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	idx := commitgraphfmt.NewMemoryIndex()
+	for _, h := range hashes {
+		c := commits[h]
+		idx.Add(h, &commitgraphfmt.CommitData{
+			TreeHash:     c.TreeHash,
+			ParentHashes: c.ParentHashes,
+			Generation:   gens[h].topological,
+			GenerationV2: gens[h].corrected,
+			When:         c.Committer.When,
+		})
+	}
+
+	return idx, true
+}
+
+func removeCommitGraphChain(fs billy.Filesystem) error {
+	entries, err := fs.ReadDir(commitGraphsDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := fs.Remove(path.Join(commitGraphsDir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return fs.Remove(commitGraphsDir)
+}
@@ -0,0 +1,528 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	commitgraph "github.com/jesseduffield/go-git/v5/plumbing/format/commitgraph/v2"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/mtimes"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type GCSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&GCSuite{})
+
+// newGCRepository creates a repository on disk with two commits, returning
+// the repository and its path.
+func (s *GCSuite) newGCRepository(c *C) (*Repository, string) {
+	dir := c.MkDir()
+	r, err := PlainInit(dir, false)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]*string{"a": str("a\n")})
+	commitFiles(c, w, map[string]*string{"b": str("b\n")})
+	return r, dir
+}
+
+// storeBlob stores a loose blob, modified at the given time.
+func (s *GCSuite) storeBlob(c *C, r *Repository, dir, content string, mtime time.Time) plumbing.Hash {
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	wr, err := obj.Writer()
+	c.Assert(err, IsNil)
+	_, err = wr.Write([]byte(content))
+	c.Assert(err, IsNil)
+	c.Assert(wr.Close(), IsNil)
+
+	h, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	name := filepath.Join(dir, ".git", "objects", h.String()[:2], h.String()[2:])
+	c.Assert(os.Chtimes(name, mtime, mtime), IsNil)
+	return h
+}
+
+func (s *GCSuite) packs(c *C, r *Repository) []plumbing.Hash {
+	packs, err := r.Storer.(storer.PackedObjectStorer).ObjectPacks()
+	c.Assert(err, IsNil)
+	return packs
+}
+
+func (s *GCSuite) looseObjects(c *C, r *Repository) []plumbing.Hash {
+	var hashes []plumbing.Hash
+	err := r.Storer.(storer.LooseObjectStorer).ForEachObjectHash(func(h plumbing.Hash) error {
+		hashes = append(hashes, h)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	return hashes
+}
+
+func (s *GCSuite) TestGC(c *C) {
+	r, dir := s.newGCRepository(c)
+	old := s.storeBlob(c, r, dir, "old\n", time.Now().AddDate(0, -1, 0))
+	recent := s.storeBlob(c, r, dir, "recent\n", time.Now())
+
+	err := r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.packs(c, r), HasLen, 1)
+	c.Assert(s.looseObjects(c, r), DeepEquals, []plumbing.Hash{recent})
+
+	_, err = r.Storer.EncodedObject(plumbing.AnyObject, old)
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+	files, err := commit.Files()
+	c.Assert(err, IsNil)
+	c.Assert(files.ForEach(func(*object.File) error { return nil }), IsNil)
+
+	_, err = os.Stat(filepath.Join(dir, ".git", "refs", "heads", "master"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = os.Stat(filepath.Join(dir, ".git", "packed-refs"))
+	c.Assert(err, IsNil)
+
+	f, err := os.Open(filepath.Join(dir, ".git", "objects", "info", "commit-graph"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	idx, err := commitgraph.OpenFileIndex(f)
+	c.Assert(err, IsNil)
+	c.Assert(idx.MaximumNumberOfHashes(), Equals, uint32(2))
+
+	i, err := idx.GetIndexByHash(head.Hash())
+	c.Assert(err, IsNil)
+	data, err := idx.GetCommitDataByIndex(i)
+	c.Assert(err, IsNil)
+	c.Assert(data.Generation, Equals, uint64(2))
+	c.Assert(data.ParentHashes, DeepEquals, commit.ParentHashes)
+
+	// A second garbage collection keeps the same objects.
+	err = r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 1)
+	c.Assert(s.looseObjects(c, r), DeepEquals, []plumbing.Hash{recent})
+}
+
+// gitClone clones url with git, returning the path of the clone.
+func (s *GCSuite) gitClone(c *C, url string, args ...string) string {
+	dir := c.MkDir()
+	cmd := exec.Command("git", append(append([]string{"clone"}, args...), url, dir)...)
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	return dir
+}
+
+// gitCheck checks the repository with git fsck and lists its references.
+func (s *GCSuite) gitCheck(c *C, dir string) string {
+	out, err := exec.Command("git", "-C", dir, "fsck").CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	out, err = exec.Command("git", "-C", dir, "show-ref", "--dereference").CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	return string(out)
+}
+
+func (s *GCSuite) TestGCPackRefs(c *C) {
+	url := s.GetLocalRepositoryURL(fixtures.ByTag("tags").One())
+	dir := s.gitClone(c, url, "--no-local")
+	refs := s.gitCheck(c, dir)
+
+	r, err := PlainOpen(dir)
+	c.Assert(err, IsNil)
+	err = r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+
+	// The remote HEAD is kept as a loose symbolic reference, and the
+	// annotated tags are still peeled.
+	c.Assert(s.gitCheck(c, dir), Equals, refs)
+	ref, err := r.Reference(plumbing.NewRemoteHEADReferenceName("origin"), false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Type(), Equals, plumbing.SymbolicReference)
+
+	content, err := os.ReadFile(filepath.Join(dir, ".git", "packed-refs"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Matches, "# pack-refs with: peeled fully-peeled sorted \n(?s).*\n\\^[0-9a-f]{40}\n.*")
+
+	err = r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(s.gitCheck(c, dir), Equals, refs)
+}
+
+func (s *GCSuite) TestGCShallow(c *C) {
+	url := s.GetBasicLocalRepositoryURL()
+	dir := s.gitClone(c, "file://"+url, "--depth", "1")
+	refs := s.gitCheck(c, dir)
+
+	r, err := PlainOpen(dir)
+	c.Assert(err, IsNil)
+	err = r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 1)
+	c.Assert(s.gitCheck(c, dir), Equals, refs)
+
+	shallows, err := r.Storer.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallows, HasLen, 1)
+}
+
+func (s *GCSuite) TestGCPartialClone(c *C) {
+	dir := c.MkDir()
+	r, err := PlainClone(dir, false, &CloneOptions{
+		URL:    s.partialCloneURL(c),
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, IsNil)
+
+	blobs := s.countBlobs(c, r)
+	err = r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 1)
+	c.Assert(s.looseObjects(c, r), HasLen, 0)
+	c.Assert(s.countBlobs(c, r), Equals, blobs)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	_, err = r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+}
+
+func (s *GCSuite) countBlobs(c *C, r *Repository) int {
+	iter, err := r.Storer.IterEncodedObjects(plumbing.BlobObject)
+	c.Assert(err, IsNil)
+
+	n := 0
+	c.Assert(iter.ForEach(func(plumbing.EncodedObject) error {
+		n++
+		return nil
+	}), IsNil)
+
+	return n
+}
+
+func (s *GCSuite) TestGCNilOptions(c *C) {
+	r, _ := s.newGCRepository(c)
+
+	err := r.GC(nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 1)
+	c.Assert(s.looseObjects(c, r), HasLen, 0)
+}
+
+func (s *GCSuite) TestGCNoPrune(c *C) {
+	r, dir := s.newGCRepository(c)
+	old := s.storeBlob(c, r, dir, "old\n", time.Now().AddDate(-1, 0, 0))
+
+	err := r.GC(&GCOptions{NoPrune: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.looseObjects(c, r), DeepEquals, []plumbing.Hash{old})
+
+	err = r.GC(&GCOptions{PruneExpire: time.Now()})
+	c.Assert(err, IsNil)
+	c.Assert(s.looseObjects(c, r), HasLen, 0)
+}
+
+func (s *GCSuite) TestGCLoosenObjects(c *C) {
+	r, dir := s.newGCRepository(c)
+	mtime := time.Now().AddDate(0, 0, -1).Truncate(time.Second)
+	h := s.storeBlob(c, r, dir, "unreachable\n", mtime)
+
+	err := r.GC(&GCOptions{Cruft: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.looseObjects(c, r), HasLen, 0)
+
+	// The objects of the deleted cruft pack are loosened with the current
+	// time, the filesystems can't keep the one of the pack.
+	err = r.GC(&GCOptions{NoPrune: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 1)
+	c.Assert(s.looseObjects(c, r), DeepEquals, []plumbing.Hash{h})
+
+	t, err := r.Storer.(storer.LooseObjectStorer).LooseObjectTime(h)
+	c.Assert(err, IsNil)
+	c.Assert(t.After(mtime), Equals, true, Commentf("%s", t))
+}
+
+func (s *GCSuite) TestGCCruft(c *C) {
+	r, dir := s.newGCRepository(c)
+	old := s.storeBlob(c, r, dir, "old\n", time.Now().AddDate(0, -1, 0))
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	recent := s.storeBlob(c, r, dir, "recent\n", mtime)
+
+	err := r.GC(&GCOptions{Cruft: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.looseObjects(c, r), HasLen, 0)
+	c.Assert(s.packs(c, r), HasLen, 2)
+
+	_, err = r.Storer.EncodedObject(plumbing.AnyObject, old)
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+	_, err = r.Storer.EncodedObject(plumbing.AnyObject, recent)
+	c.Assert(err, IsNil)
+
+	var cruft *mtimes.MTimes
+	for _, h := range s.packs(c, r) {
+		f, err := os.Open(filepath.Join(dir, ".git", "objects", "pack", "pack-"+h.String()+".mtimes"))
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		c.Assert(err, IsNil)
+		cruft = &mtimes.MTimes{}
+		err = mtimes.NewDecoder(f).Decode(cruft)
+		c.Assert(f.Close(), IsNil)
+		c.Assert(err, IsNil)
+		c.Assert(cruft.PackChecksum, Equals, h)
+	}
+
+	c.Assert(cruft, NotNil)
+	c.Assert(cruft.Times, DeepEquals, []uint32{uint32(mtime.Unix())})
+
+	// The unreachable objects are kept until they expire, with the time of
+	// the .mtimes file.
+	err = r.GC(&GCOptions{Cruft: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 2)
+	_, err = r.Storer.EncodedObject(plumbing.AnyObject, recent)
+	c.Assert(err, IsNil)
+
+	err = r.GC(&GCOptions{Cruft: true, PruneExpire: mtime.Add(time.Second)})
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 1)
+	_, err = r.Storer.EncodedObject(plumbing.AnyObject, recent)
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *GCSuite) TestGCKeepPack(c *C) {
+	r, dir := s.newGCRepository(c)
+	err := r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+
+	packs := s.packs(c, r)
+	c.Assert(packs, HasLen, 1)
+	keep := filepath.Join(dir, ".git", "objects", "pack", "pack-"+packs[0].String()+".keep")
+	c.Assert(os.WriteFile(keep, nil, 0644), IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	commitFiles(c, w, map[string]*string{"c": str("c\n")})
+
+	err = r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(s.looseObjects(c, r), HasLen, 0)
+
+	// The new pack only holds the new commit, its tree and its blob.
+	after := s.packs(c, r)
+	c.Assert(after, HasLen, 2)
+	for _, h := range after {
+		if h == packs[0] {
+			continue
+		}
+
+		p, err := readGCPack(r.Storer.(gcStorer).Filesystem(), h)
+		c.Assert(err, IsNil)
+		c.Assert(p.hashes, HasLen, 3)
+	}
+}
+
+func (s *GCSuite) TestGCAuto(c *C) {
+	r, _ := s.newGCRepository(c)
+	loose := s.looseObjects(c, r)
+
+	err := r.GC(&GCOptions{Auto: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.looseObjects(c, r), HasLen, len(loose))
+	c.Assert(s.packs(c, r), HasLen, 0)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("gc").SetOption("autoPackLimit", "1")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	// A pack holding one of the loose objects.
+	_, err = r.writeObjectPack(loose[:1], false, nil)
+	c.Assert(err, IsNil)
+
+	// Nothing is done with one pack, as it is not more than gc.autoPackLimit.
+	err = r.GC(&GCOptions{Auto: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 1)
+	c.Assert(s.looseObjects(c, r), HasLen, len(loose))
+
+	_, err = r.writeObjectPack(loose[1:2], false, nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 2)
+
+	cfg.Raw.Section("gc").SetOption("auto", "0")
+	c.Assert(r.SetConfig(cfg), IsNil)
+	err = r.GC(&GCOptions{Auto: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 2)
+
+	cfg.Raw.Section("gc").RemoveOption("auto")
+	c.Assert(r.SetConfig(cfg), IsNil)
+	err = r.GC(&GCOptions{Auto: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 1)
+	c.Assert(s.looseObjects(c, r), HasLen, 0)
+}
+
+func (s *GCSuite) TestGCWriteBitmaps(c *C) {
+	r, dir := s.newGCRepository(c)
+	bitmaps := filepath.Join(dir, ".git", "objects", "pack", "*.bitmap")
+
+	// The bitmaps are only written by default for bare repositories.
+	err := r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+	files, err := filepath.Glob(bitmaps)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("repack").SetOption("writeBitmaps", "true")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	s.storeBlob(c, r, dir, "new\n", time.Now())
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	commitFiles(c, w, map[string]*string{"c": str("c\n")})
+
+	err = r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+
+	packs := s.packs(c, r)
+	c.Assert(packs, HasLen, 1)
+	files, err = filepath.Glob(bitmaps)
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, []string{filepath.Join(dir, ".git", packPath(packs[0], "bitmap"))})
+
+	b, err := r.Storer.(storer.PackBitmapStorer).PackBitmap()
+	c.Assert(err, IsNil)
+	c.Assert(b, NotNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	reachable, ok := b.Reachable(head.Hash())
+	c.Assert(ok, Equals, true)
+	// 3 commits, 3 trees and 3 blobs.
+	c.Assert(reachable.Count(), Equals, 9)
+}
+
+func (s *GCSuite) TestGCReflogExpire(c *C) {
+	r, _ := s.newGCRepository(c)
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+
+	// A commit which is not reachable from master.
+	unreachable := &object.Commit{
+		Author:    *mergeSignature,
+		Committer: *mergeSignature,
+		Message:   "unreachable",
+		TreeHash:  commit.TreeHash,
+	}
+	obj := r.Storer.NewEncodedObject()
+	c.Assert(unreachable.Encode(obj), IsNil)
+	unreachableHash, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	entry := func(h plumbing.Hash, age time.Duration, msg string) *reflog.Entry {
+		return &reflog.Entry{
+			New:       h,
+			Committer: reflog.Signature{Name: "go-git", Email: "go-git@fake.local", When: time.Now().Add(-age)},
+			Message:   msg,
+		}
+	}
+
+	day := 24 * time.Hour
+	rs := r.Storer.(storer.ReflogStorer)
+	err = rs.SetReflog(plumbing.Master, []*reflog.Entry{
+		entry(head.Hash(), 100*day, "expired"),
+		entry(unreachableHash, 40*day, "unreachable expired"),
+		entry(head.Hash(), 40*day, "reachable"),
+		entry(unreachableHash, day, "unreachable"),
+	})
+	c.Assert(err, IsNil)
+
+	err = r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+
+	entries, err := rs.Reflog(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Message, Equals, "reachable")
+	c.Assert(entries[1].Message, Equals, "unreachable")
+
+	// The commits of the reflogs are kept.
+	_, err = r.CommitObject(unreachableHash)
+	c.Assert(err, IsNil)
+	c.Assert(s.looseObjects(c, r), HasLen, 0)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("gc").SetOption("reflogExpire", "never")
+	cfg.Raw.Section("gc").SetOption("reflogExpireUnreachable", "never")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	err = rs.SetReflog(plumbing.Master, []*reflog.Entry{entry(head.Hash(), 1000*day, "old")})
+	c.Assert(err, IsNil)
+	err = r.GC(&GCOptions{})
+	c.Assert(err, IsNil)
+
+	entries, err = rs.Reflog(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+}
+
+func (s *GCSuite) TestGCNotSupported(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	err = r.GC(&GCOptions{})
+	c.Assert(err, Equals, ErrPackedObjectsNotSupported)
+}
+
+func (s *GCSuite) TestParseExpireDate(c *C) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	for _, t := range []struct {
+		value    string
+		expected time.Time
+	}{
+		{"never", time.Time{}},
+		{"false", time.Time{}},
+		{"now", now},
+		{"2.weeks.ago", now.AddDate(0, 0, -14)},
+		{"1.day.ago", now.AddDate(0, 0, -1)},
+		{"3 hours ago", now.Add(-3 * time.Hour)},
+		{"1.month.ago", now.AddDate(0, -1, 0)},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	} {
+		expire, err := parseExpireDate(t.value, now)
+		c.Assert(err, IsNil)
+		c.Assert(expire.Equal(t.expected), Equals, true, Commentf("for %q", t.value))
+	}
+
+	_, err := parseExpireDate("soon", now)
+	c.Assert(err, ErrorMatches, `invalid expire date: "soon"`)
+
+}
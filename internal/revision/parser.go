@@ -180,7 +180,9 @@ func (p *Parser) Parse() ([]Revisioner, error) {
 
 // validateFullRevision ensures all revisioner chunks make a valid revision
 func (p *Parser) validateFullRevision(chunks *[]Revisioner) error {
-	var hasReference bool
+	// hasBase is set by "@" statements, which may be followed by "~", "^"
+	// or ":" statements like a reference.
+	var hasReference, hasBase bool
 
 	for i, chunk := range *chunks {
 		switch chunk.(type) {
@@ -191,37 +193,42 @@ func (p *Parser) validateFullRevision(chunks *[]Revisioner) error {
 				return &ErrInvalidRevision{`reference must be defined once at the beginning`}
 			}
 		case AtDate:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasBase = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{<ISO-8601 date>}, @{<ISO-8601 date>}`}
 		case AtReflog:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasBase = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{<n>}, @{<n>}`}
 		case AtCheckout:
-			if len(*chunks) == 1 {
-				return nil
+			if i == 0 {
+				hasBase = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : @{-<n>}`}
 		case AtUpstream:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasBase = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{upstream}, @{upstream}, <refname>@{u}, @{u}`}
 		case AtPush:
-			if len(*chunks) == 1 || hasReference && len(*chunks) == 2 {
-				return nil
+			if i == 0 || hasReference && i == 1 {
+				hasBase = true
+				continue
 			}
 
 			return &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{push}, @{push}`}
 		case TildePath, CaretPath, CaretReg:
-			if !hasReference {
+			if !hasReference && !hasBase {
 				return &ErrInvalidRevision{`"~" or "^" statement must have a reference defined at the beginning`}
 			}
 		case ColonReg:
@@ -231,7 +238,7 @@ func (p *Parser) validateFullRevision(chunks *[]Revisioner) error {
 
 			return &ErrInvalidRevision{`":" statement is not valid, could be : :/<regexp>`}
 		case ColonPath:
-			if i == len(*chunks)-1 && hasReference || len(*chunks) == 1 {
+			if i == len(*chunks)-1 && (hasReference || hasBase) || len(*chunks) == 1 {
 				return nil
 			}
 
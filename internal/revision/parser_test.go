@@ -117,6 +117,19 @@ func (s *ParserSuite) TestParseWithValidExpression(c *C) {
 			Ref("master"),
 			AtDate{tim},
 		},
+		"master@{1}~2": []Revisioner{
+			Ref("master"),
+			AtReflog{1},
+			TildePath{2},
+		},
+		"@{u}^": []Revisioner{
+			AtUpstream{},
+			CaretPath{1},
+		},
+		"@{-1}:README": []Revisioner{
+			AtCheckout{1},
+			ColonPath{"README"},
+		},
 		"HEAD^": []Revisioner{
 			Ref("HEAD"),
 			CaretPath{1},
@@ -191,6 +204,7 @@ func (s *ParserSuite) TestParseWithInvalidExpression(c *C) {
 		"master^1@{2016-12-16T21:42:47Z}": &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{<ISO-8601 date>}, @{<ISO-8601 date>}`},
 		"master^1@{1}":                    &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{<n>}, @{<n>}`},
 		"master@{-1}":                     &ErrInvalidRevision{`"@" statement is not valid, could be : @{-<n>}`},
+		"@{1}@{1}":                        &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{<n>}, @{<n>}`},
 		"master^1@{upstream}":             &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{upstream}, @{upstream}, <refname>@{u}, @{u}`},
 		"master^1@{u}":                    &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{upstream}, @{upstream}, <refname>@{u}, @{u}`},
 		"master^1@{push}":                 &ErrInvalidRevision{`"@" statement is not valid, could be : <refname>@{push}, @{push}`},
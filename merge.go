@@ -0,0 +1,276 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+)
+
+var (
+	// ErrMergeConflict is returned when a merge can not be completed
+	// automatically. The conflicting paths are left unmerged in the index and
+	// with conflict markers in the worktree, and MERGE_HEAD is recorded so
+	// that a later Worktree.Commit concludes the merge.
+	ErrMergeConflict = errors.New("merge conflict")
+	// ErrUnrelatedHistories is returned when merging two commits without a
+	// common ancestor, unless MergeOptions.AllowUnrelatedHistories is set.
+	ErrUnrelatedHistories = errors.New("refusing to merge unrelated histories")
+	// ErrUnmergedPaths is returned when committing an index which still
+	// contains conflicts.
+	ErrUnmergedPaths = errors.New("index contains unmerged paths")
+)
+
+// merge performs a three-way merge of the commit theirs, pointed by the
+// reference name, into HEAD, creating a merge commit if the result is clean.
+// The update of HEAD is recorded in the reflogs as the given action.
+func (w *Worktree) merge(theirs plumbing.Hash, name plumbing.ReferenceName, opts *MergeOptions, action string) error {
+	fastForward := fmt.Sprintf("%s: Fast-forward", action)
+	head, err := w.r.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return w.reset(&ResetOptions{Commit: theirs, Mode: MergeReset}, nil, fastForward)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	oursCommit, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	theirsCommit, err := w.r.CommitObject(theirs)
+	if err != nil {
+		return err
+	}
+
+	bases, err := oursCommit.MergeBase(theirsCommit)
+	if err != nil {
+		return err
+	}
+
+	if len(bases) == 0 && !opts.AllowUnrelatedHistories {
+		return ErrUnrelatedHistories
+	}
+
+	if len(bases) == 1 {
+		switch bases[0].Hash {
+		case theirsCommit.Hash:
+			// Already up to date.
+			return nil
+		case oursCommit.Hash:
+			return w.reset(&ResetOptions{Commit: theirs, Mode: MergeReset}, nil, fastForward)
+		}
+	}
+
+	clean, err := w.isClean()
+	if err != nil {
+		return err
+	}
+
+	if !clean {
+		return ErrWorktreeNotClean
+	}
+
+	baseTree, err := w.r.mergeBaseTree(bases)
+	if err != nil {
+		return err
+	}
+
+	oursTree, err := oursCommit.Tree()
+	if err != nil {
+		return err
+	}
+
+	theirsTree, err := theirsCommit.Tree()
+	if err != nil {
+		return err
+	}
+
+	result, err := object.MergeTrees(w.r.Storer, baseTree, oursTree, theirsTree, &object.MergeTreesOptions{
+		OursLabel:     "HEAD",
+		BaseLabel:     "merged common ancestors",
+		TheirsLabel:   name.Short(),
+		RenameOptions: object.DefaultDiffTreeOptions,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(result.Conflicts) > 0 {
+		return w.checkoutConflicts(result, plumbing.MergeHead, theirs)
+	}
+
+	co := &CommitOptions{
+		Author:    opts.Author,
+		Committer: opts.Committer,
+		Parents:   []plumbing.Hash{oursCommit.Hash, theirsCommit.Hash},
+	}
+	if err := co.Validate(w.r); err != nil {
+		return err
+	}
+
+	msg := opts.Message
+	if msg == "" {
+		msg = fmt.Sprintf("Merge %s\n", mergeName(name))
+	}
+
+	commit, err := w.buildCommitObject(msg, co, result.Tree)
+	if err != nil {
+		return err
+	}
+
+	return w.reset(&ResetOptions{Commit: commit, Mode: MergeReset}, nil,
+		fmt.Sprintf("%s: Merge made by the 'recursive' strategy.", action))
+}
+
+// mergeName describes the merged reference the way git does in merge commit
+// messages and conflict markers.
+func mergeName(n plumbing.ReferenceName) string {
+	switch {
+	case n.IsBranch():
+		return fmt.Sprintf("branch '%s'", n.Short())
+	case n.IsRemote():
+		return fmt.Sprintf("remote-tracking branch '%s'", n.Short())
+	case n.IsTag():
+		return fmt.Sprintf("tag '%s'", n.Short())
+	}
+
+	return fmt.Sprintf("'%s'", n.Short())
+}
+
+// checkoutConflicts writes the result of a conflicting merge to the worktree
+// and the index, and records the commit being merged in the given reference,
+// such as MERGE_HEAD, unless it is empty.
+func (w *Worktree) checkoutConflicts(result *object.MergeTreesResult, state plumbing.ReferenceName, h plumbing.Hash) error {
+	t, err := w.r.TreeObject(result.Tree)
+	if err != nil {
+		return err
+	}
+
+	if err := w.resetIndex(t, nil, nil); err != nil {
+		return err
+	}
+
+	if err := w.resetWorktree(t, nil); err != nil {
+		return err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(result.Conflicts))
+	for _, c := range result.Conflicts {
+		paths = append(paths, c.Path)
+
+		stages := []*object.MergeStage{c.Base, c.Ours, c.Theirs}
+		for _, e := range append(stages, c.Result) {
+			if e == nil {
+				continue
+			}
+
+			if _, err := idx.Remove(e.Path); err != nil && err != index.ErrEntryNotFound {
+				return err
+			}
+		}
+
+		for i, e := range stages {
+			if e == nil {
+				continue
+			}
+
+			idx.Entries = append(idx.Entries, &index.Entry{
+				Name:  e.Path,
+				Hash:  e.Hash,
+				Mode:  e.Mode,
+				Stage: index.AncestorMode + index.Stage(i),
+			})
+		}
+	}
+
+	if err := w.r.Storer.SetIndex(idx); err != nil {
+		return err
+	}
+
+	if state != "" {
+		if err := w.r.Storer.SetReference(plumbing.NewHashReference(state, h)); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrMergeConflict, strings.Join(paths, ", "))
+}
+
+// isClean returns true if neither the index nor the worktree contain changes
+// to tracked files.
+func (w *Worktree) isClean() (bool, error) {
+	s, err := w.Status()
+	if err != nil {
+		return false, err
+	}
+
+	for _, fs := range s {
+		if fs.Staging == Untracked && fs.Worktree == Untracked {
+			continue
+		}
+
+		if fs.Staging != Unmodified || fs.Worktree != Unmodified {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// mergeBaseTree returns the tree to be used as base of a three-way merge. When
+// there is more than one merge base they are merged together, recursively,
+// into a virtual ancestor, like git's recursive and ort strategies do.
+func (r *Repository) mergeBaseTree(bases []*object.Commit) (*object.Tree, error) {
+	if len(bases) == 0 {
+		return nil, nil
+	}
+
+	tree, err := bases[0].Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range bases[1:] {
+		innerBases, err := bases[0].MergeBase(other)
+		if err != nil {
+			return nil, err
+		}
+
+		innerTree, err := r.mergeBaseTree(innerBases)
+		if err != nil {
+			return nil, err
+		}
+
+		otherTree, err := other.Tree()
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := object.MergeTrees(r.Storer, innerTree, tree, otherTree, &object.MergeTreesOptions{
+			OursLabel:     "Temporary merge branch 1",
+			TheirsLabel:   "Temporary merge branch 2",
+			RenameOptions: object.DefaultDiffTreeOptions,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		tree, err = r.TreeObject(result.Tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
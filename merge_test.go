@@ -0,0 +1,243 @@
+package git
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type MergeSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&MergeSuite{})
+
+var mergeSignature = &object.Signature{
+	Name:  "go-git",
+	Email: "go-git@fake.local",
+	When:  time.Unix(1700000000, 0),
+}
+
+// commitFiles writes the given files to the worktree and commits them. A nil
+// content removes the file.
+func commitFiles(c *C, w *Worktree, files map[string]*string) plumbing.Hash {
+	for name, content := range files {
+		if content == nil {
+			_, err := w.Remove(name)
+			c.Assert(err, IsNil)
+			continue
+		}
+
+		err := util.WriteFile(w.Filesystem, name, []byte(*content), 0644)
+		c.Assert(err, IsNil)
+		_, err = w.Add(name)
+		c.Assert(err, IsNil)
+	}
+
+	h, err := w.Commit("commit", &CommitOptions{Author: mergeSignature})
+	c.Assert(err, IsNil)
+	return h
+}
+
+func str(s string) *string { return &s }
+
+// setupMerge creates a repository where master and the branch feature diverge
+// from a common commit.
+func (s *MergeSuite) setupMerge(c *C, base, ours, theirs map[string]*string) (*Repository, *Worktree, plumbing.Reference) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, base)
+
+	feature := plumbing.NewBranchReferenceName("feature")
+	err = w.Checkout(&CheckoutOptions{Branch: feature, Create: true})
+	c.Assert(err, IsNil)
+	theirsHash := commitFiles(c, w, theirs)
+
+	err = w.Checkout(&CheckoutOptions{Branch: plumbing.Master})
+	c.Assert(err, IsNil)
+	commitFiles(c, w, ours)
+
+	return r, w, *plumbing.NewHashReference(feature, theirsHash)
+}
+
+func (s *MergeSuite) TestMergeClean(c *C) {
+	r, w, ref := s.setupMerge(c,
+		map[string]*string{"a": str("1\n2\n3\n4\n5\n"), "b": str("b\n")},
+		map[string]*string{"a": str("one\n2\n3\n4\n5\n"), "c": str("c\n")},
+		map[string]*string{"a": str("1\n2\n3\n4\nfive\n"), "b": nil},
+	)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	err = r.Merge(ref, MergeOptions{Strategy: RecursiveMerge, Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	newHead, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(newHead.Name(), Equals, plumbing.Master)
+
+	commit, err := r.CommitObject(newHead.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{head.Hash(), ref.Hash()})
+	c.Assert(commit.Message, Equals, "Merge branch 'feature'\n")
+
+	content, err := util.ReadFile(w.Filesystem, "a")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "one\n2\n3\n4\nfive\n")
+
+	_, err = w.Filesystem.Stat("b")
+	c.Assert(err, NotNil)
+
+	f, err := commit.File("c")
+	c.Assert(err, IsNil)
+	c.Assert(f.Hash.IsZero(), Equals, false)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *MergeSuite) TestMergeFastForward(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]*string{"a": str("a\n")})
+	feature := plumbing.NewBranchReferenceName("feature")
+	err = w.Checkout(&CheckoutOptions{Branch: feature, Create: true})
+	c.Assert(err, IsNil)
+	h := commitFiles(c, w, map[string]*string{"a": str("b\n")})
+
+	err = w.Checkout(&CheckoutOptions{Branch: plumbing.Master})
+	c.Assert(err, IsNil)
+
+	err = r.Merge(*plumbing.NewHashReference(feature, h), MergeOptions{Strategy: RecursiveMerge})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, h)
+
+	content, err := util.ReadFile(w.Filesystem, "a")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "b\n")
+}
+
+func (s *MergeSuite) TestMergeConflict(c *C) {
+	r, w, ref := s.setupMerge(c,
+		map[string]*string{"a": str("1\n2\n3\n"), "b": str("b\n")},
+		map[string]*string{"a": str("1\nours\n3\n"), "b": str("ours\n")},
+		map[string]*string{"a": str("1\ntheirs\n3\n"), "b": nil},
+	)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	err = r.Merge(ref, MergeOptions{Strategy: RecursiveMerge, Author: mergeSignature})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	// HEAD is left untouched and MERGE_HEAD is recorded.
+	newHead, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(newHead.Hash(), Equals, head.Hash())
+
+	mergeHead, err := r.Reference(plumbing.MergeHead, false)
+	c.Assert(err, IsNil)
+	c.Assert(mergeHead.Hash(), Equals, ref.Hash())
+
+	content, err := util.ReadFile(w.Filesystem, "a")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "1\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feature\n3\n")
+
+	content, err = util.ReadFile(w.Filesystem, "b")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "ours\n")
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+
+	stages := map[string][]index.Stage{}
+	for _, e := range idx.Entries {
+		stages[e.Name] = append(stages[e.Name], e.Stage)
+	}
+	c.Assert(stages["a"], DeepEquals, []index.Stage{index.AncestorMode, index.OurMode, index.TheirMode})
+	c.Assert(stages["b"], DeepEquals, []index.Stage{index.AncestorMode, index.OurMode})
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("a").Staging, Equals, UpdatedButUnmerged)
+
+	_, err = w.Commit("merge", &CommitOptions{Author: mergeSignature})
+	c.Assert(err, Equals, ErrUnmergedPaths)
+
+	// Resolve the conflicts and conclude the merge.
+	err = util.WriteFile(w.Filesystem, "a", []byte("1\nresolved\n3\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("a")
+	c.Assert(err, IsNil)
+	_, err = w.Remove("b")
+	c.Assert(err, IsNil)
+
+	h, err := w.Commit("merge", &CommitOptions{Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{head.Hash(), ref.Hash()})
+
+	_, err = r.Reference(plumbing.MergeHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *MergeSuite) TestMergeAbortWithReset(c *C) {
+	r, w, ref := s.setupMerge(c,
+		map[string]*string{"a": str("1\n")},
+		map[string]*string{"a": str("2\n")},
+		map[string]*string{"a": str("3\n")},
+	)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	err = r.Merge(ref, MergeOptions{Strategy: RecursiveMerge})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	err = w.Reset(&ResetOptions{Commit: head.Hash(), Mode: HardReset})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	_, err = r.Reference(plumbing.MergeHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *MergeSuite) TestMergeDirtyWorktree(c *C) {
+	r, w, ref := s.setupMerge(c,
+		map[string]*string{"a": str("1\n")},
+		map[string]*string{"b": str("2\n")},
+		map[string]*string{"c": str("3\n")},
+	)
+
+	err := util.WriteFile(w.Filesystem, "a", []byte("dirty\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = r.Merge(ref, MergeOptions{Strategy: RecursiveMerge})
+	c.Assert(err, Equals, ErrWorktreeNotClean)
+}
@@ -0,0 +1,434 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/idxfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/midx"
+	"github.com/jesseduffield/go-git/v5/plumbing/hash"
+)
+
+// midxObject is the copy of an object chosen for a multi-pack-index.
+type midxObject struct {
+	hash   plumbing.Hash
+	pack   uint32
+	offset uint64
+}
+
+// WriteMultiPackIndex writes a multi-pack-index of the packs of the
+// repository, like git multi-pack-index write. Only repositories on a
+// filesystem are supported.
+func (r *Repository) WriteMultiPackIndex(opts *MultiPackIndexOptions) error {
+	if opts == nil {
+		opts = &MultiPackIndexOptions{}
+	}
+
+	s, ok := r.Storer.(gcStorer)
+	if !ok {
+		return ErrPackedObjectsNotSupported
+	}
+
+	fs := s.Filesystem()
+	packs, err := s.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	var base []*midx.MultiPackIndex
+	if opts.Incremental {
+		if base, packs, err = unindexedPacks(fs, packs); err != nil {
+			return err
+		}
+
+		if len(packs) == 0 {
+			return nil
+		}
+	}
+
+	m, err := newMultiPackIndex(fs, packs, base, opts.PreferredPack)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := midx.NewEncoder(&buf).Encode(m); err != nil {
+		return err
+	}
+
+	if rs, ok := s.(interface{ Reindex() }); ok {
+		defer rs.Reindex()
+	}
+
+	if !opts.Incremental {
+		if err := writeFileAtomic(fs, midx.FilePath, buf.Bytes()); err != nil {
+			return err
+		}
+
+		return removeMultiPackIndexChain(fs)
+	}
+
+	if err := fs.MkdirAll(midx.ChainDir, 0o755); err != nil {
+		return err
+	}
+
+	var checksum plumbing.Hash
+	copy(checksum[:], buf.Bytes()[buf.Len()-hash.Size:])
+	if err := writeFileAtomic(fs, midx.LayerPath(checksum), buf.Bytes()); err != nil {
+		return err
+	}
+
+	var layers []plumbing.Hash
+	for _, l := range base {
+		layers = append(layers, l.Checksum)
+	}
+
+	buf.Reset()
+	if err := midx.EncodeChain(&buf, append(layers, checksum)); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(fs, midx.ChainPath, buf.Bytes())
+}
+
+// unindexedPacks returns the layers of the multi-pack-index chain and the
+// packs which are not in it. A multi-pack-index file becomes the first
+// layer of the chain.
+func unindexedPacks(fs billy.Filesystem, packs []plumbing.Hash) ([]*midx.MultiPackIndex, []plumbing.Hash, error) {
+	current, err := midx.Open(fs)
+	if os.IsNotExist(err) {
+		return nil, packs, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := fs.Stat(midx.FilePath); err == nil {
+		if err := chainMultiPackIndex(fs, current[0].Checksum); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	exists := make(map[plumbing.Hash]bool, len(packs))
+	for _, h := range packs {
+		exists[h] = true
+	}
+
+	indexed := make(map[plumbing.Hash]bool)
+	for _, l := range current {
+		for id := range l.PackNames {
+			h, ok := l.PackHash(uint32(id))
+			if !ok || !exists[h] {
+				return nil, nil, fmt.Errorf("multi-pack-index refers to a missing pack: %s", l.PackNames[id])
+			}
+
+			indexed[h] = true
+		}
+	}
+
+	var unindexed []plumbing.Hash
+	for _, h := range packs {
+		if !indexed[h] {
+			unindexed = append(unindexed, h)
+		}
+	}
+
+	return current, unindexed, nil
+}
+
+// chainMultiPackIndex moves the multi-pack-index file with the given
+// checksum to the first layer of a chain.
+func chainMultiPackIndex(fs billy.Filesystem, checksum plumbing.Hash) error {
+	if err := fs.MkdirAll(midx.ChainDir, 0o755); err != nil {
+		return err
+	}
+
+	if err := fs.Rename(midx.FilePath, midx.LayerPath(checksum)); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := midx.EncodeChain(&buf, []plumbing.Hash{checksum}); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(fs, midx.ChainPath, buf.Bytes())
+}
+
+// newMultiPackIndex returns the multi-pack-index of the given packs, without
+// the objects already in the base layers. The objects in several packs are
+// taken from the preferred pack, or else from the newest one. Without a
+// preferred pack, the oldest pack is preferred, like git does.
+func newMultiPackIndex(
+	fs billy.Filesystem,
+	packs []plumbing.Hash,
+	base []*midx.MultiPackIndex,
+	preferred plumbing.Hash,
+) (*midx.MultiPackIndex, error) {
+	packs = append([]plumbing.Hash(nil), packs...)
+	sort.Slice(packs, func(i, j int) bool { return packs[i].String() < packs[j].String() })
+
+	m := &midx.MultiPackIndex{}
+	mtimes := make([]time.Time, len(packs))
+	preferredID := -1
+	for i, h := range packs {
+		m.PackNames = append(m.PackNames, path.Base(packPath(h, "idx")))
+		fi, err := fs.Stat(packPath(h, "pack"))
+		if err != nil {
+			return nil, err
+		}
+
+		mtimes[i] = fi.ModTime()
+		if h == preferred || preferred.IsZero() &&
+			(preferredID == -1 || mtimes[i].Before(mtimes[preferredID])) {
+			preferredID = i
+		}
+	}
+
+	if !preferred.IsZero() && (preferredID == -1 || packs[preferredID] != preferred) {
+		return nil, fmt.Errorf("preferred pack not found: %s", preferred)
+	}
+
+	objects := make(map[plumbing.Hash]midxObject)
+	for i, h := range packs {
+		idx, err := readPackIndex(fs, h)
+		if err != nil {
+			return nil, err
+		}
+
+		err = forEachIndexEntry(idx, func(e *idxfile.Entry) error {
+			if findInLayers(base, e.Hash) {
+				return nil
+			}
+
+			o, ok := objects[e.Hash]
+			if ok && (int(o.pack) == preferredID ||
+				i != preferredID && !mtimes[i].After(mtimes[o.pack])) {
+				return nil
+			}
+
+			objects[e.Hash] = midxObject{e.Hash, uint32(i), e.Offset}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sorted := make([]midxObject, 0, len(objects))
+	for _, o := range objects {
+		sorted = append(sorted, o)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].hash[:], sorted[j].hash[:]) < 0
+	})
+
+	for i, o := range sorted {
+		m.Hashes = append(m.Hashes, o.hash)
+		m.PackIDs = append(m.PackIDs, o.pack)
+		m.Offsets = append(m.Offsets, o.offset)
+		m.ReverseIndex = append(m.ReverseIndex, uint32(i))
+	}
+
+	// The reverse index sorts the objects in pseudo-pack order: the ones of
+	// the preferred pack first, then by pack and offset.
+	sort.Slice(m.ReverseIndex, func(i, j int) bool {
+		a, b := sorted[m.ReverseIndex[i]], sorted[m.ReverseIndex[j]]
+		if (int(a.pack) == preferredID) != (int(b.pack) == preferredID) {
+			return int(a.pack) == preferredID
+		}
+
+		if a.pack != b.pack {
+			return a.pack < b.pack
+		}
+
+		return a.offset < b.offset
+	})
+
+	return m, nil
+}
+
+func findInLayers(layers []*midx.MultiPackIndex, h plumbing.Hash) bool {
+	for _, l := range layers {
+		if _, ok := l.Find(h); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func forEachIndexEntry(idx idxfile.Index, f func(*idxfile.Entry) error) error {
+	iter, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	defer iter.Close()
+	for {
+		e, err := iter.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+}
+
+// VerifyMultiPackIndex checks the multi-pack-index of the repository, like
+// git multi-pack-index verify: all its packs must exist, each one of their
+// objects must be in it, and the offsets of the objects must be the ones in
+// their packs. The errors returned wrap midx.ErrMalformedMultiPackIndex if
+// the multi-pack-index is not valid.
+func (r *Repository) VerifyMultiPackIndex() error {
+	s, ok := r.Storer.(gcStorer)
+	if !ok {
+		return ErrPackedObjectsNotSupported
+	}
+
+	fs := s.Filesystem()
+	layers, err := midx.Open(fs)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range layers {
+		if err := verifyMultiPackIndex(fs, layers, m); err != nil {
+			return fmt.Errorf("%w: %s", midx.ErrMalformedMultiPackIndex, err)
+		}
+	}
+
+	return nil
+}
+
+func verifyMultiPackIndex(fs billy.Filesystem, layers []*midx.MultiPackIndex, m *midx.MultiPackIndex) error {
+	if !sort.StringsAreSorted(m.PackNames) {
+		return fmt.Errorf("pack names out of order")
+	}
+
+	for i := 1; i < len(m.Hashes); i++ {
+		if bytes.Compare(m.Hashes[i-1][:], m.Hashes[i][:]) >= 0 {
+			return fmt.Errorf("object %s out of order", m.Hashes[i])
+		}
+	}
+
+	if m.ReverseIndex != nil {
+		seen := make([]bool, len(m.Hashes))
+		for _, pos := range m.ReverseIndex {
+			if seen[pos] {
+				return fmt.Errorf("duplicate position %d in reverse index", pos)
+			}
+
+			seen[pos] = true
+		}
+	}
+
+	indexes := make([]idxfile.Index, len(m.PackNames))
+	for id, name := range m.PackNames {
+		h, ok := m.PackHash(uint32(id))
+		if !ok {
+			return fmt.Errorf("invalid pack name %q", name)
+		}
+
+		idx, err := readPackIndex(fs, h)
+		if err != nil {
+			return fmt.Errorf("pack %s: %w", strings.TrimSuffix(name, ".idx"), err)
+		}
+
+		err = forEachIndexEntry(idx, func(e *idxfile.Entry) error {
+			if !findInLayers(layers, e.Hash) {
+				return fmt.Errorf("object %s of %s is missing", e.Hash, name)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		indexes[id] = idx
+	}
+
+	for i, h := range m.Hashes {
+		offset, err := indexes[m.PackIDs[i]].FindOffset(h)
+		if err != nil {
+			return fmt.Errorf("object %s is not in %s", h, m.PackNames[m.PackIDs[i]])
+		}
+
+		if uint64(offset) != m.Offsets[i] {
+			return fmt.Errorf("incorrect offset for object %s", h)
+		}
+	}
+
+	return nil
+}
+
+// removeStaleMultiPackIndex removes the multi-pack-index if some of its
+// packs have been deleted.
+func removeStaleMultiPackIndex(fs billy.Filesystem) error {
+	layers, err := midx.Open(fs)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	stale := err != nil
+	for _, m := range layers {
+		for id := range m.PackNames {
+			h, ok := m.PackHash(uint32(id))
+			if !ok {
+				stale = true
+				break
+			}
+
+			if _, err := fs.Stat(packPath(h, "pack")); err != nil {
+				stale = true
+				break
+			}
+		}
+	}
+
+	if !stale {
+		return nil
+	}
+
+	if err := fs.Remove(midx.FilePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return removeMultiPackIndexChain(fs)
+}
+
+func removeMultiPackIndexChain(fs billy.Filesystem) error {
+	entries, err := fs.ReadDir(midx.ChainDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := fs.Remove(path.Join(midx.ChainDir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return fs.Remove(midx.ChainDir)
+}
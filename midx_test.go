@@ -0,0 +1,186 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/midx"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type MultiPackIndexSuite struct {
+	GCSuite
+}
+
+var _ = Suite(&MultiPackIndexSuite{})
+
+// writePacks writes a pack with the given blobs for each list of contents,
+// each pack being older than the next one.
+func (s *MultiPackIndexSuite) writePacks(c *C, r *Repository, dir string, contents ...[]string) []plumbing.Hash {
+	var packs []plumbing.Hash
+	mtime := time.Now().Add(-time.Hour)
+	for _, list := range contents {
+		var blobs []plumbing.Hash
+		for _, content := range list {
+			blobs = append(blobs, s.storeBlob(c, r, dir, content, time.Now()))
+		}
+
+		h, err := r.writeObjectPack(blobs, false, nil)
+		c.Assert(err, IsNil)
+		name := filepath.Join(dir, ".git", filepath.FromSlash(packPath(h, "pack")))
+		c.Assert(os.Chtimes(name, mtime, mtime), IsNil)
+		mtime = mtime.Add(time.Minute)
+		packs = append(packs, h)
+
+		for _, b := range blobs {
+			err := r.Storer.(storer.LooseObjectStorer).DeleteLooseObject(b)
+			c.Assert(err == nil || os.IsNotExist(err), Equals, true)
+		}
+	}
+
+	return packs
+}
+
+func (s *MultiPackIndexSuite) layers(c *C, r *Repository) []*midx.MultiPackIndex {
+	layers, err := midx.Open(r.Storer.(*filesystem.Storage).Filesystem())
+	c.Assert(err, IsNil)
+	return layers
+}
+
+// pack returns the pack of the object in the multi-pack-index.
+func (s *MultiPackIndexSuite) pack(c *C, m *midx.MultiPackIndex, h plumbing.Hash) plumbing.Hash {
+	i, ok := m.Find(h)
+	c.Assert(ok, Equals, true)
+	pack, ok := m.PackHash(m.PackIDs[i])
+	c.Assert(ok, Equals, true)
+	return pack
+}
+
+func (s *MultiPackIndexSuite) TestWriteMultiPackIndex(c *C) {
+	r, dir := s.newGCRepository(c)
+	packs := s.writePacks(c, r, dir, []string{"a\n", "foo\n"}, []string{"b\n", "foo\n"}, []string{"bar\n"})
+
+	err := r.WriteMultiPackIndex(nil)
+	c.Assert(err, IsNil)
+	c.Assert(r.VerifyMultiPackIndex(), IsNil)
+
+	layers := s.layers(c, r)
+	c.Assert(layers, HasLen, 1)
+	m := layers[0]
+	c.Assert(m.PackNames, HasLen, 3)
+	c.Assert(m.Hashes, HasLen, 4)
+	c.Assert(m.ReverseIndex, HasLen, 4)
+
+	// The objects in several packs are taken from the oldest one.
+	foo := plumbing.ComputeHash(plumbing.BlobObject, []byte("foo\n"))
+	c.Assert(s.pack(c, m, foo), Equals, packs[0])
+	first := m.ReverseIndex[0]
+	c.Assert(s.pack(c, m, m.Hashes[first]), Equals, packs[0])
+
+	err = r.WriteMultiPackIndex(&MultiPackIndexOptions{PreferredPack: packs[1]})
+	c.Assert(err, IsNil)
+	c.Assert(s.pack(c, s.layers(c, r)[0], foo), Equals, packs[1])
+
+	for _, content := range []string{"a\n", "b\n", "foo\n", "bar\n"} {
+		h := plumbing.ComputeHash(plumbing.BlobObject, []byte(content))
+		_, err := r.BlobObject(h)
+		c.Assert(err, IsNil)
+	}
+
+	err = r.WriteMultiPackIndex(&MultiPackIndexOptions{PreferredPack: plumbing.NewHash("ffffffffffffffffffffffffffffffffffffffff")})
+	c.Assert(err, NotNil)
+}
+
+func (s *MultiPackIndexSuite) TestWriteMultiPackIndexIncremental(c *C) {
+	r, dir := s.newGCRepository(c)
+	packs := s.writePacks(c, r, dir, []string{"a\n", "foo\n"})
+
+	err := r.WriteMultiPackIndex(nil)
+	c.Assert(err, IsNil)
+
+	packs = append(packs, s.writePacks(c, r, dir, []string{"b\n", "foo\n"})...)
+	err = r.WriteMultiPackIndex(&MultiPackIndexOptions{Incremental: true})
+	c.Assert(err, IsNil)
+	c.Assert(r.VerifyMultiPackIndex(), IsNil)
+
+	fs := r.Storer.(*filesystem.Storage).Filesystem()
+	_, err = fs.Stat(midx.FilePath)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	layers := s.layers(c, r)
+	c.Assert(layers, HasLen, 2)
+	c.Assert(layers[1].PackNames, DeepEquals, []string{fmt.Sprintf("pack-%s.idx", packs[1])})
+	// The objects of the first layer are not in the second one.
+	c.Assert(layers[1].Hashes, DeepEquals, []plumbing.Hash{
+		plumbing.ComputeHash(plumbing.BlobObject, []byte("b\n")),
+	})
+
+	// Nothing is written without new packs.
+	err = r.WriteMultiPackIndex(&MultiPackIndexOptions{Incremental: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.layers(c, r), DeepEquals, layers)
+
+	_, err = r.BlobObject(plumbing.ComputeHash(plumbing.BlobObject, []byte("b\n")))
+	c.Assert(err, IsNil)
+
+	// A multi-pack-index file replaces the chain.
+	err = r.WriteMultiPackIndex(nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.layers(c, r)[0].PackNames, HasLen, 2)
+	_, err = fs.Stat(midx.ChainDir)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *MultiPackIndexSuite) TestVerifyMultiPackIndex(c *C) {
+	r, dir := s.newGCRepository(c)
+	s.writePacks(c, r, dir, []string{"a\n", "foo\n"}, []string{"b\n"})
+
+	err := r.VerifyMultiPackIndex()
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(r.WriteMultiPackIndex(nil), IsNil)
+	m := s.layers(c, r)[0]
+	m.Offsets[0]++
+
+	var buf bytes.Buffer
+	c.Assert(midx.NewEncoder(&buf).Encode(m), IsNil)
+	fs := r.Storer.(*filesystem.Storage).Filesystem()
+	c.Assert(util.WriteFile(fs, midx.FilePath, buf.Bytes(), 0o444), IsNil)
+
+	err = r.VerifyMultiPackIndex()
+	c.Assert(errors.Is(err, midx.ErrMalformedMultiPackIndex), Equals, true)
+}
+
+func (s *MultiPackIndexSuite) TestRepackRemovesMultiPackIndex(c *C) {
+	r, dir := s.newGCRepository(c)
+	s.writePacks(c, r, dir, []string{"a\n"}, []string{"b\n"})
+	c.Assert(r.WriteMultiPackIndex(nil), IsNil)
+
+	err := r.RepackObjects(&RepackConfig{})
+	c.Assert(err, IsNil)
+
+	fs := r.Storer.(*filesystem.Storage).Filesystem()
+	_, err = fs.Stat(midx.FilePath)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = r.BlobObject(plumbing.ComputeHash(plumbing.BlobObject, []byte("b\n")))
+	c.Assert(err, IsNil)
+}
+
+func (s *MultiPackIndexSuite) TestMultiPackIndexNotSupported(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	c.Assert(r.WriteMultiPackIndex(nil), Equals, ErrPackedObjectsNotSupported)
+	c.Assert(r.VerifyMultiPackIndex(), Equals, ErrPackedObjectsNotSupported)
+}
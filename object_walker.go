@@ -1,6 +1,7 @@
 package git
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/jesseduffield/go-git/v5/plumbing"
@@ -15,10 +16,23 @@ type objectWalker struct {
 	// seen map can become huge if walking over large
 	// repos. Thus using struct{} as the value type.
 	seen map[plumbing.Hash]struct{}
+	// shallow are the commits of a shallow repository whose parents are
+	// not walked.
+	shallow map[plumbing.Hash]bool
+	// promised allows the objects missing from a partial clone, which are
+	// not seen.
+	promised bool
 }
 
 func newObjectWalker(s storage.Storer) *objectWalker {
-	return &objectWalker{s, map[plumbing.Hash]struct{}{}}
+	return &objectWalker{Storer: s, seen: map[plumbing.Hash]struct{}{}}
+}
+
+// complete returns whether all the objects reachable from the walked ones
+// are in the repository, which is not the case in a shallow repository or
+// in a partial clone.
+func (p *objectWalker) complete() bool {
+	return len(p.shallow) == 0 && !p.promised
 }
 
 // walkAllRefs walks all (hash) references from the repo.
@@ -59,6 +73,10 @@ func (p *objectWalker) walkObjectTree(hash plumbing.Hash) error {
 	p.add(hash)
 	// Fetch the object.
 	obj, err := object.GetObject(p.Storer, hash)
+	if p.promised && errors.Is(err, plumbing.ErrObjectNotFound) {
+		delete(p.seen, hash)
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("getting object %s failed: %v", hash, err)
 	}
@@ -69,6 +87,9 @@ func (p *objectWalker) walkObjectTree(hash plumbing.Hash) error {
 		if err != nil {
 			return err
 		}
+		if p.shallow[hash] {
+			return nil
+		}
 		for _, h := range obj.ParentHashes {
 			err = p.walkObjectTree(h)
 			if err != nil {
@@ -85,7 +106,9 @@ func (p *objectWalker) walkObjectTree(hash plumbing.Hash) error {
 			// Other non-tree objects are somewhat rare, so they
 			// are not special-cased.
 			if obj.Entries[i].Mode|0755 == filemode.Executable {
-				p.add(obj.Entries[i].Hash)
+				if !p.promised || p.Storer.HasEncodedObject(obj.Entries[i].Hash) == nil {
+					p.add(obj.Entries[i].Hash)
+				}
 				continue
 			}
 			// Normal walk for sub-trees (and symlinks etc).
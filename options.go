@@ -11,7 +11,9 @@ import (
 	"github.com/jesseduffield/go-git/v5/config"
 	"github.com/jesseduffield/go-git/v5/plumbing"
 	formatcfg "github.com/jesseduffield/go-git/v5/plumbing/format/config"
+	"github.com/jesseduffield/go-git/v5/plumbing/fsck"
 	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/jesseduffield/go-git/v5/plumbing/transport"
 )
@@ -58,6 +60,11 @@ type CloneOptions struct {
 	NoCheckout bool
 	// Limit fetching to the specified number of commits.
 	Depth int
+	// Filter omits objects from the clone, like the blobs with
+	// packp.FilterBlobNone, making a partial clone. The server must support
+	// it. The remote is recorded as a promisor remote, from which the missing
+	// objects are fetched when they are accessed.
+	Filter packp.Filter
 	// RecurseSubmodules after the clone is created, initialize all submodules
 	// within, using their default settings. This option is ignored if the
 	// cloned repository does not have a worktree.
@@ -93,6 +100,19 @@ type CloneOptions struct {
 type MergeOptions struct {
 	// Strategy defines the merge strategy to be used.
 	Strategy MergeStrategy
+	// Message is the message of the merge commit created by RecursiveMerge.
+	// If empty, a message in the form of "Merge branch 'name'" is used.
+	Message string
+	// Author is the author's signature of the merge commit. If Author is
+	// empty the Name and Email is read from the config, and time.Now it's
+	// used as When.
+	Author *object.Signature
+	// Committer is the committer's signature of the merge commit. If
+	// Committer is nil the Author signature is used.
+	Committer *object.Signature
+	// AllowUnrelatedHistories allows merging histories that do not share a
+	// common ancestor, using an empty tree as base.
+	AllowUnrelatedHistories bool
 }
 
 // MergeStrategy represents the different types of merge strategies.
@@ -106,6 +126,14 @@ const (
 	//
 	// This is the default option.
 	FastForwardMerge MergeStrategy = iota
+	// RecursiveMerge represents a three-way merge, similar to git's ort and
+	// recursive strategies. The current branch is fast-forwarded when
+	// possible, otherwise the trees are merged using the merge base of both
+	// commits, merging the merge bases into a virtual ancestor when there is
+	// more than one. A clean merge creates a merge commit with two parents.
+	// Conflicts are left unmerged in the index, using stages 1 to 3, and with
+	// conflict markers in the worktree, and ErrMergeConflict is returned.
+	RecursiveMerge
 )
 
 // Validate validates the fields and sets the default values.
@@ -159,6 +187,9 @@ type PullOptions struct {
 	CABundle []byte
 	// ProxyOptions provides info required for connecting to a proxy.
 	ProxyOptions transport.ProxyOptions
+	// MergeStrategy defines how the fetched branch is integrated into the
+	// current branch. By default only fast-forwards are possible.
+	MergeStrategy MergeStrategy
 }
 
 // Validate validates the fields and sets the default values.
@@ -199,6 +230,9 @@ type FetchOptions struct {
 	// Depth limit fetching to the specified number of commits from the tip of
 	// each remote branch history.
 	Depth int
+	// Filter omits objects from the fetched packfile, see CloneOptions.Filter.
+	// It defaults to the partial clone filter of a promisor remote.
+	Filter packp.Filter
 	// Auth credentials, if required, to use with the remote repository.
 	Auth transport.AuthMethod
 	// Progress is where the human readable information sent by the server is
@@ -281,7 +315,9 @@ type PushOptions struct {
 	ForceWithLease *ForceWithLease
 	// PushOptions sets options to be transferred to the server during push.
 	Options map[string]string
-	// Atomic sets option to be an atomic push
+	// Atomic sets option to be an atomic push, either all the references are
+	// updated by the server or none of them. The push fails with
+	// ErrAtomicNotSupported if the server does not support it.
 	Atomic bool
 	// ProxyOptions provides info required for connecting to a proxy.
 	ProxyOptions transport.ProxyOptions
@@ -580,6 +616,16 @@ func (o *CommitOptions) Validate(r *Repository) error {
 		if head != nil {
 			o.Parents = []plumbing.Hash{head.Hash()}
 		}
+
+		// Conclude a merge stopped on conflicts.
+		mergeHead, err := r.Storer.Reference(plumbing.MergeHead)
+		if err != nil && err != plumbing.ErrReferenceNotFound {
+			return err
+		}
+
+		if head != nil && mergeHead != nil {
+			o.Parents = append(o.Parents, mergeHead.Hash())
+		}
 	}
 
 	return nil
@@ -789,6 +835,10 @@ type PlainInitOptions struct {
 	// Determines if the repository will have a worktree (non-bare) or not (bare).
 	Bare         bool
 	ObjectFormat formatcfg.ObjectFormat
+	// RefStorage is the storage of the references, files by default. With
+	// reftable, they are stored in reftables, like git init
+	// --ref-format=reftable does.
+	RefStorage formatcfg.RefStorage
 }
 
 // Validate validates the fields and sets the default values.
@@ -816,3 +866,424 @@ func (o *RestoreOptions) Validate() error {
 
 	return nil
 }
+
+var (
+	ErrMissingCommit          = errors.New("commit field is required")
+	ErrContinueAndAbort       = errors.New("continue and abort cannot be used together")
+	ErrMainlineRequired       = errors.New("commit is a merge but no mainline was given")
+	ErrMainlineNotMergeCommit = errors.New("mainline was given but commit is not a merge")
+	ErrInvalidMainline        = errors.New("commit does not have the given mainline parent")
+)
+
+// CherryPickOptions describes how a cherry-pick should be performed.
+type CherryPickOptions struct {
+	// Commit is the commit whose changes are applied on top of HEAD.
+	Commit plumbing.Hash
+	// Mainline is the parent number, starting from 1, used as base when
+	// Commit is a merge commit. It is required when picking merge commits.
+	Mainline int
+	// Committer is the committer's signature of the new commit. If nil the
+	// Name and Email is read from the config, and time.Now it's used as When.
+	// The author of the picked commit is always preserved.
+	Committer *object.Signature
+	// NoCommit applies the changes to the index and the worktree without
+	// creating a commit.
+	NoCommit bool
+	// Continue concludes a cherry-pick stopped on conflicts, once they have
+	// been resolved and added to the index.
+	Continue bool
+	// Abort cancels a cherry-pick stopped on conflicts, restoring the
+	// worktree and the index to HEAD.
+	Abort bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *CherryPickOptions) Validate(r *Repository) error {
+	if err := validateSequencerOptions(o.Commit, o.Continue, o.Abort); err != nil {
+		return err
+	}
+
+	if o.Committer != nil || o.Abort || (o.NoCommit && !o.Continue) {
+		return nil
+	}
+
+	co := &CommitOptions{}
+	if err := co.loadConfigAuthorAndCommitter(r); err != nil {
+		return err
+	}
+
+	o.Committer = co.Committer
+	if o.Committer == nil {
+		o.Committer = co.Author
+	}
+
+	return nil
+}
+
+// RevertOptions describes how a revert should be performed.
+type RevertOptions struct {
+	// Commit is the commit whose changes are reverted on top of HEAD.
+	Commit plumbing.Hash
+	// Mainline is the parent number, starting from 1, of the side of the
+	// merge to be kept when Commit is a merge commit. It is required when
+	// reverting merge commits.
+	Mainline int
+	// Author is the author's signature of the new commit. If nil the Name and
+	// Email is read from the config, and time.Now it's used as When.
+	Author *object.Signature
+	// Committer is the committer's signature of the new commit. If nil the
+	// Author signature is used.
+	Committer *object.Signature
+	// NoCommit applies the changes to the index and the worktree without
+	// creating a commit.
+	NoCommit bool
+	// Continue concludes a revert stopped on conflicts, once they have been
+	// resolved and added to the index.
+	Continue bool
+	// Abort cancels a revert stopped on conflicts, restoring the worktree and
+	// the index to HEAD.
+	Abort bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *RevertOptions) Validate(r *Repository) error {
+	if err := validateSequencerOptions(o.Commit, o.Continue, o.Abort); err != nil {
+		return err
+	}
+
+	if o.Abort || (o.NoCommit && !o.Continue) {
+		return nil
+	}
+
+	if o.Author == nil {
+		co := &CommitOptions{Committer: o.Committer}
+		if err := co.loadConfigAuthorAndCommitter(r); err != nil {
+			return err
+		}
+
+		o.Author = co.Author
+		o.Committer = co.Committer
+	}
+
+	if o.Committer == nil {
+		o.Committer = o.Author
+	}
+
+	return nil
+}
+
+// validateSequencerOptions validates the options shared by cherry-pick and
+// revert.
+func validateSequencerOptions(commit plumbing.Hash, cont, abort bool) error {
+	if cont && abort {
+		return ErrContinueAndAbort
+	}
+
+	if !cont && !abort && commit.IsZero() {
+		return ErrMissingCommit
+	}
+
+	return nil
+}
+
+var (
+	ErrMissingUpstream = errors.New("upstream field is required")
+)
+
+// RebaseOptions describes how a rebase should be performed.
+type RebaseOptions struct {
+	// Upstream is the commit to compare against: the commits of Branch which
+	// are not reachable from Upstream are replayed. It is required unless
+	// Continue or Abort are used.
+	Upstream plumbing.Hash
+	// Onto is the commit the commits are replayed on top of. If zero
+	// Upstream is used.
+	Onto plumbing.Hash
+	// Branch, if given, is checked out before rebasing. By default the
+	// current HEAD is rebased.
+	Branch plumbing.ReferenceName
+	// Todo, if given, is called with the default todo list, picking every
+	// commit in order, and returns the list to be executed, like the
+	// sequence editor of git rebase --interactive.
+	Todo func(todo []RebaseTodo) ([]RebaseTodo, error)
+	// Message, if given, is called to edit the message of reworded and
+	// squashed commits. It receives the commit being applied and the default
+	// message, and returns the message to be used.
+	Message func(c *object.Commit, msg string) (string, error)
+	// Exec is called for every RebaseExec entry of the todo list. An error
+	// stops the rebase, which can then be continued or aborted.
+	Exec func(w *Worktree, command string) error
+	// Committer is the committer's signature of the rewritten commits. If nil
+	// the Name and Email is read from the config, and time.Now it's used as
+	// When. The authors of the rewritten commits are always preserved.
+	Committer *object.Signature
+	// Continue resumes a rebase stopped on conflicts, once they have been
+	// resolved and added to the index, or on a failed exec.
+	Continue bool
+	// Abort cancels a stopped rebase, restoring the original branch.
+	Abort bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *RebaseOptions) Validate(r *Repository) error {
+	if o.Continue && o.Abort {
+		return ErrContinueAndAbort
+	}
+
+	if o.Continue || o.Abort {
+		if !o.Upstream.IsZero() || !o.Onto.IsZero() || o.Branch != "" {
+			return errors.New("upstream, onto and branch cannot be used with continue or abort")
+		}
+	} else {
+		if o.Upstream.IsZero() {
+			return ErrMissingUpstream
+		}
+
+		if o.Onto.IsZero() {
+			o.Onto = o.Upstream
+		}
+	}
+
+	if o.Committer != nil || o.Abort {
+		return nil
+	}
+
+	co := &CommitOptions{}
+	if err := co.loadConfigAuthorAndCommitter(r); err != nil {
+		return err
+	}
+
+	o.Committer = co.Committer
+	if o.Committer == nil {
+		o.Committer = co.Author
+	}
+
+	return nil
+}
+
+// StashOptions describes how local changes should be stashed.
+type StashOptions struct {
+	// Message describes the stash. By default it is built from HEAD, like
+	// "WIP on master: 1234567 subject".
+	Message string
+	// IncludeUntracked stashes the untracked files too, and removes them
+	// from the worktree.
+	IncludeUntracked bool
+	// Author is the signature used for the stash commits. If nil the Name
+	// and Email is read from the config, and time.Now it's used as When.
+	Author *object.Signature
+}
+
+// Validate validates the fields and sets the default values.
+func (o *StashOptions) Validate(r *Repository) error {
+	if o.Author != nil {
+		return nil
+	}
+
+	co := &CommitOptions{}
+	if err := co.loadConfigAuthorAndCommitter(r); err != nil {
+		return err
+	}
+
+	o.Author = co.Author
+	return nil
+}
+
+// StashApplyOptions describes how a stash should be applied.
+type StashApplyOptions struct {
+	// Stash is the position of the stash in the stash list, 0 being the
+	// latest one, as in stash@{0}.
+	Stash int
+	// RestoreIndex restores the changes which were staged when stashing,
+	// instead of leaving all the changes unstaged.
+	RestoreIndex bool
+}
+
+// ApplyOptions describes how a patch should be applied.
+type ApplyOptions struct {
+	// Index applies the patch to both the index and the worktree, the
+	// patched files must not have unstaged changes.
+	Index bool
+	// Cached applies the patch to the index only, the worktree is not
+	// changed.
+	Cached bool
+	// ThreeWay falls back to a three-way merge when a file can not be
+	// patched, using the blob the patch was made against, which must exist
+	// in the repository. The conflicts are written to the worktree and the
+	// index. Implies Index, unless Cached is set.
+	ThreeWay bool
+	// Check only checks that the patch applies, without changing the
+	// worktree nor the index.
+	Check bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *ApplyOptions) Validate() error {
+	if o.ThreeWay && !o.Cached {
+		o.Index = true
+	}
+
+	if o.Cached {
+		o.Index = false
+	}
+
+	return nil
+}
+
+// FormatPatchOptions describes how commits are formatted as patch emails.
+type FormatPatchOptions struct {
+	// From is the commit the range starts after: the commits reachable from
+	// To and not from From are formatted, as in git format-patch From..To.
+	// All the commits reachable from To are formatted if empty.
+	From plumbing.Hash
+	// To is the last commit of the range, HEAD if empty.
+	To plumbing.Hash
+	// SubjectPrefix is written in brackets before the subjects, along with
+	// the number of the patch when there are several. "PATCH" by default.
+	SubjectPrefix string
+	// Signature is written at the end of every email, after a "-- " line.
+	// No signature is written if empty.
+	Signature string
+}
+
+// Validate validates the fields and sets the default values.
+func (o *FormatPatchOptions) Validate(r *Repository) error {
+	if o.To.IsZero() {
+		head, err := r.Head()
+		if err != nil {
+			return err
+		}
+
+		o.To = head.Hash()
+	}
+
+	if o.SubjectPrefix == "" {
+		o.SubjectPrefix = "PATCH"
+	}
+
+	return nil
+}
+
+// AmOptions describes how patch emails should be applied.
+type AmOptions struct {
+	// Committer of the commits, the author is taken from the emails. If
+	// empty, it is read from the configuration.
+	Committer *object.Signature
+	// ThreeWay falls back to a three-way merge when a patch does not apply,
+	// see ApplyOptions.ThreeWay.
+	ThreeWay bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *AmOptions) Validate(r *Repository) error {
+	if o.Committer == nil {
+		co := &CommitOptions{}
+		if err := co.loadConfigAuthorAndCommitter(r); err != nil {
+			return err
+		}
+
+		o.Committer = co.Committer
+		if o.Committer == nil {
+			o.Committer = co.Author
+		}
+	}
+
+	return nil
+}
+
+// DescribeOptions describes how a commit should be described.
+type DescribeOptions struct {
+	// Hash of the commit to describe, HEAD if empty.
+	Hash plumbing.Hash
+	// Tags uses any tag, not only the annotated ones.
+	Tags bool
+	// Match only uses the tags matching any of these glob patterns, the
+	// tag names being given without the refs/tags/ prefix.
+	Match []string
+	// Exclude does not use the tags matching any of these glob patterns.
+	Exclude []string
+	// Abbrev is the minimum number of hexadecimal digits of the abbreviated
+	// hash, 7 by default. If negative, only the tag is written, like
+	// git describe --abbrev=0.
+	Abbrev int
+	// Long always writes the distance to the tag and the abbreviated hash,
+	// even when the commit is tagged.
+	Long bool
+	// Always writes the abbreviated hash when no tag can describe the
+	// commit, instead of failing with ErrNoTagFound.
+	Always bool
+	// Dirty appends DirtyMark to the description when the worktree contains
+	// changes to tracked files. It can only describe HEAD.
+	Dirty bool
+	// DirtyMark is appended when the worktree is dirty, "-dirty" by default.
+	DirtyMark string
+	// Contains describes the commit by the tag which contains it, the oldest
+	// one, instead of the tag it contains, like v1.0~2.
+	Contains bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *DescribeOptions) Validate(r *Repository) error {
+	if o.Dirty && !o.Hash.IsZero() {
+		return errors.New("dirty can not be used with a commit")
+	}
+
+	if o.Hash.IsZero() {
+		head, err := r.Head()
+		if err != nil {
+			return err
+		}
+
+		o.Hash = head.Hash()
+	}
+
+	if o.Abbrev == 0 {
+		o.Abbrev = 7
+	}
+
+	if o.DirtyMark == "" {
+		o.DirtyMark = "-dirty"
+	}
+
+	return nil
+}
+
+// MultiPackIndexOptions describes how a multi-pack-index should be written.
+type MultiPackIndexOptions struct {
+	// PreferredPack is the pack from which the objects in several packs are
+	// taken. By default it is the oldest pack.
+	PreferredPack plumbing.Hash
+	// Incremental writes a new layer of a multi-pack-index chain, indexing
+	// only the packs which are not in the multi-pack-index yet, like git
+	// multi-pack-index write --incremental.
+	Incremental bool
+}
+
+// GCOptions describes how a garbage collection should be performed.
+type GCOptions struct {
+	// Auto only collects the garbage when there are too many loose objects
+	// or packs, according to gc.auto and gc.autoPackLimit, like git gc
+	// --auto. It is meant to be called often, after the operations creating
+	// objects.
+	Auto bool
+	// PruneExpire is the time before which the unreachable objects are
+	// deleted. If empty gc.pruneExpire is used, two weeks ago by default.
+	PruneExpire time.Time
+	// NoPrune keeps all the unreachable objects.
+	NoPrune bool
+	// Cruft writes the unreachable objects which are kept into a cruft pack,
+	// instead of loose objects. It is enabled too by gc.cruftPacks.
+	Cruft bool
+}
+
+// FsckOptions describes how a repository should be checked.
+type FsckOptions struct {
+	// Strict reports the warnings as errors, and rejects the group writable
+	// file modes, like git fsck --strict.
+	Strict bool
+	// Severities overrides the severities of the problems found in the
+	// objects, like the fsck.<msg-id> options of git.
+	Severities map[fsck.ID]fsck.Severity
+	// NoDangling doesn't look for the dangling objects.
+	NoDangling bool
+}
@@ -0,0 +1,230 @@
+package bitmap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/hash"
+	"github.com/jesseduffield/go-git/v5/utils/binary"
+)
+
+const (
+	version = 1
+
+	// optFullDAG tells that the bitmaps give the full closure of objects,
+	// it is required by git.
+	optFullDAG = 0x1
+	// optHashCache tells that the file has a name-hash cache.
+	optHashCache = 0x4
+	// optLookupTable tells that the file has a lookup table.
+	optLookupTable = 0x10
+
+	// lookupTableEntrySize is the size of each entry of the lookup table: a
+	// position, an offset and a position of an XORed bitmap.
+	lookupTableEntrySize = 16
+)
+
+var (
+	signature = []byte{'B', 'I', 'T', 'M'}
+
+	// ErrMalformedBitmap is returned when the .bitmap file is not valid.
+	ErrMalformedBitmap = errors.New("malformed bitmap file")
+	// ErrUnsupportedBitmap is returned when the .bitmap file has an
+	// unsupported version or options.
+	ErrUnsupportedBitmap = errors.New("unsupported bitmap file")
+)
+
+// Index is the content of a .bitmap file.
+type Index struct {
+	// PackChecksum is the checksum of the pack.
+	PackChecksum plumbing.Hash
+	// Commits, Trees, Blobs and Tags are the objects of the pack of each
+	// type.
+	Commits, Trees, Blobs, Tags *Bitmap
+	// Entries are the bitmaps of the commits.
+	Entries []*Entry
+	// HashCache holds the hashes of the paths of the objects, in the order
+	// of the pack index. It is optional.
+	HashCache []uint32
+}
+
+// Entry is the bitmap of a commit.
+type Entry struct {
+	// Position is the position of the commit in the pack index, that is the
+	// number of objects of the pack with a lower hash.
+	Position uint32
+	// XorOffset is the number of entries to go back to the entry whose
+	// bitmap is XORed with Bitmap to give the objects reachable from the
+	// commit, or 0 if Bitmap gives them.
+	XorOffset uint8
+	// Flags are the flags of the entry.
+	Flags uint8
+	// Bitmap is the bitmap of the entry as stored.
+	Bitmap *Bitmap
+}
+
+// Encoder writes an Index to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w}
+}
+
+// Encode writes idx to the output stream.
+func (e *Encoder) Encode(idx *Index) error {
+	h := hash.New(hash.CryptoType)
+	w := io.MultiWriter(e.w, h)
+
+	options := uint16(optFullDAG)
+	if len(idx.HashCache) > 0 {
+		options |= optHashCache
+	}
+
+	if _, err := w.Write(signature); err != nil {
+		return err
+	}
+
+	err := binary.Write(w, uint16(version), options, uint32(len(idx.Entries)), idx.PackChecksum)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range []*Bitmap{idx.Commits, idx.Trees, idx.Blobs, idx.Tags} {
+		if b == nil {
+			b = NewBitmap()
+		}
+
+		if err := writeEWAH(w, b); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range idx.Entries {
+		err := binary.Write(w, entry.Position, entry.XorOffset, entry.Flags)
+		if err != nil {
+			return err
+		}
+
+		if err := writeEWAH(w, entry.Bitmap); err != nil {
+			return err
+		}
+	}
+
+	for _, nh := range idx.HashCache {
+		if err := binary.WriteUint32(w, nh); err != nil {
+			return err
+		}
+	}
+
+	_, err = e.w.Write(h.Sum(nil))
+	return err
+}
+
+// Decoder reads an Index from an input stream.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a new decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r}
+}
+
+// Decode reads the whole input stream into idx, checking its checksum.
+func (d *Decoder) Decode(idx *Index) error {
+	data, err := io.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	header := len(signature) + 8 + hash.Size
+	if len(data) < header+hash.Size || !bytes.Equal(data[:len(signature)], signature) {
+		return ErrMalformedBitmap
+	}
+
+	h := hash.New(hash.CryptoType)
+	h.Write(data[:len(data)-hash.Size])
+	if !bytes.Equal(h.Sum(nil), data[len(data)-hash.Size:]) {
+		return ErrMalformedBitmap
+	}
+
+	var v, options uint16
+	var count uint32
+	r := bytes.NewReader(data[len(signature):header])
+	if err := binary.Read(r, &v, &options, &count); err != nil {
+		return err
+	}
+
+	if v != version || options&optFullDAG == 0 ||
+		options&^(optFullDAG|optHashCache|optLookupTable) != 0 {
+		return ErrUnsupportedBitmap
+	}
+
+	if _, err := r.Read(idx.PackChecksum[:]); err != nil {
+		return err
+	}
+
+	end := len(data) - hash.Size
+	if options&optLookupTable != 0 {
+		end -= int(count) * lookupTableEntrySize
+		if end < header {
+			return ErrMalformedBitmap
+		}
+	}
+
+	r = bytes.NewReader(data[header:end])
+	for _, b := range []**Bitmap{&idx.Commits, &idx.Trees, &idx.Blobs, &idx.Tags} {
+		if *b, err = readEWAH(r); err != nil {
+			return malformed(err)
+		}
+	}
+
+	idx.Entries = make([]*Entry, count)
+	for i := range idx.Entries {
+		entry := &Entry{}
+		if err := binary.Read(r, &entry.Position, &entry.XorOffset, &entry.Flags); err != nil {
+			return malformed(err)
+		}
+
+		if int(entry.XorOffset) > i {
+			return ErrMalformedBitmap
+		}
+
+		if entry.Bitmap, err = readEWAH(r); err != nil {
+			return malformed(err)
+		}
+
+		idx.Entries[i] = entry
+	}
+
+	if options&optHashCache == 0 {
+		return nil
+	}
+
+	if r.Len()%4 != 0 {
+		return ErrMalformedBitmap
+	}
+
+	idx.HashCache = make([]uint32, r.Len()/4)
+	for i := range idx.HashCache {
+		if idx.HashCache[i], err = binary.ReadUint32(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// malformed returns ErrMalformedBitmap if the data ended too early.
+func malformed(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrMalformedBitmap
+	}
+
+	return err
+}
@@ -0,0 +1,143 @@
+package bitmap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/idxfile"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type BitmapSuite struct{}
+
+var _ = Suite(&BitmapSuite{})
+
+// gitBitmap was written by git repack -adb, for a pack with two commits.
+const gitBitmap = "QklUTQABAAUAAAACZhTXcXTSsglQW8MTg4aTTfPejBgAAAACAAAAAgAAAAIAAAAAAAAAAAAAAAMAAAAAAAAABgAAAAIAAAACAAAAAAAAAAAAAAAwAAAAAAAAAAQAAAACAAAAAgAAAAAAAAAAAAAADAAAAAAAAAAAAAAAAQAAAAAAAAAAAAAAAAAAAAMAAAAAAEAAAAACAAAAAgAAAAAAAAAAAAAAPwAAAAAAAAAFAAAAAABAAAAAAgAAAAIAAAAAAAAAAAAAACYAAAAAAAAAAGIAAABhAAAAAAAAAAAAAAAAAAAAZo+vCZAgUQ/BQiVR/CwQKCNAh1w="
+
+// gitPack are the objects of the pack of gitBitmap, in the order of their
+// offsets.
+var gitPack = []struct {
+	hash   string
+	offset uint64
+}{
+	{"9ca8c4eba32e3674f1ee4b5b5ec8bbdf1ddbd98b", 12},
+	{"f44950af6fa9aeb7149cf76e4fa0db6bd7b9430b", 135},
+	{"78981922613b2afb6025042ff6bd878ac1994e85", 228},
+	{"61780798228d17af2d34fce4cfbdf35556832472", 239},
+	{"3683f870be446c7cc05ffaef9fa06415276e1828", 250},
+	{"aaff74984cccd156a469afa7d9ab10e4777beb24", 315},
+}
+
+func (s *BitmapSuite) decode(c *C) (*Index, []byte) {
+	data, err := base64.StdEncoding.DecodeString(gitBitmap)
+	c.Assert(err, IsNil)
+
+	idx := &Index{}
+	err = NewDecoder(bytes.NewReader(data)).Decode(idx)
+	c.Assert(err, IsNil)
+	return idx, data
+}
+
+func (s *BitmapSuite) packIndex(c *C, checksum plumbing.Hash) *idxfile.MemoryIndex {
+	w := &idxfile.Writer{}
+	c.Assert(w.OnHeader(uint32(len(gitPack))), IsNil)
+	for _, o := range gitPack {
+		w.Add(plumbing.NewHash(o.hash), o.offset, 0)
+	}
+
+	c.Assert(w.OnFooter(checksum), IsNil)
+	idx, err := w.Index()
+	c.Assert(err, IsNil)
+	return idx
+}
+
+func (s *BitmapSuite) TestDecodeEncode(c *C) {
+	idx, data := s.decode(c)
+	c.Assert(idx.PackChecksum, Equals, plumbing.NewHash("6614d77174d2b209505bc3138386934df3de8c18"))
+	c.Assert(positions(c, idx.Commits), DeepEquals, []int{0, 1})
+	c.Assert(positions(c, idx.Trees), DeepEquals, []int{4, 5})
+	c.Assert(positions(c, idx.Blobs), DeepEquals, []int{2, 3})
+	c.Assert(positions(c, idx.Tags), IsNil)
+	c.Assert(idx.Entries, HasLen, 2)
+	c.Assert(idx.Entries[0].Position, Equals, uint32(3))
+	c.Assert(positions(c, idx.Entries[0].Bitmap), DeepEquals, []int{0, 1, 2, 3, 4, 5})
+	c.Assert(idx.Entries[1].Position, Equals, uint32(5))
+	c.Assert(positions(c, idx.Entries[1].Bitmap), DeepEquals, []int{1, 2, 5})
+	c.Assert(idx.HashCache, DeepEquals, []uint32{0, 0x62000000, 0x61000000, 0, 0, 0})
+
+	var buf bytes.Buffer
+	err := NewEncoder(&buf).Encode(idx)
+	c.Assert(err, IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, data)
+}
+
+func (s *BitmapSuite) TestDecodeMalformed(c *C) {
+	_, data := s.decode(c)
+
+	for _, d := range [][]byte{
+		data[:20],
+		append([]byte("XITM"), data[4:]...),
+		append(bytes.Clone(data[:len(data)-1]), 0),
+	} {
+		err := NewDecoder(bytes.NewReader(d)).Decode(&Index{})
+		c.Assert(err, Equals, ErrMalformedBitmap)
+	}
+}
+
+func (s *BitmapSuite) TestPackBitmap(c *C) {
+	idx, _ := s.decode(c)
+
+	b, err := NewPackBitmap(idx, s.packIndex(c, idx.PackChecksum))
+	c.Assert(err, IsNil)
+	c.Assert(b.Len(), Equals, len(gitPack))
+
+	for i, o := range gitPack {
+		pos, ok, err := b.Position(plumbing.NewHash(o.hash))
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, true)
+		c.Assert(pos, Equals, i)
+
+		h, err := b.Hash(i)
+		c.Assert(err, IsNil)
+		c.Assert(h.String(), Equals, o.hash)
+	}
+
+	_, ok, err := b.Position(plumbing.ZeroHash)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+
+	c.Assert(b.Type(0), Equals, plumbing.CommitObject)
+	c.Assert(b.Type(3), Equals, plumbing.BlobObject)
+	c.Assert(b.Type(5), Equals, plumbing.TreeObject)
+
+	r, ok := b.Reachable(plumbing.NewHash(gitPack[1].hash))
+	c.Assert(ok, Equals, true)
+	c.Assert(positions(c, r), DeepEquals, []int{1, 2, 5})
+
+	_, ok = b.Reachable(plumbing.NewHash(gitPack[2].hash))
+	c.Assert(ok, Equals, false)
+}
+
+func (s *BitmapSuite) TestPackBitmapXor(c *C) {
+	idx, _ := s.decode(c)
+	xored := idx.Entries[1].Bitmap.Clone()
+	xored.Xor(idx.Entries[0].Bitmap)
+	idx.Entries[1] = &Entry{Position: 5, XorOffset: 1, Bitmap: xored}
+
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(idx), IsNil)
+	decoded := &Index{}
+	c.Assert(NewDecoder(&buf).Decode(decoded), IsNil)
+
+	b, err := NewPackBitmap(decoded, s.packIndex(c, idx.PackChecksum))
+	c.Assert(err, IsNil)
+	r, ok := b.Reachable(plumbing.NewHash(gitPack[1].hash))
+	c.Assert(ok, Equals, true)
+	c.Assert(positions(c, r), DeepEquals, []int{1, 2, 5})
+}
@@ -0,0 +1,42 @@
+// Package bitmap implements encoding and decoding of the .bitmap files of
+// packs, and the EWAH compressed bitmaps they are made of.
+//
+// A bitmap index gives the objects reachable from some commits of a pack, so
+// they do not have to be found walking the history. Bit i of a bitmap is set
+// if the i-th object of the pack, in the order of their offsets, is in the
+// set. A .bitmap file has the following format:
+//
+//   - A 4-byte signature: 'BITM'.
+//
+//   - A 2-byte version number, in network byte order: 1.
+//
+//   - 2 bytes of flags, in network byte order: 0x1 is always set, 0x4 if the
+//     file has a name-hash cache and 0x10 if it has a lookup table.
+//
+//   - The number of commits with a bitmap, as 4 bytes in network byte order.
+//
+//   - The checksum of the pack.
+//
+//   - Four bitmaps of the commits, trees, blobs and tags of the pack.
+//
+//   - For each commit with a bitmap, the position of the commit in the pack
+//     index as 4 bytes in network byte order, a 1-byte offset back to the
+//     commit whose bitmap is XORed with this one (0 for none), 1 byte of
+//     flags and the bitmap of the objects reachable from the commit.
+//
+//   - Optionally, the name-hash cache: a 4-byte hash of the path of each
+//     object, in the order of the pack index.
+//
+//   - Optionally, a lookup table to find the bitmaps of the commits.
+//
+//   - The checksum of all the above.
+//
+// A bitmap is stored in the EWAH format: the number of bits and the number
+// of 64-bit words as 4 bytes each, the words, and the position of the last
+// run-length word as 4 bytes, all in network byte order. Each run-length
+// word tells if its run is of zeros or ones in its lowest bit, the number of
+// words of the run in the next 32 bits and the number of literal words
+// which follow it in the highest 31 bits.
+//
+// See https://git-scm.com/docs/gitformat-bitmap
+package bitmap
@@ -0,0 +1,226 @@
+package bitmap
+
+import (
+	"io"
+	"math/bits"
+
+	"github.com/jesseduffield/go-git/v5/utils/binary"
+)
+
+const (
+	wordSize       = 64
+	runBits        = 32
+	literalBits    = 31
+	maxRunLength   = 1<<runBits - 1
+	maxLiteralRun  = 1<<literalBits - 1
+	allOnes        = ^uint64(0)
+	runLengthShift = 1
+	literalShift   = 1 + runBits
+)
+
+// Bitmap is an uncompressed set of positions.
+type Bitmap struct {
+	words []uint64
+	// size is the number of bits of the bitmap, which may be more than the
+	// highest position in it.
+	size int
+}
+
+// NewBitmap returns an empty bitmap.
+func NewBitmap() *Bitmap {
+	return &Bitmap{}
+}
+
+// Set adds the position i to the bitmap.
+func (b *Bitmap) Set(i int) {
+	w := i / wordSize
+	if w >= len(b.words) {
+		b.grow(w + 1)
+	}
+
+	b.words[w] |= 1 << (uint(i) % wordSize)
+	if i >= b.size {
+		b.size = i + 1
+	}
+}
+
+// Get returns whether the position i is in the bitmap.
+func (b *Bitmap) Get(i int) bool {
+	w := i / wordSize
+	if w >= len(b.words) {
+		return false
+	}
+
+	return b.words[w]&(1<<(uint(i)%wordSize)) != 0
+}
+
+// Or adds the positions of o to the bitmap.
+func (b *Bitmap) Or(o *Bitmap) {
+	b.grow(len(o.words))
+	for i, w := range o.words {
+		b.words[i] |= w
+	}
+
+	b.size = max(b.size, o.size)
+}
+
+// Xor toggles the positions of o in the bitmap.
+func (b *Bitmap) Xor(o *Bitmap) {
+	b.grow(len(o.words))
+	for i, w := range o.words {
+		b.words[i] ^= w
+	}
+
+	b.size = max(b.size, o.size)
+}
+
+// AndNot removes the positions of o from the bitmap.
+func (b *Bitmap) AndNot(o *Bitmap) {
+	for i := 0; i < len(b.words) && i < len(o.words); i++ {
+		b.words[i] &^= o.words[i]
+	}
+}
+
+// Count returns the number of positions in the bitmap.
+func (b *Bitmap) Count() int {
+	n := 0
+	for _, w := range b.words {
+		n += bits.OnesCount64(w)
+	}
+
+	return n
+}
+
+// ForEach calls f with each position of the bitmap, in increasing order.
+func (b *Bitmap) ForEach(f func(int) error) error {
+	for i, w := range b.words {
+		for w != 0 {
+			t := bits.TrailingZeros64(w)
+			if err := f(i*wordSize + t); err != nil {
+				return err
+			}
+
+			w &= w - 1
+		}
+	}
+
+	return nil
+}
+
+// Clone returns a copy of the bitmap.
+func (b *Bitmap) Clone() *Bitmap {
+	return &Bitmap{words: append([]uint64(nil), b.words...), size: b.size}
+}
+
+func (b *Bitmap) grow(n int) {
+	if n > len(b.words) {
+		b.words = append(b.words, make([]uint64, n-len(b.words))...)
+	}
+}
+
+// readEWAH reads a bitmap in the EWAH format.
+func readEWAH(r io.Reader) (*Bitmap, error) {
+	size, err := binary.ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := binary.ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+
+	maxWords := (int(size) + wordSize - 1) / wordSize
+	b := &Bitmap{words: make([]uint64, 0, maxWords), size: int(size)}
+	for i := uint32(0); i < n; {
+		rlw, err := binary.ReadUint64(r)
+		if err != nil {
+			return nil, err
+		}
+
+		run := int(rlw >> runLengthShift & maxRunLength)
+		literals := uint32(rlw >> literalShift)
+		if len(b.words)+run+int(literals) > maxWords || literals >= n-i {
+			return nil, ErrMalformedBitmap
+		}
+
+		fill := uint64(0)
+		if rlw&1 != 0 {
+			fill = allOnes
+		}
+
+		for ; run > 0; run-- {
+			b.words = append(b.words, fill)
+		}
+
+		for ; literals > 0; literals-- {
+			w, err := binary.ReadUint64(r)
+			if err != nil {
+				return nil, err
+			}
+
+			b.words = append(b.words, w)
+			i++
+		}
+
+		i++
+	}
+
+	// The position of the last run-length word is only used to append bits
+	// to a compressed bitmap.
+	if _, err := binary.ReadUint32(r); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// writeEWAH writes the bitmap in the EWAH format.
+func writeEWAH(w io.Writer, b *Bitmap) error {
+	words := b.words[:min(len(b.words), (b.size+wordSize-1)/wordSize)]
+	for len(words)*wordSize < b.size {
+		words = append(words, 0)
+	}
+
+	var out []uint64
+	last := 0
+	for i := 0; i < len(words) || len(out) == 0; {
+		var rlw uint64
+		run := 0
+		if i < len(words) && (words[i] == 0 || words[i] == allOnes) {
+			fill := words[i]
+			for i < len(words) && words[i] == fill && run < maxRunLength {
+				run++
+				i++
+			}
+
+			rlw = fill & 1
+		}
+
+		start := i
+		for i < len(words) && words[i] != 0 && words[i] != allOnes && i-start < maxLiteralRun {
+			i++
+		}
+
+		last = len(out)
+		rlw |= uint64(run)<<runLengthShift | uint64(i-start)<<literalShift
+		out = append(out, rlw)
+		out = append(out, words[start:i]...)
+	}
+
+	if err := binary.WriteUint32(w, uint32(b.size)); err != nil {
+		return err
+	}
+
+	if err := binary.WriteUint32(w, uint32(len(out))); err != nil {
+		return err
+	}
+
+	for _, word := range out {
+		if err := binary.WriteUint64(w, word); err != nil {
+			return err
+		}
+	}
+
+	return binary.WriteUint32(w, uint32(last))
+}
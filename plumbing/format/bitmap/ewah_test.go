@@ -0,0 +1,101 @@
+package bitmap
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+)
+
+type EWAHSuite struct{}
+
+var _ = Suite(&EWAHSuite{})
+
+func (s *EWAHSuite) TestBitmap(c *C) {
+	b := NewBitmap()
+	for _, i := range []int{0, 3, 64, 200} {
+		b.Set(i)
+	}
+
+	c.Assert(b.Get(3), Equals, true)
+	c.Assert(b.Get(4), Equals, false)
+	c.Assert(b.Get(1000), Equals, false)
+	c.Assert(b.Count(), Equals, 4)
+
+	o := NewBitmap()
+	o.Set(3)
+	o.Set(500)
+
+	or := b.Clone()
+	or.Or(o)
+	c.Assert(positions(c, or), DeepEquals, []int{0, 3, 64, 200, 500})
+
+	xor := b.Clone()
+	xor.Xor(o)
+	c.Assert(positions(c, xor), DeepEquals, []int{0, 64, 200, 500})
+
+	b.AndNot(o)
+	c.Assert(positions(c, b), DeepEquals, []int{0, 64, 200})
+}
+
+func (s *EWAHSuite) TestWriteEWAH(c *C) {
+	b := NewBitmap()
+	b.Set(0)
+	b.Set(1)
+	b.Set(200)
+
+	var buf bytes.Buffer
+	c.Assert(writeEWAH(&buf, b), IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, []byte{
+		0, 0, 0, 201, // bits
+		0, 0, 0, 4, // words
+		0, 0, 0, 2, 0, 0, 0, 0, // no run, 1 literal word
+		0, 0, 0, 0, 0, 0, 0, 3,
+		0, 0, 0, 2, 0, 0, 0, 4, // run of 2 words of zeros, 1 literal word
+		0, 0, 0, 0, 0, 0, 1, 0,
+		0, 0, 0, 2, // last run-length word
+	})
+
+	r, err := readEWAH(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, IsNil)
+	c.Assert(r, DeepEquals, b)
+}
+
+func (s *EWAHSuite) TestEWAHRuns(c *C) {
+	for _, b := range []*Bitmap{
+		NewBitmap(),
+		{words: []uint64{allOnes, allOnes, 0, 0, 5, allOnes, 7}, size: 7 * wordSize},
+		{words: []uint64{0, 0, 0}, size: 3 * wordSize},
+		{words: []uint64{allOnes}, size: wordSize},
+	} {
+		var buf bytes.Buffer
+		c.Assert(writeEWAH(&buf, b), IsNil)
+
+		r, err := readEWAH(bytes.NewReader(buf.Bytes()))
+		c.Assert(err, IsNil)
+		c.Assert(positions(c, r), DeepEquals, positions(c, b))
+		c.Assert(r.size, Equals, b.size)
+	}
+}
+
+func (s *EWAHSuite) TestReadEWAHMalformed(c *C) {
+	for _, data := range [][]byte{
+		{0, 0, 0, 64, 0, 0, 0, 1},
+		// A run longer than the bitmap.
+		{0, 0, 0, 64, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+		// More literal words than words.
+		{0, 0, 0, 64, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0},
+	} {
+		_, err := readEWAH(bytes.NewReader(data))
+		c.Assert(err, NotNil)
+	}
+}
+
+func positions(c *C, b *Bitmap) []int {
+	var p []int
+	err := b.ForEach(func(i int) error {
+		p = append(p, i)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	return p
+}
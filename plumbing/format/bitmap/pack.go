@@ -0,0 +1,156 @@
+package bitmap
+
+import (
+	"io"
+	"sort"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/idxfile"
+)
+
+// PackBitmap gives the objects reachable from the commits of a pack with a
+// bitmap index. The positions of its bitmaps are the ones of the objects in
+// the pack, in the order of their offsets.
+type PackBitmap struct {
+	index *Index
+	pack  idxfile.Index
+	// offsets are the offsets of the objects, sorted.
+	offsets []int64
+	// commits are the entries of the commits with a bitmap.
+	commits map[plumbing.Hash]int
+	// resolved are the bitmaps of the entries, with their XOR applied.
+	resolved []*Bitmap
+}
+
+// NewPackBitmap returns the PackBitmap of the given bitmap index of the pack
+// with the given pack index.
+func NewPackBitmap(idx *Index, pack idxfile.Index) (*PackBitmap, error) {
+	b := &PackBitmap{
+		index:    idx,
+		pack:     pack,
+		commits:  make(map[plumbing.Hash]int, len(idx.Entries)),
+		resolved: make([]*Bitmap, len(idx.Entries)),
+	}
+
+	iter, err := pack.EntriesByOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	defer iter.Close()
+	for {
+		e, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		b.offsets = append(b.offsets, int64(e.Offset))
+	}
+
+	// The commits are found by their position in the pack index, which is
+	// sorted by hash.
+	positions := make(map[uint32][]int, len(idx.Entries))
+	last := uint32(0)
+	for i, e := range idx.Entries {
+		if int(e.Position) >= len(b.offsets) {
+			return nil, ErrMalformedBitmap
+		}
+
+		positions[e.Position] = append(positions[e.Position], i)
+		if e.Position > last {
+			last = e.Position
+		}
+	}
+
+	entries, err := pack.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	defer entries.Close()
+	for pos := uint32(0); len(idx.Entries) > 0 && pos <= last; pos++ {
+		e, err := entries.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, i := range positions[pos] {
+			b.commits[e.Hash] = i
+		}
+	}
+
+	return b, nil
+}
+
+// Len returns the number of objects of the pack.
+func (b *PackBitmap) Len() int {
+	return len(b.offsets)
+}
+
+// Position returns the position of the object with the given hash, or false
+// if it is not in the pack.
+func (b *PackBitmap) Position(h plumbing.Hash) (int, bool, error) {
+	offset, err := b.pack.FindOffset(h)
+	if err == plumbing.ErrObjectNotFound {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	i := sort.Search(len(b.offsets), func(i int) bool { return b.offsets[i] >= offset })
+	return i, true, nil
+}
+
+// Hash returns the hash of the object at the given position.
+func (b *PackBitmap) Hash(pos int) (plumbing.Hash, error) {
+	return b.pack.FindHash(b.offsets[pos])
+}
+
+// Type returns the type of the object at the given position.
+func (b *PackBitmap) Type(pos int) plumbing.ObjectType {
+	switch {
+	case b.index.Commits.Get(pos):
+		return plumbing.CommitObject
+	case b.index.Trees.Get(pos):
+		return plumbing.TreeObject
+	case b.index.Blobs.Get(pos):
+		return plumbing.BlobObject
+	case b.index.Tags.Get(pos):
+		return plumbing.TagObject
+	}
+
+	return plumbing.InvalidObject
+}
+
+// Reachable returns the objects reachable from the given commit, or false if
+// the commit has no bitmap. The returned bitmap must not be modified.
+func (b *PackBitmap) Reachable(commit plumbing.Hash) (*Bitmap, bool) {
+	i, ok := b.commits[commit]
+	if !ok {
+		return nil, false
+	}
+
+	return b.resolve(i), true
+}
+
+func (b *PackBitmap) resolve(i int) *Bitmap {
+	if b.resolved[i] != nil {
+		return b.resolved[i]
+	}
+
+	e := b.index.Entries[i]
+	r := e.Bitmap
+	if e.XorOffset != 0 {
+		r = r.Clone()
+		r.Xor(b.resolve(i - int(e.XorOffset)))
+	}
+
+	b.resolved[i] = r
+	return r
+}
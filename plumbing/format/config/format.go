@@ -51,3 +51,18 @@ const (
 	// DefaultObjectFormat holds the default object format.
 	DefaultObjectFormat = SHA1
 )
+
+// RefStorage defines the storage of the references.
+type RefStorage string
+
+const (
+	// FilesRefStorage stores the references in loose files and in the
+	// packed-refs file.
+	FilesRefStorage RefStorage = "files"
+
+	// ReftableRefStorage stores the references in a stack of reftables.
+	ReftableRefStorage RefStorage = "reftable"
+
+	// DefaultRefStorage holds the default storage of the references.
+	DefaultRefStorage = FilesRefStorage
+)
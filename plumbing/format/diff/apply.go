@@ -0,0 +1,128 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+)
+
+// ErrPatchDoesNotApply is returned when a patch can not be applied to the
+// content of a file.
+var ErrPatchDoesNotApply = errors.New("patch does not apply")
+
+// Apply applies the patch to the content of the "from" file, returning the
+// content of the "to" file. Like git apply, the hunks are looked for around
+// the lines given by their headers, so they still apply when the lines
+// before them have changed. The content is returned unchanged if the patch
+// only renames the file or changes its mode.
+func (p *UnifiedFilePatch) Apply(src []byte) ([]byte, error) {
+	if p.isBinary {
+		return p.applyBinary(src)
+	}
+
+	lines := splitLines(string(src))
+
+	var out strings.Builder
+	var pos, offset int
+	for i, f := range p.fragments {
+		var pre, post []string
+		for _, l := range f.Lines {
+			if l.Op != Add {
+				pre = append(pre, l.Content)
+			}
+
+			if l.Op != Delete {
+				post = append(post, l.Content)
+			}
+		}
+
+		// Hunks without old lines insert after the line they start at.
+		want := f.OldPos - 1
+		if f.OldLines == 0 {
+			want = f.OldPos
+		}
+
+		at := findLines(lines, pre, pos, want+offset)
+		if at < 0 {
+			return nil, fmt.Errorf("%w: hunk #%d at line %d", ErrPatchDoesNotApply, i+1, f.OldPos)
+		}
+
+		offset = at - want
+		for _, l := range lines[pos:at] {
+			out.WriteString(l)
+		}
+
+		for _, l := range post {
+			out.WriteString(l)
+		}
+
+		pos = at + len(pre)
+	}
+
+	for _, l := range lines[pos:] {
+		out.WriteString(l)
+	}
+
+	return []byte(out.String()), nil
+}
+
+func (p *UnifiedFilePatch) applyBinary(src []byte) ([]byte, error) {
+	if p.binary == nil {
+		return nil, fmt.Errorf("%w: binary patch without data", ErrPatchDoesNotApply)
+	}
+
+	if p.from != nil {
+		if h := p.from.Hash(); !h.IsZero() && h != plumbing.ComputeHash(plumbing.BlobObject, src) {
+			return nil, fmt.Errorf("%w: binary file does not match the patch", ErrPatchDoesNotApply)
+		}
+	}
+
+	if p.binary.Method == BinaryPatchLiteral {
+		return p.binary.Data, nil
+	}
+
+	dst, err := packfile.PatchDelta(src, p.binary.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrPatchDoesNotApply, err)
+	}
+
+	return dst, nil
+}
+
+// findLines returns the position of the lines in content, the nearest to
+// want not before min, or -1 if they are not found.
+func findLines(content, lines []string, min, want int) int {
+	last := len(content) - len(lines)
+	if want < min {
+		want = min
+	}
+
+	if want > last {
+		want = last
+	}
+
+	for d := 0; want-d >= min || want+d <= last; d++ {
+		if at := want - d; at >= min && at <= last && matchLines(content[at:], lines) {
+			return at
+		}
+
+		if at := want + d; d != 0 && at >= min && at <= last && matchLines(content[at:], lines) {
+			return at
+		}
+	}
+
+	return -1
+}
+
+func matchLines(content, lines []string) bool {
+	for i, l := range lines {
+		if content[i] != l {
+			return false
+		}
+	}
+
+	return true
+}
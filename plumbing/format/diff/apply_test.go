@@ -0,0 +1,104 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+type ApplyTestSuite struct{}
+
+var _ = Suite(&ApplyTestSuite{})
+
+func (s *ApplyTestSuite) filePatch(c *C, patch string, n int) *UnifiedFilePatch {
+	return decodePatch(c, patch).FilePatches()[n].(*UnifiedFilePatch)
+}
+
+func (s *ApplyTestSuite) TestApply(c *C) {
+	for _, t := range []struct {
+		n        int
+		src, dst string
+	}{
+		{0, "", "new\n"},
+		{2, "x\ny\n", ""},
+		{3, "no newline", "no newline either"},
+		{4, "r1\nr2\nr3\nr4\nr5\n", "r1\nr2\nr3\nr4\nR5\n"},
+		{5, "mode\n", "mode\n"},
+		{7, "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n", "a\nB\nc\nd\ne\nf\ng\nh\nI\nj\nk\n"},
+	} {
+		dst, err := s.filePatch(c, gitPatch, t.n).Apply([]byte(t.src))
+		c.Assert(err, IsNil, Commentf("file patch %d", t.n))
+		c.Assert(string(dst), Equals, t.dst, Commentf("file patch %d", t.n))
+	}
+}
+
+func (s *ApplyTestSuite) TestApplyOffset(c *C) {
+	fp := s.filePatch(c, gitPatch, 4)
+
+	dst, err := fp.Apply([]byte("r0\nr0\nr1\nr2\nr3\nr4\nr5\nr6\n"))
+	c.Assert(err, IsNil)
+	c.Assert(string(dst), Equals, "r0\nr0\nr1\nr2\nr3\nr4\nR5\nr6\n")
+}
+
+func (s *ApplyTestSuite) TestApplyMismatch(c *C) {
+	fp := s.filePatch(c, gitPatch, 4)
+
+	_, err := fp.Apply([]byte("r1\nr2\nr3\nr4\nchanged\n"))
+	c.Assert(errors.Is(err, ErrPatchDoesNotApply), Equals, true)
+	c.Assert(err, ErrorMatches, "patch does not apply: hunk #1 at line 2")
+}
+
+func (s *ApplyTestSuite) TestApplyBinaryLiteral(c *C) {
+	fp := s.filePatch(c, gitPatch, 1)
+
+	dst, err := fp.Apply([]byte("\x00\x01\x02binary\x00data"))
+	c.Assert(err, IsNil)
+	c.Assert(dst, DeepEquals, []byte("\x00\x01\x02binary\x00data2"))
+
+	// The full hash of the old file is checked.
+	_, err = fp.Apply([]byte("other"))
+	c.Assert(errors.Is(err, ErrPatchDoesNotApply), Equals, true)
+}
+
+func (s *ApplyTestSuite) TestApplyBinaryDelta(c *C) {
+	// Written by git diff --binary, changing the byte 1000.
+	fp := s.filePatch(c, `diff --git a/big b/big
+index e57fd5b4e8e07e39a62591e0851986e97116111c..b17250ee5972142f79a8aca9c2568489e2d589ce 100644
+GIT binary patch
+delta 13
+UcmZn=Xb{-&f|>FE#+TwO041aaaR2}S
+
+delta 10
+PcmZn=Xb@P$!U9A959|VU
+
+`, 0)
+
+	src := make([]byte, 0, 2048)
+	for i := 0; i < 8; i++ {
+		for b := 0; b < 256; b++ {
+			src = append(src, byte(b))
+		}
+	}
+
+	expected := bytes.Clone(src)
+	expected[1000] = 0xff
+
+	c.Assert(fp.BinaryFragment().Method, Equals, BinaryPatchDelta)
+
+	dst, err := fp.Apply(src)
+	c.Assert(err, IsNil)
+	c.Assert(dst, DeepEquals, expected)
+}
+
+func (s *ApplyTestSuite) TestApplyBinaryWithoutData(c *C) {
+	fp := s.filePatch(c, `diff --git a/binary b/binary
+index a459bc245bdbc45e1bca99e7fe61731da5c48da4..6879395eacf3cc7e5634064ccb617ac7aa62be7d 100644
+Binary files a/binary and b/binary differ
+`, 0)
+
+	c.Assert(fp.IsBinary(), Equals, true)
+
+	_, err := fp.Apply([]byte("something"))
+	c.Assert(errors.Is(err, ErrPatchDoesNotApply), Equals, true)
+}
@@ -0,0 +1,612 @@
+package diff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+)
+
+// ErrMalformedPatch is returned by UnifiedDecoder when the patch is
+// malformed.
+var ErrMalformedPatch = errors.New("malformed patch")
+
+const (
+	devNull = "/dev/null"
+
+	gitHeaderPrefix    = "diff --git "
+	oldFilePrefix      = "--- "
+	newFilePrefix      = "+++ "
+	fragmentPrefix     = "@@ -"
+	binaryPatchHeader  = "GIT binary patch"
+	binaryFilesPrefix  = "Binary files "
+	noNewlineAtEOFLine = `\ No newline at end of file`
+)
+
+// UnifiedDecoder decodes unified diffs, such as the ones written by
+// UnifiedEncoder, git diff or git format-patch, including the extended
+// headers of git, like the renames or the mode changes, and the binary
+// patches.
+type UnifiedDecoder struct {
+	r io.Reader
+
+	lines []string
+	pos   int
+}
+
+// NewUnifiedDecoder returns a new UnifiedDecoder that reads from r.
+func NewUnifiedDecoder(r io.Reader) *UnifiedDecoder {
+	return &UnifiedDecoder{r: r}
+}
+
+// Decode decodes the patch. The text preceding the first file patch is
+// returned as the message of the patch, the text between the file patches is
+// ignored. Like git apply, the first directory of the paths, usually the a/
+// and b/ prefixes, is removed.
+func (d *UnifiedDecoder) Decode() (*UnifiedPatch, error) {
+	content, err := io.ReadAll(d.r)
+	if err != nil {
+		return nil, err
+	}
+
+	d.lines = strings.SplitAfter(string(content), "\n")
+	if d.lines[len(d.lines)-1] == "" {
+		d.lines = d.lines[:len(d.lines)-1]
+	}
+
+	p := &UnifiedPatch{}
+
+	var msg strings.Builder
+	for d.pos < len(d.lines) && !d.atFileHeader() {
+		msg.WriteString(d.lines[d.pos])
+		d.pos++
+	}
+
+	p.message = msg.String()
+
+	for d.pos < len(d.lines) {
+		if !d.atFileHeader() {
+			d.pos++
+			continue
+		}
+
+		fp, err := d.decodeFilePatch()
+		if err != nil {
+			return nil, err
+		}
+
+		p.filePatches = append(p.filePatches, fp)
+	}
+
+	return p, nil
+}
+
+// atFileHeader returns true if the current line starts the patch of a file.
+func (d *UnifiedDecoder) atFileHeader() bool {
+	if strings.HasPrefix(d.lines[d.pos], gitHeaderPrefix) {
+		return true
+	}
+
+	return d.pos+2 < len(d.lines) &&
+		strings.HasPrefix(d.lines[d.pos], oldFilePrefix) &&
+		strings.HasPrefix(d.lines[d.pos+1], newFilePrefix) &&
+		strings.HasPrefix(d.lines[d.pos+2], fragmentPrefix)
+}
+
+// fileHeader is the header of the patch of a file.
+type fileHeader struct {
+	oldName, newName string
+	oldMode, newMode filemode.FileMode
+	oldHash, newHash string
+
+	isNew, isDelete bool
+	isCopy          bool
+}
+
+func (d *UnifiedDecoder) decodeFilePatch() (*UnifiedFilePatch, error) {
+	h := &fileHeader{}
+
+	var err error
+	if strings.HasPrefix(d.lines[d.pos], gitHeaderPrefix) {
+		err = d.decodeGitHeader(h)
+	} else {
+		err = d.decodeTraditionalHeader(h)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	fp := &UnifiedFilePatch{isCopy: h.isCopy}
+	if !h.isNew {
+		fp.from = &UnifiedFile{path: h.oldName, mode: h.oldMode, hash: h.oldHash}
+	}
+
+	if !h.isDelete {
+		fp.to = &UnifiedFile{path: h.newName, mode: h.newMode, hash: h.newHash}
+	}
+
+	if d.pos < len(d.lines) {
+		line := trimLineEnd(d.lines[d.pos])
+		switch {
+		case line == binaryPatchHeader:
+			d.pos++
+			fp.isBinary = true
+			if fp.binary, err = d.decodeBinaryFragment(); err != nil {
+				return nil, err
+			}
+
+			if fp.reverseBinary, err = d.decodeBinaryFragment(); err != nil {
+				return nil, err
+			}
+
+			if fp.binary == nil {
+				return nil, d.errorf("binary patch without data")
+			}
+		case strings.HasPrefix(line, binaryFilesPrefix) && strings.HasSuffix(line, " differ"):
+			d.pos++
+			fp.isBinary = true
+		}
+	}
+
+	for d.pos < len(d.lines) && strings.HasPrefix(d.lines[d.pos], fragmentPrefix) {
+		f, err := d.decodeFragment()
+		if err != nil {
+			return nil, err
+		}
+
+		fp.fragments = append(fp.fragments, f)
+	}
+
+	return fp, nil
+}
+
+// decodeGitHeader decodes a header starting with "diff --git", followed by
+// the extended header lines.
+func (d *UnifiedDecoder) decodeGitHeader(h *fileHeader) error {
+	name := parseGitHeaderName(trimLineEnd(strings.TrimPrefix(d.lines[d.pos], gitHeaderPrefix)))
+	h.oldName, h.newName = name, name
+	d.pos++
+
+	for ; d.pos < len(d.lines); d.pos++ {
+		line := trimLineEnd(d.lines[d.pos])
+
+		var err error
+		switch {
+		case strings.HasPrefix(line, oldFilePrefix):
+			if name, ok := parseName(line[len(oldFilePrefix):]); ok {
+				h.oldName = name
+			}
+		case strings.HasPrefix(line, newFilePrefix):
+			if name, ok := parseName(line[len(newFilePrefix):]); ok {
+				h.newName = name
+			}
+		case strings.HasPrefix(line, "old mode "):
+			h.oldMode, err = parseMode(line[len("old mode "):])
+		case strings.HasPrefix(line, "new mode "):
+			h.newMode, err = parseMode(line[len("new mode "):])
+		case strings.HasPrefix(line, "deleted file mode "):
+			h.isDelete = true
+			h.oldMode, err = parseMode(line[len("deleted file mode "):])
+		case strings.HasPrefix(line, "new file mode "):
+			h.isNew = true
+			h.newMode, err = parseMode(line[len("new file mode "):])
+		case strings.HasPrefix(line, "rename from "):
+			h.oldName = unquoteName(line[len("rename from "):])
+		case strings.HasPrefix(line, "rename to "):
+			h.newName = unquoteName(line[len("rename to "):])
+		case strings.HasPrefix(line, "copy from "):
+			h.isCopy = true
+			h.oldName = unquoteName(line[len("copy from "):])
+		case strings.HasPrefix(line, "copy to "):
+			h.newName = unquoteName(line[len("copy to "):])
+		case strings.HasPrefix(line, "similarity index "),
+			strings.HasPrefix(line, "dissimilarity index "):
+		case strings.HasPrefix(line, "index "):
+			err = parseIndexLine(h, line[len("index "):])
+		default:
+			if h.oldName == "" && h.newName == "" {
+				return d.errorf("git diff header lacks filename information")
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return d.errorf("%s", err)
+		}
+	}
+
+	return nil
+}
+
+// decodeTraditionalHeader decodes the "---" and "+++" lines of a patch
+// without git header.
+func (d *UnifiedDecoder) decodeTraditionalHeader(h *fileHeader) error {
+	oldName, oldOK := parseName(trimLineEnd(d.lines[d.pos])[len(oldFilePrefix):])
+	newName, newOK := parseName(trimLineEnd(d.lines[d.pos+1])[len(newFilePrefix):])
+	d.pos += 2
+
+	switch {
+	case !oldOK && !newOK:
+		return d.errorf("both files are %s", devNull)
+	case !oldOK:
+		h.isNew = true
+		oldName = newName
+	case !newOK:
+		h.isDelete = true
+		newName = oldName
+	}
+
+	h.oldName, h.newName = oldName, newName
+	return nil
+}
+
+func (d *UnifiedDecoder) decodeFragment() (*Fragment, error) {
+	f := &Fragment{}
+
+	header := trimLineEnd(d.lines[d.pos])
+	end := strings.Index(header[len(fragmentPrefix):], " @@")
+	if end < 0 {
+		return nil, d.errorf("invalid hunk header %q", header)
+	}
+
+	ranges := strings.Fields(header[len(fragmentPrefix) : len(fragmentPrefix)+end])
+	if len(ranges) != 2 || !strings.HasPrefix(ranges[1], "+") {
+		return nil, d.errorf("invalid hunk header %q", header)
+	}
+
+	var err error
+	if f.OldPos, f.OldLines, err = parseRange(ranges[0]); err != nil {
+		return nil, d.errorf("invalid hunk header %q", header)
+	}
+
+	if f.NewPos, f.NewLines, err = parseRange(ranges[1][1:]); err != nil {
+		return nil, d.errorf("invalid hunk header %q", header)
+	}
+
+	f.Comment = strings.TrimSpace(header[len(fragmentPrefix)+end+len(" @@"):])
+	d.pos++
+
+	oldLines, newLines := f.OldLines, f.NewLines
+	for oldLines > 0 || newLines > 0 {
+		if d.pos >= len(d.lines) {
+			return nil, d.errorf("truncated hunk %q", header)
+		}
+
+		line := d.lines[d.pos]
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+
+		var op Operation
+		switch line[0] {
+		case ' ', '\n', '\r':
+			// Some editors remove the space of the empty context lines.
+			op = Equal
+			oldLines--
+			newLines--
+		case '-':
+			op = Delete
+			oldLines--
+		case '+':
+			op = Add
+			newLines--
+		case '\\':
+			if err := f.noNewlineAtEOF(); err != nil {
+				return nil, d.errorf("%s", err)
+			}
+
+			d.pos++
+			continue
+		default:
+			return nil, d.errorf("invalid hunk line %q", trimLineEnd(line))
+		}
+
+		if oldLines < 0 || newLines < 0 {
+			return nil, d.errorf("hunk %q has more lines than expected", header)
+		}
+
+		content := line
+		if op != Equal || line[0] == ' ' {
+			content = line[1:]
+		}
+
+		f.Lines = append(f.Lines, FragmentLine{Op: op, Content: content})
+		d.pos++
+	}
+
+	if d.pos < len(d.lines) && strings.HasPrefix(d.lines[d.pos], "\\") {
+		if err := f.noNewlineAtEOF(); err != nil {
+			return nil, d.errorf("%s", err)
+		}
+
+		d.pos++
+	}
+
+	return f, nil
+}
+
+// noNewlineAtEOF removes the line feed of the last line of the fragment.
+func (f *Fragment) noNewlineAtEOF() error {
+	if len(f.Lines) == 0 {
+		return fmt.Errorf("unexpected %q", noNewlineAtEOFLine)
+	}
+
+	l := &f.Lines[len(f.Lines)-1]
+	l.Content = strings.TrimSuffix(l.Content, "\n")
+	return nil
+}
+
+// decodeBinaryFragment decodes a "literal" or "delta" binary hunk, encoded
+// in base85 like git does. It returns nil if there is no hunk.
+func (d *UnifiedDecoder) decodeBinaryFragment() (*BinaryFragment, error) {
+	if d.pos >= len(d.lines) {
+		return nil, nil
+	}
+
+	header := trimLineEnd(d.lines[d.pos])
+
+	f := &BinaryFragment{}
+	var sizeField string
+	switch {
+	case strings.HasPrefix(header, "literal "):
+		f.Method = BinaryPatchLiteral
+		sizeField = header[len("literal "):]
+	case strings.HasPrefix(header, "delta "):
+		f.Method = BinaryPatchDelta
+		sizeField = header[len("delta "):]
+	default:
+		return nil, nil
+	}
+
+	size, err := strconv.ParseInt(sizeField, 10, 64)
+	if err != nil || size < 0 {
+		return nil, d.errorf("invalid binary hunk header %q", header)
+	}
+
+	d.pos++
+
+	var deflated []byte
+	for ; d.pos < len(d.lines); d.pos++ {
+		line := trimLineEnd(d.lines[d.pos])
+		if line == "" {
+			d.pos++
+			break
+		}
+
+		data, err := decodeBase85Line(line)
+		if err != nil {
+			return nil, d.errorf("%s", err)
+		}
+
+		deflated = append(deflated, data...)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(deflated))
+	if err != nil {
+		return nil, d.errorf("corrupt binary patch: %s", err)
+	}
+
+	defer zr.Close()
+
+	f.Data, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, d.errorf("corrupt binary patch: %s", err)
+	}
+
+	if int64(len(f.Data)) != size {
+		return nil, d.errorf("corrupt binary patch: expected %d bytes, got %d", size, len(f.Data))
+	}
+
+	return f, nil
+}
+
+func (d *UnifiedDecoder) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: line %d: %s", ErrMalformedPatch, d.pos+1, fmt.Sprintf(format, args...))
+}
+
+// parseGitHeaderName returns the name of the file in the "diff --git" line,
+// if both names are the same. Otherwise the names are given by the extended
+// headers.
+func parseGitHeaderName(line string) string {
+	if strings.HasPrefix(line, `"`) {
+		end := closingQuote(line)
+		if end < 0 || end+1 >= len(line) || line[end+1] != ' ' {
+			return ""
+		}
+
+		a, aOK := parseName(line[:end+1])
+		b, bOK := parseName(line[end+2:])
+		if !aOK || !bOK || a != b {
+			return ""
+		}
+
+		return a
+	}
+
+	for i := 0; i < len(line); i++ {
+		if line[i] != ' ' {
+			continue
+		}
+
+		a := stripComponent(line[:i])
+		if b, ok := parseName(line[i+1:]); ok && a == b {
+			return a
+		}
+	}
+
+	return ""
+}
+
+// parseName returns the name of a file in a "---" or "+++" line, without its
+// first component. It returns false for /dev/null.
+func parseName(s string) (string, bool) {
+	if !strings.HasPrefix(s, `"`) {
+		// Traditional diffs may contain a timestamp after a tab.
+		if i := strings.IndexByte(s, '\t'); i >= 0 {
+			s = s[:i]
+		}
+	}
+
+	s = unquoteName(strings.TrimRight(s, " "))
+	if s == devNull {
+		return "", false
+	}
+
+	return stripComponent(s), true
+}
+
+// unquoteName unquotes names quoted by git, which uses C-style escapes.
+func unquoteName(s string) string {
+	if !strings.HasPrefix(s, `"`) {
+		return s
+	}
+
+	end := closingQuote(s)
+	if end < 0 {
+		return s
+	}
+
+	unquoted, err := strconv.Unquote(s[:end+1])
+	if err != nil {
+		return s
+	}
+
+	return unquoted
+}
+
+// closingQuote returns the position of the quote closing the quoted string
+// s starts with, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+
+	return -1
+}
+
+func stripComponent(s string) string {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		return s[i+1:]
+	}
+
+	return s
+}
+
+func parseMode(s string) (filemode.FileMode, error) {
+	return filemode.New(strings.TrimSpace(s))
+}
+
+// parseIndexLine parses the "<old>..<new> [<mode>]" part of an index line.
+func parseIndexLine(h *fileHeader, s string) error {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return fmt.Errorf("invalid index line %q", s)
+	}
+
+	hashes := strings.SplitN(fields[0], "..", 2)
+	if len(hashes) != 2 {
+		return fmt.Errorf("invalid index line %q", s)
+	}
+
+	h.oldHash, h.newHash = hashes[0], hashes[1]
+	if len(fields) > 1 {
+		mode, err := parseMode(fields[1])
+		if err != nil {
+			return err
+		}
+
+		h.oldMode, h.newMode = mode, mode
+	}
+
+	return nil
+}
+
+// parseRange parses the "<line>[,<count>]" ranges of the hunk headers.
+func parseRange(s string) (pos, lines int, err error) {
+	lines = 1
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		if lines, err = strconv.Atoi(s[i+1:]); err != nil {
+			return
+		}
+
+		s = s[:i]
+	}
+
+	pos, err = strconv.Atoi(s)
+	if err == nil && (pos < 0 || lines < 0) {
+		err = fmt.Errorf("invalid range %q", s)
+	}
+
+	return
+}
+
+func trimLineEnd(s string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+}
+
+const base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+var base85Values [256]byte
+
+func init() {
+	for i := range base85Values {
+		base85Values[i] = 0xff
+	}
+
+	for i := 0; i < len(base85Alphabet); i++ {
+		base85Values[base85Alphabet[i]] = byte(i)
+	}
+}
+
+// decodeBase85Line decodes a line of a binary hunk: a character giving the
+// length of the data, A-Z for 1-26 bytes and a-z for 27-52 bytes, followed by
+// the data encoded in base85, 4 bytes every 5 characters.
+func decodeBase85Line(line string) ([]byte, error) {
+	var n int
+	switch c := line[0]; {
+	case c >= 'A' && c <= 'Z':
+		n = int(c-'A') + 1
+	case c >= 'a' && c <= 'z':
+		n = int(c-'a') + 27
+	default:
+		return nil, fmt.Errorf("invalid binary hunk line length %q", c)
+	}
+
+	encoded := line[1:]
+	if len(encoded) != (n+3)/4*5 {
+		return nil, fmt.Errorf("invalid binary hunk line %q", line)
+	}
+
+	out := make([]byte, 0, len(encoded)/5*4)
+	for i := 0; i < len(encoded); i += 5 {
+		var acc uint64
+		for _, c := range []byte(encoded[i : i+5]) {
+			v := base85Values[c]
+			if v == 0xff {
+				return nil, fmt.Errorf("invalid base85 character %q", c)
+			}
+
+			acc = acc*85 + uint64(v)
+		}
+
+		if acc > 0xffffffff {
+			return nil, fmt.Errorf("invalid base85 sequence %q", encoded[i:i+5])
+		}
+
+		out = append(out, byte(acc>>24), byte(acc>>16), byte(acc>>8), byte(acc))
+	}
+
+	return out[:n], nil
+}
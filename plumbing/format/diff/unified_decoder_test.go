@@ -0,0 +1,308 @@
+package diff
+
+import (
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+
+	. "gopkg.in/check.v1"
+)
+
+type UnifiedDecoderTestSuite struct{}
+
+var _ = Suite(&UnifiedDecoderTestSuite{})
+
+// gitPatch was written by git diff --cached --binary -M.
+const gitPatch = `diff --git a/added b/added
+new file mode 100644
+index 0000000..3e75765
+--- /dev/null
++++ b/added
+@@ -0,0 +1 @@
++new
+diff --git a/bin b/bin
+index 81947756ffcb39de9f9a6a6925b5fff58dc7d738..d821f9a8b73c952e2225c5e065add7d2bd27fb54 100644
+GIT binary patch
+literal 15
+WcmZQzWJ=1+ODw8nNJ%V7Gy(t}t^` + "`" + `#8
+
+literal 14
+VcmZQzWJ=1+ODw8nNJ%V71OORX1S0?d
+
+diff --git a/deleted b/deleted
+deleted file mode 100644
+index b77b4eb..0000000
+--- a/deleted
++++ /dev/null
+@@ -1,2 +0,0 @@
+-x
+-y
+diff --git a/eof b/eof
+index 20cbb4d..0a05244 100644
+--- a/eof
++++ b/eof
+@@ -1 +1 @@
+-no newline
+\ No newline at end of file
++no newline either
+\ No newline at end of file
+diff --git a/renamed b/moved
+similarity index 80%
+rename from renamed
+rename to moved
+index 0ec1772..27d4162 100644
+--- a/renamed
++++ b/moved
+@@ -2,4 +2,4 @@ r1
+ r2
+ r3
+ r4
+-r5
++R5
+diff --git a/script b/script
+old mode 100644
+new mode 100755
+diff --git "a/sp ace \303\244.txt" "b/sp ace \303\244.txt"
+index bca70f3..73c52c3 100644
+--- "a/sp ace \303\244.txt"
++++ "b/sp ace \303\244.txt"
+@@ -1 +1 @@
+-q
++Q
+diff --git a/text b/text
+index 92dfa21..6093718 100644
+--- a/text
++++ b/text
+@@ -1,10 +1,11 @@
+ a
+-b
++B
+ c
+ d
+ e
+ f
+ g
+ h
+-i
++I
+ j
++k
+`
+
+func decodePatch(c *C, s string) *UnifiedPatch {
+	p, err := NewUnifiedDecoder(strings.NewReader(s)).Decode()
+	c.Assert(err, IsNil)
+	return p
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecode(c *C) {
+	p := decodePatch(c, gitPatch)
+	c.Assert(p.Message(), Equals, "")
+
+	fps := p.FilePatches()
+	c.Assert(fps, HasLen, 8)
+
+	type file struct {
+		path string
+		mode filemode.FileMode
+		hash string
+	}
+
+	expected := []struct {
+		from, to *file
+		binary   bool
+		chunks   int
+	}{
+		{nil, &file{"added", filemode.Regular, "3e75765"}, false, 1},
+		{
+			&file{"bin", filemode.Regular, "81947756ffcb39de9f9a6a6925b5fff58dc7d738"},
+			&file{"bin", filemode.Regular, "d821f9a8b73c952e2225c5e065add7d2bd27fb54"},
+			true, 0,
+		},
+		{&file{"deleted", filemode.Regular, "b77b4eb"}, nil, false, 1},
+		{&file{"eof", filemode.Regular, "20cbb4d"}, &file{"eof", filemode.Regular, "0a05244"}, false, 2},
+		{&file{"renamed", filemode.Regular, "0ec1772"}, &file{"moved", filemode.Regular, "27d4162"}, false, 3},
+		{&file{"script", filemode.Regular, ""}, &file{"script", filemode.Executable, ""}, false, 0},
+		{&file{"sp ace ä.txt", filemode.Regular, "bca70f3"}, &file{"sp ace ä.txt", filemode.Regular, "73c52c3"}, false, 2},
+		{&file{"text", filemode.Regular, "92dfa21"}, &file{"text", filemode.Regular, "6093718"}, false, 8},
+	}
+
+	for i, e := range expected {
+		fp := fps[i].(*UnifiedFilePatch)
+		from, to := fp.Files()
+		comment := Commentf("file patch %d", i)
+
+		c.Assert(fp.IsBinary(), Equals, e.binary, comment)
+		c.Assert(fp.IsCopy(), Equals, false, comment)
+		c.Assert(fp.Chunks(), HasLen, e.chunks, comment)
+
+		for _, f := range []struct {
+			got      File
+			expected *file
+		}{{from, e.from}, {to, e.to}} {
+			if f.expected == nil {
+				c.Assert(f.got, IsNil, comment)
+				continue
+			}
+
+			c.Assert(f.got.Path(), Equals, f.expected.path, comment)
+			c.Assert(f.got.Mode(), Equals, f.expected.mode, comment)
+			c.Assert(f.got.(*UnifiedFile).HashPrefix(), Equals, f.expected.hash, comment)
+		}
+	}
+
+	_, to := fps[1].Files()
+	c.Assert(to.Hash(), Equals, plumbing.NewHash("d821f9a8b73c952e2225c5e065add7d2bd27fb54"))
+	_, to = fps[0].Files()
+	c.Assert(to.Hash(), Equals, plumbing.ZeroHash)
+
+	bin := fps[1].(*UnifiedFilePatch)
+	c.Assert(bin.BinaryFragment().Method, Equals, BinaryPatchLiteral)
+	c.Assert(bin.BinaryFragment().Data, DeepEquals, []byte("\x00\x01\x02binary\x00data2"))
+	c.Assert(bin.ReverseBinaryFragment().Data, DeepEquals, []byte("\x00\x01\x02binary\x00data"))
+
+	eof := fps[3].(*UnifiedFilePatch).Fragments()
+	c.Assert(eof, HasLen, 1)
+	c.Assert(eof[0].Lines, DeepEquals, []FragmentLine{
+		{Op: Delete, Content: "no newline"},
+		{Op: Add, Content: "no newline either"},
+	})
+
+	moved := fps[4].(*UnifiedFilePatch).Fragments()
+	c.Assert(moved, HasLen, 1)
+	c.Assert(*moved[0], DeepEquals, Fragment{
+		OldPos: 2, OldLines: 4,
+		NewPos: 2, NewLines: 4,
+		Comment: "r1",
+		Lines: []FragmentLine{
+			{Op: Equal, Content: "r2\n"},
+			{Op: Equal, Content: "r3\n"},
+			{Op: Equal, Content: "r4\n"},
+			{Op: Delete, Content: "r5\n"},
+			{Op: Add, Content: "R5\n"},
+		},
+	})
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecodeTraditional(c *C) {
+	p := decodePatch(c, `Only in a: other
+diff -u a/file b/file
+--- a/file	2024-01-01 10:00:00.000000000 +0000
++++ b/file	2024-01-01 10:00:01.000000000 +0000
+@@ -1,3 +1,3 @@
+ a
+-b
+
++c
+--- /dev/null
++++ b/new
+@@ -0,0 +1 @@
++new
+`)
+
+	c.Assert(p.Message(), Equals, "Only in a: other\ndiff -u a/file b/file\n")
+
+	fps := p.FilePatches()
+	c.Assert(fps, HasLen, 2)
+
+	from, to := fps[0].Files()
+	c.Assert(from.Path(), Equals, "file")
+	c.Assert(to.Path(), Equals, "file")
+	c.Assert(from.Mode(), Equals, filemode.Empty)
+
+	// The empty line is a context line without its space.
+	c.Assert(fps[0].(*UnifiedFilePatch).Fragments()[0].Lines, DeepEquals, []FragmentLine{
+		{Op: Equal, Content: "a\n"},
+		{Op: Delete, Content: "b\n"},
+		{Op: Equal, Content: "\n"},
+		{Op: Add, Content: "c\n"},
+	})
+
+	from, to = fps[1].Files()
+	c.Assert(from, IsNil)
+	c.Assert(to.Path(), Equals, "new")
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecodeMessage(c *C) {
+	p := decodePatch(c, `Subject: fix
+
+Some text.
+---
+ file | 2 +-
+
+diff --git a/file b/file
+--- a/file
++++ b/file
+@@ -1 +1 @@
+-a
++b
+--
+2.39.5
+`)
+
+	c.Assert(p.Message(), Equals, "Subject: fix\n\nSome text.\n---\n file | 2 +-\n\n")
+	c.Assert(p.FilePatches(), HasLen, 1)
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecodeCopy(c *C) {
+	p := decodePatch(c, `diff --git a/a b/b
+similarity index 100%
+copy from a
+copy to b
+`)
+
+	fps := p.FilePatches()
+	c.Assert(fps, HasLen, 1)
+	c.Assert(fps[0].(*UnifiedFilePatch).IsCopy(), Equals, true)
+
+	from, to := fps[0].Files()
+	c.Assert(from.Path(), Equals, "a")
+	c.Assert(to.Path(), Equals, "b")
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecodeMalformed(c *C) {
+	for _, patch := range []string{
+		"--- a/file\n+++ b/file\n@@ -1,2 +1,2 @@\n a\n",
+		"--- a/file\n+++ b/file\n@@ -1 +1 @@\n-a\n-b\n+c\n",
+		"--- a/file\n+++ b/file\n@@ -1 +1 @@\n*a\n",
+		"--- a/file\n+++ b/file\n@@ -a +1 @@\n-a\n+b\n",
+		"diff --git a/file b/file\nGIT binary patch\nliteral 1\n!!!!!!\n\n",
+	} {
+		_, err := NewUnifiedDecoder(strings.NewReader(patch)).Decode()
+		c.Assert(err, ErrorMatches, "malformed patch: .*", Commentf("while decoding %q", patch))
+	}
+}
+
+func (s *UnifiedDecoderTestSuite) TestDecodeEncoded(c *C) {
+	for _, f := range fixtures {
+		if f.color != nil {
+			continue
+		}
+
+		p := decodePatch(c, f.diff)
+		c.Assert(p.FilePatches(), HasLen, len(f.patch.FilePatches()), Commentf("while decoding %s", f.desc))
+
+		for i, fp := range f.patch.FilePatches() {
+			decoded := p.FilePatches()[i]
+			if fp.IsBinary() {
+				continue
+			}
+
+			var src, dst strings.Builder
+			for _, chunk := range fp.Chunks() {
+				if chunk.Type() != Add {
+					src.WriteString(chunk.Content())
+				}
+
+				if chunk.Type() != Delete {
+					dst.WriteString(chunk.Content())
+				}
+			}
+
+			content, err := decoded.(*UnifiedFilePatch).Apply([]byte(src.String()))
+			c.Assert(err, IsNil, Commentf("while applying %s", f.desc))
+			c.Assert(string(content), Equals, dst.String(), Commentf("while applying %s", f.desc))
+		}
+	}
+}
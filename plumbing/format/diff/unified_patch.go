@@ -0,0 +1,195 @@
+package diff
+
+import (
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+)
+
+// UnifiedPatch is a Patch decoded by UnifiedDecoder.
+type UnifiedPatch struct {
+	message     string
+	filePatches []*UnifiedFilePatch
+}
+
+// FilePatches returns the patches of each file, all of them are
+// *UnifiedFilePatch.
+func (p *UnifiedPatch) FilePatches() []FilePatch {
+	fps := make([]FilePatch, 0, len(p.filePatches))
+	for _, fp := range p.filePatches {
+		fps = append(fps, fp)
+	}
+
+	return fps
+}
+
+// Message returns the text preceding the first file patch.
+func (p *UnifiedPatch) Message() string {
+	return p.message
+}
+
+// UnifiedFilePatch is a FilePatch decoded by UnifiedDecoder. Unlike the
+// patches of a diff between trees, it only contains the hunks of the diff,
+// not the whole content of the files.
+type UnifiedFilePatch struct {
+	from, to *UnifiedFile
+	isCopy   bool
+	isBinary bool
+
+	fragments     []*Fragment
+	binary        *BinaryFragment
+	reverseBinary *BinaryFragment
+}
+
+// IsBinary returns true if this patch is representing a binary file.
+func (p *UnifiedFilePatch) IsBinary() bool {
+	return p.isBinary
+}
+
+// Files returns the from and to Files. If the patch creates a new file, "from"
+// will be nil. If the patch deletes a file, "to" will be nil.
+func (p *UnifiedFilePatch) Files() (from, to File) {
+	if p.from != nil {
+		from = p.from
+	}
+
+	if p.to != nil {
+		to = p.to
+	}
+
+	return
+}
+
+// Chunks returns the lines of the hunks of the patch, the unchanged lines
+// between the hunks are not included.
+func (p *UnifiedFilePatch) Chunks() []Chunk {
+	var chunks []Chunk
+	var last *unifiedChunk
+	for _, f := range p.fragments {
+		for _, l := range f.Lines {
+			if last != nil && last.op == l.Op {
+				last.content.WriteString(l.Content)
+				continue
+			}
+
+			last = &unifiedChunk{op: l.Op}
+			last.content.WriteString(l.Content)
+			chunks = append(chunks, last)
+		}
+
+		// Hunks are not contiguous, so their lines are never merged.
+		last = nil
+	}
+
+	return chunks
+}
+
+// Fragments returns the hunks of a text patch.
+func (p *UnifiedFilePatch) Fragments() []*Fragment {
+	return p.fragments
+}
+
+// BinaryFragment returns the data of a binary patch, nil if the patch has no
+// data, such as the ones only stating "Binary files differ".
+func (p *UnifiedFilePatch) BinaryFragment() *BinaryFragment {
+	return p.binary
+}
+
+// ReverseBinaryFragment returns the data to revert a binary patch, nil if
+// the patch has none.
+func (p *UnifiedFilePatch) ReverseBinaryFragment() *BinaryFragment {
+	return p.reverseBinary
+}
+
+// IsCopy returns true if the "to" file is a copy of the "from" file, which is
+// kept, instead of a rename.
+func (p *UnifiedFilePatch) IsCopy() bool {
+	return p.isCopy
+}
+
+// UnifiedFile is a File of a UnifiedFilePatch.
+type UnifiedFile struct {
+	path string
+	mode filemode.FileMode
+	hash string
+}
+
+// Hash returns the hash of the file, or plumbing.ZeroHash if the patch does
+// not contain it or only contains an abbreviated hash.
+func (f *UnifiedFile) Hash() plumbing.Hash {
+	if !plumbing.IsHash(f.hash) {
+		return plumbing.ZeroHash
+	}
+
+	return plumbing.NewHash(f.hash)
+}
+
+// HashPrefix returns the hash of the file as written in the patch, usually
+// abbreviated, or an empty string if the patch does not contain it.
+func (f *UnifiedFile) HashPrefix() string {
+	return f.hash
+}
+
+// Mode returns the mode of the file, or filemode.Empty if the patch does not
+// contain it.
+func (f *UnifiedFile) Mode() filemode.FileMode {
+	return f.mode
+}
+
+// Path returns the path of the file, without the a/ or b/ prefixes.
+func (f *UnifiedFile) Path() string {
+	return f.path
+}
+
+// Fragment is a hunk of a text patch.
+type Fragment struct {
+	// OldPos and OldLines are the first line, starting at 1, and the number
+	// of lines of the hunk in the old file. NewPos and NewLines are the same
+	// for the new file.
+	OldPos, OldLines int
+	NewPos, NewLines int
+	// Comment is the text following the hunk header, usually the function
+	// enclosing the hunk.
+	Comment string
+	// Lines are the lines of the hunk, including their line feed unless
+	// they are the last line of a file without one.
+	Lines []FragmentLine
+}
+
+// FragmentLine is a line of a Fragment.
+type FragmentLine struct {
+	Op      Operation
+	Content string
+}
+
+// BinaryPatchMethod is the way a binary patch describes the new file.
+type BinaryPatchMethod int
+
+const (
+	// BinaryPatchLiteral patches contain the whole new file.
+	BinaryPatchLiteral BinaryPatchMethod = iota
+	// BinaryPatchDelta patches contain a delta, like the ones of the
+	// packfiles, from the old file to the new one.
+	BinaryPatchDelta
+)
+
+// BinaryFragment is the data of a binary patch.
+type BinaryFragment struct {
+	Method BinaryPatchMethod
+	// Data is the new file or the delta, already inflated.
+	Data []byte
+}
+
+type unifiedChunk struct {
+	content strings.Builder
+	op      Operation
+}
+
+func (c *unifiedChunk) Content() string {
+	return c.content.String()
+}
+
+func (c *unifiedChunk) Type() Operation {
+	return c.op
+}
@@ -0,0 +1,119 @@
+package midx
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+const (
+	// FilePath is the path of the multi-pack-index in a .git directory.
+	FilePath = "objects/pack/multi-pack-index"
+	// ChainDir is the directory of the layers of a multi-pack-index chain in
+	// a .git directory.
+	ChainDir = "objects/pack/multi-pack-index.d"
+)
+
+// ChainPath is the path of the multi-pack-index chain file in a .git
+// directory.
+var ChainPath = path.Join(ChainDir, "multi-pack-index-chain")
+
+// LayerPath returns the path of the layer of a multi-pack-index chain with
+// the given hash in a .git directory.
+func LayerPath(h plumbing.Hash) string {
+	return path.Join(ChainDir, fmt.Sprintf("multi-pack-index-%s.midx", h))
+}
+
+// DecodeChain reads a multi-pack-index chain file and returns the hashes of
+// its layers, oldest first.
+func DecodeChain(r io.Reader) ([]plumbing.Hash, error) {
+	var hashes []plumbing.Hash
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if !plumbing.IsHash(line) {
+			return nil, ErrMalformedMultiPackIndex
+		}
+
+		hashes = append(hashes, plumbing.NewHash(line))
+	}
+
+	return hashes, s.Err()
+}
+
+// EncodeChain writes a multi-pack-index chain file with the hashes of the
+// given layers, oldest first.
+func EncodeChain(w io.Writer, hashes []plumbing.Hash) error {
+	for _, h := range hashes {
+		if _, err := fmt.Fprintln(w, h); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open expects a billy.Filesystem representing a .git directory. It reads
+// its multi-pack-index, or the layers of its multi-pack-index chain, oldest
+// first, if there is no multi-pack-index file. If neither is present, the
+// error of opening the chain file is returned.
+func Open(fs billy.Filesystem) ([]*MultiPackIndex, error) {
+	m, err := openFile(fs, FilePath)
+	if err == nil {
+		return []*MultiPackIndex{m}, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := fs.Open(ChainPath)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes, err := DecodeChain(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make([]*MultiPackIndex, 0, len(hashes))
+	for _, h := range hashes {
+		m, err := openFile(fs, LayerPath(h))
+		if err != nil {
+			return nil, err
+		}
+
+		if m.Checksum != h {
+			return nil, ErrMalformedMultiPackIndex
+		}
+
+		layers = append(layers, m)
+	}
+
+	return layers, nil
+}
+
+func openFile(fs billy.Filesystem, name string) (m *MultiPackIndex, err error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	m = &MultiPackIndex{}
+	if err := NewDecoder(f).Decode(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,62 @@
+package midx
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MidxSuite) TestChain(c *C) {
+	hashes := []plumbing.Hash{
+		plumbing.NewHash("3b0405dadc6626cc90925d39ff16d259a0490ed4"),
+		plumbing.NewHash("6652dfbaadfe0880f797c5682bb71d9907696dfd"),
+	}
+
+	var buf bytes.Buffer
+	c.Assert(EncodeChain(&buf, hashes), IsNil)
+	c.Assert(buf.String(), Equals, "3b0405dadc6626cc90925d39ff16d259a0490ed4\n"+
+		"6652dfbaadfe0880f797c5682bb71d9907696dfd\n")
+
+	decoded, err := DecodeChain(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(decoded, DeepEquals, hashes)
+
+	_, err = DecodeChain(strings.NewReader("foo\n"))
+	c.Assert(err, Equals, ErrMalformedMultiPackIndex)
+}
+
+func (s *MidxSuite) TestOpen(c *C) {
+	m, data := s.decode(c)
+	fs := memfs.New()
+
+	_, err := Open(fs)
+	c.Assert(err, NotNil)
+
+	c.Assert(util.WriteFile(fs, FilePath, data, 0o444), IsNil)
+	layers, err := Open(fs)
+	c.Assert(err, IsNil)
+	c.Assert(layers, DeepEquals, []*MultiPackIndex{m})
+}
+
+func (s *MidxSuite) TestOpenChain(c *C) {
+	m, data := s.decode(c)
+	fs := memfs.New()
+
+	c.Assert(util.WriteFile(fs, LayerPath(m.Checksum), data, 0o444), IsNil)
+	c.Assert(util.WriteFile(fs, ChainPath, []byte(m.Checksum.String()+"\n"), 0o444), IsNil)
+	layers, err := Open(fs)
+	c.Assert(err, IsNil)
+	c.Assert(layers, DeepEquals, []*MultiPackIndex{m})
+
+	// A layer whose checksum is not the one of the chain.
+	other := plumbing.NewHash("6652dfbaadfe0880f797c5682bb71d9907696dfd")
+	c.Assert(util.WriteFile(fs, LayerPath(other), data, 0o444), IsNil)
+	c.Assert(util.WriteFile(fs, ChainPath, []byte(other.String()+"\n"), 0o444), IsNil)
+	_, err = Open(fs)
+	c.Assert(err, Equals, ErrMalformedMultiPackIndex)
+}
@@ -0,0 +1,56 @@
+// Package midx implements encoding and decoding of multi-pack-index files.
+//
+// A multi-pack-index, or MIDX, indexes the objects of several packs, so an
+// object is found with a single search instead of one per pack index. It is
+// stored in objects/pack/multi-pack-index, with the following format:
+//
+//   - A 4-byte signature: 'MIDX'.
+//
+//   - A 1-byte version number: 1.
+//
+//   - A 1-byte hash function identifier: 1 for SHA-1, 2 for SHA-256.
+//
+//   - The 1-byte number of chunks.
+//
+//   - The 1-byte number of base multi-pack-index files, always 0.
+//
+//   - The number of packs, as 4 bytes in network byte order.
+//
+//   - The chunk lookup table: one entry per chunk and a terminating entry
+//     with a zero identifier, each one made of a 4-byte chunk identifier and
+//     the 8-byte offset of the chunk in the file, in network byte order.
+//
+//   - The chunks:
+//
+//   - 'PNAM': the names of the index files of the packs, sorted, each one
+//     followed by a NUL byte, padded with NUL bytes to a multiple of 4
+//     bytes. The position of a pack in this list is its pack-int-id.
+//
+//   - 'OIDF': 256 4-byte numbers, the i-th one being the number of objects
+//     whose hash starts with a byte lower or equal to i.
+//
+//   - 'OIDL': the hashes of the objects, sorted.
+//
+//   - 'OOFF': for each object, the 4-byte pack-int-id of the pack chosen
+//     for it and its 4-byte offset in this pack. If the most significant bit
+//     of the offset is set and there is a 'LOFF' chunk, the other bits are
+//     the position of the offset in it.
+//
+//   - 'LOFF', optional: the 8-byte offsets which do not fit in 31 bits.
+//
+//   - 'RIDX', optional: the positions of the objects in the 'OIDL' chunk, as
+//     4-byte numbers, sorted in pseudo-pack order: the objects of the
+//     preferred pack first, then the other ones by pack-int-id and offset.
+//
+//   - The checksum of all the above.
+//
+// Unknown chunks are ignored.
+//
+// A multi-pack-index may also be split into layers, each one indexing other
+// packs than the previous ones. The hashes of the layers, oldest first, are
+// listed one per line in objects/pack/multi-pack-index.d/multi-pack-index-chain,
+// and each layer is stored in
+// objects/pack/multi-pack-index.d/multi-pack-index-<hash>.midx.
+//
+// See https://git-scm.com/docs/gitformat-pack#_multi_pack_index_midx_files_have_the_following_format
+package midx
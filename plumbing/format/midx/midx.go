@@ -0,0 +1,412 @@
+package midx
+
+import (
+	"bytes"
+	encbin "encoding/binary"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/hash"
+	"github.com/jesseduffield/go-git/v5/utils/binary"
+)
+
+const (
+	version      = 1
+	headerSize   = 12
+	chunkEntry   = 12
+	fanoutSize   = 256
+	largeOffset  = 1 << 31
+	chunkAlign   = 4
+	maxOffset32  = largeOffset - 1
+	offsetSize   = 8
+	revIndexSize = 4
+)
+
+var (
+	signature = []byte{'M', 'I', 'D', 'X'}
+
+	chunkPackNames    = [4]byte{'P', 'N', 'A', 'M'}
+	chunkFanout       = [4]byte{'O', 'I', 'D', 'F'}
+	chunkLookup       = [4]byte{'O', 'I', 'D', 'L'}
+	chunkOffsets      = [4]byte{'O', 'O', 'F', 'F'}
+	chunkLargeOffsets = [4]byte{'L', 'O', 'F', 'F'}
+	chunkReverseIndex = [4]byte{'R', 'I', 'D', 'X'}
+
+	// ErrMalformedMultiPackIndex is returned when the multi-pack-index is
+	// not valid.
+	ErrMalformedMultiPackIndex = errors.New("malformed multi-pack-index")
+	// ErrUnsupportedVersion is returned when the version or the hash
+	// function of the multi-pack-index is not supported.
+	ErrUnsupportedVersion = errors.New("unsupported multi-pack-index version")
+)
+
+// MultiPackIndex is the index of the objects of several packs.
+type MultiPackIndex struct {
+	// Checksum is the checksum of the file. It is set when decoding.
+	Checksum plumbing.Hash
+	// PackNames are the names of the index files of the packs, such as
+	// pack-<hash>.idx, sorted.
+	PackNames []string
+	// Hashes are the hashes of the objects, sorted.
+	Hashes []plumbing.Hash
+	// PackIDs are the positions in PackNames of the packs of the objects.
+	PackIDs []uint32
+	// Offsets are the offsets of the objects in their packs.
+	Offsets []uint64
+	// ReverseIndex are the positions of the objects in Hashes, in
+	// pseudo-pack order. It may be nil.
+	ReverseIndex []uint32
+}
+
+// Find returns the position of the object in Hashes.
+func (m *MultiPackIndex) Find(h plumbing.Hash) (int, bool) {
+	i := sort.Search(len(m.Hashes), func(i int) bool {
+		return bytes.Compare(m.Hashes[i][:], h[:]) >= 0
+	})
+
+	return i, i < len(m.Hashes) && m.Hashes[i] == h
+}
+
+// PackHash returns the hash of the pack with the given pack-int-id, from its
+// name.
+func (m *MultiPackIndex) PackHash(id uint32) (plumbing.Hash, bool) {
+	if int(id) >= len(m.PackNames) {
+		return plumbing.ZeroHash, false
+	}
+
+	name := m.PackNames[id]
+	if len(name) != len("pack-.idx")+hash.HexSize ||
+		name[:5] != "pack-" || name[len(name)-4:] != ".idx" {
+		return plumbing.ZeroHash, false
+	}
+
+	s := name[5 : len(name)-4]
+	if !plumbing.IsHash(s) {
+		return plumbing.ZeroHash, false
+	}
+
+	return plumbing.NewHash(s), true
+}
+
+// Encoder writes MultiPackIndex to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w}
+}
+
+type chunk struct {
+	id   [4]byte
+	data []byte
+}
+
+// Encode writes m to the output stream.
+func (e *Encoder) Encode(m *MultiPackIndex) error {
+	if len(m.PackIDs) != len(m.Hashes) || len(m.Offsets) != len(m.Hashes) ||
+		m.ReverseIndex != nil && len(m.ReverseIndex) != len(m.Hashes) {
+		return ErrMalformedMultiPackIndex
+	}
+
+	chunks := []chunk{
+		{chunkPackNames, encodePackNames(m.PackNames)},
+		{chunkFanout, encodeFanout(m.Hashes)},
+		{chunkLookup, encodeHashes(m.Hashes)},
+	}
+
+	offsets, large := encodeOffsets(m)
+	chunks = append(chunks, chunk{chunkOffsets, offsets})
+	if large != nil {
+		chunks = append(chunks, chunk{chunkLargeOffsets, large})
+	}
+
+	if m.ReverseIndex != nil {
+		var buf bytes.Buffer
+		for _, pos := range m.ReverseIndex {
+			_ = binary.WriteUint32(&buf, pos)
+		}
+
+		chunks = append(chunks, chunk{chunkReverseIndex, buf.Bytes()})
+	}
+
+	h := hash.New(hash.CryptoType)
+	w := io.MultiWriter(e.w, h)
+
+	if _, err := w.Write(signature); err != nil {
+		return err
+	}
+
+	header := []byte{version, hashFunction(), byte(len(chunks)), 0}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	if err := binary.WriteUint32(w, uint32(len(m.PackNames))); err != nil {
+		return err
+	}
+
+	offset := uint64(headerSize + (len(chunks)+1)*chunkEntry)
+	for _, c := range append(chunks, chunk{}) {
+		if _, err := w.Write(c.id[:]); err != nil {
+			return err
+		}
+
+		if err := binary.WriteUint64(w, offset); err != nil {
+			return err
+		}
+
+		offset += uint64(len(c.data))
+	}
+
+	for _, c := range chunks {
+		if _, err := w.Write(c.data); err != nil {
+			return err
+		}
+	}
+
+	_, err := e.w.Write(h.Sum(nil))
+	return err
+}
+
+func encodePackNames(names []string) []byte {
+	var buf bytes.Buffer
+	for _, n := range names {
+		buf.WriteString(n)
+		buf.WriteByte(0)
+	}
+
+	for buf.Len()%chunkAlign != 0 {
+		buf.WriteByte(0)
+	}
+
+	return buf.Bytes()
+}
+
+func encodeFanout(hashes []plumbing.Hash) []byte {
+	var fanout [fanoutSize]uint32
+	for _, h := range hashes {
+		fanout[h[0]]++
+	}
+
+	var buf bytes.Buffer
+	var n uint32
+	for _, c := range fanout {
+		n += c
+		_ = binary.WriteUint32(&buf, n)
+	}
+
+	return buf.Bytes()
+}
+
+func encodeHashes(hashes []plumbing.Hash) []byte {
+	buf := make([]byte, 0, len(hashes)*hash.Size)
+	for _, h := range hashes {
+		buf = append(buf, h[:]...)
+	}
+
+	return buf
+}
+
+// encodeOffsets returns the OOFF chunk, and the LOFF chunk if any offset
+// does not fit in 31 bits.
+func encodeOffsets(m *MultiPackIndex) ([]byte, []byte) {
+	needLarge := false
+	for _, o := range m.Offsets {
+		if o > maxOffset32 {
+			needLarge = true
+			break
+		}
+	}
+
+	var buf, large bytes.Buffer
+	for i, o := range m.Offsets {
+		_ = binary.WriteUint32(&buf, m.PackIDs[i])
+		if needLarge && o > maxOffset32 {
+			_ = binary.WriteUint32(&buf, largeOffset|uint32(large.Len()/offsetSize))
+			_ = binary.WriteUint64(&large, o)
+			continue
+		}
+
+		_ = binary.WriteUint32(&buf, uint32(o))
+	}
+
+	if !needLarge {
+		return buf.Bytes(), nil
+	}
+
+	return buf.Bytes(), large.Bytes()
+}
+
+// Decoder reads MultiPackIndex from an input stream.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a new decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r}
+}
+
+// Decode reads the whole input stream into m, checking its checksum.
+func (d *Decoder) Decode(m *MultiPackIndex) error {
+	data, err := io.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < headerSize+chunkEntry+hash.Size ||
+		!bytes.Equal(data[:len(signature)], signature) {
+		return ErrMalformedMultiPackIndex
+	}
+
+	if data[4] != version || data[5] != hashFunction() {
+		return ErrUnsupportedVersion
+	}
+
+	body := data[:len(data)-hash.Size]
+	h := hash.New(hash.CryptoType)
+	h.Write(body)
+	if !bytes.Equal(h.Sum(nil), data[len(body):]) {
+		return ErrMalformedMultiPackIndex
+	}
+
+	chunks, err := readChunks(body, int(data[6]))
+	if err != nil {
+		return err
+	}
+
+	packs := int(encbin.BigEndian.Uint32(data[8:]))
+	if err := m.decodePackNames(chunks[chunkPackNames], packs); err != nil {
+		return err
+	}
+
+	if err := m.decodeHashes(chunks[chunkFanout], chunks[chunkLookup]); err != nil {
+		return err
+	}
+
+	if err := m.decodeOffsets(chunks[chunkOffsets], chunks[chunkLargeOffsets]); err != nil {
+		return err
+	}
+
+	m.ReverseIndex = nil
+	if ridx, ok := chunks[chunkReverseIndex]; ok {
+		if len(ridx) != len(m.Hashes)*revIndexSize {
+			return ErrMalformedMultiPackIndex
+		}
+
+		m.ReverseIndex = make([]uint32, len(m.Hashes))
+		for i := range m.ReverseIndex {
+			m.ReverseIndex[i] = encbin.BigEndian.Uint32(ridx[i*revIndexSize:])
+			if int(m.ReverseIndex[i]) >= len(m.Hashes) {
+				return ErrMalformedMultiPackIndex
+			}
+		}
+	}
+
+	copy(m.Checksum[:], data[len(body):])
+	return nil
+}
+
+// readChunks returns the data of the chunks of the file, by identifier.
+func readChunks(body []byte, n int) (map[[4]byte][]byte, error) {
+	if len(body) < headerSize+(n+1)*chunkEntry {
+		return nil, ErrMalformedMultiPackIndex
+	}
+
+	chunks := make(map[[4]byte][]byte, n)
+	for i := 0; i < n; i++ {
+		entry := body[headerSize+i*chunkEntry:]
+		start, end := encbin.BigEndian.Uint64(entry[4:]), encbin.BigEndian.Uint64(entry[4+chunkEntry:])
+		if start > end || end > uint64(len(body)) {
+			return nil, ErrMalformedMultiPackIndex
+		}
+
+		var id [4]byte
+		copy(id[:], entry)
+		chunks[id] = body[start:end]
+	}
+
+	for _, id := range [][4]byte{chunkPackNames, chunkFanout, chunkLookup, chunkOffsets} {
+		if _, ok := chunks[id]; !ok {
+			return nil, ErrMalformedMultiPackIndex
+		}
+	}
+
+	return chunks, nil
+}
+
+func (m *MultiPackIndex) decodePackNames(data []byte, n int) error {
+	m.PackNames = make([]string, 0, n)
+	for len(m.PackNames) < n {
+		i := bytes.IndexByte(data, 0)
+		if i <= 0 {
+			return ErrMalformedMultiPackIndex
+		}
+
+		m.PackNames = append(m.PackNames, string(data[:i]))
+		data = data[i+1:]
+	}
+
+	return nil
+}
+
+func (m *MultiPackIndex) decodeHashes(fanout, lookup []byte) error {
+	if len(fanout) != fanoutSize*4 {
+		return ErrMalformedMultiPackIndex
+	}
+
+	n := int(encbin.BigEndian.Uint32(fanout[(fanoutSize-1)*4:]))
+	if len(lookup) != n*hash.Size {
+		return ErrMalformedMultiPackIndex
+	}
+
+	m.Hashes = make([]plumbing.Hash, n)
+	for i := range m.Hashes {
+		copy(m.Hashes[i][:], lookup[i*hash.Size:])
+	}
+
+	return nil
+}
+
+func (m *MultiPackIndex) decodeOffsets(offsets, large []byte) error {
+	if len(offsets) != len(m.Hashes)*offsetSize || len(large)%offsetSize != 0 {
+		return ErrMalformedMultiPackIndex
+	}
+
+	m.PackIDs = make([]uint32, len(m.Hashes))
+	m.Offsets = make([]uint64, len(m.Hashes))
+	for i := range m.Hashes {
+		m.PackIDs[i] = encbin.BigEndian.Uint32(offsets[i*offsetSize:])
+		if int(m.PackIDs[i]) >= len(m.PackNames) {
+			return ErrMalformedMultiPackIndex
+		}
+
+		o := encbin.BigEndian.Uint32(offsets[i*offsetSize+4:])
+		if large == nil || o&largeOffset == 0 {
+			m.Offsets[i] = uint64(o)
+			continue
+		}
+
+		pos := int(o&^largeOffset) * offsetSize
+		if pos >= len(large) {
+			return ErrMalformedMultiPackIndex
+		}
+
+		m.Offsets[i] = encbin.BigEndian.Uint64(large[pos:])
+	}
+
+	return nil
+}
+
+// hashFunction returns the identifier of the hash function in use.
+func hashFunction() byte {
+	if hash.Size == 32 {
+		return 2
+	}
+
+	return 1
+}
@@ -0,0 +1,116 @@
+package midx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MidxSuite struct{}
+
+var _ = Suite(&MidxSuite{})
+
+// gitMidx was written by git multi-pack-index write --bitmap, for two packs
+// of three objects each.
+const gitMidx = "TUlEWAEBBQAAAAACUE5BTQAAAAAAAABUT0lERgAAAAAAAAC4T0lETAAAAAAAAAS4T09GRgAAAAAAAAUwUklEWAAAAAAAAAVgAAAAAAAAAAAAAAV4cGFjay0yM2JhZjNjYmVkYTdlZGRhNjk5MmFhZGZkMDFjMGEyMjhiNTA5ZTY0LmlkeABwYWNrLTczNmZiNzU2ZWRkMDdhYmI4Njg5MWNjOWVkNjI1ZTlkZWMzZjdiMTQuaWR4AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAACAAAAAgAAAAIAAAACAAAAAgAAAAMAAAADAAAAAwAAAAMAAAADAAAAAwAAAAMAAAADAAAAAwAAAAMAAAADAAAAAwAAAAMAAAADAAAAAwAAAAMAAAADAAAAAwAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABAAAAAQAAAAEAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABQAAAAUAAAAFAAAABgAAAAYAAAAGAAAABgAAAAYAAAAGAAAABgAAAAYAAAAGAAAABgAAAAYAAAAGAAAABgAAAAYAAAAGAAAABgAAAAYAAAAGAAAABgAAAAYAAAAGAAAABgAAAAYAAAAGAAAABgAAAAYAAAAGAAAABgAAAAYAAAAGAAAABgAAAAYAAAAGAAAABgAAAAY2g/hwvkRsfMBf+u+foGQVJ24YKGF4B5gijRevLTT85M+981VWgyRyZlLfuq3+CID3l8VoK7cdmQdpbf14mBkiYTsq+2AlBC/2vYeKwZlOhar/dJhMzNFWpGmvp9mrEOR3e+sk3Wbckv5/YVvo0Q9i6WZTRUlUAAsAAAABAAAAlAAAAAEAAACJAAAAAAAAAAwAAAAAAAAAaQAAAAAAAAB0AAAAAQAAAAwAAAACAAAAAwAAAAQAAAAFAAAAAQAAAAA7BAXa3GYmzJCSXTn/FtJZoEkO1A=="
+
+func (s *MidxSuite) decode(c *C) (*MultiPackIndex, []byte) {
+	data, err := base64.StdEncoding.DecodeString(gitMidx)
+	c.Assert(err, IsNil)
+
+	m := &MultiPackIndex{}
+	err = NewDecoder(bytes.NewReader(data)).Decode(m)
+	c.Assert(err, IsNil)
+	return m, data
+}
+
+func (s *MidxSuite) TestDecodeEncode(c *C) {
+	m, data := s.decode(c)
+	c.Assert(m.Checksum, Equals, plumbing.NewHash("3b0405dadc6626cc90925d39ff16d259a0490ed4"))
+	c.Assert(m.PackNames, DeepEquals, []string{
+		"pack-23baf3cbeda7edda6992aadfd01c0a228b509e64.idx",
+		"pack-736fb756edd07abb86891cc9ed625e9dec3f7b14.idx",
+	})
+	c.Assert(m.Hashes, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("3683f870be446c7cc05ffaef9fa06415276e1828"),
+		plumbing.NewHash("61780798228d17af2d34fce4cfbdf35556832472"),
+		plumbing.NewHash("6652dfbaadfe0880f797c5682bb71d9907696dfd"),
+		plumbing.NewHash("78981922613b2afb6025042ff6bd878ac1994e85"),
+		plumbing.NewHash("aaff74984cccd156a469afa7d9ab10e4777beb24"),
+		plumbing.NewHash("dd66dc92fe7f615be8d10f62e96653454954000b"),
+	})
+	c.Assert(m.PackIDs, DeepEquals, []uint32{1, 1, 0, 0, 0, 1})
+	c.Assert(m.Offsets, DeepEquals, []uint64{148, 137, 12, 105, 116, 12})
+	c.Assert(m.ReverseIndex, DeepEquals, []uint32{2, 3, 4, 5, 1, 0})
+
+	var buf bytes.Buffer
+	err := NewEncoder(&buf).Encode(m)
+	c.Assert(err, IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, data)
+}
+
+func (s *MidxSuite) TestFind(c *C) {
+	m, _ := s.decode(c)
+
+	i, ok := m.Find(plumbing.NewHash("78981922613b2afb6025042ff6bd878ac1994e85"))
+	c.Assert(ok, Equals, true)
+	c.Assert(i, Equals, 3)
+
+	_, ok = m.Find(plumbing.NewHash("78981922613b2afb6025042ff6bd878ac1994e86"))
+	c.Assert(ok, Equals, false)
+	_, ok = m.Find(plumbing.NewHash("ffffffffffffffffffffffffffffffffffffffff"))
+	c.Assert(ok, Equals, false)
+
+	h, ok := m.PackHash(1)
+	c.Assert(ok, Equals, true)
+	c.Assert(h, Equals, plumbing.NewHash("736fb756edd07abb86891cc9ed625e9dec3f7b14"))
+
+	_, ok = m.PackHash(2)
+	c.Assert(ok, Equals, false)
+}
+
+func (s *MidxSuite) TestLargeOffsets(c *C) {
+	m := &MultiPackIndex{
+		PackNames: []string{"pack-23baf3cbeda7edda6992aadfd01c0a228b509e64.idx"},
+		Hashes: []plumbing.Hash{
+			plumbing.NewHash("3683f870be446c7cc05ffaef9fa06415276e1828"),
+			plumbing.NewHash("61780798228d17af2d34fce4cfbdf35556832472"),
+			plumbing.NewHash("6652dfbaadfe0880f797c5682bb71d9907696dfd"),
+		},
+		PackIDs: []uint32{0, 0, 0},
+		Offsets: []uint64{12, 1 << 33, 1<<31 + 5},
+	}
+
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(m), IsNil)
+	c.Assert(bytes.Contains(buf.Bytes(), []byte("LOFF")), Equals, true)
+
+	decoded := &MultiPackIndex{}
+	c.Assert(NewDecoder(&buf).Decode(decoded), IsNil)
+	c.Assert(decoded.Offsets, DeepEquals, m.Offsets)
+	c.Assert(decoded.ReverseIndex, IsNil)
+}
+
+func (s *MidxSuite) TestDecodeMalformed(c *C) {
+	_, data := s.decode(c)
+
+	for _, d := range [][]byte{
+		data[:20],
+		append([]byte("XIDX"), data[4:]...),
+		append(bytes.Clone(data[:len(data)-1]), 0),
+	} {
+		err := NewDecoder(bytes.NewReader(d)).Decode(&MultiPackIndex{})
+		c.Assert(err, Equals, ErrMalformedMultiPackIndex)
+	}
+
+	d := bytes.Clone(data)
+	d[4] = 2
+	err := NewDecoder(bytes.NewReader(d)).Decode(&MultiPackIndex{})
+	c.Assert(err, Equals, ErrUnsupportedVersion)
+}
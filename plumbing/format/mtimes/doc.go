@@ -0,0 +1,22 @@
+// Package mtimes implements encoding and decoding of the .mtimes files of
+// cruft packs.
+//
+// A cruft pack holds unreachable objects which are not old enough to be
+// pruned. As packing them loses the modification times of their loose
+// files, the time of each object is kept in a .mtimes file next to the pack,
+// with the following format:
+//
+//   - A 4-byte signature: 'MTME'.
+//
+//   - A 4-byte version number, in network byte order: 1.
+//
+//   - A 4-byte hash function identifier: 1 for SHA-1, 2 for SHA-256.
+//
+//   - The modification times of the objects, as 4-byte unsigned numbers of
+//     seconds since the epoch in network byte order, in the order of the
+//     objects in the pack index, that is sorted by hash.
+//
+//   - The checksum of the pack, followed by the checksum of all the above.
+//
+// See https://git-scm.com/docs/gitformat-pack#_cruft_packs
+package mtimes
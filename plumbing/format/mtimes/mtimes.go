@@ -0,0 +1,134 @@
+package mtimes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/hash"
+	"github.com/jesseduffield/go-git/v5/utils/binary"
+)
+
+const version = 1
+
+var (
+	signature = []byte{'M', 'T', 'M', 'E'}
+
+	// ErrMalformedMTimes is returned when the .mtimes file is not valid.
+	ErrMalformedMTimes = errors.New("malformed mtimes file")
+)
+
+// MTimes holds the modification times of the objects of a cruft pack.
+type MTimes struct {
+	// PackChecksum is the checksum of the pack.
+	PackChecksum plumbing.Hash
+	// Times are the modification times of the objects, in seconds since the
+	// epoch, in the order of their hashes.
+	Times []uint32
+}
+
+// Encoder writes MTimes to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w}
+}
+
+// Encode writes m to the output stream.
+func (e *Encoder) Encode(m *MTimes) error {
+	h := hash.New(hash.CryptoType)
+	w := io.MultiWriter(e.w, h)
+
+	if _, err := w.Write(signature); err != nil {
+		return err
+	}
+
+	if err := binary.WriteUint32(w, version); err != nil {
+		return err
+	}
+
+	if err := binary.WriteUint32(w, hashFunction()); err != nil {
+		return err
+	}
+
+	for _, t := range m.Times {
+		if err := binary.WriteUint32(w, t); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.Write(m.PackChecksum[:]); err != nil {
+		return err
+	}
+
+	_, err := e.w.Write(h.Sum(nil))
+	return err
+}
+
+// Decoder reads MTimes from an input stream.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a new decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r}
+}
+
+// Decode reads the whole input stream into m, checking its checksum.
+func (d *Decoder) Decode(m *MTimes) error {
+	data, err := io.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	header := len(signature) + 8
+	trailer := 2 * hash.Size
+	if len(data) < header+trailer || (len(data)-header-trailer)%4 != 0 {
+		return ErrMalformedMTimes
+	}
+
+	if !bytes.Equal(data[:len(signature)], signature) {
+		return ErrMalformedMTimes
+	}
+
+	r := bytes.NewReader(data[len(signature):header])
+	v, err := binary.ReadUint32(r)
+	if err != nil || v != version {
+		return ErrMalformedMTimes
+	}
+
+	if f, err := binary.ReadUint32(r); err != nil || f != hashFunction() {
+		return ErrMalformedMTimes
+	}
+
+	h := hash.New(hash.CryptoType)
+	h.Write(data[:len(data)-hash.Size])
+	if !bytes.Equal(h.Sum(nil), data[len(data)-hash.Size:]) {
+		return ErrMalformedMTimes
+	}
+
+	r = bytes.NewReader(data[header : len(data)-trailer])
+	m.Times = make([]uint32, r.Len()/4)
+	for i := range m.Times {
+		if m.Times[i], err = binary.ReadUint32(r); err != nil {
+			return err
+		}
+	}
+
+	copy(m.PackChecksum[:], data[len(data)-trailer:])
+	return nil
+}
+
+// hashFunction returns the identifier of the hash function in use.
+func hashFunction() uint32 {
+	if hash.Size == 32 {
+		return 2
+	}
+
+	return 1
+}
@@ -0,0 +1,52 @@
+package mtimes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MTimesSuite struct{}
+
+var _ = Suite(&MTimesSuite{})
+
+// gitMTimes was written by git gc --cruft, for a pack with a single object.
+const gitMTimes = "TVRNRQAAAAEAAAABarE7gCF+c2YSc7hXpvlmxim1+irFL9GNJyZJzcCj2/elujwAG6tikpT2Sow="
+
+func (s *MTimesSuite) TestDecodeEncode(c *C) {
+	data, err := base64.StdEncoding.DecodeString(gitMTimes)
+	c.Assert(err, IsNil)
+
+	var m MTimes
+	err = NewDecoder(bytes.NewReader(data)).Decode(&m)
+	c.Assert(err, IsNil)
+	c.Assert(m.Times, DeepEquals, []uint32{1790000000})
+	c.Assert(m.PackChecksum, Equals, plumbing.NewHash("217e73661273b857a6f966c629b5fa2ac52fd18d"))
+
+	var buf bytes.Buffer
+	err = NewEncoder(&buf).Encode(&m)
+	c.Assert(err, IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, data)
+}
+
+func (s *MTimesSuite) TestDecodeMalformed(c *C) {
+	data, err := base64.StdEncoding.DecodeString(gitMTimes)
+	c.Assert(err, IsNil)
+
+	for _, d := range [][]byte{
+		data[:20],
+		append([]byte("XTME"), data[4:]...),
+		append(bytes.Clone(data[:len(data)-1]), 0),
+		append(bytes.Clone(data[:12]), data[16:]...),
+	} {
+		var m MTimes
+		err := NewDecoder(bytes.NewReader(d)).Decode(&m)
+		c.Assert(err, Equals, ErrMalformedMTimes)
+	}
+}
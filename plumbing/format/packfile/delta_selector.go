@@ -22,10 +22,14 @@ var applyDelta = map[plumbing.ObjectType]bool{
 
 type deltaSelector struct {
 	storer storer.EncodedObjectStorer
+	// depth is the maximum length of the delta chains.
+	depth int64
+	// windowMemory limits the size of the objects in the window, if not 0.
+	windowMemory int64
 }
 
 func newDeltaSelector(s storer.EncodedObjectStorer) *deltaSelector {
-	return &deltaSelector{s}
+	return &deltaSelector{storer: s, depth: maxDepth}
 }
 
 // ObjectsToPack creates a list of ObjectToPack from the hashes
@@ -81,6 +85,10 @@ func (dw *deltaSelector) ObjectsToPack(
 		return nil, err
 	}
 
+	if err := dw.breakLongChains(otp); err != nil {
+		return nil, err
+	}
+
 	return otp, nil
 }
 
@@ -178,10 +186,51 @@ func (dw *deltaSelector) fixAndBreakChainsOne(objectsToPack map[plumbing.Hash]*O
 		return err
 	}
 
+	// The chain would be too long, so we break it here.
+	if int64(base.Depth) >= dw.depth {
+		return dw.undeltify(otp)
+	}
+
 	otp.SetDelta(base, otp.Object)
 	return nil
 }
 
+// breakLongChains undeltifies the objects whose delta chain is too long. It
+// happens when the base of a reused delta is deltified by the walk.
+func (dw *deltaSelector) breakLongChains(objectsToPack []*ObjectToPack) error {
+	done := make(map[*ObjectToPack]bool, len(objectsToPack))
+	for _, otp := range objectsToPack {
+		if err := dw.breakLongChain(otp, done); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (dw *deltaSelector) breakLongChain(otp *ObjectToPack, done map[*ObjectToPack]bool) error {
+	if done[otp] || !otp.IsDelta() {
+		return nil
+	}
+
+	done[otp] = true
+	if err := dw.breakLongChain(otp.Base, done); err != nil {
+		return err
+	}
+
+	otp.Depth = otp.Base.Depth + 1
+	if int64(otp.Depth) <= dw.depth {
+		return nil
+	}
+
+	if err := dw.restoreOriginal(otp); err != nil {
+		return err
+	}
+
+	otp.BackToOriginal()
+	return nil
+}
+
 func (dw *deltaSelector) restoreOriginal(otp *ObjectToPack) error {
 	if otp.Original != nil {
 		return nil
@@ -222,6 +271,10 @@ func (dw *deltaSelector) walk(
 	packWindow uint,
 ) error {
 	indexMap := make(map[plumbing.Hash]*deltaIndex)
+	// The window starts at the object first, and the size of its objects is
+	// windowSize.
+	var first int
+	var windowSize int64
 	for i := 0; i < len(objectsToPack); i++ {
 		// Clean up the index map and reconstructed delta objects for anything
 		// outside our pack window, to save memory.
@@ -236,6 +289,16 @@ func (dw *deltaSelector) walk(
 			}
 		}
 
+		// Drop the first objects of the window while it is too big, keeping
+		// at least one.
+		if i > 0 && dw.windowMemory > 0 {
+			windowSize += objectsToPack[i-1].Size()
+			for windowSize > dw.windowMemory && first < i-1 {
+				windowSize -= objectsToPack[first].Size()
+				first++
+			}
+		}
+
 		target := objectsToPack[i]
 
 		// If we already have a delta, we don't try to find a new one for this
@@ -250,7 +313,7 @@ func (dw *deltaSelector) walk(
 			continue
 		}
 
-		for j := i - 1; j >= 0 && i-j < int(packWindow); j-- {
+		for j := i - 1; j >= first && i-j < int(packWindow); j-- {
 			base := objectsToPack[j]
 			// Objects must use only the same type as their delta base.
 			// Since objectsToPack is sorted by type and size, once we find
@@ -329,7 +392,7 @@ func (dw *deltaSelector) deltaSizeLimit(targetSize int64, baseDepth int,
 		// Evenly distribute delta size limits over allowed depth.
 		// If src is non-delta (depth = 0), delta <= 50% of original.
 		// If src is almost at limit (9/10), delta <= 10% of original.
-		return n * (maxDepth - int64(baseDepth)) / maxDepth
+		return n * (dw.depth - int64(baseDepth)) / dw.depth
 	}
 
 	// With a delta base chosen any new delta must be "better".
@@ -337,8 +400,9 @@ func (dw *deltaSelector) deltaSizeLimit(targetSize int64, baseDepth int,
 	d := int64(targetDepth)
 	n := targetSize
 
-	// If target depth is bigger than maxDepth, this delta is not suitable to be used.
-	if d >= maxDepth {
+	// If target depth is bigger than the maximum depth, this delta is not
+	// suitable to be used.
+	if d >= dw.depth {
 		return 0
 	}
 
@@ -347,7 +411,7 @@ func (dw *deltaSelector) deltaSizeLimit(targetSize int64, baseDepth int,
 	//
 	// If src is near limit (depth=9/10) and base is whole (depth=0)
 	// a new delta dependent on src must be 1/10th the size.
-	return n * (maxDepth - int64(baseDepth)) / (maxDepth - d)
+	return n * (dw.depth - int64(baseDepth)) / (dw.depth - d)
 }
 
 type byTypeAndSize []*ObjectToPack
@@ -21,24 +21,55 @@ type Encoder struct {
 	hasher   plumbing.Hasher
 
 	useRefDeltas bool
+	reusePacks   []*Packfile
+}
+
+// EncoderOptions configures an Encoder.
+type EncoderOptions struct {
+	// UseRefDeltas writes reference deltas instead of offset deltas.
+	UseRefDeltas bool
+	// Depth is the maximum length of the delta chains. If 0, 50 is used.
+	Depth uint
+	// WindowMemory limits the size of the objects in the sliding window for
+	// delta compression, in bytes. If 0, there is no limit.
+	WindowMemory uint64
+	// ReusePacks are packs whose entries are copied as they are into the
+	// new packfile, without being decompressed. A delta is reused when its
+	// base is copied too, the objects which are not reused are compressed
+	// again and searched for deltas.
+	ReusePacks []*Packfile
 }
 
 // NewEncoder creates a new packfile encoder using a specific Writer and
 // EncodedObjectStorer. By default deltas used to generate the packfile will be
 // OFSDeltaObject. To use Reference deltas, set useRefDeltas to true.
 func NewEncoder(w io.Writer, s storer.EncodedObjectStorer, useRefDeltas bool) *Encoder {
+	return NewEncoderWithOptions(w, s, EncoderOptions{UseRefDeltas: useRefDeltas})
+}
+
+// NewEncoderWithOptions creates a new packfile encoder using a specific
+// Writer and EncodedObjectStorer, configured by the given options.
+func NewEncoderWithOptions(w io.Writer, s storer.EncodedObjectStorer, opts EncoderOptions) *Encoder {
 	h := plumbing.Hasher{
 		Hash: hash.New(hash.CryptoType),
 	}
 	mw := io.MultiWriter(w, h)
 	ow := newOffsetWriter(mw)
 	zw := zlib.NewWriter(mw)
+
+	selector := newDeltaSelector(s)
+	if opts.Depth != 0 {
+		selector.depth = int64(opts.Depth)
+	}
+	selector.windowMemory = int64(opts.WindowMemory)
+
 	return &Encoder{
-		selector:     newDeltaSelector(s),
+		selector:     selector,
 		w:            ow,
 		zw:           zw,
 		hasher:       h,
-		useRefDeltas: useRefDeltas,
+		useRefDeltas: opts.UseRefDeltas,
+		reusePacks:   opts.ReusePacks,
 	}
 }
 
@@ -50,12 +81,17 @@ func (e *Encoder) Encode(
 	hashes []plumbing.Hash,
 	packWindow uint,
 ) (plumbing.Hash, error) {
+	reused, hashes, err := e.reusedObjects(hashes)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
 	objects, err := e.selector.ObjectsToPack(hashes, packWindow)
 	if err != nil {
 		return plumbing.ZeroHash, err
 	}
 
-	return e.encode(objects)
+	return e.encode(append(reused, objects...))
 }
 
 func (e *Encoder) encode(objects []*ObjectToPack) (plumbing.Hash, error) {
@@ -109,8 +145,17 @@ func (e *Encoder) entry(o *ObjectToPack) (err error) {
 
 	o.Offset = e.w.Offset()
 
+	if o.reused != nil {
+		return e.copyEntry(o)
+	}
+
+	return e.writeObject(o)
+}
+
+// writeObject writes the header and the compressed content of the object.
+func (e *Encoder) writeObject(o *ObjectToPack) (err error) {
 	if o.IsDelta() {
-		if err := e.writeDeltaHeader(o); err != nil {
+		if err := e.writeDeltaHeader(o, o.Object.Size()); err != nil {
 			return err
 		}
 	} else {
@@ -143,14 +188,14 @@ func (e *Encoder) writeBaseIfDelta(o *ObjectToPack) error {
 	return nil
 }
 
-func (e *Encoder) writeDeltaHeader(o *ObjectToPack) error {
+func (e *Encoder) writeDeltaHeader(o *ObjectToPack, size int64) error {
 	// Write offset deltas by default
 	t := plumbing.OFSDeltaObject
 	if e.useRefDeltas {
 		t = plumbing.REFDeltaObject
 	}
 
-	if err := e.entryHead(t, o.Object.Size()); err != nil {
+	if err := e.entryHead(t, size); err != nil {
 		return err
 	}
 
@@ -34,7 +34,7 @@ func (s *EncoderAdvancedSuite) TestEncodeDecode(c *C) {
 		ByTag("packfile").ByTag(".git").One())
 	fixs.Test(c, func(f *fixtures.Fixture) {
 		storage := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
-		s.testEncodeDecode(c, storage, 10)
+		s.testEncodeDecode(c, storage, 10, EncoderOptions{})
 	})
 }
 
@@ -48,7 +48,32 @@ func (s *EncoderAdvancedSuite) TestEncodeDecodeNoDeltaCompression(c *C) {
 		ByTag("packfile").ByTag(".git").One())
 	fixs.Test(c, func(f *fixtures.Fixture) {
 		storage := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
-		s.testEncodeDecode(c, storage, 0)
+		s.testEncodeDecode(c, storage, 0, EncoderOptions{})
+	})
+}
+
+func (s *EncoderAdvancedSuite) TestEncodeDecodeReusingPacks(c *C) {
+	if testing.Short() {
+		c.Skip("skipping test in short mode.")
+	}
+
+	fixs := fixtures.Basic().ByTag("packfile").ByTag(".git")
+	fixs = append(fixs, fixtures.ByURL("https://github.com/src-d/go-git.git").
+		ByTag("packfile").ByTag(".git").One())
+	fixs.Test(c, func(f *fixtures.Fixture) {
+		storage := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+
+		idx := idxfile.NewMemoryIndex()
+		c.Assert(idxfile.NewDecoder(f.Idx()).Decode(idx), IsNil)
+		pack := NewPackfile(idx, nil, f.Packfile(), 0)
+		defer pack.Close()
+
+		for _, depth := range []uint{0, 1, 3} {
+			s.testEncodeDecode(c, storage, 10, EncoderOptions{
+				Depth:      depth,
+				ReusePacks: []*Packfile{pack},
+			})
+		}
 	})
 }
 
@@ -56,6 +81,7 @@ func (s *EncoderAdvancedSuite) testEncodeDecode(
 	c *C,
 	storage storer.Storer,
 	packWindow uint,
+	opts EncoderOptions,
 ) {
 	objIter, err := storage.IterEncodedObjects(plumbing.AnyObject)
 	c.Assert(err, IsNil)
@@ -79,7 +105,7 @@ func (s *EncoderAdvancedSuite) testEncodeDecode(
 	hashes = auxHashes
 
 	buf := bytes.NewBuffer(nil)
-	enc := NewEncoder(buf, storage, false)
+	enc := NewEncoderWithOptions(buf, storage, opts)
 	encodeHash, err := enc.Encode(hashes, packWindow)
 	c.Assert(err, IsNil)
 
@@ -132,4 +158,34 @@ func (s *EncoderAdvancedSuite) testEncodeDecode(
 			c.Errorf("missing object: %s", h)
 		}
 	}
+
+	if opts.Depth != 0 {
+		c.Assert(maxDeltaDepth(c, buf.Bytes()) <= int(opts.Depth), Equals, true)
+	}
+}
+
+// maxDeltaDepth returns the length of the longest delta chain of a packfile
+// with offset deltas.
+func maxDeltaDepth(c *C, pack []byte) int {
+	scanner := NewScanner(bytes.NewReader(pack))
+	_, objects, err := scanner.Header()
+	c.Assert(err, IsNil)
+
+	depths := make(map[int64]int)
+	var max int
+	for i := uint32(0); i < objects; i++ {
+		h, err := scanner.NextObjectHeader()
+		c.Assert(err, IsNil)
+		_, _, err = scanner.NextObject(io.Discard)
+		c.Assert(err, IsNil)
+
+		if h.Type == plumbing.OFSDeltaObject {
+			depths[h.Offset] = depths[h.OffsetReference] + 1
+			if depths[h.Offset] > max {
+				max = depths[h.Offset]
+			}
+		}
+	}
+
+	return max
 }
@@ -29,6 +29,9 @@ type ObjectToPack struct {
 	originalType     plumbing.ObjectType
 	originalSize     int64
 	originalHash     plumbing.Hash
+
+	// reused is the entry of a packfile copied as it is, if any.
+	reused *reusedEntry
 }
 
 // newObjectToPack creates a correct ObjectToPack based on a non-delta object
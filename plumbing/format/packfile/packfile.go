@@ -43,6 +43,8 @@ type Packfile struct {
 	deltaBaseCache       cache.Object
 	offsetToType         map[int64]plumbing.ObjectType
 	largeObjectThreshold int64
+	// offsets are the sorted offsets of the entries, loaded when needed.
+	offsets []int64
 }
 
 // NewPackfileWithCache creates a new Packfile with the given object cache.
@@ -64,6 +66,7 @@ func NewPackfileWithCache(
 		cache,
 		make(map[int64]plumbing.ObjectType),
 		largeObjectThreshold,
+		nil,
 	}
 }
 
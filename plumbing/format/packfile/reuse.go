@@ -0,0 +1,268 @@
+package packfile
+
+import (
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/hash"
+)
+
+// reusedEntry is an entry of a packfile which is copied as it is into a new
+// packfile, with only its header written again.
+type reusedEntry struct {
+	pack   *Packfile
+	header *ObjectHeader
+	// base is the hash of the delta base, if the entry is a delta.
+	base plumbing.Hash
+	// data and end are the offsets of the start and the end of the
+	// compressed content of the entry.
+	data, end int64
+	crc       uint32
+}
+
+func (e *reusedEntry) isDelta() bool {
+	return e.header.Type.IsDelta()
+}
+
+// check checks the CRC32 of the entry against the one of the index.
+func (e *reusedEntry) check() (bool, error) {
+	crc := crc32.NewIEEE()
+	r := io.NewSectionReader(e.pack.file, e.header.Offset, e.end-e.header.Offset)
+	if _, err := io.Copy(crc, r); err != nil {
+		return false, err
+	}
+
+	return crc.Sum32() == e.crc, nil
+}
+
+// copyTo copies the compressed content of the entry.
+func (e *reusedEntry) copyTo(w io.Writer) error {
+	_, err := io.Copy(w, io.NewSectionReader(e.pack.file, e.data, e.end-e.data))
+	return err
+}
+
+// reusedEntry returns the entry of the object with the given hash.
+func (p *Packfile) reusedEntry(h plumbing.Hash) (*reusedEntry, error) {
+	offset, err := p.FindOffset(h)
+	if err != nil {
+		return nil, err
+	}
+
+	crc, err := p.FindCRC32(h)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := p.objectHeaderAtOffset(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := p.s.r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := p.entryEnd(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &reusedEntry{pack: p, header: header, data: data, end: end, crc: crc}
+	switch header.Type {
+	case plumbing.OFSDeltaObject:
+		e.base, err = p.FindHash(header.OffsetReference)
+	case plumbing.REFDeltaObject:
+		e.base = header.Reference
+	}
+
+	return e, err
+}
+
+// entryEnd returns the offset of the end of the entry at the given offset,
+// which is the start of the next entry or of the checksum of the packfile.
+func (p *Packfile) entryEnd(offset int64) (int64, error) {
+	if p.offsets == nil {
+		if err := p.loadOffsets(); err != nil {
+			return 0, err
+		}
+	}
+
+	i := sort.Search(len(p.offsets), func(i int) bool { return p.offsets[i] > offset })
+	return p.offsets[i], nil
+}
+
+// loadOffsets loads the sorted offsets of the entries, followed by the
+// offset of the checksum of the packfile.
+func (p *Packfile) loadOffsets() error {
+	iter, err := p.EntriesByOffset()
+	if err != nil {
+		return err
+	}
+
+	defer iter.Close()
+
+	var offsets []int64
+	for {
+		e, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		offsets = append(offsets, int64(e.Offset))
+	}
+
+	// The file is read through the scanner, so its position is restored.
+	size, err := p.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.s.r.Seek(p.s.r.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	p.offsets = append(offsets, size-hash.Size)
+	return nil
+}
+
+// reusedObjects returns the objects which are copied from the reused packs,
+// and the hashes of the other ones.
+func (e *Encoder) reusedObjects(hashes []plumbing.Hash) ([]*ObjectToPack, []plumbing.Hash, error) {
+	if len(e.reusePacks) == 0 {
+		return nil, hashes, nil
+	}
+
+	objects := make(map[plumbing.Hash]*ObjectToPack, len(hashes))
+	for _, h := range hashes {
+		entry, err := e.findReusedEntry(h)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if entry == nil {
+			continue
+		}
+
+		otp := &ObjectToPack{
+			resolvedOriginal: true,
+			originalHash:     h,
+			reused:           entry,
+		}
+
+		if !entry.isDelta() {
+			otp.originalType = entry.header.Type
+			otp.originalSize = entry.header.Length
+		}
+
+		objects[h] = otp
+	}
+
+	visiting := make(map[plumbing.Hash]bool)
+	for _, h := range hashes {
+		if otp, ok := objects[h]; ok {
+			e.linkReusedDelta(objects, otp, visiting)
+		}
+	}
+
+	var reused []*ObjectToPack
+	var rest []plumbing.Hash
+	for _, h := range hashes {
+		if otp, ok := objects[h]; ok {
+			reused = append(reused, otp)
+		} else {
+			rest = append(rest, h)
+		}
+	}
+
+	return reused, rest, nil
+}
+
+func (e *Encoder) findReusedEntry(h plumbing.Hash) (*reusedEntry, error) {
+	for _, p := range e.reusePacks {
+		ok, err := p.Contains(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return p.reusedEntry(h)
+		}
+	}
+
+	return nil, nil
+}
+
+// linkReusedDelta sets the base of a reused delta. The delta is not reused,
+// and removed from the objects, if its base is not reused or if the delta
+// chain would be too long.
+func (e *Encoder) linkReusedDelta(objects map[plumbing.Hash]*ObjectToPack, otp *ObjectToPack, visiting map[plumbing.Hash]bool) {
+	h := otp.Hash()
+	if !otp.reused.isDelta() || otp.Base != nil {
+		return
+	}
+
+	visiting[h] = true
+	defer delete(visiting, h)
+
+	base, ok := objects[otp.reused.base]
+	if ok && visiting[base.Hash()] {
+		// A cycle between the deltas of different packs.
+		ok = false
+	}
+
+	if ok {
+		e.linkReusedDelta(objects, base, visiting)
+		base, ok = objects[otp.reused.base]
+	}
+
+	if !ok || int64(base.Depth) >= e.selector.depth {
+		delete(objects, h)
+		return
+	}
+
+	otp.Base = base
+	otp.Depth = base.Depth + 1
+}
+
+// copyEntry writes a reused entry. If its content is corrupted, the object
+// is compressed again instead.
+func (e *Encoder) copyEntry(o *ObjectToPack) (err error) {
+	entry := o.reused
+	ok, err := entry.check()
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		o.reused = nil
+		o.Base = nil
+		o.Depth = 0
+		obj, err := e.selector.encodedObject(o.Hash())
+		if err != nil {
+			return err
+		}
+
+		o.Object = obj
+		o.SetOriginal(obj)
+		return e.writeObject(o)
+	}
+
+	if entry.isDelta() {
+		err = e.writeDeltaHeader(o, entry.header.Length)
+	} else {
+		err = e.entryHead(entry.header.Type, entry.header.Length)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return entry.copyTo(e.w)
+}
@@ -27,6 +27,13 @@ const (
 var (
 	// FlushPkt are the contents of a flush-pkt pkt-line.
 	FlushPkt = []byte{'0', '0', '0', '0'}
+	// DelimPkt are the contents of a delim-pkt pkt-line, which separates the
+	// sections of the messages of the version 2 of the protocol.
+	DelimPkt = []byte{'0', '0', '0', '1'}
+	// ResponseEndPkt are the contents of a response-end-pkt pkt-line, which
+	// ends the responses of the version 2 of the protocol over stateless
+	// connections.
+	ResponseEndPkt = []byte{'0', '0', '0', '2'}
 	// Flush is the payload to use with the Encode method to encode a flush-pkt.
 	Flush = []byte{}
 	// FlushString is the payload to use with the EncodeString method to encode a flush-pkt.
@@ -50,6 +57,20 @@ func (e *Encoder) Flush() error {
 	return err
 }
 
+// Delim encodes a delim-pkt to the output stream.
+func (e *Encoder) Delim() error {
+	defer trace.Packet.Print("packet: > 0001")
+	_, err := e.w.Write(DelimPkt)
+	return err
+}
+
+// ResponseEnd encodes a response-end-pkt to the output stream.
+func (e *Encoder) ResponseEnd() error {
+	defer trace.Packet.Print("packet: > 0002")
+	_, err := e.w.Write(ResponseEndPkt)
+	return err
+}
+
 // Encode encodes a pkt-line with the payload specified and write it to
 // the output stream.  If several payloads are specified, each of them
 // will get streamed in their own pkt-lines.
@@ -27,6 +27,15 @@ func (s *SuiteEncoder) TestFlush(c *C) {
 	c.Assert(obtained, DeepEquals, pktline.FlushPkt)
 }
 
+func (s *SuiteEncoder) TestDelimAndResponseEnd(c *C) {
+	var buf bytes.Buffer
+	e := pktline.NewEncoder(&buf)
+
+	c.Assert(e.Delim(), IsNil)
+	c.Assert(e.ResponseEnd(), IsNil)
+	c.Assert(buf.String(), Equals, "00010002")
+}
+
 func (s *SuiteEncoder) TestEncode(c *C) {
 	for i, test := range [...]struct {
 		input    [][]byte
@@ -31,6 +31,7 @@ type Scanner struct {
 	err     error         // Sticky error
 	payload []byte        // Last pkt-payload
 	len     [lenSize]byte // Last pkt-len
+	v2      bool          // Whether delim-pkt and response-end-pkt are valid
 }
 
 // NewScanner returns a new Scanner to read from r.
@@ -40,6 +41,17 @@ func NewScanner(r io.Reader) *Scanner {
 	}
 }
 
+// NewScannerV2 returns a new Scanner to read from r the messages of the
+// version 2 of the protocol, which may also contain delim-pkt and
+// response-end-pkt pkt-lines. Like flush-pkt, they are represented by empty
+// byte slices, use the IsDelim and IsResponseEnd methods to tell them apart.
+func NewScannerV2(r io.Reader) *Scanner {
+	return &Scanner{
+		r:  r,
+		v2: true,
+	}
+}
+
 // Err returns the first error encountered by the Scanner.
 func (s *Scanner) Err() error {
 	return s.err
@@ -81,6 +93,22 @@ func (s *Scanner) Scan() bool {
 	return true
 }
 
+// IsFlush returns true if the last pkt-line read by Scan is a flush-pkt.
+func (s *Scanner) IsFlush() bool {
+	return bytes.Equal(s.len[:], FlushPkt)
+}
+
+// IsDelim returns true if the last pkt-line read by Scan is a delim-pkt.
+func (s *Scanner) IsDelim() bool {
+	return s.v2 && bytes.Equal(s.len[:], DelimPkt)
+}
+
+// IsResponseEnd returns true if the last pkt-line read by Scan is a
+// response-end-pkt.
+func (s *Scanner) IsResponseEnd() bool {
+	return s.v2 && bytes.Equal(s.len[:], ResponseEndPkt)
+}
+
 // Bytes returns the most recent payload generated by a call to Scan.
 // The underlying array may point to data that will be overwritten by a
 // subsequent call to Scan. It does no allocation.
@@ -107,6 +135,8 @@ func (s *Scanner) readPayloadLen() (int, error) {
 	switch {
 	case n == 0:
 		return 0, nil
+	case s.v2 && (n == 1 || n == 2):
+		return 0, nil
 	case n <= lenSize:
 		return 0, ErrInvalidPktLen
 	case n > OversizePayloadMax+lenSize:
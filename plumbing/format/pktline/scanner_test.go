@@ -90,6 +90,38 @@ func (s *SuiteScanner) TestFlush(c *C) {
 	c.Assert(len(payload), Equals, 0)
 }
 
+func (s *SuiteScanner) TestScannerV2(c *C) {
+	var buf bytes.Buffer
+	e := pktline.NewEncoder(&buf)
+	c.Assert(e.EncodeString("command=ls-refs\n"), IsNil)
+	c.Assert(e.Delim(), IsNil)
+	c.Assert(e.EncodeString("peel\n"), IsNil)
+	c.Assert(e.Flush(), IsNil)
+	c.Assert(e.ResponseEnd(), IsNil)
+
+	sc := pktline.NewScannerV2(&buf)
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(string(sc.Bytes()), Equals, "command=ls-refs\n")
+	c.Assert(sc.IsFlush(), Equals, false)
+
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(sc.Bytes(), HasLen, 0)
+	c.Assert(sc.IsDelim(), Equals, true)
+
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(string(sc.Bytes()), Equals, "peel\n")
+
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(sc.IsFlush(), Equals, true)
+	c.Assert(sc.IsDelim(), Equals, false)
+
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(sc.IsResponseEnd(), Equals, true)
+
+	c.Assert(sc.Scan(), Equals, false)
+	c.Assert(sc.Err(), IsNil)
+}
+
 func (s *SuiteScanner) TestPktLineTooShort(c *C) {
 	r := strings.NewReader("010cfoobar")
 
@@ -0,0 +1,97 @@
+package reflog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+)
+
+// ErrMalformedEntry is returned by Decode when a line is not a valid entry.
+var ErrMalformedEntry = errors.New("malformed reflog entry")
+
+// A Decoder reads reflog entries from an input stream.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads all the entries of the stream, oldest first.
+func (d *Decoder) Decode() ([]*Entry, error) {
+	var entries []*Entry
+	scn := bufio.NewScanner(d.r)
+	for scn.Scan() {
+		line := scn.Text()
+		if line == "" {
+			continue
+		}
+
+		e, err := decodeEntry(line)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, scn.Err()
+}
+
+func decodeEntry(line string) (*Entry, error) {
+	header, msg, _ := strings.Cut(line, "\t")
+	fields := strings.SplitN(header, " ", 3)
+	if len(fields) != 3 || !plumbing.IsHash(fields[0]) || !plumbing.IsHash(fields[1]) {
+		return nil, fmt.Errorf("%w: %q", ErrMalformedEntry, line)
+	}
+
+	e := &Entry{
+		Old:     plumbing.NewHash(fields[0]),
+		New:     plumbing.NewHash(fields[1]),
+		Message: msg,
+	}
+
+	if err := decodeSignature(&e.Committer, fields[2]); err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrMalformedEntry, line)
+	}
+
+	return e, nil
+}
+
+// decodeSignature decodes "Name <email> <unix time> <tz>".
+func decodeSignature(s *Signature, b string) error {
+	open := strings.LastIndexByte(b, '<')
+	close := strings.LastIndexByte(b, '>')
+	if open == -1 || close < open {
+		return ErrMalformedEntry
+	}
+
+	s.Name = strings.TrimSpace(b[:open])
+	s.Email = b[open+1 : close]
+
+	fields := strings.Fields(b[close+1:])
+	if len(fields) != 2 {
+		return ErrMalformedEntry
+	}
+
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tz, err := time.Parse("-0700", fields[1])
+	if err != nil {
+		return err
+	}
+
+	s.When = time.Unix(ts, 0).In(tz.Location())
+	return nil
+}
@@ -0,0 +1,9 @@
+// Package reflog implements encoding and decoding of reflog files.
+//
+// A reflog records the updates of a reference, one per line, oldest first:
+//
+//	<old hash> SP <new hash> SP <name> SP "<" <email> ">" SP <unix time> SP <tz> HT <message> LF
+//
+// The reflog of a reference is stored in the logs directory of the
+// repository, under the name of the reference, e.g. logs/refs/heads/master.
+package reflog
@@ -0,0 +1,57 @@
+package reflog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An Encoder writes reflog entries to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the entries to the stream of the encoder.
+func (e *Encoder) Encode(entries ...*Entry) error {
+	for _, entry := range entries {
+		if err := e.encode(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Encoder) encode(entry *Entry) error {
+	when := entry.Committer.When
+	ts := when.Unix()
+	if ts < 0 {
+		ts = 0
+	}
+
+	_, err := fmt.Fprintf(e.w, "%s %s %s <%s> %d %s",
+		entry.Old, entry.New,
+		entry.Committer.Name, entry.Committer.Email,
+		ts, when.Format("-0700"),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Like git, the message is kept in a single line, and the tab separating
+	// it is omitted when it is empty.
+	if msg := strings.Join(strings.Fields(entry.Message), " "); msg != "" {
+		_, err = fmt.Fprintf(e.w, "\t%s", msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintln(e.w)
+	return err
+}
@@ -0,0 +1,27 @@
+package reflog
+
+import (
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+)
+
+// Entry is an entry of a reflog, recording an update of a reference.
+type Entry struct {
+	// Old is the hash the reference pointed to before the update, ZeroHash
+	// if it did not exist.
+	Old plumbing.Hash
+	// New is the hash the reference points to after the update.
+	New plumbing.Hash
+	// Committer is who updated the reference, and when.
+	Committer Signature
+	// Message describes the update. It is kept in a single line.
+	Message string
+}
+
+// Signature identifies who updated a reference, and when.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
@@ -0,0 +1,73 @@
+package reflog
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ReflogSuite struct{}
+
+var _ = Suite(&ReflogSuite{})
+
+const fixture = "0000000000000000000000000000000000000000 e8d3ffab552895c19b9fcf7aa264d277cde33881 John Doe <john@example.com> 1700000000 +0200\tcommit (initial): first\n" +
+	"e8d3ffab552895c19b9fcf7aa264d277cde33881 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 John Doe <john@example.com> 1700000100 -0130\tcheckout: moving from master to feature\n"
+
+func (s *ReflogSuite) TestDecode(c *C) {
+	entries, err := NewDecoder(strings.NewReader(fixture)).Decode()
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+
+	e := entries[0]
+	c.Assert(e.Old, Equals, plumbing.ZeroHash)
+	c.Assert(e.New, Equals, plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+	c.Assert(e.Committer.Name, Equals, "John Doe")
+	c.Assert(e.Committer.Email, Equals, "john@example.com")
+	c.Assert(e.Committer.When.Unix(), Equals, int64(1700000000))
+	_, offset := e.Committer.When.Zone()
+	c.Assert(offset, Equals, 2*60*60)
+	c.Assert(e.Message, Equals, "commit (initial): first")
+
+	_, offset = entries[1].Committer.When.Zone()
+	c.Assert(offset, Equals, -90*60)
+}
+
+func (s *ReflogSuite) TestDecodeMalformed(c *C) {
+	for _, line := range []string{
+		"foo bar baz\tmsg",
+		"0000000000000000000000000000000000000000 e8d3ffab552895c19b9fcf7aa264d277cde33881\tmsg",
+		"0000000000000000000000000000000000000000 e8d3ffab552895c19b9fcf7aa264d277cde33881 John <john> foo +0000\tmsg",
+	} {
+		_, err := NewDecoder(strings.NewReader(line)).Decode()
+		c.Assert(errors.Is(err, ErrMalformedEntry), Equals, true, Commentf("line %q", line))
+	}
+}
+
+func (s *ReflogSuite) TestEncodeDecode(c *C) {
+	entries, err := NewDecoder(strings.NewReader(fixture)).Decode()
+	c.Assert(err, IsNil)
+
+	var b bytes.Buffer
+	err = NewEncoder(&b).Encode(entries...)
+	c.Assert(err, IsNil)
+	c.Assert(b.String(), Equals, fixture)
+}
+
+func (s *ReflogSuite) TestEncodeSingleLine(c *C) {
+	var b bytes.Buffer
+	err := NewEncoder(&b).Encode(&Entry{
+		New:       plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+		Committer: Signature{Name: "John Doe", Email: "john@example.com", When: time.Unix(0, 0).UTC()},
+		Message:   "commit: subject\n\nbody",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(b.String(), Equals, "0000000000000000000000000000000000000000 e8d3ffab552895c19b9fcf7aa264d277cde33881 John Doe <john@example.com> 0 +0000\tcommit: subject body\n")
+}
@@ -0,0 +1,437 @@
+package reftable
+
+import (
+	"bytes"
+	"compress/zlib"
+	encbin "encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+	"github.com/jesseduffield/go-git/v5/plumbing/hash"
+	"github.com/jesseduffield/go-git/v5/utils/binary"
+)
+
+const (
+	headerSizeV1 = 24
+	headerSizeV2 = 28
+	// footerSize is the size of the footer without its copy of the header.
+	footerSize = 5*8 + 4
+)
+
+// Decoder reads and decodes a Table from an input stream.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a new decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r}
+}
+
+// Decode reads the table from the input stream and stores it in t. The obj
+// and the index blocks are skipped, as the ref and the log blocks are read
+// in full.
+func (d *Decoder) Decode(t *Table) error {
+	data, err := io.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	headerSize, err := decodeHeader(data)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < 2*headerSize+footerSize {
+		return ErrMalformedTable
+	}
+
+	end := len(data) - headerSize - footerSize
+	footer := data[end:]
+	crc := encbin.BigEndian.Uint32(footer[len(footer)-4:])
+	if !bytes.Equal(footer[:headerSize], data[:headerSize]) ||
+		crc32.ChecksumIEEE(footer[:len(footer)-4]) != crc {
+		return ErrMalformedTable
+	}
+
+	*t = Table{
+		MinUpdateIndex: encbin.BigEndian.Uint64(data[8:]),
+		MaxUpdateIndex: encbin.BigEndian.Uint64(data[16:]),
+	}
+
+	r := &tableReader{
+		data:       data[:end],
+		headerSize: headerSize,
+		blockSize:  int(encbin.BigEndian.Uint32(data[4:]) & 0xffffff),
+	}
+
+	first := r.blockType(0)
+	if first == blockTypeRef {
+		if err := r.readBlocks(0, blockTypeRef, func(rd *recordReader) error {
+			return decodeRef(t, rd)
+		}); err != nil {
+			return err
+		}
+	}
+
+	logsOffset := encbin.BigEndian.Uint64(footer[headerSize+24:])
+	if first != blockTypeLog && logsOffset == 0 {
+		return nil
+	}
+
+	if logsOffset >= uint64(end) {
+		return ErrMalformedTable
+	}
+
+	return r.readBlocks(int(logsOffset), blockTypeLog, func(rd *recordReader) error {
+		return decodeLog(t, rd)
+	})
+}
+
+func decodeHeader(data []byte) (int, error) {
+	if len(data) < headerSizeV1 || !bytes.Equal(data[:4], signature) {
+		return 0, ErrMalformedTable
+	}
+
+	switch data[4] {
+	case 1:
+		if hash.Size != 20 {
+			return 0, ErrUnsupportedVersion
+		}
+
+		return headerSizeV1, nil
+	case 2:
+		if len(data) < headerSizeV2 {
+			return 0, ErrMalformedTable
+		}
+
+		id := hashIDSHA1
+		if hash.Size != 20 {
+			id = hashIDSHA256
+		}
+
+		if !bytes.Equal(data[24:28], id[:]) {
+			return 0, ErrUnsupportedVersion
+		}
+
+		return headerSizeV2, nil
+	default:
+		return 0, ErrUnsupportedVersion
+	}
+}
+
+// tableReader reads the blocks of a table, without its footer.
+type tableReader struct {
+	data       []byte
+	headerSize int
+	blockSize  int
+}
+
+// blockType returns the type of the block at the given offset, or 0 if
+// there is none.
+func (r *tableReader) blockType(offset int) byte {
+	if offset == 0 {
+		offset = r.headerSize
+	}
+
+	if offset >= len(r.data) {
+		return 0
+	}
+
+	return r.data[offset]
+}
+
+// readBlocks reads the records of the blocks of the given type starting at
+// offset, until a block of another type or the end of the table.
+func (r *tableReader) readBlocks(offset int, typ byte, fn func(*recordReader) error) error {
+	for r.blockType(offset) == typ {
+		block, next, err := r.block(offset)
+		if err != nil {
+			return err
+		}
+
+		rd, err := newRecordReader(block, offset == 0, r.headerSize)
+		if err != nil {
+			return err
+		}
+
+		for rd.more() {
+			if err := fn(rd); err != nil {
+				return err
+			}
+		}
+
+		offset = next
+	}
+
+	return nil
+}
+
+// block returns the block at the given offset, uncompressed and including
+// the file header for the first block, and the offset of the next block.
+func (r *tableReader) block(offset int) ([]byte, int, error) {
+	start := offset
+	if offset == 0 {
+		start = r.headerSize
+	}
+
+	if start+4 > len(r.data) {
+		return nil, 0, ErrMalformedTable
+	}
+
+	typ := r.data[start]
+	length := int(encbin.BigEndian.Uint32(r.data[start:]) & 0xffffff)
+	if length < start-offset+4 {
+		return nil, 0, ErrMalformedTable
+	}
+
+	if typ == blockTypeLog {
+		return r.logBlock(offset, start, length)
+	}
+
+	end := offset + length
+	if end > len(r.data) {
+		return nil, 0, ErrMalformedTable
+	}
+
+	// Blocks are padded to the block size with NUL bytes, unless the table
+	// is unaligned.
+	next := end
+	if end < len(r.data) && r.data[end] == 0 && length < r.blockSize {
+		next = offset + r.blockSize
+	}
+
+	return r.data[offset:end], next, nil
+}
+
+func (r *tableReader) logBlock(offset, start, length int) ([]byte, int, error) {
+	src := bytes.NewReader(r.data[start+4:])
+	zr, err := zlib.NewReader(src)
+	if err != nil {
+		return nil, 0, ErrMalformedTable
+	}
+
+	block := make([]byte, length)
+	copy(block, r.data[offset:start+4])
+	if _, err := io.ReadFull(zr, block[start-offset+4:]); err != nil {
+		return nil, 0, ErrMalformedTable
+	}
+
+	// Reading until the end of the stream checks its checksum, and leaves
+	// the reader after it.
+	if n, err := zr.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		return nil, 0, ErrMalformedTable
+	}
+
+	return block, len(r.data) - src.Len(), nil
+}
+
+// recordReader reads the records of a block, undoing the compression of
+// their keys.
+type recordReader struct {
+	r       *bytes.Reader
+	lastKey []byte
+}
+
+func newRecordReader(block []byte, first bool, headerSize int) (*recordReader, error) {
+	start := 4
+	if first {
+		start += headerSize
+	}
+
+	if len(block) < start+2 {
+		return nil, ErrMalformedTable
+	}
+
+	count := int(encbin.BigEndian.Uint16(block[len(block)-2:]))
+	end := len(block) - 2 - 3*count
+	if count == 0 || end < start {
+		return nil, ErrMalformedTable
+	}
+
+	return &recordReader{r: bytes.NewReader(block[start:end])}, nil
+}
+
+func (rd *recordReader) more() bool {
+	return rd.r.Len() > 0
+}
+
+// key reads the key of the next record and its type.
+func (rd *recordReader) key() ([]byte, byte, error) {
+	prefix, err := rd.varint()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	v, err := rd.varint()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if prefix > uint64(len(rd.lastKey)) {
+		return nil, 0, ErrMalformedTable
+	}
+
+	suffix, err := rd.bytes(v >> 3)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rd.lastKey = append(rd.lastKey[:prefix], suffix...)
+	return rd.lastKey, byte(v & 7), nil
+}
+
+func (rd *recordReader) varint() (uint64, error) {
+	v, err := binary.ReadVariableWidthInt(rd.r)
+	if err != nil {
+		return 0, ErrMalformedTable
+	}
+
+	return uint64(v), nil
+}
+
+func (rd *recordReader) bytes(n uint64) ([]byte, error) {
+	if n > uint64(rd.r.Len()) {
+		return nil, ErrMalformedTable
+	}
+
+	b := make([]byte, n)
+	_, _ = rd.r.Read(b)
+	return b, nil
+}
+
+func (rd *recordReader) string() (string, error) {
+	n, err := rd.varint()
+	if err != nil {
+		return "", err
+	}
+
+	b, err := rd.bytes(n)
+	return string(b), err
+}
+
+func (rd *recordReader) hash() (plumbing.Hash, error) {
+	var h plumbing.Hash
+	b, err := rd.bytes(hash.Size)
+	copy(h[:], b)
+	return h, err
+}
+
+func decodeRef(t *Table, rd *recordReader) error {
+	key, typ, err := rd.key()
+	if err != nil {
+		return err
+	}
+
+	delta, err := rd.varint()
+	if err != nil {
+		return err
+	}
+
+	r := &RefRecord{
+		Name:        plumbing.ReferenceName(key),
+		UpdateIndex: t.MinUpdateIndex + delta,
+	}
+
+	switch typ {
+	case refDeletion:
+		r.Deleted = true
+	case refValue, refPeeled:
+		if r.Hash, err = rd.hash(); err != nil {
+			return err
+		}
+
+		if typ == refPeeled {
+			if r.Peeled, err = rd.hash(); err != nil {
+				return err
+			}
+		}
+	case refSymbolic:
+		target, err := rd.string()
+		if err != nil {
+			return err
+		}
+
+		r.Target = plumbing.ReferenceName(target)
+	default:
+		return ErrMalformedTable
+	}
+
+	t.Refs = append(t.Refs, r)
+	return nil
+}
+
+func decodeLog(t *Table, rd *recordReader) error {
+	key, typ, err := rd.key()
+	if err != nil {
+		return err
+	}
+
+	if len(key) < 9 || key[len(key)-9] != 0 {
+		return ErrMalformedTable
+	}
+
+	l := &LogRecord{
+		Name:        plumbing.ReferenceName(key[:len(key)-9]),
+		UpdateIndex: math.MaxUint64 - encbin.BigEndian.Uint64(key[len(key)-8:]),
+	}
+
+	switch typ {
+	case logDeletion:
+	case logUpdate:
+		if l.Entry, err = readEntry(rd); err != nil {
+			return err
+		}
+	default:
+		return ErrMalformedTable
+	}
+
+	t.Logs = append(t.Logs, l)
+	return nil
+}
+
+func readEntry(rd *recordReader) (*reflog.Entry, error) {
+	e := &reflog.Entry{}
+	var err error
+	if e.Old, err = rd.hash(); err != nil {
+		return nil, err
+	}
+
+	if e.New, err = rd.hash(); err != nil {
+		return nil, err
+	}
+
+	if e.Committer.Name, err = rd.string(); err != nil {
+		return nil, err
+	}
+
+	if e.Committer.Email, err = rd.string(); err != nil {
+		return nil, err
+	}
+
+	ts, err := rd.varint()
+	if err != nil {
+		return nil, err
+	}
+
+	tz, err := rd.bytes(2)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := int(int16(encbin.BigEndian.Uint16(tz)))
+	e.Committer.When = time.Unix(int64(ts), 0).In(time.FixedZone("", offset*60))
+
+	msg, err := rd.string()
+	if err != nil {
+		return nil, err
+	}
+
+	e.Message = strings.TrimSuffix(msg, "\n")
+	return e, nil
+}
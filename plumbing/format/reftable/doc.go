@@ -0,0 +1,60 @@
+// Package reftable implements encoding and decoding of reftable files.
+//
+// A reftable stores references and reflog entries in a sorted, immutable
+// file. A repository with extensions.refStorage=reftable keeps them in a
+// stack of such tables in the reftable directory: each transaction adds a
+// new table, whose records replace the ones of the older tables, and tables
+// are merged from time to time to keep the stack short.
+//
+// A table has the following format:
+//
+//   - The header: the 4-byte signature 'REFT', the 1-byte version number,
+//     1 for SHA-1 or 2 for other hash functions, the block size as 3 bytes,
+//     and the minimum and the maximum update indexes of its records as 8
+//     bytes each. Version 2 adds a 4-byte hash function identifier, 'sha1'
+//     or 's256'. All the numbers are in network byte order.
+//
+//   - The ref blocks, padded to the block size, then the obj and the index
+//     blocks, which are optional, then the log blocks.
+//
+//   - The footer: a copy of the header, the 8-byte positions of the ref
+//     index, of the obj blocks (shifted by 5 bits, the lower ones being the
+//     length of their abbreviated hashes), of the obj index, of the log
+//     blocks and of the log index, 0 if absent, and the CRC-32 of the
+//     footer.
+//
+// A block starts with its 1-byte type, 'r', 'o', 'i' or 'g', and its length
+// as 3 bytes, which includes the file header for the first block. Its
+// records follow, then the 3-byte offsets of the restart points, which are
+// the records whose key is not prefix compressed, one every 16 records,
+// and their number as 2 bytes. The content of a log block, after its type
+// and its length, is compressed with zlib, its length being the one before
+// compression.
+//
+// A record starts with its key: a varint with the length of the prefix it
+// shares with the key of the previous record, a varint with the length of
+// the rest of the key, shifted by 3 bits, the lower ones being the type of
+// the record, and the rest of the key. The varints are encoded like the
+// offsets of ofs-delta objects in packs.
+//
+// The key of a ref record is the name of the reference, followed by a
+// varint with its update index minus the minimum update index of the table,
+// and its value, depending on its type:
+//
+//   - 0: none, the reference is deleted.
+//   - 1: the hash it points to.
+//   - 2: the hash it points to and the hash of the peeled tag.
+//   - 3: a varint with the length of its target and its target, for a
+//     symbolic reference.
+//
+// The key of a log record is the name of the reference, a NUL byte, and
+// the update index as 8 bytes, subtracted from 0xffffffffffffffff so the
+// newest entries come first. Its type is 0 for a deletion, with no value,
+// or 1 for an entry of the reflog: the old and the new hashes, the name and
+// the email of the committer, each one preceded by a varint with its
+// length, a varint with the time in seconds, the time zone offset in
+// minutes as a signed 2-byte number, and the message, preceded by a varint
+// with its length.
+//
+// See https://git-scm.com/docs/reftable
+package reftable
@@ -0,0 +1,368 @@
+package reftable
+
+import (
+	"bytes"
+	"compress/zlib"
+	encbin "encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+	"github.com/jesseduffield/go-git/v5/plumbing/hash"
+	"github.com/jesseduffield/go-git/v5/utils/binary"
+)
+
+// Encoder writes Table to an output stream.
+type Encoder struct {
+	w         io.Writer
+	blockSize int
+}
+
+// NewEncoder returns a new encoder writing to w, with the default block
+// size.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, blockSize: DefaultBlockSize}
+}
+
+// Encode writes t to the output stream. Like git, the encoder writes no obj
+// and no index blocks, which are optional.
+func (e *Encoder) Encode(t *Table) error {
+	if err := checkTable(t); err != nil {
+		return err
+	}
+
+	header := encodeHeader(t, e.blockSize)
+	enc := &tableEncoder{w: e.w, blockSize: e.blockSize, header: header}
+	if err := enc.writeRefs(t); err != nil {
+		return err
+	}
+
+	if err := enc.writeLogs(t); err != nil {
+		return err
+	}
+
+	return enc.writeFooter()
+}
+
+func checkTable(t *Table) error {
+	if t.MinUpdateIndex > t.MaxUpdateIndex {
+		return ErrMalformedTable
+	}
+
+	for i, r := range t.Refs {
+		if i > 0 && t.Refs[i-1].Name >= r.Name ||
+			r.UpdateIndex < t.MinUpdateIndex || r.UpdateIndex > t.MaxUpdateIndex {
+			return ErrMalformedTable
+		}
+	}
+
+	for i, l := range t.Logs {
+		if i == 0 {
+			continue
+		}
+
+		p := t.Logs[i-1]
+		if p.Name > l.Name || p.Name == l.Name && p.UpdateIndex <= l.UpdateIndex {
+			return ErrMalformedTable
+		}
+	}
+
+	return nil
+}
+
+func encodeHeader(t *Table, blockSize int) []byte {
+	var buf bytes.Buffer
+	buf.Write(signature)
+	if hash.Size == 20 {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(2)
+	}
+
+	buf.Write(uint24(uint32(blockSize)))
+	_ = binary.WriteUint64(&buf, t.MinUpdateIndex)
+	_ = binary.WriteUint64(&buf, t.MaxUpdateIndex)
+	if hash.Size != 20 {
+		buf.Write(hashIDSHA256[:])
+	}
+
+	return buf.Bytes()
+}
+
+// tableEncoder writes the blocks of a table, keeping track of the position
+// of the log blocks for the footer.
+type tableEncoder struct {
+	w         io.Writer
+	blockSize int
+	header    []byte
+
+	// offset is the number of bytes written, without the padding which is
+	// only written if another block follows.
+	offset     int
+	padding    int
+	hasLogs    bool
+	logsOffset int
+}
+
+func (e *tableEncoder) newBlock(typ byte) *blockWriter {
+	var prefix []byte
+	if e.offset == 0 {
+		prefix = e.header
+	}
+
+	return newBlockWriter(typ, prefix, e.blockSize)
+}
+
+func (e *tableEncoder) writeRefs(t *Table) error {
+	b := e.newBlock(blockTypeRef)
+	for _, r := range t.Refs {
+		key, typ, value := encodeRef(r, t.MinUpdateIndex)
+		if b.add(key, typ, value) {
+			continue
+		}
+
+		if err := e.flush(b); err != nil {
+			return err
+		}
+
+		b = e.newBlock(blockTypeRef)
+		if !b.add(key, typ, value) {
+			return ErrRecordTooLarge
+		}
+	}
+
+	return e.flush(b)
+}
+
+func (e *tableEncoder) writeLogs(t *Table) error {
+	b := e.newBlock(blockTypeLog)
+	for _, l := range t.Logs {
+		key, typ, value := encodeLog(l)
+		if b.add(key, typ, value) {
+			continue
+		}
+
+		if err := e.flush(b); err != nil {
+			return err
+		}
+
+		b = e.newBlock(blockTypeLog)
+		b.add(key, typ, value)
+	}
+
+	return e.flush(b)
+}
+
+// flush writes the block if it has records.
+func (e *tableEncoder) flush(b *blockWriter) error {
+	if b.entries == 0 {
+		return nil
+	}
+
+	data, err := b.finish()
+	if err != nil {
+		return err
+	}
+
+	if e.padding > 0 {
+		if _, err := e.w.Write(make([]byte, e.padding)); err != nil {
+			return err
+		}
+
+		e.offset += e.padding
+		e.padding = 0
+	}
+
+	if b.typ == blockTypeLog && !e.hasLogs {
+		e.hasLogs = true
+		e.logsOffset = e.offset
+	}
+
+	if _, err := e.w.Write(data); err != nil {
+		return err
+	}
+
+	e.offset += len(data)
+	if b.typ != blockTypeLog && len(data) < e.blockSize {
+		e.padding = e.blockSize - len(data)
+	}
+
+	return nil
+}
+
+func (e *tableEncoder) writeFooter() error {
+	var buf bytes.Buffer
+	if e.offset == 0 {
+		buf.Write(e.header)
+	}
+
+	buf.Write(e.header)
+	for _, pos := range []uint64{0, 0, 0, uint64(e.logsOffset), 0} {
+		_ = binary.WriteUint64(&buf, pos)
+	}
+
+	footer := buf.Bytes()[buf.Len()-len(e.header)-40:]
+	_ = binary.WriteUint32(&buf, crc32.ChecksumIEEE(footer))
+
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+func encodeRef(r *RefRecord, minUpdateIndex uint64) ([]byte, byte, []byte) {
+	var buf bytes.Buffer
+	typ := r.valueType()
+	_ = binary.WriteVariableWidthInt(&buf, int64(r.UpdateIndex-minUpdateIndex))
+	switch typ {
+	case refValue:
+		buf.Write(r.Hash[:])
+	case refPeeled:
+		buf.Write(r.Hash[:])
+		buf.Write(r.Peeled[:])
+	case refSymbolic:
+		writeString(&buf, r.Target.String())
+	}
+
+	return []byte(r.Name), typ, buf.Bytes()
+}
+
+func encodeLog(l *LogRecord) ([]byte, byte, []byte) {
+	key := make([]byte, len(l.Name)+9)
+	copy(key, l.Name)
+	encbin.BigEndian.PutUint64(key[len(l.Name)+1:], math.MaxUint64-l.UpdateIndex)
+	if l.Entry == nil {
+		return key, logDeletion, nil
+	}
+
+	var buf bytes.Buffer
+	writeEntry(&buf, l.Entry)
+	return key, logUpdate, buf.Bytes()
+}
+
+func writeEntry(buf *bytes.Buffer, e *reflog.Entry) {
+	buf.Write(e.Old[:])
+	buf.Write(e.New[:])
+	writeString(buf, e.Committer.Name)
+	writeString(buf, e.Committer.Email)
+
+	when := e.Committer.When
+	ts := when.Unix()
+	if ts < 0 {
+		ts = 0
+	}
+
+	_, offset := when.Zone()
+	_ = binary.WriteVariableWidthInt(buf, ts)
+	_ = binary.WriteUint16(buf, uint16(int16(offset/60)))
+
+	// Like git, the message is kept in a single line, ending with a line
+	// feed.
+	msg := strings.Join(strings.Fields(e.Message), " ")
+	writeString(buf, msg+"\n")
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	_ = binary.WriteVariableWidthInt(buf, int64(len(s)))
+	buf.WriteString(s)
+}
+
+// blockWriter builds a block, compressing the keys of its records and
+// adding a restart point every restartInterval records.
+type blockWriter struct {
+	typ       byte
+	buf       []byte
+	headerLen int
+	blockSize int
+	entries   int
+	restarts  []int
+	lastKey   []byte
+}
+
+// newBlockWriter returns a block writer of the given type. The prefix is
+// the file header for the first block of a table.
+func newBlockWriter(typ byte, prefix []byte, blockSize int) *blockWriter {
+	buf := append(append([]byte(nil), prefix...), typ, 0, 0, 0)
+	return &blockWriter{typ: typ, buf: buf, headerLen: len(buf), blockSize: blockSize}
+}
+
+// add adds the record to the block, returning false if it does not fit.
+func (b *blockWriter) add(key []byte, typ byte, value []byte) bool {
+	restart := b.entries%restartInterval == 0
+	prefix := 0
+	if !restart {
+		for prefix < len(key) && prefix < len(b.lastKey) && key[prefix] == b.lastKey[prefix] {
+			prefix++
+		}
+	}
+
+	var rec bytes.Buffer
+	_ = binary.WriteVariableWidthInt(&rec, int64(prefix))
+	_ = binary.WriteVariableWidthInt(&rec, int64((len(key)-prefix)<<3|int(typ)))
+	rec.Write(key[prefix:])
+	rec.Write(value)
+
+	restarts := len(b.restarts)
+	if restart {
+		restarts++
+	}
+
+	// A log record is added to an empty block even if it does not fit, the
+	// length of log blocks being only limited by their 3-byte length.
+	fits := len(b.buf)+rec.Len()+3*restarts+2 <= b.blockSize
+	if !fits && (b.entries > 0 || b.typ != blockTypeLog) {
+		return false
+	}
+
+	if restart {
+		b.restarts = append(b.restarts, len(b.buf))
+	}
+
+	b.buf = append(b.buf, rec.Bytes()...)
+	b.lastKey = append(b.lastKey[:0], key...)
+	b.entries++
+	return true
+}
+
+// finish returns the block with its restart points and its length, and
+// compresses it if it is a log block.
+func (b *blockWriter) finish() ([]byte, error) {
+	if len(b.restarts) > math.MaxUint16 {
+		return nil, ErrRecordTooLarge
+	}
+
+	for _, r := range b.restarts {
+		b.buf = append(b.buf, uint24(uint32(r))...)
+	}
+
+	b.buf = encbin.BigEndian.AppendUint16(b.buf, uint16(len(b.restarts)))
+	if len(b.buf) > maxBlockSize {
+		return nil, ErrRecordTooLarge
+	}
+
+	copy(b.buf[b.headerLen-3:], uint24(uint32(len(b.buf))))
+	if b.typ != blockTypeLog {
+		return b.buf, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Write(b.buf[:b.headerLen])
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := zw.Write(b.buf[b.headerLen:]); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func uint24(v uint32) []byte {
+	return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+}
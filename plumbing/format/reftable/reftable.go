@@ -0,0 +1,212 @@
+package reftable
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+)
+
+const (
+	// DefaultBlockSize is the block size of the tables written by git.
+	DefaultBlockSize = 4096
+
+	maxBlockSize    = 1<<24 - 1
+	restartInterval = 16
+
+	blockTypeRef = 'r'
+	blockTypeLog = 'g'
+
+	refDeletion = 0
+	refValue    = 1
+	refPeeled   = 2
+	refSymbolic = 3
+
+	logDeletion = 0
+	logUpdate   = 1
+)
+
+var (
+	signature = []byte{'R', 'E', 'F', 'T'}
+
+	hashIDSHA1   = [4]byte{'s', 'h', 'a', '1'}
+	hashIDSHA256 = [4]byte{'s', '2', '5', '6'}
+
+	// ErrMalformedTable is returned when the table is not valid.
+	ErrMalformedTable = errors.New("malformed reftable")
+	// ErrUnsupportedVersion is returned when the version or the hash
+	// function of the table is not supported.
+	ErrUnsupportedVersion = errors.New("unsupported reftable version")
+	// ErrRecordTooLarge is returned when a ref record does not fit in a
+	// block.
+	ErrRecordTooLarge = errors.New("reftable record larger than the block size")
+)
+
+// Table is a reftable, with the records written by the transactions whose
+// update indexes are between MinUpdateIndex and MaxUpdateIndex.
+type Table struct {
+	MinUpdateIndex uint64
+	MaxUpdateIndex uint64
+	// Refs are the references, sorted by name.
+	Refs []*RefRecord
+	// Logs are the reflog entries, sorted by reference name, and newest
+	// first.
+	Logs []*LogRecord
+}
+
+// RefRecord is the value of a reference in a table.
+type RefRecord struct {
+	// Name is the name of the reference.
+	Name plumbing.ReferenceName
+	// UpdateIndex is the update index of the transaction which set it.
+	UpdateIndex uint64
+	// Deleted is true if the reference was deleted. The record then hides
+	// the ones of the older tables.
+	Deleted bool
+	// Hash is the object the reference points to.
+	Hash plumbing.Hash
+	// Peeled is the object the tag the reference points to points to, or
+	// ZeroHash if it is unknown.
+	Peeled plumbing.Hash
+	// Target is the target of a symbolic reference.
+	Target plumbing.ReferenceName
+}
+
+// NewRefRecord returns the record of the given reference.
+func NewRefRecord(ref *plumbing.Reference, updateIndex uint64) *RefRecord {
+	r := &RefRecord{Name: ref.Name(), UpdateIndex: updateIndex}
+	if ref.Type() == plumbing.SymbolicReference {
+		r.Target = ref.Target()
+	} else {
+		r.Hash = ref.Hash()
+	}
+
+	return r
+}
+
+// Reference returns the reference of the record, or nil if it is deleted.
+func (r *RefRecord) Reference() *plumbing.Reference {
+	switch {
+	case r.Deleted:
+		return nil
+	case r.Target != "":
+		return plumbing.NewSymbolicReference(r.Name, r.Target)
+	default:
+		return plumbing.NewHashReference(r.Name, r.Hash)
+	}
+}
+
+func (r *RefRecord) valueType() byte {
+	switch {
+	case r.Deleted:
+		return refDeletion
+	case r.Target != "":
+		return refSymbolic
+	case !r.Peeled.IsZero():
+		return refPeeled
+	default:
+		return refValue
+	}
+}
+
+// LogRecord is an entry of the reflog of a reference in a table.
+type LogRecord struct {
+	// Name is the name of the reference.
+	Name plumbing.ReferenceName
+	// UpdateIndex is the update index of the transaction which added it.
+	UpdateIndex uint64
+	// Entry is the entry of the reflog, or nil for a deletion, which hides
+	// the record with the same name and update index of the older tables.
+	Entry *reflog.Entry
+}
+
+// Ref returns the record of the reference with the given name.
+func (t *Table) Ref(name plumbing.ReferenceName) (*RefRecord, bool) {
+	i := sort.Search(len(t.Refs), func(i int) bool {
+		return t.Refs[i].Name >= name
+	})
+
+	if i < len(t.Refs) && t.Refs[i].Name == name {
+		return t.Refs[i], true
+	}
+
+	return nil, false
+}
+
+// RefLogs returns the log records of the reference with the given name,
+// newest first.
+func (t *Table) RefLogs(name plumbing.ReferenceName) []*LogRecord {
+	i := sort.Search(len(t.Logs), func(i int) bool {
+		return t.Logs[i].Name >= name
+	})
+
+	j := i
+	for j < len(t.Logs) && t.Logs[j].Name == name {
+		j++
+	}
+
+	return t.Logs[i:j]
+}
+
+type logKey struct {
+	name        plumbing.ReferenceName
+	updateIndex uint64
+}
+
+// Merge returns the table with the records of the given tables, oldest
+// first, the records of a table replacing the ones of the older tables
+// with the same keys. The deletions are dropped unless keepDeletions is
+// set, which is needed if the result does not replace all the tables of a
+// stack down to the oldest one.
+func Merge(tables []*Table, keepDeletions bool) *Table {
+	merged := &Table{}
+	refs := make(map[plumbing.ReferenceName]*RefRecord)
+	logs := make(map[logKey]*LogRecord)
+	for i, t := range tables {
+		if i == 0 || t.MinUpdateIndex < merged.MinUpdateIndex {
+			merged.MinUpdateIndex = t.MinUpdateIndex
+		}
+
+		if t.MaxUpdateIndex > merged.MaxUpdateIndex {
+			merged.MaxUpdateIndex = t.MaxUpdateIndex
+		}
+
+		for _, r := range t.Refs {
+			refs[r.Name] = r
+		}
+
+		for _, l := range t.Logs {
+			logs[logKey{l.Name, l.UpdateIndex}] = l
+		}
+	}
+
+	for _, r := range refs {
+		if keepDeletions || !r.Deleted {
+			merged.Refs = append(merged.Refs, r)
+		}
+	}
+
+	for _, l := range logs {
+		if keepDeletions || l.Entry != nil {
+			merged.Logs = append(merged.Logs, l)
+		}
+	}
+
+	sort.Slice(merged.Refs, func(i, j int) bool {
+		return merged.Refs[i].Name < merged.Refs[j].Name
+	})
+
+	sortLogs(merged.Logs)
+	return merged
+}
+
+func sortLogs(logs []*LogRecord) {
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].Name != logs[j].Name {
+			return logs[i].Name < logs[j].Name
+		}
+
+		return logs[i].UpdateIndex > logs[j].UpdateIndex
+	})
+}
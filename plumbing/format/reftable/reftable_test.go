@@ -0,0 +1,303 @@
+package reftable
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ReftableSuite struct{}
+
+var _ = Suite(&ReftableSuite{})
+
+var (
+	hashA = plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	hashB = plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+)
+
+func (s *ReftableSuite) encode(c *C, t *Table, blockSize int) []byte {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.blockSize = blockSize
+	c.Assert(e.Encode(t), IsNil)
+	return buf.Bytes()
+}
+
+func (s *ReftableSuite) decode(c *C, data []byte) *Table {
+	t := &Table{}
+	c.Assert(NewDecoder(bytes.NewReader(data)).Decode(t), IsNil)
+	return t
+}
+
+func (s *ReftableSuite) entry(msg string, when int64) *reflog.Entry {
+	return &reflog.Entry{
+		Old: hashA,
+		New: hashB,
+		Committer: reflog.Signature{
+			Name:  "John Doe",
+			Email: "john@doe.com",
+			When:  time.Unix(when, 0).In(time.FixedZone("", -(2*60+30)*60)),
+		},
+		Message: msg,
+	}
+}
+
+func (s *ReftableSuite) TestEncodeSymbolicRef(c *C) {
+	data := s.encode(c, &Table{
+		MinUpdateIndex: 1,
+		MaxUpdateIndex: 1,
+		Refs: []*RefRecord{
+			{Name: plumbing.HEAD, UpdateIndex: 1, Target: "refs/heads/main"},
+		},
+	}, DefaultBlockSize)
+
+	header := "52454654" + "01" + "001000" + "0000000000000001" + "0000000000000001"
+	block := "72" + "000038" +
+		"00" + "23" + hex.EncodeToString([]byte("HEAD")) + "00" +
+		"0f" + hex.EncodeToString([]byte("refs/heads/main")) +
+		"00001c" + "0001"
+
+	c.Assert(hex.EncodeToString(data[:56]), Equals, header+block)
+	c.Assert(data, HasLen, 56+68)
+	c.Assert(hex.EncodeToString(data[56:80]), Equals, header)
+	c.Assert(data[80:120], DeepEquals, make([]byte, 40))
+
+	t := s.decode(c, data)
+	c.Assert(t.Refs, HasLen, 1)
+	c.Assert(t.Refs[0].Reference(), DeepEquals,
+		plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main"))
+	c.Assert(t.Logs, HasLen, 0)
+}
+
+func (s *ReftableSuite) TestEncodeDecode(c *C) {
+	t := &Table{
+		MinUpdateIndex: 3,
+		MaxUpdateIndex: 5,
+		Refs: []*RefRecord{
+			{Name: plumbing.HEAD, UpdateIndex: 3, Target: "refs/heads/main"},
+			{Name: "refs/heads/main", UpdateIndex: 5, Hash: hashA},
+			{Name: "refs/heads/old", UpdateIndex: 4, Deleted: true},
+			{Name: "refs/tags/v1", UpdateIndex: 4, Hash: hashB, Peeled: hashA},
+		},
+		Logs: []*LogRecord{
+			{Name: plumbing.HEAD, UpdateIndex: 5, Entry: s.entry("commit: second", 1700000100)},
+			{Name: plumbing.HEAD, UpdateIndex: 3, Entry: s.entry("commit (initial): first", 1700000000)},
+			{Name: "refs/heads/main", UpdateIndex: 2},
+		},
+	}
+
+	data := s.encode(c, t, DefaultBlockSize)
+	decoded := s.decode(c, data)
+	c.Assert(decoded.MinUpdateIndex, Equals, uint64(3))
+	c.Assert(decoded.MaxUpdateIndex, Equals, uint64(5))
+	c.Assert(decoded.Refs, DeepEquals, t.Refs)
+	c.Assert(decoded.Logs, HasLen, 3)
+
+	for i, l := range decoded.Logs {
+		c.Assert(l.Name, Equals, t.Logs[i].Name)
+		c.Assert(l.UpdateIndex, Equals, t.Logs[i].UpdateIndex)
+		if t.Logs[i].Entry == nil {
+			c.Assert(l.Entry, IsNil)
+			continue
+		}
+
+		e := t.Logs[i].Entry
+		c.Assert(l.Entry.Old, Equals, e.Old)
+		c.Assert(l.Entry.New, Equals, e.New)
+		c.Assert(l.Entry.Committer.Name, Equals, e.Committer.Name)
+		c.Assert(l.Entry.Committer.Email, Equals, e.Committer.Email)
+		c.Assert(l.Entry.Committer.When.Format(time.RFC3339), Equals,
+			e.Committer.When.Format(time.RFC3339))
+		c.Assert(l.Entry.Message, Equals, e.Message)
+	}
+}
+
+func (s *ReftableSuite) TestEncodeDecodeBlocks(c *C) {
+	t := &Table{MinUpdateIndex: 1, MaxUpdateIndex: 1}
+	for i := 0; i < 200; i++ {
+		name := plumbing.ReferenceName(fmt.Sprintf("refs/heads/branch-%03d", i))
+		t.Refs = append(t.Refs, &RefRecord{Name: name, UpdateIndex: 1, Hash: hashA})
+		t.Logs = append(t.Logs, &LogRecord{Name: name, UpdateIndex: 1, Entry: s.entry("branch: Created from HEAD", 1700000000)})
+	}
+
+	data := s.encode(c, t, 256)
+
+	// The ref blocks are padded to the block size, but the last one.
+	c.Assert(data[256], Equals, byte(blockTypeRef))
+	c.Assert(data[512], Equals, byte(blockTypeRef))
+
+	decoded := s.decode(c, data)
+	c.Assert(decoded.Refs, DeepEquals, t.Refs)
+	c.Assert(decoded.Logs, HasLen, 200)
+	for i, l := range decoded.Logs {
+		c.Assert(l.Name, Equals, t.Logs[i].Name)
+		c.Assert(l.Entry.Message, Equals, "branch: Created from HEAD")
+	}
+}
+
+func (s *ReftableSuite) TestEncodeDecodeOnlyLogs(c *C) {
+	t := &Table{
+		MinUpdateIndex: 1,
+		MaxUpdateIndex: 1,
+		Logs: []*LogRecord{
+			{Name: "refs/heads/main", UpdateIndex: 1, Entry: s.entry("reset: moving to HEAD~", 1700000000)},
+		},
+	}
+
+	data := s.encode(c, t, DefaultBlockSize)
+	c.Assert(data[headerSizeV1], Equals, byte(blockTypeLog))
+
+	decoded := s.decode(c, data)
+	c.Assert(decoded.Refs, HasLen, 0)
+	c.Assert(decoded.Logs, HasLen, 1)
+	c.Assert(decoded.Logs[0].Entry.Message, Equals, "reset: moving to HEAD~")
+}
+
+func (s *ReftableSuite) TestEncodeDecodeEmpty(c *C) {
+	data := s.encode(c, &Table{MinUpdateIndex: 7, MaxUpdateIndex: 7}, DefaultBlockSize)
+	c.Assert(data, HasLen, 24+68)
+
+	decoded := s.decode(c, data)
+	c.Assert(decoded.MinUpdateIndex, Equals, uint64(7))
+	c.Assert(decoded.Refs, HasLen, 0)
+	c.Assert(decoded.Logs, HasLen, 0)
+}
+
+func (s *ReftableSuite) TestEncodeLargeLog(c *C) {
+	msg := string(bytes.Repeat([]byte("x"), 1000))
+	t := &Table{
+		MinUpdateIndex: 1,
+		MaxUpdateIndex: 1,
+		Refs:           []*RefRecord{{Name: "refs/heads/main", UpdateIndex: 1, Hash: hashA}},
+		Logs:           []*LogRecord{{Name: "refs/heads/main", UpdateIndex: 1, Entry: s.entry(msg, 1)}},
+	}
+
+	decoded := s.decode(c, s.encode(c, t, 256))
+	c.Assert(decoded.Logs[0].Entry.Message, Equals, msg)
+
+	t.Refs[0].Name = plumbing.ReferenceName("refs/heads/" + msg)
+	t.Logs = nil
+	err := NewEncoder(&bytes.Buffer{}).Encode(t)
+	c.Assert(err, IsNil)
+
+	e := NewEncoder(&bytes.Buffer{})
+	e.blockSize = 256
+	c.Assert(e.Encode(t), Equals, ErrRecordTooLarge)
+}
+
+func (s *ReftableSuite) TestEncodeUnsorted(c *C) {
+	t := &Table{
+		MinUpdateIndex: 1,
+		MaxUpdateIndex: 1,
+		Refs: []*RefRecord{
+			{Name: "refs/heads/b", UpdateIndex: 1, Hash: hashA},
+			{Name: "refs/heads/a", UpdateIndex: 1, Hash: hashA},
+		},
+	}
+
+	c.Assert(NewEncoder(&bytes.Buffer{}).Encode(t), Equals, ErrMalformedTable)
+
+	t.Refs = t.Refs[:1]
+	t.Refs[0].UpdateIndex = 2
+	c.Assert(NewEncoder(&bytes.Buffer{}).Encode(t), Equals, ErrMalformedTable)
+}
+
+func (s *ReftableSuite) TestDecodeMalformed(c *C) {
+	data := s.encode(c, &Table{
+		MinUpdateIndex: 1,
+		MaxUpdateIndex: 1,
+		Refs:           []*RefRecord{{Name: "refs/heads/main", UpdateIndex: 1, Hash: hashA}},
+	}, DefaultBlockSize)
+
+	for i, tc := range []struct {
+		change func([]byte)
+		err    error
+	}{
+		{func(b []byte) { b[0] = 'X' }, ErrMalformedTable},
+		{func(b []byte) { b[4] = 3 }, ErrUnsupportedVersion},
+		{func(b []byte) { b[len(b)-1]++ }, ErrMalformedTable},
+		{func(b []byte) { b[29] = 0xff }, ErrMalformedTable},
+	} {
+		corrupted := append([]byte(nil), data...)
+		tc.change(corrupted)
+
+		err := NewDecoder(bytes.NewReader(corrupted)).Decode(&Table{})
+		c.Assert(err, Equals, tc.err, Commentf("case %d", i))
+	}
+
+	err := NewDecoder(bytes.NewReader(data[:50])).Decode(&Table{})
+	c.Assert(err, Equals, ErrMalformedTable)
+}
+
+func (s *ReftableSuite) TestTableLookup(c *C) {
+	t := &Table{
+		Refs: []*RefRecord{
+			{Name: "refs/heads/a", Hash: hashA},
+			{Name: "refs/heads/b", Hash: hashB},
+		},
+		Logs: []*LogRecord{
+			{Name: "refs/heads/a", UpdateIndex: 2},
+			{Name: "refs/heads/b", UpdateIndex: 3},
+			{Name: "refs/heads/b", UpdateIndex: 1},
+		},
+	}
+
+	r, ok := t.Ref("refs/heads/b")
+	c.Assert(ok, Equals, true)
+	c.Assert(r.Hash, Equals, hashB)
+
+	_, ok = t.Ref("refs/heads/c")
+	c.Assert(ok, Equals, false)
+
+	logs := t.RefLogs("refs/heads/b")
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].UpdateIndex, Equals, uint64(3))
+	c.Assert(t.RefLogs("refs/heads/c"), HasLen, 0)
+}
+
+func (s *ReftableSuite) TestMerge(c *C) {
+	older := &Table{
+		MinUpdateIndex: 1,
+		MaxUpdateIndex: 2,
+		Refs: []*RefRecord{
+			{Name: "refs/heads/a", UpdateIndex: 1, Hash: hashA},
+			{Name: "refs/heads/b", UpdateIndex: 2, Hash: hashA},
+		},
+		Logs: []*LogRecord{
+			{Name: "refs/heads/a", UpdateIndex: 1, Entry: s.entry("a", 1)},
+			{Name: "refs/heads/b", UpdateIndex: 2, Entry: s.entry("b", 2)},
+		},
+	}
+
+	newer := &Table{
+		MinUpdateIndex: 3,
+		MaxUpdateIndex: 3,
+		Refs: []*RefRecord{
+			{Name: "refs/heads/a", UpdateIndex: 3, Hash: hashB},
+			{Name: "refs/heads/b", UpdateIndex: 3, Deleted: true},
+		},
+		Logs: []*LogRecord{
+			{Name: "refs/heads/a", UpdateIndex: 3, Entry: s.entry("a2", 3)},
+			{Name: "refs/heads/b", UpdateIndex: 2},
+		},
+	}
+
+	t := Merge([]*Table{older, newer}, false)
+	c.Assert(t.MinUpdateIndex, Equals, uint64(1))
+	c.Assert(t.MaxUpdateIndex, Equals, uint64(3))
+	c.Assert(t.Refs, DeepEquals, []*RefRecord{newer.Refs[0]})
+	c.Assert(t.Logs, DeepEquals, []*LogRecord{newer.Logs[0], older.Logs[0]})
+
+	t = Merge([]*Table{older, newer}, true)
+	c.Assert(t.Refs, DeepEquals, newer.Refs)
+	c.Assert(t.Logs, HasLen, 3)
+}
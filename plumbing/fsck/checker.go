@@ -0,0 +1,144 @@
+package fsck
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// ObjectChecker checks the syntax of the objects. The .gitmodules blobs are
+// only known from the trees referencing them, so the ones which were not
+// checked after their trees are checked by Finish.
+type ObjectChecker struct {
+	opts Options
+	// gitmodules are the .gitmodules blobs found in the trees, true once
+	// they are checked.
+	gitmodules map[plumbing.Hash]bool
+}
+
+// NewObjectChecker returns a new ObjectChecker with the given options, which
+// may be nil.
+func NewObjectChecker(opts *Options) *ObjectChecker {
+	c := &ObjectChecker{gitmodules: make(map[plumbing.Hash]bool)}
+	if opts != nil {
+		c.opts = *opts
+	}
+
+	return c
+}
+
+// Check checks the content of the object with the given hash and type, and
+// returns its problems. The content of the blobs is only needed for the
+// .gitmodules blobs already found in a tree, it may be nil otherwise.
+func (c *ObjectChecker) Check(h plumbing.Hash, t plumbing.ObjectType, data []byte) []*Problem {
+	r := &report{opts: &c.opts, hash: h, typ: t}
+	switch t {
+	case plumbing.CommitObject:
+		checkCommit(r, data)
+	case plumbing.TreeObject:
+		c.checkTree(r, data)
+	case plumbing.TagObject:
+		checkTag(r, data)
+	}
+
+	done, ok := c.gitmodules[h]
+	if !ok || done {
+		return r.problems
+	}
+
+	if t != plumbing.BlobObject {
+		r.add(GitmodulesBlob, "non-blob found at .gitmodules")
+	} else if data != nil {
+		checkGitmodules(r, data)
+	} else {
+		return r.problems
+	}
+
+	c.gitmodules[h] = true
+	return r.problems
+}
+
+// Finish checks the .gitmodules blobs found in the trees which were not
+// checked yet, reading them from the given storer.
+func (c *ObjectChecker) Finish(s storer.EncodedObjectStorer) ([]*Problem, error) {
+	var pending []plumbing.Hash
+	for h, done := range c.gitmodules {
+		if !done {
+			pending = append(pending, h)
+		}
+	}
+
+	sort.Sort(plumbing.HashSlice(pending))
+
+	var problems []*Problem
+	for _, h := range pending {
+		c.gitmodules[h] = true
+
+		o, err := s.EncodedObject(plumbing.AnyObject, h)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			r := &report{opts: &c.opts, hash: h, typ: plumbing.BlobObject}
+			r.add(GitmodulesMissing, "unable to read .gitmodules blob")
+			problems = append(problems, r.problems...)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		r := &report{opts: &c.opts, hash: h, typ: o.Type()}
+		if o.Type() != plumbing.BlobObject {
+			r.add(GitmodulesBlob, "non-blob found at .gitmodules")
+		} else {
+			data, err := readObject(o)
+			if err != nil {
+				return nil, err
+			}
+
+			checkGitmodules(r, data)
+		}
+
+		problems = append(problems, r.problems...)
+	}
+
+	return problems, nil
+}
+
+func readObject(o plumbing.EncodedObject) (data []byte, err error) {
+	rd, err := o.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(rd, &err)
+	return io.ReadAll(rd)
+}
+
+// report collects the problems of an object.
+type report struct {
+	opts     *Options
+	hash     plumbing.Hash
+	typ      plumbing.ObjectType
+	problems []*Problem
+}
+
+// add adds a problem, unless its severity is Ignore.
+func (r *report) add(id ID, format string, args ...interface{}) {
+	s := r.opts.Severity(id)
+	if s == Ignore {
+		return
+	}
+
+	r.problems = append(r.problems, &Problem{
+		ID:       id,
+		Severity: s,
+		Hash:     r.hash,
+		Type:     r.typ,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
@@ -0,0 +1,241 @@
+package fsck
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/hash"
+)
+
+// checkCommit checks the headers of a commit. Like git, the check stops at
+// the first problem.
+func checkCommit(r *report, data []byte) {
+	if !checkHeaders(r, data) {
+		return
+	}
+
+	data, ok := checkHashLine(data, "tree ")
+	if !ok {
+		if !bytes.HasPrefix(data, []byte("tree ")) {
+			r.add(MissingTree, "invalid format - expected 'tree' line")
+		} else {
+			r.add(BadTreeSha1, "invalid 'tree' line format - bad sha1")
+		}
+
+		return
+	}
+
+	for bytes.HasPrefix(data, []byte("parent ")) {
+		if data, ok = checkHashLine(data, "parent "); !ok {
+			r.add(BadParentSha1, "invalid 'parent' line format - bad sha1")
+			return
+		}
+	}
+
+	authors := 0
+	for bytes.HasPrefix(data, []byte("author ")) {
+		authors++
+		if data, ok = checkIdent(r, data[len("author "):]); !ok {
+			return
+		}
+	}
+
+	switch {
+	case authors == 0:
+		r.add(MissingAuthor, "invalid format - expected 'author' line")
+		return
+	case authors > 1:
+		r.add(MultipleAuthors, "invalid format - multiple 'author' lines")
+	}
+
+	if !bytes.HasPrefix(data, []byte("committer ")) {
+		r.add(MissingCommitter, "invalid format - expected 'committer' line")
+		return
+	}
+
+	checkIdent(r, data[len("committer "):])
+}
+
+// checkTag checks the headers of a tag. Like git, the check stops at the
+// first problem.
+func checkTag(r *report, data []byte) {
+	if !checkHeaders(r, data) {
+		return
+	}
+
+	data, ok := checkHashLine(data, "object ")
+	if !ok {
+		if !bytes.HasPrefix(data, []byte("object ")) {
+			r.add(MissingObject, "invalid format - expected 'object' line")
+		} else {
+			r.add(BadObjectSha1, "invalid 'object' line format - bad sha1")
+		}
+
+		return
+	}
+
+	value, data, ok := headerLine(data, "type ")
+	if !ok {
+		r.add(MissingTypeEntry, "invalid format - expected 'type' line")
+		return
+	}
+
+	if t, err := plumbing.ParseObjectType(value); err != nil || t > plumbing.TagObject {
+		r.add(BadType, "invalid 'type' value")
+		return
+	}
+
+	value, data, ok = headerLine(data, "tag ")
+	if !ok {
+		r.add(MissingTagEntry, "invalid format - expected 'tag' line")
+		return
+	}
+
+	if plumbing.NewTagReferenceName(value).Validate() != nil {
+		r.add(BadTagName, "invalid 'tag' name: %s", value)
+	}
+
+	if !bytes.HasPrefix(data, []byte("tagger ")) {
+		r.add(MissingTaggerEntry, "invalid format - expected 'tagger' line")
+		return
+	}
+
+	checkIdent(r, data[len("tagger "):])
+}
+
+// checkHeaders checks that the headers are terminated by an empty line, or by
+// the end of the object, and have no NUL byte.
+func checkHeaders(r *report, data []byte) bool {
+	for i, b := range data {
+		if b == 0 {
+			r.add(NulInHeader, "unterminated header: NUL at offset %d", i)
+			return false
+		}
+
+		if b == '\n' && i+1 < len(data) && data[i+1] == '\n' {
+			return true
+		}
+	}
+
+	if len(data) > 0 && data[len(data)-1] == '\n' {
+		return true
+	}
+
+	r.add(UnterminatedHeader, "unterminated header")
+	return false
+}
+
+// headerLine returns the value of the header line with the given prefix, and
+// what follows the line.
+func headerLine(data []byte, prefix string) (string, []byte, bool) {
+	if !bytes.HasPrefix(data, []byte(prefix)) {
+		return "", data, false
+	}
+
+	eol := bytes.IndexByte(data, '\n')
+	if eol < 0 {
+		return "", data, false
+	}
+
+	return string(data[len(prefix):eol]), data[eol+1:], true
+}
+
+// checkHashLine checks that the line with the given prefix holds a hash, and
+// returns what follows it.
+func checkHashLine(data []byte, prefix string) ([]byte, bool) {
+	value, rest, ok := headerLine(data, prefix)
+	if !ok || len(value) != hash.HexSize || !isLowerHex(value) {
+		return data, false
+	}
+
+	return rest, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkIdent checks a signature line, "Name <email> timestamp timezone", as
+// git does, and returns what follows it.
+func checkIdent(r *report, data []byte) ([]byte, bool) {
+	eol := bytes.IndexByte(data, '\n')
+	if eol < 0 {
+		eol = len(data)
+	}
+
+	line, rest := data[:eol], data[min(eol+1, len(data)):]
+	fail := func(id ID, reason string) ([]byte, bool) {
+		r.add(id, "invalid author/committer line - %s", reason)
+		return rest, false
+	}
+
+	if len(line) > 0 && line[0] == '<' {
+		return fail(MissingNameBeforeEmail, "missing name before email")
+	}
+
+	p := bytes.IndexAny(line, "<>")
+	switch {
+	case p < 0:
+		return fail(MissingEmail, "missing email")
+	case line[p] == '>':
+		return fail(BadName, "bad name")
+	case line[p-1] != ' ':
+		return fail(MissingSpaceBeforeEmail, "missing space before email")
+	}
+
+	line = line[p+1:]
+	p = bytes.IndexAny(line, "<>")
+	if p < 0 || line[p] != '>' {
+		return fail(BadEmail, "bad email")
+	}
+
+	line = line[p+1:]
+	if len(line) == 0 || line[0] != ' ' {
+		return fail(MissingSpaceBeforeDate, "missing space before date")
+	}
+
+	line = line[1:]
+	digits := 0
+	for digits < len(line) && line[digits] >= '0' && line[digits] <= '9' {
+		digits++
+	}
+
+	if digits > 1 && line[0] == '0' {
+		return fail(ZeroPaddedDate, "zero-padded date")
+	}
+
+	if digits > 0 {
+		if _, err := strconv.ParseInt(string(line[:digits]), 10, 64); err != nil {
+			return fail(BadDateOverflow, "date causes integer overflow")
+		}
+	}
+
+	if digits == 0 || digits == len(line) || line[digits] != ' ' {
+		return fail(BadDate, "bad date")
+	}
+
+	tz := line[digits+1:]
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') || !isDigits(tz[1:]) || eol == len(data) {
+		return fail(BadTimezone, "bad time zone")
+	}
+
+	return rest, true
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,196 @@
+// Package fsck implements the object checks of git fsck: the syntax of the
+// trees, commits and tags, the paths which could attack a checkout and the
+// .gitmodules files. The problems have the message ids of git, and the same
+// default severities.
+package fsck
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+)
+
+// ID identifies a kind of problem, like the message ids of git fsck.
+type ID string
+
+const (
+	BadDate                 ID = "badDate"
+	BadDateOverflow         ID = "badDateOverflow"
+	BadEmail                ID = "badEmail"
+	BadFilemode             ID = "badFilemode"
+	BadName                 ID = "badName"
+	BadObjectSha1           ID = "badObjectSha1"
+	BadParentSha1           ID = "badParentSha1"
+	BadTagName              ID = "badTagName"
+	BadTimezone             ID = "badTimezone"
+	BadTree                 ID = "badTree"
+	BadTreeSha1             ID = "badTreeSha1"
+	BadType                 ID = "badType"
+	DuplicateEntries        ID = "duplicateEntries"
+	EmptyName               ID = "emptyName"
+	FullPathname            ID = "fullPathname"
+	GitmodulesBlob          ID = "gitmodulesBlob"
+	GitmodulesMissing       ID = "gitmodulesMissing"
+	GitmodulesName          ID = "gitmodulesName"
+	GitmodulesParse         ID = "gitmodulesParse"
+	GitmodulesPath          ID = "gitmodulesPath"
+	GitmodulesSymlink       ID = "gitmodulesSymlink"
+	GitmodulesURL           ID = "gitmodulesUrl"
+	GitmodulesUpdate        ID = "gitmodulesUpdate"
+	HasDot                  ID = "hasDot"
+	HasDotdot               ID = "hasDotdot"
+	HasDotgit               ID = "hasDotgit"
+	MissingAuthor           ID = "missingAuthor"
+	MissingCommitter        ID = "missingCommitter"
+	MissingEmail            ID = "missingEmail"
+	MissingNameBeforeEmail  ID = "missingNameBeforeEmail"
+	MissingObject           ID = "missingObject"
+	MissingSpaceBeforeDate  ID = "missingSpaceBeforeDate"
+	MissingSpaceBeforeEmail ID = "missingSpaceBeforeEmail"
+	MissingTagEntry         ID = "missingTagEntry"
+	MissingTaggerEntry      ID = "missingTaggerEntry"
+	MissingTree             ID = "missingTree"
+	MissingTypeEntry        ID = "missingTypeEntry"
+	MultipleAuthors         ID = "multipleAuthors"
+	NulInHeader             ID = "nulInHeader"
+	NullSha1                ID = "nullSha1"
+	TreeNotSorted           ID = "treeNotSorted"
+	UnterminatedHeader      ID = "unterminatedHeader"
+	ZeroPaddedDate          ID = "zeroPaddedDate"
+	ZeroPaddedFilemode      ID = "zeroPaddedFilemode"
+
+	// The following ids have no equivalent in git, which reports these
+	// problems as fatal errors.
+
+	// HashMismatch is an object which doesn't hash to its name.
+	HashMismatch ID = "hashMismatch"
+	// CorruptObject is an object which can not be read.
+	CorruptObject ID = "corruptObject"
+	// BadPackChecksum is a packfile with a wrong trailing checksum.
+	BadPackChecksum ID = "badPackChecksum"
+	// BadPackIndexChecksum is a pack index with a wrong checksum.
+	BadPackIndexChecksum ID = "badPackIndexChecksum"
+	// BadIndexChecksum is an index of the worktree with a wrong checksum.
+	BadIndexChecksum ID = "badIndexChecksum"
+)
+
+// Severity is the severity of a problem.
+type Severity int
+
+const (
+	// Ignore drops the problems.
+	Ignore Severity = iota
+	// Info problems are only reported.
+	Info
+	// Warning problems are reported, and are errors in strict mode.
+	Warning
+	// Error problems make the checked objects invalid.
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Ignore:
+		return "ignore"
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// ErrInvalidSeverity is returned by ParseSeverity for an unknown severity.
+var ErrInvalidSeverity = errors.New("invalid fsck severity")
+
+// ParseSeverity parses a severity as written in the config of git: "error",
+// "warn", "info" or "ignore".
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "ignore":
+		return Ignore, nil
+	case "info":
+		return Info, nil
+	case "warn", "warning":
+		return Warning, nil
+	case "error":
+		return Error, nil
+	}
+
+	return Ignore, fmt.Errorf("%w: %s", ErrInvalidSeverity, s)
+}
+
+// defaultSeverities are the severities of the problems which are not errors,
+// as in git.
+var defaultSeverities = map[ID]Severity{
+	BadFilemode:        Info,
+	BadTagName:         Info,
+	EmptyName:          Warning,
+	FullPathname:       Warning,
+	GitmodulesParse:    Info,
+	HasDot:             Warning,
+	HasDotdot:          Warning,
+	HasDotgit:          Warning,
+	MissingTaggerEntry: Info,
+	NullSha1:           Warning,
+	ZeroPaddedFilemode: Warning,
+}
+
+// Options describes how the objects are checked.
+type Options struct {
+	// Strict reports the warnings as errors, and rejects the group
+	// writable file modes, like git fsck --strict.
+	Strict bool
+	// Severities overrides the severities of the problems, like the
+	// fsck.<msg-id> options of git. As in the config, the ids are case
+	// insensitive.
+	Severities map[ID]Severity
+}
+
+// Severity returns the severity of the problems with the given id.
+func (o *Options) Severity(id ID) Severity {
+	if s, ok := o.Severities[id]; ok {
+		return s
+	}
+
+	for other, s := range o.Severities {
+		if strings.EqualFold(string(other), string(id)) {
+			return s
+		}
+	}
+
+	s, ok := defaultSeverities[id]
+	if !ok {
+		return Error
+	}
+
+	if s == Warning && o.Strict {
+		return Error
+	}
+
+	return s
+}
+
+// Problem is a problem found in an object.
+type Problem struct {
+	ID       ID
+	Severity Severity
+	// Hash and Type are the object with the problem. For the problems of
+	// the packs, Hash is the pack checksum.
+	Hash    plumbing.Hash
+	Type    plumbing.ObjectType
+	Message string
+}
+
+func (p *Problem) Error() string {
+	if p.Type == plumbing.InvalidObject {
+		return fmt.Sprintf("%s in %s: %s: %s", p.Severity, p.Hash, p.ID, p.Message)
+	}
+
+	return fmt.Sprintf("%s in %s %s: %s: %s", p.Severity, p.Type, p.Hash, p.ID, p.Message)
+}
@@ -0,0 +1,274 @@
+package fsck
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type FsckSuite struct{}
+
+var _ = Suite(&FsckSuite{})
+
+const (
+	someHash = "a8d315b2b1c615d43042c3a62402b8a54288cf5c"
+	ident    = "John Doe <john@example.com> 1700000000 +0100"
+)
+
+// tree encodes the entries of a tree, given as mode and name pairs.
+func tree(entries ...string) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(entries); i += 2 {
+		buf.WriteString(entries[i] + " " + entries[i+1] + "\x00")
+		h := plumbing.NewHash(someHash)
+		buf.Write(h[:])
+	}
+
+	return buf.Bytes()
+}
+
+func ids(problems []*Problem) []ID {
+	var ids []ID
+	for _, p := range problems {
+		ids = append(ids, p.ID)
+	}
+
+	return ids
+}
+
+func (s *FsckSuite) TestValidObjects(c *C) {
+	ch := NewObjectChecker(nil)
+	c.Assert(ch.Check(plumbing.ZeroHash, plumbing.TreeObject,
+		tree("100644", "a", "100644", "b.txt", "40000", "b", "160000", "c")), HasLen, 0)
+	c.Assert(ch.Check(plumbing.ZeroHash, plumbing.CommitObject, []byte(
+		"tree "+someHash+"\nparent "+someHash+"\nauthor "+ident+"\ncommitter "+ident+"\n\nmessage\n")), HasLen, 0)
+	c.Assert(ch.Check(plumbing.ZeroHash, plumbing.TagObject, []byte(
+		"object "+someHash+"\ntype commit\ntag v1.0\ntagger "+ident+"\n\nmessage\n")), HasLen, 0)
+}
+
+func (s *FsckSuite) TestTree(c *C) {
+	for _, t := range []struct {
+		entries []string
+		ids     []ID
+	}{
+		{[]string{"100644", "b", "100644", "a"}, []ID{TreeNotSorted}},
+		{[]string{"40000", "a", "100644", "a.txt"}, []ID{TreeNotSorted}},
+		{[]string{"100644", "a", "100644", "a"}, []ID{DuplicateEntries}},
+		{[]string{"100644", "a", "40000", "a"}, []ID{DuplicateEntries}},
+		{[]string{"100600", "a"}, []ID{BadFilemode}},
+		{[]string{"040000", "a"}, []ID{ZeroPaddedFilemode}},
+		{[]string{"100644", "a/b"}, []ID{FullPathname}},
+		{[]string{"100644", ""}, []ID{EmptyName}},
+		{[]string{"40000", "."}, []ID{HasDot}},
+		{[]string{"40000", ".."}, []ID{HasDotdot}},
+		{[]string{"40000", ".git"}, []ID{HasDotgit}},
+		{[]string{"40000", ".GIT"}, []ID{HasDotgit}},
+		{[]string{"40000", ".git. "}, []ID{HasDotgit}},
+		{[]string{"40000", "GIT~1"}, []ID{HasDotgit}},
+		{[]string{"40000", ".g\u200cit"}, []ID{HasDotgit}},
+		{[]string{"120000", ".gitmodules"}, []ID{GitmodulesSymlink}},
+		{[]string{"100644", "b", "100644", "a", "100644", "a", "100644", ".git"},
+			[]ID{HasDotgit, DuplicateEntries, TreeNotSorted}},
+	} {
+		problems := NewObjectChecker(nil).Check(plumbing.ZeroHash, plumbing.TreeObject, tree(t.entries...))
+		c.Assert(ids(problems), DeepEquals, t.ids, Commentf("%q", t.entries))
+	}
+}
+
+func (s *FsckSuite) TestTreeMalformed(c *C) {
+	for _, data := range []string{"100644 a", "100644 a\x00abc", "abc a\x00" + someHash[:20]} {
+		problems := NewObjectChecker(nil).Check(plumbing.ZeroHash, plumbing.TreeObject, []byte(data))
+		c.Assert(ids(problems), DeepEquals, []ID{BadTree}, Commentf("%q", data))
+	}
+}
+
+func (s *FsckSuite) TestTreeNullSha1(c *C) {
+	data := append([]byte("100644 a\x00"), make([]byte, 20)...)
+	problems := NewObjectChecker(nil).Check(plumbing.ZeroHash, plumbing.TreeObject, data)
+	c.Assert(ids(problems), DeepEquals, []ID{NullSha1})
+	c.Assert(problems[0].Severity, Equals, Warning)
+}
+
+func (s *FsckSuite) TestStrict(c *C) {
+	data := tree("40000", ".git", "100664", "a")
+
+	problems := NewObjectChecker(nil).Check(plumbing.ZeroHash, plumbing.TreeObject, data)
+	c.Assert(ids(problems), DeepEquals, []ID{HasDotgit})
+	c.Assert(problems[0].Severity, Equals, Warning)
+
+	problems = NewObjectChecker(&Options{Strict: true}).Check(plumbing.ZeroHash, plumbing.TreeObject, data)
+	c.Assert(ids(problems), DeepEquals, []ID{HasDotgit, BadFilemode})
+	c.Assert(problems[0].Severity, Equals, Error)
+	c.Assert(problems[1].Severity, Equals, Info)
+}
+
+func (s *FsckSuite) TestSeverities(c *C) {
+	ch := NewObjectChecker(&Options{Severities: map[ID]Severity{
+		HasDotgit:     Ignore,
+		TreeNotSorted: Warning,
+	}})
+
+	problems := ch.Check(plumbing.ZeroHash, plumbing.TreeObject, tree("40000", ".git", "100644", "-"))
+	c.Assert(problems, HasLen, 1)
+	c.Assert(problems[0].ID, Equals, TreeNotSorted)
+	c.Assert(problems[0].Severity, Equals, Warning)
+	c.Assert(problems[0].Error(), Equals,
+		"warning in tree 0000000000000000000000000000000000000000: treeNotSorted: not properly sorted")
+}
+
+func (s *FsckSuite) TestCommit(c *C) {
+	tree := "tree " + someHash + "\n"
+	for _, t := range []struct {
+		data string
+		id   ID
+	}{
+		{"parent " + someHash + "\n", MissingTree},
+		{"tree " + someHash[1:] + "\n", BadTreeSha1},
+		{"tree " + someHash + "x\n", BadTreeSha1},
+		{tree + "parent abc\n", BadParentSha1},
+		{tree + "committer " + ident + "\n", MissingAuthor},
+		{tree + "author " + ident + "\n", MissingCommitter},
+		{tree + "author " + ident + "\nauthor " + ident + "\ncommitter " + ident + "\n", MultipleAuthors},
+		{tree + "author <john@example.com> 1700000000 +0100\n", MissingNameBeforeEmail},
+		{tree + "author John> 1700000000 +0100\n", BadName},
+		{tree + "author John 1700000000 +0100\n", MissingEmail},
+		{tree + "author John<john@example.com> 1700000000 +0100\n", MissingSpaceBeforeEmail},
+		{tree + "author John <john<example.com> 1700000000 +0100\n", BadEmail},
+		{tree + "author John <john@example.com>1700000000 +0100\n", MissingSpaceBeforeDate},
+		{tree + "author John <john@example.com> 01700000000 +0100\n", ZeroPaddedDate},
+		{tree + "author John <john@example.com> 99999999999999999999 +0100\n", BadDateOverflow},
+		{tree + "author John <john@example.com> yesterday +0100\n", BadDate},
+		{tree + "author John <john@example.com> 1700000000 CET\n", BadTimezone},
+		{tree + "author John <john@example.com> 1700000000 +01000\n", BadTimezone},
+		{tree + "author " + ident, UnterminatedHeader},
+		{tree + "author John\x00 <john@example.com> 1700000000 +0100\n", NulInHeader},
+	} {
+		problems := NewObjectChecker(nil).Check(plumbing.ZeroHash, plumbing.CommitObject, []byte(t.data))
+		c.Assert(ids(problems), DeepEquals, []ID{t.id}, Commentf("%q", t.data))
+	}
+}
+
+func (s *FsckSuite) TestTag(c *C) {
+	object := "object " + someHash + "\n"
+	for _, t := range []struct {
+		data string
+		id   ID
+	}{
+		{"type commit\n", MissingObject},
+		{"object abc\n", BadObjectSha1},
+		{object + "tag v1.0\n", MissingTypeEntry},
+		{object + "type ofs-delta\n", BadType},
+		{object + "type commit\ntagger " + ident + "\n", MissingTagEntry},
+		{object + "type commit\ntag v1..0\ntagger " + ident + "\n", BadTagName},
+		{object + "type commit\ntag v1.0\n", MissingTaggerEntry},
+		{object + "type commit\ntag v1.0\ntagger John\n", MissingEmail},
+	} {
+		problems := NewObjectChecker(nil).Check(plumbing.ZeroHash, plumbing.TagObject, []byte(t.data))
+		c.Assert(ids(problems), DeepEquals, []ID{t.id}, Commentf("%q", t.data))
+	}
+}
+
+func (s *FsckSuite) TestGitmodules(c *C) {
+	blob := plumbing.NewHash(someHash)
+	for _, t := range []struct {
+		data string
+		ids  []ID
+	}{
+		{"[submodule \"foo\"]\n\tpath = foo\n\turl = https://example.com/foo\n", nil},
+		{"[submodule \"foo\"]\n\tpath = foo\n\turl = ../foo\n", nil},
+		{"[submodule \"../../hooks\"]\n\tpath = foo\n\turl = ../foo\n", []ID{GitmodulesName}},
+		{"[submodule \"foo\"]\n\tpath = foo\n\turl = --upload-pack=touch\n", []ID{GitmodulesURL}},
+		{"[submodule \"foo\"]\n\tpath = foo\n\turl = ./-x\n", []ID{GitmodulesURL}},
+		{"[submodule \"foo\"]\n\tpath = foo\n\turl = https://example.com%0a.evil\n", []ID{GitmodulesURL}},
+		{"[submodule \"foo\"]\n\tpath = --foo\n\turl = ../foo\n", []ID{GitmodulesPath}},
+		{"[submodule \"foo\"]\n\tpath = foo\n\turl = ../foo\n\tupdate = !rm -rf /\n", []ID{GitmodulesUpdate}},
+		{"[submodule \"foo\"\n", []ID{GitmodulesParse}},
+	} {
+		ch := NewObjectChecker(nil)
+		c.Assert(ch.Check(plumbing.ZeroHash, plumbing.TreeObject, tree("100644", ".gitmodules")), HasLen, 0)
+
+		problems := ch.Check(blob, plumbing.BlobObject, []byte(t.data))
+		c.Assert(ids(problems), DeepEquals, t.ids, Commentf("%q", t.data))
+
+		// The blob is only checked once.
+		problems, err := ch.Finish(memory.NewStorage())
+		c.Assert(err, IsNil)
+		c.Assert(problems, HasLen, 0)
+	}
+}
+
+func (s *FsckSuite) TestGitmodulesNotChecked(c *C) {
+	ch := NewObjectChecker(nil)
+	c.Assert(ch.Check(plumbing.ZeroHash, plumbing.BlobObject, nil), HasLen, 0)
+
+	// A blob is not known to be a .gitmodules until a tree references it.
+	data := []byte("[submodule \"foo\"]\n\tpath = --foo\n")
+	st := memory.NewStorage()
+	o := st.NewEncodedObject()
+	o.SetType(plumbing.BlobObject)
+	w, err := o.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	h, err := st.SetEncodedObject(o)
+	c.Assert(err, IsNil)
+
+	c.Assert(ch.Check(h, plumbing.BlobObject, data), HasLen, 0)
+
+	var buf bytes.Buffer
+	buf.WriteString("100644 .gitmodules\x00")
+	buf.Write(h[:])
+	buf.WriteString("40000 .gitmodules2\x00")
+	missing := plumbing.NewHash(someHash)
+	buf.Write(missing[:])
+	c.Assert(ch.Check(plumbing.ZeroHash, plumbing.TreeObject, buf.Bytes()), HasLen, 0)
+
+	problems, err := ch.Finish(st)
+	c.Assert(err, IsNil)
+	c.Assert(ids(problems), DeepEquals, []ID{GitmodulesPath})
+	c.Assert(problems[0].Hash, Equals, h)
+}
+
+func (s *FsckSuite) TestGitmodulesMissing(c *C) {
+	ch := NewObjectChecker(nil)
+	c.Assert(ch.Check(plumbing.ZeroHash, plumbing.TreeObject, tree("100644", ".gitmodules")), HasLen, 0)
+
+	problems, err := ch.Finish(memory.NewStorage())
+	c.Assert(err, IsNil)
+	c.Assert(ids(problems), DeepEquals, []ID{GitmodulesMissing})
+}
+
+func (s *FsckSuite) TestGitmodulesBlob(c *C) {
+	ch := NewObjectChecker(nil)
+	c.Assert(ch.Check(plumbing.ZeroHash, plumbing.TreeObject, tree("40000", ".gitmodules")), HasLen, 0)
+
+	problems := ch.Check(plumbing.NewHash(someHash), plumbing.TreeObject, tree("100644", "a"))
+	c.Assert(ids(problems), DeepEquals, []ID{GitmodulesBlob})
+}
+
+func (s *FsckSuite) TestParseSeverity(c *C) {
+	for value, expected := range map[string]Severity{
+		"ignore": Ignore,
+		"info":   Info,
+		"warn":   Warning,
+		"ERROR":  Error,
+	} {
+		sev, err := ParseSeverity(value)
+		c.Assert(err, IsNil)
+		c.Assert(sev, Equals, expected)
+	}
+
+	_, err := ParseSeverity("fatal")
+	c.Assert(errors.Is(err, ErrInvalidSeverity), Equals, true)
+
+	opts := &Options{Severities: map[ID]Severity{"hasdotgit": Error}}
+	c.Assert(opts.Severity(HasDotgit), Equals, Error)
+}
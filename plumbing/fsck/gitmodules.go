@@ -0,0 +1,84 @@
+package fsck
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing/format/config"
+)
+
+// dotdotPath matches the paths with a ".." component.
+var dotdotPath = regexp.MustCompile(`(^|[/\\])\.\.([/\\]|$)`)
+
+// checkGitmodules checks the submodules of a .gitmodules blob, rejecting the
+// names, urls, paths and update commands which could attack a clone.
+func checkGitmodules(r *report, data []byte) {
+	cfg := config.New()
+	if err := config.NewDecoder(bytes.NewReader(data)).Decode(cfg); err != nil {
+		r.add(GitmodulesParse, "could not parse gitmodules blob")
+		return
+	}
+
+	for _, s := range cfg.Section("submodule").Subsections {
+		if s.Name == "" || dotdotPath.MatchString(s.Name) {
+			r.add(GitmodulesName, "disallowed submodule name: %s", s.Name)
+		}
+
+		for _, u := range s.Options.GetAll("url") {
+			if !validSubmoduleURL(u) {
+				r.add(GitmodulesURL, "disallowed submodule url: %s", u)
+			}
+		}
+
+		for _, p := range s.Options.GetAll("path") {
+			if isOption(p) {
+				r.add(GitmodulesPath, "disallowed submodule path: %s", p)
+			}
+		}
+
+		for _, u := range s.Options.GetAll("update") {
+			if strings.HasPrefix(u, "!") {
+				r.add(GitmodulesUpdate, "disallowed submodule update setting: %s", u)
+			}
+		}
+	}
+}
+
+// validSubmoduleURL returns false for the urls which could be taken as an
+// option by a command, or which hold a newline once decoded, which could
+// inject credentials.
+func validSubmoduleURL(u string) bool {
+	if isOption(u) {
+		return false
+	}
+
+	decoded, err := url.PathUnescape(u)
+	if err != nil {
+		decoded = u
+	}
+
+	if strings.ContainsAny(decoded, "\r\n") {
+		return false
+	}
+
+	// The relative urls are resolved against the url of the superproject,
+	// what follows their leading dot components must be safe too.
+	rest := u
+	for {
+		switch {
+		case strings.HasPrefix(rest, "./"):
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "../"):
+			rest = rest[3:]
+		default:
+			return rest == u || !isOption(rest)
+		}
+	}
+}
+
+// isOption returns whether the value looks like a command line option.
+func isOption(s string) bool {
+	return strings.HasPrefix(s, "-")
+}
@@ -0,0 +1,156 @@
+package fsck
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	"github.com/jesseduffield/go-git/v5/plumbing/hash"
+)
+
+// checkTree checks the entries of a tree. Like git, each kind of problem is
+// only reported once per tree.
+func (c *ObjectChecker) checkTree(r *report, data []byte) {
+	found := make(map[ID]bool)
+	names := make(map[string]bool)
+	var prev string
+	for i := 0; len(data) > 0; i++ {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp <= 0 || nul < sp || len(data) < nul+1+hash.Size {
+			r.add(BadTree, "cannot be parsed as a tree")
+			return
+		}
+
+		rawMode := string(data[:sp])
+		name := string(data[sp+1 : nul])
+		var h plumbing.Hash
+		copy(h[:], data[nul+1:])
+		data = data[nul+1+hash.Size:]
+
+		m, err := strconv.ParseUint(rawMode, 8, 32)
+		if err != nil {
+			r.add(BadTree, "cannot be parsed as a tree")
+			return
+		}
+
+		mode := filemode.FileMode(m)
+		if h.IsZero() {
+			found[NullSha1] = true
+		}
+
+		if rawMode[0] == '0' {
+			found[ZeroPaddedFilemode] = true
+		}
+
+		if !c.validMode(mode) {
+			found[BadFilemode] = true
+		}
+
+		switch {
+		case name == "":
+			found[EmptyName] = true
+		case strings.Contains(name, "/"):
+			found[FullPathname] = true
+		case name == ".":
+			found[HasDot] = true
+		case name == "..":
+			found[HasDotdot] = true
+		case isDotName(name, ".git", "git~1"):
+			found[HasDotgit] = true
+		case isDotName(name, ".gitmodules", "gitmod~1"):
+			if mode == filemode.Symlink {
+				found[GitmodulesSymlink] = true
+			} else if _, ok := c.gitmodules[h]; !ok {
+				c.gitmodules[h] = false
+			}
+		}
+
+		if names[name] {
+			found[DuplicateEntries] = true
+		}
+
+		names[name] = true
+
+		key := name
+		if mode == filemode.Dir {
+			key += "/"
+		}
+
+		if i > 0 && key < prev {
+			found[TreeNotSorted] = true
+		}
+
+		prev = key
+	}
+
+	for _, p := range treeProblems {
+		if found[p.id] {
+			r.add(p.id, p.message)
+		}
+	}
+}
+
+// treeProblems are the problems of the trees, in the order git reports
+// them.
+var treeProblems = []struct {
+	id      ID
+	message string
+}{
+	{NullSha1, "contains entries pointing to null sha1"},
+	{FullPathname, "contains full pathnames"},
+	{EmptyName, "contains empty pathname"},
+	{HasDot, "contains '.'"},
+	{HasDotdot, "contains '..'"},
+	{HasDotgit, "contains '.git'"},
+	{ZeroPaddedFilemode, "contains zero-padded file modes"},
+	{BadFilemode, "contains bad file modes"},
+	{DuplicateEntries, "contains duplicate file entries"},
+	{TreeNotSorted, "not properly sorted"},
+	{GitmodulesSymlink, ".gitmodules is a symbolic link"},
+}
+
+// validMode returns whether the mode is valid in a tree. The group writable
+// files of very old versions of git are only rejected in strict mode.
+func (c *ObjectChecker) validMode(m filemode.FileMode) bool {
+	switch m {
+	case filemode.Regular, filemode.Executable, filemode.Symlink,
+		filemode.Dir, filemode.Submodule:
+		return true
+	case filemode.Deprecated:
+		return !c.opts.Strict
+	}
+
+	return false
+}
+
+// isDotName returns whether the name is equivalent to dotName, or to its
+// short name, on a case insensitive filesystem. Like git, the characters
+// ignored by HFS+ and the trailing dots and spaces ignored by NTFS are
+// dropped.
+func isDotName(name, dotName, shortName string) bool {
+	name = strings.Map(func(r rune) rune {
+		if isHFSIgnorable(r) {
+			return -1
+		}
+
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+
+	return strings.EqualFold(name, dotName) || strings.EqualFold(name, shortName)
+}
+
+func isHFSIgnorable(r rune) bool {
+	switch {
+	case r >= 0x200c && r <= 0x200f,
+		r >= 0x202a && r <= 0x202e,
+		r >= 0x206a && r <= 0x206f,
+		r == 0xfeff:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,658 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/utils/diff"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// binaryDetectionSize is the number of bytes inspected to decide whether a
+// blob is binary, the same heuristic used by git.
+const binaryDetectionSize = 8000
+
+// MergeConflictType is the kind of conflict found by MergeTrees.
+type MergeConflictType int
+
+const (
+	// ContentConflict is reported when both sides modified a file in ways
+	// that could not be merged. Binary files, symlinks and submodules
+	// modified by both sides are always reported as content conflicts.
+	ContentConflict MergeConflictType = iota
+	// AddAddConflict is reported when both sides added a different file
+	// with the same path.
+	AddAddConflict
+	// ModifyDeleteConflict is reported when a file was modified by one side
+	// and deleted by the other.
+	ModifyDeleteConflict
+	// RenameDeleteConflict is reported when a file was renamed by one side
+	// and deleted by the other.
+	RenameDeleteConflict
+	// RenameRenameConflict is reported when both sides renamed a file to
+	// different paths.
+	RenameRenameConflict
+	// ModeConflict is reported when both sides changed the mode of a file
+	// to different values.
+	ModeConflict
+	// DirectoryFileConflict is reported when a file of one side has the
+	// same path as a directory of the other side.
+	DirectoryFileConflict
+)
+
+func (t MergeConflictType) String() string {
+	switch t {
+	case ContentConflict:
+		return "content"
+	case AddAddConflict:
+		return "add/add"
+	case ModifyDeleteConflict:
+		return "modify/delete"
+	case RenameDeleteConflict:
+		return "rename/delete"
+	case RenameRenameConflict:
+		return "rename/rename"
+	case ModeConflict:
+		return "mode"
+	case DirectoryFileConflict:
+		return "directory/file"
+	}
+
+	return "unknown"
+}
+
+// MergeStage is a version of a file involved in a merge.
+type MergeStage struct {
+	// Path is the full path of the file, using "/" as separator.
+	Path string
+	Mode filemode.FileMode
+	Hash plumbing.Hash
+}
+
+// MergeConflict is a path that MergeTrees could not merge automatically.
+type MergeConflict struct {
+	Type MergeConflictType
+	// Path is the path of the conflict. On rename/rename conflicts it is
+	// the path of the file in the base tree.
+	Path string
+	// Base, Ours and Theirs are the versions of the file on each side of
+	// the merge, nil when the file doesn't exist on that side. They match
+	// the stages 1, 2 and 3 of an unmerged index. Their paths may differ
+	// from Path when the file was renamed.
+	Base, Ours, Theirs *MergeStage
+	// Result is the file written to the resulting tree for this conflict,
+	// usually the content with conflict markers. It is nil when nothing
+	// was written for Path, e.g. rename/rename conflicts keep both renamed
+	// files at their own path.
+	Result *MergeStage
+}
+
+// MergeTreesOptions describes how MergeTrees should be performed.
+type MergeTreesOptions struct {
+	// OursLabel, BaseLabel and TheirsLabel are appended to the conflict
+	// markers. When a file conflicts with a directory, OursLabel or
+	// TheirsLabel are also used to name the file written to the tree.
+	OursLabel   string
+	BaseLabel   string
+	TheirsLabel string
+	// Diff3 includes the base version in the conflicting hunks.
+	Diff3 bool
+	// RenameOptions are used to detect the renames made by each side. If
+	// nil, renames are not detected. DefaultDiffTreeOptions is the
+	// recommended value.
+	RenameOptions *DiffTreeOptions
+}
+
+// MergeTreesResult is the outcome of MergeTrees.
+type MergeTreesResult struct {
+	// Tree is the hash of the resulting tree. Conflicting files are
+	// included in it with conflict markers, the same as the tree written
+	// by `git merge-tree --write-tree`.
+	Tree plumbing.Hash
+	// Conflicts found while merging, sorted by path. The merge is clean if
+	// there is none.
+	Conflicts []*MergeConflict
+}
+
+// MergeTrees mimics the behavior of `git merge-tree --write-tree`, performing
+// a three-way merge of the trees ours and theirs, using base as their common
+// ancestor, without needing a worktree or an index. Any of the trees can be
+// nil, meaning an empty tree.
+//
+// The blobs and trees created by the merge are written into s, along with
+// the resulting tree, whose hash is returned together with the list of
+// conflicts found.
+func MergeTrees(s storer.EncodedObjectStorer, base, ours, theirs *Tree, opts *MergeTreesOptions) (*MergeTreesResult, error) {
+	if opts == nil {
+		opts = &MergeTreesOptions{}
+	}
+
+	m := &treeMerger{
+		s:       s,
+		opts:    opts,
+		entries: make(map[string]*MergeStage),
+	}
+
+	var err error
+	if m.base, err = flattenTree(base); err != nil {
+		return nil, err
+	}
+
+	if m.ours, err = flattenTree(ours); err != nil {
+		return nil, err
+	}
+
+	if m.theirs, err = flattenTree(theirs); err != nil {
+		return nil, err
+	}
+
+	if opts.RenameOptions != nil && base != nil {
+		if err := m.followRenames(base, ours, theirs); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range m.paths() {
+		if err := m.mergePath(p); err != nil {
+			return nil, err
+		}
+	}
+
+	m.resolveDirectoryConflicts()
+	sort.Slice(m.conflicts, func(i, j int) bool {
+		return m.conflicts[i].Path < m.conflicts[j].Path
+	})
+
+	tree, err := writeTree(s, m.entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MergeTreesResult{Tree: tree, Conflicts: m.conflicts}, nil
+}
+
+type treeMerger struct {
+	s    storer.EncodedObjectStorer
+	opts *MergeTreesOptions
+
+	// base, ours and theirs are the flattened trees being merged.
+	base, ours, theirs map[string]*MergeStage
+
+	// entries are the files of the resulting tree.
+	entries   map[string]*MergeStage
+	conflicts []*MergeConflict
+}
+
+// paths returns all the paths found in the merged trees, sorted.
+func (m *treeMerger) paths() []string {
+	seen := make(map[string]struct{}, len(m.ours))
+	for _, files := range []map[string]*MergeStage{m.base, m.ours, m.theirs} {
+		for p := range files {
+			seen[p] = struct{}{}
+		}
+	}
+
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// followRenames detects the renames made by each side, moving the files of
+// the base tree and the other side to the new path, so their changes are
+// merged into the renamed file. Renames that can't be followed are recorded
+// as conflicts.
+func (m *treeMerger) followRenames(base, ours, theirs *Tree) error {
+	oursRenames, err := renamedPaths(base, ours, m.opts.RenameOptions)
+	if err != nil {
+		return err
+	}
+
+	theirsRenames, err := renamedPaths(base, theirs, m.opts.RenameOptions)
+	if err != nil {
+		return err
+	}
+
+	for _, from := range sortedKeys(oursRenames) {
+		to := oursRenames[from]
+		theirsTo, ok := theirsRenames[from]
+		if !ok {
+			m.followRename(from, to, m.ours, m.theirs, true)
+			continue
+		}
+
+		delete(theirsRenames, from)
+		if theirsTo == to {
+			m.base[to] = m.base[from].withPath(to)
+			delete(m.base, from)
+			continue
+		}
+
+		m.conflicts = append(m.conflicts, &MergeConflict{
+			Type:   RenameRenameConflict,
+			Path:   from,
+			Base:   m.base[from],
+			Ours:   m.ours[to],
+			Theirs: m.theirs[theirsTo],
+		})
+
+		m.entries[to] = m.ours[to]
+		m.entries[theirsTo] = m.theirs[theirsTo]
+		delete(m.base, from)
+		delete(m.ours, to)
+		delete(m.theirs, theirsTo)
+	}
+
+	for _, from := range sortedKeys(theirsRenames) {
+		m.followRename(from, theirsRenames[from], m.theirs, m.ours, false)
+	}
+
+	return nil
+}
+
+// followRename handles a file renamed by only one side of the merge.
+func (m *treeMerger) followRename(from, to string, renamed, other map[string]*MergeStage, ours bool) {
+	e, ok := other[from]
+	if !ok {
+		c := &MergeConflict{
+			Type:   RenameDeleteConflict,
+			Path:   to,
+			Base:   m.base[from].withPath(to),
+			Result: renamed[to],
+		}
+
+		if ours {
+			c.Ours = renamed[to]
+		} else {
+			c.Theirs = renamed[to]
+		}
+
+		m.conflicts = append(m.conflicts, c)
+		m.entries[to] = renamed[to]
+		delete(m.base, from)
+		delete(renamed, to)
+		return
+	}
+
+	if _, ok := other[to]; ok {
+		// The other side added a file with the same path, the rename is
+		// handled as an unrelated delete and add.
+		return
+	}
+
+	other[to] = e.withPath(to)
+	m.base[to] = m.base[from].withPath(to)
+	delete(other, from)
+	delete(m.base, from)
+}
+
+func (m *treeMerger) mergePath(p string) error {
+	b, o, t := m.base[p], m.ours[p], m.theirs[p]
+	switch {
+	case sameStage(o, t):
+		m.add(p, o)
+		return nil
+	case sameStage(b, o):
+		m.add(p, t)
+		return nil
+	case sameStage(b, t):
+		m.add(p, o)
+		return nil
+	}
+
+	c := &MergeConflict{Path: p, Base: b, Ours: o, Theirs: t}
+	if o == nil || t == nil {
+		// The modified version is kept in the resulting tree.
+		c.Type = ModifyDeleteConflict
+		c.Result = o
+		if c.Result == nil {
+			c.Result = t
+		}
+
+		m.conflict(c)
+		return nil
+	}
+
+	mode, modeMerged := mergeModes(b, o, t)
+	result := &MergeStage{Path: p, Mode: mode}
+	contentMerged := true
+	switch {
+	case o.Hash == t.Hash:
+		result.Hash = o.Hash
+	case b != nil && b.Hash == o.Hash:
+		result.Hash = t.Hash
+	case b != nil && b.Hash == t.Hash:
+		result.Hash = o.Hash
+	case isMergeableMode(mode) && (b == nil || isMergeableMode(b.Mode)):
+		h, conflict, err := m.mergeBlobs(b, o, t)
+		if err != nil {
+			return err
+		}
+
+		result.Hash = h
+		contentMerged = !conflict
+	default:
+		// Symlinks, submodules and files changing their type can not be
+		// merged, ours is kept in the resulting tree.
+		result = o
+		contentMerged = false
+	}
+
+	switch {
+	case modeMerged && contentMerged:
+		m.add(p, result)
+		return nil
+	case !contentMerged && b == nil:
+		c.Type = AddAddConflict
+	case !contentMerged:
+		c.Type = ContentConflict
+	default:
+		c.Type = ModeConflict
+	}
+
+	c.Result = result
+	m.conflict(c)
+	return nil
+}
+
+// mergeBlobs merges the content of the given files, returning the hash of the
+// resulting blob and whether it contains conflicts. Binary files are not
+// merged, ours is returned as conflicting instead.
+func (m *treeMerger) mergeBlobs(b, o, t *MergeStage) (plumbing.Hash, bool, error) {
+	var base []byte
+	if b != nil {
+		var err error
+		if base, err = m.readBlob(b.Hash); err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+	}
+
+	ours, err := m.readBlob(o.Hash)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+
+	theirs, err := m.readBlob(t.Hash)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+
+	if isBinary(base) || isBinary(ours) || isBinary(theirs) {
+		return o.Hash, true, nil
+	}
+
+	merged, conflict := diff.Merge(string(base), string(ours), string(theirs), diff.MergeOptions{
+		OursLabel:   m.opts.OursLabel,
+		BaseLabel:   m.opts.BaseLabel,
+		TheirsLabel: m.opts.TheirsLabel,
+		Diff3:       m.opts.Diff3,
+	})
+
+	h, err := writeBlob(m.s, []byte(merged))
+	return h, conflict, err
+}
+
+func (m *treeMerger) readBlob(h plumbing.Hash) (content []byte, err error) {
+	blob, err := GetBlob(m.s, h)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	return io.ReadAll(r)
+}
+
+func (m *treeMerger) add(p string, e *MergeStage) {
+	if e != nil {
+		m.entries[p] = e.withPath(p)
+	}
+}
+
+func (m *treeMerger) conflict(c *MergeConflict) {
+	if c.Result != nil {
+		m.entries[c.Result.Path] = c.Result
+	}
+
+	m.conflicts = append(m.conflicts, c)
+}
+
+// resolveDirectoryConflicts handles files that, once merged, collide with a
+// directory of the same name. Like git, the file is reported as conflicting
+// and written to the resulting tree as "<path>~<label>".
+func (m *treeMerger) resolveDirectoryConflicts() {
+	dirs := make(map[string]struct{})
+	for p := range m.entries {
+		for i := strings.LastIndexByte(p, '/'); i > 0; i = strings.LastIndexByte(p[:i], '/') {
+			dirs[p[:i]] = struct{}{}
+		}
+	}
+
+	conflicts := make(map[string]*MergeConflict, len(m.conflicts))
+	for _, c := range m.conflicts {
+		if c.Result != nil {
+			conflicts[c.Result.Path] = c
+		}
+	}
+
+	for _, p := range sortedKeys(m.entries) {
+		if _, ok := dirs[p]; !ok {
+			continue
+		}
+
+		e := m.entries[p]
+		c, ok := conflicts[p]
+		if !ok {
+			c = &MergeConflict{Path: p, Base: m.base[p], Ours: m.ours[p], Theirs: m.theirs[p]}
+			m.conflicts = append(m.conflicts, c)
+		}
+
+		label := m.opts.TheirsLabel
+		if sameStage(e, m.ours[p]) {
+			label = m.opts.OursLabel
+		}
+
+		c.Type = DirectoryFileConflict
+		c.Result = e.withPath(p + "~" + strings.ReplaceAll(label, "/", "_"))
+		delete(m.entries, p)
+		m.entries[c.Result.Path] = c.Result
+	}
+}
+
+func (e *MergeStage) withPath(p string) *MergeStage {
+	if e == nil || e.Path == p {
+		return e
+	}
+
+	return &MergeStage{Path: p, Mode: e.Mode, Hash: e.Hash}
+}
+
+// renamedPaths returns the files renamed between the given trees, indexed by
+// their original path.
+func renamedPaths(from, to *Tree, opts *DiffTreeOptions) (map[string]string, error) {
+	renames := make(map[string]string)
+	if from == nil || to == nil {
+		return renames, nil
+	}
+
+	changes, err := DiffTreeWithOptions(context.Background(), from, to, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ch := range changes {
+		if ch.From.Name != "" && ch.To.Name != "" && ch.From.Name != ch.To.Name {
+			renames[ch.From.Name] = ch.To.Name
+		}
+	}
+
+	return renames, nil
+}
+
+// mergeModes returns the file mode of the merge result and false if the mode
+// was changed differently by both sides.
+func mergeModes(b, o, t *MergeStage) (filemode.FileMode, bool) {
+	switch {
+	case o.Mode == t.Mode:
+		return o.Mode, true
+	case b != nil && b.Mode == o.Mode:
+		return t.Mode, true
+	case b != nil && b.Mode == t.Mode:
+		return o.Mode, true
+	}
+
+	return o.Mode, false
+}
+
+func isMergeableMode(m filemode.FileMode) bool {
+	return m == filemode.Regular || m == filemode.Deprecated || m == filemode.Executable
+}
+
+func isBinary(content []byte) bool {
+	if len(content) > binaryDetectionSize {
+		content = content[:binaryDetectionSize]
+	}
+
+	return bytes.IndexByte(content, 0) != -1
+}
+
+func sameStage(a, b *MergeStage) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Mode == b.Mode && a.Hash == b.Hash
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// flattenTree returns all the non-tree entries of t, indexed by their full
+// path.
+func flattenTree(t *Tree) (map[string]*MergeStage, error) {
+	files := make(map[string]*MergeStage)
+	if t == nil {
+		return files, nil
+	}
+
+	w := NewTreeWalker(t, true, nil)
+	defer w.Close()
+
+	for {
+		name, e, err := w.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if e.Mode == filemode.Dir {
+			continue
+		}
+
+		files[name] = &MergeStage{Path: name, Mode: e.Mode, Hash: e.Hash}
+	}
+
+	return files, nil
+}
+
+func writeBlob(s storer.EncodedObjectStorer, content []byte) (h plumbing.Hash, err error) {
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(content)))
+
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return s.SetEncodedObject(obj)
+}
+
+// treeBuilder creates the tree objects needed to represent a set of files.
+type treeBuilder struct {
+	entries  []TreeEntry
+	subtrees map[string]*treeBuilder
+}
+
+// writeTree stores the trees needed to represent the given files, returning
+// the hash of the root tree.
+func writeTree(s storer.EncodedObjectStorer, files map[string]*MergeStage) (plumbing.Hash, error) {
+	root := &treeBuilder{}
+	for p, e := range files {
+		root.add(p, e)
+	}
+
+	return root.write(s)
+}
+
+func (b *treeBuilder) add(p string, e *MergeStage) {
+	name, rest, isDir := strings.Cut(p, "/")
+	if !isDir {
+		b.entries = append(b.entries, TreeEntry{Name: name, Mode: e.Mode, Hash: e.Hash})
+		return
+	}
+
+	if b.subtrees == nil {
+		b.subtrees = make(map[string]*treeBuilder)
+	}
+
+	sub, ok := b.subtrees[name]
+	if !ok {
+		sub = &treeBuilder{}
+		b.subtrees[name] = sub
+	}
+
+	sub.add(rest, e)
+}
+
+func (b *treeBuilder) write(s storer.EncodedObjectStorer) (plumbing.Hash, error) {
+	for name, sub := range b.subtrees {
+		h, err := sub.write(s)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		b.entries = append(b.entries, TreeEntry{Name: name, Mode: filemode.Dir, Hash: h})
+	}
+
+	sort.Sort(TreeEntrySorter(b.entries))
+
+	t := &Tree{Entries: b.entries}
+	obj := s.NewEncodedObject()
+	if err := t.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return s.SetEncodedObject(obj)
+}
@@ -0,0 +1,200 @@
+package object
+
+import (
+	"io"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type mergeTreeSuite struct {
+	s *memory.Storage
+}
+
+var _ = Suite(&mergeTreeSuite{})
+
+func (s *mergeTreeSuite) SetUpTest(c *C) {
+	s.s = memory.NewStorage()
+}
+
+// tree creates a tree holding the given regular files.
+func (s *mergeTreeSuite) tree(c *C, files map[string]string) *Tree {
+	entries := make(map[string]*MergeStage, len(files))
+	for name, content := range files {
+		h, err := writeBlob(s.s, []byte(content))
+		c.Assert(err, IsNil)
+		entries[name] = &MergeStage{Path: name, Mode: filemode.Regular, Hash: h}
+	}
+
+	h, err := writeTree(s.s, entries)
+	c.Assert(err, IsNil)
+
+	t, err := GetTree(s.s, h)
+	c.Assert(err, IsNil)
+	return t
+}
+
+func (s *mergeTreeSuite) content(c *C, h plumbing.Hash, path string) string {
+	t, err := GetTree(s.s, h)
+	c.Assert(err, IsNil)
+
+	f, err := t.File(path)
+	c.Assert(err, IsNil)
+
+	r, err := f.Reader()
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	return string(b)
+}
+
+func (s *mergeTreeSuite) TestClean(c *C) {
+	base := s.tree(c, map[string]string{"a": "1\n2\n3\n4\n", "dir/b": "b\n", "c": "c\n"})
+	ours := s.tree(c, map[string]string{"a": "one\n2\n3\n4\n", "dir/b": "b\n", "c": "c\n", "d": "d\n"})
+	theirs := s.tree(c, map[string]string{"a": "1\n2\n3\nfour\n", "dir/b": "B\n"})
+
+	result, err := MergeTrees(s.s, base, ours, theirs, nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Conflicts, HasLen, 0)
+
+	expected := s.tree(c, map[string]string{"a": "one\n2\n3\nfour\n", "dir/b": "B\n", "d": "d\n"})
+	c.Assert(result.Tree, Equals, expected.Hash)
+}
+
+func (s *mergeTreeSuite) TestContentConflict(c *C) {
+	base := s.tree(c, map[string]string{"a": "1\n2\n3\n"})
+	ours := s.tree(c, map[string]string{"a": "1\nours\n3\n"})
+	theirs := s.tree(c, map[string]string{"a": "1\ntheirs\n3\n"})
+
+	result, err := MergeTrees(s.s, base, ours, theirs, &MergeTreesOptions{
+		OursLabel:   "ours",
+		TheirsLabel: "theirs",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result.Conflicts, HasLen, 1)
+
+	conflict := result.Conflicts[0]
+	c.Assert(conflict.Type, Equals, ContentConflict)
+	c.Assert(conflict.Path, Equals, "a")
+	c.Assert(conflict.Base.Hash, Equals, base.Entries[0].Hash)
+	c.Assert(conflict.Ours.Hash, Equals, ours.Entries[0].Hash)
+	c.Assert(conflict.Theirs.Hash, Equals, theirs.Entries[0].Hash)
+	c.Assert(conflict.Result.Path, Equals, "a")
+
+	c.Assert(s.content(c, result.Tree, "a"), Equals, "1\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n3\n")
+}
+
+func (s *mergeTreeSuite) TestAddAddConflict(c *C) {
+	ours := s.tree(c, map[string]string{"a": "ours\n"})
+	theirs := s.tree(c, map[string]string{"a": "theirs\n"})
+
+	result, err := MergeTrees(s.s, nil, ours, theirs, nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Conflicts, HasLen, 1)
+	c.Assert(result.Conflicts[0].Type, Equals, AddAddConflict)
+	c.Assert(result.Conflicts[0].Base, IsNil)
+}
+
+func (s *mergeTreeSuite) TestModifyDeleteConflict(c *C) {
+	base := s.tree(c, map[string]string{"a": "a\n", "b": "b\n"})
+	ours := s.tree(c, map[string]string{"a": "modified\n", "b": "b\n"})
+	theirs := s.tree(c, map[string]string{"b": "b\n"})
+
+	result, err := MergeTrees(s.s, base, ours, theirs, nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Conflicts, HasLen, 1)
+	c.Assert(result.Conflicts[0].Type, Equals, ModifyDeleteConflict)
+	c.Assert(result.Conflicts[0].Theirs, IsNil)
+	c.Assert(s.content(c, result.Tree, "a"), Equals, "modified\n")
+}
+
+func (s *mergeTreeSuite) TestModeConflict(c *C) {
+	base := s.tree(c, map[string]string{"a": "a\n"})
+	ours := s.tree(c, map[string]string{"a": "a\n"})
+	theirs := s.tree(c, map[string]string{"a": "a\n"})
+	ours.Entries[0].Mode = filemode.Executable
+	theirs.Entries[0].Mode = filemode.Symlink
+
+	result, err := MergeTrees(s.s, base, ours, theirs, nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Conflicts, HasLen, 1)
+	c.Assert(result.Conflicts[0].Type, Equals, ModeConflict)
+}
+
+func (s *mergeTreeSuite) TestRename(c *C) {
+	content := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	base := s.tree(c, map[string]string{"a": content})
+	ours := s.tree(c, map[string]string{"b": content})
+	theirs := s.tree(c, map[string]string{"a": "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"})
+
+	result, err := MergeTrees(s.s, base, ours, theirs, &MergeTreesOptions{
+		RenameOptions: DefaultDiffTreeOptions,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result.Conflicts, HasLen, 0)
+
+	expected := s.tree(c, map[string]string{"b": "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"})
+	c.Assert(result.Tree, Equals, expected.Hash)
+}
+
+func (s *mergeTreeSuite) TestRenameDeleteConflict(c *C) {
+	content := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	base := s.tree(c, map[string]string{"a": content, "c": "c\n"})
+	ours := s.tree(c, map[string]string{"b": content, "c": "c\n"})
+	theirs := s.tree(c, map[string]string{"c": "c\n"})
+
+	result, err := MergeTrees(s.s, base, ours, theirs, &MergeTreesOptions{
+		RenameOptions: DefaultDiffTreeOptions,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result.Conflicts, HasLen, 1)
+	c.Assert(result.Conflicts[0].Type, Equals, RenameDeleteConflict)
+	c.Assert(result.Conflicts[0].Path, Equals, "b")
+	c.Assert(s.content(c, result.Tree, "b"), Equals, content)
+}
+
+func (s *mergeTreeSuite) TestRenameRenameConflict(c *C) {
+	content := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	base := s.tree(c, map[string]string{"a": content})
+	ours := s.tree(c, map[string]string{"b": content})
+	theirs := s.tree(c, map[string]string{"c": content})
+
+	result, err := MergeTrees(s.s, base, ours, theirs, &MergeTreesOptions{
+		RenameOptions: DefaultDiffTreeOptions,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result.Conflicts, HasLen, 1)
+
+	conflict := result.Conflicts[0]
+	c.Assert(conflict.Type, Equals, RenameRenameConflict)
+	c.Assert(conflict.Base.Path, Equals, "a")
+	c.Assert(conflict.Ours.Path, Equals, "b")
+	c.Assert(conflict.Theirs.Path, Equals, "c")
+	c.Assert(conflict.Result, IsNil)
+
+	expected := s.tree(c, map[string]string{"b": content, "c": content})
+	c.Assert(result.Tree, Equals, expected.Hash)
+}
+
+func (s *mergeTreeSuite) TestDirectoryFileConflict(c *C) {
+	base := s.tree(c, map[string]string{"x": "x\n"})
+	ours := s.tree(c, map[string]string{"x": "x\n", "a": "file\n"})
+	theirs := s.tree(c, map[string]string{"x": "x\n", "a/b": "b\n"})
+
+	result, err := MergeTrees(s.s, base, ours, theirs, &MergeTreesOptions{
+		OursLabel:   "HEAD",
+		TheirsLabel: "feature",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result.Conflicts, HasLen, 1)
+	c.Assert(result.Conflicts[0].Type, Equals, DirectoryFileConflict)
+	c.Assert(result.Conflicts[0].Result.Path, Equals, "a~HEAD")
+
+	c.Assert(s.content(c, result.Tree, "a~HEAD"), Equals, "file\n")
+	c.Assert(s.content(c, result.Tree, "a/b"), Equals, "b\n")
+}
@@ -241,6 +241,31 @@ const (
 	// Filter if present, fetch-pack may send "filter" commands to request a
 	// partial clone or partial fetch and request that the server omit various objects from the packfile
 	Filter Capability = "filter"
+	// LsRefs is the command of the version 2 of the protocol listing the
+	// references of the repository. Its value lists the features of the
+	// command supported by the server, like unborn.
+	LsRefs Capability = "ls-refs"
+	// Fetch is the command of the version 2 of the protocol fetching a
+	// packfile. Its value lists the features of the command supported by
+	// the server, like shallow, filter, packfile-uris or wait-for-done.
+	Fetch Capability = "fetch"
+	// ObjectInfo is the command of the version 2 of the protocol retrieving
+	// information about objects, like their size.
+	ObjectInfo Capability = "object-info"
+	// ServerOption if present, the client may send server specific options
+	// with the commands of the version 2 of the protocol.
+	ServerOption Capability = "server-option"
+	// Unborn is a feature of the ls-refs command, the server can list the
+	// symbolic references whose target does not exist, like the HEAD of an
+	// empty repository.
+	Unborn Capability = "unborn"
+	// WaitForDone is a feature of the fetch command, the server does not
+	// send the packfile until the client sends done, even if it has found
+	// enough common objects.
+	WaitForDone Capability = "wait-for-done"
+	// PackfileURIs is a feature of the fetch command, the server can send
+	// URIs from which the client can download parts of the packfile.
+	PackfileURIs Capability = "packfile-uris"
 )
 
 const userAgent = "go-git/5.x"
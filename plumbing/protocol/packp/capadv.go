@@ -0,0 +1,185 @@
+package packp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+)
+
+// ErrNotCapabilityAdvertisement is returned by CapabilityAdvertisement.Decode
+// if the server does not speak the version 2 of the protocol.
+var ErrNotCapabilityAdvertisement = errors.New("not a protocol v2 capability advertisement")
+
+// versionV2 is the first line of a capability advertisement.
+var versionV2 = []byte("version 2")
+
+// CapabilityAdvertisement values represent the capabilities advertised by a
+// server speaking the version 2 of the protocol, instead of its references.
+// Values from this type are not zero-value safe, use the New function
+// instead.
+//
+// The values of the capabilities are kept as they are advertised, like
+// "shallow filter" for the fetch capability, see the Features method.
+type CapabilityAdvertisement struct {
+	Capabilities *capability.List
+}
+
+// NewCapabilityAdvertisement returns a pointer to a new
+// CapabilityAdvertisement value, ready to be used.
+func NewCapabilityAdvertisement() *CapabilityAdvertisement {
+	return &CapabilityAdvertisement{
+		Capabilities: capability.NewList(),
+	}
+}
+
+// Supports returns true if the server supports the given capability, like a
+// command.
+func (a *CapabilityAdvertisement) Supports(c capability.Capability) bool {
+	return a.Capabilities.Supports(c)
+}
+
+// Features returns the features of a capability, which are the words of its
+// value, like the features of the fetch command.
+func (a *CapabilityAdvertisement) Features(c capability.Capability) []string {
+	var features []string
+	for _, v := range a.Capabilities.Get(c) {
+		features = append(features, strings.Fields(v)...)
+	}
+
+	return features
+}
+
+// SupportsFeature returns true if the given capability of the server has the
+// given feature, like the shallow feature of the fetch command.
+func (a *CapabilityAdvertisement) SupportsFeature(c, feature capability.Capability) bool {
+	for _, f := range a.Features(c) {
+		if f == feature.String() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UploadPackCapabilities returns the capabilities that a server speaking the
+// version 0 of the protocol would advertise for the same features. The fetch
+// command always supports side-band-64k, ofs-delta, no-progress and
+// include-tag, and accepts wants which are not advertised.
+func (a *CapabilityAdvertisement) UploadPackCapabilities() *capability.List {
+	l := capability.NewList()
+	for _, c := range []capability.Capability{
+		capability.OFSDelta, capability.Sideband64k, capability.NoProgress,
+		capability.IncludeTag, capability.AllowReachableSHA1InWant,
+	} {
+		_ = l.Set(c)
+	}
+
+	if a.SupportsFeature(capability.Fetch, capability.Shallow) {
+		for _, c := range []capability.Capability{
+			capability.Shallow, capability.DeepenSince,
+			capability.DeepenNot, capability.DeepenRelative,
+		} {
+			_ = l.Set(c)
+		}
+	}
+
+	if a.SupportsFeature(capability.Fetch, capability.Filter) {
+		_ = l.Set(capability.Filter)
+	}
+
+	for _, c := range []capability.Capability{capability.Agent, capability.ObjectFormat} {
+		if values := a.Capabilities.Get(c); len(values) > 0 {
+			_ = l.Set(c, values[0])
+		}
+	}
+
+	return l
+}
+
+// Decode reads the capability advertisement from r. If the server does not
+// speak the version 2 of the protocol, ErrNotCapabilityAdvertisement is
+// returned.
+func (a *CapabilityAdvertisement) Decode(r io.Reader) error {
+	s := pktline.NewScanner(r)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return err
+		}
+
+		return ErrEmptyInput
+	}
+
+	if !bytes.Equal(bytes.TrimSuffix(s.Bytes(), eol), versionV2) {
+		return ErrNotCapabilityAdvertisement
+	}
+
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if len(line) == 0 {
+			return nil
+		}
+
+		if err := addCapabilityLine(a.Capabilities, line); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: missing flush-pkt", ErrNotCapabilityAdvertisement)
+}
+
+// Encode writes the capability advertisement to w.
+func (a *CapabilityAdvertisement) Encode(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	if err := e.Encodef("%s\n", versionV2); err != nil {
+		return err
+	}
+
+	if err := encodeCapabilityLines(e, a.Capabilities); err != nil {
+		return err
+	}
+
+	return e.Flush()
+}
+
+// addCapabilityLine adds to l the capability of a line of a capability
+// advertisement or of a command request, whose value may contain spaces.
+func addCapabilityLine(l *capability.List, line []byte) error {
+	pair := bytes.SplitN(line, []byte{'='}, 2)
+	c := capability.Capability(pair[0])
+	if len(pair) == 1 {
+		return l.Add(c)
+	}
+
+	return l.Add(c, string(pair[1]))
+}
+
+// encodeCapabilityLines writes the capabilities of l, one per pkt-line.
+func encodeCapabilityLines(e *pktline.Encoder, l *capability.List) error {
+	for _, c := range l.All() {
+		values := l.Get(c)
+		if len(values) == 0 {
+			if err := e.Encodef("%s\n", c); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for _, v := range values {
+			if err := e.Encodef("%s=%s\n", c, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
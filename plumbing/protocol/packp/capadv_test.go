@@ -0,0 +1,94 @@
+package packp
+
+import (
+	"bytes"
+
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+
+	. "gopkg.in/check.v1"
+)
+
+type CapabilityAdvertisementSuite struct{}
+
+var _ = Suite(&CapabilityAdvertisementSuite{})
+
+func (s *CapabilityAdvertisementSuite) TestDecode(c *C) {
+	r := toPktLines(c, []string{
+		"version 2\n",
+		"agent=git/2.39.5\n",
+		"ls-refs=unborn\n",
+		"fetch=shallow wait-for-done\n",
+		"server-option\n",
+		"object-format=sha1\n",
+		"",
+	})
+
+	adv := NewCapabilityAdvertisement()
+	c.Assert(adv.Decode(r), IsNil)
+	c.Assert(adv.Supports(capability.LsRefs), Equals, true)
+	c.Assert(adv.Supports(capability.ObjectInfo), Equals, false)
+	c.Assert(adv.Capabilities.Get(capability.Agent), DeepEquals, []string{"git/2.39.5"})
+	c.Assert(adv.Features(capability.Fetch), DeepEquals, []string{"shallow", "wait-for-done"})
+	c.Assert(adv.SupportsFeature(capability.Fetch, capability.WaitForDone), Equals, true)
+	c.Assert(adv.SupportsFeature(capability.Fetch, capability.Filter), Equals, false)
+	c.Assert(adv.SupportsFeature(capability.LsRefs, capability.Unborn), Equals, true)
+}
+
+func (s *CapabilityAdvertisementSuite) TestDecodeVersion0(c *C) {
+	r := toPktLines(c, []string{
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD\x00ofs-delta\n",
+		"",
+	})
+
+	adv := NewCapabilityAdvertisement()
+	c.Assert(adv.Decode(r), Equals, ErrNotCapabilityAdvertisement)
+}
+
+func (s *CapabilityAdvertisementSuite) TestDecodeMissingFlush(c *C) {
+	r := toPktLines(c, []string{"version 2\n", "ls-refs\n"})
+
+	adv := NewCapabilityAdvertisement()
+	c.Assert(adv.Decode(r), ErrorMatches, ".*missing flush-pkt")
+}
+
+func (s *CapabilityAdvertisementSuite) TestEncode(c *C) {
+	adv := NewCapabilityAdvertisement()
+	c.Assert(adv.Capabilities.Add(capability.Agent, "go-git/5.x"), IsNil)
+	c.Assert(adv.Capabilities.Add(capability.LsRefs), IsNil)
+	c.Assert(adv.Capabilities.Add(capability.Fetch, "shallow filter"), IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(adv.Encode(&buf), IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, pktlines(c,
+		"version 2\n",
+		"agent=go-git/5.x\n",
+		"ls-refs\n",
+		"fetch=shallow filter\n",
+		"",
+	))
+}
+
+func (s *CapabilityAdvertisementSuite) TestUploadPackCapabilities(c *C) {
+	adv := NewCapabilityAdvertisement()
+	c.Assert(adv.Capabilities.Add(capability.Agent, "git/2.39.5"), IsNil)
+	c.Assert(adv.Capabilities.Add(capability.Fetch, "shallow filter"), IsNil)
+
+	caps := adv.UploadPackCapabilities()
+	for _, cap := range []capability.Capability{
+		capability.OFSDelta, capability.Sideband64k, capability.NoProgress,
+		capability.IncludeTag, capability.AllowReachableSHA1InWant,
+		capability.Shallow, capability.DeepenSince, capability.DeepenNot,
+		capability.DeepenRelative, capability.Filter,
+	} {
+		c.Assert(caps.Supports(cap), Equals, true, Commentf("%s", cap))
+	}
+
+	c.Assert(caps.Get(capability.Agent), DeepEquals, []string{"git/2.39.5"})
+	c.Assert(caps.Supports(capability.Fetch), Equals, false)
+
+	adv = NewCapabilityAdvertisement()
+	c.Assert(adv.Capabilities.Add(capability.Fetch), IsNil)
+	caps = adv.UploadPackCapabilities()
+	c.Assert(caps.Supports(capability.Shallow), Equals, false)
+	c.Assert(caps.Supports(capability.Filter), Equals, false)
+}
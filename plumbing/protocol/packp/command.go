@@ -0,0 +1,140 @@
+package packp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+)
+
+// commandPrefix starts the first line of a command request.
+var commandPrefix = []byte("command=")
+
+// CommandRequest values represent a command request of the version 2 of the
+// protocol, as read by a server before knowing the command. Its arguments are
+// decoded by the request of the command, see NewLsRefsRequestFromCommand,
+// NewFetchRequestFromCommand and NewObjectInfoRequestFromCommand. Values from
+// this type are not zero-value safe, use the New function instead.
+type CommandRequest struct {
+	// Command is the name of the command, like ls-refs.
+	Command capability.Capability
+	// Capabilities are the capabilities sent with the command.
+	Capabilities *capability.List
+	// Args are the arguments of the command, one per line.
+	Args []string
+}
+
+// NewCommandRequest returns a pointer to a new CommandRequest value, ready to
+// be used.
+func NewCommandRequest() *CommandRequest {
+	return &CommandRequest{
+		Capabilities: capability.NewList(),
+	}
+}
+
+// Decode reads a command request from r. ErrEmptyInput is returned if the
+// client closed its input, or sent a flush-pkt instead of a command, both
+// ending the session.
+func (c *CommandRequest) Decode(r io.Reader) error {
+	s := pktline.NewScannerV2(r)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return err
+		}
+
+		return ErrEmptyInput
+	}
+
+	if s.IsFlush() {
+		return ErrEmptyInput
+	}
+
+	line := bytes.TrimSuffix(s.Bytes(), eol)
+	if !bytes.HasPrefix(line, commandPrefix) {
+		return fmt.Errorf("malformed command request: %q", line)
+	}
+
+	c.Command = capability.Capability(line[len(commandPrefix):])
+
+	args := false
+	for s.Scan() {
+		switch {
+		case s.IsFlush():
+			return nil
+		case s.IsDelim() && !args:
+			args = true
+			continue
+		case len(s.Bytes()) == 0:
+			return fmt.Errorf("malformed command request: unexpected special packet")
+		}
+
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if args {
+			c.Args = append(c.Args, string(line))
+			continue
+		}
+
+		if err := addCapabilityLine(c.Capabilities, line); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("malformed command request: missing flush-pkt")
+}
+
+// Encode writes the command request to w.
+func (c *CommandRequest) Encode(w io.Writer) error {
+	return encodeCommand(w, c.Command, c.Capabilities, c.Args)
+}
+
+// checkCommand returns an error if c is not a request of the given command.
+func (c *CommandRequest) checkCommand(command capability.Capability) error {
+	if c.Command != command {
+		return fmt.Errorf("unexpected command %q, expected %q", c.Command, command)
+	}
+
+	return nil
+}
+
+// decodeHashArg decodes the hash of an argument, like the hash of a want.
+func decodeHashArg(arg string) (plumbing.Hash, error) {
+	if !plumbing.IsHash(arg) {
+		return plumbing.ZeroHash, fmt.Errorf("invalid hash: %q", arg)
+	}
+
+	return plumbing.NewHash(arg), nil
+}
+
+// encodeCommand writes a command request of the version 2 of the protocol:
+// the command, its capabilities and its arguments.
+func encodeCommand(w io.Writer, command capability.Capability, caps *capability.List, args []string) error {
+	e := pktline.NewEncoder(w)
+	if err := e.Encodef("command=%s\n", command); err != nil {
+		return err
+	}
+
+	if caps != nil {
+		if err := encodeCapabilityLines(e, caps); err != nil {
+			return err
+		}
+	}
+
+	if err := e.Delim(); err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		if err := e.Encodef("%s\n", arg); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
@@ -0,0 +1,59 @@
+package packp
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+
+	. "gopkg.in/check.v1"
+)
+
+type CommandSuite struct{}
+
+var _ = Suite(&CommandSuite{})
+
+func (s *CommandSuite) TestEncodeDecode(c *C) {
+	req := NewCommandRequest()
+	req.Command = capability.LsRefs
+	c.Assert(req.Capabilities.Set(capability.Agent, "git/2.39.5"), IsNil)
+	req.Args = []string{"peel", "ref-prefix refs/heads/"}
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+
+	expected := string(pktlines(c, "command=ls-refs\n", "agent=git/2.39.5\n")) + "0001" +
+		string(pktlines(c, "peel\n", "ref-prefix refs/heads/\n", ""))
+	c.Assert(buf.String(), Equals, expected)
+
+	decoded := NewCommandRequest()
+	c.Assert(decoded.Decode(&buf), IsNil)
+	c.Assert(decoded, DeepEquals, req)
+}
+
+func (s *CommandSuite) TestDecodeWithoutArgs(c *C) {
+	req := NewCommandRequest()
+	c.Assert(req.Decode(toPktLines(c, []string{"command=ls-refs\n", ""})), IsNil)
+	c.Assert(req.Command, Equals, capability.LsRefs)
+	c.Assert(req.Args, HasLen, 0)
+}
+
+func (s *CommandSuite) TestDecodeEmpty(c *C) {
+	req := NewCommandRequest()
+	c.Assert(req.Decode(bytes.NewReader(nil)), Equals, ErrEmptyInput)
+	c.Assert(req.Decode(toPktLines(c, []string{""})), Equals, ErrEmptyInput)
+}
+
+func (s *CommandSuite) TestDecodeMalformed(c *C) {
+	req := NewCommandRequest()
+	err := req.Decode(toPktLines(c, []string{"want 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n", ""}))
+	c.Assert(err, ErrorMatches, "malformed command request.*")
+}
+
+func (s *CommandSuite) TestDecodeMissingFlush(c *C) {
+	input := string(pktlines(c, "command=fetch\n")) + "0001" + string(pktlines(c, "done\n"))
+
+	req := NewCommandRequest()
+	err := req.Decode(strings.NewReader(input))
+	c.Assert(err, ErrorMatches, ".*missing flush-pkt")
+}
@@ -32,6 +32,11 @@ var (
 	deepenCommits   = []byte("deepen ")
 	deepenSince     = []byte("deepen-since ")
 	deepenReference = []byte("deepen-not ")
+	filter          = []byte("filter ")
+
+	// upload-haves
+	have     = []byte("have ")
+	doneLine = []byte("done")
 
 	// shallow-update
 	unshallow = []byte("unshallow ")
@@ -42,6 +47,20 @@ var (
 
 	// updreq
 	shallowNoSp = []byte("shallow")
+
+	// ls-refs
+	refPrefix    = "ref-prefix "
+	unborn       = []byte("unborn")
+	symrefTarget = []byte("symref-target:")
+	peeledAttr   = []byte("peeled:")
+
+	// fetch-response
+	acknowledgments = []byte("acknowledgments")
+	shallowInfo     = []byte("shallow-info")
+	wantedRefs      = []byte("wanted-refs")
+	packfileURIs    = []byte("packfile-uris")
+	packfileSection = []byte("packfile")
+	ready           = []byte("ready")
 )
 
 func isFlush(payload []byte) bool {
@@ -0,0 +1,467 @@
+package packp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+)
+
+// FetchRequest values represent a fetch command of the version 2 of the
+// protocol, which negotiates the common objects with the server and fetches
+// a packfile. Values from this type are not zero-value safe, use the New
+// function instead.
+type FetchRequest struct {
+	// Capabilities are the capabilities sent with the command, like agent.
+	Capabilities *capability.List
+	// Wants are the objects to fetch.
+	Wants []plumbing.Hash
+	// WantRefs are the references to fetch, whose hashes are returned in the
+	// WantedRefs of the response. The server must support the ref-in-want
+	// feature of fetch.
+	WantRefs []plumbing.ReferenceName
+	// Haves are the objects that the client has.
+	Haves []plumbing.Hash
+	// Done ends the negotiation, the server sends the packfile.
+	Done bool
+	// ThinPack requests a thin packfile.
+	ThinPack bool
+	// NoProgress disables the progress messages of the sideband.
+	NoProgress bool
+	// IncludeTag requests the annotated tags pointing to the fetched
+	// objects.
+	IncludeTag bool
+	// OFSDelta requests offset deltas in the packfile.
+	OFSDelta bool
+	// Shallows are the shallow commits of the client.
+	Shallows []plumbing.Hash
+	// Depth is the depth of the fetch, the server must support the shallow
+	// feature of fetch.
+	Depth Depth
+	// DeepenRelative makes DepthCommits relative to the shallow commits.
+	DeepenRelative bool
+	// Filter omits objects from the packfile, the server must support the
+	// filter feature of fetch.
+	Filter Filter
+	// PackfileURIs are the protocols of the packfile URIs supported by the
+	// client, like https. The server must support the packfile-uris feature
+	// of fetch.
+	PackfileURIs []string
+	// WaitForDone requests the packfile to be sent only once Done is sent,
+	// the server must support the wait-for-done feature of fetch.
+	WaitForDone bool
+}
+
+// NewFetchRequest returns a pointer to a new FetchRequest value, ready to be
+// used.
+func NewFetchRequest() *FetchRequest {
+	return &FetchRequest{
+		Capabilities: capability.NewList(),
+		Depth:        DepthCommits(0),
+	}
+}
+
+// NewFetchRequestFromCommand returns a pointer to a new FetchRequest value,
+// decoded from the arguments of a fetch command request.
+func NewFetchRequestFromCommand(cmd *CommandRequest) (*FetchRequest, error) {
+	if err := cmd.checkCommand(capability.Fetch); err != nil {
+		return nil, err
+	}
+
+	req := NewFetchRequest()
+	req.Capabilities = cmd.Capabilities
+
+	flags := map[string]*bool{
+		"thin-pack":       &req.ThinPack,
+		"no-progress":     &req.NoProgress,
+		"include-tag":     &req.IncludeTag,
+		"ofs-delta":       &req.OFSDelta,
+		"deepen-relative": &req.DeepenRelative,
+		"wait-for-done":   &req.WaitForDone,
+		"done":            &req.Done,
+	}
+
+	hashes := map[string]*[]plumbing.Hash{
+		"want":    &req.Wants,
+		"have":    &req.Haves,
+		"shallow": &req.Shallows,
+	}
+
+	hasDepth := false
+	for _, arg := range cmd.Args {
+		if flag, ok := flags[arg]; ok {
+			*flag = true
+			continue
+		}
+
+		name, value, _ := strings.Cut(arg, " ")
+		if list, ok := hashes[name]; ok {
+			h, err := decodeHashArg(value)
+			if err != nil {
+				return nil, err
+			}
+
+			*list = append(*list, h)
+			continue
+		}
+
+		var depth Depth
+		switch name {
+		case "want-ref":
+			req.WantRefs = append(req.WantRefs, plumbing.ReferenceName(value))
+		case "filter":
+			req.Filter = Filter(value)
+		case "packfile-uris":
+			req.PackfileURIs = strings.Split(value, ",")
+		case "deepen":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid deepen argument: %q", arg)
+			}
+
+			depth = DepthCommits(n)
+		case "deepen-since":
+			secs, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid deepen-since argument: %q", arg)
+			}
+
+			depth = DepthSince(time.Unix(secs, 0).UTC())
+		case "deepen-not":
+			depth = DepthReference(value)
+		default:
+			return nil, fmt.Errorf("unexpected fetch argument: %q", arg)
+		}
+
+		if depth != nil {
+			if hasDepth {
+				return nil, fmt.Errorf("only one deepen argument is supported")
+			}
+
+			req.Depth, hasDepth = depth, true
+		}
+	}
+
+	return req, nil
+}
+
+// Encode writes the fetch command to w.
+func (req *FetchRequest) Encode(w io.Writer) error {
+	var args []string
+	add := func(format string, a ...interface{}) {
+		args = append(args, fmt.Sprintf(format, a...))
+	}
+
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{req.ThinPack, "thin-pack"},
+		{req.NoProgress, "no-progress"},
+		{req.IncludeTag, "include-tag"},
+		{req.OFSDelta, "ofs-delta"},
+		{req.DeepenRelative, "deepen-relative"},
+		{req.WaitForDone, "wait-for-done"},
+	} {
+		if flag.set {
+			args = append(args, flag.name)
+		}
+	}
+
+	for _, h := range req.Wants {
+		add("want %s", h)
+	}
+
+	for _, ref := range req.WantRefs {
+		add("want-ref %s", ref)
+	}
+
+	for _, h := range req.Shallows {
+		add("shallow %s", h)
+	}
+
+	switch depth := req.Depth.(type) {
+	case DepthCommits:
+		if depth != 0 {
+			add("deepen %d", depth)
+		}
+	case DepthSince:
+		if !depth.IsZero() {
+			add("deepen-since %d", time.Time(depth).Unix())
+		}
+	case DepthReference:
+		if depth != "" {
+			add("deepen-not %s", depth)
+		}
+	case nil:
+	default:
+		return fmt.Errorf("unsupported depth type")
+	}
+
+	if req.Filter != "" {
+		add("filter %s", req.Filter)
+	}
+
+	if len(req.PackfileURIs) > 0 {
+		add("packfile-uris %s", strings.Join(req.PackfileURIs, ","))
+	}
+
+	for _, h := range req.Haves {
+		add("have %s", h)
+	}
+
+	if req.Done {
+		args = append(args, "done")
+	}
+
+	return encodeCommand(w, capability.Fetch, req.Capabilities, args)
+}
+
+// PackfileURI is a part of the packfile, which the client must download from
+// the URI instead of receiving it in the response.
+type PackfileURI struct {
+	// Hash is the hash of the packfile.
+	Hash plumbing.Hash
+	// URI is the location of the packfile.
+	URI string
+}
+
+// FetchResponse values represent the response to a fetch command. Values
+// from this type are not zero-value safe, use the New function instead.
+type FetchResponse struct {
+	ShallowUpdate
+	// ACKs are the common objects acknowledged by the server.
+	ACKs []plumbing.Hash
+	// Ready is true if the server found enough common objects to send the
+	// packfile.
+	Ready bool
+	// WantedRefs are the hashes of the WantRefs of the request.
+	WantedRefs map[plumbing.ReferenceName]plumbing.Hash
+	// PackfileURIs are the parts of the packfile to download.
+	PackfileURIs []PackfileURI
+	// Packfile reads the packfile section of the response, multiplexed with
+	// the progress messages (see sideband.Demuxer with sideband.Sideband64k).
+	// It is nil if the response has no packfile, which happens while the
+	// negotiation is not done.
+	Packfile io.Reader
+}
+
+// NewFetchResponse returns a pointer to a new FetchResponse value, ready to
+// be used.
+func NewFetchResponse() *FetchResponse {
+	return &FetchResponse{
+		WantedRefs: make(map[plumbing.ReferenceName]plumbing.Hash),
+	}
+}
+
+// Decode reads the sections of the response from r, up to the packfile
+// section, which is then read from Packfile.
+func (r *FetchResponse) Decode(reader io.Reader) error {
+	s := pktline.NewScannerV2(reader)
+	for s.Scan() {
+		header := bytes.TrimSuffix(s.Bytes(), eol)
+		if bytes.Equal(header, packfileSection) {
+			r.Packfile = reader
+			return nil
+		}
+
+		var decodeLine func([]byte) error
+		switch {
+		case bytes.Equal(header, acknowledgments):
+			decodeLine = r.decodeAcknowledgment
+		case bytes.Equal(header, shallowInfo):
+			decodeLine = r.decodeShallowInfo
+		case bytes.Equal(header, wantedRefs):
+			decodeLine = r.decodeWantedRef
+		case bytes.Equal(header, packfileURIs):
+			decodeLine = r.decodePackfileURI
+		default:
+			return fmt.Errorf("unexpected section in fetch response: %q", header)
+		}
+
+		for s.Scan() && !s.IsDelim() {
+			if s.IsFlush() || s.IsResponseEnd() {
+				// Only the acknowledgments are sent while the negotiation
+				// is not done.
+				return nil
+			}
+
+			if err := decodeLine(bytes.TrimSuffix(s.Bytes(), eol)); err != nil {
+				return err
+			}
+		}
+
+		if err := s.Err(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return ErrEmptyInput
+}
+
+// Encode writes the response to w. The acknowledgments section is written if
+// the response has no packfile, ending the response, or if Ready is true. The
+// Packfile is copied as is, followed by a flush-pkt.
+func (r *FetchResponse) Encode(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	sections := 0
+	section := func(header []byte, lines []string) error {
+		if sections > 0 {
+			if err := e.Delim(); err != nil {
+				return err
+			}
+		}
+
+		sections++
+		if err := e.Encodef("%s\n", header); err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			if err := e.Encodef("%s\n", line); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if r.Packfile == nil || r.Ready {
+		var lines []string
+		for _, h := range r.ACKs {
+			lines = append(lines, fmt.Sprintf("%s %s", ack, h))
+		}
+
+		if len(lines) == 0 {
+			lines = append(lines, string(nak))
+		}
+
+		if r.Ready {
+			lines = append(lines, string(ready))
+		}
+
+		if err := section(acknowledgments, lines); err != nil {
+			return err
+		}
+
+		if r.Packfile == nil {
+			return e.Flush()
+		}
+	}
+
+	if len(r.Shallows) > 0 || len(r.Unshallows) > 0 {
+		var lines []string
+		for _, h := range r.Shallows {
+			lines = append(lines, fmt.Sprintf("%s%s", shallow, h))
+		}
+
+		for _, h := range r.Unshallows {
+			lines = append(lines, fmt.Sprintf("%s%s", unshallow, h))
+		}
+
+		if err := section(shallowInfo, lines); err != nil {
+			return err
+		}
+	}
+
+	if len(r.WantedRefs) > 0 {
+		var lines []string
+		for name, h := range r.WantedRefs {
+			lines = append(lines, fmt.Sprintf("%s %s", h, name))
+		}
+
+		// The references are sorted by name, after the hash.
+		sort.Slice(lines, func(i, j int) bool { return lines[i][hashSize:] < lines[j][hashSize:] })
+		if err := section(wantedRefs, lines); err != nil {
+			return err
+		}
+	}
+
+	if len(r.PackfileURIs) > 0 {
+		var lines []string
+		for _, uri := range r.PackfileURIs {
+			lines = append(lines, fmt.Sprintf("%s %s", uri.Hash, uri.URI))
+		}
+
+		if err := section(packfileURIs, lines); err != nil {
+			return err
+		}
+	}
+
+	if err := section(packfileSection, nil); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r.Packfile); err != nil {
+		return err
+	}
+
+	return e.Flush()
+}
+
+func (r *FetchResponse) decodeAcknowledgment(line []byte) error {
+	switch {
+	case bytes.Equal(line, nak):
+	case bytes.Equal(line, ready):
+		r.Ready = true
+	case bytes.HasPrefix(line, ack):
+		h := bytes.TrimPrefix(line, ack)
+		if len(h) != hashSize+1 {
+			return fmt.Errorf("malformed ACK: %q", line)
+		}
+
+		r.ACKs = append(r.ACKs, plumbing.NewHash(string(h[1:])))
+	default:
+		return fmt.Errorf("unexpected acknowledgment: %q", line)
+	}
+
+	return nil
+}
+
+func (r *FetchResponse) decodeShallowInfo(line []byte) error {
+	switch {
+	case bytes.HasPrefix(line, shallow):
+		r.Shallows = append(r.Shallows, plumbing.NewHash(string(line[len(shallow):])))
+	case bytes.HasPrefix(line, unshallow):
+		r.Unshallows = append(r.Unshallows, plumbing.NewHash(string(line[len(unshallow):])))
+	default:
+		return fmt.Errorf("unexpected shallow-info: %q", line)
+	}
+
+	return nil
+}
+
+func (r *FetchResponse) decodeWantedRef(line []byte) error {
+	parts := bytes.SplitN(line, sp, 2)
+	if len(parts) != 2 || len(parts[0]) != hashSize {
+		return fmt.Errorf("malformed wanted-ref: %q", line)
+	}
+
+	r.WantedRefs[plumbing.ReferenceName(parts[1])] = plumbing.NewHash(string(parts[0]))
+	return nil
+}
+
+func (r *FetchResponse) decodePackfileURI(line []byte) error {
+	parts := bytes.SplitN(line, sp, 2)
+	if len(parts) != 2 || len(parts[0]) != hashSize {
+		return fmt.Errorf("malformed packfile-uri: %q", line)
+	}
+
+	r.PackfileURIs = append(r.PackfileURIs, PackfileURI{
+		Hash: plumbing.NewHash(string(parts[0])),
+		URI:  string(parts[1]),
+	})
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package packp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+type FetchSuite struct{}
+
+var _ = Suite(&FetchSuite{})
+
+func (s *FetchSuite) TestEncodeRequest(c *C) {
+	req := NewFetchRequest()
+	req.OFSDelta = true
+	req.NoProgress = true
+	req.WaitForDone = true
+	req.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+	req.WantRefs = []plumbing.ReferenceName{"refs/heads/master"}
+	req.Shallows = []plumbing.Hash{plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")}
+	req.Depth = DepthCommits(1)
+	req.Filter = FilterBlobNone()
+	req.PackfileURIs = []string{"https", "http"}
+	req.Haves = []plumbing.Hash{plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")}
+	req.Done = true
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+
+	expected := string(pktlines(c, "command=fetch\n")) + "0001" + string(pktlines(c,
+		"no-progress\n",
+		"ofs-delta\n",
+		"wait-for-done\n",
+		"want 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		"want-ref refs/heads/master\n",
+		"shallow b029517f6300c2da0f4b651b8642506cd6aaf45d\n",
+		"deepen 1\n",
+		"filter blob:none\n",
+		"packfile-uris https,http\n",
+		"have 918c48b83bd081e863dbe1b80f8998f058cd8294\n",
+		"done\n",
+		"",
+	))
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *FetchSuite) TestNewRequestFromCommand(c *C) {
+	req := NewFetchRequest()
+	req.ThinPack = true
+	req.IncludeTag = true
+	req.OFSDelta = true
+	req.Wants = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+	req.Shallows = []plumbing.Hash{plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")}
+	req.Depth = DepthSince(time.Unix(1136214245, 0).UTC())
+	req.Filter = FilterBlobLimit(1024, BlobLimitPrefixNone)
+	req.Haves = []plumbing.Hash{plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")}
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+
+	cmd := NewCommandRequest()
+	c.Assert(cmd.Decode(&buf), IsNil)
+
+	decoded, err := NewFetchRequestFromCommand(cmd)
+	c.Assert(err, IsNil)
+	c.Assert(decoded, DeepEquals, req)
+}
+
+func (s *FetchSuite) TestNewRequestFromCommandInvalid(c *C) {
+	for _, args := range [][]string{
+		{"want foo"},
+		{"deepen -1"},
+		{"deepen 1", "deepen-not refs/heads/master"},
+		{"foo"},
+	} {
+		cmd := NewCommandRequest()
+		cmd.Command = "fetch"
+		cmd.Args = args
+
+		_, err := NewFetchRequestFromCommand(cmd)
+		c.Assert(err, NotNil, Commentf("args: %q", args))
+	}
+
+	cmd := NewCommandRequest()
+	cmd.Command = "ls-refs"
+	_, err := NewFetchRequestFromCommand(cmd)
+	c.Assert(err, ErrorMatches, "unexpected command.*")
+}
+
+func (s *FetchSuite) TestEncodeResponseAcknowledgments(c *C) {
+	res := NewFetchResponse()
+
+	var buf bytes.Buffer
+	c.Assert(res.Encode(&buf), IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, pktlines(c, "acknowledgments\n", "NAK\n", ""))
+
+	res.ACKs = []plumbing.Hash{plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")}
+	res.Ready = true
+
+	buf.Reset()
+	c.Assert(res.Encode(&buf), IsNil)
+
+	decoded := NewFetchResponse()
+	c.Assert(decoded.Decode(&buf), IsNil)
+	c.Assert(decoded.ACKs, DeepEquals, res.ACKs)
+	c.Assert(decoded.Ready, Equals, true)
+	c.Assert(decoded.Packfile, IsNil)
+}
+
+func (s *FetchSuite) TestEncodeResponsePackfile(c *C) {
+	res := NewFetchResponse()
+	res.Shallows = []plumbing.Hash{plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")}
+	res.Unshallows = []plumbing.Hash{plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")}
+	res.Packfile = bytes.NewReader(pktlines(c, "\x01PACK"))
+
+	var buf bytes.Buffer
+	c.Assert(res.Encode(&buf), IsNil)
+
+	expected := string(pktlines(c,
+		"shallow-info\n",
+		"shallow b029517f6300c2da0f4b651b8642506cd6aaf45d\n",
+		"unshallow 918c48b83bd081e863dbe1b80f8998f058cd8294\n",
+	)) + "0001" + string(pktlines(c, "packfile\n", "\x01PACK", ""))
+	c.Assert(buf.String(), Equals, expected)
+
+	decoded := NewFetchResponse()
+	c.Assert(decoded.Decode(&buf), IsNil)
+	c.Assert(decoded.Shallows, DeepEquals, res.Shallows)
+	c.Assert(decoded.Unshallows, DeepEquals, res.Unshallows)
+
+	pack, err := io.ReadAll(decoded.Packfile)
+	c.Assert(err, IsNil)
+	c.Assert(pack, DeepEquals, pktlines(c, "\x01PACK", ""))
+}
+
+func (s *FetchSuite) TestDecodeResponseAcknowledgments(c *C) {
+	r := toPktLines(c, []string{
+		"acknowledgments\n",
+		"ACK 918c48b83bd081e863dbe1b80f8998f058cd8294\n",
+		"ready\n",
+		"",
+	})
+
+	res := NewFetchResponse()
+	c.Assert(res.Decode(r), IsNil)
+	c.Assert(res.ACKs, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+	})
+	c.Assert(res.Ready, Equals, true)
+	c.Assert(res.Packfile, IsNil)
+}
+
+func (s *FetchSuite) TestDecodeResponsePackfile(c *C) {
+	input := string(pktlines(c, "acknowledgments\n", "NAK\n")) + "0001" +
+		string(pktlines(c,
+			"shallow-info\n",
+			"shallow b029517f6300c2da0f4b651b8642506cd6aaf45d\n",
+			"unshallow 918c48b83bd081e863dbe1b80f8998f058cd8294\n",
+		)) + "0001" +
+		string(pktlines(c,
+			"wanted-refs\n",
+			"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n",
+		)) + "0001" +
+		string(pktlines(c,
+			"packfile-uris\n",
+			"a3fed42da1e8189a077c0e6846c040dcf73fc9dd https://example.com/pack\n",
+		)) + "0001" +
+		string(pktlines(c, "packfile\n", "\x01PACK", ""))
+
+	res := NewFetchResponse()
+	c.Assert(res.Decode(strings.NewReader(input)), IsNil)
+	c.Assert(res.ACKs, HasLen, 0)
+	c.Assert(res.Shallows, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+	})
+	c.Assert(res.Unshallows, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+	})
+	c.Assert(res.WantedRefs, DeepEquals, map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/heads/master": plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+	c.Assert(res.PackfileURIs, DeepEquals, []PackfileURI{{
+		Hash: plumbing.NewHash("a3fed42da1e8189a077c0e6846c040dcf73fc9dd"),
+		URI:  "https://example.com/pack",
+	}})
+
+	c.Assert(res.Packfile, NotNil)
+	pack, err := io.ReadAll(res.Packfile)
+	c.Assert(err, IsNil)
+	c.Assert(pack, DeepEquals, pktlines(c, "\x01PACK", ""))
+}
+
+func (s *FetchSuite) TestDecodeResponseUnexpectedSection(c *C) {
+	r := toPktLines(c, []string{"foo\n", ""})
+
+	res := NewFetchResponse()
+	c.Assert(res.Decode(r), ErrorMatches, "unexpected section in fetch response.*")
+}
+
+func (s *FetchSuite) TestDecodeResponseEmpty(c *C) {
+	res := NewFetchResponse()
+	c.Assert(res.Decode(bytes.NewReader(nil)), Equals, ErrEmptyInput)
+}
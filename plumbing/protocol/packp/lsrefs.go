@@ -0,0 +1,238 @@
+package packp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+)
+
+// LsRefsRequest values represent an ls-refs command of the version 2 of the
+// protocol, which lists the references of the repository. Values from this
+// type are not zero-value safe, use the New function instead.
+type LsRefsRequest struct {
+	// Capabilities are the capabilities sent with the command, like agent.
+	Capabilities *capability.List
+	// Symrefs requests the targets of the symbolic references.
+	Symrefs bool
+	// Peel requests the peeled hashes of the annotated tags.
+	Peel bool
+	// Unborn requests the symbolic references whose target does not exist,
+	// the server must support the unborn feature of ls-refs.
+	Unborn bool
+	// RefPrefixes restricts the references to the ones starting with one of
+	// the prefixes. All the references are listed if it is empty.
+	RefPrefixes []string
+}
+
+// NewLsRefsRequest returns a pointer to a new LsRefsRequest value, ready to be
+// used.
+func NewLsRefsRequest() *LsRefsRequest {
+	return &LsRefsRequest{
+		Capabilities: capability.NewList(),
+	}
+}
+
+// NewLsRefsRequestFromCommand returns a pointer to a new LsRefsRequest value,
+// decoded from the arguments of an ls-refs command request.
+func NewLsRefsRequestFromCommand(cmd *CommandRequest) (*LsRefsRequest, error) {
+	if err := cmd.checkCommand(capability.LsRefs); err != nil {
+		return nil, err
+	}
+
+	req := NewLsRefsRequest()
+	req.Capabilities = cmd.Capabilities
+	for _, arg := range cmd.Args {
+		switch {
+		case arg == "symrefs":
+			req.Symrefs = true
+		case arg == "peel":
+			req.Peel = true
+		case arg == string(unborn):
+			req.Unborn = true
+		case strings.HasPrefix(arg, refPrefix):
+			req.RefPrefixes = append(req.RefPrefixes, arg[len(refPrefix):])
+		default:
+			return nil, fmt.Errorf("unexpected ls-refs argument: %q", arg)
+		}
+	}
+
+	return req, nil
+}
+
+// Encode writes the ls-refs command to w.
+func (req *LsRefsRequest) Encode(w io.Writer) error {
+	var args []string
+	if req.Symrefs {
+		args = append(args, "symrefs")
+	}
+
+	if req.Peel {
+		args = append(args, "peel")
+	}
+
+	if req.Unborn {
+		args = append(args, "unborn")
+	}
+
+	for _, prefix := range req.RefPrefixes {
+		args = append(args, refPrefix+prefix)
+	}
+
+	return encodeCommand(w, capability.LsRefs, req.Capabilities, args)
+}
+
+// LsRefsResponse values represent the response to an ls-refs command.
+// Values from this type are not zero-value safe, use the New function
+// instead.
+type LsRefsResponse struct {
+	// References are the hash references, in the order of the server. The
+	// symbolic references with an existing target are included, with the
+	// hash of their target.
+	References []*plumbing.Reference
+	// Symrefs are the symbolic references, if requested.
+	Symrefs []*plumbing.Reference
+	// Peeled are the peeled hashes of the annotated tags, if requested.
+	Peeled map[string]plumbing.Hash
+}
+
+// NewLsRefsResponse returns a pointer to a new LsRefsResponse value, ready to
+// be used.
+func NewLsRefsResponse() *LsRefsResponse {
+	return &LsRefsResponse{
+		Peeled: make(map[string]plumbing.Hash),
+	}
+}
+
+// Decode reads the response from r.
+func (r *LsRefsResponse) Decode(reader io.Reader) error {
+	s := pktline.NewScanner(reader)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if len(line) == 0 {
+			return nil
+		}
+
+		if err := r.decodeLine(line); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("malformed ls-refs response: missing flush-pkt")
+}
+
+func (r *LsRefsResponse) decodeLine(line []byte) error {
+	fields := bytes.Split(line, sp)
+	if len(fields) < 2 {
+		return fmt.Errorf("malformed ls-refs response: %q", line)
+	}
+
+	name := plumbing.ReferenceName(fields[1])
+	isUnborn := bytes.Equal(fields[0], unborn)
+	if !isUnborn {
+		if len(fields[0]) != hashSize {
+			return fmt.Errorf("malformed ls-refs response: %q", line)
+		}
+
+		r.References = append(r.References,
+			plumbing.NewHashReference(name, plumbing.NewHash(string(fields[0]))))
+	}
+
+	for _, attr := range fields[2:] {
+		switch {
+		case bytes.HasPrefix(attr, symrefTarget):
+			target := plumbing.ReferenceName(attr[len(symrefTarget):])
+			r.Symrefs = append(r.Symrefs, plumbing.NewSymbolicReference(name, target))
+		case bytes.HasPrefix(attr, peeledAttr):
+			r.Peeled[name.String()] = plumbing.NewHash(string(attr[len(peeledAttr):]))
+		}
+	}
+
+	return nil
+}
+
+// Encode writes the response to w.
+func (r *LsRefsResponse) Encode(w io.Writer) error {
+	targets := make(map[plumbing.ReferenceName]plumbing.ReferenceName, len(r.Symrefs))
+	for _, ref := range r.Symrefs {
+		targets[ref.Name()] = ref.Target()
+	}
+
+	e := pktline.NewEncoder(w)
+	listed := make(map[plumbing.ReferenceName]bool, len(r.References))
+	for _, ref := range r.References {
+		listed[ref.Name()] = true
+		line := fmt.Sprintf("%s %s", ref.Hash(), ref.Name())
+		if target, ok := targets[ref.Name()]; ok {
+			line += fmt.Sprintf(" %s%s", symrefTarget, target)
+		}
+
+		if h, ok := r.Peeled[ref.Name().String()]; ok {
+			line += fmt.Sprintf(" %s%s", peeledAttr, h)
+		}
+
+		if err := e.Encodef("%s\n", line); err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range r.Symrefs {
+		if listed[ref.Name()] {
+			continue
+		}
+
+		if err := e.Encodef("%s %s %s%s\n", unborn, ref.Name(), symrefTarget, ref.Target()); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
+
+// AdvRefs returns the AdvRefs equivalent to the response, as sent by a server
+// with the given capabilities speaking the version 0 of the protocol. Only
+// the symbolic reference HEAD is advertised as a symref capability, and its
+// target is added with the hash of HEAD if it was filtered out by the
+// prefixes of the request.
+func (r *LsRefsResponse) AdvRefs(adv *CapabilityAdvertisement) (*AdvRefs, error) {
+	ar := NewAdvRefs()
+	ar.Capabilities = adv.UploadPackCapabilities()
+
+	for _, ref := range r.References {
+		h := ref.Hash()
+		if ref.Name() == plumbing.HEAD {
+			ar.Head = &h
+			continue
+		}
+
+		ar.References[ref.Name().String()] = h
+	}
+
+	for name, h := range r.Peeled {
+		ar.Peeled[name] = h
+	}
+
+	for _, ref := range r.Symrefs {
+		if ref.Name() != plumbing.HEAD || ar.Head == nil {
+			continue
+		}
+
+		if err := ar.AddReference(ref); err != nil {
+			return nil, err
+		}
+
+		if _, ok := ar.References[ref.Target().String()]; !ok {
+			ar.References[ref.Target().String()] = *ar.Head
+		}
+	}
+
+	return ar, nil
+}
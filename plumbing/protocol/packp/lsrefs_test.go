@@ -0,0 +1,133 @@
+package packp
+
+import (
+	"bytes"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+
+	. "gopkg.in/check.v1"
+)
+
+type LsRefsSuite struct{}
+
+var _ = Suite(&LsRefsSuite{})
+
+func (s *LsRefsSuite) TestEncodeRequest(c *C) {
+	req := NewLsRefsRequest()
+	c.Assert(req.Capabilities.Add(capability.Agent, "go-git/5.x"), IsNil)
+	req.Symrefs = true
+	req.Peel = true
+	req.RefPrefixes = []string{"HEAD", "refs/heads/"}
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+
+	expected := string(pktlines(c, "command=ls-refs\n", "agent=go-git/5.x\n")) +
+		"0001" + string(pktlines(c,
+		"symrefs\n",
+		"peel\n",
+		"ref-prefix HEAD\n",
+		"ref-prefix refs/heads/\n",
+		"",
+	))
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *LsRefsSuite) TestDecodeResponse(c *C) {
+	r := toPktLines(c, []string{
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD symref-target:refs/heads/master\n",
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n",
+		"b029517f6300c2da0f4b651b8642506cd6aaf45d refs/tags/v1.0.0 peeled:6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		"unborn refs/remotes/origin/HEAD symref-target:refs/remotes/origin/main\n",
+		"",
+	})
+
+	res := NewLsRefsResponse()
+	c.Assert(res.Decode(r), IsNil)
+	c.Assert(res.References, DeepEquals, []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("HEAD", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewReferenceFromStrings("refs/heads/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewReferenceFromStrings("refs/tags/v1.0.0", "b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+	})
+	c.Assert(res.Symrefs, DeepEquals, []*plumbing.Reference{
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master),
+		plumbing.NewSymbolicReference("refs/remotes/origin/HEAD", "refs/remotes/origin/main"),
+	})
+	c.Assert(res.Peeled, DeepEquals, map[string]plumbing.Hash{
+		"refs/tags/v1.0.0": plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+}
+
+func (s *LsRefsSuite) TestDecodeResponseMalformed(c *C) {
+	r := toPktLines(c, []string{"6ecf0ef2 HEAD\n", ""})
+
+	res := NewLsRefsResponse()
+	c.Assert(res.Decode(r), ErrorMatches, "malformed ls-refs response.*")
+}
+
+func (s *LsRefsSuite) TestEncodeResponse(c *C) {
+	res := NewLsRefsResponse()
+	res.References = []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("HEAD", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewReferenceFromStrings("refs/tags/v1.0.0", "b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+	}
+	res.Symrefs = []*plumbing.Reference{
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master),
+		plumbing.NewSymbolicReference("refs/remotes/origin/HEAD", "refs/remotes/origin/main"),
+	}
+	res.Peeled["refs/tags/v1.0.0"] = plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	var buf bytes.Buffer
+	c.Assert(res.Encode(&buf), IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, pktlines(c,
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD symref-target:refs/heads/master\n",
+		"b029517f6300c2da0f4b651b8642506cd6aaf45d refs/tags/v1.0.0 peeled:6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		"unborn refs/remotes/origin/HEAD symref-target:refs/remotes/origin/main\n",
+		"",
+	))
+}
+
+func (s *LsRefsSuite) TestAdvRefs(c *C) {
+	head := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	res := NewLsRefsResponse()
+	res.References = []*plumbing.Reference{
+		plumbing.NewHashReference(plumbing.HEAD, head),
+		plumbing.NewReferenceFromStrings("refs/tags/v1.0.0", "b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+	}
+	res.Symrefs = []*plumbing.Reference{
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master),
+	}
+	res.Peeled["refs/tags/v1.0.0"] = head
+
+	adv := NewCapabilityAdvertisement()
+	c.Assert(adv.Capabilities.Add(capability.Fetch), IsNil)
+
+	ar, err := res.AdvRefs(adv)
+	c.Assert(err, IsNil)
+	c.Assert(*ar.Head, Equals, head)
+	c.Assert(ar.Capabilities.Get(capability.SymRef), DeepEquals, []string{"HEAD:refs/heads/master"})
+	c.Assert(ar.References, DeepEquals, map[string]plumbing.Hash{
+		// The target of HEAD is not listed, but it is known.
+		"refs/heads/master": head,
+		"refs/tags/v1.0.0":  plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"),
+	})
+	c.Assert(ar.Peeled, DeepEquals, map[string]plumbing.Hash{"refs/tags/v1.0.0": head})
+}
+
+func (s *LsRefsSuite) TestNewRequestFromCommand(c *C) {
+	cmd := NewCommandRequest()
+	cmd.Command = "ls-refs"
+	cmd.Args = []string{"symrefs", "peel", "unborn", "ref-prefix HEAD", "ref-prefix refs/tags/"}
+
+	req, err := NewLsRefsRequestFromCommand(cmd)
+	c.Assert(err, IsNil)
+	c.Assert(req.Symrefs, Equals, true)
+	c.Assert(req.Peel, Equals, true)
+	c.Assert(req.Unborn, Equals, true)
+	c.Assert(req.RefPrefixes, DeepEquals, []string{"HEAD", "refs/tags/"})
+
+	cmd.Args = []string{"foo"}
+	_, err = NewLsRefsRequestFromCommand(cmd)
+	c.Assert(err, ErrorMatches, "unexpected ls-refs argument.*")
+}
@@ -0,0 +1,175 @@
+package packp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+)
+
+const (
+	// objectInfoSize is the size attribute of object-info.
+	objectInfoSize = "size"
+	// objectInfoOID prefixes the objects of an object-info request.
+	objectInfoOID = "oid "
+)
+
+// ObjectInfoRequest values represent an object-info command of the version 2
+// of the protocol, which retrieves information about objects without
+// fetching them. Values from this type are not zero-value safe, use the New
+// function instead.
+type ObjectInfoRequest struct {
+	// Capabilities are the capabilities sent with the command, like agent.
+	Capabilities *capability.List
+	// Size requests the size of the objects.
+	Size bool
+	// Hashes are the objects.
+	Hashes []plumbing.Hash
+}
+
+// NewObjectInfoRequest returns a pointer to a new ObjectInfoRequest value,
+// ready to be used.
+func NewObjectInfoRequest() *ObjectInfoRequest {
+	return &ObjectInfoRequest{
+		Capabilities: capability.NewList(),
+	}
+}
+
+// NewObjectInfoRequestFromCommand returns a pointer to a new
+// ObjectInfoRequest value, decoded from the arguments of an object-info
+// command request.
+func NewObjectInfoRequestFromCommand(cmd *CommandRequest) (*ObjectInfoRequest, error) {
+	if err := cmd.checkCommand(capability.ObjectInfo); err != nil {
+		return nil, err
+	}
+
+	req := NewObjectInfoRequest()
+	req.Capabilities = cmd.Capabilities
+	for _, arg := range cmd.Args {
+		switch {
+		case arg == objectInfoSize:
+			req.Size = true
+		case strings.HasPrefix(arg, objectInfoOID):
+			h, err := decodeHashArg(arg[len(objectInfoOID):])
+			if err != nil {
+				return nil, err
+			}
+
+			req.Hashes = append(req.Hashes, h)
+		default:
+			return nil, fmt.Errorf("unexpected object-info argument: %q", arg)
+		}
+	}
+
+	return req, nil
+}
+
+// Encode writes the object-info command to w.
+func (req *ObjectInfoRequest) Encode(w io.Writer) error {
+	var args []string
+	if req.Size {
+		args = append(args, objectInfoSize)
+	}
+
+	for _, h := range req.Hashes {
+		args = append(args, objectInfoOID+h.String())
+	}
+
+	return encodeCommand(w, capability.ObjectInfo, req.Capabilities, args)
+}
+
+// ObjectInfo is the information about an object returned by object-info.
+type ObjectInfo struct {
+	Hash plumbing.Hash
+	// Size is the size of the object, if requested.
+	Size int64
+}
+
+// ObjectInfoResponse values represent the response to an object-info command.
+type ObjectInfoResponse struct {
+	// Size is true if the sizes of the objects are returned.
+	Size bool
+	// Objects are the information about the objects, in the order of the
+	// request.
+	Objects []ObjectInfo
+}
+
+// Decode reads the response from r.
+func (r *ObjectInfoResponse) Decode(reader io.Reader) error {
+	s := pktline.NewScanner(reader)
+	for first := true; s.Scan(); first = false {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		if len(line) == 0 {
+			return nil
+		}
+
+		// The attributes are only listed if some were requested.
+		if first && string(line) == objectInfoSize {
+			r.Size = true
+			continue
+		}
+
+		if err := r.decodeLine(line); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("malformed object-info response: missing flush-pkt")
+}
+
+func (r *ObjectInfoResponse) decodeLine(line []byte) error {
+	fields := bytes.Fields(line)
+	n := 1
+	if r.Size {
+		n++
+	}
+
+	if len(fields) != n || len(fields[0]) != hashSize {
+		return fmt.Errorf("malformed object-info: %q", line)
+	}
+
+	info := ObjectInfo{Hash: plumbing.NewHash(string(fields[0]))}
+	if r.Size {
+		size, err := strconv.ParseInt(string(fields[1]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("malformed object-info size: %q", line)
+		}
+
+		info.Size = size
+	}
+
+	r.Objects = append(r.Objects, info)
+	return nil
+}
+
+// Encode writes the response to w.
+func (r *ObjectInfoResponse) Encode(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	if r.Size {
+		if err := e.Encodef("%s\n", objectInfoSize); err != nil {
+			return err
+		}
+	}
+
+	for _, info := range r.Objects {
+		line := info.Hash.String()
+		if r.Size {
+			line += " " + strconv.FormatInt(info.Size, 10)
+		}
+
+		if err := e.Encodef("%s\n", line); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
@@ -0,0 +1,82 @@
+package packp
+
+import (
+	"bytes"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+type ObjectInfoSuite struct{}
+
+var _ = Suite(&ObjectInfoSuite{})
+
+func (s *ObjectInfoSuite) TestEncodeRequest(c *C) {
+	req := NewObjectInfoRequest()
+	req.Size = true
+	req.Hashes = []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")}
+
+	var buf bytes.Buffer
+	c.Assert(req.Encode(&buf), IsNil)
+
+	expected := string(pktlines(c, "command=object-info\n")) + "0001" + string(pktlines(c,
+		"size\n",
+		"oid 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		"",
+	))
+	c.Assert(buf.String(), Equals, expected)
+}
+
+func (s *ObjectInfoSuite) TestNewRequestFromCommand(c *C) {
+	cmd := NewCommandRequest()
+	cmd.Command = "object-info"
+	cmd.Args = []string{"size", "oid 6ecf0ef2c2dffb796033e5a02219af86ec6584e5"}
+
+	req, err := NewObjectInfoRequestFromCommand(cmd)
+	c.Assert(err, IsNil)
+	c.Assert(req.Size, Equals, true)
+	c.Assert(req.Hashes, DeepEquals, []plumbing.Hash{plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")})
+
+	cmd.Args = []string{"oid foo"}
+	_, err = NewObjectInfoRequestFromCommand(cmd)
+	c.Assert(err, NotNil)
+}
+
+func (s *ObjectInfoSuite) TestEncodeDecodeResponse(c *C) {
+	res := &ObjectInfoResponse{
+		Size: true,
+		Objects: []ObjectInfo{
+			{Hash: plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"), Size: 245},
+		},
+	}
+
+	var buf bytes.Buffer
+	c.Assert(res.Encode(&buf), IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, pktlines(c,
+		"size\n",
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 245\n",
+		"",
+	))
+
+	decoded := &ObjectInfoResponse{}
+	c.Assert(decoded.Decode(&buf), IsNil)
+	c.Assert(decoded, DeepEquals, res)
+}
+
+func (s *ObjectInfoSuite) TestDecodeResponseWithoutAttributes(c *C) {
+	r := toPktLines(c, []string{"6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n", ""})
+
+	res := &ObjectInfoResponse{}
+	c.Assert(res.Decode(r), IsNil)
+	c.Assert(res.Objects, DeepEquals, []ObjectInfo{
+		{Hash: plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")},
+	})
+}
+
+func (s *ObjectInfoSuite) TestDecodeResponseMalformed(c *C) {
+	r := toPktLines(c, []string{"size\n", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n", ""})
+
+	res := &ObjectInfoResponse{}
+	c.Assert(res.Decode(r), ErrorMatches, "malformed object-info.*")
+}
@@ -26,6 +26,11 @@ func NewMuxer(t Type, w io.Writer) *Muxer {
 		max = MaxPackedSize
 	}
 
+	// The payload of a pkt-line can't exceed pktline.MaxPayloadSize.
+	if max > pktline.MaxPayloadSize {
+		max = pktline.MaxPayloadSize
+	}
+
 	return &Muxer{
 		max: max - chLen,
 		e:   pktline.NewEncoder(w),
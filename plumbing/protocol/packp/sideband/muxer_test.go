@@ -2,6 +2,7 @@ package sideband
 
 import (
 	"bytes"
+	"io"
 
 	. "gopkg.in/check.v1"
 )
@@ -17,6 +18,21 @@ func (s *SidebandSuite) TestMuxerWrite(c *C) {
 	c.Assert(buf.Len(), Equals, 2008)
 }
 
+func (s *SidebandSuite) TestMuxerWrite64k(c *C) {
+	buf := bytes.NewBuffer(nil)
+
+	m := NewMuxer(Sideband64k, buf)
+
+	n, err := m.Write(bytes.Repeat([]byte{'F'}, MaxPackedSize64k))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, MaxPackedSize64k)
+
+	d := NewDemuxer(Sideband64k, buf)
+	content, err := io.ReadAll(d)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, MaxPackedSize64k)
+}
+
 func (s *SidebandSuite) TestMuxerWriteChannelMultipleChannels(c *C) {
 	buf := bytes.NewBuffer(nil)
 
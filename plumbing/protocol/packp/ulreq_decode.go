@@ -81,6 +81,12 @@ func (d *ulReqDecoder) decodeFirstWant() stateFn {
 		return nil
 	}
 
+	// A client with nothing to fetch only sends a flush-pkt.
+	if len(d.line) == 0 {
+		d.err = ErrEmptyInput
+		return nil
+	}
+
 	if !bytes.HasPrefix(d.line, want) {
 		d.error("missing 'want ' prefix")
 		return nil
@@ -136,6 +142,10 @@ func (d *ulReqDecoder) decodeOtherWants() stateFn {
 		return d.decodeDeepen
 	}
 
+	if bytes.HasPrefix(d.line, filter) {
+		return d.decodeFilter
+	}
+
 	if len(d.line) == 0 {
 		return nil
 	}
@@ -161,6 +171,10 @@ func (d *ulReqDecoder) decodeShallow() stateFn {
 		return d.decodeDeepen
 	}
 
+	if bytes.HasPrefix(d.line, filter) {
+		return d.decodeFilter
+	}
+
 	if len(d.line) == 0 {
 		return nil
 	}
@@ -219,7 +233,7 @@ func (d *ulReqDecoder) decodeDeepenCommits() stateFn {
 	}
 	d.data.Depth = DepthCommits(n)
 
-	return d.decodeFlush
+	return d.decodeAfterDeepen
 }
 
 func (d *ulReqDecoder) decodeDeepenSince() stateFn {
@@ -233,7 +247,7 @@ func (d *ulReqDecoder) decodeDeepenSince() stateFn {
 	t := time.Unix(secs, 0).UTC()
 	d.data.Depth = DepthSince(t)
 
-	return d.decodeFlush
+	return d.decodeAfterDeepen
 }
 
 func (d *ulReqDecoder) decodeDeepenReference() stateFn {
@@ -241,6 +255,36 @@ func (d *ulReqDecoder) decodeDeepenReference() stateFn {
 
 	d.data.Depth = DepthReference(string(d.line))
 
+	return d.decodeAfterDeepen
+}
+
+// Expected format: filter <filter-spec> / flush-pkt
+func (d *ulReqDecoder) decodeAfterDeepen() stateFn {
+	if ok := d.nextLine(); !ok {
+		return nil
+	}
+
+	if bytes.HasPrefix(d.line, filter) {
+		return d.decodeFilter
+	}
+
+	if len(d.line) != 0 {
+		d.err = fmt.Errorf("unexpected payload while expecting a flush-pkt: %q", d.line)
+	}
+
+	return nil
+}
+
+// Expected format: filter <filter-spec>
+func (d *ulReqDecoder) decodeFilter() stateFn {
+	d.line = bytes.TrimPrefix(d.line, filter)
+	if len(d.line) == 0 {
+		d.error("empty filter")
+		return nil
+	}
+
+	d.data.Filter = Filter(d.line)
+
 	return d.decodeFlush
 }
 
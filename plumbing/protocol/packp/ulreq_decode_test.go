@@ -27,6 +27,12 @@ func (s *UlReqDecodeSuite) TestEmpty(c *C) {
 	c.Assert(err, ErrorMatches, "pkt-line 1: EOF")
 }
 
+func (s *UlReqDecodeSuite) TestFlushOnly(c *C) {
+	r := toPktLines(c, []string{pktline.FlushString})
+	ur := NewUploadRequest()
+	c.Assert(ur.Decode(r), Equals, ErrEmptyInput)
+}
+
 func (s *UlReqDecodeSuite) TestNoWant(c *C) {
 	payloads := []string{
 		"foobar",
@@ -478,6 +484,40 @@ func (s *UlReqDecodeSuite) TestDeepenReference(c *C) {
 	c.Assert(string(reference), Equals, expected)
 }
 
+func (s *UlReqDecodeSuite) TestFilter(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 ofs-delta filter",
+		"filter blob:none",
+		pktline.FlushString,
+	}
+	ur := s.testDecodeOK(c, payloads)
+	c.Assert(ur.Filter, Equals, FilterBlobNone())
+}
+
+func (s *UlReqDecodeSuite) TestDeepenWithFilter(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 ofs-delta filter",
+		"shallow aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"deepen 1",
+		"filter tree:0",
+		pktline.FlushString,
+	}
+	ur := s.testDecodeOK(c, payloads)
+	c.Assert(ur.Depth, Equals, DepthCommits(1))
+	c.Assert(ur.Shallows, HasLen, 1)
+	c.Assert(ur.Filter, Equals, FilterTreeDepth(0))
+}
+
+func (s *UlReqDecodeSuite) TestEmptyFilter(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 ofs-delta filter",
+		"filter ",
+		pktline.FlushString,
+	}
+	r := toPktLines(c, payloads)
+	s.testDecoderErrorMatches(c, r, ".*empty filter.*")
+}
+
 func (s *UlReqDecodeSuite) TestAll(c *C) {
 	payloads := []string{
 		"want 3333333333333333333333333333333333333333 ofs-delta multi_ack",
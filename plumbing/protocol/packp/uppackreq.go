@@ -38,6 +38,12 @@ func NewUploadPackRequestFromCapabilities(adv *capability.List) *UploadPackReque
 	}
 }
 
+// Decode decodes the upload-request of r. The haves are sent by the client in
+// the following negotiation rounds, see UploadHaves.Decode.
+func (r *UploadPackRequest) Decode(rd io.Reader) error {
+	return r.UploadRequest.Decode(rd)
+}
+
 // IsEmpty returns whether a request is empty - it is empty if Haves are contained
 // in the Wants, or if Wants length is zero, and we don't have any shallows
 func (r *UploadPackRequest) IsEmpty() bool {
@@ -96,3 +102,34 @@ func (u *UploadHaves) Encode(w io.Writer, flush bool) error {
 
 	return nil
 }
+
+// Decode decodes the haves of a negotiation round into u.Haves. The round
+// ends with a flush-pkt, or with a done line if the client does not send more
+// haves, in which case done is true.
+func (u *UploadHaves) Decode(r io.Reader) (done bool, err error) {
+	s := pktline.NewScanner(r)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), eol)
+		switch {
+		case len(line) == 0:
+			return false, nil
+		case bytes.Equal(line, doneLine):
+			return true, nil
+		case bytes.HasPrefix(line, have):
+			h, err := decodeHashArg(string(bytes.TrimPrefix(line, have)))
+			if err != nil {
+				return false, err
+			}
+
+			u.Haves = append(u.Haves, h)
+		default:
+			return false, NewErrUnexpectedData("unexpected payload while expecting a have", line)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return false, err
+	}
+
+	return false, io.ErrUnexpectedEOF
+}
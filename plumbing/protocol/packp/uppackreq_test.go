@@ -2,8 +2,10 @@ package packp
 
 import (
 	"bytes"
+	"io"
 
 	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
 
 	. "gopkg.in/check.v1"
@@ -74,3 +76,42 @@ func (s *UploadHavesSuite) TestEncode(c *C) {
 		"0000",
 	)
 }
+
+func (s *UploadHavesSuite) TestDecode(c *C) {
+	r := toPktLines(c, []string{
+		"have 1111111111111111111111111111111111111111\n",
+		"have 2222222222222222222222222222222222222222\n",
+		pktline.FlushString,
+		"have 3333333333333333333333333333333333333333\n",
+		"done\n",
+	})
+
+	uh := &UploadHaves{}
+	done, err := uh.Decode(r)
+	c.Assert(err, IsNil)
+	c.Assert(done, Equals, false)
+	c.Assert(uh.Haves, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("1111111111111111111111111111111111111111"),
+		plumbing.NewHash("2222222222222222222222222222222222222222"),
+	})
+
+	uh = &UploadHaves{}
+	done, err = uh.Decode(r)
+	c.Assert(err, IsNil)
+	c.Assert(done, Equals, true)
+	c.Assert(uh.Haves, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("3333333333333333333333333333333333333333"),
+	})
+
+	_, err = uh.Decode(r)
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+}
+
+func (s *UploadHavesSuite) TestDecodeInvalid(c *C) {
+	uh := &UploadHaves{}
+	_, err := uh.Decode(toPktLines(c, []string{"want 1111111111111111111111111111111111111111\n"}))
+	c.Assert(err, ErrorMatches, ".*expecting a have.*")
+
+	_, err = uh.Decode(toPktLines(c, []string{"have 1111\n"}))
+	c.Assert(err, ErrorMatches, "invalid hash.*")
+}
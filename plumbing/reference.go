@@ -217,6 +217,25 @@ const (
 	HEAD   ReferenceName = "HEAD"
 	Master ReferenceName = "refs/heads/master"
 	Main   ReferenceName = "refs/heads/main"
+
+	// MergeHead records the commit being merged while a merge is stopped
+	// on conflicts.
+	MergeHead ReferenceName = "MERGE_HEAD"
+	// CherryPickHead records the commit being picked while a cherry-pick
+	// is stopped on conflicts.
+	CherryPickHead ReferenceName = "CHERRY_PICK_HEAD"
+	// RevertHead records the commit being reverted while a revert is
+	// stopped on conflicts.
+	RevertHead ReferenceName = "REVERT_HEAD"
+	// RebaseHead records the commit being applied while a rebase is stopped
+	// on conflicts.
+	RebaseHead ReferenceName = "REBASE_HEAD"
+	// OrigHead records the previous position of HEAD before a drastic
+	// operation, such as a rebase.
+	OrigHead ReferenceName = "ORIG_HEAD"
+	// Stash points to the latest stash entry, the older ones are recorded
+	// in its reflog.
+	Stash ReferenceName = "refs/stash"
 )
 
 // Reference is a representation of git reference
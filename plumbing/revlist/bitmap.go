@@ -0,0 +1,179 @@
+package revlist
+
+import (
+	"fmt"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/bitmap"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+)
+
+// bitmapObjects is the same as objects, but the objects reachable from the
+// commits with a bitmap are taken from it instead of walking their history.
+func bitmapObjects(
+	s storer.EncodedObjectStorer,
+	b *bitmap.PackBitmap,
+	objs,
+	ignore []plumbing.Hash,
+) ([]plumbing.Hash, error) {
+	ignored := newReachable(s, b, nil)
+	if err := ignored.walk(ignore, true); err != nil {
+		return nil, err
+	}
+
+	wanted := newReachable(s, b, ignored)
+	if err := wanted.walk(objs, false); err != nil {
+		return nil, err
+	}
+
+	wanted.packed.AndNot(ignored.packed)
+
+	var result []plumbing.Hash
+	err := wanted.packed.ForEach(func(pos int) error {
+		h, err := b.Hash(pos)
+		if err != nil {
+			return err
+		}
+
+		result = append(result, h)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for h := range wanted.unpacked {
+		if !ignored.unpacked[h] {
+			result = append(result, h)
+		}
+	}
+
+	return result, nil
+}
+
+// reachable is a set of reachable objects, with the objects of the pack with
+// bitmaps in a bitmap.
+type reachable struct {
+	s storer.EncodedObjectStorer
+	b *bitmap.PackBitmap
+	// stop are objects whose history is not walked, if not nil.
+	stop *reachable
+
+	packed   *bitmap.Bitmap
+	unpacked map[plumbing.Hash]bool
+}
+
+// walkItem is an object to walk, with its type if known.
+type walkItem struct {
+	hash plumbing.Hash
+	typ  plumbing.ObjectType
+}
+
+func newReachable(s storer.EncodedObjectStorer, b *bitmap.PackBitmap, stop *reachable) *reachable {
+	return &reachable{
+		s:        s,
+		b:        b,
+		stop:     stop,
+		packed:   bitmap.NewBitmap(),
+		unpacked: make(map[plumbing.Hash]bool),
+	}
+}
+
+func (r *reachable) has(h plumbing.Hash, pos int, packed bool) bool {
+	if packed {
+		return r.packed.Get(pos)
+	}
+
+	return r.unpacked[h]
+}
+
+func (r *reachable) add(h plumbing.Hash, pos int, packed bool) {
+	if packed {
+		r.packed.Set(pos)
+	} else {
+		r.unpacked[h] = true
+	}
+}
+
+// walk adds the objects reachable from the given ones. Missing objects are
+// skipped if allowMissingObjects is true.
+func (r *reachable) walk(hashes []plumbing.Hash, allowMissingObjects bool) error {
+	var pending []walkItem
+	for i := len(hashes) - 1; i >= 0; i-- {
+		pending = append(pending, walkItem{hashes[i], plumbing.AnyObject})
+	}
+
+	for len(pending) > 0 {
+		item := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		pos, packed, err := r.b.Position(item.hash)
+		if err != nil {
+			return err
+		}
+
+		if r.has(item.hash, pos, packed) || r.stop != nil && r.stop.has(item.hash, pos, packed) {
+			continue
+		}
+
+		if reachable, ok := r.b.Reachable(item.hash); ok {
+			r.packed.Or(reachable)
+			continue
+		}
+
+		typ := item.typ
+		if typ == plumbing.AnyObject && packed {
+			typ = r.b.Type(pos)
+		}
+
+		// The blobs do not need to be read.
+		if typ == plumbing.BlobObject {
+			r.add(item.hash, pos, packed)
+			continue
+		}
+
+		o, err := r.s.EncodedObject(plumbing.AnyObject, item.hash)
+		if err == plumbing.ErrObjectNotFound && allowMissingObjects {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		r.add(item.hash, pos, packed)
+		do, err := object.DecodeObject(r.s, o)
+		if err != nil {
+			return err
+		}
+
+		switch do := do.(type) {
+		case *object.Commit:
+			pending = append(pending, walkItem{do.TreeHash, plumbing.TreeObject})
+			// The parents are walked first, as they may have bitmaps.
+			for _, p := range do.ParentHashes {
+				pending = append(pending, walkItem{p, plumbing.CommitObject})
+			}
+		case *object.Tree:
+			for _, e := range do.Entries {
+				switch e.Mode {
+				case filemode.Submodule:
+				case filemode.Dir:
+					pending = append(pending, walkItem{e.Hash, plumbing.TreeObject})
+				default:
+					pending = append(pending, walkItem{e.Hash, plumbing.BlobObject})
+				}
+			}
+		case *object.Tag:
+			pending = append(pending, walkItem{do.Target, do.TargetType})
+		case *object.Blob:
+		default:
+			return fmt.Errorf("object type not valid: %s. "+
+				"Object reference: %s", o.Type(), o.Hash())
+		}
+	}
+
+	return nil
+}
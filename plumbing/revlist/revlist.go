@@ -15,12 +15,24 @@ import (
 // Objects applies a complementary set. It gets all the hashes from all
 // the reachable objects from the given objects. Ignore param are object hashes
 // that we want to ignore on the result. All that objects must be accessible
-// from the object storer.
+// from the object storer. If the storer has a pack with a bitmap index, the
+// bitmaps of its commits are used instead of walking their history.
 func Objects(
 	s storer.EncodedObjectStorer,
 	objs,
 	ignore []plumbing.Hash,
 ) ([]plumbing.Hash, error) {
+	if bs, ok := s.(storer.PackBitmapStorer); ok {
+		b, err := bs.PackBitmap()
+		if err != nil {
+			return nil, err
+		}
+
+		if b != nil {
+			return bitmapObjects(s, b, objs, ignore)
+		}
+	}
+
 	return ObjectsWithStorageForIgnores(s, s, objs, ignore)
 }
 
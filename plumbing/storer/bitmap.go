@@ -0,0 +1,13 @@
+package storer
+
+import (
+	"github.com/jesseduffield/go-git/v5/plumbing/format/bitmap"
+)
+
+// PackBitmapStorer is an optional interface for storers with packs which
+// have a bitmap index, giving the objects reachable from their commits.
+type PackBitmapStorer interface {
+	// PackBitmap returns the bitmaps of a pack with a bitmap index, or nil if
+	// there is none.
+	PackBitmap() (*bitmap.PackBitmap, error)
+}
@@ -98,6 +98,25 @@ type PackfileWriter interface {
 	PackfileWriter() (io.WriteCloser, error)
 }
 
+// ObjectQuarantiner is an optional interface for EncodedObjectStorer, it
+// enables receiving objects apart from the storage until they are accepted,
+// like the quarantine of the objects pushed to git receive-pack.
+type ObjectQuarantiner interface {
+	// ObjectQuarantine starts a quarantine.
+	ObjectQuarantine() (ObjectQuarantine, error)
+}
+
+// ObjectQuarantine is an EncodedObjectStorer writing the new objects apart
+// from the storage it was started from, whose objects it can also read. A
+// quarantine must end with a call to Commit or Abort.
+type ObjectQuarantine interface {
+	EncodedObjectStorer
+	// Commit moves the objects of the quarantine to the storage.
+	Commit() error
+	// Abort discards the objects of the quarantine.
+	Abort() error
+}
+
 // EncodedObjectIter is a generic closable interface for iterating over objects.
 type EncodedObjectIter interface {
 	Next() (plumbing.EncodedObject, error)
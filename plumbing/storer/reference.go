@@ -2,16 +2,27 @@ package storer
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"sort"
 
 	"github.com/jesseduffield/go-git/v5/plumbing"
 )
 
 const MaxResolveRecursion = 1024
 
-// ErrMaxResolveRecursion is returned by ResolveReference is MaxResolveRecursion
-// is exceeded
-var ErrMaxResolveRecursion = errors.New("max. recursion level reached")
+var (
+	// ErrMaxResolveRecursion is returned by ResolveReference is MaxResolveRecursion
+	// is exceeded
+	ErrMaxResolveRecursion = errors.New("max. recursion level reached")
+	// ErrReferenceTransactionClosed is returned when a reference transaction
+	// is used after being committed or aborted, or updated after being
+	// prepared.
+	ErrReferenceTransactionClosed = errors.New("reference transaction is closed")
+	// ErrDuplicatedReferenceUpdate is returned when a reference transaction
+	// updates the same reference more than once.
+	ErrDuplicatedReferenceUpdate = errors.New("reference updated more than once")
+)
 
 // ReferenceStorer is a generic storage of references.
 type ReferenceStorer interface {
@@ -28,6 +39,79 @@ type ReferenceStorer interface {
 	PackRefs() error
 }
 
+// ReferenceTransactioner is an optional interface for ReferenceStorer, it
+// enables updating several references atomically.
+type ReferenceTransactioner interface {
+	// ReferenceTransaction starts a reference transaction.
+	ReferenceTransaction() (ReferenceTransaction, error)
+}
+
+// ReferenceTransaction is a set of updates of references, which are either
+// all applied or none of them. Each update can give the expected current
+// value of its reference, as an old reference which is either nil, if the
+// value is not checked, or a hash reference with a zero hash if the
+// reference must not exist.
+type ReferenceTransaction interface {
+	// SetReference adds the update of ref to the transaction.
+	SetReference(ref, old *plumbing.Reference) error
+	// RemoveReference adds the removal of the named reference to the
+	// transaction. Removing a reference which does not exist is a no-op.
+	RemoveReference(name plumbing.ReferenceName, old *plumbing.Reference) error
+	// Prepare locks the references and checks their current values. It
+	// returns an error, and aborts the transaction, if a reference cannot
+	// be locked or does not have its expected value.
+	Prepare() error
+	// Commit applies the updates, preparing the transaction first if it
+	// was not.
+	Commit() error
+	// Abort releases the locks of the transaction without applying its
+	// updates.
+	Abort() error
+}
+
+// ReferenceUpdate is an update of a reference in a ReferenceTransaction.
+type ReferenceUpdate struct {
+	// Name is the name of the updated reference.
+	Name plumbing.ReferenceName
+	// Ref is the new value of the reference, or nil if it is removed.
+	Ref *plumbing.Reference
+	// Old is the expected value of the reference, or nil if it is not
+	// checked.
+	Old *plumbing.Reference
+}
+
+// Expected returns whether current, the value of the reference or nil if it
+// does not exist, is the one expected by the update.
+func (u *ReferenceUpdate) Expected(current *plumbing.Reference) bool {
+	switch {
+	case u.Old == nil:
+		return true
+	case current == nil:
+		return u.Old.Type() == plumbing.HashReference && u.Old.Hash().IsZero()
+	case u.Old.Type() == plumbing.SymbolicReference:
+		return current.Type() == plumbing.SymbolicReference && current.Target() == u.Old.Target()
+	default:
+		return current.Type() == plumbing.HashReference && current.Hash() == u.Old.Hash()
+	}
+}
+
+// SortReferenceUpdates sorts the updates by reference name, the order in
+// which their references are locked. It returns ErrDuplicatedReferenceUpdate
+// if a reference is updated more than once.
+func SortReferenceUpdates(updates []ReferenceUpdate) error {
+	sort.SliceStable(updates, func(i, j int) bool {
+		return updates[i].Name < updates[j].Name
+	})
+
+	for i := 1; i < len(updates); i++ {
+		if updates[i].Name == updates[i-1].Name {
+			return fmt.Errorf("%w: %s", ErrDuplicatedReferenceUpdate, updates[i].Name)
+		}
+	}
+
+	return nil
+}
+
 // ReferenceIter is a generic closable interface for iterating over references.
 type ReferenceIter interface {
 	Next() (*plumbing.Reference, error)
@@ -0,0 +1,21 @@
+package storer
+
+import (
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+)
+
+// ReflogStorer is a storage of reflogs, the logs of the updates of the
+// references. The entries of a reflog are ordered oldest first.
+type ReflogStorer interface {
+	// Reflog returns the entries of the reflog of the given reference, or
+	// no entries if it has no reflog.
+	Reflog(plumbing.ReferenceName) ([]*reflog.Entry, error)
+	// AppendReflog adds an entry at the end of the reflog of the given
+	// reference, creating it if needed.
+	AppendReflog(plumbing.ReferenceName, *reflog.Entry) error
+	// SetReflog replaces the entries of the reflog of the given reference.
+	SetReflog(plumbing.ReferenceName, []*reflog.Entry) error
+	// RemoveReflog removes the reflog of the given reference, if any.
+	RemoveReflog(plumbing.ReferenceName) error
+}
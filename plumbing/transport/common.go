@@ -37,6 +37,7 @@ var (
 	ErrEmptyUploadPackRequest = errors.New("empty git-upload-pack given")
 	ErrInvalidAuthMethod      = errors.New("invalid auth method")
 	ErrAlreadyConnected       = errors.New("session already established")
+	ErrProtocolV2Unsupported  = errors.New("server does not support protocol v2")
 )
 
 const (
@@ -84,6 +85,43 @@ type UploadPackSession interface {
 	UploadPack(context.Context, *packp.UploadPackRequest) (*packp.UploadPackResponse, error)
 }
 
+// UploadPackV2Session is an UploadPackSession which requests the version 2 of
+// the protocol from the server. When the server speaks it, the references are
+// listed with the ls-refs command and the packfiles are fetched with the fetch
+// command, also behind the UploadPackSession methods.
+type UploadPackV2Session interface {
+	UploadPackSession
+	// CapabilityAdvertisement returns the capabilities advertised by the
+	// server. If the server does not speak the version 2 of the protocol,
+	// ErrProtocolV2Unsupported is returned, and the UploadPackSession methods
+	// must be used instead.
+	CapabilityAdvertisement(context.Context) (*packp.CapabilityAdvertisement, error)
+	// LsRefs runs an ls-refs command, listing the references of the
+	// repository.
+	LsRefs(context.Context, *packp.LsRefsRequest) (*packp.LsRefsResponse, error)
+	// Fetch runs a fetch command. The packfile of the response, if any, must
+	// be read before running another command.
+	Fetch(context.Context, *packp.FetchRequest) (*packp.FetchResponse, error)
+	// ObjectInfo runs an object-info command, retrieving information about
+	// objects without fetching them.
+	ObjectInfo(context.Context, *packp.ObjectInfoRequest) (*packp.ObjectInfoResponse, error)
+}
+
+// UploadPackNegotiator is implemented by the UploadPackSession of a server,
+// which negotiates the common objects with a client speaking the version 0 or
+// 1 of the protocol. The packfile is then requested with UploadPack, the haves
+// of the request being the common objects.
+type UploadPackNegotiator interface {
+	// ShallowUpdate returns the changes of the shallow commits of the client
+	// requested by the deepen lines of the request, which are sent before the
+	// negotiation.
+	ShallowUpdate(context.Context, *packp.UploadRequest) (*packp.ShallowUpdate, error)
+	// Negotiate returns the haves which are common objects. Ready is true if
+	// they are enough to send the packfile, with the common objects found in
+	// the previous rounds.
+	Negotiate(ctx context.Context, req *packp.UploadRequest, common, haves []plumbing.Hash) (found []plumbing.Hash, ready bool, err error)
+}
+
 // ReceivePackSession represents a git-receive-pack session.
 // A git-receive-pack session has two steps: reference discovery
 // (AdvertisedReferences) and receiving pack (ReceivePack).
@@ -125,6 +125,11 @@ func (c *command) Start() error {
 	return c.cmd.Start()
 }
 
+// SetGitProtocol sets the GIT_PROTOCOL environment variable of the command.
+func (c *command) SetGitProtocol(value string) {
+	c.cmd.Env = append(os.Environ(), "GIT_PROTOCOL="+value)
+}
+
 func (c *command) StderrPipe() (io.Reader, error) {
 	// Pipe returned by Command.StderrPipe has a race with Read + Command.Wait.
 	// We use an io.Pipe and close it after the command finishes.
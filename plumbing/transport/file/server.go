@@ -12,7 +12,8 @@ import (
 
 // ServeUploadPack serves a git-upload-pack request using standard output, input
 // and error. This is meant to be used when implementing a git-upload-pack
-// command.
+// command. The version 2 of the protocol is spoken if it is requested by the
+// GIT_PROTOCOL environment variable.
 func ServeUploadPack(path string) error {
 	ep, err := transport.NewEndpoint(path)
 	if err != nil {
@@ -25,6 +26,10 @@ func ServeUploadPack(path string) error {
 		return fmt.Errorf("error creating session: %s", err)
 	}
 
+	if v2, ok := s.(transport.UploadPackV2Session); ok && common.IsGitProtocolV2(os.Getenv("GIT_PROTOCOL")) {
+		return common.ServeUploadPackV2(srvCmd, v2)
+	}
+
 	return common.ServeUploadPack(srvCmd, s)
 }
 
@@ -67,6 +67,44 @@ func (s *ServerSuite) TestClone(c *C) {
 	c.Assert(err, IsNil, Commentf("combined stdout and stderr:\n%s\n", out))
 }
 
+func (s *ServerSuite) TestCloneProtocolV2(c *C) {
+	if !s.checkExecPerm(c) {
+		c.Skip("go-git binary has not execution permissions")
+	}
+
+	pathToClone := c.MkDir()
+
+	cmd := exec.Command("git", "-c", "protocol.version=2", "clone",
+		"--upload-pack", s.UploadPackBin,
+		"--depth", "1", "--filter", "blob:none",
+		"file://"+s.SrcPath, pathToClone,
+	)
+	cmd.Env = os.Environ()
+	cmd.Env = append(cmd.Env, "GIT_TRACE=true", "GIT_TRACE_PACKET=true")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("combined stdout and stderr:\n%s\n", out))
+	c.Assert(string(out), Matches, "(?s).*clone< version 2.*")
+}
+
+func (s *ServerSuite) TestCloneShallowFilter(c *C) {
+	if !s.checkExecPerm(c) {
+		c.Skip("go-git binary has not execution permissions")
+	}
+
+	pathToClone := c.MkDir()
+
+	cmd := exec.Command("git", "-c", "protocol.version=0", "clone",
+		"--upload-pack", s.UploadPackBin,
+		"--depth", "1", "--filter", "blob:none", "--no-checkout",
+		"file://"+s.SrcPath, pathToClone,
+	)
+	cmd.Env = os.Environ()
+	cmd.Env = append(cmd.Env, "GIT_TRACE=true", "GIT_TRACE_PACKET=true")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("combined stdout and stderr:\n%s\n", out))
+	c.Assert(string(out), Matches, "(?s).*clone> filter blob:none.*")
+}
+
 func (s *ServerSuite) checkExecPerm(c *C) bool {
 	const userExecPermMask = 0100
 	info, err := os.Stat(s.ReceivePackBin)
@@ -33,10 +33,17 @@ func (r *runner) Command(cmd string, ep *transport.Endpoint, auth transport.Auth
 }
 
 type command struct {
-	conn      net.Conn
-	connected bool
-	command   string
-	endpoint  *transport.Endpoint
+	conn        net.Conn
+	connected   bool
+	command     string
+	endpoint    *transport.Endpoint
+	gitProtocol string
+}
+
+// SetGitProtocol sets the extra parameter of the request which git daemon
+// passes as the GIT_PROTOCOL environment variable.
+func (c *command) SetGitProtocol(value string) {
+	c.gitProtocol = value
 }
 
 // Start executes the command sending the required message to the TCP connection
@@ -51,6 +58,9 @@ func (c *command) Start() error {
 	}
 
 	req.Host = host
+	if c.gitProtocol != "" {
+		req.ExtraParams = append(req.ExtraParams, c.gitProtocol)
+	}
 
 	return req.Encode(c.conn)
 }
@@ -1,6 +1,9 @@
 package git
 
 import (
+	"os/exec"
+	"path/filepath"
+
 	"github.com/jesseduffield/go-git/v5/plumbing/transport/test"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
@@ -19,6 +22,10 @@ func (s *UploadPackSuite) SetUpSuite(c *C) {
 
 	s.UploadPackSuite.Client = DefaultClient
 	s.UploadPackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	cmd := exec.Command("git", "config", "transfer.advertiseObjectInfo", "true")
+	cmd.Dir = filepath.Join(s.base, "basic.git")
+	c.Assert(cmd.Run(), IsNil)
+
 	s.UploadPackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
 	s.UploadPackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
 
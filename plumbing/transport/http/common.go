@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -38,9 +39,25 @@ func applyHeadersToRequest(req *http.Request, content *bytes.Buffer, host string
 	req.Header.Add("Content-Length", strconv.Itoa(content.Len()))
 }
 
-const infoRefsPath = "/info/refs"
+const (
+	infoRefsPath = "/info/refs"
+	// gitProtocolHeader is the header requesting a version of the protocol.
+	gitProtocolHeader = "Git-Protocol"
+)
 
 func advertisedReferences(ctx context.Context, s *session, serviceName string) (ref *packp.AdvRefs, err error) {
+	res, err := infoRefs(ctx, s, serviceName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+	return decodeAdvRefs(s, res.Body, serviceName)
+}
+
+// infoRefs requests the references of the service, the gitProtocol header is
+// sent if it is not empty.
+func infoRefs(ctx context.Context, s *session, serviceName, gitProtocol string) (*http.Response, error) {
 	url := fmt.Sprintf(
 		"%s%s?service=%s",
 		s.endpoint.String(), infoRefsPath, serviceName,
@@ -53,20 +70,27 @@ func advertisedReferences(ctx context.Context, s *session, serviceName string) (
 
 	s.ApplyAuthToRequest(req)
 	applyHeadersToRequest(req, nil, s.endpoint.Host, serviceName)
+	if gitProtocol != "" {
+		req.Header.Set(gitProtocolHeader, gitProtocol)
+	}
+
 	res, err := s.client.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 
 	s.ModifyEndpointIfRedirect(res)
-	defer ioutil.CheckClose(res.Body, &err)
-
-	if err = NewErr(res); err != nil {
+	if err := NewErr(res); err != nil {
+		_ = res.Body.Close()
 		return nil, err
 	}
 
+	return res, nil
+}
+
+func decodeAdvRefs(s *session, r io.Reader, serviceName string) (*packp.AdvRefs, error) {
 	ar := packp.NewAdvRefs()
-	if err = ar.Decode(res.Body); err != nil {
+	if err := ar.Decode(r); err != nil {
 		if err == packp.ErrEmptyAdvRefs {
 			err = transport.ErrEmptyRemoteRepository
 		}
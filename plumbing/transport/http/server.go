@@ -0,0 +1,408 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/internal/common"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/server"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// ServerOptions holds user configurable options for the server.
+type ServerOptions struct {
+	// Authenticate authenticates a request, returning the auth method given
+	// to the sessions and to Authorize, which is nil for an anonymous
+	// request. A transport.ErrAuthenticationRequired error asks the client
+	// for credentials.
+	Authenticate func(r *http.Request) (transport.AuthMethod, error)
+	// Authorize checks that a request may use the service of the repository
+	// of the endpoint, transport.UploadPackServiceName for the files of the
+	// dumb protocol. The request is refused with the error, which is usually
+	// transport.ErrAuthorizationFailed or transport.ErrRepositoryNotFound.
+	// If Authorize is nil, only the authenticated requests may push, as in
+	// git-http-backend.
+	Authorize func(r *http.Request, ep *transport.Endpoint, service string, auth transport.AuthMethod) error
+	// ErrorLog logs the internal errors of the requests, which are answered
+	// with a generic message. If nil, the standard logger of the log package
+	// is used.
+	ErrorLog *log.Logger
+}
+
+type httpServer struct {
+	loader    server.Loader
+	transport transport.Transport
+	opts      ServerOptions
+}
+
+// NewServer returns an http.Handler serving the repositories loaded by loader
+// with the smart HTTP protocol. The endpoint given to the loader is the URL of
+// the repository, without the user information and the query. The repository
+// path is the request path without the suffix of the protocol, e.g.
+// "/info/refs", use http.StripPrefix to serve the repositories under a prefix.
+//
+// The files of the dumb HTTP protocol, as generated by git update-server-info
+// or the plumbing/serverinfo package, are served if the loaded storer.Storer
+// exposes its filesystem, as filesystem.Storage does.
+func NewServer(loader server.Loader, opts *ServerOptions) http.Handler {
+	if opts == nil {
+		opts = &ServerOptions{}
+	}
+
+	return &httpServer{
+		loader:    loader,
+		transport: server.NewServer(loader),
+		opts:      *opts,
+	}
+}
+
+type route struct {
+	method  string
+	pattern *regexp.Regexp
+	handle  func(s *httpServer, w http.ResponseWriter, r *serverRequest) error
+}
+
+// routes are the paths served, the first group of a pattern is the path of
+// the repository, the second one is the path of the file in the repository.
+var routes = []route{
+	{http.MethodGet, regexp.MustCompile(`^(.*)/(HEAD)$`), serveTextFile},
+	{http.MethodGet, regexp.MustCompile(`^(.*)/(info/refs)$`), serveInfoRefs},
+	{http.MethodGet, regexp.MustCompile(`^(.*)/(objects/info/alternates)$`), serveTextFile},
+	{http.MethodGet, regexp.MustCompile(`^(.*)/(objects/info/http-alternates)$`), serveTextFile},
+	{http.MethodGet, regexp.MustCompile(`^(.*)/(objects/info/packs)$`), serveInfoPacks},
+	{http.MethodGet, regexp.MustCompile(`^(.*)/(objects/[0-9a-f]{2}/[0-9a-f]{38})$`), serveLooseObject},
+	{http.MethodGet, regexp.MustCompile(`^(.*)/(objects/pack/pack-[0-9a-f]{40}\.pack)$`), servePackFile},
+	{http.MethodGet, regexp.MustCompile(`^(.*)/(objects/pack/pack-[0-9a-f]{40}\.idx)$`), serveIdxFile},
+	{http.MethodPost, regexp.MustCompile(`^(.*)/(` + transport.UploadPackServiceName + `)$`), serveRPC},
+	{http.MethodPost, regexp.MustCompile(`^(.*)/(` + transport.ReceivePackServiceName + `)$`), serveRPC},
+}
+
+// serverRequest is a request for a file, or a service, of a repository.
+type serverRequest struct {
+	*http.Request
+	endpoint *transport.Endpoint
+	file     string
+	service  string
+	auth     transport.AuthMethod
+}
+
+func (s *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.serve(w, r); err != nil {
+		s.writeError(w, r, err)
+	}
+}
+
+func (s *httpServer) serve(w http.ResponseWriter, r *http.Request) error {
+	p := path.Clean("/" + r.URL.Path)
+	for _, rt := range routes {
+		m := rt.pattern.FindStringSubmatch(p)
+		if m == nil {
+			continue
+		}
+
+		if r.Method != rt.method && !(rt.method == http.MethodGet && r.Method == http.MethodHead) {
+			return errMethodNotAllowed
+		}
+
+		req, err := s.newRequest(r, m[1], m[2])
+		if err != nil {
+			return err
+		}
+
+		return rt.handle(s, w, req)
+	}
+
+	return transport.ErrRepositoryNotFound
+}
+
+// newRequest returns the request of the file of the repository at path,
+// once it is authenticated and authorized.
+func (s *httpServer) newRequest(r *http.Request, path, file string) (*serverRequest, error) {
+	req := &serverRequest{Request: r, file: file, service: transport.UploadPackServiceName}
+	switch file {
+	case transport.UploadPackServiceName, transport.ReceivePackServiceName:
+		req.service = file
+	case "info/refs":
+		if service := r.URL.Query().Get("service"); service != "" {
+			if service != transport.UploadPackServiceName && service != transport.ReceivePackServiceName {
+				return nil, fmt.Errorf("%w: unsupported service %q", transport.ErrAuthorizationFailed, service)
+			}
+
+			req.service = service
+		}
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	ep, err := transport.NewEndpoint(fmt.Sprintf("%s://%s%s", scheme, r.Host, path))
+	if err != nil {
+		return nil, err
+	}
+
+	req.endpoint = ep
+	if s.opts.Authenticate != nil {
+		if req.auth, err = s.opts.Authenticate(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.opts.Authorize != nil {
+		err = s.opts.Authorize(r, ep, req.service, req.auth)
+	} else if req.service == transport.ReceivePackServiceName && req.auth == nil {
+		err = transport.ErrAuthenticationRequired
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (r *serverRequest) isProtocolV2() bool {
+	return common.IsGitProtocolV2(r.Header.Get(gitProtocolHeader))
+}
+
+// serveInfoRefs advertises the references of a service of the smart protocol,
+// or the capabilities of the version 2 of the protocol if they are requested.
+// Otherwise, the info/refs file of the dumb protocol is served.
+func serveInfoRefs(s *httpServer, w http.ResponseWriter, r *serverRequest) (err error) {
+	if r.URL.Query().Get("service") == "" {
+		return serveTextFile(s, w, r)
+	}
+
+	sess, err := s.newSession(r)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(sess, &err)
+
+	buf := bytes.NewBuffer(nil)
+	if v2, ok := sess.(transport.UploadPackV2Session); ok && r.isProtocolV2() {
+		adv, err := v2.CapabilityAdvertisement(r.Context())
+		if err != nil {
+			return err
+		}
+
+		if err := adv.Encode(buf); err != nil {
+			return err
+		}
+	} else {
+		ar, err := sess.AdvertisedReferencesContext(r.Context())
+		if err != nil {
+			return err
+		}
+
+		ar.Prefix = [][]byte{[]byte("# service=" + r.service), pktline.Flush}
+		if err := ar.Encode(buf); err != nil {
+			return err
+		}
+	}
+
+	setNoCacheHeaders(w)
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", r.service))
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// serveRPC serves a request of the stateless version of a service, the
+// response is streamed as it is written.
+func serveRPC(s *httpServer, w http.ResponseWriter, r *serverRequest) (err error) {
+	if r.Header.Get("Content-Type") != fmt.Sprintf("application/x-%s-request", r.service) {
+		return errUnsupportedMediaType
+	}
+
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return err
+		}
+
+		defer ioutil.CheckClose(gz, &err)
+		body = gz
+	}
+
+	sess, err := s.newSession(r)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(sess, &err)
+
+	setNoCacheHeaders(w)
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", r.service))
+
+	rw := &responseWriter{ResponseWriter: w}
+	cmd := common.ServerCommand{
+		Stdin:        body,
+		Stdout:       ioutil.WriteNopCloser(rw),
+		StatelessRPC: true,
+	}
+
+	switch sess := sess.(type) {
+	case transport.UploadPackV2Session:
+		if r.isProtocolV2() {
+			err = common.ServeUploadPackV2(cmd, sess)
+		} else {
+			err = common.ServeUploadPack(cmd, sess)
+		}
+	case transport.UploadPackSession:
+		err = common.ServeUploadPack(cmd, sess)
+	case transport.ReceivePackSession:
+		err = common.ServeReceivePack(cmd, sess)
+	}
+
+	// The errors are reported by the protocol once the response is started,
+	// e.g. with the report status of a git-receive-pack.
+	if rw.written {
+		return nil
+	}
+
+	return err
+}
+
+func (s *httpServer) newSession(r *serverRequest) (transport.Session, error) {
+	if r.service == transport.ReceivePackServiceName {
+		return s.transport.NewReceivePackSession(r.endpoint, r.auth)
+	}
+
+	return s.transport.NewUploadPackSession(r.endpoint, r.auth)
+}
+
+func serveTextFile(s *httpServer, w http.ResponseWriter, r *serverRequest) error {
+	setNoCacheHeaders(w)
+	return s.serveFile(w, r, "text/plain")
+}
+
+func serveInfoPacks(s *httpServer, w http.ResponseWriter, r *serverRequest) error {
+	setNoCacheHeaders(w)
+	return s.serveFile(w, r, "text/plain; charset=utf-8")
+}
+
+func serveLooseObject(s *httpServer, w http.ResponseWriter, r *serverRequest) error {
+	setCacheForeverHeaders(w)
+	return s.serveFile(w, r, "application/x-git-loose-object")
+}
+
+func servePackFile(s *httpServer, w http.ResponseWriter, r *serverRequest) error {
+	setCacheForeverHeaders(w)
+	return s.serveFile(w, r, "application/x-git-packed-objects")
+}
+
+func serveIdxFile(s *httpServer, w http.ResponseWriter, r *serverRequest) error {
+	setCacheForeverHeaders(w)
+	return s.serveFile(w, r, "application/x-git-packed-objects-toc")
+}
+
+// serveFile serves a file of the dumb protocol from the filesystem of the
+// repository.
+func (s *httpServer) serveFile(w http.ResponseWriter, r *serverRequest, contentType string) (err error) {
+	sto, err := s.loader.Load(r.endpoint)
+	if err != nil {
+		return err
+	}
+
+	fs, ok := sto.(interface{ Filesystem() billy.Filesystem })
+	if !ok {
+		return errFileNotFound
+	}
+
+	fi, err := fs.Filesystem().Stat(r.file)
+	if err != nil || fi.IsDir() {
+		return errFileNotFound
+	}
+
+	f, err := fs.Filesystem().Open(r.file)
+	if err != nil {
+		return errFileNotFound
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r.Request, r.file, fi.ModTime(), f)
+	return nil
+}
+
+func setNoCacheHeaders(w http.ResponseWriter) {
+	w.Header().Set("Expires", "Fri, 01 Jan 1980 00:00:00 GMT")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+}
+
+func setCacheForeverHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+}
+
+var (
+	errMethodNotAllowed     = errors.New("method not allowed")
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	errFileNotFound         = errors.New("file not found")
+)
+
+// authenticateHeader is the challenge sent to the unauthenticated clients.
+const authenticateHeader = `Basic realm="git"`
+
+// writeError writes the status code matching err. The message of err is only
+// sent for the client errors, the server errors are logged.
+func (s *httpServer) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired):
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", authenticateHeader)
+	case errors.Is(err, transport.ErrAuthorizationFailed):
+		code = http.StatusForbidden
+	case errors.Is(err, transport.ErrRepositoryNotFound), errors.Is(err, errFileNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, errMethodNotAllowed):
+		code = http.StatusMethodNotAllowed
+	case errors.Is(err, errUnsupportedMediaType):
+		code = http.StatusUnsupportedMediaType
+	}
+
+	if code >= http.StatusInternalServerError {
+		s.logf("git http server: %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	http.Error(w, strings.TrimSpace(err.Error()), code)
+}
+
+func (s *httpServer) logf(format string, args ...interface{}) {
+	if s.opts.ErrorLog != nil {
+		s.opts.ErrorLog.Printf(format, args...)
+		return
+	}
+
+	log.Printf(format, args...)
+}
+
+// responseWriter records whether the response is started.
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,330 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	. "github.com/jesseduffield/go-git/v5/internal/test"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/server"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/test"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+// ServerBaseSuite serves the repositories of a base directory with NewServer.
+type ServerBaseSuite struct {
+	fixtures.Suite
+
+	base   string
+	server *httptest.Server
+}
+
+// startServer serves the repositories of a new base directory.
+func (s *ServerBaseSuite) startServer(c *C, opts *ServerOptions) {
+	s.base = c.MkDir()
+	loader := server.NewFilesystemLoader(osfs.New(s.base))
+	s.server = httptest.NewServer(NewServer(loader, opts))
+}
+
+func (s *ServerBaseSuite) TearDownTest(c *C) {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+func (s *ServerBaseSuite) prepareRepository(c *C, f *fixtures.Fixture, name string) *transport.Endpoint {
+	fs := f.DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+	c.Assert(os.Rename(fs.Root(), filepath.Join(s.base, name)), IsNil)
+
+	return s.newEndpoint(c, name)
+}
+
+func (s *ServerBaseSuite) newEndpoint(c *C, name string) *transport.Endpoint {
+	ep, err := transport.NewEndpoint(fmt.Sprintf("%s/%s", s.server.URL, name))
+	c.Assert(err, IsNil)
+
+	return ep
+}
+
+type ServerSuite struct {
+	ServerBaseSuite
+}
+
+var _ = Suite(&ServerSuite{})
+
+// basicAuth authenticates the user "user" with the password "pass".
+func basicAuth(r *http.Request) (transport.AuthMethod, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	if user != "user" || pass != "pass" {
+		return nil, transport.ErrAuthenticationRequired
+	}
+
+	return &BasicAuth{Username: user, Password: pass}, nil
+}
+
+func (s *ServerSuite) TestAuthenticate(c *C) {
+	s.startServer(c, &ServerOptions{Authenticate: basicAuth})
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	for _, t := range []struct {
+		auth transport.AuthMethod
+		err  error
+	}{
+		{nil, transport.ErrAuthenticationRequired},
+		{&BasicAuth{Username: "user", Password: "wrong"}, transport.ErrAuthenticationRequired},
+		{&BasicAuth{Username: "user", Password: "pass"}, nil},
+	} {
+		r, err := DefaultClient.NewReceivePackSession(ep, t.auth)
+		c.Assert(err, IsNil)
+
+		_, err = r.AdvertisedReferences()
+		if t.err == nil {
+			c.Assert(err, IsNil)
+		} else {
+			c.Assert(err, ErrorIs, t.err)
+		}
+	}
+
+	r, err := DefaultClient.NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+}
+
+func (s *ServerSuite) TestAuthorize(c *C) {
+	s.startServer(c, &ServerOptions{
+		Authorize: func(r *http.Request, ep *transport.Endpoint, service string, auth transport.AuthMethod) error {
+			if ep.Path == "/private.git" {
+				return transport.ErrAuthorizationFailed
+			}
+
+			return nil
+		},
+	})
+
+	public := s.prepareRepository(c, fixtures.Basic().One(), "public.git")
+	private := s.prepareRepository(c, fixtures.Basic().One(), "private.git")
+
+	r, err := DefaultClient.NewReceivePackSession(public, nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	r, err = DefaultClient.NewReceivePackSession(private, nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, ErrorIs, transport.ErrAuthorizationFailed)
+}
+
+func (s *ServerSuite) TestUploadPackGzip(c *C) {
+	s.startServer(c, nil)
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	c.Assert(pktline.NewEncoder(gz).EncodeString(
+		"want 6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n",
+		pktline.FlushString,
+		"done\n",
+	), IsNil)
+	c.Assert(gz.Close(), IsNil)
+
+	req, err := http.NewRequest(http.MethodPost, ep.String()+"/git-upload-pack", &body)
+	c.Assert(err, IsNil)
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	res, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "application/x-git-upload-pack-result")
+
+	b, err := io.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(b), "0008NAK\nPACK"), Equals, true)
+}
+
+func (s *ServerSuite) TestErrors(c *C) {
+	s.startServer(c, nil)
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	for _, t := range []struct {
+		method, path, contentType string
+		code                      int
+	}{
+		{http.MethodGet, "/non-existent.git/info/refs?service=git-upload-pack", "", http.StatusNotFound},
+		{http.MethodGet, "/basic.git/info/refs?service=git-foo", "", http.StatusForbidden},
+		{http.MethodGet, "/basic.git/info/refs?service=git-receive-pack", "", http.StatusUnauthorized},
+		{http.MethodGet, "/basic.git/git-upload-pack", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/basic.git/git-upload-pack", "text/plain", http.StatusUnsupportedMediaType},
+		{http.MethodGet, "/basic.git/config", "", http.StatusNotFound},
+		{http.MethodGet, "/basic.git/objects/00/00000000000000000000000000000000000000", "", http.StatusNotFound},
+	} {
+		req, err := http.NewRequest(t.method, s.server.URL+t.path, nil)
+		c.Assert(err, IsNil)
+		if t.contentType != "" {
+			req.Header.Set("Content-Type", t.contentType)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		c.Assert(res.Body.Close(), IsNil)
+		c.Assert(res.StatusCode, Equals, t.code, Commentf("%s %s", t.method, t.path))
+	}
+}
+
+// errorLoader fails to load any repository.
+type errorLoader struct{}
+
+func (errorLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	return nil, errors.New("cannot open /srv/git/basic.git")
+}
+
+func (s *ServerSuite) TestInternalError(c *C) {
+	var logs bytes.Buffer
+	s.server = httptest.NewServer(NewServer(errorLoader{}, &ServerOptions{
+		ErrorLog: log.New(&logs, "", 0),
+	}))
+
+	res, err := http.Get(s.server.URL + "/basic.git/info/refs?service=git-upload-pack")
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	c.Assert(res.StatusCode, Equals, http.StatusInternalServerError)
+	b, err := io.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "Internal Server Error\n")
+	c.Assert(logs.String(), Matches, ".*cannot open /srv/git/basic.git\n")
+}
+
+func (s *ServerSuite) TestDumbProtocol(c *C) {
+	s.startServer(c, nil)
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	cmd := exec.Command("git", "update-server-info")
+	cmd.Dir = filepath.Join(s.base, "basic.git")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	res, err := http.Get(ep.String() + "/info/refs")
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "text/plain")
+
+	b, err := io.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Matches, "(?s).*6ecf0ef2c2dffb796033e5a02219af86ec6584e5\trefs/heads/master\n.*")
+
+	s.gitClone(c, []string{"GIT_SMART_HTTP=0"}, "clone", ep.String())
+}
+
+func (s *ServerSuite) TestGitClone(c *C) {
+	s.startServer(c, nil)
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	s.gitClone(c, nil, "-c", "protocol.version=0", "clone", ep.String())
+	s.gitClone(c, nil, "-c", "protocol.version=2", "clone", "--depth", "1", ep.String())
+}
+
+func (s *ServerSuite) TestGitPush(c *C) {
+	s.startServer(c, &ServerOptions{Authenticate: basicAuth})
+	ep := s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	src := fixtures.Basic().One().DotGit().Root()
+
+	url := strings.Replace(ep.String(), "http://", "http://user:pass@", 1)
+	for _, refspec := range []string{"refs/heads/*:refs/heads/*", ":refs/heads/branch"} {
+		cmd := exec.Command("git", "push", url, refspec)
+		cmd.Dir = src
+		out, err := cmd.CombinedOutput()
+		c.Assert(err, IsNil, Commentf("%s", out))
+	}
+
+	r, err := DefaultClient.NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.References["refs/heads/master"].String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	_, ok := ar.References["refs/heads/branch"]
+	c.Assert(ok, Equals, false)
+}
+
+// gitClone runs a git clone in a new directory, with the given environment
+// and arguments.
+func (s *ServerSuite) gitClone(c *C, env []string, args ...string) {
+	cmd := exec.Command("git", append(args, c.MkDir())...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+}
+
+type ServerUploadPackSuite struct {
+	test.UploadPackSuite
+	ServerBaseSuite
+}
+
+var _ = Suite(&ServerUploadPackSuite{})
+
+func (s *ServerUploadPackSuite) SetUpTest(c *C) {
+	s.startServer(c, nil)
+	s.UploadPackSuite.Client = DefaultClient
+	s.UploadPackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.UploadPackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.UploadPackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
+
+// Overwritten, different behaviour for HTTP.
+func (s *ServerUploadPackSuite) TestAdvertisedReferencesNotExists(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.NonExistentEndpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	info, err := r.AdvertisedReferences()
+	c.Assert(err, ErrorIs, transport.ErrRepositoryNotFound)
+	c.Assert(info, IsNil)
+}
+
+func (s *ServerUploadPackSuite) TestUploadPackWithContextOnRead(c *C) {
+	c.Skip("flaky tests, looks like sometimes the request body is cached, so doesn't fail on context cancel")
+}
+
+type ServerReceivePackSuite struct {
+	test.ReceivePackSuite
+	ServerBaseSuite
+}
+
+var _ = Suite(&ServerReceivePackSuite{})
+
+func (s *ServerReceivePackSuite) SetUpTest(c *C) {
+	s.startServer(c, &ServerOptions{Authenticate: basicAuth})
+	s.ReceivePackSuite.Client = DefaultClient
+	s.ReceivePackSuite.EmptyAuth = &BasicAuth{Username: "user", Password: "pass"}
+	s.ReceivePackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.ReceivePackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.ReceivePackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/jesseduffield/go-git/v5/plumbing"
 	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/jesseduffield/go-git/v5/plumbing/transport"
 	"github.com/jesseduffield/go-git/v5/plumbing/transport/internal/common"
 	"github.com/jesseduffield/go-git/v5/utils/ioutil"
@@ -17,19 +18,187 @@ import (
 
 type upSession struct {
 	*session
+	advRead bool
+	capAdv  *packp.CapabilityAdvertisement
+	// body is the body of the response to the last command, which may still
+	// be read from the packfile of a FetchResponse.
+	body io.Closer
 }
 
 func newUploadPackSession(c *client, ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
 	s, err := newSession(c, ep, auth)
-	return &upSession{s}, err
+	return &upSession{session: s}, err
 }
 
 func (s *upSession) AdvertisedReferences() (*packp.AdvRefs, error) {
-	return advertisedReferences(context.TODO(), s.session, transport.UploadPackServiceName)
+	return s.AdvertisedReferencesContext(context.TODO())
 }
 
 func (s *upSession) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
-	return advertisedReferences(ctx, s.session, transport.UploadPackServiceName)
+	adv, err := s.CapabilityAdvertisement(ctx)
+	if err == transport.ErrProtocolV2Unsupported {
+		return s.advRefs, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.advRefs != nil {
+		return s.advRefs, nil
+	}
+
+	res, err := s.LsRefs(ctx, common.NewLsRefsRequest(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.References) == 0 {
+		return nil, transport.ErrEmptyRemoteRepository
+	}
+
+	ar, err := res.AdvRefs(adv)
+	if err != nil {
+		return nil, err
+	}
+
+	s.advRefs = ar
+	return ar, nil
+}
+
+// CapabilityAdvertisement returns the capabilities advertised by the server,
+// if it speaks the version 2 of the protocol. Otherwise, the references
+// advertised by the server are kept for AdvertisedReferences.
+func (s *upSession) CapabilityAdvertisement(ctx context.Context) (*packp.CapabilityAdvertisement, error) {
+	if !s.advRead {
+		if err := s.discover(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.capAdv == nil {
+		return nil, transport.ErrProtocolV2Unsupported
+	}
+
+	return s.capAdv, nil
+}
+
+// discover requests the version 2 of the protocol, and decodes either the
+// capabilities or the references advertised by the server.
+func (s *upSession) discover(ctx context.Context) (err error) {
+	res, err := infoRefs(ctx, s.session, transport.UploadPackServiceName, common.GitProtocolV2)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	adv, peeked, err := common.PeekCapabilityAdvertisement(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if adv == nil {
+		r := io.MultiReader(bytes.NewReader(peeked), res.Body)
+		if _, err := decodeAdvRefs(s.session, r, transport.UploadPackServiceName); err != nil {
+			return err
+		}
+	}
+
+	s.capAdv, s.advRead = adv, true
+	return nil
+}
+
+// LsRefs runs an ls-refs command.
+func (s *upSession) LsRefs(ctx context.Context, req *packp.LsRefsRequest) (*packp.LsRefsResponse, error) {
+	body, err := s.command(ctx, req.Capabilities, req.Encode)
+	if err != nil {
+		return nil, err
+	}
+
+	res := packp.NewLsRefsResponse()
+	if err := res.Decode(body); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Fetch runs a fetch command. The body of the response is closed by the next
+// command, or by Close.
+func (s *upSession) Fetch(ctx context.Context, req *packp.FetchRequest) (*packp.FetchResponse, error) {
+	body, err := s.command(ctx, req.Capabilities, req.Encode)
+	if err != nil {
+		return nil, err
+	}
+
+	res := packp.NewFetchResponse()
+	if err := res.Decode(body); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ObjectInfo runs an object-info command.
+func (s *upSession) ObjectInfo(ctx context.Context, req *packp.ObjectInfoRequest) (*packp.ObjectInfoResponse, error) {
+	body, err := s.command(ctx, req.Capabilities, req.Encode)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &packp.ObjectInfoResponse{}
+	if err := res.Decode(body); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// command posts a command of the version 2 of the protocol, encoded by
+// encode, and returns the body of the response.
+func (s *upSession) command(
+	ctx context.Context, caps *capability.List, encode func(io.Writer) error,
+) (io.Reader, error) {
+	adv, err := s.CapabilityAdvertisement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.closeBody(); err != nil {
+		return nil, err
+	}
+
+	common.AddCommandCapabilities(adv, caps)
+	content := bytes.NewBuffer(nil)
+	if err := encode(content); err != nil {
+		return nil, err
+	}
+
+	res, err := s.doRequest(ctx, http.MethodPost, s.uploadPackURL(), content)
+	if err != nil {
+		return nil, err
+	}
+
+	s.body = res.Body
+	return res.Body, nil
+}
+
+func (s *upSession) closeBody() error {
+	if s.body == nil {
+		return nil
+	}
+
+	err := s.body.Close()
+	s.body = nil
+	return err
+}
+
+func (s *upSession) uploadPackURL() string {
+	return fmt.Sprintf(
+		"%s/%s",
+		s.endpoint.String(), transport.UploadPackServiceName,
+	)
 }
 
 func (s *upSession) UploadPack(
@@ -44,17 +213,18 @@ func (s *upSession) UploadPack(
 		return nil, err
 	}
 
-	url := fmt.Sprintf(
-		"%s/%s",
-		s.endpoint.String(), transport.UploadPackServiceName,
-	)
+	if adv, err := s.CapabilityAdvertisement(ctx); err == nil {
+		return s.uploadPackV2(ctx, adv, req)
+	} else if err != transport.ErrProtocolV2Unsupported {
+		return nil, err
+	}
 
 	content, err := uploadPackRequestToReader(req)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := s.doRequest(ctx, http.MethodPost, url, content)
+	res, err := s.doRequest(ctx, http.MethodPost, s.uploadPackURL(), content)
 	if err != nil {
 		return nil, err
 	}
@@ -72,9 +242,32 @@ func (s *upSession) UploadPack(
 	return common.DecodeUploadPackResponse(rc, req)
 }
 
-// Close does nothing.
+// uploadPackV2 runs the fetch command of an upload-pack request.
+func (s *upSession) uploadPackV2(
+	ctx context.Context, adv *packp.CapabilityAdvertisement, req *packp.UploadPackRequest,
+) (*packp.UploadPackResponse, error) {
+	fr, err := common.NewFetchRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	common.AddCommandCapabilities(adv, fr.Capabilities)
+	content := bytes.NewBuffer(nil)
+	if err := fr.Encode(content); err != nil {
+		return nil, err
+	}
+
+	res, err := s.doRequest(ctx, http.MethodPost, s.uploadPackURL(), content)
+	if err != nil {
+		return nil, err
+	}
+
+	return common.DecodeFetchResponse(res.Body, req)
+}
+
+// Close closes the body of the response to the last command, if any.
 func (s *upSession) Close() error {
-	return nil
+	return s.closeBody()
 }
 
 func (s *upSession) doRequest(
@@ -92,6 +285,10 @@ func (s *upSession) doRequest(
 	}
 
 	applyHeadersToRequest(req, content, s.endpoint.Host, transport.UploadPackServiceName)
+	if s.capAdv != nil {
+		req.Header.Set(gitProtocolHeader, common.GitProtocolV2)
+	}
+
 	s.ApplyAuthToRequest(req)
 
 	res, err := s.client.Do(req.WithContext(ctx))
@@ -7,6 +7,7 @@ package common
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -77,6 +78,15 @@ type CommandKiller interface {
 	Kill() error
 }
 
+// CommandGitProtocol expands the Command interface, enabling it to request a
+// version of the protocol from the server.
+type CommandGitProtocol interface {
+	// SetGitProtocol sets the GIT_PROTOCOL environment variable of the
+	// server, like GitProtocolV2, or its equivalent for the transport. It is
+	// called before Start.
+	SetGitProtocol(value string)
+}
+
 type client struct {
 	cmdr Commander
 }
@@ -107,6 +117,9 @@ type session struct {
 
 	isReceivePack bool
 	advRefs       *packp.AdvRefs
+	advRead       bool
+	capAdv        *packp.CapabilityAdvertisement
+	peeked        []byte
 	packRun       bool
 	finished      bool
 	firstErrLine  chan string
@@ -133,6 +146,10 @@ func (c *client) newSession(s string, ep *transport.Endpoint, auth transport.Aut
 		return nil, err
 	}
 
+	if p, ok := cmd.(CommandGitProtocol); ok && s == transport.UploadPackServiceName {
+		p.SetGitProtocol(GitProtocolV2)
+	}
+
 	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
@@ -183,8 +200,23 @@ func (s *session) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRe
 		return s.advRefs, nil
 	}
 
+	adv, err := s.CapabilityAdvertisement(ctx)
+	switch {
+	case err == nil:
+		ar, err := s.advertisedReferencesV2(ctx, adv)
+		if err != nil {
+			return nil, err
+		}
+
+		s.advRefs = ar
+		return ar, nil
+	case err != transport.ErrProtocolV2Unsupported:
+		return nil, err
+	}
+
 	ar := packp.NewAdvRefs()
-	if err := ar.Decode(s.StdoutContext(ctx)); err != nil {
+	r := io.MultiReader(bytes.NewReader(s.peeked), s.StdoutContext(ctx))
+	if err := ar.Decode(r); err != nil {
 		if err := s.handleAdvRefDecodeError(err); err != nil {
 			return nil, err
 		}
@@ -271,6 +303,15 @@ func (s *session) UploadPack(ctx context.Context, req *packp.UploadPackRequest)
 		return nil, err
 	}
 
+	adv, err := s.CapabilityAdvertisement(ctx)
+	if err == nil {
+		return s.uploadPackV2(ctx, adv, req)
+	}
+
+	if err != transport.ErrProtocolV2Unsupported {
+		return nil, err
+	}
+
 	if _, err := s.AdvertisedReferencesContext(ctx); err != nil {
 		return nil, err
 	}
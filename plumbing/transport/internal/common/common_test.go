@@ -30,6 +30,13 @@ func (s *CommonSuite) TestIsRepoNotFoundError(c *C) {
 	c.Assert(isRepoNotFound, Equals, true)
 }
 
+func (s *CommonSuite) TestIsGitProtocolV2(c *C) {
+	c.Assert(IsGitProtocolV2("version=2"), Equals, true)
+	c.Assert(IsGitProtocolV2("foo=bar:version=2"), Equals, true)
+	c.Assert(IsGitProtocolV2("version=1"), Equals, false)
+	c.Assert(IsGitProtocolV2(""), Equals, false)
+}
+
 func (s *CommonSuite) TestCheckNotFoundError(c *C) {
 	firstErrLine := make(chan string, 1)
 
@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/jesseduffield/go-git/v5/plumbing/transport"
 	"github.com/jesseduffield/go-git/v5/utils/ioutil"
 )
@@ -15,42 +19,252 @@ type ServerCommand struct {
 	Stderr io.Writer
 	Stdout io.WriteCloser
 	Stdin  io.Reader
+	// StatelessRPC serves a single request of the client, as in the smart
+	// HTTP protocol: the references, or the capabilities, are not advertised,
+	// and the negotiation of an upload-pack ends after a single round.
+	StatelessRPC bool
 }
 
 func ServeUploadPack(cmd ServerCommand, s transport.UploadPackSession) (err error) {
 	ioutil.CheckClose(cmd.Stdout, &err)
 
-	ar, err := s.AdvertisedReferences()
-	if err != nil {
-		return err
-	}
+	if !cmd.StatelessRPC {
+		ar, err := s.AdvertisedReferences()
+		if err != nil {
+			return err
+		}
 
-	if err := ar.Encode(cmd.Stdout); err != nil {
-		return err
+		if err := ar.Encode(cmd.Stdout); err != nil {
+			return err
+		}
 	}
 
 	req := packp.NewUploadPackRequest()
 	if err := req.Decode(cmd.Stdin); err != nil {
+		// The client has nothing to fetch.
+		if err == packp.ErrEmptyInput {
+			return nil
+		}
+
 		return err
 	}
 
-	var resp *packp.UploadPackResponse
-	resp, err = s.UploadPack(context.TODO(), req)
+	ctx := context.TODO()
+	n, ok := s.(transport.UploadPackNegotiator)
+	if !ok {
+		// The haves are not read, the packfile contains all the objects.
+		var resp *packp.UploadPackResponse
+		resp, err = s.UploadPack(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		return resp.Encode(cmd.Stdout)
+	}
+
+	send, err := negotiate(ctx, cmd, n, req)
+	if err != nil || !send {
+		return err
+	}
+
+	resp, err := s.UploadPack(ctx, req)
 	if err != nil {
 		return err
 	}
 
-	return resp.Encode(cmd.Stdout)
+	defer ioutil.CheckClose(resp, &err)
+	_, err = io.Copy(cmd.Stdout, resp)
+	return err
 }
 
-func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
-	ar, err := s.AdvertisedReferences()
+// negotiate sends the shallow update requested by the client, then
+// acknowledges its haves until it is done, or until the server is ready to
+// send the packfile if the client supports no-done. The common objects become
+// the haves of req. A stateless client sends a new request for each round,
+// send is false if the packfile is not sent in response to this one.
+func negotiate(ctx context.Context, cmd ServerCommand, s transport.UploadPackNegotiator, req *packp.UploadPackRequest) (send bool, err error) {
+	if req.Depth != nil && !req.Depth.IsZero() {
+		update, err := s.ShallowUpdate(ctx, &req.UploadRequest)
+		if err != nil {
+			return false, err
+		}
+
+		if err := update.Encode(cmd.Stdout); err != nil {
+			return false, err
+		}
+	}
+
+	detailed := req.Capabilities.Supports(capability.MultiACKDetailed)
+	multiACK := detailed || req.Capabilities.Supports(capability.MultiACK)
+	noDone := req.Capabilities.Supports(capability.NoDone)
+
+	e := pktline.NewEncoder(cmd.Stdout)
+	var common []plumbing.Hash
+	var sentReady bool
+	for {
+		var haves packp.UploadHaves
+		done, err := haves.Decode(cmd.Stdin)
+		if err != nil {
+			return false, err
+		}
+
+		found, ready, err := s.Negotiate(ctx, &req.UploadRequest, common, haves.Haves)
+		if err != nil {
+			return false, err
+		}
+
+		var lines []string
+		for _, h := range found {
+			switch {
+			case detailed:
+				lines = append(lines, fmt.Sprintf("ACK %s common\n", h))
+			case multiACK:
+				lines = append(lines, fmt.Sprintf("ACK %s continue\n", h))
+			case len(common) == 0:
+				// Without multi_ack, only the first common object is
+				// acknowledged.
+				lines = append(lines, fmt.Sprintf("ACK %s\n", h))
+			}
+
+			common = append(common, h)
+		}
+
+		switch {
+		case done && len(common) == 0:
+			lines = append(lines, "NAK\n")
+		case done:
+			if multiACK {
+				lines = append(lines, fmt.Sprintf("ACK %s\n", common[len(common)-1]))
+			}
+		default:
+			if detailed && len(common) > 0 && ready {
+				lines = append(lines, fmt.Sprintf("ACK %s ready\n", common[len(common)-1]))
+				sentReady = true
+			}
+
+			if len(common) == 0 || multiACK {
+				lines = append(lines, "NAK\n")
+			}
+
+			// The client does not send done once the server is ready.
+			if noDone && sentReady {
+				lines = append(lines, fmt.Sprintf("ACK %s\n", common[len(common)-1]))
+				done = true
+			}
+		}
+
+		for _, line := range lines {
+			if err := e.EncodeString(line); err != nil {
+				return false, err
+			}
+		}
+
+		if done {
+			req.Haves = common
+			return true, nil
+		}
+
+		if cmd.StatelessRPC {
+			return false, nil
+		}
+	}
+}
+
+// ServeUploadPackV2 serves an upload-pack session speaking the version 2 of
+// the protocol: the capabilities are advertised, then the commands of the
+// client are run until it ends the session with a flush-pkt.
+func ServeUploadPackV2(cmd ServerCommand, s transport.UploadPackV2Session) (err error) {
+	ioutil.CheckClose(cmd.Stdout, &err)
+
+	ctx := context.TODO()
+	if !cmd.StatelessRPC {
+		adv, err := s.CapabilityAdvertisement(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := adv.Encode(cmd.Stdout); err != nil {
+			return err
+		}
+	}
+
+	for {
+		req := packp.NewCommandRequest()
+		if err := req.Decode(cmd.Stdin); err != nil {
+			if err == packp.ErrEmptyInput {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := serveCommand(ctx, cmd.Stdout, s, req); err != nil {
+			return err
+		}
+	}
+}
+
+func serveCommand(ctx context.Context, w io.Writer, s transport.UploadPackV2Session, cmd *packp.CommandRequest) error {
+	switch cmd.Command {
+	case capability.LsRefs:
+		req, err := packp.NewLsRefsRequestFromCommand(cmd)
+		if err != nil {
+			return err
+		}
+
+		res, err := s.LsRefs(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		return res.Encode(w)
+	case capability.Fetch:
+		return serveFetch(ctx, w, s, cmd)
+	case capability.ObjectInfo:
+		req, err := packp.NewObjectInfoRequestFromCommand(cmd)
+		if err != nil {
+			return err
+		}
+
+		res, err := s.ObjectInfo(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		return res.Encode(w)
+	default:
+		return fmt.Errorf("unknown command %q", cmd.Command)
+	}
+}
+
+func serveFetch(ctx context.Context, w io.Writer, s transport.UploadPackV2Session, cmd *packp.CommandRequest) (err error) {
+	req, err := packp.NewFetchRequestFromCommand(cmd)
 	if err != nil {
-		return fmt.Errorf("internal error in advertised references: %s", err)
+		return err
 	}
 
-	if err := ar.Encode(cmd.Stdout); err != nil {
-		return fmt.Errorf("error in advertised references encoding: %s", err)
+	res, err := s.Fetch(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if c, ok := res.Packfile.(io.Closer); ok {
+		defer ioutil.CheckClose(c, &err)
+	}
+
+	return res.Encode(w)
+}
+
+func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
+	if !cmd.StatelessRPC {
+		ar, err := s.AdvertisedReferences()
+		if err != nil {
+			return fmt.Errorf("internal error in advertised references: %s", err)
+		}
+
+		if err := ar.Encode(cmd.Stdout); err != nil {
+			return fmt.Errorf("error in advertised references encoding: %s", err)
+		}
 	}
 
 	req := packp.NewReferenceUpdateRequest()
@@ -58,16 +272,46 @@ func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
 		return fmt.Errorf("error decoding: %s", err)
 	}
 
+	// The messages of the hooks and the report status are multiplexed if
+	// the client supports sideband.
+	var w io.Writer = cmd.Stdout
+	var m *sideband.Muxer
+	if req.Capabilities.Supports(capability.Sideband64k) {
+		m = sideband.NewMuxer(sideband.Sideband64k, cmd.Stdout)
+	} else if req.Capabilities.Supports(capability.Sideband) {
+		m = sideband.NewMuxer(sideband.Sideband, cmd.Stdout)
+	}
+
+	if m != nil {
+		req.Progress = &progressWriter{m}
+		w = m
+	}
+
 	rs, err := s.ReceivePack(context.TODO(), req)
 	if rs != nil {
-		if err := rs.Encode(cmd.Stdout); err != nil {
+		if err := rs.Encode(w); err != nil {
 			return fmt.Errorf("error in encoding report status %s", err)
 		}
 	}
 
+	if m != nil {
+		if err := pktline.NewEncoder(cmd.Stdout).Flush(); err != nil {
+			return fmt.Errorf("error in encoding sideband flush %s", err)
+		}
+	}
+
 	if err != nil {
 		return fmt.Errorf("error in receive pack: %s", err)
 	}
 
 	return nil
 }
+
+// progressWriter writes in the progress channel of a sideband.Muxer.
+type progressWriter struct {
+	m *sideband.Muxer
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	return w.m.WriteChannel(sideband.ProgressMessage, p)
+}
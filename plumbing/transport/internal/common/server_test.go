@@ -0,0 +1,196 @@
+package common
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/server"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type ServerSuite struct {
+	fixtures.Suite
+}
+
+var _ = Suite(&ServerSuite{})
+
+const (
+	masterHash  = "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"
+	commonHash  = "b029517f6300c2da0f4b651b8642506cd6aaf45d"
+	branchHash  = "e8d3ffab552895c19b9fcf7aa264d277cde33881"
+	unknownHash = "0000000000000000000000000000000000000001"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// serveUploadPack serves an upload-pack session of the basic fixture, with
+// the given client input. It returns the output following the advertised
+// references.
+func (s *ServerSuite) serveUploadPack(c *C, input []string) *bytes.Buffer {
+	out := s.serve(c, ServerCommand{}, input)
+
+	ar := packp.NewAdvRefs()
+	c.Assert(ar.Decode(out), IsNil)
+	return out
+}
+
+// serve serves an upload-pack session of the basic fixture with cmd, whose
+// input and output are replaced.
+func (s *ServerSuite) serve(c *C, cmd ServerCommand, input []string) *bytes.Buffer {
+	fs := fixtures.Basic().One().DotGit()
+	ep, err := transport.NewEndpoint(fs.Root())
+	c.Assert(err, IsNil)
+
+	loader := server.MapLoader{ep.String(): filesystem.NewStorage(fs, cache.NewObjectLRUDefault())}
+	sess, err := server.NewServer(loader).NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+
+	var in, out bytes.Buffer
+	c.Assert(pktline.NewEncoder(&in).EncodeString(input...), IsNil)
+	cmd.Stdin, cmd.Stdout = &in, nopWriteCloser{&out}
+	c.Assert(ServeUploadPack(cmd, sess), IsNil)
+	return &out
+}
+
+// readLines reads n pkt-lines of r.
+func readLines(c *C, r io.Reader, n int) []string {
+	var lines []string
+	sc := pktline.NewScanner(r)
+	for i := 0; i < n; i++ {
+		c.Assert(sc.Scan(), Equals, true)
+		lines = append(lines, string(sc.Bytes()))
+	}
+
+	return lines
+}
+
+// readPackfile stores the objects of the multiplexed packfile of r, returning
+// the number of commits.
+func readPackfile(c *C, r io.Reader) int {
+	st := memory.NewStorage()
+	c.Assert(packfile.UpdateObjectStorage(st, sideband.NewDemuxer(sideband.Sideband64k, r)), IsNil)
+
+	iter, err := st.IterEncodedObjects(plumbing.CommitObject)
+	c.Assert(err, IsNil)
+
+	n := 0
+	c.Assert(iter.ForEach(func(plumbing.EncodedObject) error {
+		n++
+		return nil
+	}), IsNil)
+
+	return n
+}
+
+func (s *ServerSuite) TestServeUploadPackNegotiation(c *C) {
+	out := s.serveUploadPack(c, []string{
+		"want " + masterHash + " multi_ack_detailed side-band-64k ofs-delta\n",
+		pktline.FlushString,
+		"have " + unknownHash + "\n",
+		pktline.FlushString,
+		"have " + commonHash + "\n",
+		pktline.FlushString,
+		"done\n",
+	})
+
+	c.Assert(readLines(c, out, 5), DeepEquals, []string{
+		"NAK\n",
+		"ACK " + commonHash + " common\n",
+		"ACK " + commonHash + " ready\n",
+		"NAK\n",
+		"ACK " + commonHash + "\n",
+	})
+	c.Assert(readPackfile(c, out), Equals, 7)
+}
+
+func (s *ServerSuite) TestServeUploadPackNoDone(c *C) {
+	out := s.serveUploadPack(c, []string{
+		"want " + masterHash + " multi_ack_detailed no-done side-band-64k ofs-delta\n",
+		pktline.FlushString,
+		"have " + commonHash + "\n",
+		pktline.FlushString,
+	})
+
+	c.Assert(readLines(c, out, 4), DeepEquals, []string{
+		"ACK " + commonHash + " common\n",
+		"ACK " + commonHash + " ready\n",
+		"NAK\n",
+		"ACK " + commonHash + "\n",
+	})
+	c.Assert(readPackfile(c, out), Equals, 7)
+}
+
+func (s *ServerSuite) TestServeUploadPackSingleACK(c *C) {
+	out := s.serveUploadPack(c, []string{
+		"want " + masterHash + " side-band-64k ofs-delta\n",
+		pktline.FlushString,
+		"have " + commonHash + "\n",
+		"have " + branchHash + "\n",
+		"done\n",
+	})
+
+	// Without multi_ack, only the first common object is acknowledged.
+	c.Assert(readLines(c, out, 1), DeepEquals, []string{
+		"ACK " + commonHash + "\n",
+	})
+	c.Assert(readPackfile(c, out), Not(Equals), 0)
+}
+
+func (s *ServerSuite) TestServeUploadPackShallow(c *C) {
+	out := s.serveUploadPack(c, []string{
+		"want " + masterHash + " side-band-64k ofs-delta\n",
+		"deepen 1\n",
+		pktline.FlushString,
+		"done\n",
+	})
+
+	c.Assert(readLines(c, out, 3), DeepEquals, []string{
+		"shallow " + masterHash + "\n",
+		"",
+		"NAK\n",
+	})
+	c.Assert(readPackfile(c, out), Equals, 1)
+}
+
+func (s *ServerSuite) TestServeUploadPackNothingToFetch(c *C) {
+	out := s.serveUploadPack(c, []string{pktline.FlushString})
+	c.Assert(out.Len(), Equals, 0)
+}
+
+func (s *ServerSuite) TestServeUploadPackStatelessRPC(c *C) {
+	// The references are not advertised, and the client sends a new request
+	// after the round.
+	input := []string{
+		"want " + masterHash + " multi_ack_detailed side-band-64k ofs-delta\n",
+		pktline.FlushString,
+		"have " + unknownHash + "\n",
+		pktline.FlushString,
+	}
+
+	out := s.serve(c, ServerCommand{StatelessRPC: true}, input)
+	c.Assert(readLines(c, out, 1), DeepEquals, []string{"NAK\n"})
+	c.Assert(out.Len(), Equals, 0)
+
+	input = append(input[:2], "have "+commonHash+"\n", "done\n")
+	out = s.serve(c, ServerCommand{StatelessRPC: true}, input)
+	c.Assert(readLines(c, out, 2), DeepEquals, []string{
+		"ACK " + commonHash + " common\n",
+		"ACK " + commonHash + "\n",
+	})
+	c.Assert(readPackfile(c, out), Equals, 7)
+}
@@ -0,0 +1,280 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// GitProtocolV2 is the value of GIT_PROTOCOL requesting the version 2 of the
+// protocol.
+const GitProtocolV2 = "version=2"
+
+// IsGitProtocolV2 returns true if the value of GIT_PROTOCOL, a colon-separated
+// list of parameters, requests the version 2 of the protocol.
+func IsGitProtocolV2(value string) bool {
+	for _, param := range strings.Split(value, ":") {
+		if param == GitProtocolV2 {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	servicePrefix = []byte("# service=")
+	versionLine   = []byte("version 2")
+)
+
+// PeekCapabilityAdvertisement reads the first pkt-lines sent by a server, and
+// decodes its capability advertisement if it speaks the version 2 of the
+// protocol. Otherwise, it returns the bytes read, which are the beginning of
+// the adv-refs of the server. The "# service=" prefix sent by the servers
+// over HTTP is skipped.
+func PeekCapabilityAdvertisement(r io.Reader) (*packp.CapabilityAdvertisement, []byte, error) {
+	var buf bytes.Buffer
+	s := pktline.NewScanner(io.TeeReader(r, &buf))
+
+	start := 0
+	for s.Scan() {
+		line := s.Bytes()
+		if start == 0 && bytes.HasPrefix(line, servicePrefix) {
+			// The prefix is followed by a flush-pkt.
+			if !s.Scan() {
+				break
+			}
+
+			start = buf.Len()
+			continue
+		}
+
+		if !bytes.Equal(bytes.TrimSuffix(line, []byte("\n")), versionLine) {
+			break
+		}
+
+		adv := packp.NewCapabilityAdvertisement()
+		err := adv.Decode(io.MultiReader(bytes.NewReader(buf.Bytes()[start:]), r))
+		return adv, nil, err
+	}
+
+	// The errors are left to the decoding of the adv-refs, which reads the
+	// same pkt-lines again.
+	return nil, buf.Bytes(), nil
+}
+
+// AddCommandCapabilities adds to caps the capabilities sent with the commands
+// to a server with the given capabilities.
+func AddCommandCapabilities(adv *packp.CapabilityAdvertisement, caps *capability.List) {
+	if adv.Supports(capability.Agent) && !caps.Supports(capability.Agent) {
+		_ = caps.Set(capability.Agent, capability.DefaultAgent())
+	}
+
+	if formats := adv.Capabilities.Get(capability.ObjectFormat); len(formats) > 0 &&
+		!caps.Supports(capability.ObjectFormat) {
+		_ = caps.Set(capability.ObjectFormat, formats[0])
+	}
+}
+
+// NewLsRefsRequest returns the ls-refs command listing the references with
+// the given prefixes, as they are advertised by the version 0 of the
+// protocol.
+func NewLsRefsRequest(prefixes []string) *packp.LsRefsRequest {
+	req := packp.NewLsRefsRequest()
+	req.Symrefs = true
+	req.Peel = true
+	req.RefPrefixes = prefixes
+	return req
+}
+
+// NewFetchRequest returns the fetch command equivalent to an upload-pack
+// request, which ends the negotiation at once like the version 0 of the
+// protocol.
+func NewFetchRequest(req *packp.UploadPackRequest) (*packp.FetchRequest, error) {
+	if req.Capabilities.Supports(capability.Sideband) &&
+		!req.Capabilities.Supports(capability.Sideband64k) {
+		return nil, fmt.Errorf("%s is not supported by protocol v2, use %s",
+			capability.Sideband, capability.Sideband64k)
+	}
+
+	fr := packp.NewFetchRequest()
+	fr.Wants = req.Wants
+	fr.Haves = req.Haves
+	fr.Shallows = req.Shallows
+	fr.Depth = req.Depth
+	fr.Filter = req.Filter
+	fr.Done = true
+	fr.OFSDelta = req.Capabilities.Supports(capability.OFSDelta)
+	fr.NoProgress = req.Capabilities.Supports(capability.NoProgress)
+	fr.IncludeTag = req.Capabilities.Supports(capability.IncludeTag)
+	fr.ThinPack = req.Capabilities.Supports(capability.ThinPack)
+	fr.DeepenRelative = req.Capabilities.Supports(capability.DeepenRelative)
+	if agent := req.Capabilities.Get(capability.Agent); len(agent) > 0 {
+		_ = fr.Capabilities.Set(capability.Agent, agent[0])
+	}
+
+	return fr, nil
+}
+
+// DecodeFetchResponse decodes r, the response to the fetch command of an
+// upload-pack request, into a new packp.UploadPackResponse. The packfile is
+// demultiplexed if the request has no sideband capability.
+func DecodeFetchResponse(r io.ReadCloser, req *packp.UploadPackRequest) (
+	*packp.UploadPackResponse, error,
+) {
+	fr := packp.NewFetchResponse()
+	if err := fr.Decode(r); err != nil {
+		return nil, fmt.Errorf("error decoding fetch response: %s", err)
+	}
+
+	if fr.Packfile == nil {
+		return nil, errors.New("error decoding fetch response: missing packfile")
+	}
+
+	pack := fr.Packfile
+	if !req.Capabilities.Supports(capability.Sideband64k) {
+		pack = sideband.NewDemuxer(sideband.Sideband64k, pack)
+	}
+
+	res := packp.NewUploadPackResponseWithPackfile(req, ioutil.NewReadCloser(pack, r))
+	res.ShallowUpdate = fr.ShallowUpdate
+	res.ACKs = fr.ACKs
+	return res, nil
+}
+
+// CapabilityAdvertisement returns the capabilities advertised by the server,
+// if it speaks the version 2 of the protocol.
+func (s *session) CapabilityAdvertisement(ctx context.Context) (*packp.CapabilityAdvertisement, error) {
+	if !s.advRead && !s.isReceivePack {
+		adv, peeked, err := PeekCapabilityAdvertisement(s.StdoutContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		s.capAdv, s.peeked, s.advRead = adv, peeked, true
+	}
+
+	if s.capAdv == nil {
+		return nil, transport.ErrProtocolV2Unsupported
+	}
+
+	return s.capAdv, nil
+}
+
+// advertisedReferencesV2 lists the references with an ls-refs command, and
+// returns them as they are advertised by the version 0 of the protocol.
+func (s *session) advertisedReferencesV2(ctx context.Context, adv *packp.CapabilityAdvertisement) (*packp.AdvRefs, error) {
+	res, err := s.LsRefs(ctx, NewLsRefsRequest(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.References) == 0 {
+		if err := s.finish(); err != nil {
+			return nil, err
+		}
+
+		return nil, transport.ErrEmptyRemoteRepository
+	}
+
+	return res.AdvRefs(adv)
+}
+
+// LsRefs runs an ls-refs command.
+func (s *session) LsRefs(ctx context.Context, req *packp.LsRefsRequest) (*packp.LsRefsResponse, error) {
+	adv, err := s.CapabilityAdvertisement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	AddCommandCapabilities(adv, req.Capabilities)
+	if err := req.Encode(s.StdinContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	res := packp.NewLsRefsResponse()
+	if err := res.Decode(s.StdoutContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Fetch runs a fetch command.
+func (s *session) Fetch(ctx context.Context, req *packp.FetchRequest) (*packp.FetchResponse, error) {
+	adv, err := s.CapabilityAdvertisement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	AddCommandCapabilities(adv, req.Capabilities)
+	if err := req.Encode(s.StdinContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	res := packp.NewFetchResponse()
+	if err := res.Decode(s.StdoutContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ObjectInfo runs an object-info command.
+func (s *session) ObjectInfo(ctx context.Context, req *packp.ObjectInfoRequest) (*packp.ObjectInfoResponse, error) {
+	adv, err := s.CapabilityAdvertisement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	AddCommandCapabilities(adv, req.Capabilities)
+	if err := req.Encode(s.StdinContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	res := &packp.ObjectInfoResponse{}
+	if err := res.Decode(s.StdoutContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// uploadPackV2 runs the fetch command of an upload-pack request, then ends
+// the session.
+func (s *session) uploadPackV2(ctx context.Context, adv *packp.CapabilityAdvertisement, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	fr, err := NewFetchRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.packRun = true
+	AddCommandCapabilities(adv, fr.Capabilities)
+
+	in := s.StdinContext(ctx)
+	if err := fr.Encode(in); err != nil {
+		return nil, err
+	}
+
+	// An empty request ends the session once the packfile is sent.
+	if _, err := in.Write(pktline.FlushPkt); err != nil {
+		return nil, err
+	}
+
+	if err := in.Close(); err != nil {
+		return nil, fmt.Errorf("closing input: %s", err)
+	}
+
+	rc := ioutil.NewReadCloser(s.StdoutContext(ctx), s)
+	return DecodeFetchResponse(rc, req)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+)
+
+// objectFilter omits objects from a packfile, as requested by the filter of a
+// partial clone (see packp.Filter). The objects wanted by the client are
+// never omitted.
+type objectFilter struct {
+	// blobLimit omits the blobs with a size of at least blobLimit bytes, all
+	// of them if it is zero, and none of them if it is negative.
+	blobLimit int64
+	// treeDepth omits the trees and blobs with a depth of at least treeDepth
+	// from the root tree, the trees being not walked. It is negative if there
+	// is no limit.
+	treeDepth int
+	// types are the types of the objects to send, all of them must match.
+	types []plumbing.ObjectType
+}
+
+// parseFilter parses the filter sent by a client, which may be empty.
+func parseFilter(filter packp.Filter) (*objectFilter, error) {
+	f := &objectFilter{blobLimit: -1, treeDepth: -1}
+	if filter == "" {
+		return f, nil
+	}
+
+	if err := f.add(string(filter)); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *objectFilter) add(spec string) error {
+	name, value, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "blob:none":
+		f.limitBlobs(0)
+	case name == "blob" && strings.HasPrefix(value, "limit="):
+		n, err := parseFilterSize(strings.TrimPrefix(value, "limit="))
+		if err != nil {
+			return fmt.Errorf("invalid filter %q: %w", spec, err)
+		}
+
+		f.limitBlobs(n)
+	case name == "tree":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid filter %q", spec)
+		}
+
+		if f.treeDepth < 0 || n < f.treeDepth {
+			f.treeDepth = n
+		}
+	case name == "object" && strings.HasPrefix(value, "type="):
+		t, err := plumbing.ParseObjectType(strings.TrimPrefix(value, "type="))
+		if err != nil || t.IsDelta() {
+			return fmt.Errorf("%w: %q", packp.ErrUnsupportedObjectFilterType, spec)
+		}
+
+		f.types = append(f.types, t)
+	case name == "combine":
+		for _, sub := range strings.Split(value, "+") {
+			sub, err := url.QueryUnescape(sub)
+			if err != nil {
+				return fmt.Errorf("invalid filter %q: %w", spec, err)
+			}
+
+			if err := f.add(sub); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: %q", packp.ErrUnsupportedObjectFilterType, spec)
+	}
+
+	return nil
+}
+
+func (f *objectFilter) limitBlobs(n int64) {
+	if f.blobLimit < 0 || n < f.blobLimit {
+		f.blobLimit = n
+	}
+}
+
+// parseFilterSize parses the size of a blob:limit filter, with an optional
+// k, m or g unit.
+func parseFilterSize(s string) (int64, error) {
+	unit := int64(1)
+	if len(s) > 0 {
+		switch strings.ToLower(s[len(s)-1:]) {
+		case "k":
+			unit = 1 << 10
+		case "m":
+			unit = 1 << 20
+		case "g":
+			unit = 1 << 30
+		}
+
+		if unit > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return n * unit, nil
+}
+
+// isEmpty returns true if the filter does not omit any object.
+func (f *objectFilter) isEmpty() bool {
+	return f.blobLimit < 0 && f.treeDepth < 0 && len(f.types) == 0
+}
+
+// showType returns true if the objects of type t are sent.
+func (f *objectFilter) showType(t plumbing.ObjectType) bool {
+	for _, typ := range f.types {
+		if typ != t {
+			return false
+		}
+	}
+
+	return true
+}
+
+// walkTree returns true if the trees at the given depth are walked.
+func (f *objectFilter) walkTree(depth int) bool {
+	return f.treeDepth < 0 || depth < f.treeDepth
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jesseduffield/go-git/v5/config"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	"github.com/jesseduffield/go-git/v5/plumbing/fsck"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/revlist"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// fsckOptions returns the options of the checks of the pushed objects, from
+// receive.fsckObjects, or transfer.fsckObjects, and the receive.fsck.<msg-id>
+// severities of the config of the storer. It returns nil when the objects
+// are not checked.
+func fsckOptions(s storer.Storer) (*fsck.Options, error) {
+	cs, ok := s.(config.ConfigStorer)
+	if !ok {
+		return nil, nil
+	}
+
+	cfg, err := cs.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	receive := cfg.Raw.Section("receive")
+	enabled := cfg.Raw.Section("transfer").Option("fsckObjects") == "true"
+	if receive.HasOption("fsckObjects") {
+		enabled = receive.Option("fsckObjects") == "true"
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+
+	opts := &fsck.Options{Severities: make(map[fsck.ID]fsck.Severity)}
+	for _, o := range receive.Subsection("fsck").Options {
+		sev, err := fsck.ParseSeverity(o.Value)
+		if err != nil {
+			return nil, fmt.Errorf("receive.fsck.%s: %w", o.Key, err)
+		}
+
+		opts.Severities[fsck.ID(o.Key)] = sev
+	}
+
+	return opts, nil
+}
+
+// checkObjects checks the objects pushed for the commands, like git
+// receive-pack with receive.fsckObjects, and returns the accepted commands.
+// The pushed objects are the ones reachable from the new values of the
+// commands and not from the references: their history must be connected
+// to the objects of the repository, and they must have no problem with the
+// fsck.Error severity. The status of the rejected commands is set.
+func (s *rpSession) checkObjects(cmds []*packp.Command) ([]*packp.Command, error) {
+	opts, err := fsckOptions(s.storer)
+	if err != nil || opts == nil {
+		return cmds, err
+	}
+
+	objects := s.objectStorer()
+	c, err := newObjectsCheck(objects, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rejected := make(map[plumbing.ReferenceName]error)
+	for _, cmd := range cmds {
+		if cmd.Action() == packp.Delete {
+			continue
+		}
+
+		if err := c.walk(cmd.New); err != nil {
+			rejected[cmd.Name] = err
+		}
+	}
+
+	// The .gitmodules blobs which were already in the repository are only
+	// checked at the end, their problems reject all the commands.
+	problems, err := c.checker.Finish(objects)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range problems {
+		if p.Severity != fsck.Error {
+			continue
+		}
+
+		for _, cmd := range cmds {
+			if _, ok := rejected[cmd.Name]; !ok && cmd.Action() != packp.Delete {
+				rejected[cmd.Name] = p
+			}
+		}
+	}
+
+	var accepted []*packp.Command
+	for _, cmd := range cmds {
+		if err, ok := rejected[cmd.Name]; ok {
+			s.setStatus(cmd.Name, err)
+			continue
+		}
+
+		accepted = append(accepted, cmd)
+	}
+
+	return accepted, nil
+}
+
+// objectsCheck checks the objects which are not reachable from the
+// references, remembering the result of each object.
+type objectsCheck struct {
+	storer  storer.EncodedObjectStorer
+	checker *fsck.ObjectChecker
+	// known are the objects reachable from the references.
+	known   map[plumbing.Hash]bool
+	checked map[plumbing.Hash]*checkedObject
+}
+
+type checkedObject struct {
+	err   error
+	links []plumbing.Hash
+}
+
+func newObjectsCheck(s storer.Storer, opts *fsck.Options) (*objectsCheck, error) {
+	iter, err := s.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []plumbing.Hash
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	known, err := revlist.Objects(s, tips, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &objectsCheck{
+		storer:  s,
+		checker: fsck.NewObjectChecker(opts),
+		known:   make(map[plumbing.Hash]bool, len(known)),
+		checked: make(map[plumbing.Hash]*checkedObject),
+	}
+
+	for _, h := range known {
+		c.known[h] = true
+	}
+
+	return c, nil
+}
+
+// walk checks the objects reachable from h which are not known, and returns
+// the first error found.
+func (c *objectsCheck) walk(h plumbing.Hash) error {
+	visited := make(map[plumbing.Hash]bool)
+	pending := []plumbing.Hash{h}
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[h] || c.known[h] {
+			continue
+		}
+
+		visited[h] = true
+		o, err := c.check(h)
+		if err != nil {
+			return err
+		}
+
+		if o.err != nil {
+			return o.err
+		}
+
+		pending = append(pending, o.links...)
+	}
+
+	return nil
+}
+
+func (c *objectsCheck) check(h plumbing.Hash) (*checkedObject, error) {
+	if o, ok := c.checked[h]; ok {
+		return o, nil
+	}
+
+	o := &checkedObject{}
+	c.checked[h] = o
+
+	obj, err := c.storer.EncodedObject(plumbing.AnyObject, h)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		o.err = ErrMissingObjects
+		return o, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readObject(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range c.checker.Check(h, obj.Type(), data) {
+		if p.Severity == fsck.Error {
+			o.err = p
+			return o, nil
+		}
+	}
+
+	o.links, o.err = objectLinks(c.storer, obj)
+	return o, nil
+}
+
+func readObject(o plumbing.EncodedObject) (data []byte, err error) {
+	r, err := o.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	return io.ReadAll(r)
+}
+
+// objectLinks returns the objects referenced by an object.
+func objectLinks(s storer.EncodedObjectStorer, o plumbing.EncodedObject) ([]plumbing.Hash, error) {
+	if o.Type() == plumbing.BlobObject {
+		return nil, nil
+	}
+
+	do, err := object.DecodeObject(s, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []plumbing.Hash
+	switch do := do.(type) {
+	case *object.Commit:
+		links = append(links, do.TreeHash)
+		links = append(links, do.ParentHashes...)
+	case *object.Tree:
+		for _, e := range do.Entries {
+			if e.Mode != filemode.Submodule {
+				links = append(links, e.Hash)
+			}
+		}
+	case *object.Tag:
+		links = append(links, do.Target)
+	}
+
+	return links, nil
+}
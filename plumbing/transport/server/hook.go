@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+)
+
+// ReceiveHook is called by the receive-pack sessions around the update of the
+// references, like the pre-receive, update and post-receive hooks of git.
+type ReceiveHook interface {
+	// PreReceive is called with the commands of a push before any reference
+	// is updated, once the pushed objects are in the storer. An error
+	// rejects all the commands.
+	PreReceive(ctx context.Context, req *HookRequest) error
+	// Update is called for each command accepted by PreReceive, before its
+	// reference is updated. An error rejects the command.
+	Update(ctx context.Context, req *HookRequest, cmd *packp.Command) error
+	// PostReceive is called with the commands whose references were
+	// updated.
+	PostReceive(ctx context.Context, req *HookRequest)
+}
+
+// HookRequest is a push, as seen by a ReceiveHook.
+type HookRequest struct {
+	// Storer is the storer of the repository, containing the pushed
+	// objects. Until the commands are accepted, the pushed objects are in
+	// quarantine if the storer supports it.
+	Storer storer.Storer
+	// Commands are the commands of the push which are not rejected yet.
+	Commands []*packp.Command
+	// Options are the push options sent by the client.
+	Options []*packp.Option
+	// Progress receives the messages of the hook, which are sent to the
+	// client over the sideband progress channel. They are discarded if the
+	// client does not support sideband.
+	Progress sideband.Progress
+}
+
+// HookLoader is a Loader which also loads the ReceiveHook of the
+// repositories.
+type HookLoader interface {
+	Loader
+	// LoadHook returns the ReceiveHook of the repository of the given
+	// transport.Endpoint, or nil if it has none.
+	LoadHook(ep *transport.Endpoint) (ReceiveHook, error)
+}
+
+type hookLoader struct {
+	Loader
+	hook ReceiveHook
+}
+
+// NewHookLoader returns a HookLoader loading the repositories with the given
+// Loader, which all have the given ReceiveHook.
+func NewHookLoader(l Loader, h ReceiveHook) HookLoader {
+	return &hookLoader{l, h}
+}
+
+// LoadHook returns the ReceiveHook of the loader.
+func (l *hookLoader) LoadHook(*transport.Endpoint) (ReceiveHook, error) {
+	return l.hook, nil
+}
+
+// receiveHooks runs the pre-receive and update hooks with the commands, and
+// returns the accepted ones. The status of the rejected commands is set.
+func (s *rpSession) receiveHooks(ctx context.Context, req *packp.ReferenceUpdateRequest, cmds []*packp.Command) []*packp.Command {
+	if s.hook == nil || len(cmds) == 0 {
+		return cmds
+	}
+
+	hr := s.hookRequest(req, cmds)
+	if err := s.hook.PreReceive(ctx, hr); err != nil {
+		for _, cmd := range cmds {
+			s.setStatus(cmd.Name, err)
+		}
+
+		return nil
+	}
+
+	var accepted []*packp.Command
+	for _, cmd := range cmds {
+		if err := s.hook.Update(ctx, hr, cmd); err != nil {
+			s.setStatus(cmd.Name, err)
+			continue
+		}
+
+		accepted = append(accepted, cmd)
+	}
+
+	return accepted
+}
+
+// postReceiveHook runs the post-receive hook with the commands whose
+// references were updated.
+func (s *rpSession) postReceiveHook(ctx context.Context, req *packp.ReferenceUpdateRequest, cmds []*packp.Command) {
+	if s.hook == nil {
+		return
+	}
+
+	var updated []*packp.Command
+	for _, cmd := range cmds {
+		if s.cmdStatus[cmd.Name] == nil {
+			updated = append(updated, cmd)
+		}
+	}
+
+	if len(updated) == 0 {
+		return
+	}
+
+	s.hook.PostReceive(ctx, s.hookRequest(req, updated))
+}
+
+func (s *rpSession) hookRequest(req *packp.ReferenceUpdateRequest, cmds []*packp.Command) *HookRequest {
+	var progress sideband.Progress = io.Discard
+	if req.Progress != nil && (s.caps.Supports(capability.Sideband64k) ||
+		s.caps.Supports(capability.Sideband)) {
+		progress = req.Progress
+	}
+
+	return &HookRequest{
+		Storer:   s.objectStorer(),
+		Commands: cmds,
+		Options:  req.Options,
+		Progress: progress,
+	}
+}
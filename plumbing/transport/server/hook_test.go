@@ -0,0 +1,163 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/server"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+var errProtected = errors.New("protected branch")
+
+// testHook rejects the updates of refs/heads/bad, and the whole push if
+// declined is set.
+type testHook struct {
+	declined    bool
+	missing     []plumbing.Hash
+	updates     []plumbing.ReferenceName
+	postReceive []plumbing.ReferenceName
+}
+
+func (h *testHook) PreReceive(ctx context.Context, req *server.HookRequest) error {
+	fmt.Fprintf(req.Progress, "pre-receive: %d commands\n", len(req.Commands))
+	for _, cmd := range req.Commands {
+		if req.Storer.HasEncodedObject(cmd.New) != nil {
+			h.missing = append(h.missing, cmd.New)
+		}
+	}
+
+	if h.declined {
+		return errProtected
+	}
+
+	return nil
+}
+
+func (h *testHook) Update(ctx context.Context, req *server.HookRequest, cmd *packp.Command) error {
+	h.updates = append(h.updates, cmd.Name)
+	if cmd.Name == "refs/heads/bad" {
+		fmt.Fprintf(req.Progress, "update: %s is protected\n", cmd.Name)
+		return errProtected
+	}
+
+	return nil
+}
+
+func (h *testHook) PostReceive(ctx context.Context, req *server.HookRequest) {
+	for _, cmd := range req.Commands {
+		h.postReceive = append(h.postReceive, cmd.Name)
+	}
+}
+
+type HookSuite struct {
+	loader   server.MapLoader
+	client   transport.Transport
+	endpoint *transport.Endpoint
+	hook     *testHook
+}
+
+var _ = Suite(&HookSuite{})
+
+func (s *HookSuite) SetUpTest(c *C) {
+	var err error
+	s.endpoint, err = transport.NewEndpoint("/empty.git")
+	c.Assert(err, IsNil)
+
+	s.loader = server.MapLoader{s.endpoint.String(): memory.NewStorage()}
+	s.hook = &testHook{}
+	s.client = server.NewClient(server.NewHookLoader(s.loader, s.hook))
+}
+
+func (s *HookSuite) TestReceivePackHooks(c *C) {
+	sto := s.loader[s.endpoint.String()]
+	r, err := s.client.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	var progress bytes.Buffer
+	req, _ := fsckRequest(c)
+	c.Assert(req.Capabilities.Set(capability.Sideband64k), IsNil)
+	req.Progress = &progress
+
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, Equals, errProtected)
+	c.Assert(statuses(report), DeepEquals, map[plumbing.ReferenceName]string{
+		"refs/heads/good":         "ok",
+		"refs/heads/bad":          errProtected.Error(),
+		"refs/heads/disconnected": "ok",
+	})
+	c.Assert(progress.String(), Equals,
+		"pre-receive: 3 commands\nupdate: refs/heads/bad is protected\n")
+	c.Assert(s.hook.updates, DeepEquals, []plumbing.ReferenceName{
+		"refs/heads/good", "refs/heads/bad", "refs/heads/disconnected",
+	})
+	c.Assert(s.hook.postReceive, DeepEquals, []plumbing.ReferenceName{
+		"refs/heads/good", "refs/heads/disconnected",
+	})
+
+	_, err = sto.Reference("refs/heads/bad")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *HookSuite) TestReceivePackHooksWithoutSideband(c *C) {
+	r, err := s.client.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	var progress bytes.Buffer
+	req, _ := fsckRequest(c)
+	req.Progress = &progress
+
+	_, err = r.ReceivePack(context.Background(), req)
+	c.Assert(err, Equals, errProtected)
+	c.Assert(progress.Len(), Equals, 0)
+}
+
+func (s *HookSuite) TestReceivePackPreReceiveDeclined(c *C) {
+	s.hook.declined = true
+	sto := s.loader[s.endpoint.String()]
+	r, err := s.client.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req, _ := fsckRequest(c)
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, Equals, errProtected)
+	for _, status := range statuses(report) {
+		c.Assert(status, Equals, errProtected.Error())
+	}
+
+	c.Assert(s.hook.missing, HasLen, 0)
+	c.Assert(s.hook.updates, HasLen, 0)
+	c.Assert(s.hook.postReceive, HasLen, 0)
+	c.Assert(sto.(*memory.Storage).Objects, HasLen, 0)
+
+	_, err = sto.Reference("refs/heads/good")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *HookSuite) TestReceivePackHooksAtomic(c *C) {
+	sto := s.loader[s.endpoint.String()]
+	r, err := s.client.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req, _ := fsckRequest(c)
+	c.Assert(req.Capabilities.Set(capability.Atomic), IsNil)
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, Equals, errProtected)
+	c.Assert(statuses(report)["refs/heads/good"], Equals, server.ErrAtomicPushFailure.Error())
+	c.Assert(s.hook.postReceive, HasLen, 0)
+
+	_, err = sto.Reference("refs/heads/good")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
@@ -0,0 +1,535 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/revlist"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+)
+
+// ErrNoShallowCommits is returned if a deepen-since or deepen-not request
+// selects no commits.
+var ErrNoShallowCommits = errors.New("no commits selected for shallow requests")
+
+// objectsRequest selects the objects sent to a client, by an upload-pack
+// request or a fetch command.
+type objectsRequest struct {
+	Wants []plumbing.Hash
+	// Haves are the common objects, which the client has.
+	Haves []plumbing.Hash
+	// Shallows are the shallow commits of the client.
+	Shallows       []plumbing.Hash
+	Depth          packp.Depth
+	DeepenRelative bool
+	Filter         packp.Filter
+}
+
+// newObjectsRequest returns the objects request of an upload-pack request,
+// with the given common objects.
+func newObjectsRequest(req *packp.UploadRequest, haves []plumbing.Hash) *objectsRequest {
+	return &objectsRequest{
+		Wants:          req.Wants,
+		Haves:          haves,
+		Shallows:       req.Shallows,
+		Depth:          req.Depth,
+		DeepenRelative: req.Capabilities.Supports(capability.DeepenRelative),
+		Filter:         req.Filter,
+	}
+}
+
+// objects returns the objects to send to a client, and the changes of its
+// shallow commits.
+func (s *upSession) objects(req *objectsRequest) ([]plumbing.Hash, *packp.ShallowUpdate, error) {
+	filter, err := parseFilter(req.Filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	update := &packp.ShallowUpdate{}
+	deepen := req.Depth != nil && !req.Depth.IsZero()
+	if len(req.Shallows) == 0 && !deepen && filter.isEmpty() {
+		objs, err := revlist.Objects(s.storer, req.Wants, req.Haves)
+		return objs, update, err
+	}
+
+	clientShallows := s.clientShallows(req.Shallows)
+
+	// The history of the client stops at its shallow commits.
+	have := newObjectWalker(s.storer, clientShallows, nil, nil)
+	have.allowMissing = true
+	if err := have.walk(req.Haves); err != nil {
+		return nil, nil, err
+	}
+
+	wants := req.Wants
+	shallows := clientShallows
+	if deepen {
+		wants, shallows, err = s.deepen(req, clientShallows, update)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	w := newObjectWalker(s.storer, shallows, filter, have.seen)
+	w.explicit = make(map[plumbing.Hash]bool, len(req.Wants))
+	for _, h := range req.Wants {
+		w.explicit[h] = true
+	}
+
+	if err := w.walk(wants); err != nil {
+		return nil, nil, err
+	}
+
+	return w.objects, update, nil
+}
+
+// clientShallows returns the shallow commits of the client known by the
+// server, the other ones being ignored.
+func (s *upSession) clientShallows(shallows []plumbing.Hash) map[plumbing.Hash]bool {
+	clientShallows := make(map[plumbing.Hash]bool, len(shallows))
+	for _, h := range shallows {
+		if err := s.storer.HasEncodedObject(h); err == nil {
+			clientShallows[h] = true
+		}
+	}
+
+	return clientShallows
+}
+
+// deepen computes the new shallow commits of the client, which are added to
+// update with the client shallow commits which are not shallow anymore. It
+// returns the commits whose parents are not sent, and the wants including the
+// parents of the unshallowed commits.
+func (s *upSession) deepen(
+	req *objectsRequest, clientShallows map[plumbing.Hash]bool, update *packp.ShallowUpdate,
+) ([]plumbing.Hash, map[plumbing.Hash]bool, error) {
+	heads, err := s.peelCommits(req.Wants)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var border, included map[plumbing.Hash]bool
+	switch depth := req.Depth.(type) {
+	case packp.DepthCommits:
+		n := int(depth)
+		if req.DeepenRelative {
+			heads, n = hashSetToList(clientShallows), n+1
+		}
+
+		border, included, err = s.shallowCommits(heads, n)
+	case packp.DepthSince:
+		border, included, err = s.shallowCommitsSince(heads, time.Time(depth))
+	case packp.DepthReference:
+		border, included, err = s.shallowCommitsNot(heads, plumbing.ReferenceName(depth))
+	default:
+		err = fmt.Errorf("unsupported depth type")
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for h := range border {
+		if !clientShallows[h] {
+			update.Shallows = append(update.Shallows, h)
+		}
+	}
+
+	wants := req.Wants
+	for h := range clientShallows {
+		if !included[h] {
+			border[h] = true
+			continue
+		}
+
+		// The client has the commit, but not its parents.
+		update.Unshallows = append(update.Unshallows, h)
+		c, err := object.GetCommit(s.storer, h)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		wants = append(wants, c.ParentHashes...)
+	}
+
+	plumbing.HashesSort(update.Shallows)
+	plumbing.HashesSort(update.Unshallows)
+	return wants, border, nil
+}
+
+// shallowCommits walks the history of heads up to depth commits. It returns
+// the commits at the maximum depth which have parents, and the other walked
+// commits.
+func (s *upSession) shallowCommits(heads []plumbing.Hash, depth int) (
+	border, included map[plumbing.Hash]bool, err error,
+) {
+	border = make(map[plumbing.Hash]bool)
+	included = make(map[plumbing.Hash]bool)
+
+	type pending struct {
+		hash  plumbing.Hash
+		depth int
+	}
+
+	// The walk is breadth-first, so the commits are reached at their minimum
+	// depth first.
+	var queue []pending
+	for _, h := range heads {
+		queue = append(queue, pending{h, 1})
+	}
+
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if border[p.hash] || included[p.hash] {
+			continue
+		}
+
+		c, err := object.GetCommit(s.storer, p.hash)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if p.depth >= depth {
+			if c.NumParents() > 0 {
+				border[c.Hash] = true
+			} else {
+				included[c.Hash] = true
+			}
+
+			continue
+		}
+
+		included[c.Hash] = true
+		for _, parent := range c.ParentHashes {
+			queue = append(queue, pending{parent, p.depth + 1})
+		}
+	}
+
+	return border, included, nil
+}
+
+// shallowCommitsSince walks the history of heads, up to the commits older
+// than since.
+func (s *upSession) shallowCommitsSince(heads []plumbing.Hash, since time.Time) (
+	border, included map[plumbing.Hash]bool, err error,
+) {
+	return s.shallowCommitsWhere(heads, func(c *object.Commit) bool {
+		return !c.Committer.When.Before(since)
+	})
+}
+
+// shallowCommitsNot walks the history of heads, up to the commits reachable
+// from the given reference.
+func (s *upSession) shallowCommitsNot(heads []plumbing.Hash, name plumbing.ReferenceName) (
+	border, included map[plumbing.Hash]bool, err error,
+) {
+	ref, err := s.resolveDeepenNot(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commits, err := s.peelCommits([]plumbing.Hash{ref.Hash()})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	excluded := make(map[plumbing.Hash]bool)
+	for _, h := range commits {
+		c, err := object.GetCommit(s.storer, h)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = object.NewCommitPreorderIter(c, nil, nil).ForEach(func(c *object.Commit) error {
+			excluded[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return s.shallowCommitsWhere(heads, func(c *object.Commit) bool {
+		return !excluded[c.Hash]
+	})
+}
+
+// resolveDeepenNot resolves the reference of a deepen-not request, which may
+// be a short name.
+func (s *upSession) resolveDeepenNot(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	for _, rule := range plumbing.RefRevParseRules {
+		ref, err := storer.ResolveReference(s.storer, plumbing.ReferenceName(fmt.Sprintf(rule, name)))
+		if err == nil {
+			return ref, nil
+		}
+
+		if err != plumbing.ErrReferenceNotFound {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("deepen-not is not a reference: %s", name)
+}
+
+// shallowCommitsWhere walks the history of heads, up to the commits which are
+// not selected. It returns the selected commits which have a parent not
+// selected, and the other selected commits.
+func (s *upSession) shallowCommitsWhere(heads []plumbing.Hash, selected func(*object.Commit) bool) (
+	border, included map[plumbing.Hash]bool, err error,
+) {
+	border = make(map[plumbing.Hash]bool)
+	included = make(map[plumbing.Hash]bool)
+	visited := make(map[plumbing.Hash]bool)
+
+	var commits []*object.Commit
+	pending := append([]plumbing.Hash(nil), heads...)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[h] {
+			continue
+		}
+
+		visited[h] = true
+		c, err := object.GetCommit(s.storer, h)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !selected(c) {
+			continue
+		}
+
+		included[h] = true
+		commits = append(commits, c)
+		pending = append(pending, c.ParentHashes...)
+	}
+
+	if len(commits) == 0 {
+		return nil, nil, ErrNoShallowCommits
+	}
+
+	for _, c := range commits {
+		for _, p := range c.ParentHashes {
+			if !included[p] {
+				border[c.Hash] = true
+				break
+			}
+		}
+	}
+
+	for h := range border {
+		delete(included, h)
+	}
+
+	return border, included, nil
+}
+
+// peelCommits returns the commits of the given objects, the annotated tags
+// being peeled. The other objects are skipped.
+func (s *upSession) peelCommits(hashes []plumbing.Hash) ([]plumbing.Hash, error) {
+	var commits []plumbing.Hash
+	for _, h := range hashes {
+		o, err := object.GetObject(s.storer, h)
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			t, ok := o.(*object.Tag)
+			if !ok {
+				break
+			}
+
+			if o, err = t.Object(); err != nil {
+				return nil, err
+			}
+		}
+
+		if c, ok := o.(*object.Commit); ok {
+			commits = append(commits, c.Hash)
+		}
+	}
+
+	return commits, nil
+}
+
+// objectWalker walks the objects reachable from commits, trees, blobs and
+// tags.
+type objectWalker struct {
+	storer storer.EncodedObjectStorer
+	// shallows are the commits whose parents are not walked.
+	shallows map[plumbing.Hash]bool
+	filter   *objectFilter
+	// have are the objects of the client, which are not walked.
+	have map[plumbing.Hash]bool
+	// explicit are the objects wanted by the client, never filtered.
+	explicit map[plumbing.Hash]bool
+	// allowMissing skips the missing objects.
+	allowMissing bool
+
+	seen map[plumbing.Hash]bool
+	// treeDepths are the minimum depths of the walked trees, if the filter
+	// limits the depth of the trees.
+	treeDepths map[plumbing.Hash]int
+	objects    []plumbing.Hash
+}
+
+func newObjectWalker(
+	s storer.EncodedObjectStorer, shallows map[plumbing.Hash]bool, filter *objectFilter, have map[plumbing.Hash]bool,
+) *objectWalker {
+	if filter == nil {
+		filter = &objectFilter{blobLimit: -1, treeDepth: -1}
+	}
+
+	return &objectWalker{
+		storer:     s,
+		shallows:   shallows,
+		filter:     filter,
+		have:       have,
+		seen:       make(map[plumbing.Hash]bool),
+		treeDepths: make(map[plumbing.Hash]int),
+	}
+}
+
+func (w *objectWalker) walk(hashes []plumbing.Hash) error {
+	pending := append([]plumbing.Hash(nil), hashes...)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if w.seen[h] || w.have[h] {
+			continue
+		}
+
+		o, err := object.GetObject(w.storer, h)
+		if err == plumbing.ErrObjectNotFound && w.allowMissing {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch o := o.(type) {
+		case *object.Commit:
+			w.add(h, plumbing.CommitObject)
+			if err := w.walkTree(o.TreeHash, 0); err != nil {
+				return err
+			}
+
+			if !w.shallows[h] {
+				pending = append(pending, o.ParentHashes...)
+			}
+		case *object.Tag:
+			w.add(h, plumbing.TagObject)
+			pending = append(pending, o.Target)
+		case *object.Tree:
+			if err := w.walkTree(h, 0); err != nil {
+				return err
+			}
+		case *object.Blob:
+			w.add(h, plumbing.BlobObject)
+		}
+	}
+
+	return nil
+}
+
+func (w *objectWalker) walkTree(h plumbing.Hash, depth int) error {
+	if w.have[h] || (!w.filter.walkTree(depth) && !w.explicit[h]) {
+		return nil
+	}
+
+	// Without a depth limit, the trees are walked once.
+	if d, ok := w.treeDepths[h]; ok && (d <= depth || w.filter.treeDepth < 0) {
+		return nil
+	}
+
+	w.treeDepths[h] = depth
+	w.add(h, plumbing.TreeObject)
+
+	t, err := object.GetTree(w.storer, h)
+	if err == plumbing.ErrObjectNotFound && w.allowMissing {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range t.Entries {
+		switch e.Mode {
+		case filemode.Submodule:
+		case filemode.Dir:
+			if err := w.walkTree(e.Hash, depth+1); err != nil {
+				return err
+			}
+		default:
+			if err := w.walkBlob(e.Hash, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *objectWalker) walkBlob(h plumbing.Hash, depth int) error {
+	if w.seen[h] || w.have[h] {
+		return nil
+	}
+
+	if !w.explicit[h] {
+		if !w.filter.walkTree(depth) {
+			return nil
+		}
+
+		if w.filter.blobLimit >= 0 {
+			size, err := w.storer.EncodedObjectSize(h)
+			if err == plumbing.ErrObjectNotFound && w.allowMissing {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if size >= w.filter.blobLimit {
+				return nil
+			}
+		}
+	}
+
+	w.add(h, plumbing.BlobObject)
+	return nil
+}
+
+// add marks an object as walked, and adds it to the objects if it is not
+// filtered.
+func (w *objectWalker) add(h plumbing.Hash, t plumbing.ObjectType) {
+	if w.seen[h] {
+		return
+	}
+
+	w.seen[h] = true
+	if w.filter.showType(t) || w.explicit[h] {
+		w.objects = append(w.objects, h)
+	}
+}
+
+func hashSetToList(hashes map[plumbing.Hash]bool) []plumbing.Hash {
+	list := make([]plumbing.Hash, 0, len(hashes))
+	for h := range hashes {
+		list = append(list, h)
+	}
+
+	return list
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+)
+
+// startQuarantine starts the quarantine of the pushed objects, if the storer
+// supports it. The objects are only moved to the storer by
+// commitQuarantine, once some commands are accepted.
+func (s *rpSession) startQuarantine() error {
+	oq, ok := s.storer.(storer.ObjectQuarantiner)
+	if !ok {
+		return nil
+	}
+
+	q, err := oq.ObjectQuarantine()
+	if err != nil {
+		return err
+	}
+
+	s.quarantine = q
+	return nil
+}
+
+// commitQuarantine moves the pushed objects to the storer if one of the
+// accepted commands needs them, otherwise they are discarded.
+func (s *rpSession) commitQuarantine(cmds []*packp.Command) error {
+	if s.quarantine == nil {
+		return nil
+	}
+
+	for _, cmd := range cmds {
+		if cmd.Action() != packp.Delete {
+			q := s.quarantine
+			s.quarantine = nil
+			return q.Commit()
+		}
+	}
+
+	return s.abortQuarantine()
+}
+
+// abortQuarantine discards the pushed objects, if they are still in
+// quarantine.
+func (s *rpSession) abortQuarantine() error {
+	if s.quarantine == nil {
+		return nil
+	}
+
+	q := s.quarantine
+	s.quarantine = nil
+	return q.Abort()
+}
+
+// objectStorer returns the storer of the session, whose objects include the
+// ones in quarantine.
+func (s *rpSession) objectStorer() storer.Storer {
+	if s.quarantine == nil {
+		return s.storer
+	}
+
+	return &quarantinedStorer{Storer: s.storer, quarantine: s.quarantine}
+}
+
+// quarantinedStorer is a storer whose objects are the ones of a quarantine.
+type quarantinedStorer struct {
+	storer.Storer
+	quarantine storer.ObjectQuarantine
+}
+
+func (s *quarantinedStorer) NewEncodedObject() plumbing.EncodedObject {
+	return s.quarantine.NewEncodedObject()
+}
+
+func (s *quarantinedStorer) SetEncodedObject(o plumbing.EncodedObject) (plumbing.Hash, error) {
+	return s.quarantine.SetEncodedObject(o)
+}
+
+func (s *quarantinedStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	return s.quarantine.EncodedObject(t, h)
+}
+
+func (s *quarantinedStorer) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	return s.quarantine.IterEncodedObjects(t)
+}
+
+func (s *quarantinedStorer) HasEncodedObject(h plumbing.Hash) error {
+	return s.quarantine.HasEncodedObject(h)
+}
+
+func (s *quarantinedStorer) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	return s.quarantine.EncodedObjectSize(h)
+}
+
+func (s *quarantinedStorer) AddAlternate(remote string) error {
+	return s.quarantine.AddAlternate(remote)
+}
@@ -0,0 +1,97 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/server"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	. "gopkg.in/check.v1"
+)
+
+type QuarantineSuite struct {
+	fs       billy.Filesystem
+	storage  *filesystem.Storage
+	client   transport.Transport
+	endpoint *transport.Endpoint
+}
+
+var _ = Suite(&QuarantineSuite{})
+
+func (s *QuarantineSuite) SetUpTest(c *C) {
+	s.fs = osfs.New(c.MkDir())
+	s.storage = filesystem.NewStorage(s.fs, cache.NewObjectLRUDefault())
+	c.Assert(s.storage.Init(), IsNil)
+
+	var err error
+	s.endpoint, err = transport.NewEndpoint("/repo.git")
+	c.Assert(err, IsNil)
+	s.client = server.NewClient(server.MapLoader{s.endpoint.String(): s.storage})
+
+	cfg, err := s.storage.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("receive").SetOption("fsckObjects", "true")
+	c.Assert(s.storage.SetConfig(cfg), IsNil)
+}
+
+func (s *QuarantineSuite) receivePack(c *C, req *packp.ReferenceUpdateRequest) map[plumbing.ReferenceName]string {
+	r, err := s.client.NewReceivePackSession(s.endpoint, nil)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	report, _ := r.ReceivePack(context.Background(), req)
+	return statuses(report)
+}
+
+func (s *QuarantineSuite) objectFiles(c *C) []string {
+	var files []string
+	var walk func(dir string)
+	walk = func(dir string) {
+		entries, err := s.fs.ReadDir(dir)
+		c.Assert(err, IsNil)
+		for _, e := range entries {
+			name := s.fs.Join(dir, e.Name())
+			if e.IsDir() {
+				walk(name)
+			} else {
+				files = append(files, name)
+			}
+		}
+	}
+
+	walk("objects")
+	return files
+}
+
+func (s *QuarantineSuite) TestRejected(c *C) {
+	req, _ := fsckRequest(c)
+	req.Commands = req.Commands[1:]
+
+	status := s.receivePack(c, req)
+	c.Assert(status["refs/heads/bad"], Not(Equals), "ok")
+	c.Assert(status["refs/heads/disconnected"], Equals, server.ErrMissingObjects.Error())
+	c.Assert(s.objectFiles(c), HasLen, 0)
+}
+
+func (s *QuarantineSuite) TestAccepted(c *C) {
+	req, _ := fsckRequest(c)
+	good := req.Commands[0].New
+
+	status := s.receivePack(c, req)
+	c.Assert(status["refs/heads/good"], Equals, "ok")
+
+	files := s.objectFiles(c)
+	c.Assert(files, HasLen, 2)
+	for _, f := range files {
+		c.Assert(strings.HasPrefix(f, s.fs.Join("objects", "pack", "pack-")), Equals, true)
+	}
+
+	c.Assert(s.storage.HasEncodedObject(good), IsNil)
+}
@@ -1,11 +1,21 @@
 package server_test
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"strings"
 
+	"github.com/jesseduffield/go-git/v5/config"
 	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
 	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/server"
+	"github.com/jesseduffield/go-git/v5/storage"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
 	. "gopkg.in/check.v1"
@@ -62,3 +72,223 @@ func (s *ReceivePackSuite) TestReceivePackWithNilPackfile(c *C) {
 	c.Assert(report, IsNil, comment)
 	c.Assert(err, NotNil, comment)
 }
+
+func (s *ReceivePackSuite) TestReceivePackAtomic(c *C) {
+	sto := s.loader[s.Endpoint.String()]
+	master, err := sto.Reference(plumbing.Master)
+	c.Assert(err, IsNil)
+
+	branch := plumbing.NewRemoteReferenceName("origin", "branch")
+	other := plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881")
+
+	r, err := s.Client.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.Capabilities.Supports(capability.Atomic), Equals, true)
+
+	// The update of master has the wrong old hash, so no reference is
+	// updated.
+	req := packp.NewReferenceUpdateRequest()
+	c.Assert(req.Capabilities.Set(capability.Atomic), IsNil)
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/new", Old: plumbing.ZeroHash, New: master.Hash()},
+		{Name: plumbing.Master, Old: other, New: other},
+		{Name: branch, Old: other, New: plumbing.ZeroHash},
+	}
+
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, Equals, storage.ErrReferenceHasChanged)
+	c.Assert(report.CommandStatuses, HasLen, 3)
+	for _, cs := range report.CommandStatuses {
+		c.Assert(cs.Status, Equals, storage.ErrReferenceHasChanged.Error())
+	}
+
+	_, err = sto.Reference("refs/heads/new")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	ref, err := sto.Reference(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, master.Hash())
+
+	c.Assert(r.Close(), IsNil)
+	r, err = s.Client.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+
+	req.Commands[1].Old = master.Hash()
+	report, err = r.ReceivePack(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(report.Error(), IsNil)
+
+	ref, err = sto.Reference(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, other)
+
+	_, err = sto.Reference("refs/heads/new")
+	c.Assert(err, IsNil)
+	_, err = sto.Reference(branch)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func storeObject(c *C, s storer.EncodedObjectStorer, t plumbing.ObjectType, content string) plumbing.Hash {
+	o := s.NewEncodedObject()
+	o.SetType(t)
+	w, err := o.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte(content))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	h, err := s.SetEncodedObject(o)
+	c.Assert(err, IsNil)
+	return h
+}
+
+// fsckRequest returns a request creating a branch with a valid history, a
+// branch with a malicious .gitmodules and a branch whose parent is missing.
+func fsckRequest(c *C) (*packp.ReferenceUpdateRequest, plumbing.Hash) {
+	src := memory.NewStorage()
+	signature := "John Doe <john@example.com> 1700000000 +0100"
+	commit := func(tree plumbing.Hash, parents ...plumbing.Hash) plumbing.Hash {
+		content := "tree " + tree.String() + "\n"
+		for _, p := range parents {
+			content += "parent " + p.String() + "\n"
+		}
+
+		content += "author " + signature + "\ncommitter " + signature + "\n\ncommit\n"
+		return storeObject(c, src, plumbing.CommitObject, content)
+	}
+
+	blob := storeObject(c, src, plumbing.BlobObject, "a\n")
+	tree := storeObject(c, src, plumbing.TreeObject, "100644 a\x00"+string(blob[:]))
+	good := commit(tree)
+
+	gitmodules := storeObject(c, src, plumbing.BlobObject,
+		"[submodule \"a\"]\n\tpath = a\n\turl = --upload-pack=touch\n")
+	badTree := storeObject(c, src, plumbing.TreeObject, "100644 .gitmodules\x00"+string(gitmodules[:]))
+	bad := commit(badTree, good)
+
+	disconnected := commit(tree, plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+
+	var buf bytes.Buffer
+	_, err := packfile.NewEncoder(&buf, src, false).Encode(
+		[]plumbing.Hash{blob, tree, good, gitmodules, badTree, bad, disconnected}, 10)
+	c.Assert(err, IsNil)
+
+	req := packp.NewReferenceUpdateRequest()
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/good", Old: plumbing.ZeroHash, New: good},
+		{Name: "refs/heads/bad", Old: plumbing.ZeroHash, New: bad},
+		{Name: "refs/heads/disconnected", Old: plumbing.ZeroHash, New: disconnected},
+	}
+	req.Packfile = io.NopCloser(&buf)
+
+	return req, gitmodules
+}
+
+func (s *ReceivePackSuite) setReceiveConfig(c *C, sto storer.Storer, options map[string]string) {
+	cs := sto.(config.ConfigStorer)
+	cfg, err := cs.Config()
+	c.Assert(err, IsNil)
+	for key, value := range options {
+		parts := strings.Split(key, ".")
+		if len(parts) == 3 {
+			cfg.Raw.SetOption(parts[0], parts[1], parts[2], value)
+		} else {
+			cfg.Raw.Section(parts[0]).SetOption(parts[1], value)
+		}
+	}
+
+	c.Assert(cs.SetConfig(cfg), IsNil)
+}
+
+func statuses(report *packp.ReportStatus) map[plumbing.ReferenceName]string {
+	m := make(map[plumbing.ReferenceName]string)
+	for _, cs := range report.CommandStatuses {
+		m[cs.ReferenceName] = cs.Status
+	}
+
+	return m
+}
+
+func (s *ReceivePackSuite) TestReceivePackFsckObjects(c *C) {
+	sto := s.loader[s.EmptyEndpoint.String()]
+	s.setReceiveConfig(c, sto, map[string]string{"receive.fsckObjects": "true"})
+
+	r, err := s.Client.NewReceivePackSession(s.EmptyEndpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req, gitmodules := fsckRequest(c)
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, NotNil)
+	c.Assert(report.UnpackStatus, Equals, "ok")
+	c.Assert(statuses(report), DeepEquals, map[plumbing.ReferenceName]string{
+		"refs/heads/good": "ok",
+		"refs/heads/bad": "error in blob " + gitmodules.String() +
+			": gitmodulesUrl: disallowed submodule url: --upload-pack=touch",
+		"refs/heads/disconnected": server.ErrMissingObjects.Error(),
+	})
+
+	_, err = sto.Reference("refs/heads/good")
+	c.Assert(err, IsNil)
+	_, err = sto.Reference("refs/heads/bad")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+	_, err = sto.Reference("refs/heads/disconnected")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *ReceivePackSuite) TestReceivePackFsckObjectsAtomic(c *C) {
+	sto := s.loader[s.EmptyEndpoint.String()]
+	s.setReceiveConfig(c, sto, map[string]string{"transfer.fsckObjects": "true"})
+
+	r, err := s.Client.NewReceivePackSession(s.EmptyEndpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req, _ := fsckRequest(c)
+	c.Assert(req.Capabilities.Set(capability.Atomic), IsNil)
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, NotNil)
+	c.Assert(statuses(report)["refs/heads/good"], Equals, server.ErrAtomicPushFailure.Error())
+
+	_, err = sto.Reference("refs/heads/good")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *ReceivePackSuite) TestReceivePackFsckSeverities(c *C) {
+	sto := s.loader[s.EmptyEndpoint.String()]
+	s.setReceiveConfig(c, sto, map[string]string{
+		"receive.fsckObjects":        "true",
+		"receive.fsck.gitmodulesurl": "ignore",
+	})
+
+	r, err := s.Client.NewReceivePackSession(s.EmptyEndpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req, _ := fsckRequest(c)
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, Equals, server.ErrMissingObjects)
+	c.Assert(statuses(report)["refs/heads/bad"], Equals, "ok")
+}
+
+func (s *ReceivePackSuite) TestReceivePackNoFsckObjects(c *C) {
+	sto := s.loader[s.EmptyEndpoint.String()]
+
+	r, err := s.Client.NewReceivePackSession(s.EmptyEndpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req, _ := fsckRequest(c)
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(report.Error(), IsNil)
+
+	_, err = sto.Reference("refs/heads/disconnected")
+	c.Assert(err, IsNil)
+}
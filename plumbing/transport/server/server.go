@@ -8,11 +8,13 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/jesseduffield/go-git/v5/config"
 	"github.com/jesseduffield/go-git/v5/plumbing"
 	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
-	"github.com/jesseduffield/go-git/v5/plumbing/revlist"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/jesseduffield/go-git/v5/plumbing/storer"
 	"github.com/jesseduffield/go-git/v5/plumbing/transport"
 	"github.com/jesseduffield/go-git/v5/utils/ioutil"
@@ -58,7 +60,15 @@ func (s *server) NewReceivePackSession(ep *transport.Endpoint, auth transport.Au
 		return nil, err
 	}
 
-	return s.handler.NewReceivePackSession(sto)
+	var hook ReceiveHook
+	if hl, ok := s.loader.(HookLoader); ok {
+		hook, err = hl.LoadHook(ep)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.handler.NewReceivePackSession(sto, hook)
 }
 
 type handler struct {
@@ -71,9 +81,10 @@ func (h *handler) NewUploadPackSession(s storer.Storer) (transport.UploadPackSes
 	}, nil
 }
 
-func (h *handler) NewReceivePackSession(s storer.Storer) (transport.ReceivePackSession, error) {
+func (h *handler) NewReceivePackSession(s storer.Storer, hook ReceiveHook) (transport.ReceivePackSession, error) {
 	return &rpSession{
 		session:   session{storer: s, asClient: h.asClient},
+		hook:      hook,
 		cmdStatus: map[plumbing.ReferenceName]error{},
 	}, nil
 }
@@ -140,7 +151,7 @@ func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest
 		return nil, transport.ErrEmptyUploadPackRequest
 	}
 
-	if err := req.Validate(); err != nil {
+	if err := validateRequest(&req.UploadRequest); err != nil {
 		return nil, err
 	}
 
@@ -157,35 +168,279 @@ func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest
 
 	s.caps = req.Capabilities
 
-	if len(req.Shallows) > 0 {
-		return nil, fmt.Errorf("shallow not supported")
+	if err := s.checkWants(req.Wants); err != nil {
+		return nil, err
 	}
 
-	objs, err := s.objectsToUpload(req)
+	objs, update, err := s.objectsToUpload(req)
 	if err != nil {
 		return nil, err
 	}
 
-	pr, pw := io.Pipe()
-	e := packfile.NewEncoder(pw, s.storer, false)
-	go func() {
-		// TODO: plumb through a pack window.
-		_, err := e.Encode(objs, 10)
-		pw.CloseWithError(err)
-	}()
+	var t sideband.Type
+	if s.caps.Supports(capability.Sideband64k) {
+		t = sideband.Sideband64k
+	} else if s.caps.Supports(capability.Sideband) {
+		t = sideband.Sideband
+	}
 
-	return packp.NewUploadPackResponseWithPackfile(req,
-		ioutil.NewContextReadCloser(ctx, pr),
-	), nil
+	pf := s.packfile(ctx, objs, !s.caps.Supports(capability.OFSDelta), t, true)
+	res := packp.NewUploadPackResponseWithPackfile(req, pf)
+	res.ShallowUpdate = *update
+	return res, nil
 }
 
-func (s *upSession) objectsToUpload(req *packp.UploadPackRequest) ([]plumbing.Hash, error) {
-	haves, err := revlist.Objects(s.storer, req.Haves, nil)
+// objectsToUpload returns the objects reachable from the wants and not from
+// the haves, as selected by the shallow and filter requests, and the changes
+// of the shallow commits of the client.
+func (s *upSession) objectsToUpload(req *packp.UploadPackRequest) ([]plumbing.Hash, *packp.ShallowUpdate, error) {
+	objs, update, err := s.objects(newObjectsRequest(&req.UploadRequest, req.Haves))
 	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.caps.Supports(capability.IncludeTag) {
+		if objs, err = s.includeTags(objs); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return objs, update, nil
+}
+
+// ShallowUpdate returns the changes of the shallow commits of the client,
+// requested by the deepen lines of req.
+func (s *upSession) ShallowUpdate(ctx context.Context, req *packp.UploadRequest) (*packp.ShallowUpdate, error) {
+	if err := validateRequest(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkWants(req.Wants); err != nil {
+		return nil, err
+	}
+
+	update := &packp.ShallowUpdate{}
+	if req.Depth == nil || req.Depth.IsZero() {
+		return update, nil
+	}
+
+	oreq := newObjectsRequest(req, nil)
+	if _, _, err := s.deepen(oreq, s.clientShallows(oreq.Shallows), update); err != nil {
 		return nil, err
 	}
 
-	return revlist.Objects(s.storer, req.Wants, haves)
+	return update, nil
+}
+
+// Negotiate returns the haves which the server has. They are enough to send
+// the packfile once every wanted commit has an ancestor in common with the
+// client.
+func (s *upSession) Negotiate(
+	ctx context.Context, req *packp.UploadRequest, common, haves []plumbing.Hash,
+) (found []plumbing.Hash, ready bool, err error) {
+	for _, h := range haves {
+		if err := s.storer.HasEncodedObject(h); err == nil {
+			found = append(found, h)
+		}
+	}
+
+	all := append(append([]plumbing.Hash(nil), common...), found...)
+	ready, err = s.readyToSend(req.Wants, all)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return found, ready, nil
+}
+
+// validateRequest validates an upload request. Git clients send shallow and
+// deepen lines without requesting the shallow capability, which is implied.
+func validateRequest(req *packp.UploadRequest) error {
+	shallow := len(req.Shallows) > 0 || (req.Depth != nil && !req.Depth.IsZero())
+	if shallow && !req.Capabilities.Supports(capability.Shallow) {
+		if err := req.Capabilities.Set(capability.Shallow); err != nil {
+			return err
+		}
+	}
+
+	return req.Validate()
+}
+
+// checkWants checks that the objects wanted by the client may be sent, like
+// git upload-pack with allow-reachable-sha1-in-want: the commits must be
+// reachable from the advertised references, the other objects must be
+// references or peeled tags. Any object may be wanted if the config of the
+// storer sets uploadpack.allowAnySHA1InWant, as the lazy fetches of the
+// partial clones need.
+func (s *upSession) checkWants(wants []plumbing.Hash) error {
+	tips, err := s.referenceTips()
+	if err != nil {
+		return err
+	}
+
+	isTip := make(map[plumbing.Hash]bool, len(tips))
+	for _, h := range tips {
+		isTip[h] = true
+	}
+
+	var others []plumbing.Hash
+	for _, h := range wants {
+		if !isTip[h] {
+			others = append(others, h)
+		}
+	}
+
+	if len(others) == 0 {
+		return nil
+	}
+
+	allowAny, err := allowAnyWant(s.storer)
+	if err != nil {
+		return err
+	}
+
+	commits := make(map[plumbing.Hash]bool)
+	for _, h := range others {
+		obj, err := s.storer.EncodedObject(plumbing.AnyObject, h)
+		if err == plumbing.ErrObjectNotFound {
+			return fmt.Errorf("not our ref %s", h)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if allowAny {
+			continue
+		}
+
+		if obj.Type() != plumbing.CommitObject {
+			return fmt.Errorf("not our ref %s", h)
+		}
+
+		commits[h] = true
+	}
+
+	if len(commits) == 0 {
+		return nil
+	}
+
+	if err := s.walkReachableCommits(tips, commits); err != nil {
+		return err
+	}
+
+	for h := range commits {
+		return fmt.Errorf("not our ref %s", h)
+	}
+
+	return nil
+}
+
+// walkReachableCommits walks the commits reachable from the tips, removing
+// them from wanted, until it is empty. The walk stops at the shallow commits
+// and at the missing ones.
+func (s *upSession) walkReachableCommits(tips []plumbing.Hash, wanted map[plumbing.Hash]bool) error {
+	shallow := make(map[plumbing.Hash]bool)
+	if ss, ok := s.storer.(storer.ShallowStorer); ok {
+		shallows, err := ss.Shallow()
+		if err != nil {
+			return err
+		}
+
+		for _, h := range shallows {
+			shallow[h] = true
+		}
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+	pending := append([]plumbing.Hash(nil), tips...)
+	for len(pending) > 0 && len(wanted) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[h] {
+			continue
+		}
+
+		seen[h] = true
+		delete(wanted, h)
+		if shallow[h] {
+			continue
+		}
+
+		c, err := object.GetCommit(s.storer, h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		pending = append(pending, c.ParentHashes...)
+	}
+
+	return nil
+}
+
+// allowAnyWant returns whether the config of the storer sets
+// uploadpack.allowAnySHA1InWant.
+func allowAnyWant(s storer.Storer) (bool, error) {
+	cs, ok := s.(config.ConfigStorer)
+	if !ok {
+		return false, nil
+	}
+
+	cfg, err := cs.Config()
+	if err != nil {
+		return false, err
+	}
+
+	return cfg.Raw.Section("uploadpack").Option("allowAnySHA1InWant") == "true", nil
+}
+
+// referenceTips returns the objects of the advertised references, and the
+// objects their annotated tags peel to.
+func (s *upSession) referenceTips() ([]plumbing.Hash, error) {
+	iter, err := s.storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []plumbing.Hash
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := storer.ResolveReference(s.storer, plumbing.HEAD)
+	switch err {
+	case nil:
+		tips = append(tips, head.Hash())
+	case plumbing.ErrReferenceNotFound:
+	default:
+		return nil, err
+	}
+
+	for i := 0; i < len(tips); i++ {
+		tag, err := object.GetTag(s.storer, tips[i])
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		tips = append(tips, tag.Target)
+	}
+
+	return tips, nil
 }
 
 func (*upSession) setSupportedCapabilities(c *capability.List) error {
@@ -193,8 +448,24 @@ func (*upSession) setSupportedCapabilities(c *capability.List) error {
 		return err
 	}
 
-	if err := c.Set(capability.OFSDelta); err != nil {
-		return err
+	for _, name := range []capability.Capability{
+		capability.OFSDelta,
+		capability.MultiACKDetailed,
+		capability.NoDone,
+		capability.Sideband64k,
+		capability.NoProgress,
+		capability.ThinPack,
+		capability.IncludeTag,
+		capability.Shallow,
+		capability.DeepenSince,
+		capability.DeepenNot,
+		capability.DeepenRelative,
+		capability.Filter,
+		capability.AllowReachableSHA1InWant,
+	} {
+		if err := c.Set(name); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -202,9 +473,12 @@ func (*upSession) setSupportedCapabilities(c *capability.List) error {
 
 type rpSession struct {
 	session
-	cmdStatus map[plumbing.ReferenceName]error
-	firstErr  error
-	unpackErr error
+	hook ReceiveHook
+	// quarantine holds the pushed objects until the commands are accepted.
+	quarantine storer.ObjectQuarantine
+	cmdStatus  map[plumbing.ReferenceName]error
+	firstErr   error
+	unpackErr  error
 }
 
 func (s *rpSession) AdvertisedReferences() (*packp.AdvRefs, error) {
@@ -233,6 +507,12 @@ func (s *rpSession) AdvertisedReferencesContext(ctx context.Context) (*packp.Adv
 
 var (
 	ErrUpdateReference = errors.New("failed to update ref")
+	// ErrMissingObjects is reported for the commands whose pushed history
+	// is not connected to the objects of the repository.
+	ErrMissingObjects = errors.New("missing necessary objects")
+	// ErrAtomicPushFailure is reported for the commands of an atomic push
+	// which are not applied because other commands were rejected.
+	ErrAtomicPushFailure = errors.New("atomic push failure")
 )
 
 func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
@@ -249,9 +529,14 @@ func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateR
 
 	s.caps = req.Capabilities
 
-	//TODO: Implement 'atomic' update of references.
+	// The clients do not send a packfile if every command is a delete.
+	if req.Packfile != nil && !deleteOnly(req.Commands) {
+		if err := s.startQuarantine(); err != nil {
+			return nil, err
+		}
+
+		defer func() { _ = s.abortQuarantine() }()
 
-	if req.Packfile != nil {
 		r := ioutil.NewContextReadCloser(ctx, req.Packfile)
 		if err := s.writePackfile(r); err != nil {
 			s.unpackErr = err
@@ -260,12 +545,87 @@ func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateR
 		}
 	}
 
-	s.updateReferences(req)
+	cmds, err := s.checkObjects(req.Commands)
+	if err != nil {
+		for _, cmd := range req.Commands {
+			s.setStatus(cmd.Name, err)
+		}
+
+		return s.reportStatus(), s.firstErr
+	}
+
+	atomic := s.caps.Supports(capability.Atomic)
+	if !atomic || len(cmds) == len(req.Commands) {
+		cmds = s.receiveHooks(ctx, req, cmds)
+	}
+
+	if atomic && len(cmds) < len(req.Commands) {
+		for _, cmd := range cmds {
+			s.setStatus(cmd.Name, ErrAtomicPushFailure)
+		}
+
+		return s.reportStatus(), s.firstErr
+	}
+
+	if err := s.commitQuarantine(cmds); err != nil {
+		for _, cmd := range cmds {
+			s.setStatus(cmd.Name, err)
+		}
+
+		return s.reportStatus(), s.firstErr
+	}
+
+	if atomic {
+		s.updateReferencesAtomic(cmds)
+	} else {
+		s.updateReferences(cmds)
+	}
+
+	s.postReceiveHook(ctx, req, cmds)
 	return s.reportStatus(), s.firstErr
 }
 
-func (s *rpSession) updateReferences(req *packp.ReferenceUpdateRequest) {
-	for _, cmd := range req.Commands {
+// updateReferencesAtomic updates the references in a single transaction,
+// checking that they have the old values of the commands. Either all the
+// references are updated or none of them, with the error of the transaction
+// reported for every command.
+func (s *rpSession) updateReferencesAtomic(cmds []*packp.Command) {
+	err := s.commitReferences(cmds)
+	for _, cmd := range cmds {
+		s.setStatus(cmd.Name, err)
+	}
+}
+
+func (s *rpSession) commitReferences(cmds []*packp.Command) error {
+	rt, ok := s.storer.(storer.ReferenceTransactioner)
+	if !ok {
+		return fmt.Errorf("unsupported capability: %s", capability.Atomic)
+	}
+
+	t, err := rt.ReferenceTransaction()
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range cmds {
+		old := plumbing.NewHashReference(cmd.Name, cmd.Old)
+		if cmd.Action() == packp.Delete {
+			err = t.RemoveReference(cmd.Name, old)
+		} else {
+			err = t.SetReference(plumbing.NewHashReference(cmd.Name, cmd.New), old)
+		}
+
+		if err != nil {
+			_ = t.Abort()
+			return err
+		}
+	}
+
+	return t.Commit()
+}
+
+func (s *rpSession) updateReferences(cmds []*packp.Command) {
+	for _, cmd := range cmds {
 		exists, err := referenceExists(s.storer, cmd.Name)
 		if err != nil {
 			s.setStatus(cmd.Name, err)
@@ -303,12 +663,27 @@ func (s *rpSession) updateReferences(req *packp.ReferenceUpdateRequest) {
 	}
 }
 
+func deleteOnly(cmds []*packp.Command) bool {
+	for _, cmd := range cmds {
+		if cmd.Action() != packp.Delete {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (s *rpSession) writePackfile(r io.ReadCloser) error {
 	if r == nil {
 		return nil
 	}
 
-	if err := packfile.UpdateObjectStorage(s.storer, r); err != nil {
+	var objects storer.EncodedObjectStorer = s.storer
+	if s.quarantine != nil {
+		objects = s.quarantine
+	}
+
+	if err := packfile.UpdateObjectStorage(objects, r); err != nil {
 		_ = r.Close()
 		return err
 	}
@@ -354,7 +729,7 @@ func (s *rpSession) reportStatus() *packp.ReportStatus {
 	return rs
 }
 
-func (*rpSession) setSupportedCapabilities(c *capability.List) error {
+func (s *rpSession) setSupportedCapabilities(c *capability.List) error {
 	if err := c.Set(capability.Agent, capability.DefaultAgent()); err != nil {
 		return err
 	}
@@ -367,6 +742,16 @@ func (*rpSession) setSupportedCapabilities(c *capability.List) error {
 		return err
 	}
 
+	if err := c.Set(capability.Sideband64k); err != nil {
+		return err
+	}
+
+	if _, ok := s.storer.(storer.ReferenceTransactioner); ok {
+		if err := c.Set(capability.Atomic); err != nil {
+			return err
+		}
+	}
+
 	return c.Set(capability.ReportStatus)
 }
 
@@ -1,8 +1,21 @@
 package server_test
 
 import (
+	"context"
+
+	"github.com/jesseduffield/go-git/v5/config"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/server"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
 
+	fixtures "github.com/go-git/go-git-fixtures/v4"
 	. "gopkg.in/check.v1"
 )
 
@@ -48,3 +61,238 @@ func (s *ClientLikeUploadPackSuite) SetUpSuite(c *C) {
 func (s *ClientLikeUploadPackSuite) TestAdvertisedReferencesEmpty(c *C) {
 	s.UploadPackSuite.TestAdvertisedReferencesEmpty(c)
 }
+
+type UploadPackObjectsSuite struct {
+	fixtures.Suite
+	loader server.MapLoader
+}
+
+var _ = Suite(&UploadPackObjectsSuite{})
+
+func (s *UploadPackObjectsSuite) SetUpTest(c *C) {
+	s.loader = server.MapLoader{}
+}
+
+func (s *UploadPackObjectsSuite) newSession(c *C, f *fixtures.Fixture) transport.UploadPackSession {
+	fs := f.DotGit()
+	ep, err := transport.NewEndpoint(fs.Root())
+	c.Assert(err, IsNil)
+	s.loader[ep.String()] = filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+
+	sess, err := server.NewServer(s.loader).NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+	return sess
+}
+
+// uploadPack runs the upload-pack request, multiplexed with
+// sideband.Sideband64k, and stores the objects of its packfile in a new
+// storage.
+func (s *UploadPackObjectsSuite) uploadPack(c *C, f *fixtures.Fixture, req *packp.UploadPackRequest) (*packp.UploadPackResponse, *memory.Storage) {
+	sess := s.newSession(c, f)
+	_, err := sess.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	c.Assert(req.Capabilities.Set(capability.Sideband64k), IsNil)
+	c.Assert(req.Capabilities.Set(capability.OFSDelta), IsNil)
+	res, err := sess.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	st := memory.NewStorage()
+	d := sideband.NewDemuxer(sideband.Sideband64k, res)
+	c.Assert(packfile.UpdateObjectStorage(st, d), IsNil)
+	c.Assert(res.Close(), IsNil)
+	return res, st
+}
+
+func (s *UploadPackObjectsSuite) TestAdvertisedCapabilities(c *C) {
+	ar, err := s.newSession(c, fixtures.Basic().One()).AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	for _, name := range []capability.Capability{
+		capability.Filter,
+		capability.Shallow,
+		capability.DeepenSince,
+		capability.DeepenNot,
+		capability.MultiACKDetailed,
+		capability.NoDone,
+		capability.Sideband64k,
+		capability.ThinPack,
+		capability.IncludeTag,
+	} {
+		c.Assert(ar.Capabilities.Supports(name), Equals, true, Commentf("%s", name))
+	}
+}
+
+func (s *UploadPackObjectsSuite) TestUploadPackFilter(c *C) {
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+	req.Filter = packp.FilterBlobNone()
+	c.Assert(req.Capabilities.Set(capability.Filter), IsNil)
+
+	_, st := s.uploadPack(c, fixtures.Basic().One(), req)
+	c.Assert(countObjects(c, st, plumbing.CommitObject), Equals, 8)
+	c.Assert(countObjects(c, st, plumbing.TreeObject), Not(Equals), 0)
+	c.Assert(countObjects(c, st, plumbing.BlobObject), Equals, 0)
+}
+
+func (s *UploadPackObjectsSuite) TestUploadPackShallow(c *C) {
+	// Git clients do not request the shallow capability.
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+	req.Depth = packp.DepthCommits(1)
+
+	res, st := s.uploadPack(c, fixtures.Basic().One(), req)
+	c.Assert(res.Shallows, DeepEquals, []plumbing.Hash{masterHash})
+	c.Assert(countObjects(c, st, plumbing.CommitObject), Equals, 1)
+}
+
+func (s *UploadPackObjectsSuite) TestUploadPackHaves(c *C) {
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+	req.Haves = []plumbing.Hash{commonHash}
+
+	_, st := s.uploadPack(c, fixtures.Basic().One(), req)
+	c.Assert(countObjects(c, st, plumbing.CommitObject), Equals, 7)
+	c.Assert(st.HasEncodedObject(commonHash), NotNil)
+}
+
+func (s *UploadPackObjectsSuite) TestUploadPackIncludeTag(c *C) {
+	commit := plumbing.NewHash("f7b877701fbf855b44c0a9e86f3fdce2c298b07f")
+	tags := []plumbing.Hash{
+		plumbing.NewHash("b742a2a9fa0afcfa9a6fad080980fbc26b007c69"),
+		plumbing.NewHash("ad7897c0fb8e7d9a9ba41fa66072cf06095a6cfc"),
+	}
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{commit}
+	_, st := s.uploadPack(c, fixtures.ByTag("tags").One(), req)
+	for _, h := range tags {
+		c.Assert(st.HasEncodedObject(h), Equals, plumbing.ErrObjectNotFound)
+	}
+
+	req = packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{commit}
+	c.Assert(req.Capabilities.Set(capability.IncludeTag), IsNil)
+	_, st = s.uploadPack(c, fixtures.ByTag("tags").One(), req)
+	for _, h := range tags {
+		c.Assert(st.HasEncodedObject(h), IsNil)
+	}
+}
+
+func (s *UploadPackObjectsSuite) TestUploadPackNotOurRef(c *C) {
+	sess := s.newSession(c, fixtures.Basic().One())
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{plumbing.NewHash("0000000000000000000000000000000000000001")}
+
+	_, err := sess.UploadPack(context.Background(), req)
+	c.Assert(err, ErrorMatches, "not our ref.*")
+}
+
+func (s *UploadPackObjectsSuite) TestUploadPackUnreachable(c *C) {
+	sess := s.newSession(c, fixtures.Basic().One())
+	for _, sto := range s.loader {
+		obj := sto.NewEncodedObject()
+		obj.SetType(plumbing.BlobObject)
+		w, err := obj.Writer()
+		c.Assert(err, IsNil)
+		_, err = w.Write([]byte("unreachable"))
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+
+		h, err := sto.SetEncodedObject(obj)
+		c.Assert(err, IsNil)
+
+		req := packp.NewUploadPackRequest()
+		req.Wants = []plumbing.Hash{h}
+		_, err = sess.UploadPack(context.Background(), req)
+		c.Assert(err, ErrorMatches, "not our ref.*")
+	}
+
+	// An object reachable from a reference may be wanted.
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{commonHash}
+	_, st := s.uploadPack(c, fixtures.Basic().One(), req)
+
+	_, err := st.EncodedObject(plumbing.CommitObject, commonHash)
+	c.Assert(err, IsNil)
+}
+
+func (s *UploadPackObjectsSuite) TestUploadPackBlob(c *C) {
+	blob := plumbing.NewHash("d3ff53e0564a9f87d8e84b6e28e5060e517008aa")
+	sess := s.newSession(c, fixtures.Basic().One())
+	_, err := sess.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	// Like git, only the commits are checked for reachability, the other
+	// objects must be references.
+	req := packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{blob}
+	_, err = sess.UploadPack(context.Background(), req)
+	c.Assert(err, ErrorMatches, "not our ref.*")
+
+	// The lazy fetches of a partial clone want any object, with a filter.
+	for url, sto := range s.loader {
+		cfg, err := sto.(config.ConfigStorer).Config()
+		c.Assert(err, IsNil)
+		cfg.Raw.Section("uploadpack").SetOption("allowAnySHA1InWant", "true")
+		c.Assert(sto.(config.ConfigStorer).SetConfig(cfg), IsNil)
+
+		ep, err := transport.NewEndpoint(url)
+		c.Assert(err, IsNil)
+		sess, err = server.NewServer(s.loader).NewUploadPackSession(ep, nil)
+		c.Assert(err, IsNil)
+		_, err = sess.AdvertisedReferences()
+		c.Assert(err, IsNil)
+	}
+
+	req = packp.NewUploadPackRequest()
+	req.Wants = []plumbing.Hash{blob}
+	req.Filter = packp.FilterBlobNone()
+	c.Assert(req.Capabilities.Set(capability.Filter), IsNil)
+	c.Assert(req.Capabilities.Set(capability.Sideband64k), IsNil)
+	res, err := sess.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	st := memory.NewStorage()
+	c.Assert(packfile.UpdateObjectStorage(st, sideband.NewDemuxer(sideband.Sideband64k, res)), IsNil)
+	c.Assert(res.Close(), IsNil)
+
+	_, err = st.EncodedObject(plumbing.BlobObject, blob)
+	c.Assert(err, IsNil)
+	c.Assert(countObjects(c, st, plumbing.BlobObject), Equals, 1)
+}
+
+func (s *UploadPackObjectsSuite) TestNegotiate(c *C) {
+	n, ok := s.newSession(c, fixtures.Basic().One()).(transport.UploadPackNegotiator)
+	c.Assert(ok, Equals, true)
+
+	req := packp.NewUploadRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+
+	unknown := plumbing.NewHash("0000000000000000000000000000000000000001")
+	found, ready, err := n.Negotiate(context.Background(), req, nil, []plumbing.Hash{unknown})
+	c.Assert(err, IsNil)
+	c.Assert(found, HasLen, 0)
+	c.Assert(ready, Equals, false)
+
+	found, ready, err = n.Negotiate(context.Background(), req, nil, []plumbing.Hash{unknown, commonHash})
+	c.Assert(err, IsNil)
+	c.Assert(found, DeepEquals, []plumbing.Hash{commonHash})
+	c.Assert(ready, Equals, true)
+}
+
+func (s *UploadPackObjectsSuite) TestShallowUpdate(c *C) {
+	n, ok := s.newSession(c, fixtures.Basic().One()).(transport.UploadPackNegotiator)
+	c.Assert(ok, Equals, true)
+
+	req := packp.NewUploadRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+	update, err := n.ShallowUpdate(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(update.Shallows, HasLen, 0)
+
+	req.Depth = packp.DepthCommits(1)
+	update, err = n.ShallowUpdate(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(update.Shallows, DeepEquals, []plumbing.Hash{masterHash})
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/pktline"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// CapabilityAdvertisement returns the capabilities of the server for the
+// version 2 of the protocol.
+func (s *upSession) CapabilityAdvertisement(ctx context.Context) (*packp.CapabilityAdvertisement, error) {
+	adv := packp.NewCapabilityAdvertisement()
+	for _, c := range []struct {
+		name   capability.Capability
+		values []string
+	}{
+		{capability.Agent, []string{capability.DefaultAgent()}},
+		{capability.LsRefs, []string{capability.Unborn.String()}},
+		{capability.Fetch, []string{strings.Join([]string{
+			capability.Shallow.String(),
+			capability.WaitForDone.String(),
+			capability.Filter.String(),
+		}, " ")}},
+		{capability.ServerOption, nil},
+		{capability.ObjectFormat, []string{"sha1"}},
+		{capability.ObjectInfo, nil},
+	} {
+		if err := adv.Capabilities.Add(c.name, c.values...); err != nil {
+			return nil, err
+		}
+	}
+
+	return adv, nil
+}
+
+// LsRefs lists the references starting with one of the prefixes of the
+// request, HEAD first.
+func (s *upSession) LsRefs(ctx context.Context, req *packp.LsRefsRequest) (*packp.LsRefsResponse, error) {
+	iter, err := s.storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() != plumbing.HEAD {
+			refs = append(refs, ref)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name() < refs[j].Name() })
+	head, err := s.storer.Reference(plumbing.HEAD)
+	if err == nil {
+		refs = append([]*plumbing.Reference{head}, refs...)
+	} else if err != plumbing.ErrReferenceNotFound {
+		return nil, err
+	}
+
+	res := packp.NewLsRefsResponse()
+	for _, ref := range refs {
+		if !hasRefPrefix(ref.Name(), req.RefPrefixes) {
+			continue
+		}
+
+		if err := s.addLsRef(res, req, ref); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (s *upSession) addLsRef(res *packp.LsRefsResponse, req *packp.LsRefsRequest, ref *plumbing.Reference) error {
+	resolved, err := storer.ResolveReference(s.storer, ref.Name())
+	switch {
+	case err == plumbing.ErrReferenceNotFound && ref.Type() == plumbing.SymbolicReference:
+		// Only HEAD is listed when its target does not exist.
+		if ref.Name() == plumbing.HEAD && req.Unborn && req.Symrefs {
+			res.Symrefs = append(res.Symrefs, ref)
+		}
+
+		return nil
+	case err != nil:
+		return err
+	}
+
+	res.References = append(res.References, plumbing.NewHashReference(ref.Name(), resolved.Hash()))
+	if ref.Type() == plumbing.SymbolicReference && req.Symrefs {
+		res.Symrefs = append(res.Symrefs, ref)
+	}
+
+	if !req.Peel {
+		return nil
+	}
+
+	tag, err := object.GetTag(s.storer, resolved.Hash())
+	if err == plumbing.ErrObjectNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	target, err := peelTag(s.storer, tag)
+	if err != nil {
+		return err
+	}
+
+	res.Peeled[ref.Name().String()] = target
+	return nil
+}
+
+func hasRefPrefix(name plumbing.ReferenceName, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name.String(), prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peelTag returns the object pointed by an annotated tag, which is not a tag.
+func peelTag(s storer.EncodedObjectStorer, tag *object.Tag) (plumbing.Hash, error) {
+	for tag.TargetType == plumbing.TagObject {
+		var err error
+		if tag, err = object.GetTag(s, tag.Target); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	return tag.Target, nil
+}
+
+// Fetch acknowledges the common objects of the client until the negotiation
+// is done, then sends the packfile, multiplexed with sideband.Sideband64k.
+func (s *upSession) Fetch(ctx context.Context, req *packp.FetchRequest) (*packp.FetchResponse, error) {
+	if len(req.WantRefs) > 0 {
+		return nil, fmt.Errorf("want-ref is not supported")
+	}
+
+	if err := s.checkWants(req.Wants); err != nil {
+		return nil, err
+	}
+
+	var common []plumbing.Hash
+	for _, h := range req.Haves {
+		if err := s.storer.HasEncodedObject(h); err == nil {
+			common = append(common, h)
+		}
+	}
+
+	res := packp.NewFetchResponse()
+	if !req.Done && len(req.Haves) > 0 {
+		res.ACKs = common
+		if req.WaitForDone {
+			return res, nil
+		}
+
+		ready, err := s.readyToSend(req.Wants, common)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ready {
+			return res, nil
+		}
+
+		res.Ready = true
+	}
+
+	objs, update, err := s.objects(&objectsRequest{
+		Wants:          req.Wants,
+		Haves:          common,
+		Shallows:       req.Shallows,
+		Depth:          req.Depth,
+		DeepenRelative: req.DeepenRelative,
+		Filter:         req.Filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if req.IncludeTag {
+		if objs, err = s.includeTags(objs); err != nil {
+			return nil, err
+		}
+	}
+
+	res.ShallowUpdate = *update
+	res.Packfile = s.packfile(ctx, objs, !req.OFSDelta, sideband.Sideband64k, false)
+	return res, nil
+}
+
+// readyToSend returns true if every wanted commit has an ancestor in common
+// with the client.
+func (s *upSession) readyToSend(wants, common []plumbing.Hash) (bool, error) {
+	if len(common) == 0 {
+		return false, nil
+	}
+
+	isCommon := make(map[plumbing.Hash]bool, len(common))
+	for _, h := range common {
+		isCommon[h] = true
+	}
+
+	commits, err := s.peelCommits(wants)
+	if err != nil {
+		return false, err
+	}
+
+	for _, h := range commits {
+		c, err := object.GetCommit(s.storer, h)
+		if err != nil {
+			return false, err
+		}
+
+		found := false
+		err = object.NewCommitPreorderIter(c, nil, nil).ForEach(func(c *object.Commit) error {
+			if isCommon[c.Hash] {
+				found = true
+				return storer.ErrStop
+			}
+
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+
+		if !found {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// includeTags adds to objs the annotated tags pointing to one of them.
+func (s *upSession) includeTags(objs []plumbing.Hash) ([]plumbing.Hash, error) {
+	sent := make(map[plumbing.Hash]bool, len(objs))
+	for _, h := range objs {
+		sent[h] = true
+	}
+
+	iter, err := s.storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsTag() || ref.Type() != plumbing.HashReference || sent[ref.Hash()] {
+			return nil
+		}
+
+		var tags []plumbing.Hash
+		target := ref.Hash()
+		for {
+			tag, err := object.GetTag(s.storer, target)
+			if err == plumbing.ErrObjectNotFound {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			tags = append(tags, tag.Hash)
+			target = tag.Target
+			if tag.TargetType != plumbing.TagObject {
+				break
+			}
+		}
+
+		if !sent[target] {
+			return nil
+		}
+
+		for _, h := range tags {
+			if !sent[h] {
+				sent[h] = true
+				objs = append(objs, h)
+			}
+		}
+
+		return nil
+	})
+
+	return objs, err
+}
+
+// ObjectInfo returns the information about the objects of the request.
+func (s *upSession) ObjectInfo(ctx context.Context, req *packp.ObjectInfoRequest) (*packp.ObjectInfoResponse, error) {
+	res := &packp.ObjectInfoResponse{Size: req.Size}
+	for _, h := range req.Hashes {
+		info := packp.ObjectInfo{Hash: h}
+		if req.Size {
+			size, err := s.storer.EncodedObjectSize(h)
+			if err != nil {
+				return nil, fmt.Errorf("object %s: %w", h, err)
+			}
+
+			info.Size = size
+		}
+
+		res.Objects = append(res.Objects, info)
+	}
+
+	return res, nil
+}
+
+// packfile returns the packfile of the given objects, which is multiplexed if
+// t is not zero. The multiplexed packfile ends with a flush-pkt if flush is
+// true, as in the version 0 of the protocol.
+func (s *upSession) packfile(ctx context.Context, objs []plumbing.Hash, useRefDeltas bool, t sideband.Type, flush bool) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	var w io.Writer = pw
+	if t != 0 {
+		w = sideband.NewMuxer(t, pw)
+	}
+
+	e := packfile.NewEncoder(w, s.storer, useRefDeltas)
+	go func() {
+		// TODO: plumb through a pack window.
+		_, err := e.Encode(objs, 10)
+		if err == nil && t != 0 && flush {
+			err = pktline.NewEncoder(pw).Flush()
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	return ioutil.NewContextReadCloser(ctx, pr)
+}
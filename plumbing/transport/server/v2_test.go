@@ -0,0 +1,263 @@
+package server_test
+
+import (
+	"context"
+	"io"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/server"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type UploadPackV2Suite struct {
+	fixtures.Suite
+	loader server.MapLoader
+}
+
+var _ = Suite(&UploadPackV2Suite{})
+
+var (
+	masterHash = plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	commonHash = plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+)
+
+func (s *UploadPackV2Suite) SetUpTest(c *C) {
+	s.loader = server.MapLoader{}
+}
+
+func (s *UploadPackV2Suite) newSession(c *C, f *fixtures.Fixture) transport.UploadPackV2Session {
+	fs := f.DotGit()
+	ep, err := transport.NewEndpoint(fs.Root())
+	c.Assert(err, IsNil)
+	s.loader[ep.String()] = filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+
+	sess, err := server.NewServer(s.loader).NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+
+	v2, ok := sess.(transport.UploadPackV2Session)
+	c.Assert(ok, Equals, true)
+	return v2
+}
+
+// fetch runs the fetch command, and stores the objects of its packfile in a
+// new storage.
+func (s *UploadPackV2Suite) fetch(c *C, req *packp.FetchRequest) (*packp.FetchResponse, *memory.Storage) {
+	sess := s.newSession(c, fixtures.Basic().One())
+	res, err := sess.Fetch(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	st := memory.NewStorage()
+	if res.Packfile == nil {
+		return res, st
+	}
+
+	d := sideband.NewDemuxer(sideband.Sideband64k, res.Packfile)
+	c.Assert(packfile.UpdateObjectStorage(st, d), IsNil)
+	c.Assert(res.Packfile.(io.Closer).Close(), IsNil)
+	return res, st
+}
+
+func countObjects(c *C, st storer.EncodedObjectStorer, t plumbing.ObjectType) int {
+	iter, err := st.IterEncodedObjects(t)
+	c.Assert(err, IsNil)
+
+	n := 0
+	c.Assert(iter.ForEach(func(plumbing.EncodedObject) error {
+		n++
+		return nil
+	}), IsNil)
+
+	return n
+}
+
+func (s *UploadPackV2Suite) TestCapabilityAdvertisement(c *C) {
+	sess := s.newSession(c, fixtures.Basic().One())
+	adv, err := sess.CapabilityAdvertisement(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(adv.Supports(capability.LsRefs), Equals, true)
+	c.Assert(adv.Supports(capability.ObjectInfo), Equals, true)
+	c.Assert(adv.SupportsFeature(capability.Fetch, capability.Shallow), Equals, true)
+	c.Assert(adv.SupportsFeature(capability.Fetch, capability.Filter), Equals, true)
+}
+
+func (s *UploadPackV2Suite) TestLsRefs(c *C) {
+	sess := s.newSession(c, fixtures.Basic().One())
+
+	req := packp.NewLsRefsRequest()
+	req.Symrefs = true
+	req.RefPrefixes = []string{"HEAD", "refs/heads/"}
+	res, err := sess.LsRefs(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	c.Assert(res.References, DeepEquals, []*plumbing.Reference{
+		plumbing.NewHashReference(plumbing.HEAD, masterHash),
+		plumbing.NewHashReference("refs/heads/branch", plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881")),
+		plumbing.NewHashReference("refs/heads/master", masterHash),
+	})
+	c.Assert(res.Symrefs, DeepEquals, []*plumbing.Reference{
+		plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/master"),
+	})
+}
+
+func (s *UploadPackV2Suite) TestLsRefsPeel(c *C) {
+	f := fixtures.ByTag("tags").One()
+	sess := s.newSession(c, f)
+
+	req := packp.NewLsRefsRequest()
+	req.Peel = true
+	req.RefPrefixes = []string{"refs/tags/"}
+	res, err := sess.LsRefs(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(res.References, Not(HasLen), 0)
+
+	st := filesystem.NewStorage(f.DotGit(), cache.NewObjectLRUDefault())
+	for _, ref := range res.References {
+		peeled, ok := res.Peeled[ref.Name().String()]
+		_, err := object.GetTag(st, ref.Hash())
+		if err == plumbing.ErrObjectNotFound {
+			c.Assert(ok, Equals, false)
+			continue
+		}
+
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, true)
+
+		o, err := st.EncodedObject(plumbing.AnyObject, peeled)
+		c.Assert(err, IsNil)
+		c.Assert(o.Type(), Not(Equals), plumbing.TagObject)
+	}
+}
+
+func (s *UploadPackV2Suite) TestFetch(c *C) {
+	req := packp.NewFetchRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+	req.Done = true
+
+	res, st := s.fetch(c, req)
+	c.Assert(res.ACKs, HasLen, 0)
+	c.Assert(res.Shallows, HasLen, 0)
+	c.Assert(countObjects(c, st, plumbing.CommitObject), Equals, 8)
+	c.Assert(countObjects(c, st, plumbing.BlobObject), Not(Equals), 0)
+}
+
+func (s *UploadPackV2Suite) TestFetchNegotiation(c *C) {
+	req := packp.NewFetchRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+	req.Haves = []plumbing.Hash{plumbing.NewHash("0000000000000000000000000000000000000001")}
+
+	res, st := s.fetch(c, req)
+	c.Assert(res.ACKs, HasLen, 0)
+	c.Assert(res.Ready, Equals, false)
+	c.Assert(res.Packfile, IsNil)
+
+	req.Haves = append(req.Haves, commonHash)
+	res, st = s.fetch(c, req)
+	c.Assert(res.ACKs, DeepEquals, []plumbing.Hash{commonHash})
+	c.Assert(res.Ready, Equals, true)
+	c.Assert(countObjects(c, st, plumbing.CommitObject), Equals, 7)
+	c.Assert(st.HasEncodedObject(commonHash), NotNil)
+}
+
+func (s *UploadPackV2Suite) TestFetchWaitForDone(c *C) {
+	req := packp.NewFetchRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+	req.Haves = []plumbing.Hash{commonHash}
+	req.WaitForDone = true
+
+	res, _ := s.fetch(c, req)
+	c.Assert(res.ACKs, DeepEquals, []plumbing.Hash{commonHash})
+	c.Assert(res.Ready, Equals, false)
+	c.Assert(res.Packfile, IsNil)
+}
+
+func (s *UploadPackV2Suite) TestFetchShallow(c *C) {
+	req := packp.NewFetchRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+	req.Depth = packp.DepthCommits(1)
+	req.Done = true
+
+	res, st := s.fetch(c, req)
+	c.Assert(res.Shallows, DeepEquals, []plumbing.Hash{masterHash})
+	c.Assert(countObjects(c, st, plumbing.CommitObject), Equals, 1)
+
+	commit, err := object.GetCommit(st, masterHash)
+	c.Assert(err, IsNil)
+
+	req.Shallows = res.Shallows
+	req.Depth = packp.DepthCommits(2)
+	req.DeepenRelative = true
+	req.Haves = []plumbing.Hash{masterHash}
+
+	res, st = s.fetch(c, req)
+	c.Assert(res.Unshallows, DeepEquals, []plumbing.Hash{masterHash})
+	c.Assert(res.Shallows, HasLen, len(commit.ParentHashes))
+	for _, h := range commit.ParentHashes {
+		c.Assert(st.HasEncodedObject(h), IsNil)
+	}
+}
+
+func (s *UploadPackV2Suite) TestFetchFilter(c *C) {
+	req := packp.NewFetchRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+	req.Filter = packp.FilterBlobNone()
+	req.Done = true
+
+	_, st := s.fetch(c, req)
+	c.Assert(countObjects(c, st, plumbing.CommitObject), Equals, 8)
+	c.Assert(countObjects(c, st, plumbing.TreeObject), Not(Equals), 0)
+	c.Assert(countObjects(c, st, plumbing.BlobObject), Equals, 0)
+
+	req.Filter = packp.FilterTreeDepth(0)
+	_, st = s.fetch(c, req)
+	c.Assert(countObjects(c, st, plumbing.CommitObject), Equals, 8)
+	c.Assert(countObjects(c, st, plumbing.TreeObject), Equals, 0)
+}
+
+func (s *UploadPackV2Suite) TestFetchUnsupportedFilter(c *C) {
+	sess := s.newSession(c, fixtures.Basic().One())
+
+	req := packp.NewFetchRequest()
+	req.Wants = []plumbing.Hash{masterHash}
+	req.Filter = "sparse:oid=master:.sparse"
+	req.Done = true
+
+	_, err := sess.Fetch(context.Background(), req)
+	c.Assert(err, ErrorMatches, ".*sparse:oid.*")
+}
+
+func (s *UploadPackV2Suite) TestFetchNotOurRef(c *C) {
+	sess := s.newSession(c, fixtures.Basic().One())
+
+	req := packp.NewFetchRequest()
+	req.Wants = []plumbing.Hash{plumbing.NewHash("0000000000000000000000000000000000000001")}
+	req.Done = true
+
+	_, err := sess.Fetch(context.Background(), req)
+	c.Assert(err, ErrorMatches, "not our ref.*")
+}
+
+func (s *UploadPackV2Suite) TestObjectInfo(c *C) {
+	sess := s.newSession(c, fixtures.Basic().One())
+
+	req := packp.NewObjectInfoRequest()
+	req.Size = true
+	req.Hashes = []plumbing.Hash{plumbing.NewHash("d3ff53e0564a9f87d8e84b6e28e5060e517008aa")}
+
+	res, err := sess.ObjectInfo(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(res.Objects, DeepEquals, []packp.ObjectInfo{
+		{Hash: req.Hashes[0], Size: 18},
+	})
+}
@@ -62,12 +62,13 @@ func (r *runner) Command(cmd string, ep *transport.Endpoint, auth transport.Auth
 
 type command struct {
 	*ssh.Session
-	connected bool
-	command   string
-	endpoint  *transport.Endpoint
-	client    *ssh.Client
-	auth      AuthMethod
-	config    *ssh.ClientConfig
+	connected   bool
+	command     string
+	endpoint    *transport.Endpoint
+	client      *ssh.Client
+	auth        AuthMethod
+	config      *ssh.ClientConfig
+	gitProtocol string
 }
 
 func (c *command) setAuth(auth transport.AuthMethod) error {
@@ -80,7 +81,18 @@ func (c *command) setAuth(auth transport.AuthMethod) error {
 	return nil
 }
 
+// SetGitProtocol sets the GIT_PROTOCOL environment variable of the session.
+func (c *command) SetGitProtocol(value string) {
+	c.gitProtocol = value
+}
+
 func (c *command) Start() error {
+	if c.gitProtocol != "" {
+		// The servers which do not accept the variable speak the version 0
+		// of the protocol.
+		_ = c.Session.Setenv("GIT_PROTOCOL", c.gitProtocol)
+	}
+
 	return c.Session.Start(endpointToCommand(c.command, c.endpoint))
 }
 
@@ -3,6 +3,7 @@ package test
 import (
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"runtime"
 	"strings"
@@ -18,6 +19,8 @@ func HandlerSSH(s ssh.Session) {
 		return
 	}
 
+	// The environment sent by the client, like GIT_PROTOCOL.
+	cmd.Env = append(os.Environ(), s.Environ()...)
 	if err := cmd.Start(); err != nil {
 		fmt.Println(err)
 		return
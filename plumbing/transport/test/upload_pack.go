@@ -12,6 +12,7 @@ import (
 	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/jesseduffield/go-git/v5/plumbing/transport"
 	"github.com/jesseduffield/go-git/v5/storage/memory"
 
@@ -252,6 +253,105 @@ func (s *UploadPackSuite) TestFetchError(c *C) {
 	//     different errors if a previous error was found.
 }
 
+// v2Session returns a session with the server of the endpoint, skipping the
+// test if the server does not speak the version 2 of the protocol.
+func (s *UploadPackSuite) v2Session(c *C) (transport.UploadPackV2Session, *packp.CapabilityAdvertisement) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+
+	v2, ok := r.(transport.UploadPackV2Session)
+	if !ok {
+		c.Assert(r.Close(), IsNil)
+		c.Skip("protocol v2 is not supported by the client")
+	}
+
+	adv, err := v2.CapabilityAdvertisement(context.Background())
+	if err == transport.ErrProtocolV2Unsupported {
+		c.Assert(r.Close(), IsNil)
+		c.Skip("protocol v2 is not supported by the server")
+	}
+
+	c.Assert(err, IsNil)
+	c.Assert(adv.Supports(capability.LsRefs), Equals, true)
+	c.Assert(adv.Supports(capability.Fetch), Equals, true)
+	return v2, adv
+}
+
+func (s *UploadPackSuite) TestLsRefs(c *C) {
+	r, _ := s.v2Session(c)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req := packp.NewLsRefsRequest()
+	req.Symrefs = true
+	req.RefPrefixes = []string{"HEAD", "refs/heads/"}
+	res, err := r.LsRefs(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, ref := range res.References {
+		names = append(names, ref.Name().String())
+	}
+
+	c.Assert(names, DeepEquals, []string{
+		"HEAD", "refs/heads/branch", "refs/heads/master",
+	})
+	c.Assert(res.Symrefs, DeepEquals, []*plumbing.Reference{
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master),
+	})
+}
+
+func (s *UploadPackSuite) TestFetchWaitForDone(c *C) {
+	r, adv := s.v2Session(c)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	if !adv.SupportsFeature(capability.Fetch, capability.WaitForDone) {
+		c.Skip("wait-for-done is not supported by the server")
+	}
+
+	req := packp.NewFetchRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	req.Haves = append(req.Haves, plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"))
+	req.OFSDelta = true
+	req.NoProgress = true
+	req.WaitForDone = true
+
+	res, err := r.Fetch(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(res.ACKs, DeepEquals, req.Haves)
+	c.Assert(res.Packfile, IsNil)
+
+	req.Done = true
+	res, err = r.Fetch(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(res.Packfile, NotNil)
+
+	s.checkObjectNumber(c, sideband.NewDemuxer(sideband.Sideband64k, res.Packfile), 4)
+}
+
+func (s *UploadPackSuite) TestObjectInfo(c *C) {
+	r, adv := s.v2Session(c)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	if !adv.Supports(capability.ObjectInfo) {
+		c.Skip("object-info is not supported by the server")
+	}
+
+	req := packp.NewObjectInfoRequest()
+	req.Size = true
+	req.Hashes = []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		plumbing.NewHash("d5c0f4ab811897cadf03aec358ae60d21f91c50d"),
+	}
+
+	res, err := r.ObjectInfo(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(res.Size, Equals, true)
+	c.Assert(res.Objects, DeepEquals, []packp.ObjectInfo{
+		{Hash: req.Hashes[0], Size: 245},
+		{Hash: req.Hashes[1], Size: 76110},
+	})
+}
+
 func (s *UploadPackSuite) checkObjectNumber(c *C, r io.Reader, n int) {
 	b, err := io.ReadAll(r)
 	c.Assert(err, IsNil)
@@ -0,0 +1,215 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jesseduffield/go-git/v5/config"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+	"github.com/jesseduffield/go-git/v5/utils/merkletrie"
+)
+
+// promisorStorer is the storer of the objects decoded by a repository. The
+// objects missing from a partial clone are fetched from the promisor remotes
+// when they are accessed.
+type promisorStorer struct {
+	storer.EncodedObjectStorer
+	r *Repository
+}
+
+func (s *promisorStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.EncodedObjectStorer.EncodedObject(t, h)
+	if err != plumbing.ErrObjectNotFound {
+		return obj, err
+	}
+
+	if err := s.fetchMissing(h); err != nil {
+		return nil, err
+	}
+
+	return s.EncodedObjectStorer.EncodedObject(t, h)
+}
+
+func (s *promisorStorer) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := s.EncodedObjectStorer.EncodedObjectSize(h)
+	if err != plumbing.ErrObjectNotFound {
+		return size, err
+	}
+
+	if err := s.fetchMissing(h); err != nil {
+		return 0, err
+	}
+
+	return s.EncodedObjectStorer.EncodedObjectSize(h)
+}
+
+func (s *promisorStorer) fetchMissing(h plumbing.Hash) error {
+	remotes, err := s.r.promisorRemotes()
+	if err != nil || len(remotes) == 0 {
+		return err
+	}
+
+	// The object may exist with another type than the requested one.
+	if s.EncodedObjectStorer.HasEncodedObject(h) == nil {
+		return nil
+	}
+
+	return s.r.fetchPromisedObjects(context.Background(), remotes, []plumbing.Hash{h})
+}
+
+// objectStorer returns the storer decoding the objects of the repository,
+// which fetches the objects missing from a partial clone.
+func (r *Repository) objectStorer() storer.EncodedObjectStorer {
+	return &promisorStorer{EncodedObjectStorer: r.Storer, r: r}
+}
+
+// promisorRemotes returns the promisor remotes of the repository, sorted by
+// name. There are none if the repository is not a partial clone.
+func (r *Repository) promisorRemotes() ([]*config.RemoteConfig, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var remotes []*config.RemoteConfig
+	for _, c := range cfg.Remotes {
+		if c.Promisor {
+			remotes = append(remotes, c)
+		}
+	}
+
+	sort.Slice(remotes, func(i, j int) bool {
+		return remotes[i].Name < remotes[j].Name
+	})
+
+	return remotes, nil
+}
+
+// fetchPromisedObjects fetches the given objects from the promisor remotes,
+// trying them in turn. The auth method of the last fetch from each remote is
+// used.
+func (r *Repository) fetchPromisedObjects(ctx context.Context, remotes []*config.RemoteConfig, hashes []plumbing.Hash) error {
+	var err error
+	for _, c := range remotes {
+		if err = NewRemote(r.Storer, c).fetchObjects(ctx, hashes, r.promisorAuth[c.Name]); err == nil {
+			return nil
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("fetching missing objects from promisor remote: %w", err)
+	}
+
+	return nil
+}
+
+// setPromisorAuth records the auth method of a fetch from the given remote,
+// to fetch the objects missing from a partial clone from it later.
+func (r *Repository) setPromisorAuth(remote string, auth transport.AuthMethod) {
+	if auth == nil {
+		return
+	}
+
+	if r.promisorAuth == nil {
+		r.promisorAuth = make(map[string]transport.AuthMethod)
+	}
+
+	r.promisorAuth[remote] = auth
+}
+
+// fetchObjects fetches the given objects without updating the references,
+// like the objects missing from a partial clone. The server must allow any
+// object to be wanted. The blobs referenced by the fetched trees are omitted if
+// it supports filters.
+func (r *Remote) fetchObjects(ctx context.Context, hashes []plumbing.Hash, auth transport.AuthMethod) (err error) {
+	s, err := newUploadPackSession(r.c.URLs[0], auth, false, nil, transport.ProxyOptions{})
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(s, &err)
+
+	ar, err := advertisedReferences(ctx, s, []string{plumbing.HEAD.String()})
+	if err != nil {
+		return err
+	}
+
+	if !ar.Capabilities.Supports(capability.AllowReachableSHA1InWant) &&
+		!ar.Capabilities.Supports(capability.AllowTipSHA1InWant) {
+		return ErrExactSHA1NotSupported
+	}
+
+	o := &FetchOptions{RemoteName: r.c.Name, Auth: auth}
+	if ar.Capabilities.Supports(capability.Filter) {
+		o.Filter = packp.FilterBlobNone()
+	}
+
+	req, err := r.newUploadPackRequest(o, ar)
+	if err != nil {
+		return err
+	}
+
+	req.Wants = hashes
+	return r.fetchPack(ctx, o, s, req)
+}
+
+// setPromisor records the remote as a promisor remote, after fetching a
+// packfile with the given filter.
+func (r *Remote) setPromisor(filter packp.Filter) error {
+	cfg, err := r.s.Config()
+	if err != nil {
+		return err
+	}
+
+	r.c.Promisor = true
+	r.c.PartialCloneFilter = string(filter)
+
+	// The objects of an anonymous remote can't be fetched later.
+	c, ok := cfg.Remotes[r.c.Name]
+	if !ok {
+		return nil
+	}
+
+	c.Promisor = true
+	c.PartialCloneFilter = string(filter)
+	return r.s.SetConfig(cfg)
+}
+
+// prefetchBlobs fetches at once the blobs missing from a partial clone which
+// are checked out by the changes, instead of fetching them one by one.
+func (w *Worktree) prefetchBlobs(t *object.Tree, changes []merkletrie.Change) error {
+	remotes, err := w.r.promisorRemotes()
+	if err != nil || len(remotes) == 0 {
+		return err
+	}
+
+	var missing []plumbing.Hash
+	for _, ch := range changes {
+		if ch.To == nil {
+			continue
+		}
+
+		e, err := t.FindEntry(ch.To.String())
+		if err != nil {
+			return err
+		}
+
+		if e.Mode != filemode.Submodule && w.r.Storer.HasEncodedObject(e.Hash) != nil {
+			missing = append(missing, e.Hash)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return w.r.fetchPromisedObjects(context.Background(), remotes, missing)
+}
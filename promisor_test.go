@@ -0,0 +1,238 @@
+package git
+
+import (
+	"github.com/jesseduffield/go-git/v5/config"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/client"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/http"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/server"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type PromisorSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&PromisorSuite{})
+
+// partialCloneURL returns the URL of a copy of the basic fixture, which
+// allows filters in upload-pack.
+func (s *BaseSuite) partialCloneURL(c *C) string {
+	url := s.GetLocalRepositoryURL(fixtures.Basic().One())
+
+	r, err := PlainOpen(url)
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("uploadpack").SetOption("allowFilter", "true")
+	c.Assert(r.Storer.SetConfig(cfg), IsNil)
+
+	return url
+}
+
+func (s *PromisorSuite) countObjects(c *C, r *Repository, t plumbing.ObjectType) int {
+	iter, err := r.Storer.IterEncodedObjects(t)
+	c.Assert(err, IsNil)
+
+	n := 0
+	c.Assert(iter.ForEach(func(plumbing.EncodedObject) error {
+		n++
+		return nil
+	}), IsNil)
+
+	return n
+}
+
+func (s *PromisorSuite) TestClone(c *C) {
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:    s.partialCloneURL(c),
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Remotes["origin"].Promisor, Equals, true)
+	c.Assert(cfg.Remotes["origin"].PartialCloneFilter, Equals, "blob:none")
+	c.Assert(s.countObjects(c, r, plumbing.CommitObject), Equals, 9)
+	c.Assert(s.countObjects(c, r, plumbing.BlobObject), Equals, 0)
+
+	commit, err := r.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+	tree, err := commit.Tree()
+	c.Assert(err, IsNil)
+	e, err := tree.FindEntry("CHANGELOG")
+	c.Assert(err, IsNil)
+	c.Assert(r.Storer.HasEncodedObject(e.Hash), Equals, plumbing.ErrObjectNotFound)
+
+	blob, err := r.BlobObject(e.Hash)
+	c.Assert(err, IsNil)
+	c.Assert(blob.Size, Equals, int64(18))
+	c.Assert(r.Storer.HasEncodedObject(e.Hash), IsNil)
+	c.Assert(s.countObjects(c, r, plumbing.BlobObject), Equals, 1)
+}
+
+func (s *PromisorSuite) TestCloneCheckout(c *C) {
+	fs := memfs.New()
+	r, err := Clone(memory.NewStorage(), fs, &CloneOptions{
+		URL:    s.partialCloneURL(c),
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, IsNil)
+
+	fi, err := fs.ReadDir("")
+	c.Assert(err, IsNil)
+	c.Assert(fi, HasLen, 8)
+
+	content, err := util.ReadFile(fs, "CHANGELOG")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "Initial changelog\n")
+
+	status, err := mustWorktree(c, r).Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *PromisorSuite) TestCloneTreeDepth(c *C) {
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:    s.partialCloneURL(c),
+		Filter: packp.FilterTreeDepth(0),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.countObjects(c, r, plumbing.TreeObject), Equals, 0)
+
+	commit, err := r.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+
+	f, err := commit.File("CHANGELOG")
+	c.Assert(err, IsNil)
+
+	content, err := f.Contents()
+	c.Assert(err, IsNil)
+	c.Assert(content, Equals, "Initial changelog\n")
+}
+
+func (s *PromisorSuite) TestCloneFilterNotSupported(c *C) {
+	_, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:    s.GetBasicLocalRepositoryURL(),
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, Equals, ErrFilterNotSupported)
+}
+
+func (s *PromisorSuite) TestFetch(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{s.partialCloneURL(c)},
+	})
+	c.Assert(err, IsNil)
+
+	err = r.Fetch(&FetchOptions{Filter: packp.FilterBlobLimit(20, packp.BlobLimitPrefixNone)})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Remotes["origin"].Promisor, Equals, true)
+	c.Assert(cfg.Remotes["origin"].PartialCloneFilter, Equals, "blob:limit=20")
+
+	// The small blobs are fetched.
+	blob, err := r.BlobObject(plumbing.NewHash("d3ff53e0564a9f87d8e84b6e28e5060e517008aa"))
+	c.Assert(err, IsNil)
+	c.Assert(blob.Size, Equals, int64(18))
+}
+
+func (s *PromisorSuite) TestMissingObjectWithoutPromisor(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	_, err = r.BlobObject(plumbing.NewHash("d3ff53e0564a9f87d8e84b6e28e5060e517008aa"))
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+func mustWorktree(c *C, r *Repository) *Worktree {
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	return w
+}
+
+// authTransport records the auth methods of the upload-pack sessions.
+type authTransport struct {
+	transport.Transport
+	auths []transport.AuthMethod
+}
+
+func (t *authTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	t.auths = append(t.auths, auth)
+	return t.Transport.NewUploadPackSession(ep, auth)
+}
+
+func (s *PromisorSuite) TestCloneAuth(c *C) {
+	url := s.partialCloneURL(c)
+
+	backup := client.Protocols["file"]
+	t := &authTransport{Transport: backup}
+	client.InstallProtocol("file", t)
+	defer client.InstallProtocol("file", backup)
+
+	auth := &http.BasicAuth{Username: "user", Password: "pass"}
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:    url,
+		Auth:   auth,
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(t.auths, HasLen, 1)
+
+	_, err = r.BlobObject(plumbing.NewHash("d3ff53e0564a9f87d8e84b6e28e5060e517008aa"))
+	c.Assert(err, IsNil)
+	c.Assert(t.auths, DeepEquals, []transport.AuthMethod{auth, auth})
+}
+
+func (s *PromisorSuite) TestServerLazyFetch(c *C) {
+	url := s.partialCloneURL(c)
+	sr, err := PlainOpen(url)
+	c.Assert(err, IsNil)
+	cfg, err := sr.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("uploadpack").SetOption("allowAnySHA1InWant", "true")
+	c.Assert(sr.Storer.SetConfig(cfg), IsNil)
+
+	backup := client.Protocols["file"]
+	client.InstallProtocol("file", server.NewServer(server.DefaultLoader))
+	defer client.InstallProtocol("file", backup)
+
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:    url,
+		Filter: packp.FilterBlobNone(),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.countObjects(c, r, plumbing.BlobObject), Equals, 0)
+
+	// A single blob is fetched from the go-git server.
+	blob, err := r.BlobObject(plumbing.NewHash("d3ff53e0564a9f87d8e84b6e28e5060e517008aa"))
+	c.Assert(err, IsNil)
+	c.Assert(blob.Size, Equals, int64(18))
+	c.Assert(s.countObjects(c, r, plumbing.BlobObject), Equals, 1)
+}
+
+func (s *PromisorSuite) TestNoPromisor(c *C) {
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	// The missing objects are not fetched without a promisor remote.
+	_, err = r.BlobObject(plumbing.NewHash("0000000000000000000000000000000000000001"))
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
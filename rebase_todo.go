@@ -0,0 +1,364 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+)
+
+// ErrInvalidRebaseTodo is returned when a rebase todo list can not be parsed
+// or executed.
+var ErrInvalidRebaseTodo = errors.New("invalid rebase todo")
+
+// RebaseAction is the action applied by a rebase to an entry of its todo list.
+type RebaseAction int
+
+const (
+	// RebasePick applies the commit.
+	RebasePick RebaseAction = iota
+	// RebaseReword applies the commit and edits its message.
+	RebaseReword
+	// RebaseSquash melds the commit into the previous one, combining both
+	// messages.
+	RebaseSquash
+	// RebaseFixup melds the commit into the previous one, keeping the
+	// message of the previous one.
+	RebaseFixup
+	// RebaseDrop skips the commit.
+	RebaseDrop
+	// RebaseExec calls RebaseOptions.Exec with the entry command.
+	RebaseExec
+)
+
+var rebaseActions = map[string]RebaseAction{
+	"pick":   RebasePick,
+	"p":      RebasePick,
+	"reword": RebaseReword,
+	"r":      RebaseReword,
+	"squash": RebaseSquash,
+	"s":      RebaseSquash,
+	"fixup":  RebaseFixup,
+	"f":      RebaseFixup,
+	"drop":   RebaseDrop,
+	"d":      RebaseDrop,
+	"exec":   RebaseExec,
+	"x":      RebaseExec,
+}
+
+// String returns the keyword used for the action in git todo lists.
+func (a RebaseAction) String() string {
+	switch a {
+	case RebasePick:
+		return "pick"
+	case RebaseReword:
+		return "reword"
+	case RebaseSquash:
+		return "squash"
+	case RebaseFixup:
+		return "fixup"
+	case RebaseDrop:
+		return "drop"
+	case RebaseExec:
+		return "exec"
+	}
+
+	return fmt.Sprintf("RebaseAction(%d)", int(a))
+}
+
+// RebaseTodo is an entry of the todo list of a rebase.
+type RebaseTodo struct {
+	Action RebaseAction
+	// Commit is the commit the action applies to. It is unused by RebaseExec.
+	Commit plumbing.Hash
+	// Command is the command of a RebaseExec entry.
+	Command string
+}
+
+// String returns the entry in the format of git todo lists.
+func (t RebaseTodo) String() string {
+	if t.Action == RebaseExec {
+		return fmt.Sprintf("%s %s", t.Action, t.Command)
+	}
+
+	return fmt.Sprintf("%s %s", t.Action, t.Commit)
+}
+
+const (
+	rebaseMergeDir      = "rebase-merge"
+	rebaseHeadNameFile  = "head-name"
+	rebaseOntoFile      = "onto"
+	rebaseOrigHeadFile  = "orig-head"
+	rebaseTodoFile      = "git-rebase-todo"
+	rebaseDoneFile      = "done"
+	rebaseMsgNumFile    = "msgnum"
+	rebaseEndFile       = "end"
+	rebaseInteractive   = "interactive"
+	rebaseMessageFile   = "message"
+	rebaseAuthorFile    = "author-script"
+	rebaseStoppedFile   = "stopped-sha"
+	rebaseDetachedHEAD  = "detached HEAD"
+	rebaseCommentPrefix = "#"
+)
+
+// rebaseState is the state of a rebase in progress, stored in the
+// rebase-merge directory like git does, so a rebase can be continued or
+// aborted by either go-git or git.
+type rebaseState struct {
+	fs billy.Filesystem
+
+	// headName is the branch being rebased, empty for a detached HEAD.
+	headName plumbing.ReferenceName
+	onto     plumbing.Hash
+	origHead plumbing.Hash
+	todo     []RebaseTodo
+	done     []RebaseTodo
+}
+
+// stateFilesystem returns the filesystem holding the state of operations in
+// progress. It is the .git directory for filesystem based storers.
+func (r *Repository) stateFilesystem() billy.Filesystem {
+	if s, ok := r.Storer.(interface{ Filesystem() billy.Filesystem }); ok {
+		return s.Filesystem()
+	}
+
+	if r.state == nil {
+		r.state = memfs.New()
+	}
+
+	return r.state
+}
+
+// inProgress returns true if the state directory exists.
+func (s *rebaseState) inProgress() (bool, error) {
+	_, err := s.fs.Stat(rebaseMergeDir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (s *rebaseState) load(r *Repository) error {
+	headName, err := s.read(rebaseHeadNameFile)
+	if err != nil {
+		return err
+	}
+
+	if headName != rebaseDetachedHEAD {
+		s.headName = plumbing.ReferenceName(headName)
+	}
+
+	for name, h := range map[string]*plumbing.Hash{
+		rebaseOntoFile:     &s.onto,
+		rebaseOrigHeadFile: &s.origHead,
+	} {
+		content, err := s.read(name)
+		if err != nil {
+			return err
+		}
+
+		if *h, err = resolveTodoHash(r, content); err != nil {
+			return err
+		}
+	}
+
+	if s.todo, err = s.readTodo(r, rebaseTodoFile); err != nil {
+		return err
+	}
+
+	s.done, err = s.readTodo(r, rebaseDoneFile)
+	return err
+}
+
+func (s *rebaseState) save(r *Repository) error {
+	headName := rebaseDetachedHEAD
+	if s.headName != "" {
+		headName = s.headName.String()
+	}
+
+	todo, err := formatTodo(r, s.todo)
+	if err != nil {
+		return err
+	}
+
+	done, err := formatTodo(r, s.done)
+	if err != nil {
+		return err
+	}
+
+	for name, content := range map[string]string{
+		rebaseHeadNameFile: headName + "\n",
+		rebaseOntoFile:     s.onto.String() + "\n",
+		rebaseOrigHeadFile: s.origHead.String() + "\n",
+		rebaseTodoFile:     todo,
+		rebaseDoneFile:     done,
+		rebaseMsgNumFile:   strconv.Itoa(len(s.done)) + "\n",
+		rebaseEndFile:      strconv.Itoa(len(s.done)+len(s.todo)) + "\n",
+		rebaseInteractive:  "",
+	} {
+		if err := s.write(name, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveStopped records the commit a rebase stopped on, with its message and
+// author, which git uses to commit the resolved conflicts.
+func (s *rebaseState) saveStopped(c *object.Commit) error {
+	author := fmt.Sprintf("GIT_AUTHOR_NAME=%s\nGIT_AUTHOR_EMAIL=%s\nGIT_AUTHOR_DATE=%s\n",
+		shellQuote(c.Author.Name),
+		shellQuote(c.Author.Email),
+		shellQuote(fmt.Sprintf("@%d %s", c.Author.When.Unix(), c.Author.When.Format("-0700"))),
+	)
+
+	for name, content := range map[string]string{
+		rebaseMessageFile: c.Message,
+		rebaseAuthorFile:  author,
+		rebaseStoppedFile: c.Hash.String() + "\n",
+	} {
+		if err := s.write(name, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clearStopped removes the files written by saveStopped.
+func (s *rebaseState) clearStopped() error {
+	for _, name := range []string{rebaseMessageFile, rebaseAuthorFile, rebaseStoppedFile} {
+		err := s.fs.Remove(path.Join(rebaseMergeDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *rebaseState) remove() error {
+	return util.RemoveAll(s.fs, rebaseMergeDir)
+}
+
+func (s *rebaseState) read(name string) (string, error) {
+	b, err := util.ReadFile(s.fs, path.Join(rebaseMergeDir, name))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (s *rebaseState) write(name, content string) error {
+	return util.WriteFile(s.fs, path.Join(rebaseMergeDir, name), []byte(content), 0644)
+}
+
+func (s *rebaseState) readTodo(r *Repository, name string) ([]RebaseTodo, error) {
+	f, err := s.fs.Open(path.Join(rebaseMergeDir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var todo []RebaseTodo
+	scn := bufio.NewScanner(f)
+	for scn.Scan() {
+		t, ok, err := parseTodoLine(r, scn.Text())
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			todo = append(todo, t)
+		}
+	}
+
+	return todo, scn.Err()
+}
+
+// parseTodoLine parses a line of a git todo list. It returns false for empty
+// lines, comments and no-ops.
+func parseTodoLine(r *Repository, line string) (RebaseTodo, bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, rebaseCommentPrefix) || line == "noop" {
+		return RebaseTodo{}, false, nil
+	}
+
+	keyword, rest, _ := strings.Cut(line, " ")
+	action, ok := rebaseActions[keyword]
+	if !ok {
+		return RebaseTodo{}, false, fmt.Errorf("%w: %s", ErrInvalidRebaseTodo, line)
+	}
+
+	rest = strings.TrimSpace(rest)
+	if action == RebaseExec {
+		return RebaseTodo{Action: action, Command: rest}, true, nil
+	}
+
+	id, _, _ := strings.Cut(rest, " ")
+	h, err := resolveTodoHash(r, id)
+	if err != nil {
+		return RebaseTodo{}, false, fmt.Errorf("%w: %s", ErrInvalidRebaseTodo, line)
+	}
+
+	return RebaseTodo{Action: action, Commit: h}, true, nil
+}
+
+// resolveTodoHash resolves a, possibly abbreviated, hash of a todo list.
+func resolveTodoHash(r *Repository, id string) (plumbing.Hash, error) {
+	if plumbing.IsHash(id) {
+		return plumbing.NewHash(id), nil
+	}
+
+	h, err := r.ResolveRevision(plumbing.Revision(id))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return *h, nil
+}
+
+// formatTodo formats a todo list like git does, with the subject of each
+// commit following its hash.
+func formatTodo(r *Repository, todo []RebaseTodo) (string, error) {
+	var b strings.Builder
+	for _, t := range todo {
+		b.WriteString(t.String())
+		if t.Action != RebaseExec {
+			c, err := r.CommitObject(t.Commit)
+			if err != nil {
+				return "", err
+			}
+
+			if subject := commitSubject(c); subject != "" {
+				b.WriteString(" " + subject)
+			}
+		}
+
+		b.WriteString("\n")
+	}
+
+	return b.String(), nil
+}
+
+// shellQuote quotes s for a POSIX shell, as expected in author-script.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
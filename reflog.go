@@ -0,0 +1,219 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/config"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/storage"
+)
+
+// ErrReflogNotSupported is returned when the storer of the repository does
+// not implement storer.ReflogStorer.
+var ErrReflogNotSupported = errors.New("reflog not supported by the storer")
+
+// Reflog returns the reflog of the given reference, the latest entry first,
+// so the entry n is the value of name@{n}. A reference without reflog has no
+// entries.
+func (r *Repository) Reflog(name plumbing.ReferenceName) ([]*reflog.Entry, error) {
+	rs, err := r.reflogStorer()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := rs.Reflog(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	return entries, nil
+}
+
+func (r *Repository) reflogStorer() (storer.ReflogStorer, error) {
+	rs, ok := r.Storer.(storer.ReflogStorer)
+	if !ok {
+		return nil, ErrReflogNotSupported
+	}
+
+	return rs, nil
+}
+
+// refLogger updates references, recording the updates in their reflogs like
+// git does: the reflogs of HEAD, the branches, the remote branches and the
+// notes are written, unless the repository is bare. The reflogs are not
+// written if the storer does not implement storer.ReflogStorer.
+type refLogger struct {
+	s storage.Storer
+	// committer is recorded in the entries, with the current time if its
+	// When is zero. When nil it is loaded from the config of the repository,
+	// the global and system configs are not read on each update.
+	committer *object.Signature
+
+	loaded  bool
+	enabled bool
+}
+
+func newRefLogger(s storage.Storer, committer *object.Signature) *refLogger {
+	return &refLogger{s: s, committer: committer}
+}
+
+// setReference sets ref, checking that the stored reference is old if old is
+// not nil, and records the update with the given message. Nothing is recorded
+// when the message is empty.
+func (l *refLogger) setReference(ref, old *plumbing.Reference, msg string) error {
+	if msg == "" {
+		return l.set(ref, old)
+	}
+
+	ok, err := l.load()
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return l.set(ref, old)
+	}
+
+	prev, err := l.resolve(ref.Name())
+	if err != nil {
+		return err
+	}
+
+	if err := l.set(ref, old); err != nil {
+		return err
+	}
+
+	next, err := l.resolve(ref.Name())
+	if err != nil {
+		return err
+	}
+
+	names := []plumbing.ReferenceName{ref.Name()}
+	if ref.Name() != plumbing.HEAD {
+		head, err := l.s.Reference(plumbing.HEAD)
+		if err != nil && err != plumbing.ErrReferenceNotFound {
+			return err
+		}
+
+		if head != nil && head.Type() == plumbing.SymbolicReference && head.Target() == ref.Name() {
+			names = append(names, plumbing.HEAD)
+		}
+	}
+
+	e := &reflog.Entry{
+		Old: prev,
+		New: next,
+		Committer: reflog.Signature{
+			Name:  l.committer.Name,
+			Email: l.committer.Email,
+			When:  l.committer.When,
+		},
+		Message: msg,
+	}
+
+	if e.Committer.When.IsZero() {
+		e.Committer.When = time.Now()
+	}
+
+	rs := l.s.(storer.ReflogStorer)
+	for _, name := range names {
+		if !isLoggedReference(name) {
+			continue
+		}
+
+		if err := rs.AppendReflog(name, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *refLogger) set(ref, old *plumbing.Reference) error {
+	if old == nil {
+		return l.s.SetReference(ref)
+	}
+
+	return l.s.CheckAndSetReference(ref, old)
+}
+
+// resolve returns the hash a reference points to, or ZeroHash if it, or the
+// reference it points to, does not exist.
+func (l *refLogger) resolve(name plumbing.ReferenceName) (plumbing.Hash, error) {
+	ref, err := storer.ResolveReference(l.s, name)
+	if err == plumbing.ErrReferenceNotFound {
+		return plumbing.ZeroHash, nil
+	}
+
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return ref.Hash(), nil
+}
+
+// load reads the config once, returning whether the updates are recorded.
+func (l *refLogger) load() (bool, error) {
+	if l.loaded {
+		return l.enabled, nil
+	}
+
+	if _, ok := l.s.(storer.ReflogStorer); !ok {
+		l.loaded = true
+		return false, nil
+	}
+
+	cfg, err := l.s.Config()
+	if err != nil {
+		return false, err
+	}
+
+	if l.committer == nil {
+		l.committer = reflogCommitter(cfg)
+	}
+
+	l.loaded = true
+	l.enabled = !cfg.Core.IsBare
+	return l.enabled, nil
+}
+
+// isLoggedReference returns true for the references whose reflog is written
+// by default by git.
+func isLoggedReference(name plumbing.ReferenceName) bool {
+	return name == plumbing.HEAD || name.IsBranch() || name.IsRemote() || name.IsNote()
+}
+
+// reflogCommitter returns the committer from the config or, like git, an
+// identity made of the user name and the host name.
+func reflogCommitter(cfg *config.Config) *object.Signature {
+	if cfg.Committer.Name != "" && cfg.Committer.Email != "" {
+		return &object.Signature{Name: cfg.Committer.Name, Email: cfg.Committer.Email}
+	}
+
+	if cfg.User.Name != "" && cfg.User.Email != "" {
+		return &object.Signature{Name: cfg.User.Name, Email: cfg.User.Email}
+	}
+
+	var name string
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "(none)"
+	}
+
+	return &object.Signature{Name: name, Email: fmt.Sprintf("%s@%s", name, host)}
+}
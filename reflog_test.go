@@ -0,0 +1,202 @@
+package git
+
+import (
+	"context"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/config"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type ReflogSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ReflogSuite{})
+
+func (s *ReflogSuite) setUser(c *C, r *Repository) {
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.User.Name = "foo"
+	cfg.User.Email = "foo@foo.com"
+	err = r.SetConfig(cfg)
+	c.Assert(err, IsNil)
+}
+
+// reflogMessages returns the messages of the reflog, the latest first.
+func reflogMessages(c *C, r *Repository, name plumbing.ReferenceName) []string {
+	entries, err := r.Reflog(name)
+	c.Assert(err, IsNil)
+
+	msgs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		msgs = append(msgs, e.Message)
+	}
+
+	return msgs
+}
+
+func (s *ReflogSuite) TestCommitCheckoutReset(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+	s.setUser(c, r)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	first := commitFiles(c, w, map[string]*string{"a": str("a\n")})
+
+	feature := plumbing.NewBranchReferenceName("feature")
+	err = w.Checkout(&CheckoutOptions{Branch: feature, Create: true})
+	c.Assert(err, IsNil)
+
+	second := commitFiles(c, w, map[string]*string{"b": str("b\n")})
+
+	err = w.Reset(&ResetOptions{Commit: first, Mode: HardReset})
+	c.Assert(err, IsNil)
+
+	err = w.Checkout(&CheckoutOptions{Branch: plumbing.Master})
+	c.Assert(err, IsNil)
+
+	c.Assert(reflogMessages(c, r, plumbing.HEAD), DeepEquals, []string{
+		"checkout: moving from feature to master",
+		"reset: moving to " + first.String(),
+		"commit: commit",
+		"checkout: moving from master to feature",
+		"commit (initial): commit",
+	})
+
+	c.Assert(reflogMessages(c, r, feature), DeepEquals, []string{
+		"reset: moving to " + first.String(),
+		"commit: commit",
+		"branch: Created from HEAD",
+	})
+
+	c.Assert(reflogMessages(c, r, plumbing.Master), DeepEquals, []string{
+		"commit (initial): commit",
+	})
+
+	entries, err := r.Reflog(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(entries[1].Old, Equals, second)
+	c.Assert(entries[1].New, Equals, first)
+	c.Assert(entries[1].Committer.Name, Equals, "foo")
+	c.Assert(entries[1].Committer.Email, Equals, "foo@foo.com")
+
+	// Commits are recorded with the identity of their committer.
+	c.Assert(entries[4].Old, Equals, plumbing.ZeroHash)
+	c.Assert(entries[4].New, Equals, first)
+	c.Assert(entries[4].Committer.Name, Equals, mergeSignature.Name)
+}
+
+func (s *ReflogSuite) TestResetFilesNotRecorded(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+	s.setUser(c, r)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	commitFiles(c, w, map[string]*string{"a": str("a\n")})
+
+	err = util.WriteFile(w.Filesystem, "a", []byte("b\n"), 0644)
+	c.Assert(err, IsNil)
+	err = w.Restore(&RestoreOptions{Staged: true, Worktree: true, Files: []string{"a"}})
+	c.Assert(err, IsNil)
+
+	c.Assert(reflogMessages(c, r, plumbing.HEAD), HasLen, 1)
+}
+
+func (s *ReflogSuite) TestFilesystem(c *C) {
+	dotgit := memfs.New()
+	r, err := Init(filesystem.NewStorage(dotgit, cache.NewObjectLRUDefault()), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	h := commitFiles(c, w, map[string]*string{"a": str("a\n")})
+
+	for _, name := range []string{"logs/HEAD", "logs/refs/heads/master"} {
+		content, err := util.ReadFile(dotgit, name)
+		c.Assert(err, IsNil)
+		c.Assert(string(content), Equals, plumbing.ZeroHash.String()+" "+h.String()+
+			" go-git <go-git@fake.local> 1700000000 +0000\tcommit (initial): commit\n")
+	}
+}
+
+func (s *ReflogSuite) TestBare(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	ref := plumbing.NewHashReference(plumbing.Master, plumbing.NewHash("b8e471f58bcbca63b07bda20e428190409c2db47"))
+	err = newRefLogger(r.Storer, mergeSignature).setReference(ref, nil, "branch: Created from HEAD")
+	c.Assert(err, IsNil)
+
+	entries, err := r.Reflog(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+}
+
+func (s *ReflogSuite) TestClone(c *C) {
+	url := s.GetBasicLocalRepositoryURL()
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{URL: url})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	for _, name := range []plumbing.ReferenceName{
+		plumbing.HEAD,
+		plumbing.Master,
+		plumbing.NewRemoteReferenceName("origin", "master"),
+	} {
+		entries, err := r.Reflog(name)
+		c.Assert(err, IsNil)
+		c.Assert(entries, HasLen, 1, Commentf("%s", name))
+		c.Assert(entries[0].Old, Equals, plumbing.ZeroHash)
+		c.Assert(entries[0].New, Equals, head.Hash())
+		c.Assert(entries[0].Message, Equals, "clone: from "+url)
+	}
+}
+
+func (s *ReflogSuite) TestFetch(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Core.IsBare = false
+	err = r.SetConfig(cfg)
+	c.Assert(err, IsNil)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+	c.Assert(err, IsNil)
+
+	err = r.FetchContext(context.Background(), &FetchOptions{})
+	c.Assert(err, IsNil)
+
+	entries, err := r.Reflog(plumbing.NewRemoteReferenceName("origin", "master"))
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Old, Equals, plumbing.ZeroHash)
+	c.Assert(entries[0].Message, Equals, "fetch origin: storing head")
+
+	// Tags are not recorded.
+	tags, err := r.Tags()
+	c.Assert(err, IsNil)
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		entries, err := r.Reflog(ref.Name())
+		c.Assert(err, IsNil)
+		c.Assert(entries, HasLen, 0)
+		return nil
+	})
+	c.Assert(err, IsNil)
+}
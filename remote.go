@@ -32,8 +32,10 @@ import (
 var (
 	NoErrAlreadyUpToDate     = errors.New("already up-to-date")
 	ErrDeleteRefNotSupported = errors.New("server does not support delete-refs")
+	ErrAtomicNotSupported    = errors.New("server does not support atomic push")
 	ErrForceNeeded           = errors.New("some refs were not updated")
 	ErrExactSHA1NotSupported = errors.New("server does not support exact SHA1 refspec")
+	ErrFilterNotSupported    = errors.New("server does not support filters")
 	ErrEmptyUrls             = errors.New("URLs cannot be empty")
 )
 
@@ -152,6 +154,10 @@ func (r *Remote) PushContext(ctx context.Context, o *PushOptions) (err error) {
 		return ErrDeleteRefNotSupported
 	}
 
+	if o.Atomic && !ar.Capabilities.Supports(capability.Atomic) {
+		return ErrAtomicNotSupported
+	}
+
 	if o.Force {
 		for i := 0; i < len(o.RefSpecs); i++ {
 			rs := &o.RefSpecs[i]
@@ -330,7 +336,7 @@ func (r *Remote) newReferenceUpdateRequest(
 		}
 	}
 
-	if o.Atomic && ar.Capabilities.Supports(capability.Atomic) {
+	if o.Atomic {
 		_ = req.Capabilities.Set(capability.Atomic)
 	}
 
@@ -351,6 +357,7 @@ func (r *Remote) newReferenceUpdateRequest(
 func (r *Remote) updateRemoteReferenceStorage(
 	req *packp.ReferenceUpdateRequest,
 ) error {
+	l := newRefLogger(r.s, nil)
 
 	for _, spec := range r.c.Fetch {
 		for _, c := range req.Commands {
@@ -362,7 +369,7 @@ func (r *Remote) updateRemoteReferenceStorage(
 			ref := plumbing.NewHashReference(local, c.New)
 			switch c.Action() {
 			case packp.Create, packp.Update:
-				if err := r.s.SetReference(ref); err != nil {
+				if err := l.setReference(ref, nil, "update by push"); err != nil {
 					return err
 				}
 			case packp.Delete:
@@ -386,7 +393,7 @@ func (r *Remote) updateRemoteReferenceStorage(
 // operation is complete, an error is returned. The context only affects the
 // transport operations.
 func (r *Remote) FetchContext(ctx context.Context, o *FetchOptions) error {
-	_, err := r.fetch(ctx, o)
+	_, err := r.fetch(ctx, o, "fetch "+r.c.Name)
 	return err
 }
 
@@ -399,7 +406,10 @@ func (r *Remote) Fetch(o *FetchOptions) error {
 	return r.FetchContext(context.Background(), o)
 }
 
-func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.ReferenceStorer, err error) {
+// fetch fetches the references and their objects, recording the updates of the
+// local references in the reflogs as the given action. No update is recorded
+// if the action is empty.
+func (r *Remote) fetch(ctx context.Context, o *FetchOptions, action string) (sto storer.ReferenceStorer, err error) {
 	if o.RemoteName == "" {
 		o.RemoteName = r.c.Name
 	}
@@ -416,6 +426,10 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		o.RemoteURL = r.c.URLs[0]
 	}
 
+	if o.Filter == "" && r.c.Promisor {
+		o.Filter = packp.Filter(r.c.PartialCloneFilter)
+	}
+
 	s, err := newUploadPackSession(o.RemoteURL, o.Auth, o.InsecureSkipTLS, o.CABundle, o.ProxyOptions)
 	if err != nil {
 		return nil, err
@@ -423,7 +437,7 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 
 	defer ioutil.CheckClose(s, &err)
 
-	ar, err := s.AdvertisedReferencesContext(ctx)
+	ar, err := advertisedReferences(ctx, s, refPrefixes(o.RefSpecs, o.Tags))
 	if err != nil {
 		return nil, err
 	}
@@ -469,6 +483,12 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		if err = r.fetchPack(ctx, o, s, req); err != nil {
 			return nil, err
 		}
+
+		if o.Filter != "" && !r.c.Promisor {
+			if err = r.setPromisor(o.Filter); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	var updatedPrune bool
@@ -479,7 +499,7 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		}
 	}
 
-	updated, err := r.updateLocalReferenceStorage(o.RefSpecs, refs, remoteRefs, specToRefs, o.Tags, o.Force)
+	updated, err := r.updateLocalReferenceStorage(o.RefSpecs, refs, remoteRefs, specToRefs, o.Tags, o.Force, action)
 	if err != nil {
 		return nil, err
 	}
@@ -541,6 +561,75 @@ func newUploadPackSession(url string, auth transport.AuthMethod, insecure bool,
 	return c.NewUploadPackSession(ep, auth)
 }
 
+// advertisedReferences returns the references advertised by the server. If the
+// server speaks the version 2 of the protocol, only the references starting
+// with one of the prefixes are listed, all of them if prefixes is empty.
+func advertisedReferences(ctx context.Context, s transport.UploadPackSession, prefixes []string) (*packp.AdvRefs, error) {
+	v2, ok := s.(transport.UploadPackV2Session)
+	if !ok || len(prefixes) == 0 {
+		return s.AdvertisedReferencesContext(ctx)
+	}
+
+	adv, err := v2.CapabilityAdvertisement(ctx)
+	if err == transport.ErrProtocolV2Unsupported {
+		return s.AdvertisedReferencesContext(ctx)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	req := packp.NewLsRefsRequest()
+	req.Symrefs = true
+	req.Peel = true
+	req.RefPrefixes = prefixes
+	res, err := v2.LsRefs(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.References) == 0 {
+		// All the references are listed to tell an empty repository apart
+		// from a missing one.
+		return s.AdvertisedReferencesContext(ctx)
+	}
+
+	return res.AdvRefs(adv)
+}
+
+// refPrefixes returns the prefixes of the references matching the refspecs,
+// HEAD included, and the tags unless they are not fetched. It returns nil if a
+// refspec may match any reference, like a short name.
+func refPrefixes(specs []config.RefSpec, tags TagMode) []string {
+	prefixes := []string{plumbing.HEAD.String()}
+	for _, spec := range specs {
+		if spec.IsNegative() || spec.IsExactSHA1() {
+			continue
+		}
+
+		src := spec.Src()
+		if spec.IsWildcard() {
+			src = src[:strings.Index(src, "*")]
+		}
+
+		if src == plumbing.HEAD.String() {
+			continue
+		}
+
+		if !strings.HasPrefix(src, "refs/") {
+			return nil
+		}
+
+		prefixes = append(prefixes, src)
+	}
+
+	if tags != NoTags {
+		prefixes = append(prefixes, "refs/tags/")
+	}
+
+	return prefixes
+}
+
 func newSendPackSession(url string, auth transport.AuthMethod, insecure bool, cabundle []byte, proxyOpts transport.ProxyOptions) (transport.ReceivePackSession, error) {
 	c, ep, err := newClient(url, insecure, cabundle, proxyOpts)
 	if err != nil {
@@ -1163,6 +1252,17 @@ func (r *Remote) newUploadPackRequest(o *FetchOptions,
 		}
 	}
 
+	if o.Filter != "" {
+		if !ar.Capabilities.Supports(capability.Filter) {
+			return nil, ErrFilterNotSupported
+		}
+
+		req.Filter = o.Filter
+		if err := req.Capabilities.Set(capability.Filter); err != nil {
+			return nil, err
+		}
+	}
+
 	if o.Progress == nil && ar.Capabilities.Supports(capability.NoProgress) {
 		if err := req.Capabilities.Set(capability.NoProgress); err != nil {
 			return nil, err
@@ -1230,9 +1330,11 @@ func (r *Remote) updateLocalReferenceStorage(
 	specToRefs [][]*plumbing.Reference,
 	tagMode TagMode,
 	force bool,
+	action string,
 ) (updated bool, err error) {
 	isWildcard := true
 	forceNeeded := false
+	l := newRefLogger(r.s, nil)
 
 	for i, spec := range specs {
 		if !spec.IsWildcard() {
@@ -1252,6 +1354,7 @@ func (r *Remote) updateLocalReferenceStorage(
 			old, _ := storer.ResolveReference(r.s, localName)
 			new := plumbing.NewHashReference(localName, ref.Hash())
 
+			msg := "storing head"
 			// If the ref exists locally as a non-tag and force is not
 			// specified, only update if the new ref is an ancestor of the old
 			if old != nil && !old.Name().IsTag() && !force && !spec.IsForceUpdate() {
@@ -1264,9 +1367,27 @@ func (r *Remote) updateLocalReferenceStorage(
 					forceNeeded = true
 					continue
 				}
+
+				msg = "fast-forward"
+			} else if old != nil && action != "" {
+				msg = "fast-forward"
+				if ff, err := isFastForward(r.s, old.Hash(), new.Hash(), nil); err != nil || !ff {
+					msg = "forced-update"
+				}
+			}
+
+			switch {
+			case action == "":
+				msg = ""
+			case strings.HasPrefix(action, "clone: "):
+				// Like git, the references created by a clone are recorded
+				// with the action only.
+				msg = action
+			default:
+				msg = fmt.Sprintf("%s: %s", action, msg)
 			}
 
-			refUpdated, err := checkAndUpdateReferenceStorerIfNeeded(r.s, new, old)
+			refUpdated, err := checkAndUpdateReferenceStorerIfNeeded(l, new, old, msg)
 			if err != nil {
 				return updated, err
 			}
@@ -1316,7 +1437,7 @@ func (r *Remote) buildFetchedTags(refs memory.ReferenceStorage) (updated bool, e
 			return false, err
 		}
 
-		refUpdated, err := updateReferenceStorerIfNeeded(r.s, ref)
+		refUpdated, err := updateReferenceStorerIfNeeded(newRefLogger(r.s, nil), ref, "")
 		if err != nil {
 			return updated, err
 		}
@@ -24,6 +24,9 @@ import (
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp"
 	"github.com/jesseduffield/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/client"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport/server"
 	"github.com/jesseduffield/go-git/v5/storage"
 	"github.com/jesseduffield/go-git/v5/storage/filesystem"
 	"github.com/jesseduffield/go-git/v5/storage/memory"
@@ -104,14 +107,47 @@ func (s *RemoteSuite) TestFetchExactSHA1_NotSoported(c *C) {
 		URLs: []string{s.GetBasicLocalRepositoryURL()},
 	})
 
-	err := r.Fetch(&FetchOptions{
+	// The servers speaking the version 0 of the protocol only accept the
+	// advertised objects by default.
+	err := r.isSupportedRefSpec([]config.RefSpec{
+		config.RefSpec("35e85108805c84807bc66a02d91535e1e24b38b9:refs/heads/foo"),
+	}, packp.NewAdvRefs())
+
+	c.Assert(err, Equals, ErrExactSHA1NotSupported)
+
+}
+
+func (s *RemoteSuite) TestFetchExactSHA1ProtocolV2(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	s.testFetch(c, r, &FetchOptions{
 		RefSpecs: []config.RefSpec{
 			config.RefSpec("35e85108805c84807bc66a02d91535e1e24b38b9:refs/heads/foo"),
 		},
+	}, []*plumbing.Reference{
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "35e85108805c84807bc66a02d91535e1e24b38b9"),
 	})
+}
 
-	c.Assert(err, Equals, ErrExactSHA1NotSupported)
+func (s *RemoteSuite) TestRefPrefixes(c *C) {
+	c.Assert(refPrefixes([]config.RefSpec{
+		"+refs/heads/*:refs/remotes/origin/*",
+		"refs/pull/1/head:refs/heads/pr",
+		"+HEAD:refs/remotes/origin/HEAD",
+		"35e85108805c84807bc66a02d91535e1e24b38b9:refs/heads/foo",
+	}, TagFollowing), DeepEquals, []string{
+		"HEAD", "refs/heads/", "refs/pull/1/head", "refs/tags/",
+	})
+
+	c.Assert(refPrefixes([]config.RefSpec{
+		"+refs/heads/master:refs/remotes/origin/master",
+	}, NoTags), DeepEquals, []string{"HEAD", "refs/heads/master"})
 
+	c.Assert(refPrefixes([]config.RefSpec{
+		"master:refs/heads/master",
+	}, NoTags), IsNil)
 }
 
 func (s *RemoteSuite) TestFetchWildcardTags(c *C) {
@@ -600,6 +636,54 @@ func (s *RemoteSuite) TestPushToEmptyRepository(c *C) {
 
 }
 
+func (s *RemoteSuite) TestPushAtomic(c *C) {
+	url := c.MkDir()
+
+	server, err := PlainInit(url, true)
+	c.Assert(err, IsNil)
+
+	sto := filesystem.NewStorage(fixtures.Basic().One().DotGit(), cache.NewObjectLRUDefault())
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{url},
+	})
+
+	err = r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/*:refs/heads/*"},
+		Atomic:   true,
+	})
+	c.Assert(err, IsNil)
+
+	AssertReferences(c, server, map[string]string{
+		"refs/heads/master": "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"refs/heads/branch": "e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	})
+}
+
+func (s *RemoteSuite) TestPushAtomicNotSupported(c *C) {
+	// The server does not support atomic pushes, as its storer cannot
+	// update several references in a transaction.
+	ep, err := transport.NewEndpoint("file:///not-atomic.git")
+	c.Assert(err, IsNil)
+
+	loader := server.MapLoader{ep.String(): struct{ storer.Storer }{memory.NewStorage()}}
+	backup := client.Protocols["file"]
+	client.InstallProtocol("file", server.NewServer(loader))
+	defer client.InstallProtocol("file", backup)
+
+	sto := filesystem.NewStorage(fixtures.Basic().One().DotGit(), cache.NewObjectLRUDefault())
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{ep.String()},
+	})
+
+	err = r.Push(&PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/*:refs/heads/*"},
+		Atomic:   true,
+	})
+	c.Assert(err, Equals, ErrAtomicNotSupported)
+}
+
 func (s *RemoteSuite) TestPushContext(c *C) {
 	url := c.MkDir()
 
@@ -0,0 +1,252 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/idxfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// writeObjectPack writes a pack with the given objects, compressed as
+// configured by the pack section of the config. The entries of the given
+// packs are copied as they are when possible.
+func (r *Repository) writeObjectPack(hashes []plumbing.Hash, useRefDeltas bool, reuse []plumbing.Hash) (h plumbing.Hash, err error) {
+	pfw, ok := r.Storer.(storer.PackfileWriter)
+	if !ok {
+		return h, fmt.Errorf("Repository storer is not a storer.PackfileWriter")
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return h, err
+	}
+
+	packs, err := r.openObjectPacks(reuse)
+	for _, p := range packs {
+		defer ioutil.CheckClose(p, &err)
+	}
+
+	if err != nil {
+		return h, err
+	}
+
+	wc, err := pfw.PackfileWriter()
+	if err != nil {
+		return h, err
+	}
+
+	defer ioutil.CheckClose(wc, &err)
+	enc := packfile.NewEncoderWithOptions(wc, r.Storer, packfile.EncoderOptions{
+		UseRefDeltas: useRefDeltas,
+		Depth:        cfg.Pack.Depth,
+		WindowMemory: cfg.Pack.WindowMemory,
+		ReusePacks:   packs,
+	})
+
+	return enc.Encode(hashes, cfg.Pack.Window)
+}
+
+// openObjectPacks opens the given packs of a storer with a filesystem. No
+// pack is opened for the other storers.
+func (r *Repository) openObjectPacks(hashes []plumbing.Hash) ([]*packfile.Packfile, error) {
+	s, ok := r.Storer.(interface{ Filesystem() billy.Filesystem })
+	if !ok {
+		return nil, nil
+	}
+
+	fs := s.Filesystem()
+	var packs []*packfile.Packfile
+	for _, h := range hashes {
+		idx, err := readPackIndex(fs, h)
+		if err != nil {
+			return packs, err
+		}
+
+		f, err := fs.Open(packPath(h, "pack"))
+		if err != nil {
+			return packs, err
+		}
+
+		packs = append(packs, packfile.NewPackfile(idx, nil, f, 0))
+	}
+
+	return packs, nil
+}
+
+func readPackIndex(fs billy.Filesystem, h plumbing.Hash) (idx *idxfile.MemoryIndex, err error) {
+	f, err := fs.Open(packPath(h, "idx"))
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	idx = idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(f).Decode(idx); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// repackGeometric packs the loose objects and the objects of the smallest
+// packs into a new pack, leaving the packs which form a geometric
+// progression.
+func (r *Repository) repackGeometric(cfg *RepackConfig) error {
+	s, ok := r.Storer.(gcStorer)
+	if !ok {
+		return ErrPackedObjectsNotSupported
+	}
+
+	fs := s.Filesystem()
+	hashes, err := s.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	var packs []*gcPack
+	for _, h := range hashes {
+		p, err := readGCPack(fs, h)
+		if err != nil {
+			return err
+		}
+
+		if !p.kept {
+			packs = append(packs, p)
+		}
+	}
+
+	rolled := packs[:geometricSplit(packs, cfg.Geometric)]
+
+	seen := make(map[plumbing.Hash]bool)
+	var objs, reuse, loose []plumbing.Hash
+	add := func(h plumbing.Hash) {
+		if !seen[h] {
+			seen[h] = true
+			objs = append(objs, h)
+		}
+	}
+
+	for _, p := range rolled {
+		reuse = append(reuse, p.hash)
+		for _, h := range p.hashes {
+			add(h)
+		}
+	}
+
+	err = s.ForEachObjectHash(func(h plumbing.Hash) error {
+		loose = append(loose, h)
+		add(h)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// There is nothing to roll up.
+	if len(objs) == 0 || len(rolled) == 1 && len(loose) == 0 {
+		return nil
+	}
+
+	if !cfg.ReuseDeltas {
+		reuse = nil
+	}
+
+	nh, err := r.writeObjectPack(objs, cfg.UseRefDeltas, reuse)
+	if err != nil {
+		return err
+	}
+
+	if rs, ok := s.(interface{ Reindex() }); ok {
+		defer rs.Reindex()
+	}
+
+	for _, p := range rolled {
+		if p.hash == nh {
+			continue
+		}
+
+		if err := deleteObjectPack(s, fs, p.hash, cfg.OnlyDeletePacksOlderThan); err != nil {
+			return err
+		}
+	}
+
+	if err := removeStaleMultiPackIndex(fs); err != nil {
+		return err
+	}
+
+	for _, h := range loose {
+		if err := s.DeleteLooseObject(h); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// geometricSplit sorts the packs by number of objects and returns how many
+// of the smallest ones must be rolled up into a new pack, so that each pack
+// has at least factor times as many objects as the previous one, like git
+// does.
+func geometricSplit(packs []*gcPack, factor int) int {
+	sort.SliceStable(packs, func(i, j int) bool {
+		return len(packs[i].hashes) < len(packs[j].hashes)
+	})
+
+	i := len(packs) - 1
+	for ; i > 0; i-- {
+		if len(packs[i].hashes) < factor*len(packs[i-1].hashes) {
+			break
+		}
+	}
+
+	// The biggest pack of the last compared pair is not in the progression.
+	split := 0
+	if i > 0 {
+		split = i + 1
+	}
+
+	// The new pack may break the progression with the next packs, which are
+	// then rolled up too.
+	total := 0
+	for _, p := range packs[:split] {
+		total += len(p.hashes)
+	}
+
+	for ; split < len(packs); split++ {
+		if len(packs[split].hashes) >= factor*total {
+			break
+		}
+
+		total += len(packs[split].hashes)
+	}
+
+	return split
+}
+
+// deleteObjectPack deletes the pack, with its index and its other files, if
+// it is older than t or if t is zero.
+func deleteObjectPack(s gcStorer, fs billy.Filesystem, h plumbing.Hash, t time.Time) error {
+	if err := s.DeleteOldObjectPackAndIndex(h, t); err != nil {
+		return err
+	}
+
+	if _, err := fs.Stat(packPath(h, "pack")); err == nil {
+		return nil
+	}
+
+	for _, ext := range []string{"mtimes", "rev", "bitmap"} {
+		if err := fs.Remove(packPath(h, ext)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package git
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git-fixtures/v4"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/revlist"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem"
+
+	. "gopkg.in/check.v1"
+)
+
+type RepackSuite struct {
+	GCSuite
+}
+
+var _ = Suite(&RepackSuite{})
+
+func (s *RepackSuite) TestRepackObjectsReuseDeltas(c *C) {
+	if testing.Short() {
+		c.Skip("skipping test in short mode.")
+	}
+
+	fs := fixtures.ByTag("unpacked").One().DotGit()
+	sto := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	r, err := Open(sto, fs)
+	c.Assert(err, IsNil)
+
+	var hashes []plumbing.Hash
+	iter, err := sto.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+	err = iter.ForEach(func(o plumbing.EncodedObject) error {
+		hashes = append(hashes, o.Hash())
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	err = r.RepackObjects(&RepackConfig{ReuseDeltas: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.looseObjects(c, r), HasLen, 0)
+	c.Assert(s.packs(c, r), HasLen, 1)
+
+	for _, h := range hashes {
+		o, err := sto.EncodedObject(plumbing.AnyObject, h)
+		c.Assert(err, IsNil)
+		c.Assert(o.Hash(), Equals, h)
+	}
+}
+
+func (s *RepackSuite) TestRepackObjectsGeometric(c *C) {
+	r, dir := s.newGCRepository(c)
+
+	var blobs []plumbing.Hash
+	for i := 0; i < 36; i++ {
+		blobs = append(blobs, s.storeBlob(c, r, dir, fmt.Sprintf("blob %d\n", i), time.Now()))
+	}
+
+	big, err := r.writeObjectPack(blobs[:32], false, nil)
+	c.Assert(err, IsNil)
+	_, err = r.writeObjectPack(blobs[32:33], false, nil)
+	c.Assert(err, IsNil)
+	_, err = r.writeObjectPack(blobs[33:34], false, nil)
+	c.Assert(err, IsNil)
+
+	for _, h := range blobs[:34] {
+		c.Assert(r.Storer.(storer.LooseObjectStorer).DeleteLooseObject(h), IsNil)
+	}
+
+	loose := s.looseObjects(c, r)
+	err = r.RepackObjects(&RepackConfig{Geometric: 2, ReuseDeltas: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.looseObjects(c, r), HasLen, 0)
+
+	packs := s.packs(c, r)
+	c.Assert(packs, HasLen, 2)
+
+	var found bool
+	for _, h := range packs {
+		p, err := readGCPack(r.Storer.(gcStorer).Filesystem(), h)
+		c.Assert(err, IsNil)
+		if h == big {
+			found = true
+			c.Assert(p.hashes, HasLen, 32)
+		} else {
+			c.Assert(p.hashes, HasLen, 2+len(loose))
+		}
+	}
+
+	c.Assert(found, Equals, true)
+
+	// The packs form a geometric progression now.
+	err = r.RepackObjects(&RepackConfig{Geometric: 2})
+	c.Assert(err, IsNil)
+	c.Assert(s.packs(c, r), HasLen, 2)
+
+	for _, h := range blobs {
+		_, err := r.Storer.EncodedObject(plumbing.BlobObject, h)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *RepackSuite) TestRepackObjectsWriteBitmap(c *C) {
+	fs := fixtures.Basic().One().DotGit()
+	sto := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	r, err := Open(sto, fs)
+	c.Assert(err, IsNil)
+
+	err = r.RepackObjects(&RepackConfig{WriteBitmap: true})
+	c.Assert(err, IsNil)
+
+	packs := s.packs(c, r)
+	c.Assert(packs, HasLen, 1)
+	_, err = fs.Stat(packPath(packs[0], "bitmap"))
+	c.Assert(err, IsNil)
+
+	b, err := sto.PackBitmap()
+	c.Assert(err, IsNil)
+	c.Assert(b, NotNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	_, ok := b.Reachable(head.Hash())
+	c.Assert(ok, Equals, true)
+
+	var tips []plumbing.Hash
+	refs, err := r.References()
+	c.Assert(err, IsNil)
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	ignore := []plumbing.Hash{plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")}
+	for _, ignore := range [][]plumbing.Hash{nil, ignore} {
+		objs, err := revlist.Objects(sto, tips, ignore)
+		c.Assert(err, IsNil)
+
+		// The same objects are found walking the history.
+		expected, err := revlist.ObjectsWithStorageForIgnores(sto, sto, tips, ignore)
+		c.Assert(err, IsNil)
+		c.Assert(objs, HasLen, len(expected))
+
+		found := make(map[plumbing.Hash]bool)
+		for _, h := range objs {
+			found[h] = true
+		}
+
+		for _, h := range expected {
+			c.Assert(found[h], Equals, true)
+		}
+	}
+}
+
+func (s *RepackSuite) TestGeometricSplit(c *C) {
+	for _, t := range []struct {
+		sizes  []int
+		factor int
+		split  int
+	}{
+		{[]int{1, 2, 4}, 2, 0},
+		{[]int{1, 1, 1}, 2, 3},
+		{[]int{100, 2, 8, 1}, 2, 0},
+		{[]int{3, 16, 2}, 2, 2},
+		{[]int{3, 16, 2}, 3, 2},
+		{[]int{3, 6, 2}, 2, 3},
+		{[]int{5}, 2, 0},
+		{nil, 2, 0},
+	} {
+		var packs []*gcPack
+		for _, size := range t.sizes {
+			packs = append(packs, &gcPack{hashes: make([]plumbing.Hash, size)})
+		}
+
+		c.Assert(geometricSplit(packs, t.factor), Equals, t.split, Commentf("%v", t.sizes))
+	}
+}
@@ -26,10 +26,12 @@ import (
 	"github.com/jesseduffield/go-git/v5/plumbing"
 	"github.com/jesseduffield/go-git/v5/plumbing/cache"
 	formatcfg "github.com/jesseduffield/go-git/v5/plumbing/format/config"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
 	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
 	"github.com/jesseduffield/go-git/v5/plumbing/hash"
 	"github.com/jesseduffield/go-git/v5/plumbing/object"
 	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/plumbing/transport"
 	"github.com/jesseduffield/go-git/v5/storage"
 	"github.com/jesseduffield/go-git/v5/storage/filesystem"
 	"github.com/jesseduffield/go-git/v5/storage/filesystem/dotgit"
@@ -74,6 +76,12 @@ type Repository struct {
 
 	r  map[string]*Remote
 	wt billy.Filesystem
+	// state holds the state of operations in progress, such as a rebase,
+	// when the storer is not backed by a filesystem.
+	state billy.Filesystem
+	// promisorAuth are the auth methods of the fetches from the remotes,
+	// reused to fetch the objects missing from a partial clone.
+	promisorAuth map[string]transport.AuthMethod
 }
 
 type InitOptions struct {
@@ -264,6 +272,14 @@ func PlainInitWithOptions(path string, opts *PlainInitOptions) (*Repository, err
 
 	s := filesystem.NewStorage(dot, cache.NewObjectLRUDefault())
 
+	// The storage of the references is set first, as HEAD is written on
+	// initialization.
+	if opts.RefStorage != "" && opts.RefStorage != formatcfg.FilesRefStorage {
+		if err := setRefStorage(s, opts.RefStorage); err != nil {
+			return nil, err
+		}
+	}
+
 	r, err := InitWithOptions(s, wt, opts.InitOptions)
 	if err != nil {
 		return nil, err
@@ -291,6 +307,25 @@ func PlainInitWithOptions(path string, opts *PlainInitOptions) (*Repository, err
 	return r, err
 }
 
+func setRefStorage(s *filesystem.Storage, rs formatcfg.RefStorage) error {
+	if rs != formatcfg.ReftableRefStorage {
+		return fmt.Errorf("%w: %s", filesystem.ErrUnsupportedRefStorage, rs)
+	}
+
+	if _, err := s.Reference(plumbing.HEAD); err == nil {
+		return ErrRepositoryAlreadyExists
+	}
+
+	cfg, err := s.Config()
+	if err != nil {
+		return err
+	}
+
+	cfg.Core.RepositoryFormatVersion = formatcfg.Version_1
+	cfg.Extensions.RefStorage = rs
+	return s.SetConfig(cfg)
+}
+
 // PlainOpen opens a git repository from the given path. It detects if the
 // repository is bare or a normal one. If the path doesn't contain a valid
 // repository ErrRepositoryNotExists is returned
@@ -565,7 +600,12 @@ func (r *Repository) SetConfig(cfg *config.Config) error {
 // are returned merged in one config value.
 func (r *Repository) ConfigScoped(scope config.Scope) (*config.Config, error) {
 	// TODO(mcuadros): v6, add this as ConfigOptions.Scoped
+	return loadConfigScoped(r.Storer, scope)
+}
 
+// loadConfigScoped returns the config of the storer merged with the requested
+// scope and lower, see Repository.ConfigScoped.
+func loadConfigScoped(s config.ConfigStorer, scope config.Scope) (*config.Config, error) {
 	var err error
 	system := config.NewConfig()
 	if scope >= config.SystemScope {
@@ -583,7 +623,7 @@ func (r *Repository) ConfigScoped(scope config.Scope) (*config.Config, error) {
 		}
 	}
 
-	local, err := r.Storer.Config()
+	local, err := s.Config()
 	if err != nil {
 		return nil, err
 	}
@@ -888,10 +928,12 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 	}
 
 	c := &config.RemoteConfig{
-		Name:   o.RemoteName,
-		URLs:   []string{o.URL},
-		Fetch:  r.cloneRefSpec(o),
-		Mirror: o.Mirror,
+		Name:               o.RemoteName,
+		URLs:               []string{o.URL},
+		Fetch:              r.cloneRefSpec(o),
+		Mirror:             o.Mirror,
+		Promisor:           o.Filter != "",
+		PartialCloneFilter: string(o.Filter),
 	}
 
 	if _, err := r.CreateRemote(c); err != nil {
@@ -925,6 +967,7 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 	ref, err := r.fetchAndUpdateReferences(ctx, &FetchOptions{
 		RefSpecs:        c.Fetch,
 		Depth:           o.Depth,
+		Filter:          o.Filter,
 		Auth:            o.Auth,
 		Progress:        o.Progress,
 		Tags:            o.Tags,
@@ -948,10 +991,10 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 			return err
 		}
 
-		if err := w.Reset(&ResetOptions{
+		if err := w.reset(&ResetOptions{
 			Mode:   MergeReset,
 			Commit: head.Hash(),
-		}); err != nil {
+		}, nil, ""); err != nil {
 			return err
 		}
 
@@ -1066,8 +1109,13 @@ func (r *Repository) fetchAndUpdateReferences(
 		return nil, err
 	}
 
+	if o.RemoteURL == "" {
+		o.RemoteURL = remote.c.URLs[0]
+	}
+
+	r.setPromisorAuth(o.RemoteName, o.Auth)
 	objsUpdated := true
-	remoteRefs, err := remote.fetch(ctx, o)
+	remoteRefs, err := remote.fetch(ctx, o, "clone: from "+o.RemoteURL)
 	if err == NoErrAlreadyUpToDate {
 		objsUpdated = false
 	} else if err == packfile.ErrEmptyPackfile {
@@ -1081,7 +1129,7 @@ func (r *Repository) fetchAndUpdateReferences(
 		return nil, err
 	}
 
-	refsUpdated, err := r.updateReferences(remote.c.Fetch, resolvedRef)
+	refsUpdated, err := r.updateReferences(remote.c.Fetch, resolvedRef, "clone: from "+o.RemoteURL)
 	if err != nil {
 		return nil, err
 	}
@@ -1094,7 +1142,8 @@ func (r *Repository) fetchAndUpdateReferences(
 }
 
 func (r *Repository) updateReferences(spec []config.RefSpec,
-	resolvedRef *plumbing.Reference) (updated bool, err error) {
+	resolvedRef *plumbing.Reference, msg string) (updated bool, err error) {
+	l := newRefLogger(r.Storer, nil)
 
 	if !resolvedRef.Name().IsBranch() {
 		// Detached HEAD mode
@@ -1103,7 +1152,7 @@ func (r *Repository) updateReferences(spec []config.RefSpec,
 			return false, err
 		}
 		head := plumbing.NewHashReference(plumbing.HEAD, h)
-		return updateReferenceStorerIfNeeded(r.Storer, head)
+		return updateReferenceStorerIfNeeded(l, head, msg)
 	}
 
 	refs := []*plumbing.Reference{
@@ -1116,7 +1165,7 @@ func (r *Repository) updateReferences(spec []config.RefSpec,
 	refs = append(refs, r.calculateRemoteHeadReference(spec, resolvedRef)...)
 
 	for _, ref := range refs {
-		u, err := updateReferenceStorerIfNeeded(r.Storer, ref)
+		u, err := updateReferenceStorerIfNeeded(l, ref, msg)
 		if err != nil {
 			return updated, err
 		}
@@ -1153,16 +1202,16 @@ func (r *Repository) calculateRemoteHeadReference(spec []config.RefSpec,
 }
 
 func checkAndUpdateReferenceStorerIfNeeded(
-	s storer.ReferenceStorer, r, old *plumbing.Reference) (
+	l *refLogger, r, old *plumbing.Reference, msg string) (
 	updated bool, err error) {
-	p, err := s.Reference(r.Name())
+	p, err := l.s.Reference(r.Name())
 	if err != nil && err != plumbing.ErrReferenceNotFound {
 		return false, err
 	}
 
 	// we use the string method to compare references, is the easiest way
 	if err == plumbing.ErrReferenceNotFound || r.String() != p.String() {
-		if err := s.CheckAndSetReference(r, old); err != nil {
+		if err := l.setReference(r, old, msg); err != nil {
 			return false, err
 		}
 
@@ -1173,8 +1222,8 @@ func checkAndUpdateReferenceStorerIfNeeded(
 }
 
 func updateReferenceStorerIfNeeded(
-	s storer.ReferenceStorer, r *plumbing.Reference) (updated bool, err error) {
-	return checkAndUpdateReferenceStorerIfNeeded(s, r, nil)
+	l *refLogger, r *plumbing.Reference, msg string) (updated bool, err error) {
+	return checkAndUpdateReferenceStorerIfNeeded(l, r, nil, msg)
 }
 
 // Fetch fetches references along with the objects necessary to complete
@@ -1205,6 +1254,7 @@ func (r *Repository) FetchContext(ctx context.Context, o *FetchOptions) error {
 		return err
 	}
 
+	r.setPromisorAuth(o.RemoteName, o.Auth)
 	return remote.FetchContext(ctx, o)
 }
 
@@ -1408,7 +1458,7 @@ func (r *Repository) Notes() (storer.ReferenceIter, error) {
 // TreeObject return a Tree with the given hash. If not found
 // plumbing.ErrObjectNotFound is returned
 func (r *Repository) TreeObject(h plumbing.Hash) (*object.Tree, error) {
-	return object.GetTree(r.Storer, h)
+	return object.GetTree(r.objectStorer(), h)
 }
 
 // TreeObjects returns an unsorted TreeIter with all the trees in the repository
@@ -1418,13 +1468,13 @@ func (r *Repository) TreeObjects() (*object.TreeIter, error) {
 		return nil, err
 	}
 
-	return object.NewTreeIter(r.Storer, iter), nil
+	return object.NewTreeIter(r.objectStorer(), iter), nil
 }
 
 // CommitObject return a Commit with the given hash. If not found
 // plumbing.ErrObjectNotFound is returned.
 func (r *Repository) CommitObject(h plumbing.Hash) (*object.Commit, error) {
-	return object.GetCommit(r.Storer, h)
+	return object.GetCommit(r.objectStorer(), h)
 }
 
 // CommitObjects returns an unsorted CommitIter with all the commits in the repository.
@@ -1434,13 +1484,13 @@ func (r *Repository) CommitObjects() (object.CommitIter, error) {
 		return nil, err
 	}
 
-	return object.NewCommitIter(r.Storer, iter), nil
+	return object.NewCommitIter(r.objectStorer(), iter), nil
 }
 
 // BlobObject returns a Blob with the given hash. If not found
 // plumbing.ErrObjectNotFound is returned.
 func (r *Repository) BlobObject(h plumbing.Hash) (*object.Blob, error) {
-	return object.GetBlob(r.Storer, h)
+	return object.GetBlob(r.objectStorer(), h)
 }
 
 // BlobObjects returns an unsorted BlobIter with all the blobs in the repository.
@@ -1450,14 +1500,14 @@ func (r *Repository) BlobObjects() (*object.BlobIter, error) {
 		return nil, err
 	}
 
-	return object.NewBlobIter(r.Storer, iter), nil
+	return object.NewBlobIter(r.objectStorer(), iter), nil
 }
 
 // TagObject returns a Tag with the given hash. If not found
 // plumbing.ErrObjectNotFound is returned. This method only returns
 // annotated Tags, no lightweight Tags.
 func (r *Repository) TagObject(h plumbing.Hash) (*object.Tag, error) {
-	return object.GetTag(r.Storer, h)
+	return object.GetTag(r.objectStorer(), h)
 }
 
 // TagObjects returns a unsorted TagIter that can step through all of the annotated
@@ -1468,18 +1518,19 @@ func (r *Repository) TagObjects() (*object.TagIter, error) {
 		return nil, err
 	}
 
-	return object.NewTagIter(r.Storer, iter), nil
+	return object.NewTagIter(r.objectStorer(), iter), nil
 }
 
 // Object returns an Object with the given hash. If not found
 // plumbing.ErrObjectNotFound is returned.
 func (r *Repository) Object(t plumbing.ObjectType, h plumbing.Hash) (object.Object, error) {
-	obj, err := r.Storer.EncodedObject(t, h)
+	s := r.objectStorer()
+	obj, err := s.EncodedObject(t, h)
 	if err != nil {
 		return nil, err
 	}
 
-	return object.DecodeObject(r.Storer, obj)
+	return object.DecodeObject(s, obj)
 }
 
 // Objects returns an unsorted ObjectIter with all the objects in the repository.
@@ -1489,7 +1540,7 @@ func (r *Repository) Objects() (*object.ObjectIter, error) {
 		return nil, err
 	}
 
-	return object.NewObjectIter(r.Storer, iter), nil
+	return object.NewObjectIter(r.objectStorer(), iter), nil
 }
 
 // Head returns the reference where HEAD is pointing to.
@@ -1541,11 +1592,13 @@ func expand_ref(s storer.ReferenceStorer, ref plumbing.ReferenceName) (*plumbing
 	return nil, ret
 }
 
-// ResolveRevision resolves revision to corresponding hash. It will always
-// resolve to a commit hash, not a tree or annotated tag.
+// ResolveRevision resolves revision to corresponding hash. It resolves to a
+// commit hash, not an annotated tag, unless a path is given, which resolves
+// to a blob or a tree hash.
 //
 // Implemented resolvers : HEAD, branch, tag, heads/branch, refs/heads/branch,
-// refs/tags/tag, refs/remotes/origin/branch, refs/remotes/origin/HEAD, tilde and caret (HEAD~1, master~^, tag~2, ref/heads/master~1, ...), selection by text (HEAD^{/fix nasty bug}), hash (prefix and full)
+// refs/tags/tag, refs/remotes/origin/branch, refs/remotes/origin/HEAD, tilde and caret (HEAD~1, master~^, tag~2, ref/heads/master~1, ...), selection by text (HEAD^{/fix nasty bug}, :/fix nasty bug), hash (prefix and full),
+// reflog (master@{1}, @{1}, master@{yesterday}, @{-1}), upstream and push branches (@{upstream}, @{u}, master@{push}), path (HEAD:README, :README, :2:README)
 func (r *Repository) ResolveRevision(in plumbing.Revision) (*plumbing.Hash, error) {
 	rev := in.String()
 	if rev == "" {
@@ -1561,8 +1614,63 @@ func (r *Repository) ResolveRevision(in plumbing.Revision) (*plumbing.Hash, erro
 
 	var commit *object.Commit
 
+	// The reference of <refname>@{...} is resolved by the @ item.
+	var name string
+	if len(items) > 1 {
+		if ref, ok := items[0].(revision.Ref); ok {
+			switch items[1].(type) {
+			case revision.AtReflog, revision.AtDate, revision.AtUpstream, revision.AtPush:
+				name = string(ref)
+				items = items[1:]
+			}
+		}
+	}
+
 	for _, item := range items {
+		var h plumbing.Hash
+
 		switch item := item.(type) {
+		case revision.AtReflog:
+			h, err = r.resolveReflogEntry(name, item.Depth)
+		case revision.AtDate:
+			h, err = r.resolveReflogDate(name, item.Date)
+		case revision.AtCheckout:
+			h, err = r.resolvePreviousCheckout(item.Depth)
+		case revision.AtUpstream, revision.AtPush:
+			var ref plumbing.ReferenceName
+			if _, ok := item.(revision.AtUpstream); ok {
+				ref, err = r.resolveUpstream(name)
+			} else {
+				ref, err = r.resolvePush(name)
+			}
+
+			if err == nil {
+				h, err = r.resolveRefHash(ref)
+			}
+		case revision.ColonReg:
+			commit, err = r.resolveMessageRegexp(item.Regexp, item.Negate)
+			if err != nil {
+				return &plumbing.ZeroHash, err
+			}
+		case revision.ColonPath:
+			if commit == nil {
+				h, err = r.resolveIndexPath(item.Path, index.Merged)
+			} else {
+				h, err = resolveTreePath(commit, item.Path)
+			}
+
+			if err != nil {
+				return &plumbing.ZeroHash, err
+			}
+
+			return &h, nil
+		case revision.ColonStagePath:
+			h, err = r.resolveIndexPath(item.Path, index.Stage(item.Stage))
+			if err != nil {
+				return &plumbing.ZeroHash, err
+			}
+
+			return &h, nil
 		case revision.Ref:
 			revisionRef := item
 
@@ -1677,6 +1785,17 @@ func (r *Repository) ResolveRevision(in plumbing.Revision) (*plumbing.Hash, erro
 
 			commit = c
 		}
+
+		if err != nil {
+			return &plumbing.ZeroHash, err
+		}
+
+		if !h.IsZero() {
+			commit, err = r.CommitObject(h)
+			if err != nil {
+				return &plumbing.ZeroHash, err
+			}
+		}
 	}
 
 	if commit == nil {
@@ -1736,14 +1855,34 @@ type RepackConfig struct {
 	// OnlyDeletePacksOlderThan if set to non-zero value
 	// selects only objects older than the time provided.
 	OnlyDeletePacksOlderThan time.Time
-}
-
+	// ReuseDeltas copies the objects of the existing packs, and their
+	// deltas, as they are instead of compressing them again, like git repack
+	// does without -f. Only the other objects are searched for deltas.
+	ReuseDeltas bool
+	// Geometric, if greater than 1, only repacks the loose objects and the
+	// smallest packs, so that each remaining pack has at least Geometric
+	// times as many objects as the next smaller one, like git repack
+	// --geometric. The packs with a .keep file are left as they are.
+	Geometric int
+	// WriteBitmap writes a bitmap index for the new pack, like git repack
+	// -b. It is ignored with Geometric.
+	WriteBitmap bool
+}
+
+// RepackObjects packs the objects reachable from the references into a new
+// pack and deletes the previous packs and loose objects. The window, the
+// depth and the window memory of the delta compression are the ones of the
+// pack section of the config.
 func (r *Repository) RepackObjects(cfg *RepackConfig) (err error) {
 	pos, ok := r.Storer.(storer.PackedObjectStorer)
 	if !ok {
 		return ErrPackedObjectsNotSupported
 	}
 
+	if cfg.Geometric > 1 {
+		return r.repackGeometric(cfg)
+	}
+
 	// Get the existing object packs.
 	hs, err := pos.ObjectPacks()
 	if err != nil {
@@ -1751,11 +1890,22 @@ func (r *Repository) RepackObjects(cfg *RepackConfig) (err error) {
 	}
 
 	// Create a new pack.
-	nh, err := r.createNewObjectPack(cfg)
+	nh, err := r.createNewObjectPack(cfg, hs)
 	if err != nil {
 		return err
 	}
 
+	if cfg.WriteBitmap {
+		s, ok := r.Storer.(gcStorer)
+		if !ok {
+			return ErrPackedObjectsNotSupported
+		}
+
+		if err := r.writePackBitmap(s, nh); err != nil {
+			return err
+		}
+	}
+
 	// Delete old packs.
 	for _, h := range hs {
 		// Skip if new hash is the same as an old one.
@@ -1768,6 +1918,17 @@ func (r *Repository) RepackObjects(cfg *RepackConfig) (err error) {
 		}
 	}
 
+	if s, ok := r.Storer.(interface{ Filesystem() billy.Filesystem }); ok {
+		if err := removeStaleMultiPackIndex(s.Filesystem()); err != nil {
+			return err
+		}
+	}
+
+	// The objects of the deleted packs are now in the new one.
+	if rs, ok := r.Storer.(interface{ Reindex() }); ok {
+		rs.Reindex()
+	}
+
 	return nil
 }
 
@@ -1777,11 +1938,26 @@ func (r *Repository) RepackObjects(cfg *RepackConfig) (err error) {
 // the HEAD for the current branch. Possible errors include:
 //   - The merge strategy is not supported.
 //   - The specific strategy cannot be used (e.g. using FastForwardMerge when one is not possible).
+//
+// When using RecursiveMerge a merge resulting in conflicts returns
+// ErrMergeConflict, leaving the conflicts in the index and worktree.
 func (r *Repository) Merge(ref plumbing.Reference, opts MergeOptions) error {
-	if opts.Strategy != FastForwardMerge {
-		return ErrUnsupportedMergeStrategy
+	switch opts.Strategy {
+	case FastForwardMerge:
+		return r.fastForwardMerge(ref)
+	case RecursiveMerge:
+		w, err := r.Worktree()
+		if err != nil {
+			return err
+		}
+
+		return w.merge(ref.Hash(), ref.Name(), &opts, "merge "+ref.Name().Short())
 	}
 
+	return ErrUnsupportedMergeStrategy
+}
+
+func (r *Repository) fastForwardMerge(ref plumbing.Reference) error {
 	// Ignore error as not having a shallow list is optional here.
 	shallowList, _ := r.Storer.Shallow()
 	var earliestShallow *plumbing.Hash
@@ -1803,13 +1979,16 @@ func (r *Repository) Merge(ref plumbing.Reference, opts MergeOptions) error {
 		return ErrFastForwardMergeNotPossible
 	}
 
-	return r.Storer.SetReference(plumbing.NewHashReference(head.Name(), ref.Hash()))
+	return newRefLogger(r.Storer, nil).setReference(
+		plumbing.NewHashReference(head.Name(), ref.Hash()), nil,
+		fmt.Sprintf("merge %s: Fast-forward", ref.Name().Short()),
+	)
 }
 
 // createNewObjectPack is a helper for RepackObjects taking care
-// of creating a new pack. It is used so the PackfileWriter
-// deferred close has the right scope.
-func (r *Repository) createNewObjectPack(cfg *RepackConfig) (h plumbing.Hash, err error) {
+// of creating a new pack, reusing the entries of the given packs
+// if configured.
+func (r *Repository) createNewObjectPack(cfg *RepackConfig, packs []plumbing.Hash) (h plumbing.Hash, err error) {
 	ow := newObjectWalker(r.Storer)
 	err = ow.walkAllRefs()
 	if err != nil {
@@ -1819,21 +1998,12 @@ func (r *Repository) createNewObjectPack(cfg *RepackConfig) (h plumbing.Hash, er
 	for h := range ow.seen {
 		objs = append(objs, h)
 	}
-	pfw, ok := r.Storer.(storer.PackfileWriter)
-	if !ok {
-		return h, fmt.Errorf("Repository storer is not a storer.PackfileWriter")
-	}
-	wc, err := pfw.PackfileWriter()
-	if err != nil {
-		return h, err
-	}
-	defer ioutil.CheckClose(wc, &err)
-	scfg, err := r.Config()
-	if err != nil {
-		return h, err
+
+	if !cfg.ReuseDeltas {
+		packs = nil
 	}
-	enc := packfile.NewEncoder(wc, r.Storer, cfg.UseRefDeltas)
-	h, err = enc.Encode(objs, scfg.Pack.Window)
+
+	h, err = r.writeObjectPack(objs, cfg.UseRefDeltas, packs)
 	if err != nil {
 		return h, err
 	}
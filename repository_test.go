@@ -25,6 +25,8 @@ import (
 	"github.com/jesseduffield/go-git/v5/config"
 	"github.com/jesseduffield/go-git/v5/plumbing"
 	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	formatcfg "github.com/jesseduffield/go-git/v5/plumbing/format/config"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
 	"github.com/jesseduffield/go-git/v5/plumbing/object"
 	"github.com/jesseduffield/go-git/v5/plumbing/storer"
 	"github.com/jesseduffield/go-git/v5/plumbing/transport"
@@ -671,6 +673,39 @@ func (s *RepositorySuite) TestPlainInitWithOptions(c *C) {
 	c.Assert(ref.Name().String(), Equals, "refs/heads/foo")
 }
 
+func (s *RepositorySuite) TestPlainInitWithReftable(c *C) {
+	dir := c.MkDir()
+
+	r, err := PlainInitWithOptions(dir, &PlainInitOptions{
+		RefStorage: formatcfg.ReftableRefStorage,
+	})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Extensions.RefStorage, Equals, formatcfg.ReftableRefStorage)
+
+	hash := createCommit(c, r)
+	_, err = os.Stat(filepath.Join(dir, GitDirName, "refs", "heads", "master"))
+	c.Assert(err, NotNil)
+
+	r, err = PlainOpen(dir)
+	c.Assert(err, IsNil)
+
+	ref, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(ref.Name(), Equals, plumbing.Master)
+	c.Assert(ref.Hash(), Equals, hash)
+
+	_, err = PlainInitWithOptions(dir, &PlainInitOptions{
+		RefStorage: formatcfg.ReftableRefStorage,
+	})
+	c.Assert(err, Equals, ErrRepositoryAlreadyExists)
+
+	_, err = PlainInitWithOptions(c.MkDir(), &PlainInitOptions{RefStorage: "foo"})
+	c.Assert(errors.Is(err, filesystem.ErrUnsupportedRefStorage), Equals, true)
+}
+
 func (s *RepositorySuite) TestPlainInitAlreadyExists(c *C) {
 	dir := c.MkDir()
 
@@ -3151,7 +3186,13 @@ func (s *RepositorySuite) TestResolveRevision(c *C) {
 		"v1.0.0~1":                   "918c48b83bd081e863dbe1b80f8998f058cd8294",
 		"master~1":                   "918c48b83bd081e863dbe1b80f8998f058cd8294",
 		"918c48b83bd081e863dbe1b80f8998f058cd8294": "918c48b83bd081e863dbe1b80f8998f058cd8294",
-		"918c48b": "918c48b83bd081e863dbe1b80f8998f058cd8294", // odd number of hex digits
+		"918c48b":                 "918c48b83bd081e863dbe1b80f8998f058cd8294", // odd number of hex digits
+		":/binary file":           "35e85108805c84807bc66a02d91535e1e24b38b9",
+		":/!-some":                "6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"HEAD:CHANGELOG":          "d3ff53e0564a9f87d8e84b6e28e5060e517008aa",
+		"HEAD:":                   "a8d315b2b1c615d43042c3a62402b8a54288cf5c",
+		"HEAD~1:go":               "a39771a7651f97faf5c72e08224d857fc35133db",
+		"master:./json/long.json": "49c6bb89b17060d7b4deacb7b338fcc6ea2352a9",
 	}
 
 	for rev, hash := range datas {
@@ -3211,6 +3252,130 @@ func (s *RepositorySuite) TestResolveRevisionWithErrors(c *C) {
 	}
 }
 
+func (s *RepositorySuite) TestResolveRevisionReflog(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	first := commitFiles(c, w, map[string]*string{"a": str("a\n")})
+	second := commitFiles(c, w, map[string]*string{"a": str("b\n")})
+
+	err = w.Checkout(&CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature"), Create: true})
+	c.Assert(err, IsNil)
+	third := commitFiles(c, w, map[string]*string{"a": str("c\n")})
+
+	datas := map[string]plumbing.Hash{
+		"master@{0}":                      second,
+		"master@{1}":                      first,
+		"master@{1}:a":                    plumbing.ComputeHash(plumbing.BlobObject, []byte("a\n")),
+		"feature@{1}":                     second,
+		"@{1}":                            second,
+		"HEAD@{1}":                        second,
+		"HEAD@{2}~1":                      first,
+		"@{-1}":                           second,
+		"master@{2000-01-01T00:00:00Z}":   first,
+		"feature@{2100-01-01T00:00:00Z}":  third,
+		"refs/heads/master@{1}^{/commit}": first,
+	}
+
+	for rev, hash := range datas {
+		h, err := r.ResolveRevision(plumbing.Revision(rev))
+
+		c.Assert(err, IsNil, Commentf("while checking %s", rev))
+		c.Check(*h, Equals, hash, Commentf("while checking %s", rev))
+	}
+
+	_, err = r.ResolveRevision("master@{2}")
+	c.Assert(errors.Is(err, ErrReflogEntryNotFound), Equals, true)
+
+	_, err = r.ResolveRevision("@{-2}")
+	c.Assert(errors.Is(err, ErrReflogEntryNotFound), Equals, true)
+}
+
+func (s *RepositorySuite) TestResolveRevisionUpstream(c *C) {
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	local := commitFiles(c, w, map[string]*string{"a": str("a\n")})
+
+	err = w.Checkout(&CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature"), Create: true})
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Branches["feature"] = &config.Branch{
+		Name:   "feature",
+		Remote: ".",
+		Merge:  plumbing.Master,
+	}
+	cfg.Raw.Section("branch").Subsection("master").SetOption("pushRemote", "fork")
+	cfg.Remotes["fork"] = &config.RemoteConfig{
+		Name:  "fork",
+		URLs:  []string{"https://example.com/fork.git"},
+		Fetch: []config.RefSpec{"+refs/heads/*:refs/remotes/fork/*"},
+	}
+	err = r.SetConfig(cfg)
+	c.Assert(err, IsNil)
+
+	head := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	fork := plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	err = r.Storer.SetReference(plumbing.NewHashReference("refs/remotes/fork/master", fork))
+	c.Assert(err, IsNil)
+
+	datas := map[string]plumbing.Hash{
+		"master@{upstream}": head,
+		"master@{u}~1":      plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+		"master@{push}":     fork,
+		"@{u}":              local,
+		"@{push}":           local,
+	}
+
+	for rev, hash := range datas {
+		h, err := r.ResolveRevision(plumbing.Revision(rev))
+
+		c.Assert(err, IsNil, Commentf("while checking %s", rev))
+		c.Check(*h, Equals, hash, Commentf("while checking %s", rev))
+	}
+
+	err = w.Checkout(&CheckoutOptions{Branch: plumbing.NewBranchReferenceName("other"), Create: true})
+	c.Assert(err, IsNil)
+
+	_, err = r.ResolveRevision("@{u}")
+	c.Assert(errors.Is(err, ErrNoUpstream), Equals, true)
+}
+
+func (s *RepositorySuite) TestResolveRevisionIndex(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	commitFiles(c, w, map[string]*string{"a": str("a\n")})
+
+	err = util.WriteFile(w.Filesystem, "a", []byte("staged\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("a")
+	c.Assert(err, IsNil)
+
+	h, err := r.ResolveRevision(":a")
+	c.Assert(err, IsNil)
+	c.Assert(*h, Equals, plumbing.ComputeHash(plumbing.BlobObject, []byte("staged\n")))
+
+	h, err = r.ResolveRevision("HEAD:a")
+	c.Assert(err, IsNil)
+	c.Assert(*h, Equals, plumbing.ComputeHash(plumbing.BlobObject, []byte("a\n")))
+
+	_, err = r.ResolveRevision(":2:a")
+	c.Assert(err, Equals, index.ErrEntryNotFound)
+}
+
 func (s *RepositorySuite) testRepackObjects(
 	c *C, deleteTime time.Time, expectedPacks int) {
 	srcFs := fixtures.ByTag("unpacked").One().DotGit()
@@ -0,0 +1,319 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jesseduffield/go-git/v5/config"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+)
+
+var (
+	// ErrNoUpstream is returned when resolving @{upstream} for a branch
+	// without upstream configured.
+	ErrNoUpstream = errors.New("no upstream configured for branch")
+	// ErrReflogEntryNotFound is returned when resolving <refname>@{<n>} or
+	// @{-<n>} beyond the entries of the reflog.
+	ErrReflogEntryNotFound = errors.New("reflog entry not found")
+)
+
+// checkoutReflogRegexp matches the reflog messages of checkouts, capturing
+// the checked out branch or commit.
+var checkoutReflogRegexp = regexp.MustCompile(`^checkout: moving from (\S+) to \S+$`)
+
+// expandRefName returns the full name of a, possibly short, reference name,
+// following the same rules than git. An empty name stands for the branch
+// checked out, or HEAD when it is detached.
+func (r *Repository) expandRefName(name string) (plumbing.ReferenceName, error) {
+	if name == "" {
+		head, err := r.Storer.Reference(plumbing.HEAD)
+		if err != nil {
+			return "", err
+		}
+
+		if head.Type() == plumbing.SymbolicReference {
+			return head.Target(), nil
+		}
+
+		return plumbing.HEAD, nil
+	}
+
+	for _, rule := range plumbing.RefRevParseRules {
+		n := plumbing.ReferenceName(fmt.Sprintf(rule, name))
+		_, err := r.Storer.Reference(n)
+		if err == nil {
+			return n, nil
+		}
+
+		if err != plumbing.ErrReferenceNotFound {
+			return "", err
+		}
+	}
+
+	return "", plumbing.ErrReferenceNotFound
+}
+
+// resolveReflogEntry resolves <name>@{<n>}, the value of the reference
+// before its n latest updates.
+func (r *Repository) resolveReflogEntry(name string, n int) (plumbing.Hash, error) {
+	refName, err := r.expandRefName(name)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	entries, err := r.Reflog(refName)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if n == 0 && len(entries) == 0 {
+		// Like git, @{0} is the current value even without reflog.
+		return r.resolveRefHash(refName)
+	}
+
+	if n >= len(entries) {
+		return plumbing.ZeroHash, fmt.Errorf("%w: log for %s only has %d entries", ErrReflogEntryNotFound, refName, len(entries))
+	}
+
+	return entries[n].New, nil
+}
+
+// resolveReflogDate resolves <name>@{<date>}, the value of the reference at
+// the given date. Like git, the oldest known value is used for dates older
+// than the reflog.
+func (r *Repository) resolveReflogDate(name string, date time.Time) (plumbing.Hash, error) {
+	refName, err := r.expandRefName(name)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	entries, err := r.Reflog(refName)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if len(entries) == 0 {
+		return r.resolveRefHash(refName)
+	}
+
+	for _, e := range entries {
+		if !e.Committer.When.After(date) {
+			return e.New, nil
+		}
+	}
+
+	oldest := entries[len(entries)-1]
+	if oldest.Old.IsZero() {
+		return oldest.New, nil
+	}
+
+	return oldest.Old, nil
+}
+
+// resolvePreviousCheckout resolves @{-<n>}, the branch or commit checked out
+// before the n-th latest checkout.
+func (r *Repository) resolvePreviousCheckout(n int) (plumbing.Hash, error) {
+	entries, err := r.Reflog(plumbing.HEAD)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	for _, e := range entries {
+		m := checkoutReflogRegexp.FindStringSubmatch(e.Message)
+		if m == nil {
+			continue
+		}
+
+		if n--; n > 0 {
+			continue
+		}
+
+		if plumbing.IsHash(m[1]) {
+			return plumbing.NewHash(m[1]), nil
+		}
+
+		return r.resolveRefHash(plumbing.NewBranchReferenceName(m[1]))
+	}
+
+	return plumbing.ZeroHash, fmt.Errorf("%w: no previous checkout", ErrReflogEntryNotFound)
+}
+
+// resolveUpstream resolves <branch>@{upstream}, the reference the branch
+// merges from, as configured by branch.<name>.remote and
+// branch.<name>.merge.
+func (r *Repository) resolveUpstream(name string) (plumbing.ReferenceName, error) {
+	branch, err := r.revisionBranch(name)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+
+	b, ok := cfg.Branches[branch.Short()]
+	if !ok || b.Remote == "" || b.Merge == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoUpstream, branch.Short())
+	}
+
+	if b.Remote == "." {
+		return b.Merge, nil
+	}
+
+	return remoteTrackingReference(cfg, b.Remote, b.Merge)
+}
+
+// resolvePush resolves <branch>@{push}, the remote-tracking reference of
+// the branch the branch would be pushed to. The branch is pushed to the remote
+// given by branch.<name>.pushRemote, remote.pushDefault or
+// branch.<name>.remote, to a branch with the same name.
+func (r *Repository) resolvePush(name string) (plumbing.ReferenceName, error) {
+	branch, err := r.revisionBranch(name)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+
+	remote := cfg.Raw.Section("branch").Subsection(branch.Short()).Option("pushRemote")
+	if remote == "" {
+		remote = cfg.Raw.Section("remote").Option("pushDefault")
+	}
+
+	if b, ok := cfg.Branches[branch.Short()]; ok && remote == "" {
+		remote = b.Remote
+	}
+
+	if remote == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoUpstream, branch.Short())
+	}
+
+	if remote == "." {
+		return branch, nil
+	}
+
+	return remoteTrackingReference(cfg, remote, branch)
+}
+
+// revisionBranch returns the branch given to @{upstream} and @{push}, the
+// branch checked out by default.
+func (r *Repository) revisionBranch(name string) (plumbing.ReferenceName, error) {
+	branch, err := r.expandRefName(name)
+	if err != nil {
+		return "", err
+	}
+
+	if !branch.IsBranch() {
+		return "", fmt.Errorf("%w: %s is not a branch", ErrNoUpstream, branch)
+	}
+
+	return branch, nil
+}
+
+// remoteTrackingReference returns the reference where the given branch of the
+// remote is fetched to.
+func remoteTrackingReference(cfg *config.Config, remote string, branch plumbing.ReferenceName) (plumbing.ReferenceName, error) {
+	rc, ok := cfg.Remotes[remote]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrRemoteNotFound, remote)
+	}
+
+	for _, spec := range rc.Fetch {
+		if spec.Match(branch) {
+			return spec.Dst(branch), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s is not fetched from %s", ErrNoUpstream, branch, remote)
+}
+
+// resolveRefHash returns the hash the reference points to.
+func (r *Repository) resolveRefHash(name plumbing.ReferenceName) (plumbing.Hash, error) {
+	ref, err := storer.ResolveReference(r.Storer, name)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return ref.Hash(), nil
+}
+
+// resolveMessageRegexp resolves :/<regexp>, the youngest commit reachable
+// from any reference whose message matches.
+func (r *Repository) resolveMessageRegexp(re *regexp.Regexp, negate bool) (*object.Commit, error) {
+	iter, err := r.Log(&LogOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var found *object.Commit
+	err = iter.ForEach(func(c *object.Commit) error {
+		if re.MatchString(c.Message) == negate {
+			return nil
+		}
+
+		if found == nil || c.Committer.When.After(found.Committer.When) {
+			found = c
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("no commit message match regexp: %q", re.String())
+	}
+
+	return found, nil
+}
+
+// resolveTreePath resolves <rev>:<path>, the blob or tree at the path of the
+// tree of the commit. An empty path is the tree itself.
+func resolveTreePath(c *object.Commit, p string) (plumbing.Hash, error) {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return c.TreeHash, nil
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	e, err := tree.FindEntry(p)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return e.Hash, nil
+}
+
+// resolveIndexPath resolves :<n>:<path>, the blob at the path of the index
+// in the given stage.
+func (r *Repository) resolveIndexPath(p string, stage index.Stage) (plumbing.Hash, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	p = strings.Trim(path.Clean("/"+p), "/")
+	for _, e := range idx.Entries {
+		if e.Name == p && e.Stage == stage {
+			return e.Hash, nil
+		}
+	}
+
+	return plumbing.ZeroHash, index.ErrEntryNotFound
+}
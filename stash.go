@@ -0,0 +1,505 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+)
+
+var (
+	// ErrNoLocalChanges is returned when stashing a clean worktree.
+	ErrNoLocalChanges = errors.New("no local changes to save")
+	// ErrStashNotFound is returned when the given stash does not exist.
+	ErrStashNotFound = errors.New("stash entry not found")
+	// ErrStashIndexConflict is returned when the staged changes of a stash
+	// can not be restored cleanly.
+	ErrStashIndexConflict = errors.New("conflicts in index, try without restoring the index")
+	// ErrUntrackedFileExists is returned when applying a stash would
+	// overwrite an untracked file.
+	ErrUntrackedFileExists = errors.New("untracked file already exists")
+)
+
+// Stash is an entry of the stash list.
+type Stash struct {
+	// Index is the position of the stash in the list, as in stash@{Index}.
+	Index int
+	// Hash is the hash of the stash commit.
+	Hash plumbing.Hash
+	// Message describes the stash.
+	Message string
+}
+
+// StashPush saves the local changes in a new stash and resets the worktree
+// and the index to HEAD. Like git, the stash is a commit of the worktree whose
+// parents are HEAD, a commit of the index and, when untracked files are
+// included, a commit of them.
+func (w *Worktree) StashPush(opts *StashOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(w.r); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	headCommit, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	s, err := w.Status()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Stage != index.Merged {
+			return plumbing.ZeroHash, ErrUnmergedPaths
+		}
+	}
+
+	indexTree, err := w.buildTree(idx)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	// The worktree commit holds the index updated with the changes of the
+	// tracked files.
+	wtIdx := copyIndex(idx)
+	var changed, untracked []string
+	for path, fs := range s {
+		if fs.Staging == Untracked && fs.Worktree == Untracked {
+			untracked = append(untracked, path)
+			continue
+		}
+
+		changed = append(changed, path)
+		if fs.Worktree == Modified || fs.Worktree == Deleted {
+			if _, _, err := w.doAddFile(wtIdx, nil, path, nil); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+	}
+
+	wtTree, err := w.buildTree(wtIdx)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if !opts.IncludeUntracked {
+		untracked = nil
+	}
+
+	if wtTree == headCommit.TreeHash && indexTree == headCommit.TreeHash && len(untracked) == 0 {
+		return plumbing.ZeroHash, ErrNoLocalChanges
+	}
+
+	branch := "(no branch)"
+	if ref, err := w.r.Storer.Reference(plumbing.HEAD); err == nil && ref.Type() == plumbing.SymbolicReference {
+		branch = ref.Target().Short()
+	}
+
+	info := fmt.Sprintf("%s: %s %s", branch, headCommit.Hash.String()[:7], commitSubject(headCommit))
+	co := &CommitOptions{Author: opts.Author, Committer: opts.Author}
+
+	co.Parents = []plumbing.Hash{headCommit.Hash}
+	indexCommit, err := w.buildCommitObject(fmt.Sprintf("index on %s\n", info), co, indexTree)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	co.Parents = []plumbing.Hash{headCommit.Hash, indexCommit}
+	if len(untracked) > 0 {
+		untrackedIdx := &index.Index{Version: idx.Version}
+		for _, path := range untracked {
+			if _, _, err := w.doAddFile(untrackedIdx, nil, path, nil); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+
+		untrackedTree, err := w.buildTree(untrackedIdx)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		untrackedCommit, err := w.buildCommitObject(fmt.Sprintf("untracked files on %s\n", info),
+			&CommitOptions{Author: opts.Author, Committer: opts.Author}, untrackedTree)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		co.Parents = append(co.Parents, untrackedCommit)
+	}
+
+	msg := fmt.Sprintf("WIP on %s", info)
+	if opts.Message != "" {
+		msg = fmt.Sprintf("On %s: %s", branch, strings.TrimSpace(opts.Message))
+	}
+
+	stash, err := w.buildCommitObject(msg+"\n", co, wtTree)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	entries, err := w.r.stashLog()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	old := plumbing.ZeroHash
+	if len(entries) > 0 {
+		old = entries[len(entries)-1].New
+	}
+
+	entries = append(entries, &reflog.Entry{
+		Old: old,
+		New: stash,
+		Committer: reflog.Signature{
+			Name:  opts.Author.Name,
+			Email: opts.Author.Email,
+			When:  opts.Author.When,
+		},
+		Message: msg,
+	})
+	if err := w.r.setStashLog(entries); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	// Only the changed paths are reset, so the untracked files are kept.
+	if len(changed) > 0 {
+		err := w.Reset(&ResetOptions{Commit: headCommit.Hash, Mode: HardReset, Files: changed})
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	for _, path := range untracked {
+		if err := w.Filesystem.Remove(path); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	return stash, nil
+}
+
+// StashApply applies the changes of a stash on top of HEAD, keeping it in the
+// stash list. The worktree must be clean. If the changes can not be applied
+// cleanly ErrMergeConflict is returned and the conflicts are left in the
+// index and the worktree.
+func (w *Worktree) StashApply(opts *StashApplyOptions) error {
+	stash, err := w.r.stash(opts.Stash)
+	if err != nil {
+		return err
+	}
+
+	clean, err := w.isClean()
+	if err != nil {
+		return err
+	}
+
+	if !clean {
+		return ErrWorktreeNotClean
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return err
+	}
+
+	headCommit, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	stashCommit, err := w.r.CommitObject(stash.Hash)
+	if err != nil {
+		return err
+	}
+
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return err
+	}
+
+	stashTree, err := stashCommit.Tree()
+	if err != nil {
+		return err
+	}
+
+	parents := make([]*object.Commit, 0, stashCommit.NumParents())
+	err = stashCommit.Parents().ForEach(func(c *object.Commit) error {
+		parents = append(parents, c)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(parents) < 2 {
+		return fmt.Errorf("%s is not a stash commit", stash.Hash)
+	}
+
+	baseTree, err := parents[0].Tree()
+	if err != nil {
+		return err
+	}
+
+	var indexTree *object.Tree
+	if opts.RestoreIndex {
+		t, err := parents[1].Tree()
+		if err != nil {
+			return err
+		}
+
+		result, err := object.MergeTrees(w.r.Storer, baseTree, headTree, t, nil)
+		if err != nil {
+			return err
+		}
+
+		if len(result.Conflicts) > 0 {
+			return ErrStashIndexConflict
+		}
+
+		if indexTree, err = w.r.TreeObject(result.Tree); err != nil {
+			return err
+		}
+	}
+
+	var untracked *object.Tree
+	if len(parents) > 2 {
+		if untracked, err = parents[2].Tree(); err != nil {
+			return err
+		}
+
+		if err := w.checkUntrackedFiles(untracked); err != nil {
+			return err
+		}
+	}
+
+	result, err := object.MergeTrees(w.r.Storer, baseTree, headTree, stashTree, &object.MergeTreesOptions{
+		OursLabel:     "Updated upstream",
+		TheirsLabel:   "Stashed changes",
+		RenameOptions: object.DefaultDiffTreeOptions,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(result.Conflicts) > 0 {
+		err = w.checkoutConflicts(result, "", plumbing.ZeroHash)
+		if untracked != nil {
+			if err := w.checkoutUntrackedFiles(untracked); err != nil {
+				return err
+			}
+		}
+
+		return err
+	}
+
+	t, err := w.r.TreeObject(result.Tree)
+	if err != nil {
+		return err
+	}
+
+	if err := w.resetIndex(t, nil, nil); err != nil {
+		return err
+	}
+
+	if err := w.resetWorktree(t, nil); err != nil {
+		return err
+	}
+
+	if indexTree != nil {
+		if err := w.resetIndex(indexTree, nil, nil); err != nil {
+			return err
+		}
+	} else if err := w.unstageStashChanges(headTree, t); err != nil {
+		return err
+	}
+
+	if untracked != nil {
+		return w.checkoutUntrackedFiles(untracked)
+	}
+
+	return nil
+}
+
+// StashPop applies a stash, like StashApply, and drops it if it was applied
+// cleanly.
+func (w *Worktree) StashPop(opts *StashApplyOptions) error {
+	if err := w.StashApply(opts); err != nil {
+		return err
+	}
+
+	return w.r.StashDrop(opts.Stash)
+}
+
+// unstageStashChanges restores in the index the paths of HEAD modified or
+// deleted by an applied stash, leaving its changes unstaged. Like git, files
+// added by the stash are kept in the index.
+func (w *Worktree) unstageStashChanges(headTree, applied *object.Tree) error {
+	changes, err := object.DiffTree(headTree, applied)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for _, ch := range changes {
+		if ch.From.Name != "" {
+			files = append(files, ch.From.Name)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	return w.resetIndex(headTree, nil, files)
+}
+
+// checkUntrackedFiles fails if any file of the tree exists in the worktree.
+func (w *Worktree) checkUntrackedFiles(t *object.Tree) error {
+	return t.Files().ForEach(func(f *object.File) error {
+		if _, err := w.Filesystem.Lstat(f.Name); err == nil {
+			return fmt.Errorf("%w: %s", ErrUntrackedFileExists, f.Name)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// checkoutUntrackedFiles writes the files of the tree to the worktree,
+// without adding them to the index.
+func (w *Worktree) checkoutUntrackedFiles(t *object.Tree) error {
+	return t.Files().ForEach(func(f *object.File) error {
+		return w.checkoutFile(f)
+	})
+}
+
+// buildTree writes the tree objects of the given index and returns the hash
+// of the root tree.
+func (w *Worktree) buildTree(idx *index.Index) (plumbing.Hash, error) {
+	h := &buildTreeHelper{
+		fs: w.Filesystem,
+		s:  w.r.Storer,
+	}
+
+	return h.BuildTree(idx, &CommitOptions{})
+}
+
+// copyIndex returns a copy of the entries of idx which can be modified
+// without altering idx.
+func copyIndex(idx *index.Index) *index.Index {
+	c := &index.Index{Version: idx.Version, Entries: make([]*index.Entry, 0, len(idx.Entries))}
+	for _, e := range idx.Entries {
+		entry := *e
+		c.Entries = append(c.Entries, &entry)
+	}
+
+	return c
+}
+
+// StashList returns the stash list, the latest stash first.
+func (r *Repository) StashList() ([]*Stash, error) {
+	entries, err := r.stashLog()
+	if err != nil {
+		return nil, err
+	}
+
+	stashes := make([]*Stash, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		stashes = append(stashes, &Stash{
+			Index:   len(stashes),
+			Hash:    entries[i].New,
+			Message: entries[i].Message,
+		})
+	}
+
+	return stashes, nil
+}
+
+// StashDrop removes a stash from the stash list, given its position.
+func (r *Repository) StashDrop(n int) error {
+	entries, err := r.stashLog()
+	if err != nil {
+		return err
+	}
+
+	i := len(entries) - 1 - n
+	if n < 0 || i < 0 {
+		return ErrStashNotFound
+	}
+
+	// Keep the chain of old and new hashes, like git reflog delete --rewrite.
+	if i+1 < len(entries) {
+		entries[i+1].Old = entries[i].Old
+	}
+
+	return r.setStashLog(append(entries[:i], entries[i+1:]...))
+}
+
+// StashClear removes all the stashes.
+func (r *Repository) StashClear() error {
+	return r.setStashLog(nil)
+}
+
+func (r *Repository) stash(n int) (*Stash, error) {
+	stashes, err := r.StashList()
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 || n >= len(stashes) {
+		return nil, ErrStashNotFound
+	}
+
+	return stashes[n], nil
+}
+
+// stashLog returns the entries of the reflog of refs/stash, oldest first.
+func (r *Repository) stashLog() ([]*reflog.Entry, error) {
+	rs, err := r.reflogStorer()
+	if err != nil {
+		return nil, err
+	}
+
+	return rs.Reflog(plumbing.Stash)
+}
+
+// setStashLog writes the reflog of refs/stash, pointing refs/stash to the
+// latest entry, or removing both if there are no entries.
+func (r *Repository) setStashLog(entries []*reflog.Entry) error {
+	rs, err := r.reflogStorer()
+	if err != nil {
+		return err
+	}
+
+	if err := rs.SetReflog(plumbing.Stash, entries); err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		err := r.Storer.RemoveReference(plumbing.Stash)
+		if err == plumbing.ErrReferenceNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	return r.Storer.SetReference(plumbing.NewHashReference(plumbing.Stash, entries[len(entries)-1].New))
+}
@@ -0,0 +1,271 @@
+package git
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type StashSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&StashSuite{})
+
+func (s *StashSuite) setupStash(c *C) (*Repository, *Worktree) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]*string{"a": str("a\n"), "b": str("b\n")})
+	return r, w
+}
+
+func (s *StashSuite) TestStashPushApply(c *C) {
+	r, w := s.setupStash(c)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(w.Filesystem, "a", []byte("modified\n"), 0644)
+	c.Assert(err, IsNil)
+	err = util.WriteFile(w.Filesystem, "c", []byte("c\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("c")
+	c.Assert(err, IsNil)
+	_, err = w.Remove("b")
+	c.Assert(err, IsNil)
+	err = util.WriteFile(w.Filesystem, "untracked", []byte("u\n"), 0644)
+	c.Assert(err, IsNil)
+
+	h, err := w.StashPush(&StashOptions{Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 1)
+	c.Assert(status.File("untracked").Worktree, Equals, Untracked)
+
+	stash, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(stash.ParentHashes, HasLen, 2)
+	c.Assert(stash.ParentHashes[0], Equals, head.Hash())
+	c.Assert(stash.Message, Equals, "WIP on master: "+head.Hash().String()[:7]+" commit\n")
+
+	index, err := r.CommitObject(stash.ParentHashes[1])
+	c.Assert(err, IsNil)
+	c.Assert(index.ParentHashes, DeepEquals, []plumbing.Hash{head.Hash()})
+
+	_, err = index.File("c")
+	c.Assert(err, IsNil)
+	f, err := index.File("a")
+	c.Assert(err, IsNil)
+	content, err := f.Contents()
+	c.Assert(err, IsNil)
+	c.Assert(content, Equals, "a\n")
+
+	f, err = stash.File("a")
+	c.Assert(err, IsNil)
+	content, err = f.Contents()
+	c.Assert(err, IsNil)
+	c.Assert(content, Equals, "modified\n")
+
+	stashes, err := r.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(stashes, HasLen, 1)
+	c.Assert(stashes[0].Hash, Equals, h)
+	c.Assert(stashes[0].Message, Equals, "WIP on master: "+head.Hash().String()[:7]+" commit")
+
+	ref, err := r.Reference(plumbing.Stash, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, h)
+
+	err = w.StashPop(&StashApplyOptions{})
+	c.Assert(err, IsNil)
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("a").Staging, Equals, Unmodified)
+	c.Assert(status.File("a").Worktree, Equals, Modified)
+	c.Assert(status.File("b").Staging, Equals, Unmodified)
+	c.Assert(status.File("b").Worktree, Equals, Deleted)
+	c.Assert(status.File("c").Staging, Equals, Added)
+
+	stashes, err = r.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(stashes, HasLen, 0)
+
+	_, err = r.Reference(plumbing.Stash, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *StashSuite) TestStashApplyRestoreIndex(c *C) {
+	r, w := s.setupStash(c)
+
+	err := util.WriteFile(w.Filesystem, "a", []byte("staged\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("a")
+	c.Assert(err, IsNil)
+	err = util.WriteFile(w.Filesystem, "b", []byte("unstaged\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.StashPush(&StashOptions{Message: "work", Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	stashes, err := r.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(stashes[0].Message, Equals, "On master: work")
+
+	err = w.StashApply(&StashApplyOptions{RestoreIndex: true})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("a").Staging, Equals, Modified)
+	c.Assert(status.File("a").Worktree, Equals, Unmodified)
+	c.Assert(status.File("b").Staging, Equals, Unmodified)
+	c.Assert(status.File("b").Worktree, Equals, Modified)
+
+	// Apply keeps the stash.
+	stashes, err = r.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(stashes, HasLen, 1)
+}
+
+func (s *StashSuite) TestStashIncludeUntracked(c *C) {
+	r, w := s.setupStash(c)
+
+	err := util.WriteFile(w.Filesystem, "untracked", []byte("u\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.StashPush(&StashOptions{Author: mergeSignature})
+	c.Assert(err, Equals, ErrNoLocalChanges)
+
+	h, err := w.StashPush(&StashOptions{IncludeUntracked: true, Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	stash, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(stash.ParentHashes, HasLen, 3)
+
+	untracked, err := r.CommitObject(stash.ParentHashes[2])
+	c.Assert(err, IsNil)
+	c.Assert(untracked.ParentHashes, HasLen, 0)
+	_, err = untracked.File("untracked")
+	c.Assert(err, IsNil)
+
+	_, err = w.Filesystem.Stat("untracked")
+	c.Assert(err, NotNil)
+
+	err = util.WriteFile(w.Filesystem, "untracked", []byte("other\n"), 0644)
+	c.Assert(err, IsNil)
+	err = w.StashApply(&StashApplyOptions{})
+	c.Assert(errors.Is(err, ErrUntrackedFileExists), Equals, true)
+
+	err = w.Filesystem.Remove("untracked")
+	c.Assert(err, IsNil)
+	err = w.StashPop(&StashApplyOptions{})
+	c.Assert(err, IsNil)
+
+	content, err := util.ReadFile(w.Filesystem, "untracked")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "u\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("untracked").Worktree, Equals, Untracked)
+}
+
+func (s *StashSuite) TestStashApplyConflict(c *C) {
+	r, w := s.setupStash(c)
+
+	err := util.WriteFile(w.Filesystem, "a", []byte("stashed\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = w.StashPush(&StashOptions{Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]*string{"a": str("committed\n")})
+
+	err = w.StashPop(&StashApplyOptions{})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	content, err := util.ReadFile(w.Filesystem, "a")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "<<<<<<< Updated upstream\ncommitted\n=======\nstashed\n>>>>>>> Stashed changes\n")
+
+	// The stash is kept when it can not be applied cleanly.
+	stashes, err := r.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(stashes, HasLen, 1)
+
+	_, err = r.Reference(plumbing.MergeHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *StashSuite) TestStashDropClear(c *C) {
+	dotgit := memfs.New()
+	r, err := Init(filesystem.NewStorage(dotgit, cache.NewObjectLRUDefault()), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	commitFiles(c, w, map[string]*string{"a": str("a\n")})
+
+	var hashes []plumbing.Hash
+	for _, content := range []string{"1\n", "2\n", "3\n"} {
+		err := util.WriteFile(w.Filesystem, "a", []byte(content), 0644)
+		c.Assert(err, IsNil)
+
+		h, err := w.StashPush(&StashOptions{Message: content, Author: mergeSignature})
+		c.Assert(err, IsNil)
+		hashes = append(hashes, h)
+	}
+
+	log, err := util.ReadFile(dotgit, "logs/refs/stash")
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+	c.Assert(lines, HasLen, 3)
+	c.Assert(lines[0], Equals, plumbing.ZeroHash.String()+" "+hashes[0].String()+
+		" go-git <go-git@fake.local> 1700000000 +0000\tOn master: 1")
+
+	err = r.StashDrop(1)
+	c.Assert(err, IsNil)
+
+	stashes, err := r.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(stashes, HasLen, 2)
+	c.Assert(stashes[0].Hash, Equals, hashes[2])
+	c.Assert(stashes[1].Hash, Equals, hashes[0])
+	c.Assert(stashes[1].Index, Equals, 1)
+
+	err = r.StashDrop(2)
+	c.Assert(err, Equals, ErrStashNotFound)
+
+	err = r.StashDrop(0)
+	c.Assert(err, IsNil)
+
+	ref, err := r.Reference(plumbing.Stash, false)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, hashes[0])
+
+	err = r.StashClear()
+	c.Assert(err, IsNil)
+
+	stashes, err = r.StashList()
+	c.Assert(err, IsNil)
+	c.Assert(stashes, HasLen, 0)
+
+	_, err = dotgit.Stat("logs/refs/stash")
+	c.Assert(err, NotNil)
+}
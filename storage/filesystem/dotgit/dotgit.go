@@ -50,6 +50,15 @@ const (
 	idxExt     = ".idx"
 )
 
+const (
+	// packedRefsHeader is the header of the packed-refs files written by
+	// PackRefs, whose refs are not all peeled.
+	packedRefsHeader = "# pack-refs with: sorted \n"
+	// packedRefsPeeledHeader is the header of the packed-refs files written
+	// by PackRefsPeeled, as written by git pack-refs.
+	packedRefsPeeledHeader = "# pack-refs with: peeled fully-peeled sorted \n"
+)
+
 var (
 	// ErrNotFound is returned by New when the path is not found.
 	ErrNotFound = errors.New("path not found")
@@ -75,6 +84,9 @@ var (
 	// ErrEmptyRefFile is returned when a reference file is attempted to be read,
 	// but the file is empty
 	ErrEmptyRefFile = errors.New("ref file is empty")
+	// ErrReferenceLocked is returned by a reference transaction when the
+	// lock file of a reference already exists.
+	ErrReferenceLocked = errors.New("reference is locked")
 )
 
 // Options holds configuration for the storage.
@@ -321,6 +333,17 @@ func (d *DotGit) ObjectPackIdx(hash plumbing.Hash) (billy.File, error) {
 	return d.objectPackOpen(hash, `idx`)
 }
 
+// ObjectPackBitmap returns a fs.File of the bitmap index file for a given
+// packfile
+func (d *DotGit) ObjectPackBitmap(hash plumbing.Hash) (billy.File, error) {
+	err := d.hasPack(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.objectPackOpen(hash, `bitmap`)
+}
+
 func (d *DotGit) DeleteOldObjectPackAndIndex(hash plumbing.Hash, t time.Time) error {
 	d.cleanPackList()
 
@@ -702,17 +725,21 @@ func (d *DotGit) checkReferenceAndTruncate(f billy.File, old *plumbing.Reference
 }
 
 func (d *DotGit) SetRef(r, old *plumbing.Reference) error {
-	var content string
+	fileName := r.Name().String()
+
+	return d.setRef(fileName, refContent(r), old)
+}
+
+// refContent returns the content of the file of a loose reference.
+func refContent(r *plumbing.Reference) string {
 	switch r.Type() {
 	case plumbing.SymbolicReference:
-		content = fmt.Sprintf("ref: %s\n", r.Target())
+		return fmt.Sprintf("ref: %s\n", r.Target())
 	case plumbing.HashReference:
-		content = fmt.Sprintln(r.Hash().String())
+		return fmt.Sprintln(r.Hash().String())
 	}
 
-	fileName := r.Name().String()
-
-	return d.setRef(fileName, content, old)
+	return ""
 }
 
 // Refs scans the git directory collecting references, which it returns.
@@ -898,6 +925,12 @@ func (d *DotGit) rewritePackedRefsWithoutRef(name plumbing.ReferenceName) (err e
 	}
 	defer ioutil.CheckClose(pr, &err)
 
+	return d.rewritePackedRefsWithout(pr, map[plumbing.ReferenceName]bool{name: true})
+}
+
+// rewritePackedRefsWithout rewrites the locked packed-refs file without the
+// given references, if it has any of them.
+func (d *DotGit) rewritePackedRefsWithout(pr billy.File, names map[plumbing.ReferenceName]bool) (err error) {
 	// Creating the temp file in the same directory as the target file
 	// improves our chances for rename operation to be atomic.
 	tmp, err := d.fs.TempFile("", tmpPackedRefsPrefix)
@@ -912,6 +945,7 @@ func (d *DotGit) rewritePackedRefsWithoutRef(name plumbing.ReferenceName) (err e
 
 	s := bufio.NewScanner(pr)
 	found := false
+	removed := false
 	for s.Scan() {
 		line := s.Text()
 		ref, err := d.processLine(line)
@@ -919,8 +953,13 @@ func (d *DotGit) rewritePackedRefsWithoutRef(name plumbing.ReferenceName) (err e
 			return err
 		}
 
-		if ref != nil && ref.Name() == name {
-			found = true
+		if ref != nil {
+			removed = names[ref.Name()]
+			found = found || removed
+		}
+
+		// The peeled hash of an annotated tag follows its reference.
+		if removed && (ref != nil || strings.HasPrefix(line, "^")) {
 			continue
 		}
 
@@ -1059,10 +1098,21 @@ func (d *DotGit) CountLooseRefs() (int, error) {
 // required during ref-packing.  But that would worsen performance in
 // the common case.
 //
+// Like git, the symbolic refs are kept loose. The peeled hashes of the refs
+// already packed are kept, the loose refs are not peeled, see PackRefsPeeled.
+//
 // TODO: add an "all" boolean like the `git pack-refs --all` flag.
 // When `all` is false, it would only pack refs that have already been
 // packed, plus all tags.
-func (d *DotGit) PackRefs() (err error) {
+func (d *DotGit) PackRefs() error {
+	return d.PackRefsPeeled(nil)
+}
+
+// PackRefsPeeled packs the loose refs like PackRefs, recording the object
+// each ref peels to, as returned by peel, which returns plumbing.ZeroHash for
+// the refs which are not annotated tags. The packed-refs file is then fully
+// peeled, as written by git pack-refs.
+func (d *DotGit) PackRefsPeeled(peel func(plumbing.Hash) (plumbing.Hash, error)) (err error) {
 	// Lock packed-refs, and create it if it doesn't exist yet.
 	f, err := d.openAndLockPackedRefs(true)
 	if err != nil {
@@ -1070,21 +1120,51 @@ func (d *DotGit) PackRefs() (err error) {
 	}
 	defer ioutil.CheckClose(f, &err)
 
-	// Gather all refs using addRefsFromRefDir and addRefsFromPackedRefs.
-	var refs []*plumbing.Reference
+	// Gather all refs using addRefsFromRefDir and the packed-refs file.
+	var loose []*plumbing.Reference
 	seen := make(map[plumbing.ReferenceName]bool)
-	if err = d.addRefsFromRefDir(&refs, seen); err != nil {
+	if err = d.addRefsFromRefDir(&loose, seen); err != nil {
 		return err
 	}
+
+	var refs []*plumbing.Reference
+	for _, ref := range loose {
+		if ref.Type() == plumbing.HashReference {
+			refs = append(refs, ref)
+		}
+	}
+
 	if len(refs) == 0 {
 		// Nothing to do!
 		return nil
 	}
 	numLooseRefs := len(refs)
-	if err = d.addRefsFromPackedRefsFile(&refs, f, seen); err != nil {
+
+	packed, peeled, err := d.readPackedRefsPeeled(f)
+	if err != nil {
 		return err
 	}
 
+	for _, ref := range packed {
+		if !seen[ref.Name()] {
+			refs = append(refs, ref)
+		}
+	}
+
+	if peel != nil {
+		peeled = make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+		for _, ref := range refs {
+			if peeled[ref.Name()], err = peel(ref.Hash()); err != nil {
+				return err
+			}
+		}
+	}
+
+	sorted := append([]*plumbing.Reference(nil), refs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name() < sorted[j].Name()
+	})
+
 	// Write them all to a new temp packed-refs file.
 	tmp, err := d.fs.TempFile("", tmpPackedRefsPrefix)
 	if err != nil {
@@ -1097,11 +1177,25 @@ func (d *DotGit) PackRefs() (err error) {
 	}()
 
 	w := bufio.NewWriter(tmp)
-	for _, ref := range refs {
-		_, err = w.WriteString(ref.String() + "\n")
-		if err != nil {
+	header := packedRefsHeader
+	if peel != nil {
+		header = packedRefsPeeledHeader
+	}
+
+	if _, err = w.WriteString(header); err != nil {
+		return err
+	}
+
+	for _, ref := range sorted {
+		if _, err = fmt.Fprintf(w, "%s %s\n", ref.Hash(), ref.Name()); err != nil {
 			return err
 		}
+
+		if h, ok := peeled[ref.Name()]; ok && !h.IsZero() {
+			if _, err = fmt.Fprintf(w, "^%s\n", h); err != nil {
+				return err
+			}
+		}
 	}
 	err = w.Flush()
 	if err != nil {
@@ -1127,6 +1221,35 @@ func (d *DotGit) PackRefs() (err error) {
 	return nil
 }
 
+// readPackedRefsPeeled reads the refs of a packed-refs file, with the peeled
+// hashes following them.
+func (d *DotGit) readPackedRefsPeeled(f billy.File) ([]*plumbing.Reference, map[plumbing.ReferenceName]plumbing.Hash, error) {
+	var refs []*plumbing.Reference
+	peeled := make(map[plumbing.ReferenceName]plumbing.Hash)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "^") {
+			if len(refs) > 0 {
+				peeled[refs[len(refs)-1].Name()] = plumbing.NewHash(line[1:])
+			}
+
+			continue
+		}
+
+		ref, err := d.processLine(line)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if ref != nil {
+			refs = append(refs, ref)
+		}
+	}
+
+	return refs, peeled, s.Err()
+}
+
 // Module return a billy.Filesystem pointing to the module folder
 func (d *DotGit) Module(name string) (billy.Filesystem, error) {
 	return d.fs.Chroot(d.fs.Join(modulePath, name))
@@ -808,6 +808,50 @@ func (s *SuiteDotGit) TestPackRefs(c *C) {
 	c.Assert(ref.Hash().String(), Equals, "b8d3ffab552895c19b9fcf7aa264d277cde33881")
 }
 
+func (s *SuiteDotGit) TestPackRefsSymbolicAndPeeled(c *C) {
+	fs := s.TemporalFilesystem(c)
+	dir := New(fs)
+
+	err := util.WriteFile(fs, packedRefsPath, []byte(""+
+		"# pack-refs with: peeled fully-peeled sorted \n"+
+		"b742a2a9fa0afcfa9a6fad080980fbc26b007c69 refs/tags/annotated\n"+
+		"^f7b877701fbf855b44c0a9e86f3fdce2c298b07f\n",
+	), 0644)
+	c.Assert(err, IsNil)
+
+	err = dir.SetRef(plumbing.NewReferenceFromStrings(
+		"refs/remotes/origin/master",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	), nil)
+	c.Assert(err, IsNil)
+	err = dir.SetRef(plumbing.NewSymbolicReference(
+		"refs/remotes/origin/HEAD",
+		"refs/remotes/origin/master",
+	), nil)
+	c.Assert(err, IsNil)
+
+	err = dir.PackRefs()
+	c.Assert(err, IsNil)
+
+	// The symbolic refs are kept loose, like git does.
+	looseCount, err := dir.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(looseCount, Equals, 1)
+
+	ref, err := dir.Ref("refs/remotes/origin/HEAD")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Type(), Equals, plumbing.SymbolicReference)
+
+	content, err := util.ReadFile(fs, packedRefsPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, ""+
+		"# pack-refs with: sorted \n"+
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881 refs/remotes/origin/master\n"+
+		"b742a2a9fa0afcfa9a6fad080980fbc26b007c69 refs/tags/annotated\n"+
+		"^f7b877701fbf855b44c0a9e86f3fdce2c298b07f\n",
+	)
+}
+
 func TestAlternatesDefault(t *testing.T) {
 	// Create a new dotgit object.
 	dotFS := osfs.New(t.TempDir())
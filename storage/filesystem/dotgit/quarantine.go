@@ -0,0 +1,199 @@
+package dotgit
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// quarantinePrefix is the prefix of the temporary object directories, the
+// same as the one of git receive-pack.
+const quarantinePrefix = "tmp_objdir-incoming-"
+
+// ObjectQuarantine is a temporary object directory, in the objects directory
+// of a DotGit. Its DotGit only has the objects of the temporary directory,
+// which are moved to the DotGit it was created from by Migrate.
+type ObjectQuarantine struct {
+	*DotGit
+	parent *DotGit
+	dir    string
+}
+
+// NewObjectQuarantine creates a temporary object directory, named like the
+// ones of git receive-pack.
+func (d *DotGit) NewObjectQuarantine() (*ObjectQuarantine, error) {
+	dir, err := util.TempDir(d.fs, objectsPath, quarantinePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.fs.MkdirAll(d.fs.Join(dir, packPath), os.ModeDir|os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	fs := &quarantineFilesystem{Filesystem: d.fs, dir: dir}
+	return &ObjectQuarantine{DotGit: New(fs), parent: d, dir: dir}, nil
+}
+
+// Migrate moves the objects of the quarantine to the objects directory of
+// the DotGit it was created from, and removes the quarantine. Like git, the
+// objects which already exist are not replaced, and the files of the packs
+// are moved before their indexes.
+func (q *ObjectQuarantine) Migrate() error {
+	if err := q.DotGit.Close(); err != nil {
+		return err
+	}
+
+	if err := q.migrate(""); err != nil {
+		return err
+	}
+
+	q.parent.cleanObjectList()
+	q.parent.cleanPackList()
+	q.parent.incomingChecked = false
+	q.parent.incomingDirName = ""
+
+	return q.Remove()
+}
+
+func (q *ObjectQuarantine) migrate(dir string) error {
+	fs := q.parent.fs
+	entries, err := fs.ReadDir(fs.Join(q.dir, dir))
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return packCopyPriority(entries[i].Name()) < packCopyPriority(entries[j].Name())
+	})
+
+	for _, e := range entries {
+		name := fs.Join(dir, e.Name())
+		if e.IsDir() {
+			if err := q.migrate(name); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		target := fs.Join(objectsPath, name)
+		if _, err := fs.Stat(target); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := fs.MkdirAll(fs.Join(objectsPath, dir), os.ModeDir|os.ModePerm); err != nil {
+			return err
+		}
+
+		if err := fs.Rename(fs.Join(q.dir, name), target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// packCopyPriority returns the order in which the files of the packs are
+// moved, the same as the one of git.
+func packCopyPriority(name string) int {
+	switch {
+	case !strings.HasPrefix(name, "pack"):
+		return 0
+	case strings.HasSuffix(name, ".keep"):
+		return 1
+	case strings.HasSuffix(name, ".pack"):
+		return 2
+	case strings.HasSuffix(name, ".rev"):
+		return 3
+	case strings.HasSuffix(name, ".idx"):
+		return 4
+	}
+
+	return 5
+}
+
+// Remove removes the quarantine and its objects.
+func (q *ObjectQuarantine) Remove() error {
+	if err := q.DotGit.Close(); err != nil {
+		return err
+	}
+
+	return util.RemoveAll(q.parent.fs, q.dir)
+}
+
+// quarantineFilesystem is the filesystem of a DotGit whose objects directory
+// is a temporary object directory. The paths in the objects directory are
+// mapped to the temporary directory, unless they are already in it, like the
+// names of the temporary files.
+type quarantineFilesystem struct {
+	billy.Filesystem
+	dir string
+}
+
+func (fs *quarantineFilesystem) path(name string) string {
+	clean := filepath.Clean(name)
+	if clean == fs.dir || strings.HasPrefix(clean, fs.dir+string(filepath.Separator)) {
+		return name
+	}
+
+	if clean == objectsPath {
+		return fs.dir
+	}
+
+	if rel := strings.TrimPrefix(clean, objectsPath+string(filepath.Separator)); rel != clean {
+		return fs.Filesystem.Join(fs.dir, rel)
+	}
+
+	return name
+}
+
+func (fs *quarantineFilesystem) Create(filename string) (billy.File, error) {
+	return fs.Filesystem.Create(fs.path(filename))
+}
+
+func (fs *quarantineFilesystem) Open(filename string) (billy.File, error) {
+	return fs.Filesystem.Open(fs.path(filename))
+}
+
+func (fs *quarantineFilesystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	return fs.Filesystem.OpenFile(fs.path(filename), flag, perm)
+}
+
+func (fs *quarantineFilesystem) Stat(filename string) (os.FileInfo, error) {
+	return fs.Filesystem.Stat(fs.path(filename))
+}
+
+func (fs *quarantineFilesystem) Lstat(filename string) (os.FileInfo, error) {
+	return fs.Filesystem.Lstat(fs.path(filename))
+}
+
+func (fs *quarantineFilesystem) Rename(oldpath, newpath string) error {
+	return fs.Filesystem.Rename(fs.path(oldpath), fs.path(newpath))
+}
+
+func (fs *quarantineFilesystem) Remove(filename string) error {
+	return fs.Filesystem.Remove(fs.path(filename))
+}
+
+func (fs *quarantineFilesystem) TempFile(dir, prefix string) (billy.File, error) {
+	return fs.Filesystem.TempFile(fs.path(dir), prefix)
+}
+
+func (fs *quarantineFilesystem) ReadDir(path string) ([]os.FileInfo, error) {
+	return fs.Filesystem.ReadDir(fs.path(path))
+}
+
+func (fs *quarantineFilesystem) MkdirAll(filename string, perm os.FileMode) error {
+	return fs.Filesystem.MkdirAll(fs.path(filename), perm)
+}
+
+func (fs *quarantineFilesystem) Chroot(path string) (billy.Filesystem, error) {
+	return fs.Filesystem.Chroot(fs.path(path))
+}
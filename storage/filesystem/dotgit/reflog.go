@@ -0,0 +1,82 @@
+package dotgit
+
+import (
+	"os"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// Reflog returns the entries of the reflog of the given reference, stored in
+// the logs directory, or no entries if the reference has no reflog.
+func (d *DotGit) Reflog(name plumbing.ReferenceName) (entries []*reflog.Entry, err error) {
+	f, err := d.fs.Open(d.reflogPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return reflog.NewDecoder(f).Decode()
+}
+
+// AppendReflog adds an entry at the end of the reflog of the given reference.
+func (d *DotGit) AppendReflog(name plumbing.ReferenceName, e *reflog.Entry) (err error) {
+	path := d.reflogPath(name)
+	if err := d.fs.MkdirAll(d.fs.Join(path, ".."), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := d.fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return reflog.NewEncoder(f).Encode(e)
+}
+
+// SetReflog replaces the entries of the reflog of the given reference. The
+// reflog is written to a lock file first, which then replaces it.
+func (d *DotGit) SetReflog(name plumbing.ReferenceName, entries []*reflog.Entry) error {
+	path := d.reflogPath(name)
+	if err := d.fs.MkdirAll(d.fs.Join(path, ".."), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+
+	lock := path + ".lock"
+	f, err := d.fs.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+
+	err = reflog.NewEncoder(f).Encode(entries...)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = d.fs.Remove(lock)
+		return err
+	}
+
+	return d.fs.Rename(lock, path)
+}
+
+// RemoveReflog removes the reflog of the given reference, if any.
+func (d *DotGit) RemoveReflog(name plumbing.ReferenceName) error {
+	err := d.fs.Remove(d.reflogPath(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (d *DotGit) reflogPath(name plumbing.ReferenceName) string {
+	return d.fs.Join(logsPath, name.String())
+}
@@ -0,0 +1,201 @@
+package dotgit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/storage"
+)
+
+const lockExt = ".lock"
+
+// ReferenceTransaction returns a new reference transaction. Like git, the
+// references are locked with lock files holding their new values, which are
+// renamed over the references on commit. The removed references are dropped
+// from the packed-refs file in a single rewrite.
+func (d *DotGit) ReferenceTransaction() storer.ReferenceTransaction {
+	return &referenceTransaction{d: d}
+}
+
+type referenceTransaction struct {
+	d       *DotGit
+	updates []storer.ReferenceUpdate
+	// locks are the lock files created by Prepare, in the order of the
+	// updates.
+	locks []string
+	// removed are the removed references which exist, and packed is the
+	// packed-refs file locked to rewrite it without them.
+	removed map[plumbing.ReferenceName]bool
+	packed  billy.File
+
+	prepared bool
+	closed   bool
+}
+
+func (t *referenceTransaction) SetReference(ref, old *plumbing.Reference) error {
+	return t.add(storer.ReferenceUpdate{Name: ref.Name(), Ref: ref, Old: old})
+}
+
+func (t *referenceTransaction) RemoveReference(name plumbing.ReferenceName, old *plumbing.Reference) error {
+	return t.add(storer.ReferenceUpdate{Name: name, Old: old})
+}
+
+func (t *referenceTransaction) add(u storer.ReferenceUpdate) error {
+	if t.prepared || t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	t.updates = append(t.updates, u)
+	return nil
+}
+
+func (t *referenceTransaction) Prepare() (err error) {
+	if t.prepared || t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	defer func() {
+		if err != nil {
+			t.closed = true
+			t.release()
+		}
+	}()
+
+	if err := storer.SortReferenceUpdates(t.updates); err != nil {
+		return err
+	}
+
+	t.removed = make(map[plumbing.ReferenceName]bool)
+	for _, u := range t.updates {
+		if err := t.lock(u); err != nil {
+			return err
+		}
+	}
+
+	if len(t.removed) > 0 {
+		if t.packed, err = t.d.openAndLockPackedRefs(false); err != nil {
+			return err
+		}
+	}
+
+	t.prepared = true
+	return nil
+}
+
+// lock creates the lock file of the updated reference, with its new value,
+// and checks its current value.
+func (t *referenceTransaction) lock(u storer.ReferenceUpdate) (err error) {
+	name := u.Name.String() + lockExt
+	f, err := t.d.fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return fmt.Errorf("%w: %s", ErrReferenceLocked, u.Name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	t.locks = append(t.locks, name)
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	current, err := t.d.Ref(u.Name)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		current, err = nil, nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !u.Expected(current) {
+		return storage.ErrReferenceHasChanged
+	}
+
+	if u.Ref == nil {
+		if current != nil {
+			t.removed[u.Name] = true
+		}
+
+		return nil
+	}
+
+	_, err = f.Write([]byte(refContent(u.Ref)))
+	return err
+}
+
+func (t *referenceTransaction) Commit() (err error) {
+	if !t.prepared {
+		if err := t.Prepare(); err != nil {
+			return err
+		}
+	}
+
+	if t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	t.closed = true
+	defer t.release()
+
+	if t.packed != nil {
+		err = t.d.rewritePackedRefsWithout(t.packed, t.removed)
+		if cerr := t.packed.Close(); err == nil {
+			err = cerr
+		}
+
+		t.packed = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, u := range t.updates {
+		if u.Ref != nil {
+			if err := t.d.fs.Rename(t.locks[i], u.Name.String()); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if t.removed[u.Name] {
+			if err := t.d.fs.Remove(u.Name.String()); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *referenceTransaction) Abort() error {
+	if t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	t.closed = true
+	t.release()
+	return nil
+}
+
+// release removes the lock files which were not renamed, and unlocks the
+// packed-refs file.
+func (t *referenceTransaction) release() {
+	for _, name := range t.locks {
+		_ = t.d.fs.Remove(name)
+	}
+
+	t.locks = nil
+	if t.packed != nil {
+		_ = t.packed.Close()
+		t.packed = nil
+	}
+}
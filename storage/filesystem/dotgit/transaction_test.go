@@ -0,0 +1,74 @@
+package dotgit
+
+import (
+	"errors"
+
+	"github.com/go-git/go-billy/v5/util"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *SuiteDotGit) TestReferenceTransaction(c *C) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	dir := New(fs)
+
+	master := plumbing.NewReferenceFromStrings("refs/heads/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	foo := plumbing.NewReferenceFromStrings("refs/heads/foo", "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+
+	t := dir.ReferenceTransaction()
+	c.Assert(t.SetReference(plumbing.NewHashReference(master.Name(), foo.Hash()), master), IsNil)
+	c.Assert(t.SetReference(foo, plumbing.NewHashReference(foo.Name(), plumbing.ZeroHash)), IsNil)
+	c.Assert(t.RemoveReference("refs/remotes/origin/master", nil), IsNil)
+	c.Assert(t.RemoveReference("refs/remotes/origin/branch", nil), IsNil)
+	c.Assert(t.Prepare(), IsNil)
+
+	b, err := util.ReadFile(fs, "refs/heads/foo.lock")
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "e8d3ffab552895c19b9fcf7aa264d277cde33881\n")
+
+	c.Assert(t.Commit(), IsNil)
+
+	b, err = util.ReadFile(fs, packedRefsPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, ""+
+		"# pack-refs with: peeled fully-peeled \n"+
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n")
+
+	ref, err := dir.Ref(master.Name())
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, foo.Hash())
+
+	ref, err = dir.Ref(foo.Name())
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, foo)
+
+	for _, name := range []string{"refs/heads/master.lock", "refs/heads/foo.lock"} {
+		_, err = fs.Stat(name)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (s *SuiteDotGit) TestReferenceTransactionLocked(c *C) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	dir := New(fs)
+
+	c.Assert(util.WriteFile(fs, "refs/heads/master.lock", nil, 0666), IsNil)
+
+	t := dir.ReferenceTransaction()
+	c.Assert(t.SetReference(plumbing.NewReferenceFromStrings("refs/heads/foo", "e8d3ffab552895c19b9fcf7aa264d277cde33881"), nil), IsNil)
+	c.Assert(t.RemoveReference("refs/heads/master", nil), IsNil)
+
+	err := t.Commit()
+	c.Assert(errors.Is(err, ErrReferenceLocked), Equals, true)
+
+	// The lock files of the transaction are removed, not the others.
+	_, err = fs.Stat("refs/heads/foo.lock")
+	c.Assert(err, NotNil)
+	_, err = fs.Stat("refs/heads/master.lock")
+	c.Assert(err, IsNil)
+
+	_, err = dir.Ref("refs/heads/foo")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
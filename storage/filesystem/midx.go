@@ -0,0 +1,89 @@
+package filesystem
+
+import (
+	"bytes"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/midx"
+)
+
+// multiPackIndex are the layers of a multi-pack-index, with the hashes of
+// their packs.
+type multiPackIndex struct {
+	layers []*midx.MultiPackIndex
+	// packs are the hashes of the packs of each layer, by pack-int-id.
+	packs [][]plumbing.Hash
+	// covers are the packs whose objects are in the multi-pack-index.
+	covers map[plumbing.Hash]bool
+}
+
+// loadMultiPackIndex reads the multi-pack-index of the repository. It
+// returns nil if there is none, if it is not valid or if some of its packs
+// are not in the given ones anymore, as the pack indexes are used then.
+func loadMultiPackIndex(fs billy.Filesystem, packs []plumbing.Hash) (*multiPackIndex, error) {
+	layers, err := midx.Open(fs)
+	if os.IsNotExist(err) || err == midx.ErrMalformedMultiPackIndex ||
+		err == midx.ErrUnsupportedVersion {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make(map[plumbing.Hash]bool, len(packs))
+	for _, h := range packs {
+		exists[h] = true
+	}
+
+	m := &multiPackIndex{
+		layers: layers,
+		packs:  make([][]plumbing.Hash, len(layers)),
+		covers: make(map[plumbing.Hash]bool),
+	}
+
+	for i, l := range layers {
+		m.packs[i] = make([]plumbing.Hash, len(l.PackNames))
+		for id := range l.PackNames {
+			h, ok := l.PackHash(uint32(id))
+			if !ok || !exists[h] {
+				return nil, nil
+			}
+
+			m.packs[i][id] = h
+			m.covers[h] = true
+		}
+	}
+
+	return m, nil
+}
+
+// find returns the pack of the object and its offset in it.
+func (m *multiPackIndex) find(h plumbing.Hash) (plumbing.Hash, int64, bool) {
+	for i, l := range m.layers {
+		if pos, ok := l.Find(h); ok {
+			return m.packs[i][l.PackIDs[pos]], int64(l.Offsets[pos]), true
+		}
+	}
+
+	return plumbing.ZeroHash, -1, false
+}
+
+// hashesWithPrefix returns the objects whose hash starts with prefix.
+func (m *multiPackIndex) hashesWithPrefix(prefix []byte) []plumbing.Hash {
+	var hashes []plumbing.Hash
+	for _, l := range m.layers {
+		i := sort.Search(len(l.Hashes), func(i int) bool {
+			return bytes.Compare(l.Hashes[i][:], prefix) >= 0
+		})
+
+		for ; i < len(l.Hashes) && bytes.HasPrefix(l.Hashes[i][:], prefix); i++ {
+			hashes = append(hashes, l.Hashes[i])
+		}
+	}
+
+	return hashes
+}
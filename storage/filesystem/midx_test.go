@@ -0,0 +1,133 @@
+package filesystem
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/idxfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/midx"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem/dotgit"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+// writeMultiPackIndex writes a multi-pack-index of the given packs, taking
+// the objects in several packs from the first one.
+func writeMultiPackIndex(c *C, fs billy.Filesystem, packs []plumbing.Hash) {
+	dg := dotgit.New(fs)
+	m := &midx.MultiPackIndex{}
+	type object struct {
+		pack   uint32
+		offset uint64
+	}
+
+	objects := make(map[plumbing.Hash]object)
+	for i, h := range packs {
+		m.PackNames = append(m.PackNames, fmt.Sprintf("pack-%s.idx", h))
+
+		f, err := dg.ObjectPackIdx(h)
+		c.Assert(err, IsNil)
+		idx := idxfile.NewMemoryIndex()
+		c.Assert(idxfile.NewDecoder(f).Decode(idx), IsNil)
+		c.Assert(f.Close(), IsNil)
+
+		iter, err := idx.Entries()
+		c.Assert(err, IsNil)
+		for {
+			e, err := iter.Next()
+			if err == io.EOF {
+				break
+			}
+
+			c.Assert(err, IsNil)
+			if _, ok := objects[e.Hash]; !ok {
+				objects[e.Hash] = object{uint32(i), e.Offset}
+			}
+		}
+	}
+
+	for h := range objects {
+		m.Hashes = append(m.Hashes, h)
+	}
+
+	sort.Slice(m.Hashes, func(i, j int) bool {
+		return bytes.Compare(m.Hashes[i][:], m.Hashes[j][:]) < 0
+	})
+
+	for _, h := range m.Hashes {
+		m.PackIDs = append(m.PackIDs, objects[h].pack)
+		m.Offsets = append(m.Offsets, objects[h].offset)
+	}
+
+	var buf bytes.Buffer
+	c.Assert(midx.NewEncoder(&buf).Encode(m), IsNil)
+	c.Assert(util.WriteFile(fs, midx.FilePath, buf.Bytes(), 0o444), IsNil)
+}
+
+func (s *FsSuite) TestGetFromMultiPackIndex(c *C) {
+	fs := fixtures.ByTag(".git").ByTag("multi-packfile").One().DotGit()
+	packs, err := dotgit.New(fs).ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(len(packs) > 1, Equals, true)
+	writeMultiPackIndex(c, fs, packs)
+
+	// The indexes of the packs are loaded when they are needed.
+	o := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
+	c.Assert(o.requireIndex(), IsNil)
+	c.Assert(o.midx, NotNil)
+	c.Assert(o.index, HasLen, 0)
+
+	for _, h := range []string{
+		"8d45a34641d73851e01d3754320b33bb5be3c4d3",
+		"e9cfa4c9ca160546efd7e8582ec77952a27b17db",
+	} {
+		expected := plumbing.NewHash(h)
+		obj, err := o.getFromPackfile(expected, false)
+		c.Assert(err, IsNil)
+		c.Assert(obj.Hash(), Equals, expected)
+
+		size, err := o.EncodedObjectSize(expected)
+		c.Assert(err, IsNil)
+		c.Assert(size, Equals, obj.Size())
+
+		hashes, err := o.HashesWithPrefix(expected[:4])
+		c.Assert(err, IsNil)
+		c.Assert(hashes, DeepEquals, []plumbing.Hash{expected})
+	}
+
+	_, err = o.getFromPackfile(plumbing.NewHash("8d45a34641d73851e01d3754320b33bb5be3c4d4"), false)
+	c.Assert(err, Equals, plumbing.ErrObjectNotFound)
+}
+
+func (s *FsSuite) TestMultiPackIndexWithMissingPack(c *C) {
+	fs := fixtures.ByTag(".git").ByTag("multi-packfile").One().DotGit()
+	packs, err := dotgit.New(fs).ObjectPacks()
+	c.Assert(err, IsNil)
+	writeMultiPackIndex(c, fs, packs)
+
+	layers, err := midx.Open(fs)
+	c.Assert(err, IsNil)
+	m := layers[0]
+	m.PackNames = append(m.PackNames, "pack-ffffffffffffffffffffffffffffffffffffffff.idx")
+	var buf bytes.Buffer
+	c.Assert(midx.NewEncoder(&buf).Encode(m), IsNil)
+	c.Assert(util.WriteFile(fs, midx.FilePath, buf.Bytes(), 0o444), IsNil)
+
+	// The packs missing from the index are indexed with their own index.
+	o := NewObjectStorage(dotgit.New(fs), cache.NewObjectLRUDefault())
+	c.Assert(o.requireIndex(), IsNil)
+	c.Assert(o.midx, IsNil)
+	c.Assert(o.index, HasLen, len(packs))
+
+	expected := plumbing.NewHash("8d45a34641d73851e01d3754320b33bb5be3c4d3")
+	obj, err := o.getFromPackfile(expected, false)
+	c.Assert(err, IsNil)
+	c.Assert(obj.Hash(), Equals, expected)
+}
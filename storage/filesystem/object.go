@@ -9,6 +9,7 @@ import (
 
 	"github.com/jesseduffield/go-git/v5/plumbing"
 	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/bitmap"
 	"github.com/jesseduffield/go-git/v5/plumbing/format/idxfile"
 	"github.com/jesseduffield/go-git/v5/plumbing/format/objfile"
 	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
@@ -28,10 +29,16 @@ type ObjectStorage struct {
 
 	dir   *dotgit.DotGit
 	index map[plumbing.Hash]idxfile.Index
+	// midx is the multi-pack-index, if any. The indexes of its packs are
+	// only loaded when they are needed.
+	midx *multiPackIndex
 
 	packList    []plumbing.Hash
 	packListIdx int
 	packfiles   map[plumbing.Hash]*packfile.Packfile
+
+	bitmap       *bitmap.PackBitmap
+	bitmapLoaded bool
 }
 
 // NewObjectStorage creates a new ObjectStorage with the given .git directory and cache.
@@ -59,7 +66,17 @@ func (s *ObjectStorage) requireIndex() error {
 		return err
 	}
 
+	s.midx, err = loadMultiPackIndex(s.dir.Fs(), packs)
+	if err != nil {
+		s.index = nil
+		return err
+	}
+
 	for _, h := range packs {
+		if s.midx != nil && s.midx.covers[h] {
+			continue
+		}
+
 		if err := s.loadIdxFile(h); err != nil {
 			return err
 		}
@@ -71,6 +88,88 @@ func (s *ObjectStorage) requireIndex() error {
 // Reindex indexes again all packfiles. Useful if git changed packfiles externally
 func (s *ObjectStorage) Reindex() {
 	s.index = nil
+	s.midx = nil
+	s.bitmap = nil
+	s.bitmapLoaded = false
+}
+
+// PackBitmap returns the bitmaps of the first pack with a bitmap index, or
+// nil if there is none.
+func (s *ObjectStorage) PackBitmap() (*bitmap.PackBitmap, error) {
+	if err := s.requireIndex(); err != nil {
+		return nil, err
+	}
+
+	if s.bitmapLoaded {
+		return s.bitmap, nil
+	}
+
+	packs, err := s.dir.ObjectPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range packs {
+		idx, err := s.loadBitmapFile(h)
+		if err == dotgit.ErrPackfileNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// The bitmap index of another version of the pack is ignored.
+		if idx.PackChecksum != h {
+			continue
+		}
+
+		pi, err := s.packIndex(h)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := bitmap.NewPackBitmap(idx, pi)
+		if err != nil {
+			return nil, err
+		}
+
+		s.bitmap = b
+		break
+	}
+
+	s.bitmapLoaded = true
+	return s.bitmap, nil
+}
+
+func (s *ObjectStorage) loadBitmapFile(h plumbing.Hash) (idx *bitmap.Index, err error) {
+	f, err := s.dir.ObjectPackBitmap(h)
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	idx = &bitmap.Index{}
+	if err := bitmap.NewDecoder(f).Decode(idx); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// packIndex returns the index of the pack, loading it if it is in the
+// multi-pack-index.
+func (s *ObjectStorage) packIndex(h plumbing.Hash) (idxfile.Index, error) {
+	if idx, ok := s.index[h]; ok {
+		return idx, nil
+	}
+
+	if err := s.loadIdxFile(h); err != nil {
+		return nil, err
+	}
+
+	return s.index[h], nil
 }
 
 func (s *ObjectStorage) loadIdxFile(h plumbing.Hash) (err error) {
@@ -284,7 +383,11 @@ func (s *ObjectStorage) encodedObjectSizeFromPackfile(h plumbing.Hash) (
 		return 0, plumbing.ErrObjectNotFound
 	}
 
-	idx := s.index[pack]
+	idx, err := s.packIndex(pack)
+	if err != nil {
+		return 0, err
+	}
+
 	hash, err := idx.FindHash(offset)
 	if err == nil {
 		obj, ok := s.objectCache.Get(hash)
@@ -462,7 +565,11 @@ func (s *ObjectStorage) getFromPackfile(h plumbing.Hash, canBeDelta bool) (
 		return nil, plumbing.ErrObjectNotFound
 	}
 
-	idx := s.index[pack]
+	idx, err := s.packIndex(pack)
+	if err != nil {
+		return nil, err
+	}
+
 	p, err := s.packfile(idx, pack)
 	if err != nil {
 		return nil, err
@@ -540,7 +647,17 @@ func (s *ObjectStorage) decodeDeltaObjectAt(
 }
 
 func (s *ObjectStorage) findObjectInPackfile(h plumbing.Hash) (plumbing.Hash, plumbing.Hash, int64) {
+	if s.midx != nil {
+		if pack, offset, ok := s.midx.find(h); ok {
+			return pack, h, offset
+		}
+	}
+
 	for packfile, index := range s.index {
+		if s.midx != nil && s.midx.covers[packfile] {
+			continue
+		}
+
 		offset, err := index.FindOffset(h)
 		if err == nil {
 			return packfile, h, offset
@@ -565,7 +682,21 @@ func (s *ObjectStorage) HashesWithPrefix(prefix []byte) ([]plumbing.Hash, error)
 	if err := s.requireIndex(); err != nil {
 		return nil, err
 	}
-	for _, index := range s.index {
+
+	if s.midx != nil {
+		for _, h := range s.midx.hashesWithPrefix(prefix) {
+			if _, ok := seen[h]; !ok {
+				seen[h] = struct{}{}
+				hashes = append(hashes, h)
+			}
+		}
+	}
+
+	for pack, index := range s.index {
+		if s.midx != nil && s.midx.covers[pack] {
+			continue
+		}
+
 		ei, err := index.Entries()
 		if err != nil {
 			return nil, err
@@ -629,12 +760,17 @@ func (s *ObjectStorage) buildPackfileIters(
 	return &lazyPackfilesIter{
 		hashes: packs,
 		open: func(h plumbing.Hash) (storer.EncodedObjectIter, error) {
+			idx, err := s.packIndex(h)
+			if err != nil {
+				return nil, err
+			}
+
 			pack, err := s.dir.ObjectPack(h)
 			if err != nil {
 				return nil, err
 			}
 			return newPackfileIter(
-				s.dir.Fs(), pack, t, seen, s.index[h],
+				s.dir.Fs(), pack, t, seen, idx,
 				s.objectCache, s.options.KeepDescriptors,
 				s.options.LargeObjectThreshold,
 			)
@@ -888,5 +1024,14 @@ func (s *ObjectStorage) ObjectPacks() ([]plumbing.Hash, error) {
 }
 
 func (s *ObjectStorage) DeleteOldObjectPackAndIndex(h plumbing.Hash, t time.Time) error {
+	s.bitmap = nil
+	s.bitmapLoaded = false
+
+	// The multi-pack-index is not used anymore once one of its packs is
+	// deleted.
+	if s.midx != nil && s.midx.covers[h] {
+		s.Reindex()
+	}
+
 	return s.dir.DeleteOldObjectPackAndIndex(h, t)
 }
@@ -0,0 +1,112 @@
+package filesystem
+
+import (
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem/dotgit"
+)
+
+// ObjectQuarantine starts a quarantine, whose objects are written in a
+// temporary object directory like the one of git receive-pack. The objects
+// of the storage are read as if it was an alternate of this directory.
+func (s *ObjectStorage) ObjectQuarantine() (storer.ObjectQuarantine, error) {
+	dir, err := s.dir.NewObjectQuarantine()
+	if err != nil {
+		return nil, err
+	}
+
+	// The quarantine has its own cache, so that the objects of an aborted
+	// quarantine are not found in the storage.
+	return &objectQuarantine{
+		ObjectStorage: NewObjectStorageWithOptions(dir.DotGit, cache.NewObjectLRUDefault(), s.options),
+		dir:           dir,
+		storage:       s,
+	}, nil
+}
+
+type objectQuarantine struct {
+	*ObjectStorage
+	dir     *dotgit.ObjectQuarantine
+	storage *ObjectStorage
+}
+
+func (q *objectQuarantine) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := q.ObjectStorage.EncodedObject(t, h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.storage.EncodedObject(t, h)
+	}
+
+	return obj, err
+}
+
+func (q *objectQuarantine) DeltaObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := q.ObjectStorage.DeltaObject(t, h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.storage.DeltaObject(t, h)
+	}
+
+	return obj, err
+}
+
+func (q *objectQuarantine) HasEncodedObject(h plumbing.Hash) error {
+	err := q.ObjectStorage.HasEncodedObject(h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.storage.HasEncodedObject(h)
+	}
+
+	return err
+}
+
+func (q *objectQuarantine) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := q.ObjectStorage.EncodedObjectSize(h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.storage.EncodedObjectSize(h)
+	}
+
+	return size, err
+}
+
+// IterEncodedObjects iterates over the objects of the quarantine, then over
+// the objects of the storage.
+func (q *objectQuarantine) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	quarantined, err := q.ObjectStorage.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := q.storage.IterEncodedObjects(t)
+	if err != nil {
+		quarantined.Close()
+		return nil, err
+	}
+
+	return storer.NewMultiEncodedObjectIter([]storer.EncodedObjectIter{quarantined, stored}), nil
+}
+
+func (q *objectQuarantine) AddAlternate(remote string) error {
+	return q.dir.AddAlternate(remote)
+}
+
+// Commit moves the objects to the object directory of the storage.
+func (q *objectQuarantine) Commit() error {
+	if err := q.ObjectStorage.Close(); err != nil {
+		return err
+	}
+
+	if err := q.dir.Migrate(); err != nil {
+		return err
+	}
+
+	q.storage.Reindex()
+	return nil
+}
+
+// Abort removes the temporary object directory.
+func (q *objectQuarantine) Abort() error {
+	if err := q.ObjectStorage.Close(); err != nil {
+		return err
+	}
+
+	return q.dir.Remove()
+}
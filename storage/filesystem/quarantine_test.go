@@ -0,0 +1,103 @@
+package filesystem
+
+import (
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type QuarantineSuite struct {
+	fixtures.Suite
+	fs      billy.Filesystem
+	storage *Storage
+}
+
+var _ = Suite(&QuarantineSuite{})
+
+func (s *QuarantineSuite) SetUpTest(c *C) {
+	s.fs = osfs.New(c.MkDir())
+	s.storage = NewStorage(s.fs, cache.NewObjectLRUDefault())
+	c.Assert(s.storage.Init(), IsNil)
+}
+
+func (s *QuarantineSuite) quarantineDirs(c *C) []string {
+	entries, err := s.fs.ReadDir("objects")
+	c.Assert(err, IsNil)
+
+	var dirs []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "tmp_objdir-incoming-") {
+			dirs = append(dirs, e.Name())
+		}
+	}
+
+	return dirs
+}
+
+func (s *QuarantineSuite) TestCommit(c *C) {
+	q, err := s.storage.ObjectQuarantine()
+	c.Assert(err, IsNil)
+	c.Assert(s.quarantineDirs(c), HasLen, 1)
+
+	f := fixtures.Basic().One()
+	c.Assert(packfile.UpdateObjectStorage(q, f.Packfile()), IsNil)
+
+	blob := s.storage.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	_, err = q.SetEncodedObject(blob)
+	c.Assert(err, IsNil)
+
+	packs, err := s.storage.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 0)
+
+	c.Assert(q.Commit(), IsNil)
+	c.Assert(s.quarantineDirs(c), HasLen, 0)
+
+	packs, err = s.storage.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, DeepEquals, []plumbing.Hash{plumbing.NewHash(f.PackfileHash)})
+
+	_, err = s.fs.Stat(s.fs.Join("objects", "pack", "pack-"+f.PackfileHash+".idx"))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.storage.HasEncodedObject(plumbing.NewHash(f.Head)), IsNil)
+	c.Assert(s.storage.HasEncodedObject(blob.Hash()), IsNil)
+}
+
+func (s *QuarantineSuite) TestCommitExistingObjects(c *C) {
+	f := fixtures.Basic().One()
+	c.Assert(packfile.UpdateObjectStorage(s.storage, f.Packfile()), IsNil)
+
+	q, err := s.storage.ObjectQuarantine()
+	c.Assert(err, IsNil)
+	c.Assert(packfile.UpdateObjectStorage(q, f.Packfile()), IsNil)
+	c.Assert(q.Commit(), IsNil)
+	c.Assert(s.quarantineDirs(c), HasLen, 0)
+
+	packs, err := s.storage.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+}
+
+func (s *QuarantineSuite) TestAbort(c *C) {
+	q, err := s.storage.ObjectQuarantine()
+	c.Assert(err, IsNil)
+
+	f := fixtures.Basic().One()
+	c.Assert(packfile.UpdateObjectStorage(q, f.Packfile()), IsNil)
+	c.Assert(q.Abort(), IsNil)
+	c.Assert(s.quarantineDirs(c), HasLen, 0)
+
+	packs, err := s.storage.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 0)
+	c.Assert(s.storage.HasEncodedObject(plumbing.NewHash(f.Head)), Equals, plumbing.ErrObjectNotFound)
+}
@@ -1,28 +1,77 @@
 package filesystem
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+
 	"github.com/jesseduffield/go-git/v5/plumbing"
+	formatcfg "github.com/jesseduffield/go-git/v5/plumbing/format/config"
 	"github.com/jesseduffield/go-git/v5/plumbing/storer"
 	"github.com/jesseduffield/go-git/v5/storage/filesystem/dotgit"
 )
 
+// ErrUnsupportedRefStorage is returned when the storage of the references
+// set in the repository config is not supported.
+var ErrUnsupportedRefStorage = errors.New("unsupported ref storage")
+
+// ReferenceStorage stores the references in files, in the format used by
+// git, or in a ReftableStorage if the repository config sets
+// extensions.refStorage to reftable.
 type ReferenceStorage struct {
-	dir *dotgit.DotGit
+	dir  *dotgit.DotGit
+	refs *refStorage
 }
 
 func (r *ReferenceStorage) SetReference(ref *plumbing.Reference) error {
+	rt, err := r.refs.reftable()
+	if err != nil {
+		return err
+	}
+
+	if rt != nil {
+		return rt.SetReference(ref)
+	}
+
 	return r.dir.SetRef(ref, nil)
 }
 
 func (r *ReferenceStorage) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	rt, err := r.refs.reftable()
+	if err != nil {
+		return err
+	}
+
+	if rt != nil {
+		return rt.CheckAndSetReference(ref, old)
+	}
+
 	return r.dir.SetRef(ref, old)
 }
 
 func (r *ReferenceStorage) Reference(n plumbing.ReferenceName) (*plumbing.Reference, error) {
+	rt, err := r.refs.reftable()
+	if err != nil {
+		return nil, err
+	}
+
+	if rt != nil {
+		return rt.Reference(n)
+	}
+
 	return r.dir.Ref(n)
 }
 
 func (r *ReferenceStorage) IterReferences() (storer.ReferenceIter, error) {
+	rt, err := r.refs.reftable()
+	if err != nil {
+		return nil, err
+	}
+
+	if rt != nil {
+		return rt.IterReferences()
+	}
+
 	refs, err := r.dir.Refs()
 	if err != nil {
 		return nil, err
@@ -32,13 +81,110 @@ func (r *ReferenceStorage) IterReferences() (storer.ReferenceIter, error) {
 }
 
 func (r *ReferenceStorage) RemoveReference(n plumbing.ReferenceName) error {
+	rt, err := r.refs.reftable()
+	if err != nil {
+		return err
+	}
+
+	if rt != nil {
+		return rt.RemoveReference(n)
+	}
+
 	return r.dir.RemoveRef(n)
 }
 
 func (r *ReferenceStorage) CountLooseRefs() (int, error) {
+	rt, err := r.refs.reftable()
+	if err != nil {
+		return 0, err
+	}
+
+	if rt != nil {
+		return rt.CountLooseRefs()
+	}
+
 	return r.dir.CountLooseRefs()
 }
 
 func (r *ReferenceStorage) PackRefs() error {
+	rt, err := r.refs.reftable()
+	if err != nil {
+		return err
+	}
+
+	if rt != nil {
+		return rt.PackRefs()
+	}
+
 	return r.dir.PackRefs()
 }
+
+// ReferenceTransaction returns a new transaction updating several references
+// atomically.
+func (r *ReferenceStorage) ReferenceTransaction() (storer.ReferenceTransaction, error) {
+	rt, err := r.refs.reftable()
+	if err != nil {
+		return nil, err
+	}
+
+	if rt != nil {
+		return rt.ReferenceTransaction()
+	}
+
+	return r.dir.ReferenceTransaction(), nil
+}
+
+// refStorage selects the storage of the references from the
+// extensions.refStorage option of the repository config. The config is
+// read once, and again after it is set.
+type refStorage struct {
+	dir *dotgit.DotGit
+
+	mu     sync.Mutex
+	loaded bool
+	rt     *ReftableStorage
+}
+
+// reftable returns the ReftableStorage of the repository, or nil if its
+// references are stored in files.
+func (s *refStorage) reftable() (*ReftableStorage, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loaded {
+		return s.rt, nil
+	}
+
+	cfg, err := (&ConfigStorage{dir: s.dir}).Config()
+	if err != nil {
+		return nil, err
+	}
+
+	switch rs := cfg.Extensions.RefStorage; rs {
+	case "", formatcfg.FilesRefStorage:
+		s.rt = nil
+	case formatcfg.ReftableRefStorage:
+		if s.rt == nil {
+			s.rt = NewReftableStorage(s.dir.Fs())
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedRefStorage, rs)
+	}
+
+	s.loaded = true
+	return s.rt, nil
+}
+
+// reset makes the config to be read again.
+func (s *refStorage) reset() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loaded = false
+}
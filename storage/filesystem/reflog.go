@@ -0,0 +1,75 @@
+package filesystem
+
+import (
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem/dotgit"
+)
+
+// ReflogStorage stores the reflogs in the logs directory of the .git folder,
+// in the format used by git, or in a ReftableStorage if the repository
+// config sets extensions.refStorage to reftable.
+type ReflogStorage struct {
+	dir  *dotgit.DotGit
+	refs *refStorage
+}
+
+// Reflog returns the entries of the reflog of the given reference.
+func (s *ReflogStorage) Reflog(name plumbing.ReferenceName) ([]*reflog.Entry, error) {
+	rt, err := s.refs.reftable()
+	if err != nil {
+		return nil, err
+	}
+
+	if rt != nil {
+		return rt.Reflog(name)
+	}
+
+	return s.dir.Reflog(name)
+}
+
+// AppendReflog adds an entry at the end of the reflog of the given reference.
+func (s *ReflogStorage) AppendReflog(name plumbing.ReferenceName, e *reflog.Entry) error {
+	rt, err := s.refs.reftable()
+	if err != nil {
+		return err
+	}
+
+	if rt != nil {
+		return rt.AppendReflog(name, e)
+	}
+
+	return s.dir.AppendReflog(name, e)
+}
+
+// SetReflog replaces the entries of the reflog of the given reference.
+func (s *ReflogStorage) SetReflog(name plumbing.ReferenceName, entries []*reflog.Entry) error {
+	rt, err := s.refs.reftable()
+	if err != nil {
+		return err
+	}
+
+	if rt != nil {
+		return rt.SetReflog(name, entries)
+	}
+
+	if len(entries) == 0 {
+		return s.dir.RemoveReflog(name)
+	}
+
+	return s.dir.SetReflog(name, entries)
+}
+
+// RemoveReflog removes the reflog of the given reference.
+func (s *ReflogStorage) RemoveReflog(name plumbing.ReferenceName) error {
+	rt, err := s.refs.reftable()
+	if err != nil {
+		return err
+	}
+
+	if rt != nil {
+		return rt.RemoveReflog(name)
+	}
+
+	return s.dir.RemoveReflog(name)
+}
@@ -0,0 +1,709 @@
+package filesystem
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reftable"
+	"github.com/jesseduffield/go-git/v5/plumbing/storer"
+	"github.com/jesseduffield/go-git/v5/storage"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+const (
+	reftablePath = "reftable"
+	// reftableRetries is the number of times the stack is read again when
+	// one of its tables has been removed by a concurrent compaction.
+	reftableRetries = 3
+)
+
+var (
+	tablesListPath = path.Join(reftablePath, "tables.list")
+	tablesListLock = tablesListPath + ".lock"
+
+	// ErrReftableLocked is returned when the stack of reftables is being
+	// updated by another process.
+	ErrReftableLocked = errors.New("reftable stack is locked")
+)
+
+// ReftableStorage stores the references and their reflogs in a stack of
+// reftables, in the reftable directory of the .git folder, like git does
+// with extensions.refStorage=reftable. The tables are listed oldest first
+// in its tables.list file. Each update adds a table, written atomically, and
+// the newest tables are merged when needed to keep the stack short.
+type ReftableStorage struct {
+	fs billy.Filesystem
+
+	mu sync.Mutex
+	// tables are the decoded tables, by file name. Tables are never
+	// modified, so they are only read once.
+	tables map[string]*stackTable
+}
+
+// NewReftableStorage returns a ReftableStorage for the given .git
+// directory.
+func NewReftableStorage(fs billy.Filesystem) *ReftableStorage {
+	return &ReftableStorage{fs: fs, tables: make(map[string]*stackTable)}
+}
+
+type stackTable struct {
+	*reftable.Table
+	size int64
+}
+
+// reftableStack are the tables of a stack, oldest first.
+type reftableStack struct {
+	names  []string
+	tables []*stackTable
+}
+
+func (st *reftableStack) nextUpdateIndex() uint64 {
+	if len(st.tables) == 0 {
+		return 1
+	}
+
+	return st.tables[len(st.tables)-1].MaxUpdateIndex + 1
+}
+
+// ref returns the reference with the given name from the newest table
+// which has it.
+func (st *reftableStack) ref(name plumbing.ReferenceName) *plumbing.Reference {
+	for i := len(st.tables) - 1; i >= 0; i-- {
+		if r, ok := st.tables[i].Ref(name); ok {
+			return r.Reference()
+		}
+	}
+
+	return nil
+}
+
+// logs returns the log records of the reference with the given name,
+// without the deleted ones, oldest first.
+func (st *reftableStack) logs(name plumbing.ReferenceName) []*reftable.LogRecord {
+	byIndex := make(map[uint64]*reftable.LogRecord)
+	for _, t := range st.tables {
+		for _, l := range t.RefLogs(name) {
+			byIndex[l.UpdateIndex] = l
+		}
+	}
+
+	var logs []*reftable.LogRecord
+	for _, l := range byIndex {
+		if l.Entry != nil {
+			logs = append(logs, l)
+		}
+	}
+
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].UpdateIndex < logs[j].UpdateIndex
+	})
+
+	return logs
+}
+
+// Init creates the layout of a repository using reftables. Like git does,
+// HEAD and refs/heads are files which make older versions of git fail to
+// use the repository, instead of ignoring its references.
+func (s *ReftableStorage) Init() error {
+	if err := s.fs.MkdirAll(reftablePath, os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := s.fs.MkdirAll("refs", os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+
+	for name, content := range map[string]string{
+		"HEAD":       "ref: refs/heads/.invalid\n",
+		"refs/heads": "this repository uses the reftable format\n",
+	} {
+		if _, err := s.fs.Stat(name); err == nil {
+			continue
+		}
+
+		if err := writeFile(s.fs, name, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFile(fs billy.Filesystem, name, content string) (err error) {
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	_, err = f.Write([]byte(content))
+	return err
+}
+
+// SetReference sets the given reference.
+func (s *ReftableStorage) SetReference(ref *plumbing.Reference) error {
+	t := s.transaction()
+	_ = t.SetReference(ref, nil)
+	return t.Commit()
+}
+
+// CheckAndSetReference sets the reference ref, but if old is not nil, it
+// first checks that the reference stored with its name has the value of old.
+func (s *ReftableStorage) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	t := s.transaction()
+	_ = t.SetReference(ref, old)
+	return t.Commit()
+}
+
+// Reference returns the reference with the given name.
+func (s *ReftableStorage) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	st, err := s.stack()
+	if err != nil {
+		return nil, err
+	}
+
+	ref := st.ref(name)
+	if ref == nil {
+		return nil, plumbing.ErrReferenceNotFound
+	}
+
+	return ref, nil
+}
+
+// IterReferences returns an iterator over the references, sorted by name.
+func (s *ReftableStorage) IterReferences() (storer.ReferenceIter, error) {
+	st, err := s.stack()
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]*reftable.Table, len(st.tables))
+	for i, t := range st.tables {
+		tables[i] = t.Table
+	}
+
+	var refs []*plumbing.Reference
+	for _, r := range reftable.Merge(tables, false).Refs {
+		refs = append(refs, r.Reference())
+	}
+
+	return storer.NewReferenceSliceIter(refs), nil
+}
+
+// RemoveReference removes the reference with the given name, if it exists.
+func (s *ReftableStorage) RemoveReference(name plumbing.ReferenceName) error {
+	t := s.transaction()
+	_ = t.RemoveReference(name, nil)
+	return t.Commit()
+}
+
+// CountLooseRefs returns 0, as there are no loose references in a
+// reftable.
+func (s *ReftableStorage) CountLooseRefs() (int, error) {
+	return 0, nil
+}
+
+// PackRefs merges all the tables of the stack in a single one, like git
+// pack-refs does.
+func (s *ReftableStorage) PackRefs() error {
+	return s.compact(true)
+}
+
+// Reflog returns the entries of the reflog of the given reference, oldest
+// first.
+func (s *ReftableStorage) Reflog(name plumbing.ReferenceName) ([]*reflog.Entry, error) {
+	st, err := s.stack()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*reflog.Entry
+	for _, l := range st.logs(name) {
+		entries = append(entries, l.Entry)
+	}
+
+	return entries, nil
+}
+
+// AppendReflog adds an entry at the end of the reflog of the given reference.
+func (s *ReftableStorage) AppendReflog(name plumbing.ReferenceName, e *reflog.Entry) error {
+	return s.update(func(st *reftableStack, next uint64) (*reftable.Table, error) {
+		return &reftable.Table{
+			MinUpdateIndex: next,
+			MaxUpdateIndex: next,
+			Logs:           []*reftable.LogRecord{{Name: name, UpdateIndex: next, Entry: e}},
+		}, nil
+	})
+}
+
+// SetReflog replaces the entries of the reflog of the given reference. The
+// current entries are deleted, and the new ones are added with new update
+// indexes.
+func (s *ReftableStorage) SetReflog(name plumbing.ReferenceName, entries []*reflog.Entry) error {
+	return s.update(func(st *reftableStack, next uint64) (*reftable.Table, error) {
+		current := st.logs(name)
+		if len(current) == 0 && len(entries) == 0 {
+			return nil, nil
+		}
+
+		t := &reftable.Table{MinUpdateIndex: next, MaxUpdateIndex: next}
+		if len(entries) > 0 {
+			t.MaxUpdateIndex = next + uint64(len(entries)) - 1
+		}
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			t.Logs = append(t.Logs, &reftable.LogRecord{Name: name, UpdateIndex: next + uint64(i), Entry: entries[i]})
+		}
+
+		for i := len(current) - 1; i >= 0; i-- {
+			t.Logs = append(t.Logs, &reftable.LogRecord{Name: name, UpdateIndex: current[i].UpdateIndex})
+		}
+
+		return t, nil
+	})
+}
+
+// RemoveReflog removes the reflog of the given reference, if any.
+func (s *ReftableStorage) RemoveReflog(name plumbing.ReferenceName) error {
+	return s.SetReflog(name, nil)
+}
+
+// ReferenceTransaction returns a new reference transaction, whose updates
+// are written in a single table.
+func (s *ReftableStorage) ReferenceTransaction() (storer.ReferenceTransaction, error) {
+	return s.transaction(), nil
+}
+
+func (s *ReftableStorage) transaction() *reftableTransaction {
+	return &reftableTransaction{s: s}
+}
+
+type reftableTransaction struct {
+	s       *ReftableStorage
+	updates []storer.ReferenceUpdate
+	// pending is the table of the prepared transaction, with the stack
+	// locked.
+	pending *pendingUpdate
+	closed  bool
+}
+
+func (t *reftableTransaction) SetReference(ref, old *plumbing.Reference) error {
+	return t.add(storer.ReferenceUpdate{Name: ref.Name(), Ref: ref, Old: old})
+}
+
+func (t *reftableTransaction) RemoveReference(name plumbing.ReferenceName, old *plumbing.Reference) error {
+	return t.add(storer.ReferenceUpdate{Name: name, Old: old})
+}
+
+func (t *reftableTransaction) add(u storer.ReferenceUpdate) error {
+	if t.pending != nil || t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	t.updates = append(t.updates, u)
+	return nil
+}
+
+func (t *reftableTransaction) Prepare() error {
+	if t.pending != nil || t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	if err := storer.SortReferenceUpdates(t.updates); err != nil {
+		t.closed = true
+		return err
+	}
+
+	p, err := t.s.prepareUpdate(func(st *reftableStack, next uint64) (*reftable.Table, error) {
+		table := &reftable.Table{MinUpdateIndex: next, MaxUpdateIndex: next}
+		for _, u := range t.updates {
+			current := st.ref(u.Name)
+			if !u.Expected(current) {
+				return nil, storage.ErrReferenceHasChanged
+			}
+
+			switch {
+			case u.Ref != nil:
+				table.Refs = append(table.Refs, reftable.NewRefRecord(u.Ref, next))
+			case current != nil:
+				table.Refs = append(table.Refs, &reftable.RefRecord{Name: u.Name, UpdateIndex: next, Deleted: true})
+			}
+		}
+
+		if len(table.Refs) == 0 {
+			return nil, nil
+		}
+
+		return table, nil
+	})
+
+	if err != nil {
+		t.closed = true
+		return err
+	}
+
+	t.pending = p
+	return nil
+}
+
+func (t *reftableTransaction) Commit() error {
+	if t.pending == nil {
+		if err := t.Prepare(); err != nil {
+			return err
+		}
+	}
+
+	if t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	t.closed = true
+	return t.s.commitUpdate(t.pending)
+}
+
+func (t *reftableTransaction) Abort() error {
+	if t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	t.closed = true
+	if t.pending != nil {
+		t.pending.lock.release()
+	}
+
+	return nil
+}
+
+// stack returns the current tables of the stack.
+func (s *ReftableStorage) stack() (*reftableStack, error) {
+	for i := 0; ; i++ {
+		st, err := s.readStack()
+		if os.IsNotExist(err) && i < reftableRetries {
+			continue
+		}
+
+		return st, err
+	}
+}
+
+func (s *ReftableStorage) readStack() (*reftableStack, error) {
+	names, err := s.readTablesList()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := &reftableStack{names: names}
+	used := make(map[string]bool, len(names))
+	for _, name := range names {
+		t, ok := s.tables[name]
+		if !ok {
+			if t, err = s.readTable(name); err != nil {
+				return nil, err
+			}
+
+			s.tables[name] = t
+		}
+
+		used[name] = true
+		st.tables = append(st.tables, t)
+	}
+
+	for name := range s.tables {
+		if !used[name] {
+			delete(s.tables, name)
+		}
+	}
+
+	return st, nil
+}
+
+func (s *ReftableStorage) readTablesList() (names []string, err error) {
+	f, err := s.fs.Open(tablesListPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	scn := bufio.NewScanner(f)
+	for scn.Scan() {
+		if name := strings.TrimSpace(scn.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names, scn.Err()
+}
+
+func (s *ReftableStorage) readTable(name string) (t *stackTable, err error) {
+	f, err := s.fs.Open(path.Join(reftablePath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	var buf bytes.Buffer
+	t = &stackTable{Table: &reftable.Table{}}
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, err
+	}
+
+	t.size = int64(buf.Len())
+	if err := reftable.NewDecoder(&buf).Decode(t.Table); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return t, nil
+}
+
+// update adds to the stack the table returned by fn, which is called with
+// the stack locked, unless it is nil. The newest tables are then merged if
+// needed.
+func (s *ReftableStorage) update(fn func(st *reftableStack, next uint64) (*reftable.Table, error)) error {
+	p, err := s.prepareUpdate(fn)
+	if err != nil {
+		return err
+	}
+
+	return s.commitUpdate(p)
+}
+
+// pendingUpdate is a table to add to a locked stack.
+type pendingUpdate struct {
+	lock  *stackLock
+	stack *reftableStack
+	table *reftable.Table
+}
+
+// prepareUpdate locks the stack and calls fn to build the table to add.
+func (s *ReftableStorage) prepareUpdate(fn func(st *reftableStack, next uint64) (*reftable.Table, error)) (*pendingUpdate, error) {
+	l, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := s.readStack()
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+
+	t, err := fn(st, st.nextUpdateIndex())
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+
+	return &pendingUpdate{lock: l, stack: st, table: t}, nil
+}
+
+// commitUpdate writes the table of the update, if any, and adds it to the
+// stack, releasing its lock.
+func (s *ReftableStorage) commitUpdate(p *pendingUpdate) error {
+	defer p.lock.release()
+	if p.table == nil {
+		return nil
+	}
+
+	name, err := s.writeTable(p.table)
+	if err != nil {
+		return err
+	}
+
+	if err := p.lock.commit(append(p.stack.names, name)); err != nil {
+		_ = s.fs.Remove(path.Join(reftablePath, name))
+		return err
+	}
+
+	if err := s.compact(false); err != nil && err != ErrReftableLocked {
+		return err
+	}
+
+	return nil
+}
+
+// compact merges all the tables of the stack, or the newest ones needed to
+// keep each table at least twice as large as the next one, like git does
+// after each update.
+func (s *ReftableStorage) compact(all bool) error {
+	l, err := s.lock()
+	if err != nil {
+		return err
+	}
+
+	defer l.release()
+
+	st, err := s.readStack()
+	if err != nil {
+		return err
+	}
+
+	start, end := 0, len(st.tables)
+	if !all {
+		sizes := make([]int64, len(st.tables))
+		for i, t := range st.tables {
+			sizes[i] = t.size
+		}
+
+		start, end = compactionSegment(sizes)
+	}
+
+	if end-start < 2 {
+		return nil
+	}
+
+	tables := make([]*reftable.Table, 0, end-start)
+	for _, t := range st.tables[start:end] {
+		tables = append(tables, t.Table)
+	}
+
+	// The deletions are kept if older tables may have the deleted records.
+	name, err := s.writeTable(reftable.Merge(tables, start > 0))
+	if err != nil {
+		return err
+	}
+
+	names := append(append(append([]string(nil), st.names[:start]...), name), st.names[end:]...)
+	if err := l.commit(names); err != nil {
+		_ = s.fs.Remove(path.Join(reftablePath, name))
+		return err
+	}
+
+	for _, name := range st.names[start:end] {
+		_ = s.fs.Remove(path.Join(reftablePath, name))
+	}
+
+	return nil
+}
+
+// compactionSegment returns the range of the tables with the given sizes,
+// oldest first, to merge so each table is at least twice as large as the
+// next one.
+func compactionSegment(sizes []int64) (start, end int) {
+	i := len(sizes) - 1
+	for i > 0 && sizes[i-1] >= 2*sizes[i] {
+		i--
+	}
+
+	if i <= 0 {
+		return 0, 0
+	}
+
+	start, end = i-1, i+1
+	total := sizes[i-1] + sizes[i]
+	for start > 0 && sizes[start-1] < 2*total {
+		start--
+		total += sizes[start]
+	}
+
+	return start, end
+}
+
+// writeTable writes the table to a file of the reftable directory, and
+// returns its name.
+func (s *ReftableStorage) writeTable(t *reftable.Table) (string, error) {
+	var buf bytes.Buffer
+	if err := reftable.NewEncoder(&buf).Encode(t); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("0x%012x-0x%012x-%08x.ref", t.MinUpdateIndex, t.MaxUpdateIndex, rand.Uint32())
+	f, err := s.fs.TempFile(reftablePath, "tmp_table_")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = s.fs.Rename(f.Name(), path.Join(reftablePath, name))
+	}
+
+	if err != nil {
+		_ = s.fs.Remove(f.Name())
+		return "", err
+	}
+
+	return name, nil
+}
+
+// stackLock is the lock of the tables.list file, which replaces it when
+// committed.
+type stackLock struct {
+	fs   billy.Filesystem
+	f    billy.File
+	done bool
+}
+
+func (s *ReftableStorage) lock() (*stackLock, error) {
+	if err := s.fs.MkdirAll(reftablePath, os.ModeDir|os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	f, err := s.fs.OpenFile(tablesListLock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return nil, ErrReftableLocked
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &stackLock{fs: s.fs, f: f}, nil
+}
+
+// commit writes the names of the tables of the stack, oldest first.
+func (l *stackLock) commit(names []string) error {
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString(name + "\n")
+	}
+
+	_, err := l.f.Write(buf.Bytes())
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = l.fs.Rename(tablesListLock, tablesListPath)
+	}
+
+	if err != nil {
+		_ = l.fs.Remove(tablesListLock)
+	}
+
+	l.done = true
+	return err
+}
+
+// release removes the lock if it has not been committed.
+func (l *stackLock) release() {
+	if l.done {
+		return
+	}
+
+	_ = l.f.Close()
+	_ = l.fs.Remove(tablesListLock)
+	l.done = true
+}
@@ -0,0 +1,227 @@
+package filesystem
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/config"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	formatcfg "github.com/jesseduffield/go-git/v5/plumbing/format/config"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
+	"github.com/jesseduffield/go-git/v5/storage"
+	"github.com/jesseduffield/go-git/v5/storage/test"
+
+	. "gopkg.in/check.v1"
+)
+
+type ReftableSuite struct {
+	test.BaseStorageSuite
+	fs      billy.Filesystem
+	storage *Storage
+}
+
+var _ = Suite(&ReftableSuite{})
+
+func (s *ReftableSuite) SetUpTest(c *C) {
+	tmp, err := util.TempDir(osfs.Default, "", "go-git-filesystem-reftable")
+	c.Assert(err, IsNil)
+
+	s.fs = osfs.New(tmp)
+	s.storage = NewStorage(s.fs, cache.NewObjectLRUDefault())
+	setRefStorage(c, s.storage, formatcfg.ReftableRefStorage)
+	c.Assert(s.storage.Init(), IsNil)
+
+	s.BaseStorageSuite = test.NewBaseStorageSuite(s.storage)
+}
+
+func setRefStorage(c *C, s *Storage, rs formatcfg.RefStorage) {
+	cfg, err := s.Config()
+	c.Assert(err, IsNil)
+
+	cfg.Core.RepositoryFormatVersion = formatcfg.Version_1
+	cfg.Extensions.RefStorage = rs
+	c.Assert(s.SetConfig(cfg), IsNil)
+}
+
+func (s *ReftableSuite) tables(c *C) []string {
+	content, err := util.ReadFile(s.fs, tablesListPath)
+	c.Assert(err, IsNil)
+	return strings.Fields(string(content))
+}
+
+func (s *ReftableSuite) TestLayout(c *C) {
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master)
+	c.Assert(s.storage.SetReference(head), IsNil)
+
+	content, err := util.ReadFile(s.fs, "HEAD")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "ref: refs/heads/.invalid\n")
+
+	fi, err := s.fs.Stat("refs/heads")
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, false)
+
+	tables := s.tables(c)
+	c.Assert(tables, HasLen, 1)
+	c.Assert(tables[0], Matches, `0x000000000001-0x000000000001-[0-9a-f]{8}\.ref`)
+
+	ref, err := s.storage.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, head)
+
+	// A new storage reads the references from the reftable.
+	ref, err = NewStorage(s.fs, cache.NewObjectLRUDefault()).Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, head)
+}
+
+func (s *ReftableSuite) TestReferenceTransaction(c *C) {
+	main := plumbing.NewHashReference("refs/heads/main", plumbing.NewHash("bc9968d75e48de59f0870ffb71f5e160bbbdcf52"))
+	c.Assert(s.storage.SetReference(main), IsNil)
+
+	feature := plumbing.NewHashReference("refs/heads/feature", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	moved := plumbing.NewHashReference("refs/heads/main", feature.Hash())
+	other := plumbing.NewHashReference("refs/heads/main", plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"))
+
+	// Nothing is applied if a reference does not have its expected value.
+	t, err := s.storage.ReferenceTransaction()
+	c.Assert(err, IsNil)
+	c.Assert(t.SetReference(feature, nil), IsNil)
+	c.Assert(t.SetReference(moved, other), IsNil)
+	c.Assert(t.Commit(), Equals, storage.ErrReferenceHasChanged)
+
+	_, err = s.storage.Reference(feature.Name())
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	t, err = s.storage.ReferenceTransaction()
+	c.Assert(err, IsNil)
+	c.Assert(t.SetReference(feature, nil), IsNil)
+	c.Assert(t.SetReference(moved, main), IsNil)
+	c.Assert(t.Prepare(), IsNil)
+
+	// The stack is locked until the transaction is committed.
+	c.Assert(s.storage.SetReference(other), Equals, ErrReftableLocked)
+	c.Assert(t.Commit(), IsNil)
+
+	st, err := NewReftableStorage(s.fs).stack()
+	c.Assert(err, IsNil)
+	c.Assert(st.nextUpdateIndex(), Equals, uint64(3))
+
+	ref, err := s.storage.Reference(moved.Name())
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, moved)
+
+	t, err = s.storage.ReferenceTransaction()
+	c.Assert(err, IsNil)
+	c.Assert(t.RemoveReference(feature.Name(), nil), IsNil)
+	c.Assert(t.Prepare(), IsNil)
+	c.Assert(t.Abort(), IsNil)
+
+	_, err = s.storage.Reference(feature.Name())
+	c.Assert(err, IsNil)
+	c.Assert(s.storage.SetReference(other), IsNil)
+}
+
+func (s *ReftableSuite) TestCompaction(c *C) {
+	for i := 0; i < 100; i++ {
+		name := plumbing.ReferenceName(fmt.Sprintf("refs/heads/branch-%03d", i))
+		ref := plumbing.NewHashReference(name, plumbing.NewHash("bc9968d75e48de59f0870ffb71f5e160bbbdcf52"))
+		c.Assert(s.storage.SetReference(ref), IsNil)
+	}
+
+	c.Assert(len(s.tables(c)) < 10, Equals, true)
+
+	c.Assert(s.storage.RemoveReference("refs/heads/branch-050"), IsNil)
+	_, err := s.storage.Reference("refs/heads/branch-050")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	c.Assert(s.storage.PackRefs(), IsNil)
+	tables := s.tables(c)
+	c.Assert(tables, HasLen, 1)
+	c.Assert(tables[0], Matches, `0x000000000001-0x000000000065-.*`)
+
+	entries, err := s.fs.ReadDir(reftablePath)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+
+	iter, err := s.storage.IterReferences()
+	c.Assert(err, IsNil)
+
+	var count int
+	err = iter.ForEach(func(r *plumbing.Reference) error {
+		c.Assert(r.Name(), Not(Equals), plumbing.ReferenceName("refs/heads/branch-050"))
+		count++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 99)
+}
+
+func (s *ReftableSuite) TestCompactionSegment(c *C) {
+	for _, tc := range []struct {
+		sizes      []int64
+		start, end int
+	}{
+		{nil, 0, 0},
+		{[]int64{100}, 0, 0},
+		{[]int64{400, 200, 100}, 0, 0},
+		{[]int64{400, 100, 100}, 1, 3},
+		{[]int64{1000, 100, 100}, 1, 3},
+		{[]int64{1000, 300, 100, 100}, 1, 4},
+		{[]int64{1000, 500, 200, 150}, 0, 4},
+	} {
+		start, end := compactionSegment(tc.sizes)
+		c.Assert([]int{start, end}, DeepEquals, []int{tc.start, tc.end}, Commentf("%v", tc.sizes))
+	}
+}
+
+func (s *ReftableSuite) TestReflogInReftable(c *C) {
+	name := plumbing.NewBranchReferenceName("main")
+	e := &reflog.Entry{
+		New:       plumbing.NewHash("bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
+		Committer: reflog.Signature{Name: "foo", Email: "foo@foo.com"},
+		Message:   "commit (initial): foo",
+	}
+
+	c.Assert(s.storage.AppendReflog(name, e), IsNil)
+	c.Assert(s.storage.PackRefs(), IsNil)
+	c.Assert(s.storage.RemoveReflog(name), IsNil)
+
+	entries, err := s.storage.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	_, err = s.fs.Stat("logs")
+	c.Assert(err, NotNil)
+}
+
+func (s *ReftableSuite) TestLocked(c *C) {
+	f, err := s.fs.Create(tablesListLock)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	ref := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master)
+	c.Assert(s.storage.SetReference(ref), Equals, ErrReftableLocked)
+}
+
+func (s *ReftableSuite) TestRefStorageFromConfig(c *C) {
+	ref := plumbing.NewHashReference("refs/heads/main", plumbing.NewHash("bc9968d75e48de59f0870ffb71f5e160bbbdcf52"))
+	c.Assert(s.storage.SetReference(ref), IsNil)
+
+	setRefStorage(c, s.storage, formatcfg.FilesRefStorage)
+	_, err := s.storage.Reference(ref.Name())
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	setRefStorage(c, s.storage, "foo")
+	_, err = s.storage.Reference(ref.Name())
+	c.Assert(err, ErrorMatches, "unsupported ref storage: foo")
+
+	cfg := config.NewConfig()
+	c.Assert(s.storage.SetConfig(cfg), IsNil)
+	_, err = s.storage.Reference(ref.Name())
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
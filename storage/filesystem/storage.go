@@ -2,8 +2,15 @@
 package filesystem
 
 import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/config"
+	"github.com/jesseduffield/go-git/v5/plumbing"
 	"github.com/jesseduffield/go-git/v5/plumbing/cache"
 	"github.com/jesseduffield/go-git/v5/storage/filesystem/dotgit"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
 
 	"github.com/go-git/go-billy/v5"
 )
@@ -21,6 +28,7 @@ type Storage struct {
 	ShallowStorage
 	ConfigStorage
 	ModuleStorage
+	ReflogStorage
 }
 
 // Options holds configuration for the storage.
@@ -56,17 +64,19 @@ func NewStorageWithOptions(fs billy.Filesystem, cache cache.Object, ops Options)
 		AlternatesFS:    ops.AlternatesFS,
 	}
 	dir := dotgit.NewWithOptions(fs, dirOps)
+	refs := &refStorage{dir: dir}
 
 	return &Storage{
 		fs:  fs,
 		dir: dir,
 
 		ObjectStorage:    *NewObjectStorageWithOptions(dir, cache, ops),
-		ReferenceStorage: ReferenceStorage{dir: dir},
+		ReferenceStorage: ReferenceStorage{dir: dir, refs: refs},
 		IndexStorage:     IndexStorage{dir: dir},
 		ShallowStorage:   ShallowStorage{dir: dir},
 		ConfigStorage:    ConfigStorage{dir: dir},
 		ModuleStorage:    ModuleStorage{dir: dir},
+		ReflogStorage:    ReflogStorage{dir: dir, refs: refs},
 	}
 }
 
@@ -75,11 +85,89 @@ func (s *Storage) Filesystem() billy.Filesystem {
 	return s.fs
 }
 
-// Init initializes .git directory
+// Init initializes .git directory, with the layout of a repository using
+// reftables if the config sets extensions.refStorage to reftable.
 func (s *Storage) Init() error {
+	rt, err := s.ReferenceStorage.refs.reftable()
+	if err != nil {
+		return err
+	}
+
+	if rt != nil {
+		if err := rt.Init(); err != nil {
+			return err
+		}
+	}
+
 	return s.dir.Initialize()
 }
 
+// SetConfig writes the config of the repository, which may change the
+// storage of the references.
+func (s *Storage) SetConfig(cfg *config.Config) error {
+	defer s.ReferenceStorage.refs.reset()
+	return s.ConfigStorage.SetConfig(cfg)
+}
+
+// PackRefs packs the loose references, recording the objects the annotated
+// tags peel to, as git pack-refs does.
+func (s *Storage) PackRefs() error {
+	rt, err := s.ReferenceStorage.refs.reftable()
+	if err != nil {
+		return err
+	}
+
+	if rt != nil {
+		return rt.PackRefs()
+	}
+
+	return s.dir.PackRefsPeeled(s.peel)
+}
+
+// peel returns the object the annotated tag h points to, following the tags
+// of tags, or plumbing.ZeroHash if h is not an annotated tag.
+func (s *Storage) peel(h plumbing.Hash) (plumbing.Hash, error) {
+	peeled := plumbing.ZeroHash
+	for {
+		obj, err := s.EncodedObject(plumbing.TagObject, h)
+		if err == plumbing.ErrObjectNotFound {
+			return peeled, nil
+		}
+
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if h, err = tagTarget(obj); err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		peeled = h
+	}
+}
+
+// tagTarget returns the object an annotated tag points to, from its first
+// header, without decoding the whole tag.
+func tagTarget(obj plumbing.EncodedObject) (h plumbing.Hash, err error) {
+	r, err := obj.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	hex, ok := strings.CutPrefix(strings.TrimSuffix(line, "\n"), "object ")
+	if !ok || !plumbing.IsHash(hex) {
+		return plumbing.ZeroHash, fmt.Errorf("malformed tag %s", obj.Hash())
+	}
+
+	return plumbing.NewHash(hex), nil
+}
+
 func (s *Storage) AddAlternate(remote string) error {
 	return s.dir.AddAlternate(remote)
 }
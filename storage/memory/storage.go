@@ -8,6 +8,7 @@ import (
 	"github.com/jesseduffield/go-git/v5/config"
 	"github.com/jesseduffield/go-git/v5/plumbing"
 	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
 	"github.com/jesseduffield/go-git/v5/plumbing/storer"
 	"github.com/jesseduffield/go-git/v5/storage"
 )
@@ -25,6 +26,7 @@ type Storage struct {
 	IndexStorage
 	ReferenceStorage
 	ModuleStorage
+	ReflogStorage
 }
 
 // NewStorage returns a new Storage base on memory
@@ -41,6 +43,7 @@ func NewStorage() *Storage {
 			Tags:    make(map[plumbing.Hash]plumbing.EncodedObject),
 		},
 		ModuleStorage: make(ModuleStorage),
+		ReflogStorage: make(ReflogStorage),
 	}
 }
 
@@ -243,6 +246,85 @@ func (tx *TxObjectStorage) Rollback() error {
 	return nil
 }
 
+// ObjectQuarantine starts a quarantine, whose objects are kept apart from
+// the storage until it is committed.
+func (o *ObjectStorage) ObjectQuarantine() (storer.ObjectQuarantine, error) {
+	return &objectQuarantine{
+		ObjectStorage: ObjectStorage{
+			Objects: make(map[plumbing.Hash]plumbing.EncodedObject),
+			Commits: make(map[plumbing.Hash]plumbing.EncodedObject),
+			Trees:   make(map[plumbing.Hash]plumbing.EncodedObject),
+			Blobs:   make(map[plumbing.Hash]plumbing.EncodedObject),
+			Tags:    make(map[plumbing.Hash]plumbing.EncodedObject),
+		},
+		storage: o,
+	}, nil
+}
+
+type objectQuarantine struct {
+	ObjectStorage
+	storage *ObjectStorage
+}
+
+func (q *objectQuarantine) HasEncodedObject(h plumbing.Hash) error {
+	if err := q.ObjectStorage.HasEncodedObject(h); err != plumbing.ErrObjectNotFound {
+		return err
+	}
+
+	return q.storage.HasEncodedObject(h)
+}
+
+func (q *objectQuarantine) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := q.ObjectStorage.EncodedObjectSize(h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.storage.EncodedObjectSize(h)
+	}
+
+	return size, err
+}
+
+func (q *objectQuarantine) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := q.ObjectStorage.EncodedObject(t, h)
+	if err == plumbing.ErrObjectNotFound {
+		return q.storage.EncodedObject(t, h)
+	}
+
+	return obj, err
+}
+
+func (q *objectQuarantine) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	quarantined, err := q.ObjectStorage.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := q.storage.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return storer.NewMultiEncodedObjectIter([]storer.EncodedObjectIter{quarantined, stored}), nil
+}
+
+func (q *objectQuarantine) Commit() error {
+	for _, obj := range q.Objects {
+		if _, err := q.storage.SetEncodedObject(obj); err != nil {
+			return err
+		}
+	}
+
+	return q.Abort()
+}
+
+func (q *objectQuarantine) Abort() error {
+	q.Objects = make(map[plumbing.Hash]plumbing.EncodedObject)
+	q.Commits = make(map[plumbing.Hash]plumbing.EncodedObject)
+	q.Trees = make(map[plumbing.Hash]plumbing.EncodedObject)
+	q.Blobs = make(map[plumbing.Hash]plumbing.EncodedObject)
+	q.Tags = make(map[plumbing.Hash]plumbing.EncodedObject)
+	return nil
+}
+
 type ReferenceStorage map[plumbing.ReferenceName]*plumbing.Reference
 
 func (r ReferenceStorage) SetReference(ref *plumbing.Reference) error {
@@ -299,6 +381,132 @@ func (r ReferenceStorage) RemoveReference(n plumbing.ReferenceName) error {
 	return nil
 }
 
+// ReferenceTransaction returns a new reference transaction, as the storage
+// is not safe for concurrent use there is nothing to lock.
+func (r ReferenceStorage) ReferenceTransaction() (storer.ReferenceTransaction, error) {
+	return &referenceTransaction{refs: r}, nil
+}
+
+type referenceTransaction struct {
+	refs     ReferenceStorage
+	updates  []storer.ReferenceUpdate
+	prepared bool
+	closed   bool
+}
+
+func (t *referenceTransaction) SetReference(ref, old *plumbing.Reference) error {
+	return t.add(storer.ReferenceUpdate{Name: ref.Name(), Ref: ref, Old: old})
+}
+
+func (t *referenceTransaction) RemoveReference(n plumbing.ReferenceName, old *plumbing.Reference) error {
+	return t.add(storer.ReferenceUpdate{Name: n, Old: old})
+}
+
+func (t *referenceTransaction) add(u storer.ReferenceUpdate) error {
+	if t.prepared || t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	t.updates = append(t.updates, u)
+	return nil
+}
+
+func (t *referenceTransaction) Prepare() error {
+	if t.prepared || t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	if err := storer.SortReferenceUpdates(t.updates); err != nil {
+		t.closed = true
+		return err
+	}
+
+	for _, u := range t.updates {
+		if !u.Expected(t.refs[u.Name]) {
+			t.closed = true
+			return storage.ErrReferenceHasChanged
+		}
+	}
+
+	t.prepared = true
+	return nil
+}
+
+func (t *referenceTransaction) Commit() error {
+	if !t.prepared {
+		if err := t.Prepare(); err != nil {
+			return err
+		}
+	}
+
+	if t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	for _, u := range t.updates {
+		if u.Ref != nil {
+			t.refs[u.Name] = u.Ref
+		} else {
+			delete(t.refs, u.Name)
+		}
+	}
+
+	t.closed = true
+	return nil
+}
+
+func (t *referenceTransaction) Abort() error {
+	if t.closed {
+		return storer.ErrReferenceTransactionClosed
+	}
+
+	t.closed = true
+	return nil
+}
+
+type ReflogStorage map[plumbing.ReferenceName][]*reflog.Entry
+
+func (r ReflogStorage) Reflog(n plumbing.ReferenceName) ([]*reflog.Entry, error) {
+	return copyReflog(r[n]), nil
+}
+
+func (r ReflogStorage) AppendReflog(n plumbing.ReferenceName, e *reflog.Entry) error {
+	entry := *e
+	r[n] = append(r[n], &entry)
+	return nil
+}
+
+func (r ReflogStorage) SetReflog(n plumbing.ReferenceName, entries []*reflog.Entry) error {
+	if len(entries) == 0 {
+		delete(r, n)
+		return nil
+	}
+
+	r[n] = copyReflog(entries)
+	return nil
+}
+
+func (r ReflogStorage) RemoveReflog(n plumbing.ReferenceName) error {
+	delete(r, n)
+	return nil
+}
+
+// copyReflog copies the entries, so the stored ones are not modified by the
+// callers.
+func copyReflog(entries []*reflog.Entry) []*reflog.Entry {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	c := make([]*reflog.Entry, 0, len(entries))
+	for _, e := range entries {
+		entry := *e
+		c = append(c, &entry)
+	}
+
+	return c
+}
+
 type ShallowStorage []plumbing.Hash
 
 func (s *ShallowStorage) SetShallow(commits []plumbing.Hash) error {
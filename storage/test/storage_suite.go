@@ -5,10 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/jesseduffield/go-git/v5/config"
 	"github.com/jesseduffield/go-git/v5/plumbing"
 	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/packfile"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/reflog"
 	"github.com/jesseduffield/go-git/v5/plumbing/storer"
 	"github.com/jesseduffield/go-git/v5/storage"
 
@@ -377,6 +380,167 @@ func (s *BaseStorageSuite) TestIterReferences(c *C) {
 	c.Assert(err, Equals, io.EOF)
 }
 
+func (s *BaseStorageSuite) objectQuarantine(c *C) storer.ObjectQuarantine {
+	oq, ok := s.Storer.(storer.ObjectQuarantiner)
+	if !ok {
+		c.Skip("not a storer.ObjectQuarantiner")
+	}
+
+	q, err := oq.ObjectQuarantine()
+	c.Assert(err, IsNil)
+	return q
+}
+
+func (s *BaseStorageSuite) TestObjectQuarantine(c *C) {
+	commit := s.testObjects[plumbing.CommitObject]
+	_, err := s.Storer.SetEncodedObject(commit.Object)
+	c.Assert(err, IsNil)
+
+	q := s.objectQuarantine(c)
+	for _, t := range []plumbing.ObjectType{plumbing.TreeObject, plumbing.BlobObject, plumbing.TagObject} {
+		h, err := q.SetEncodedObject(s.testObjects[t].Object)
+		c.Assert(err, IsNil)
+		c.Assert(q.HasEncodedObject(h), IsNil)
+	}
+
+	o, err := q.EncodedObject(plumbing.CommitObject, plumbing.NewHash(commit.Hash))
+	c.Assert(err, IsNil)
+	c.Assert(o.Hash().String(), Equals, commit.Hash)
+
+	f := fixtures.Basic().One()
+	c.Assert(packfile.UpdateObjectStorage(q, f.Packfile()), IsNil)
+	head := plumbing.NewHash(f.Head)
+	c.Assert(q.HasEncodedObject(head), IsNil)
+	c.Assert(s.Storer.HasEncodedObject(head), Equals, plumbing.ErrObjectNotFound)
+
+	c.Assert(q.Commit(), IsNil)
+
+	for _, expected := range s.testObjects {
+		o, err := s.Storer.EncodedObject(expected.Type, plumbing.NewHash(expected.Hash))
+		c.Assert(err, IsNil)
+		c.Assert(o.Hash().String(), Equals, expected.Hash)
+	}
+
+	o, err = s.Storer.EncodedObject(plumbing.CommitObject, head)
+	c.Assert(err, IsNil)
+	c.Assert(o.Hash(), Equals, head)
+}
+
+func (s *BaseStorageSuite) TestObjectQuarantineAbort(c *C) {
+	q := s.objectQuarantine(c)
+	for _, o := range s.testObjects {
+		_, err := q.SetEncodedObject(o.Object)
+		c.Assert(err, IsNil)
+	}
+
+	f := fixtures.Basic().One()
+	c.Assert(packfile.UpdateObjectStorage(q, f.Packfile()), IsNil)
+	c.Assert(q.Abort(), IsNil)
+
+	iter, err := s.Storer.IterEncodedObjects(plumbing.AnyObject)
+	c.Assert(err, IsNil)
+	_, err = iter.Next()
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *BaseStorageSuite) referenceTransaction(c *C) storer.ReferenceTransaction {
+	rt, ok := s.Storer.(storer.ReferenceTransactioner)
+	if !ok {
+		c.Skip("not a storer.ReferenceTransactioner")
+	}
+
+	t, err := rt.ReferenceTransaction()
+	c.Assert(err, IsNil)
+	return t
+}
+
+func (s *BaseStorageSuite) TestReferenceTransaction(c *C) {
+	foo := plumbing.NewReferenceFromStrings("refs/heads/foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa")
+	bar := plumbing.NewReferenceFromStrings("refs/heads/bar", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+	qux := plumbing.NewReferenceFromStrings("refs/heads/qux", "c3f4688a08fd86f1bf8e055724c84b7a40a09733")
+	c.Assert(s.Storer.SetReference(foo), IsNil)
+	c.Assert(s.Storer.SetReference(qux), IsNil)
+
+	t := s.referenceTransaction(c)
+	c.Assert(t.SetReference(plumbing.NewHashReference(foo.Name(), bar.Hash()), foo), IsNil)
+	c.Assert(t.SetReference(bar, plumbing.NewHashReference(bar.Name(), plumbing.ZeroHash)), IsNil)
+	c.Assert(t.RemoveReference(qux.Name(), qux), IsNil)
+	c.Assert(t.RemoveReference("refs/heads/nonexistent", nil), IsNil)
+	c.Assert(t.Commit(), IsNil)
+	c.Assert(t.Commit(), Equals, storer.ErrReferenceTransactionClosed)
+
+	ref, err := s.Storer.Reference(foo.Name())
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, bar.Hash())
+
+	ref, err = s.Storer.Reference(bar.Name())
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, bar)
+
+	_, err = s.Storer.Reference(qux.Name())
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *BaseStorageSuite) TestReferenceTransactionHasChanged(c *C) {
+	foo := plumbing.NewReferenceFromStrings("refs/heads/foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa")
+	bar := plumbing.NewReferenceFromStrings("refs/heads/bar", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+	c.Assert(s.Storer.SetReference(foo), IsNil)
+
+	for _, old := range []*plumbing.Reference{
+		plumbing.NewHashReference(foo.Name(), plumbing.ZeroHash),
+		plumbing.NewHashReference(foo.Name(), bar.Hash()),
+		plumbing.NewSymbolicReference(foo.Name(), bar.Name()),
+	} {
+		t := s.referenceTransaction(c)
+		c.Assert(t.SetReference(bar, nil), IsNil)
+		c.Assert(t.RemoveReference(foo.Name(), old), IsNil)
+		c.Assert(t.Prepare(), Equals, storage.ErrReferenceHasChanged)
+		c.Assert(t.Commit(), Equals, storer.ErrReferenceTransactionClosed)
+	}
+
+	t := s.referenceTransaction(c)
+	c.Assert(t.SetReference(foo, bar), IsNil)
+	c.Assert(t.Commit(), Equals, storage.ErrReferenceHasChanged)
+
+	ref, err := s.Storer.Reference(foo.Name())
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, foo)
+
+	_, err = s.Storer.Reference(bar.Name())
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *BaseStorageSuite) TestReferenceTransactionAbort(c *C) {
+	foo := plumbing.NewReferenceFromStrings("refs/heads/foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa")
+
+	t := s.referenceTransaction(c)
+	c.Assert(t.SetReference(foo, nil), IsNil)
+	c.Assert(t.Prepare(), IsNil)
+	c.Assert(t.SetReference(foo, nil), Equals, storer.ErrReferenceTransactionClosed)
+	c.Assert(t.Abort(), IsNil)
+	c.Assert(t.Commit(), Equals, storer.ErrReferenceTransactionClosed)
+
+	_, err := s.Storer.Reference(foo.Name())
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	// The references are not locked anymore.
+	t = s.referenceTransaction(c)
+	c.Assert(t.SetReference(foo, nil), IsNil)
+	c.Assert(t.Commit(), IsNil)
+}
+
+func (s *BaseStorageSuite) TestReferenceTransactionDuplicated(c *C) {
+	foo := plumbing.NewReferenceFromStrings("refs/heads/foo", "482e0eada5de4039e6f216b45b3c9b683b83bfa")
+
+	t := s.referenceTransaction(c)
+	c.Assert(t.SetReference(foo, nil), IsNil)
+	c.Assert(t.RemoveReference(foo.Name(), nil), IsNil)
+	c.Assert(errors.Is(t.Commit(), storer.ErrDuplicatedReferenceUpdate), Equals, true)
+
+	_, err := s.Storer.Reference(foo.Name())
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
 func (s *BaseStorageSuite) TestSetShallowAndShallow(c *C) {
 	expected := []plumbing.Hash{
 		plumbing.NewHash("b66c08ba28aa1f81eb06a1127aa3936ff77e5e2c"),
@@ -392,6 +556,66 @@ func (s *BaseStorageSuite) TestSetShallowAndShallow(c *C) {
 	c.Assert(result, DeepEquals, expected)
 }
 
+func (s *BaseStorageSuite) TestReflog(c *C) {
+	rs, ok := s.Storer.(storer.ReflogStorer)
+	if !ok {
+		c.Skip("not a storer.ReflogStorer")
+	}
+
+	name := plumbing.NewBranchReferenceName("foo")
+	entries, err := rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	when := time.Unix(1700000000, 0).In(time.FixedZone("", 3600))
+	first := &reflog.Entry{
+		New:       plumbing.NewHash("b66c08ba28aa1f81eb06a1127aa3936ff77e5e2c"),
+		Committer: reflog.Signature{Name: "foo", Email: "foo@foo.com", When: when},
+		Message:   "branch: Created from HEAD",
+	}
+	second := &reflog.Entry{
+		Old:       first.New,
+		New:       plumbing.NewHash("c3f4688a08fd86f1bf8e055724c84b7a40a09733"),
+		Committer: first.Committer,
+		Message:   "commit: bar",
+	}
+
+	for _, e := range []*reflog.Entry{first, second} {
+		err = rs.AppendReflog(name, e)
+		c.Assert(err, IsNil)
+	}
+
+	entries, err = rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	for i, e := range []*reflog.Entry{first, second} {
+		c.Assert(entries[i].Old, Equals, e.Old)
+		c.Assert(entries[i].New, Equals, e.New)
+		c.Assert(entries[i].Committer.Name, Equals, e.Committer.Name)
+		c.Assert(entries[i].Committer.Email, Equals, e.Committer.Email)
+		c.Assert(entries[i].Committer.When.Equal(when), Equals, true)
+		c.Assert(entries[i].Message, Equals, e.Message)
+	}
+
+	err = rs.SetReflog(name, entries[1:])
+	c.Assert(err, IsNil)
+
+	entries, err = rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].New, Equals, second.New)
+
+	err = rs.RemoveReflog(name)
+	c.Assert(err, IsNil)
+
+	entries, err = rs.Reflog(name)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	err = rs.RemoveReflog(name)
+	c.Assert(err, IsNil)
+}
+
 func (s *BaseStorageSuite) TestSetConfigAndConfig(c *C) {
 	expected := config.NewConfig()
 	expected.Core.IsBare = true
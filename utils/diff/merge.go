@@ -0,0 +1,260 @@
+package diff
+
+import (
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	conflictMarkerSize = 7
+)
+
+// MergeOptions describes how the conflicting hunks of a three-way merge are
+// rendered.
+type MergeOptions struct {
+	// OursLabel, BaseLabel and TheirsLabel are appended to the conflict
+	// markers, e.g. "<<<<<<< HEAD".
+	OursLabel   string
+	BaseLabel   string
+	TheirsLabel string
+	// Diff3 includes the base version of conflicting hunks between the
+	// "|||||||" and "=======" markers, like git's merge.conflictStyle=diff3.
+	Diff3 bool
+}
+
+// Merge performs a line oriented three-way merge of ours and theirs, using
+// base as the common ancestor, following the rules of git's xdl_merge. Changes
+// made by only one side are applied, identical changes made by both sides are
+// applied once, and overlapping or adjacent changes are reported as
+// conflicts, delimited in the merged text by conflict markers.
+//
+// The returned bool is true if at least one conflict was found.
+func Merge(base, ours, theirs string, opts MergeOptions) (string, bool) {
+	baseLines := splitLines(base)
+	oursHunks := hunks(base, ours)
+	theirsHunks := hunks(base, theirs)
+
+	var out strings.Builder
+	var conflict bool
+
+	pos := 0
+	for len(oursHunks) > 0 || len(theirsHunks) > 0 {
+		start := nextStart(oursHunks, theirsHunks)
+		writeLines(&out, baseLines[pos:start])
+
+		// Collect every hunk overlapping (or touching) the region, from
+		// both sides, until the region stops growing.
+		end := start
+		var o, t []hunk
+		for {
+			grown := false
+			if len(oursHunks) > 0 && overlaps(oursHunks[0], start, end, len(o)+len(t) == 0) {
+				o = append(o, oursHunks[0])
+				end = max(end, oursHunks[0].end)
+				oursHunks = oursHunks[1:]
+				grown = true
+			}
+
+			if len(theirsHunks) > 0 && overlaps(theirsHunks[0], start, end, len(o)+len(t) == 0) {
+				t = append(t, theirsHunks[0])
+				end = max(end, theirsHunks[0].end)
+				theirsHunks = theirsHunks[1:]
+				grown = true
+			}
+
+			if !grown {
+				break
+			}
+		}
+
+		pos = end
+		region := baseLines[start:end]
+		switch {
+		case len(t) == 0:
+			writeLines(&out, apply(region, start, o))
+		case len(o) == 0:
+			writeLines(&out, apply(region, start, t))
+		default:
+			oursRegion := apply(region, start, o)
+			theirsRegion := apply(region, start, t)
+			if equalLines(oursRegion, theirsRegion) {
+				writeLines(&out, oursRegion)
+				continue
+			}
+
+			conflict = true
+			writeConflict(&out, region, oursRegion, theirsRegion, opts)
+		}
+	}
+
+	writeLines(&out, baseLines[pos:])
+	return out.String(), conflict
+}
+
+// hunk is a change replacing the base lines [start, end) with lines.
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// hunks returns the changes needed to turn src into dst, as a list of hunks
+// ordered by their position in src.
+func hunks(src, dst string) []hunk {
+	var result []hunk
+	var current *hunk
+
+	pos := 0
+	for _, d := range Do(src, dst) {
+		lines := splitLines(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			if current != nil {
+				result = append(result, *current)
+				current = nil
+			}
+			pos += len(lines)
+		case diffmatchpatch.DiffDelete:
+			if current == nil {
+				current = &hunk{start: pos, end: pos}
+			}
+			pos += len(lines)
+			current.end = pos
+		case diffmatchpatch.DiffInsert:
+			if current == nil {
+				current = &hunk{start: pos, end: pos}
+			}
+			current.lines = append(current.lines, lines...)
+		}
+	}
+
+	if current != nil {
+		result = append(result, *current)
+	}
+
+	return result
+}
+
+func nextStart(a, b []hunk) int {
+	switch {
+	case len(a) == 0:
+		return b[0].start
+	case len(b) == 0:
+		return a[0].start
+	}
+
+	return min(a[0].start, b[0].start)
+}
+
+// overlaps returns true if h belongs to the region [start, end). Hunks that
+// only touch the region are considered overlapping as well, since git reports
+// changes to adjacent lines as a conflict.
+func overlaps(h hunk, start, end int, empty bool) bool {
+	if empty {
+		return h.start == start
+	}
+
+	return h.start <= end
+}
+
+// apply returns the lines of region, starting at offset in the base text,
+// once the given hunks are applied.
+func apply(region []string, offset int, hs []hunk) []string {
+	var result []string
+	pos := 0
+	for _, h := range hs {
+		result = append(result, region[pos:h.start-offset]...)
+		result = append(result, h.lines...)
+		pos = h.end - offset
+	}
+
+	return append(result, region[pos:]...)
+}
+
+func writeConflict(out *strings.Builder, base, ours, theirs []string, opts MergeOptions) {
+	var prefix, suffix []string
+	if !opts.Diff3 {
+		// Like git's XDL_MERGE_ZEALOUS level, lines common to both sides
+		// are moved out of the conflict.
+		n := 0
+		for n < len(ours) && n < len(theirs) && ours[n] == theirs[n] {
+			n++
+		}
+		prefix, ours, theirs = ours[:n], ours[n:], theirs[n:]
+
+		n = 0
+		for n < len(ours) && n < len(theirs) &&
+			ours[len(ours)-1-n] == theirs[len(theirs)-1-n] {
+			n++
+		}
+		suffix = ours[len(ours)-n:]
+		ours, theirs = ours[:len(ours)-n], theirs[:len(theirs)-n]
+	}
+
+	writeLines(out, prefix)
+	writeMarker(out, '<', opts.OursLabel)
+	writeLines(out, ours)
+	terminateLine(out)
+	if opts.Diff3 {
+		writeMarker(out, '|', opts.BaseLabel)
+		writeLines(out, base)
+		terminateLine(out)
+	}
+	writeMarker(out, '=', "")
+	writeLines(out, theirs)
+	terminateLine(out)
+	writeMarker(out, '>', opts.TheirsLabel)
+	writeLines(out, suffix)
+}
+
+func writeMarker(out *strings.Builder, c byte, label string) {
+	out.WriteString(strings.Repeat(string(c), conflictMarkerSize))
+	if label != "" {
+		out.WriteByte(' ')
+		out.WriteString(label)
+	}
+	out.WriteByte('\n')
+}
+
+// terminateLine adds a line feed if the text written so far doesn't end with
+// one, so the next conflict marker starts at the beginning of a line.
+func terminateLine(out *strings.Builder) {
+	s := out.String()
+	if len(s) > 0 && s[len(s)-1] != '\n' {
+		out.WriteByte('\n')
+	}
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, l := range lines {
+		out.WriteString(l)
+	}
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// splitLines splits s in lines, keeping the line feed at the end of each one.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
@@ -0,0 +1,87 @@
+package diff_test
+
+import (
+	"github.com/jesseduffield/go-git/v5/utils/diff"
+
+	. "gopkg.in/check.v1"
+)
+
+var mergeTests = [...]struct {
+	base, ours, theirs string
+	exp                string
+	conflict           bool
+}{
+	// trivial cases
+	{"", "", "", "", false},
+	{"a\n", "a\n", "a\n", "a\n", false},
+	{"a\n", "b\n", "a\n", "b\n", false},
+	{"a\n", "a\n", "b\n", "b\n", false},
+	{"a\n", "b\n", "b\n", "b\n", false},
+	// non overlapping changes
+	{
+		base:   "a\nb\nc\nd\ne\n",
+		ours:   "A\nb\nc\nd\ne\n",
+		theirs: "a\nb\nc\nd\nE\n",
+		exp:    "A\nb\nc\nd\nE\n",
+	},
+	// insertions and deletions
+	{
+		base:   "a\nb\nc\nd\ne\n",
+		ours:   "a\nc\nd\ne\n",
+		theirs: "a\nb\nc\nd\ne\nf\n",
+		exp:    "a\nc\nd\ne\nf\n",
+	},
+	// overlapping changes
+	{
+		base:     "a\nb\nc\n",
+		ours:     "a\nB\nc\n",
+		theirs:   "a\nX\nc\n",
+		exp:      "a\n<<<<<<< ours\nB\n=======\nX\n>>>>>>> theirs\nc\n",
+		conflict: true,
+	},
+	// adjacent changes
+	{
+		base:     "a\nb\nc\nd\n",
+		ours:     "a\nB\nc\nd\n",
+		theirs:   "a\nb\nC\nd\n",
+		exp:      "a\n<<<<<<< ours\nB\nc\n=======\nb\nC\n>>>>>>> theirs\nd\n",
+		conflict: true,
+	},
+	// add/add with common lines
+	{
+		base:     "",
+		ours:     "a\nb\nc\n",
+		theirs:   "a\nx\nc\n",
+		exp:      "a\n<<<<<<< ours\nb\n=======\nx\n>>>>>>> theirs\nc\n",
+		conflict: true,
+	},
+	// missing line feed at the end of a conflict
+	{
+		base:     "a\n",
+		ours:     "b",
+		theirs:   "c",
+		exp:      "<<<<<<< ours\nb\n=======\nc\n>>>>>>> theirs\n",
+		conflict: true,
+	},
+}
+
+func (s *suiteCommon) TestMerge(c *C) {
+	opts := diff.MergeOptions{OursLabel: "ours", TheirsLabel: "theirs"}
+	for i, t := range mergeTests {
+		merged, conflict := diff.Merge(t.base, t.ours, t.theirs, opts)
+		c.Assert(merged, Equals, t.exp, Commentf("subtest %d", i))
+		c.Assert(conflict, Equals, t.conflict, Commentf("subtest %d", i))
+	}
+}
+
+func (s *suiteCommon) TestMergeDiff3(c *C) {
+	merged, conflict := diff.Merge("a\nb\nc\n", "a\nB\nc\n", "a\nX\nc\n", diff.MergeOptions{
+		OursLabel:   "ours",
+		BaseLabel:   "base",
+		TheirsLabel: "theirs",
+		Diff3:       true,
+	})
+
+	c.Assert(conflict, Equals, true)
+	c.Assert(merged, Equals, "a\n<<<<<<< ours\nB\n||||||| base\nb\n=======\nX\n>>>>>>> theirs\nc\n")
+}
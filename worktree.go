@@ -47,7 +47,8 @@ type Worktree struct {
 // Returns nil if the operation is successful, NoErrAlreadyUpToDate if there are
 // no changes to be fetched, or an error.
 //
-// Pull only supports merges where the can be resolved as a fast-forward.
+// By default Pull only supports merges where the can be resolved as a
+// fast-forward, see PullOptions.MergeStrategy.
 func (w *Worktree) Pull(o *PullOptions) error {
 	return w.PullContext(context.Background(), o)
 }
@@ -56,7 +57,8 @@ func (w *Worktree) Pull(o *PullOptions) error {
 // branch. Returns nil if the operation is successful, NoErrAlreadyUpToDate if
 // there are no changes to be fetched, or an error.
 //
-// Pull only supports merges where the can be resolved as a fast-forward.
+// By default Pull only supports merges where the can be resolved as a
+// fast-forward, see PullOptions.MergeStrategy.
 //
 // The provided Context must be non-nil. If the context expires before the
 // operation is complete, an error is returned. The context only affects the
@@ -71,6 +73,7 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 		return err
 	}
 
+	w.r.setPromisorAuth(o.RemoteName, o.Auth)
 	fetchHead, err := remote.fetch(ctx, &FetchOptions{
 		RemoteName:      o.RemoteName,
 		RemoteURL:       o.RemoteURL,
@@ -81,7 +84,7 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 		InsecureSkipTLS: o.InsecureSkipTLS,
 		CABundle:        o.CABundle,
 		ProxyOptions:    o.ProxyOptions,
-	})
+	}, "pull")
 
 	updated := true
 	if err == NoErrAlreadyUpToDate {
@@ -95,6 +98,7 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 		return err
 	}
 
+	var merge bool
 	head, err := w.r.Head()
 	if err == nil {
 		// if we don't have a shallows list, just ignore it
@@ -119,24 +123,40 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 			return err
 		}
 
-		if !ff {
+		if !ff && o.MergeStrategy != RecursiveMerge {
 			return ErrNonFastForwardUpdate
 		}
+
+		merge = !ff
 	}
 
 	if err != nil && err != plumbing.ErrReferenceNotFound {
 		return err
 	}
 
-	if err := w.updateHEAD(ref.Hash()); err != nil {
-		return err
-	}
+	if merge {
+		url := o.RemoteURL
+		if url == "" {
+			url = remote.c.URLs[0]
+		}
 
-	if err := w.Reset(&ResetOptions{
-		Mode:   MergeReset,
-		Commit: ref.Hash(),
-	}); err != nil {
-		return err
+		if err := w.merge(ref.Hash(), ref.Name(), &MergeOptions{
+			Strategy: RecursiveMerge,
+			Message:  fmt.Sprintf("Merge %s of %s\n", mergeName(ref.Name()), url),
+		}, "pull"); err != nil {
+			return err
+		}
+	} else {
+		if err := w.updateHEAD(ref.Hash(), nil, "pull: Fast-forward"); err != nil {
+			return err
+		}
+
+		if err := w.reset(&ResetOptions{
+			Mode:   MergeReset,
+			Commit: ref.Hash(),
+		}, nil, ""); err != nil {
+			return err
+		}
 	}
 
 	if o.RecurseSubmodules != NoRecurseSubmodules {
@@ -164,6 +184,22 @@ func (w *Worktree) Checkout(opts *CheckoutOptions) error {
 		return err
 	}
 
+	from, err := w.describeHEAD()
+	if err != nil {
+		return err
+	}
+
+	to := opts.Branch.Short()
+	if !opts.Hash.IsZero() && !opts.Create {
+		to = opts.Hash.String()
+	}
+
+	return w.checkout(opts, fmt.Sprintf("checkout: moving from %s to %s", from, to))
+}
+
+// checkout checks out the validated opts, recording the update of HEAD in
+// its reflog with the given message.
+func (w *Worktree) checkout(opts *CheckoutOptions, msg string) error {
 	if opts.Create {
 		if err := w.createBranch(opts); err != nil {
 			return err
@@ -183,20 +219,31 @@ func (w *Worktree) Checkout(opts *CheckoutOptions) error {
 	}
 
 	if !opts.Hash.IsZero() && !opts.Create {
-		err = w.setHEADToCommit(opts.Hash)
+		err = w.setHEADToCommit(opts.Hash, msg)
 	} else {
-		err = w.setHEADToBranch(opts.Branch, c)
+		err = w.setHEADToBranch(opts.Branch, c, msg)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	if len(opts.SparseCheckoutDirectories) > 0 {
-		return w.ResetSparsely(ro, opts.SparseCheckoutDirectories)
+	return w.reset(ro, opts.SparseCheckoutDirectories, "")
+}
+
+// describeHEAD returns the name of the branch HEAD points to or, when it is
+// detached, the hash of the commit, as used in the reflog messages.
+func (w *Worktree) describeHEAD() (string, error) {
+	head, err := w.r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target().Short(), nil
 	}
 
-	return w.Reset(ro)
+	return head.Hash().String(), nil
 }
 
 func (w *Worktree) createBranch(opts *CheckoutOptions) error {
@@ -213,6 +260,7 @@ func (w *Worktree) createBranch(opts *CheckoutOptions) error {
 		return err
 	}
 
+	from := "HEAD"
 	if opts.Hash.IsZero() {
 		ref, err := w.r.Head()
 		if err != nil {
@@ -220,10 +268,13 @@ func (w *Worktree) createBranch(opts *CheckoutOptions) error {
 		}
 
 		opts.Hash = ref.Hash()
+	} else {
+		from = opts.Hash.String()
 	}
 
-	return w.r.Storer.SetReference(
-		plumbing.NewHashReference(opts.Branch, opts.Hash),
+	return newRefLogger(w.r.Storer, nil).setReference(
+		plumbing.NewHashReference(opts.Branch, opts.Hash), nil,
+		"branch: Created from "+from,
 	)
 }
 
@@ -257,12 +308,12 @@ func (w *Worktree) getCommitFromCheckoutOptions(opts *CheckoutOptions) (plumbing
 	return plumbing.ZeroHash, fmt.Errorf("%w: %q", object.ErrUnsupportedObject, o.Type())
 }
 
-func (w *Worktree) setHEADToCommit(commit plumbing.Hash) error {
+func (w *Worktree) setHEADToCommit(commit plumbing.Hash, msg string) error {
 	head := plumbing.NewHashReference(plumbing.HEAD, commit)
-	return w.r.Storer.SetReference(head)
+	return newRefLogger(w.r.Storer, nil).setReference(head, nil, msg)
 }
 
-func (w *Worktree) setHEADToBranch(branch plumbing.ReferenceName, commit plumbing.Hash) error {
+func (w *Worktree) setHEADToBranch(branch plumbing.ReferenceName, commit plumbing.Hash, msg string) error {
 	target, err := w.r.Storer.Reference(branch)
 	if err != nil {
 		return err
@@ -275,7 +326,7 @@ func (w *Worktree) setHEADToBranch(branch plumbing.ReferenceName, commit plumbin
 		head = plumbing.NewHashReference(plumbing.HEAD, commit)
 	}
 
-	return w.r.Storer.SetReference(head)
+	return newRefLogger(w.r.Storer, nil).setReference(head, nil, msg)
 }
 
 func (w *Worktree) ResetSparsely(opts *ResetOptions, dirs []string) error {
@@ -283,6 +334,22 @@ func (w *Worktree) ResetSparsely(opts *ResetOptions, dirs []string) error {
 		return err
 	}
 
+	// Like git, resetting paths does not move HEAD.
+	var msg string
+	if len(opts.Files) == 0 {
+		msg = fmt.Sprintf("reset: moving to %s", opts.Commit)
+	}
+
+	return w.reset(opts, dirs, msg)
+}
+
+// reset resets the worktree, recording the update of HEAD in the reflogs
+// with the given message.
+func (w *Worktree) reset(opts *ResetOptions, dirs []string, msg string) error {
+	if err := opts.Validate(w.r); err != nil {
+		return err
+	}
+
 	if opts.Mode == MergeReset {
 		unstaged, err := w.containsUnstagedChanges()
 		if err != nil {
@@ -294,10 +361,16 @@ func (w *Worktree) ResetSparsely(opts *ResetOptions, dirs []string) error {
 		}
 	}
 
-	if err := w.setHEADCommit(opts.Commit); err != nil {
+	if err := w.setHEADCommit(opts.Commit, msg); err != nil {
 		return err
 	}
 
+	if len(opts.Files) == 0 {
+		if err := w.removeMergeState(); err != nil {
+			return err
+		}
+	}
+
 	if opts.Mode == SoftReset {
 		return nil
 	}
@@ -363,6 +436,10 @@ func (w *Worktree) Reset(opts *ResetOptions) error {
 }
 
 func (w *Worktree) resetIndex(t *object.Tree, dirs []string, files []string) error {
+	if err := w.resetUnmergedEntries(files); err != nil {
+		return err
+	}
+
 	idx, err := w.r.Storer.Index()
 	if err != nil {
 		return err
@@ -424,6 +501,33 @@ func (w *Worktree) resetIndex(t *object.Tree, dirs []string, files []string) err
 	return w.r.Storer.SetIndex(idx)
 }
 
+// resetUnmergedEntries drops the conflict stages left by a merge, so the paths
+// are restored from the target tree.
+func (w *Worktree) resetUnmergedEntries(files []string) error {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	var removed bool
+	entries := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if e.Stage != index.Merged && (len(files) == 0 || inFiles(files, e.Name)) {
+			removed = true
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	if !removed {
+		return nil
+	}
+
+	idx.Entries = entries
+	return w.r.Storer.SetIndex(idx)
+}
+
 func inFiles(files []string, v string) bool {
 	v = filepath.Clean(v)
 	for _, s := range files {
@@ -447,6 +551,7 @@ func (w *Worktree) resetWorktree(t *object.Tree, files []string) error {
 	}
 	b := newIndexBuilder(idx)
 
+	var checkout []merkletrie.Change
 	for _, ch := range changes {
 		if err := w.validChange(ch); err != nil {
 			return err
@@ -470,6 +575,14 @@ func (w *Worktree) resetWorktree(t *object.Tree, files []string) error {
 			}
 		}
 
+		checkout = append(checkout, ch)
+	}
+
+	if err := w.prefetchBlobs(t, checkout); err != nil {
+		return err
+	}
+
+	for _, ch := range checkout {
 		if err := w.checkoutChange(ch, t, b); err != nil {
 			return err
 		}
@@ -633,15 +746,16 @@ func (w *Worktree) containsUnstagedChanges() (bool, error) {
 	return false, nil
 }
 
-func (w *Worktree) setHEADCommit(commit plumbing.Hash) error {
+func (w *Worktree) setHEADCommit(commit plumbing.Hash, msg string) error {
 	head, err := w.r.Reference(plumbing.HEAD, false)
 	if err != nil {
 		return err
 	}
 
+	l := newRefLogger(w.r.Storer, nil)
 	if head.Type() == plumbing.HashReference {
 		head = plumbing.NewHashReference(plumbing.HEAD, commit)
-		return w.r.Storer.SetReference(head)
+		return l.setReference(head, nil, msg)
 	}
 
 	branch, err := w.r.Reference(head.Target(), false)
@@ -654,7 +768,7 @@ func (w *Worktree) setHEADCommit(commit plumbing.Hash) error {
 	}
 
 	branch = plumbing.NewHashReference(branch.Name(), commit)
-	return w.r.Storer.SetReference(branch)
+	return l.setReference(branch, nil, msg)
 }
 
 func (w *Worktree) checkoutChangeSubmodule(name string,
@@ -0,0 +1,290 @@
+package git
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	fdiff "github.com/jesseduffield/go-git/v5/plumbing/format/diff"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+)
+
+var (
+	// ErrIndexNotClean is returned by Worktree.Am when the index contains
+	// changes which are not committed.
+	ErrIndexNotClean = errors.New("index contains uncommitted changes")
+	// ErrInvalidPatchEmail is returned when a patch email can not be parsed.
+	ErrInvalidPatchEmail = errors.New("invalid patch email")
+	// ErrEmptyPatch is returned when a patch email does not contain a patch.
+	ErrEmptyPatch = errors.New("patch is empty")
+)
+
+// Am applies the patch emails of the mailbox, like git am, committing each
+// patch with the author, the date and the message of its email. The mailbox
+// is usually written by Repository.FormatPatch or git format-patch. The
+// index must not contain uncommitted changes.
+//
+// When a patch does not apply, the following ones are not applied and the
+// hashes of the commits already created are returned along with the error,
+// see Worktree.Apply.
+func (w *Worktree) Am(mbox io.Reader, opts *AmOptions) ([]plumbing.Hash, error) {
+	if err := opts.Validate(w.r); err != nil {
+		return nil, err
+	}
+
+	emails, err := readMailbox(mbox)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fs := range s {
+		if fs.Staging != Unmodified && fs.Staging != Untracked {
+			return nil, ErrIndexNotClean
+		}
+	}
+
+	var commits []plumbing.Hash
+	for _, e := range emails {
+		patch, err := fdiff.NewUnifiedDecoder(strings.NewReader(e.patch)).Decode()
+		if err != nil {
+			return commits, err
+		}
+
+		if len(patch.FilePatches()) == 0 {
+			return commits, fmt.Errorf("%w: %s", ErrEmptyPatch, messageSubject(e.message))
+		}
+
+		err = w.Apply(patch, &ApplyOptions{Index: true, ThreeWay: opts.ThreeWay})
+		if err != nil {
+			return commits, err
+		}
+
+		author := e.author
+		h, err := w.commit(e.message, &CommitOptions{
+			Author:    &author,
+			Committer: opts.Committer,
+		}, "am")
+		if err != nil {
+			return commits, err
+		}
+
+		commits = append(commits, h)
+	}
+
+	return commits, nil
+}
+
+// patchEmail is a patch email read from a mailbox.
+type patchEmail struct {
+	author  object.Signature
+	message string
+	patch   string
+}
+
+// readMailbox reads the patch emails of a mailbox, in mbox format or made of
+// a single email.
+func readMailbox(r io.Reader) ([]*patchEmail, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []*patchEmail
+	for _, msg := range splitMailbox(strings.ReplaceAll(string(data), "\r\n", "\n")) {
+		e, err := parsePatchEmail(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, e)
+	}
+
+	return emails, nil
+}
+
+// splitMailbox splits a mailbox into its emails, which start with a "From "
+// line, at the beginning or after an empty line, followed by a header.
+func splitMailbox(mbox string) []string {
+	lines := strings.SplitAfter(mbox, "\n")
+
+	var msgs []string
+	var msg strings.Builder
+	flush := func() {
+		if strings.TrimSpace(msg.String()) != "" {
+			msgs = append(msgs, msg.String())
+		}
+
+		msg.Reset()
+	}
+
+	blank := true
+	for i, l := range lines {
+		if blank && strings.HasPrefix(l, "From ") && i+1 < len(lines) && isHeaderLine(lines[i+1]) {
+			flush()
+			continue
+		}
+
+		blank = strings.TrimSpace(l) == ""
+		msg.WriteString(l)
+	}
+
+	flush()
+	return msgs
+}
+
+func isHeaderLine(l string) bool {
+	name, _, ok := strings.Cut(l, ":")
+	return ok && name != "" && !strings.ContainsAny(name, " \t")
+}
+
+// parsePatchEmail parses an email, splitting its body into the message and
+// the patch, at the "---" line or at the first diff. Like git am, the
+// From, Date and Subject headers can be overridden at the beginning of the
+// body.
+func parsePatchEmail(msg string) (*patchEmail, error) {
+	m, err := mail.ReadMessage(strings.NewReader(msg))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatchEmail, err)
+	}
+
+	data, err := io.ReadAll(transferDecoder(m.Header.Get("Content-Transfer-Encoding"), m.Body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatchEmail, err)
+	}
+
+	header := map[string]string{
+		"From":    m.Header.Get("From"),
+		"Date":    m.Header.Get("Date"),
+		"Subject": m.Header.Get("Subject"),
+	}
+
+	body := strings.ReplaceAll(string(data), "\r\n", "\n")
+	body = parseInBodyHeader(body, header)
+
+	e := &patchEmail{}
+	if e.author, err = parseAuthor(header["From"], header["Date"]); err != nil {
+		return nil, err
+	}
+
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(header["Subject"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatchEmail, err)
+	}
+
+	var text strings.Builder
+	lines := strings.SplitAfter(body, "\n")
+	for i, l := range lines {
+		if strings.TrimRight(l, " \t\n") == "---" {
+			e.patch = strings.Join(lines[i+1:], "")
+			break
+		}
+
+		if strings.HasPrefix(l, "diff -") || strings.HasPrefix(l, "Index: ") {
+			e.patch = strings.Join(lines[i:], "")
+			break
+		}
+
+		text.WriteString(l)
+	}
+
+	e.message = cleanSubject(subject) + "\n"
+	if b := strings.TrimSpace(text.String()); b != "" {
+		e.message += "\n" + b + "\n"
+	}
+
+	return e, nil
+}
+
+// parseInBodyHeader reads the From, Date and Subject headers at the
+// beginning of the body, returning the rest of it.
+func parseInBodyHeader(body string, header map[string]string) string {
+	rest := strings.TrimLeft(body, "\n")
+	found := false
+	for {
+		line, next, _ := strings.Cut(rest, "\n")
+		name, value, ok := strings.Cut(line, ":")
+		if _, known := header[name]; !ok || !known {
+			break
+		}
+
+		header[name] = strings.TrimSpace(value)
+		rest = next
+		found = true
+	}
+
+	if !found {
+		return body
+	}
+
+	return rest
+}
+
+// parseAuthor returns the signature of the author of the patch, given the
+// From and Date headers.
+func parseAuthor(from, date string) (object.Signature, error) {
+	var sig object.Signature
+	addr, err := new(mail.AddressParser).Parse(from)
+	if err == nil {
+		sig.Name, sig.Email = addr.Name, addr.Address
+	} else {
+		// Addresses not following RFC 5322 are accepted, like git does.
+		name, email, ok := strings.Cut(from, "<")
+		if !ok {
+			return sig, fmt.Errorf("%w: invalid author %q", ErrInvalidPatchEmail, from)
+		}
+
+		sig.Name = strings.Trim(strings.TrimSpace(name), `"`)
+		sig.Email = strings.TrimSuffix(strings.TrimSpace(email), ">")
+	}
+
+	if sig.When, err = mail.ParseDate(date); err != nil {
+		return sig, fmt.Errorf("%w: %s", ErrInvalidPatchEmail, err)
+	}
+
+	return sig, nil
+}
+
+// cleanSubject removes the "Re:" and bracketed prefixes of the subject of an
+// email, such as "[PATCH 1/2]".
+func cleanSubject(s string) string {
+	for {
+		s = strings.TrimSpace(s)
+		switch {
+		case len(s) >= 3 && strings.EqualFold(s[:3], "re:"):
+			s = s[3:]
+		case strings.HasPrefix(s, "["):
+			i := strings.Index(s, "]")
+			if i < 0 {
+				return s
+			}
+
+			s = s[i+1:]
+		default:
+			return s
+		}
+	}
+}
+
+// transferDecoder decodes a body with the given Content-Transfer-Encoding.
+func transferDecoder(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	}
+
+	return r
+}
@@ -0,0 +1,322 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	fdiff "github.com/jesseduffield/go-git/v5/plumbing/format/diff"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type AmSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&AmSuite{})
+
+var amCommitter = &object.Signature{
+	Name:  "Committer",
+	Email: "committer@example.com",
+	When:  time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC),
+}
+
+// commitAs commits the files with the given author and message.
+func (s *AmSuite) commitAs(c *C, w *Worktree, author *object.Signature, msg string, files map[string]*string) plumbing.Hash {
+	for name, content := range files {
+		if content == nil {
+			_, err := w.Remove(name)
+			c.Assert(err, IsNil)
+			continue
+		}
+
+		err := util.WriteFile(w.Filesystem, name, []byte(*content), 0644)
+		c.Assert(err, IsNil)
+		_, err = w.Add(name)
+		c.Assert(err, IsNil)
+	}
+
+	h, err := w.Commit(msg, &CommitOptions{Author: author})
+	c.Assert(err, IsNil)
+	return h
+}
+
+func (s *AmSuite) newRepository(c *C) (*Repository, *Worktree) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	return r, w
+}
+
+func (s *AmSuite) TestFormatPatch(c *C) {
+	r, w := s.newRepository(c)
+
+	author := &object.Signature{
+		Name:  "A U Thor",
+		Email: "author@example.com",
+		When:  time.Date(2024, 1, 2, 15, 4, 5, 0, time.FixedZone("", 3600)),
+	}
+
+	first := s.commitAs(c, w, author, "first\n", map[string]*string{"a": str("a\n")})
+	second := s.commitAs(c, w, author, "add b\n\nThe body.\n", map[string]*string{"a": str("a\nA\n"), "b": str("b\n")})
+
+	var buf bytes.Buffer
+	err := r.FormatPatch(&buf, &FormatPatchOptions{From: first, Signature: "go-git"})
+	c.Assert(err, IsNil)
+
+	c.Assert(buf.String(), Equals, "From "+second.String()+` Mon Sep 17 00:00:00 2001
+From: A U Thor <author@example.com>
+Date: Tue, 2 Jan 2024 15:04:05 +0100
+Subject: [PATCH] add b
+
+The body.
+---
+ a | 1 +
+ b | 1 +
+ 2 files changed, 2 insertions(+)
+ create mode 100644 b
+
+diff --git a/a b/a
+index 78981922613b2afb6025042ff6bd878ac1994e85..aa00f2f88ff89db044b6fc48a329fcbf59632cf5 100644
+--- a/a
++++ b/a
+@@ -1 +1,2 @@
+ a
++A
+diff --git a/b b/b
+new file mode 100644
+index 0000000000000000000000000000000000000000..61780798228d17af2d34fce4cfbdf35556832472
+--- /dev/null
++++ b/b
+@@ -0,0 +1 @@
++b
+-- 
+go-git
+
+`)
+}
+
+func (s *AmSuite) TestFormatPatchNumbered(c *C) {
+	r, w := s.newRepository(c)
+
+	author := &object.Signature{Name: "Jöhn Dœ", Email: "john@example.com", When: time.Now()}
+	s.commitAs(c, w, author, "first", map[string]*string{"a": str("a\n")})
+	s.commitAs(c, w, author, "ünicode\n\nBödy", map[string]*string{"a": str("b\n")})
+
+	var buf bytes.Buffer
+	err := r.FormatPatch(&buf, &FormatPatchOptions{SubjectPrefix: "RFC"})
+	c.Assert(err, IsNil)
+
+	out := buf.String()
+	c.Assert(strings.Count(out, "From: =?UTF-8?q?J=C3=B6hn_D=C5=93?= <john@example.com>\n"), Equals, 2)
+	c.Assert(strings.Contains(out, "Subject: [RFC 1/2] first\n"), Equals, true)
+	c.Assert(strings.Contains(out, "Subject: [RFC 2/2] =?UTF-8?q?=C3=BCnicode?=\n"), Equals, true)
+	c.Assert(strings.Contains(out, "Content-Type: text/plain; charset=UTF-8\n"), Equals, true)
+	c.Assert(strings.Index(out, "[RFC 1/2]") < strings.Index(out, "[RFC 2/2]"), Equals, true)
+}
+
+func (s *AmSuite) TestAm(c *C) {
+	r, w := s.newRepository(c)
+
+	author := &object.Signature{
+		Name:  "Jöhn Dœ",
+		Email: "john@example.com",
+		When:  time.Date(2024, 1, 2, 15, 4, 5, 0, time.FixedZone("", -7200)),
+	}
+
+	first := s.commitAs(c, w, author, "first\n", map[string]*string{"a": str("1\n2\n3\n"), "b": str("b\n")})
+	second := s.commitAs(c, w, author, "change a\n\nThe body,\non two lines.\n",
+		map[string]*string{"a": str("1\ntwo\n3\n"), "b": nil})
+	third := s.commitAs(c, w, author, "[tag] a subject\nspanning lines\n",
+		map[string]*string{"dir/c": str("c\n")})
+
+	var buf bytes.Buffer
+	err := r.FormatPatch(&buf, &FormatPatchOptions{From: first})
+	c.Assert(err, IsNil)
+
+	err = w.Reset(&ResetOptions{Commit: first, Mode: HardReset})
+	c.Assert(err, IsNil)
+
+	commits, err := w.Am(&buf, &AmOptions{Committer: amCommitter})
+	c.Assert(err, IsNil)
+	c.Assert(commits, HasLen, 2)
+
+	for i, h := range []plumbing.Hash{second, third} {
+		expected, err := r.CommitObject(h)
+		c.Assert(err, IsNil)
+
+		commit, err := r.CommitObject(commits[i])
+		c.Assert(err, IsNil)
+
+		c.Assert(commit.TreeHash, Equals, expected.TreeHash)
+		c.Assert(commit.Author.Name, Equals, author.Name)
+		c.Assert(commit.Author.Email, Equals, author.Email)
+		c.Assert(commit.Author.When.Equal(author.When), Equals, true)
+		c.Assert(commit.Author.When.Format("-0700"), Equals, "-0200")
+		c.Assert(commit.Committer.Name, Equals, amCommitter.Name)
+	}
+
+	commit, err := r.CommitObject(commits[0])
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, "change a\n\nThe body,\non two lines.\n")
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{first})
+
+	// Like with git am, the bracketed prefixes of the subject are removed.
+	commit, err = r.CommitObject(commits[1])
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, "a subject spanning lines\n")
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, commits[1])
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *AmSuite) TestAmGitFormatPatch(c *C) {
+	r, w := s.newRepository(c)
+	s.commitAs(c, w, amCommitter, "base", map[string]*string{"f": str("a\n")})
+
+	// Written by git format-patch.
+	mbox := `From ddc2bfe6dd28eff0b0e16dbc7aedf252c1a76404 Mon Sep 17 00:00:00 2001
+From: =?UTF-8?q?J=C3=B6hn=20D=C5=93?= <a@b.c>
+Date: Sat, 17 Oct 2026 19:30:41 +0000
+Subject: [PATCH 1/2] second thing here
+
+Body line one.
+Body line two.
+---
+ f | 1 +
+ g | 1 +
+ 2 files changed, 2 insertions(+)
+ create mode 100644 g
+
+diff --git a/f b/f
+index 7898192..422c2b7 100644
+--- a/f
++++ b/f
+@@ -1 +1,2 @@
+ a
++b
+diff --git a/g b/g
+new file mode 100644
+index 0000000..587be6b
+--- /dev/null
++++ b/g
+@@ -0,0 +1 @@
++x
+-- 
+2.39.5
+
+
+From 13688c53baf29f043b0bdee9fba9be71a52c85cb Mon Sep 17 00:00:00 2001
+From: A U Thor <a@b.c>
+Date: Sat, 17 Oct 2026 19:30:41 +0000
+Subject: [PATCH 2/2] third
+
+---
+ f | 1 +
+ 1 file changed, 1 insertion(+)
+
+diff --git a/f b/f
+index 422c2b7..de98044 100644
+--- a/f
++++ b/f
+@@ -1,2 +1,3 @@
+ a
+ b
++c
+-- 
+2.39.5
+
+`
+
+	commits, err := w.Am(strings.NewReader(mbox), &AmOptions{Committer: amCommitter})
+	c.Assert(err, IsNil)
+	c.Assert(commits, HasLen, 2)
+
+	commit, err := r.CommitObject(commits[0])
+	c.Assert(err, IsNil)
+	c.Assert(commit.Author.Name, Equals, "Jöhn Dœ")
+	c.Assert(commit.Author.Email, Equals, "a@b.c")
+	c.Assert(commit.Message, Equals, "second thing here\n\nBody line one.\nBody line two.\n")
+
+	commit, err = r.CommitObject(commits[1])
+	c.Assert(err, IsNil)
+	c.Assert(commit.Author.Name, Equals, "A U Thor")
+	c.Assert(commit.Message, Equals, "third\n")
+
+	for name, content := range map[string]string{"f": "a\nb\nc\n", "g": "x\n"} {
+		data, err := util.ReadFile(w.Filesystem, name)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, content)
+	}
+}
+
+func (s *AmSuite) TestAmDoesNotApply(c *C) {
+	r, w := s.newRepository(c)
+	first := s.commitAs(c, w, amCommitter, "first", map[string]*string{"a": str("a\n")})
+	s.commitAs(c, w, amCommitter, "second", map[string]*string{"b": str("b\n")})
+	s.commitAs(c, w, amCommitter, "third", map[string]*string{"a": str("A\n")})
+
+	var buf bytes.Buffer
+	err := r.FormatPatch(&buf, &FormatPatchOptions{From: first})
+	c.Assert(err, IsNil)
+
+	err = w.Reset(&ResetOptions{Commit: first, Mode: HardReset})
+	c.Assert(err, IsNil)
+	s.commitAs(c, w, amCommitter, "changed", map[string]*string{"a": str("changed\n")})
+
+	commits, err := w.Am(&buf, &AmOptions{Committer: amCommitter})
+	c.Assert(errors.Is(err, fdiff.ErrPatchDoesNotApply), Equals, true)
+	c.Assert(commits, HasLen, 1)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash(), Equals, commits[0])
+}
+
+func (s *AmSuite) TestAmIndexNotClean(c *C) {
+	r, w := s.newRepository(c)
+	first := s.commitAs(c, w, amCommitter, "first", map[string]*string{"a": str("a\n")})
+	s.commitAs(c, w, amCommitter, "second", map[string]*string{"b": str("b\n")})
+
+	var buf bytes.Buffer
+	err := r.FormatPatch(&buf, &FormatPatchOptions{From: first})
+	c.Assert(err, IsNil)
+
+	err = w.Reset(&ResetOptions{Commit: first, Mode: HardReset})
+	c.Assert(err, IsNil)
+
+	err = util.WriteFile(w.Filesystem, "a", []byte("staged\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("a")
+	c.Assert(err, IsNil)
+
+	_, err = w.Am(&buf, &AmOptions{Committer: amCommitter})
+	c.Assert(err, Equals, ErrIndexNotClean)
+}
+
+func (s *AmSuite) TestAmEmptyPatch(c *C) {
+	_, w := s.newRepository(c)
+	s.commitAs(c, w, amCommitter, "first", map[string]*string{"a": str("a\n")})
+
+	_, err := w.Am(strings.NewReader(`From: A U Thor <author@example.com>
+Date: Tue, 2 Jan 2024 15:04:05 +0100
+Subject: [PATCH] nothing
+
+Just text.
+`), &AmOptions{Committer: amCommitter})
+	c.Assert(errors.Is(err, ErrEmptyPatch), Equals, true)
+}
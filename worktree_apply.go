@@ -0,0 +1,488 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/filemode"
+	fdiff "github.com/jesseduffield/go-git/v5/plumbing/format/diff"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/utils/diff"
+	"github.com/jesseduffield/go-git/v5/utils/ioutil"
+)
+
+// Apply applies the patch to the worktree, like git apply. The patch is
+// usually decoded by fdiff.UnifiedDecoder, but the patches between trees,
+// such as object.Patch, can be applied too. With ApplyOptions.Index the index
+// is updated too, with ApplyOptions.Cached only the index is.
+//
+// Nothing is changed if any file can not be patched, the error wraps
+// fdiff.ErrPatchDoesNotApply. With ApplyOptions.ThreeWay these files are
+// merged instead and, if the merge has conflicts, written with conflict
+// markers and ErrMergeConflict is returned.
+func (w *Worktree) Apply(patch fdiff.Patch, opts *ApplyOptions) error {
+	if opts == nil {
+		opts = &ApplyOptions{}
+	}
+
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	a := &patchApplier{w: w, opts: opts, idx: idx, files: map[string]*patchedFile{}}
+	for _, fp := range patch.FilePatches() {
+		if err := a.apply(fp); err != nil {
+			return err
+		}
+	}
+
+	if opts.Check {
+		return nil
+	}
+
+	return a.write()
+}
+
+// patchApplier applies the patches of the files, keeping the patched files in
+// memory until all of them are patched.
+type patchApplier struct {
+	w    *Worktree
+	opts *ApplyOptions
+	idx  *index.Index
+
+	files map[string]*patchedFile
+	paths []string
+}
+
+// patchedFile is a file to be patched, or already patched.
+type patchedFile struct {
+	exists  bool
+	content []byte
+	mode    filemode.FileMode
+
+	// stages are the base, ours and theirs contents of a conflicting
+	// three-way merge.
+	stages [3][]byte
+	// conflict is true when the content contains conflict markers.
+	conflict bool
+}
+
+func (a *patchApplier) apply(fp fdiff.FilePatch) error {
+	from, to := fp.Files()
+	if from == nil && to == nil {
+		return nil
+	}
+
+	var src *patchedFile
+	if from != nil {
+		var err error
+		if src, err = a.read(from.Path()); err != nil {
+			return err
+		}
+
+		if !src.exists {
+			return fmt.Errorf("%w: %s does not exist in %s", fdiff.ErrPatchDoesNotApply, from.Path(), a.target())
+		}
+	}
+
+	if to != nil && (from == nil || from.Path() != to.Path()) {
+		dst, err := a.read(to.Path())
+		if err != nil {
+			return err
+		}
+
+		if dst.exists {
+			return fmt.Errorf("%w: %s already exists in %s", fdiff.ErrPatchDoesNotApply, to.Path(), a.target())
+		}
+	}
+
+	result := &patchedFile{}
+	if src != nil {
+		result.content = src.content
+		result.mode = src.mode
+	}
+
+	content, err := applyFilePatch(a.w.r, fp, result.content)
+	switch {
+	case err == nil:
+		result.content = content
+	case errors.Is(err, fdiff.ErrPatchDoesNotApply) && a.opts.ThreeWay && from != nil && to != nil:
+		if err := a.merge(fp, result); err != nil {
+			return err
+		}
+	case errors.Is(err, fdiff.ErrPatchDoesNotApply):
+		name := to
+		if name == nil {
+			name = from
+		}
+
+		return fmt.Errorf("%s: %w", name.Path(), err)
+	default:
+		return err
+	}
+
+	if from != nil && (to == nil || from.Path() != to.Path() && !isCopyPatch(fp)) {
+		a.set(from.Path(), &patchedFile{})
+	}
+
+	if to != nil {
+		result.exists = true
+		if to.Mode() != filemode.Empty {
+			result.mode = to.Mode()
+		}
+
+		if result.mode == filemode.Empty {
+			result.mode = filemode.Regular
+		}
+
+		a.set(to.Path(), result)
+	}
+
+	return nil
+}
+
+// merge merges the changes of the patch into the file with a three-way merge,
+// using the blob the patch was made against as base.
+func (a *patchApplier) merge(fp fdiff.FilePatch, f *patchedFile) error {
+	from, _ := fp.Files()
+	if fp.IsBinary() {
+		return fmt.Errorf("%w: %s: cannot merge binary files", fdiff.ErrPatchDoesNotApply, from.Path())
+	}
+
+	base, err := a.baseBlob(from)
+	if err != nil {
+		return err
+	}
+
+	theirs, err := applyFilePatch(a.w.r, fp, base)
+	if err != nil {
+		return fmt.Errorf("%s: %w", from.Path(), err)
+	}
+
+	merged, conflict := diff.Merge(string(base), string(f.content), string(theirs), diff.MergeOptions{
+		OursLabel:   "ours",
+		TheirsLabel: "theirs",
+	})
+
+	if conflict {
+		f.stages = [3][]byte{base, f.content, theirs}
+		f.conflict = true
+	}
+
+	f.content = []byte(merged)
+	return nil
+}
+
+// baseBlob returns the content of the blob the patch of the file was made
+// against, given by the full or abbreviated hash of the patch.
+func (a *patchApplier) baseBlob(from fdiff.File) ([]byte, error) {
+	candidates := []plumbing.Hash{from.Hash()}
+	if f, ok := from.(*fdiff.UnifiedFile); ok && from.Hash().IsZero() {
+		candidates = a.w.r.resolveHashPrefix(f.HashPrefix())
+	}
+
+	for _, h := range candidates {
+		blob, err := a.w.r.BlobObject(h)
+		if err != nil {
+			continue
+		}
+
+		return readBlob(blob)
+	}
+
+	return nil, fmt.Errorf("%w: %s: repository lacks the necessary blob to perform 3-way merge",
+		fdiff.ErrPatchDoesNotApply, from.Path())
+}
+
+func (a *patchApplier) set(name string, f *patchedFile) {
+	if _, ok := a.files[name]; !ok {
+		a.paths = append(a.paths, name)
+	}
+
+	a.files[name] = f
+}
+
+// target returns where the files are patched, for the error messages.
+func (a *patchApplier) target() string {
+	if a.opts.Cached || a.opts.Index {
+		return "index"
+	}
+
+	return "working directory"
+}
+
+// read returns the file to be patched, from the previous patches, the index
+// or the worktree.
+func (a *patchApplier) read(name string) (*patchedFile, error) {
+	if f, ok := a.files[name]; ok {
+		return f, nil
+	}
+
+	if !a.opts.Cached && !a.opts.Index {
+		return a.readWorktree(name)
+	}
+
+	e, err := a.idx.Entry(name)
+	if err == index.ErrEntryNotFound {
+		if a.opts.Cached {
+			return &patchedFile{}, nil
+		}
+
+		// With Index the files must not exist in the worktree either.
+		return a.readWorktree(name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := &patchedFile{exists: true, mode: e.Mode}
+	if a.opts.Cached {
+		blob, err := a.w.r.BlobObject(e.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		f.content, err = readBlob(blob)
+		return f, err
+	}
+
+	wf, err := a.readWorktree(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !wf.exists || plumbing.ComputeHash(plumbing.BlobObject, wf.content) != e.Hash {
+		return nil, fmt.Errorf("%w: %s does not match index", fdiff.ErrPatchDoesNotApply, name)
+	}
+
+	f.content = wf.content
+	return f, nil
+}
+
+func (a *patchApplier) readWorktree(name string) (*patchedFile, error) {
+	fs := a.w.Filesystem
+	fi, err := fs.Lstat(name)
+	if os.IsNotExist(err) {
+		return &patchedFile{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := &patchedFile{exists: true}
+	if f.mode, err = filemode.NewFromOSFileMode(fi.Mode()); err != nil {
+		return nil, err
+	}
+
+	if f.mode == filemode.Symlink {
+		target, err := fs.Readlink(name)
+		if err != nil {
+			return nil, err
+		}
+
+		f.content = []byte(target)
+		return f, nil
+	}
+
+	f.content, err = util.ReadFile(fs, name)
+	return f, err
+}
+
+// write writes the patched files to the worktree and the index.
+func (a *patchApplier) write() error {
+	var conflicts []string
+	for _, name := range a.paths {
+		f := a.files[name]
+		if !a.opts.Cached {
+			if err := a.writeWorktree(name, f); err != nil {
+				return err
+			}
+		}
+
+		if a.opts.Cached || a.opts.Index {
+			if err := a.writeIndex(name, f); err != nil {
+				return err
+			}
+		}
+
+		if f.conflict {
+			conflicts = append(conflicts, name)
+		}
+	}
+
+	if a.opts.Cached || a.opts.Index {
+		if err := a.w.r.Storer.SetIndex(a.idx); err != nil {
+			return err
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: %s", ErrMergeConflict, strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
+
+func (a *patchApplier) writeWorktree(name string, f *patchedFile) (err error) {
+	fs := a.w.Filesystem
+	if !f.exists {
+		return rmFileAndDirsIfEmpty(fs, name)
+	}
+
+	// billy doesn't implement chmod, so the file is deleted to apply the
+	// mode changes.
+	if err := fs.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if f.mode == filemode.Symlink {
+		return fs.Symlink(string(f.content), name)
+	}
+
+	mode, err := f.mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	file, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(file, &err)
+	_, err = file.Write(f.content)
+	return err
+}
+
+func (a *patchApplier) writeIndex(name string, f *patchedFile) error {
+	removeUnmergedEntries(a.idx, name)
+	if _, err := a.idx.Remove(name); err != nil && err != index.ErrEntryNotFound {
+		return err
+	}
+
+	if !f.exists {
+		return nil
+	}
+
+	if f.conflict {
+		for i, content := range f.stages {
+			h, err := writeBlob(a.w.r, content)
+			if err != nil {
+				return err
+			}
+
+			a.idx.Entries = append(a.idx.Entries, &index.Entry{
+				Name:  name,
+				Hash:  h,
+				Mode:  f.mode,
+				Stage: index.AncestorMode + index.Stage(i),
+			})
+		}
+
+		return nil
+	}
+
+	h, err := writeBlob(a.w.r, f.content)
+	if err != nil {
+		return err
+	}
+
+	if a.opts.Cached {
+		e := a.idx.Add(name)
+		e.Hash = h
+		e.Mode = f.mode
+		return nil
+	}
+
+	return a.w.doAddFileToIndex(a.idx, name, h)
+}
+
+// applyFilePatch returns the content of the file patched. The patches which
+// are not decoded from a unified diff contain the whole files, or the hashes
+// of the blobs for the binary files.
+func applyFilePatch(r *Repository, fp fdiff.FilePatch, src []byte) ([]byte, error) {
+	if ufp, ok := fp.(*fdiff.UnifiedFilePatch); ok {
+		return ufp.Apply(src)
+	}
+
+	_, to := fp.Files()
+	if fp.IsBinary() {
+		if to == nil {
+			return nil, nil
+		}
+
+		blob, err := r.BlobObject(to.Hash())
+		if err != nil {
+			return nil, fmt.Errorf("%w: binary patch without data", fdiff.ErrPatchDoesNotApply)
+		}
+
+		return readBlob(blob)
+	}
+
+	var old, new bytes.Buffer
+	for _, c := range fp.Chunks() {
+		if c.Type() != fdiff.Add {
+			old.WriteString(c.Content())
+		}
+
+		if c.Type() != fdiff.Delete {
+			new.WriteString(c.Content())
+		}
+	}
+
+	if !bytes.Equal(old.Bytes(), src) {
+		return nil, fdiff.ErrPatchDoesNotApply
+	}
+
+	return new.Bytes(), nil
+}
+
+func isCopyPatch(fp fdiff.FilePatch) bool {
+	ufp, ok := fp.(*fdiff.UnifiedFilePatch)
+	return ok && ufp.IsCopy()
+}
+
+func readBlob(blob *object.Blob) (content []byte, err error) {
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+	return io.ReadAll(r)
+}
+
+func writeBlob(r *Repository, content []byte) (plumbing.Hash, error) {
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(content)))
+
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return r.Storer.SetEncodedObject(obj)
+}
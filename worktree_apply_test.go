@@ -0,0 +1,346 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	fdiff "github.com/jesseduffield/go-git/v5/plumbing/format/diff"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type ApplySuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ApplySuite{})
+
+// setupApply creates a repository with a commit with base and a commit with
+// changes, returning the patch between them, encoded and decoded again. The
+// worktree is reset to the first commit.
+func (s *ApplySuite) setupApply(c *C, base, changes map[string]*string) (*Repository, *Worktree, *fdiff.UnifiedPatch) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	first := commitFiles(c, w, base)
+	second := commitFiles(c, w, changes)
+
+	from, err := r.CommitObject(first)
+	c.Assert(err, IsNil)
+	to, err := r.CommitObject(second)
+	c.Assert(err, IsNil)
+
+	patch, err := from.Patch(to)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	err = fdiff.NewUnifiedEncoder(&buf, fdiff.DefaultContextLines).Encode(patch)
+	c.Assert(err, IsNil)
+
+	decoded, err := fdiff.NewUnifiedDecoder(&buf).Decode()
+	c.Assert(err, IsNil)
+
+	err = w.Reset(&ResetOptions{Commit: first, Mode: HardReset})
+	c.Assert(err, IsNil)
+
+	return r, w, decoded
+}
+
+func (s *ApplySuite) assertFiles(c *C, w *Worktree, files map[string]*string) {
+	for name, content := range files {
+		data, err := util.ReadFile(w.Filesystem, name)
+		if content == nil {
+			c.Assert(err, NotNil, Commentf("%s exists", name))
+			continue
+		}
+
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, *content, Commentf("while checking %s", name))
+	}
+}
+
+func (s *ApplySuite) TestApply(c *C) {
+	_, w, patch := s.setupApply(c,
+		map[string]*string{"a": str("1\n2\n3\n"), "b": str("b\n"), "dir/c": str("c\n")},
+		map[string]*string{"a": str("1\ntwo\n3\n"), "b": nil, "dir/d": str("d\n")},
+	)
+
+	err := w.Apply(patch, nil)
+	c.Assert(err, IsNil)
+
+	s.assertFiles(c, w, map[string]*string{
+		"a": str("1\ntwo\n3\n"), "b": nil, "dir/c": str("c\n"), "dir/d": str("d\n"),
+	})
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("a").Staging, Equals, Unmodified)
+	c.Assert(status.File("a").Worktree, Equals, Modified)
+	c.Assert(status.File("b").Worktree, Equals, Deleted)
+	c.Assert(status.File("dir/d").Worktree, Equals, Untracked)
+}
+
+func (s *ApplySuite) TestApplyIndex(c *C) {
+	_, w, patch := s.setupApply(c,
+		map[string]*string{"a": str("1\n2\n3\n"), "b": str("b\n")},
+		map[string]*string{"a": str("1\ntwo\n3\n"), "b": nil, "d": str("d\n")},
+	)
+
+	err := w.Apply(patch, &ApplyOptions{Index: true})
+	c.Assert(err, IsNil)
+
+	s.assertFiles(c, w, map[string]*string{"a": str("1\ntwo\n3\n"), "b": nil, "d": str("d\n")})
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status, HasLen, 3)
+	c.Assert(status.File("a").Staging, Equals, Modified)
+	c.Assert(status.File("a").Worktree, Equals, Unmodified)
+	c.Assert(status.File("b").Staging, Equals, Deleted)
+	c.Assert(status.File("d").Staging, Equals, Added)
+	c.Assert(status.File("d").Worktree, Equals, Unmodified)
+}
+
+func (s *ApplySuite) TestApplyIndexNotMatching(c *C) {
+	_, w, patch := s.setupApply(c,
+		map[string]*string{"a": str("1\n2\n3\n")},
+		map[string]*string{"a": str("1\ntwo\n3\n")},
+	)
+
+	err := util.WriteFile(w.Filesystem, "a", []byte("1\n2\n3\n4\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = w.Apply(patch, &ApplyOptions{Index: true})
+	c.Assert(errors.Is(err, fdiff.ErrPatchDoesNotApply), Equals, true)
+	c.Assert(err, ErrorMatches, ".*a does not match index")
+
+	// Without Index the worktree is patched.
+	err = w.Apply(patch, nil)
+	c.Assert(err, IsNil)
+	s.assertFiles(c, w, map[string]*string{"a": str("1\ntwo\n3\n4\n")})
+}
+
+func (s *ApplySuite) TestApplyCached(c *C) {
+	_, w, patch := s.setupApply(c,
+		map[string]*string{"a": str("1\n2\n3\n"), "b": str("b\n")},
+		map[string]*string{"a": str("1\ntwo\n3\n"), "b": nil},
+	)
+
+	err := util.WriteFile(w.Filesystem, "a", []byte("worktree\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = w.Apply(patch, &ApplyOptions{Cached: true})
+	c.Assert(err, IsNil)
+
+	s.assertFiles(c, w, map[string]*string{"a": str("worktree\n"), "b": str("b\n")})
+
+	idx, err := w.r.Storer.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Entries, HasLen, 1)
+	c.Assert(idx.Entries[0].Hash, Equals, plumbing.ComputeHash(plumbing.BlobObject, []byte("1\ntwo\n3\n")))
+}
+
+func (s *ApplySuite) TestApplyCheck(c *C) {
+	_, w, patch := s.setupApply(c,
+		map[string]*string{"a": str("a\n"), "b": str("b\n")},
+		map[string]*string{"a": str("A\n"), "b": str("B\n")},
+	)
+
+	err := w.Apply(patch, &ApplyOptions{Check: true})
+	c.Assert(err, IsNil)
+	s.assertFiles(c, w, map[string]*string{"a": str("a\n"), "b": str("b\n")})
+
+	// Nothing is patched if any file can not be patched.
+	err = util.WriteFile(w.Filesystem, "b", []byte("changed\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = w.Apply(patch, nil)
+	c.Assert(errors.Is(err, fdiff.ErrPatchDoesNotApply), Equals, true)
+	c.Assert(err, ErrorMatches, "b: patch does not apply: .*")
+	s.assertFiles(c, w, map[string]*string{"a": str("a\n"), "b": str("changed\n")})
+}
+
+func (s *ApplySuite) TestApplyExisting(c *C) {
+	_, w, patch := s.setupApply(c,
+		map[string]*string{"a": str("a\n")},
+		map[string]*string{"b": str("b\n")},
+	)
+
+	err := util.WriteFile(w.Filesystem, "b", []byte("b\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = w.Apply(patch, nil)
+	c.Assert(errors.Is(err, fdiff.ErrPatchDoesNotApply), Equals, true)
+	c.Assert(err, ErrorMatches, ".*b already exists in working directory")
+}
+
+func (s *ApplySuite) TestApplyRenameAndMode(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	commitFiles(c, w, map[string]*string{"old": str("1\n2\n3\n4\n5\n"), "script": str("run\n")})
+
+	patch, err := fdiff.NewUnifiedDecoder(strings.NewReader(`diff --git a/old b/new
+similarity index 80%
+rename from old
+rename to new
+index 8a1218a..4d4d31d 100644
+--- a/old
++++ b/new
+@@ -3,3 +3,3 @@
+ 3
+ 4
+-5
++five
+diff --git a/script b/script
+old mode 100644
+new mode 100755
+`)).Decode()
+	c.Assert(err, IsNil)
+
+	err = w.Apply(patch, &ApplyOptions{Index: true})
+	c.Assert(err, IsNil)
+
+	s.assertFiles(c, w, map[string]*string{"old": nil, "new": str("1\n2\n3\n4\nfive\n")})
+
+	fi, err := w.Filesystem.Lstat("script")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm()&0100, Not(Equals), 0)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("old").Staging, Equals, Deleted)
+	c.Assert(status.File("new").Staging, Equals, Added)
+	c.Assert(status.File("script").Staging, Equals, Modified)
+	c.Assert(status.File("script").Worktree, Equals, Unmodified)
+}
+
+func (s *ApplySuite) TestApplyThreeWay(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	base := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	commitFiles(c, w, map[string]*string{"a": str(base), "b": str(base)})
+
+	// The lines around the hunks are changed, so it doesn't apply, but
+	// merging with the original blob is clean for a and conflicting for b.
+	commitFiles(c, w, map[string]*string{
+		"a": str("1\n2\nthree\n4\n5\n6\n7\n8\n9\n"),
+		"b": str("1\n2\n3\n4\nFIVE\n6\n7\n8\n9\n"),
+	})
+
+	h := plumbing.ComputeHash(plumbing.BlobObject, []byte(base)).String()[:7]
+	patch, err := fdiff.NewUnifiedDecoder(strings.NewReader(fmt.Sprintf(`diff --git a/a b/a
+index %[1]s..0000000 100644
+--- a/a
++++ b/a
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+diff --git a/b b/b
+index %[1]s..0000000 100644
+--- a/b
++++ b/b
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`, h))).Decode()
+	c.Assert(err, IsNil)
+
+	err = w.Apply(patch, nil)
+	c.Assert(errors.Is(err, fdiff.ErrPatchDoesNotApply), Equals, true)
+
+	err = w.Apply(patch, &ApplyOptions{ThreeWay: true})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+	c.Assert(err, ErrorMatches, "merge conflict: b")
+
+	s.assertFiles(c, w, map[string]*string{
+		"a": str("1\n2\nthree\n4\nfive\n6\n7\n8\n9\n"),
+		"b": str("1\n2\n3\n4\n<<<<<<< ours\nFIVE\n=======\nfive\n>>>>>>> theirs\n6\n7\n8\n9\n"),
+	})
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+
+	stages := map[index.Stage]plumbing.Hash{}
+	for _, e := range idx.Entries {
+		if e.Name == "b" {
+			stages[e.Stage] = e.Hash
+		}
+	}
+
+	c.Assert(stages, DeepEquals, map[index.Stage]plumbing.Hash{
+		index.AncestorMode: plumbing.ComputeHash(plumbing.BlobObject, []byte(base)),
+		index.OurMode:      plumbing.ComputeHash(plumbing.BlobObject, []byte("1\n2\n3\n4\nFIVE\n6\n7\n8\n9\n")),
+		index.TheirMode:    plumbing.ComputeHash(plumbing.BlobObject, []byte("1\n2\n3\n4\nfive\n6\n7\n8\n9\n")),
+	})
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("a").Staging, Equals, Modified)
+	c.Assert(status.File("b").Staging, Equals, UpdatedButUnmerged)
+}
+
+func (s *ApplySuite) TestApplyTreePatch(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	first := commitFiles(c, w, map[string]*string{"a": str("a\n"), "b": str("b\n")})
+	second := commitFiles(c, w, map[string]*string{"a": str("A\n"), "b": nil, "c": str("c\n")})
+
+	from, err := r.CommitObject(first)
+	c.Assert(err, IsNil)
+	to, err := r.CommitObject(second)
+	c.Assert(err, IsNil)
+
+	patch, err := from.Patch(to)
+	c.Assert(err, IsNil)
+
+	err = w.Reset(&ResetOptions{Commit: first, Mode: HardReset})
+	c.Assert(err, IsNil)
+
+	err = w.Apply(patch, &ApplyOptions{Index: true})
+	c.Assert(err, IsNil)
+
+	s.assertFiles(c, w, map[string]*string{"a": str("A\n"), "b": nil, "c": str("c\n")})
+
+	tree, err := w.buildTree(mustIndex(c, r))
+	c.Assert(err, IsNil)
+	c.Assert(tree, Equals, to.TreeHash)
+}
+
+func mustIndex(c *C, r *Repository) *index.Index {
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+	return idx
+}
@@ -0,0 +1,325 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// mergeMsgFile is the file holding the message of the commit concluding a
+// revert stopped on conflicts.
+const mergeMsgFile = "MERGE_MSG"
+
+var (
+	// ErrNoCherryPickInProgress is returned when continuing or aborting a
+	// cherry-pick which was not stopped on conflicts.
+	ErrNoCherryPickInProgress = errors.New("no cherry-pick in progress")
+	// ErrNoRevertInProgress is returned when continuing or aborting a revert
+	// which was not stopped on conflicts.
+	ErrNoRevertInProgress = errors.New("no revert in progress")
+	// ErrOperationInProgress is returned when starting a cherry-pick or a
+	// revert while a merge, cherry-pick or revert is stopped on conflicts.
+	ErrOperationInProgress = errors.New("a merge, cherry-pick or revert is already in progress")
+)
+
+// CherryPick applies the changes introduced by an existing commit on top of
+// HEAD, creating a new commit which keeps the author and the message of the
+// original one. If the changes can not be applied cleanly ErrMergeConflict is
+// returned, the conflicts are left in the index and the worktree, and
+// CHERRY_PICK_HEAD is recorded until the cherry-pick is continued or aborted.
+func (w *Worktree) CherryPick(opts *CherryPickOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(w.r); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	switch {
+	case opts.Abort:
+		return plumbing.ZeroHash, w.abortPick(plumbing.CherryPickHead, ErrNoCherryPickInProgress)
+	case opts.Continue:
+		c, err := w.pickInProgress(plumbing.CherryPickHead, ErrNoCherryPickInProgress)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		return w.commitPick("cherry-pick", c.Message, &c.Author, opts.Committer)
+	}
+
+	c, err := w.r.CommitObject(opts.Commit)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	parent, err := mainlineParent(c, opts.Mainline)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	label := pickLabel(c)
+	err = w.pick(c, parent, c, &object.MergeTreesOptions{
+		OursLabel:   "HEAD",
+		BaseLabel:   "parent of " + label,
+		TheirsLabel: label,
+	}, plumbing.CherryPickHead, opts.NoCommit)
+	if err != nil || opts.NoCommit {
+		return plumbing.ZeroHash, err
+	}
+
+	return w.commitPick("cherry-pick", c.Message, &c.Author, opts.Committer)
+}
+
+// Revert creates a new commit on top of HEAD which reverses the changes
+// introduced by an existing commit. If the changes can not be reverted
+// cleanly ErrMergeConflict is returned, the conflicts are left in the index
+// and the worktree, and REVERT_HEAD is recorded until the revert is continued
+// or aborted.
+func (w *Worktree) Revert(opts *RevertOptions) (plumbing.Hash, error) {
+	if err := opts.Validate(w.r); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	switch {
+	case opts.Abort:
+		return plumbing.ZeroHash, w.abortPick(plumbing.RevertHead, ErrNoRevertInProgress)
+	case opts.Continue:
+		c, err := w.pickInProgress(plumbing.RevertHead, ErrNoRevertInProgress)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		msg, err := w.r.mergeMessage()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if msg == "" {
+			msg = revertMessage(c, opts.Mainline)
+		}
+
+		return w.commitPick("revert", msg, opts.Author, opts.Committer)
+	}
+
+	c, err := w.r.CommitObject(opts.Commit)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	parent, err := mainlineParent(c, opts.Mainline)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	label := pickLabel(c)
+	err = w.pick(c, c, parent, &object.MergeTreesOptions{
+		OursLabel:   "HEAD",
+		BaseLabel:   label,
+		TheirsLabel: "parent of " + label,
+	}, plumbing.RevertHead, opts.NoCommit)
+	msg := revertMessage(c, opts.Mainline)
+	if errors.Is(err, ErrMergeConflict) {
+		if err := w.r.setMergeMessage(msg); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	if err != nil || opts.NoCommit {
+		return plumbing.ZeroHash, err
+	}
+
+	return w.commitPick("revert", msg, opts.Author, opts.Committer)
+}
+
+// mergeMessage returns the message saved in MERGE_MSG by a revert stopped
+// on conflicts, or an empty string if there is none.
+func (r *Repository) mergeMessage() (string, error) {
+	b, err := util.ReadFile(r.stateFilesystem(), mergeMsgFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	return string(b), err
+}
+
+// setMergeMessage saves in MERGE_MSG the message of the commit concluding a
+// revert stopped on conflicts, like git does, so it is kept when the revert
+// is continued.
+func (r *Repository) setMergeMessage(msg string) error {
+	return util.WriteFile(r.stateFilesystem(), mergeMsgFile, []byte(msg), 0644)
+}
+
+// pick merges into HEAD the changes going from the commit base to the commit
+// theirs, either of them may be nil meaning an empty tree, and writes the
+// result to the index and the worktree. On conflicts the commit c is recorded
+// in the state reference.
+func (w *Worktree) pick(c, base, theirs *object.Commit, opts *object.MergeTreesOptions,
+	state plumbing.ReferenceName, noCommit bool) error {
+	for _, name := range []plumbing.ReferenceName{
+		plumbing.MergeHead,
+		plumbing.CherryPickHead,
+		plumbing.RevertHead,
+	} {
+		_, err := w.r.Storer.Reference(name)
+		if err == nil {
+			return ErrOperationInProgress
+		}
+
+		if err != plumbing.ErrReferenceNotFound {
+			return err
+		}
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return err
+	}
+
+	headCommit, err := w.r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	clean, err := w.isClean()
+	if err != nil {
+		return err
+	}
+
+	if !clean {
+		return ErrWorktreeNotClean
+	}
+
+	baseTree, err := commitTree(base)
+	if err != nil {
+		return err
+	}
+
+	oursTree, err := headCommit.Tree()
+	if err != nil {
+		return err
+	}
+
+	theirsTree, err := commitTree(theirs)
+	if err != nil {
+		return err
+	}
+
+	opts.RenameOptions = object.DefaultDiffTreeOptions
+	result, err := object.MergeTrees(w.r.Storer, baseTree, oursTree, theirsTree, opts)
+	if err != nil {
+		return err
+	}
+
+	if len(result.Conflicts) > 0 {
+		return w.checkoutConflicts(result, state, c.Hash)
+	}
+
+	if result.Tree == headCommit.TreeHash && !noCommit {
+		return ErrEmptyCommit
+	}
+
+	t, err := w.r.TreeObject(result.Tree)
+	if err != nil {
+		return err
+	}
+
+	if err := w.resetIndex(t, nil, nil); err != nil {
+		return err
+	}
+
+	return w.resetWorktree(t, nil)
+}
+
+// pickInProgress returns the commit recorded in the state reference by a
+// cherry-pick or revert stopped on conflicts.
+func (w *Worktree) pickInProgress(state plumbing.ReferenceName, errNotInProgress error) (*object.Commit, error) {
+	ref, err := w.r.Storer.Reference(state)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, errNotInProgress
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return w.r.CommitObject(ref.Hash())
+}
+
+// abortPick restores the index and the worktree to HEAD, dropping the state
+// of a cherry-pick or revert stopped on conflicts.
+func (w *Worktree) abortPick(state plumbing.ReferenceName, errNotInProgress error) error {
+	if _, err := w.pickInProgress(state, errNotInProgress); err != nil {
+		return err
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return err
+	}
+
+	return w.Reset(&ResetOptions{Commit: head.Hash(), Mode: HardReset})
+}
+
+// commitPick commits the index on top of HEAD, concluding a cherry-pick or a
+// revert, recorded in the reflogs as the given action.
+func (w *Worktree) commitPick(action, msg string, author, committer *object.Signature) (plumbing.Hash, error) {
+	head, err := w.r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return w.commit(msg, &CommitOptions{
+		Author:    author,
+		Committer: committer,
+		Parents:   []plumbing.Hash{head.Hash()},
+	}, action)
+}
+
+// mainlineParent returns the parent of c to be used as base, given the
+// mainline option. It returns nil for root commits.
+func mainlineParent(c *object.Commit, mainline int) (*object.Commit, error) {
+	switch n := c.NumParents(); {
+	case n > 1 && mainline == 0:
+		return nil, ErrMainlineRequired
+	case n > 1 && (mainline < 1 || mainline > n):
+		return nil, ErrInvalidMainline
+	case n > 1:
+		return c.Parent(mainline - 1)
+	case mainline != 0:
+		return nil, ErrMainlineNotMergeCommit
+	case n == 1:
+		return c.Parent(0)
+	}
+
+	return nil, nil
+}
+
+// commitTree returns the tree of c, or nil if c is nil.
+func commitTree(c *object.Commit) (*object.Tree, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	return c.Tree()
+}
+
+// pickLabel returns the label used in conflict markers for the commit c.
+func pickLabel(c *object.Commit) string {
+	return fmt.Sprintf("%s... %s", c.Hash.String()[:7], commitSubject(c))
+}
+
+// revertMessage returns the default message of a commit reverting c.
+func revertMessage(c *object.Commit, mainline int) string {
+	msg := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", commitSubject(c), c.Hash)
+	if mainline > 0 && mainline <= len(c.ParentHashes) {
+		msg += fmt.Sprintf(", reversing\nchanges made to %s", c.ParentHashes[mainline-1])
+	}
+
+	return msg + ".\n"
+}
+
+func commitSubject(c *object.Commit) string {
+	return messageSubject(c.Message)
+}
@@ -0,0 +1,284 @@
+package git
+
+import (
+	"errors"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type CherryPickSuite struct {
+	MergeSuite
+}
+
+var _ = Suite(&CherryPickSuite{})
+
+func (s *CherryPickSuite) TestCherryPick(c *C) {
+	r, w, ref := s.setupMerge(c,
+		map[string]*string{"a": str("1\n2\n3\n4\n5\n")},
+		map[string]*string{"a": str("one\n2\n3\n4\n5\n")},
+		map[string]*string{"a": str("1\n2\n3\n4\nfive\n"), "b": str("b\n")},
+	)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	h, err := w.CherryPick(&CherryPickOptions{Commit: ref.Hash(), Committer: mergeSignature})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{head.Hash()})
+
+	picked, err := r.CommitObject(ref.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, picked.Message)
+	c.Assert(commit.Author.Name, Equals, picked.Author.Name)
+
+	content, err := util.ReadFile(w.Filesystem, "a")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "one\n2\n3\n4\nfive\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *CherryPickSuite) TestCherryPickNoCommit(c *C) {
+	r, w, ref := s.setupMerge(c,
+		map[string]*string{"a": str("a\n")},
+		map[string]*string{"b": str("b\n")},
+		map[string]*string{"c": str("c\n")},
+	)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	h, err := w.CherryPick(&CherryPickOptions{Commit: ref.Hash(), NoCommit: true})
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, plumbing.ZeroHash)
+
+	newHead, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(newHead.Hash(), Equals, head.Hash())
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("c").Staging, Equals, Added)
+}
+
+func (s *CherryPickSuite) TestCherryPickConflictContinue(c *C) {
+	r, w, ref := s.setupMerge(c,
+		map[string]*string{"a": str("1\n2\n3\n")},
+		map[string]*string{"a": str("1\nours\n3\n")},
+		map[string]*string{"a": str("1\ntheirs\n3\n")},
+	)
+
+	_, err := w.CherryPick(&CherryPickOptions{Commit: ref.Hash(), Committer: mergeSignature})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	state, err := r.Reference(plumbing.CherryPickHead, false)
+	c.Assert(err, IsNil)
+	c.Assert(state.Hash(), Equals, ref.Hash())
+
+	content, err := util.ReadFile(w.Filesystem, "a")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "1\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> "+
+		ref.Hash().String()[:7]+"... commit\n3\n")
+
+	_, err = w.CherryPick(&CherryPickOptions{Continue: true, Committer: mergeSignature})
+	c.Assert(err, Equals, ErrUnmergedPaths)
+
+	err = util.WriteFile(w.Filesystem, "a", []byte("1\nresolved\n3\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("a")
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	h, err := w.CherryPick(&CherryPickOptions{Continue: true, Committer: mergeSignature})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{head.Hash()})
+
+	_, err = r.Reference(plumbing.CherryPickHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *CherryPickSuite) TestCherryPickAbort(c *C) {
+	r, w, ref := s.setupMerge(c,
+		map[string]*string{"a": str("1\n")},
+		map[string]*string{"a": str("2\n")},
+		map[string]*string{"a": str("3\n")},
+	)
+
+	_, err := w.CherryPick(&CherryPickOptions{Commit: ref.Hash(), Committer: mergeSignature})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	_, err = w.CherryPick(&CherryPickOptions{Commit: ref.Hash(), Committer: mergeSignature})
+	c.Assert(err, Equals, ErrOperationInProgress)
+
+	_, err = w.CherryPick(&CherryPickOptions{Abort: true})
+	c.Assert(err, IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	_, err = r.Reference(plumbing.CherryPickHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	_, err = w.CherryPick(&CherryPickOptions{Abort: true})
+	c.Assert(err, Equals, ErrNoCherryPickInProgress)
+}
+
+func (s *CherryPickSuite) TestCherryPickMainline(c *C) {
+	r, w, ref := s.setupMerge(c,
+		map[string]*string{"a": str("a\n")},
+		map[string]*string{"b": str("b\n")},
+		map[string]*string{"c": str("c\n")},
+	)
+
+	err := r.Merge(ref, MergeOptions{Strategy: RecursiveMerge, Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	merge, err := r.Head()
+	c.Assert(err, IsNil)
+
+	err = w.Reset(&ResetOptions{Commit: ref.Hash(), Mode: HardReset})
+	c.Assert(err, IsNil)
+
+	_, err = w.CherryPick(&CherryPickOptions{Commit: merge.Hash(), Committer: mergeSignature})
+	c.Assert(err, Equals, ErrMainlineRequired)
+
+	_, err = w.CherryPick(&CherryPickOptions{Commit: merge.Hash(), Mainline: 3, Committer: mergeSignature})
+	c.Assert(err, Equals, ErrInvalidMainline)
+
+	_, err = w.CherryPick(&CherryPickOptions{Commit: ref.Hash(), Mainline: 1, Committer: mergeSignature})
+	c.Assert(err, Equals, ErrMainlineNotMergeCommit)
+
+	// Picking the merge relative to the feature parent brings in the changes
+	// made on master.
+	_, err = w.CherryPick(&CherryPickOptions{Commit: merge.Hash(), Mainline: 2, Committer: mergeSignature})
+	c.Assert(err, IsNil)
+
+	content, err := util.ReadFile(w.Filesystem, "b")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "b\n")
+}
+
+func (s *CherryPickSuite) setupRevert(c *C) (*Repository, *Worktree) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	return r, w
+}
+
+func (s *CherryPickSuite) TestRevert(c *C) {
+	r, w := s.setupRevert(c)
+	commitFiles(c, w, map[string]*string{"a": str("1\n2\n3\n4\n5\n")})
+	commitFiles(c, w, map[string]*string{"a": str("one\n2\n3\n4\n5\n")})
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	h, err := w.Revert(&RevertOptions{Commit: head.Hash(), Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{head.Hash()})
+	c.Assert(commit.Message, Equals, "Revert \"commit\"\n\nThis reverts commit "+head.Hash().String()+".\n")
+
+	content, err := util.ReadFile(w.Filesystem, "a")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "1\n2\n3\n4\n5\n")
+}
+
+func (s *CherryPickSuite) TestRevertConflictContinue(c *C) {
+	r, w := s.setupRevert(c)
+	commitFiles(c, w, map[string]*string{"a": str("1\n")})
+	commitFiles(c, w, map[string]*string{"a": str("2\n")})
+
+	reverted, err := r.Head()
+	c.Assert(err, IsNil)
+	commitFiles(c, w, map[string]*string{"a": str("3\n")})
+
+	_, err = w.Revert(&RevertOptions{Commit: reverted.Hash(), Author: mergeSignature})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	state, err := r.Reference(plumbing.RevertHead, false)
+	c.Assert(err, IsNil)
+	c.Assert(state.Hash(), Equals, reverted.Hash())
+
+	err = util.WriteFile(w.Filesystem, "a", []byte("1\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("a")
+	c.Assert(err, IsNil)
+
+	h, err := w.Revert(&RevertOptions{Continue: true, Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, "Revert \"commit\"\n\nThis reverts commit "+reverted.Hash().String()+".\n")
+
+	_, err = r.Reference(plumbing.RevertHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *CherryPickSuite) TestRevertMainlineConflictContinue(c *C) {
+	r, w, ref := s.setupMerge(c,
+		map[string]*string{"a": str("1\n")},
+		map[string]*string{"b": str("b\n")},
+		map[string]*string{"a": str("2\n")},
+	)
+
+	err := r.Merge(ref, MergeOptions{Strategy: RecursiveMerge, Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	merge, err := r.Head()
+	c.Assert(err, IsNil)
+	commitFiles(c, w, map[string]*string{"a": str("3\n")})
+
+	mergeCommit, err := r.CommitObject(merge.Hash())
+	c.Assert(err, IsNil)
+
+	_, err = w.Revert(&RevertOptions{Commit: merge.Hash(), Mainline: 1, Author: mergeSignature})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	err = util.WriteFile(w.Filesystem, "a", []byte("1\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("a")
+	c.Assert(err, IsNil)
+
+	h, err := w.Revert(&RevertOptions{Continue: true, Author: mergeSignature})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, "Revert \""+commitSubject(mergeCommit)+"\"\n\nThis reverts commit "+merge.Hash().String()+
+		", reversing\nchanges made to "+mergeCommit.ParentHashes[0].String()+".\n")
+
+	msg, err := r.mergeMessage()
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "")
+}
+
+func (s *CherryPickSuite) TestRevertOptionsValidate(c *C) {
+	w := &Worktree{}
+	_, err := w.Revert(&RevertOptions{})
+	c.Assert(err, Equals, ErrMissingCommit)
+
+	_, err = w.Revert(&RevertOptions{Continue: true, Abort: true})
+	c.Assert(err, Equals, ErrContinueAndAbort)
+}
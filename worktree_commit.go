@@ -3,7 +3,9 @@ package git
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"os"
 	"path"
 	"regexp"
 	"sort"
@@ -33,6 +35,13 @@ var (
 // Commit stores the current contents of the index in a new commit along with
 // a log message from the user describing the changes.
 func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error) {
+	return w.commit(msg, opts, "")
+}
+
+// commit creates a commit, recording the update of HEAD in the reflogs with a
+// message made of the given action and the subject of the commit. The action
+// defaults to commit, qualified like git does.
+func (w *Worktree) commit(msg string, opts *CommitOptions, action string) (plumbing.Hash, error) {
 	if err := opts.Validate(w.r); err != nil {
 		return plumbing.ZeroHash, err
 	}
@@ -64,6 +73,12 @@ func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error
 		return plumbing.ZeroHash, err
 	}
 
+	for _, e := range idx.Entries {
+		if e.Stage != index.Merged {
+			return plumbing.ZeroHash, ErrUnmergedPaths
+		}
+	}
+
 	// First handle the case of the first commit in the repository being empty.
 	if len(opts.Parents) == 0 && len(idx.Entries) == 0 && !opts.AllowEmptyCommits {
 		return plumbing.ZeroHash, ErrEmptyCommit
@@ -97,7 +112,15 @@ func (w *Worktree) Commit(msg string, opts *CommitOptions) (plumbing.Hash, error
 		return plumbing.ZeroHash, err
 	}
 
-	return commit, w.updateHEAD(commit)
+	if action == "" {
+		action = commitReflogAction(opts)
+	}
+
+	if err := w.updateHEAD(commit, opts.Committer, fmt.Sprintf("%s: %s", action, messageSubject(msg))); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return commit, w.removeMergeState()
 }
 
 func (w *Worktree) autoAddModifiedAndDeleted() error {
@@ -125,7 +148,30 @@ func (w *Worktree) autoAddModifiedAndDeleted() error {
 	return w.r.Storer.SetIndex(idx)
 }
 
-func (w *Worktree) updateHEAD(commit plumbing.Hash) error {
+// commitReflogAction returns the action recorded in the reflogs for a commit
+// created with the given options.
+func commitReflogAction(opts *CommitOptions) string {
+	switch {
+	case opts.Amend:
+		return "commit (amend)"
+	case len(opts.Parents) == 0:
+		return "commit (initial)"
+	case len(opts.Parents) > 1:
+		return "commit (merge)"
+	}
+
+	return "commit"
+}
+
+// messageSubject returns the first line of a commit message.
+func messageSubject(msg string) string {
+	subject, _, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+	return subject
+}
+
+// updateHEAD points HEAD, or the branch it points to, to commit, recording
+// the update in the reflogs with the given committer and message.
+func (w *Worktree) updateHEAD(commit plumbing.Hash, committer *object.Signature, msg string) error {
 	head, err := w.r.Storer.Reference(plumbing.HEAD)
 	if err != nil {
 		return err
@@ -137,7 +183,38 @@ func (w *Worktree) updateHEAD(commit plumbing.Hash) error {
 	}
 
 	ref := plumbing.NewHashReference(name, commit)
-	return w.r.Storer.SetReference(ref)
+	return newRefLogger(w.r.Storer, committer).setReference(ref, nil, msg)
+}
+
+// removeMergeState removes the references and the MERGE_MSG recorded by a
+// merge, cherry-pick or revert stopped on conflicts, once it has been
+// committed.
+func (w *Worktree) removeMergeState() error {
+	err := w.r.stateFilesystem().Remove(mergeMsgFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, name := range []plumbing.ReferenceName{
+		plumbing.MergeHead,
+		plumbing.CherryPickHead,
+		plumbing.RevertHead,
+	} {
+		_, err := w.r.Storer.Reference(name)
+		if err == plumbing.ErrReferenceNotFound {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := w.r.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (w *Worktree) buildCommitObject(msg string, opts *CommitOptions, tree plumbing.Hash) (plumbing.Hash, error) {
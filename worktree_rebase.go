@@ -0,0 +1,435 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/format/index"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+)
+
+var (
+	// ErrRebaseInProgress is returned when starting a rebase while another
+	// one is stopped.
+	ErrRebaseInProgress = errors.New("a rebase is already in progress")
+	// ErrNoRebaseInProgress is returned when continuing or aborting a rebase
+	// which was not stopped.
+	ErrNoRebaseInProgress = errors.New("no rebase in progress")
+	// ErrRebaseExecFailed is returned when a RebaseExec entry fails, or when
+	// no RebaseOptions.Exec is given to run it. The rebase is stopped after
+	// the entry.
+	ErrRebaseExecFailed = errors.New("rebase exec failed")
+)
+
+// Rebase replays the commits of a branch on top of another commit, following
+// a todo list which may pick, reword, squash, fixup or drop each commit, and
+// run callbacks between them. Merge commits are not replayed.
+//
+// When a commit can not be applied cleanly ErrMergeConflict is returned, and
+// the rebase is stopped with the conflicts in the index and the worktree. Its
+// state is stored in the rebase-merge directory like git does, so the rebase
+// can be continued or aborted by either go-git or git.
+func (w *Worktree) Rebase(opts *RebaseOptions) error {
+	if err := opts.Validate(w.r); err != nil {
+		return err
+	}
+
+	s := &rebaseState{fs: w.r.stateFilesystem()}
+	inProgress, err := s.inProgress()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case opts.Abort || opts.Continue:
+		if !inProgress {
+			return ErrNoRebaseInProgress
+		}
+
+		if err := s.load(w.r); err != nil {
+			return err
+		}
+
+		if opts.Abort {
+			return w.abortRebase(s)
+		}
+
+		if err := w.continueRebase(s, opts); err != nil {
+			return err
+		}
+	case inProgress:
+		return ErrRebaseInProgress
+	default:
+		if err := w.startRebase(s, opts); err != nil {
+			return err
+		}
+	}
+
+	return w.runRebase(s, opts)
+}
+
+// startRebase checks out the branch to be rebased, builds the todo list and
+// detaches HEAD at the onto commit.
+func (w *Worktree) startRebase(s *rebaseState, opts *RebaseOptions) error {
+	clean, err := w.isClean()
+	if err != nil {
+		return err
+	}
+
+	if !clean {
+		return ErrWorktreeNotClean
+	}
+
+	if opts.Branch != "" {
+		if err := w.Checkout(&CheckoutOptions{Branch: opts.Branch}); err != nil {
+			return err
+		}
+	}
+
+	head, err := w.r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+
+	if head.Type() == plumbing.SymbolicReference {
+		s.headName = head.Target()
+	}
+
+	headRef, err := w.r.Head()
+	if err != nil {
+		return err
+	}
+
+	s.origHead = headRef.Hash()
+	s.onto = opts.Onto
+
+	todo, err := w.rebaseTodo(s.origHead, opts.Upstream)
+	if err != nil {
+		return err
+	}
+
+	if opts.Todo != nil {
+		if todo, err = opts.Todo(todo); err != nil {
+			return err
+		}
+	}
+
+	if err := validateRebaseTodo(todo); err != nil {
+		return err
+	}
+
+	s.todo = todo
+	if err := s.save(w.r); err != nil {
+		return err
+	}
+
+	if err := w.r.Storer.SetReference(plumbing.NewHashReference(plumbing.OrigHead, s.origHead)); err != nil {
+		return err
+	}
+
+	return w.checkout(&CheckoutOptions{Hash: s.onto}, fmt.Sprintf("rebase (start): checkout %s", s.onto))
+}
+
+// rebaseTodo returns the default todo list, picking in order the commits
+// reachable from head and not from upstream, except merges.
+func (w *Worktree) rebaseTodo(head, upstream plumbing.Hash) ([]RebaseTodo, error) {
+	headCommit, err := w.r.CommitObject(head)
+	if err != nil {
+		return nil, err
+	}
+
+	upstreamCommit, err := w.r.CommitObject(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	bases, err := headCommit.MergeBase(upstreamCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	ignore := make([]plumbing.Hash, 0, len(bases))
+	for _, b := range bases {
+		ignore = append(ignore, b.Hash)
+	}
+
+	var commits []*object.Commit
+	var merges bool
+	err = object.NewCommitPostorderIter(headCommit, ignore).ForEach(func(c *object.Commit) error {
+		if c.NumParents() > 1 {
+			merges = true
+			return nil
+		}
+
+		commits = append(commits, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var todo []RebaseTodo
+	for i := len(commits) - 1; i >= 0; i-- {
+		// Merges may bring in commits which are also reachable from upstream
+		// through a path not crossing the merge bases.
+		if merges {
+			ok, err := commits[i].IsAncestor(upstreamCommit)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				continue
+			}
+		}
+
+		todo = append(todo, RebaseTodo{Action: RebasePick, Commit: commits[i].Hash})
+	}
+
+	return todo, nil
+}
+
+// validateRebaseTodo checks that squash and fixup entries follow a commit.
+func validateRebaseTodo(todo []RebaseTodo) error {
+	var picked bool
+	for _, t := range todo {
+		switch t.Action {
+		case RebasePick, RebaseReword:
+			picked = true
+		case RebaseSquash, RebaseFixup:
+			if !picked {
+				return fmt.Errorf("%w: cannot %s without a previous commit", ErrInvalidRebaseTodo, t.Action)
+			}
+		case RebaseDrop, RebaseExec:
+		default:
+			return fmt.Errorf("%w: %s", ErrInvalidRebaseTodo, t)
+		}
+	}
+
+	return nil
+}
+
+// runRebase executes the remaining entries of the todo list, saving the state
+// before each of them, and finishes the rebase.
+func (w *Worktree) runRebase(s *rebaseState, opts *RebaseOptions) error {
+	for len(s.todo) > 0 {
+		t := s.todo[0]
+		s.todo = s.todo[1:]
+		s.done = append(s.done, t)
+
+		if err := s.save(w.r); err != nil {
+			return err
+		}
+
+		switch t.Action {
+		case RebaseDrop:
+			continue
+		case RebaseExec:
+			if opts.Exec == nil {
+				return fmt.Errorf("%w: %s: no exec callback", ErrRebaseExecFailed, t.Command)
+			}
+
+			if err := opts.Exec(w, t.Command); err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrRebaseExecFailed, t.Command, err)
+			}
+
+			continue
+		}
+
+		c, err := w.r.CommitObject(t.Commit)
+		if err != nil {
+			return err
+		}
+
+		err = w.applyRebaseTodo(t, c)
+		if errors.Is(err, ErrMergeConflict) {
+			if err := s.saveStopped(c); err != nil {
+				return err
+			}
+
+			return err
+		}
+
+		if err == ErrEmptyCommit && (t.Action == RebasePick || t.Action == RebaseReword) {
+			// The changes are already present in the new base.
+			continue
+		}
+
+		if err != nil && err != ErrEmptyCommit {
+			return err
+		}
+
+		if err := w.commitRebaseTodo(t, c, opts); err != nil {
+			return err
+		}
+	}
+
+	return w.finishRebase(s)
+}
+
+// applyRebaseTodo applies the changes of c to the index and the worktree.
+func (w *Worktree) applyRebaseTodo(t RebaseTodo, c *object.Commit) error {
+	parent, err := mainlineParent(c, 0)
+	if err != nil {
+		return err
+	}
+
+	head, err := w.r.Head()
+	if err != nil {
+		return err
+	}
+
+	if t.Action == RebasePick && parent != nil && parent.Hash == head.Hash() {
+		// Fast-forward, the commit is kept as it is.
+		return w.reset(&ResetOptions{Commit: c.Hash, Mode: MergeReset}, nil,
+			fmt.Sprintf("rebase (%s): %s", t.Action, commitSubject(c)))
+	}
+
+	label := fmt.Sprintf("%s (%s)", c.Hash.String()[:7], commitSubject(c))
+	return w.pick(c, parent, c, &object.MergeTreesOptions{
+		OursLabel:   "HEAD",
+		BaseLabel:   "parent of " + label,
+		TheirsLabel: label,
+	}, plumbing.RebaseHead, false)
+}
+
+// commitRebaseTodo commits the index once the changes of c have been applied.
+func (w *Worktree) commitRebaseTodo(t RebaseTodo, c *object.Commit, opts *RebaseOptions) error {
+	head, err := w.r.Head()
+	if err != nil {
+		return err
+	}
+
+	if head.Hash() == c.Hash {
+		// Fast-forwarded.
+		return nil
+	}
+
+	msg := c.Message
+	co := &CommitOptions{
+		Author:    &c.Author,
+		Committer: opts.Committer,
+		Parents:   []plumbing.Hash{head.Hash()},
+	}
+
+	if t.Action == RebaseSquash || t.Action == RebaseFixup {
+		headCommit, err := w.r.CommitObject(head.Hash())
+		if err != nil {
+			return err
+		}
+
+		msg = headCommit.Message
+		if t.Action == RebaseSquash {
+			msg = strings.TrimRight(msg, "\n") + "\n\n" + c.Message
+		}
+
+		co.Author = &headCommit.Author
+		co.Parents = nil
+		co.Amend = true
+		co.AllowEmptyCommits = true
+	}
+
+	if (t.Action == RebaseReword || t.Action == RebaseSquash) && opts.Message != nil {
+		if msg, err = opts.Message(c, msg); err != nil {
+			return err
+		}
+	}
+
+	_, err = w.commit(msg, co, fmt.Sprintf("rebase (%s)", t.Action))
+	return err
+}
+
+// continueRebase commits the resolution of the conflicts the rebase stopped
+// on, if any.
+func (w *Worktree) continueRebase(s *rebaseState, opts *RebaseOptions) error {
+	ref, err := w.r.Storer.Reference(plumbing.RebaseHead)
+	if err == plumbing.ErrReferenceNotFound {
+		// Stopped on a failed exec.
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Stage != index.Merged {
+			return ErrUnmergedPaths
+		}
+	}
+
+	c, err := w.r.CommitObject(ref.Hash())
+	if err != nil {
+		return err
+	}
+
+	t := RebaseTodo{Action: RebasePick, Commit: c.Hash}
+	if len(s.done) > 0 {
+		t = s.done[len(s.done)-1]
+	}
+
+	err = w.commitRebaseTodo(t, c, opts)
+	if err != nil && err != ErrEmptyCommit {
+		return err
+	}
+
+	if err := w.r.Storer.RemoveReference(plumbing.RebaseHead); err != nil {
+		return err
+	}
+
+	return s.clearStopped()
+}
+
+// finishRebase points the rebased branch to the new HEAD and checks it out.
+func (w *Worktree) finishRebase(s *rebaseState) error {
+	if s.headName != "" {
+		head, err := w.r.Head()
+		if err != nil {
+			return err
+		}
+
+		l := newRefLogger(w.r.Storer, nil)
+		branch := plumbing.NewHashReference(s.headName, head.Hash())
+		if err := l.setReference(branch, nil, fmt.Sprintf("rebase (finish): %s onto %s", s.headName, s.onto)); err != nil {
+			return err
+		}
+
+		ref := plumbing.NewSymbolicReference(plumbing.HEAD, s.headName)
+		if err := l.setReference(ref, nil, fmt.Sprintf("rebase (finish): returning to %s", s.headName)); err != nil {
+			return err
+		}
+	}
+
+	return s.remove()
+}
+
+// abortRebase restores the rebased branch and checks it out.
+func (w *Worktree) abortRebase(s *rebaseState) error {
+	if err := w.r.Storer.RemoveReference(plumbing.RebaseHead); err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("rebase (abort): returning to %s", s.origHead)
+	if s.headName != "" {
+		ref := plumbing.NewSymbolicReference(plumbing.HEAD, s.headName)
+		if err := newRefLogger(w.r.Storer, nil).setReference(ref, nil, fmt.Sprintf("rebase (abort): returning to %s", s.headName)); err != nil {
+			return err
+		}
+
+		// The branch was not modified by the rebase.
+		msg = ""
+	}
+
+	if err := w.reset(&ResetOptions{Commit: s.origHead, Mode: HardReset}, nil, msg); err != nil {
+		return err
+	}
+
+	return s.remove()
+}
@@ -0,0 +1,315 @@
+package git
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jesseduffield/go-git/v5/plumbing"
+	"github.com/jesseduffield/go-git/v5/plumbing/cache"
+	"github.com/jesseduffield/go-git/v5/plumbing/object"
+	"github.com/jesseduffield/go-git/v5/storage/filesystem"
+	"github.com/jesseduffield/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type RebaseSuite struct {
+	MergeSuite
+}
+
+var _ = Suite(&RebaseSuite{})
+
+// setupRebase creates a repository where master gets one commit and the
+// branch feature two commits, diverging from a common commit. The feature
+// branch is left checked out.
+func (s *RebaseSuite) setupRebase(c *C, r *Repository, feature ...map[string]*string) (*Worktree, plumbing.Hash) {
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	commitFiles(c, w, map[string]*string{"a": str("1\n2\n3\n")})
+
+	branch := plumbing.NewBranchReferenceName("feature")
+	err = w.Checkout(&CheckoutOptions{Branch: branch, Create: true})
+	c.Assert(err, IsNil)
+	for _, files := range feature {
+		commitFiles(c, w, files)
+	}
+
+	err = w.Checkout(&CheckoutOptions{Branch: plumbing.Master})
+	c.Assert(err, IsNil)
+	master := commitFiles(c, w, map[string]*string{"a": str("1\n2\nmaster\n")})
+
+	err = w.Checkout(&CheckoutOptions{Branch: branch})
+	c.Assert(err, IsNil)
+
+	return w, master
+}
+
+// rebaseLog returns the messages of the commits of HEAD, newest first.
+func rebaseLog(c *C, r *Repository) []string {
+	iter, err := r.Log(&LogOptions{})
+	c.Assert(err, IsNil)
+
+	var msgs []string
+	err = iter.ForEach(func(commit *object.Commit) error {
+		msgs = append(msgs, commit.Message)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	return msgs
+}
+
+func (s *RebaseSuite) TestRebase(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, master := s.setupRebase(c, r,
+		map[string]*string{"b": str("b\n")},
+		map[string]*string{"a": str("one\n2\n3\n")},
+	)
+
+	err = w.Rebase(&RebaseOptions{Upstream: master, Committer: mergeSignature})
+	c.Assert(err, IsNil)
+
+	head, err := r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.NewBranchReferenceName("feature"))
+
+	c.Assert(rebaseLog(c, r), DeepEquals, []string{"commit", "commit", "commit", "commit"})
+
+	commit, err := r.CommitObject(mustHead(c, r))
+	c.Assert(err, IsNil)
+	parent, err := commit.Parent(0)
+	c.Assert(err, IsNil)
+	grandparent, err := parent.Parent(0)
+	c.Assert(err, IsNil)
+	c.Assert(grandparent.Hash, Equals, master)
+
+	content, err := util.ReadFile(w.Filesystem, "a")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "one\n2\nmaster\n")
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	origHead, err := r.Reference(plumbing.OrigHead, false)
+	c.Assert(err, IsNil)
+	c.Assert(origHead.Hash().IsZero(), Equals, false)
+}
+
+func (s *RebaseSuite) TestRebaseTodo(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, master := s.setupRebase(c, r,
+		map[string]*string{"b": str("b\n")},
+		map[string]*string{"c": str("c\n")},
+		map[string]*string{"d": str("d\n")},
+		map[string]*string{"e": str("e\n")},
+		map[string]*string{"f": str("f\n")},
+	)
+
+	var execs []string
+	err = w.Rebase(&RebaseOptions{
+		Upstream:  master,
+		Committer: mergeSignature,
+		Todo: func(todo []RebaseTodo) ([]RebaseTodo, error) {
+			c.Assert(todo, HasLen, 5)
+			todo[1].Action = RebaseSquash
+			todo[2].Action = RebaseFixup
+			todo[3].Action = RebaseDrop
+			todo[4].Action = RebaseReword
+			return append(todo, RebaseTodo{Action: RebaseExec, Command: "test"}), nil
+		},
+		Message: func(commit *object.Commit, msg string) (string, error) {
+			if strings.HasPrefix(msg, "commit\n\ncommit") {
+				return "squashed\n", nil
+			}
+
+			return "reworded\n", nil
+		},
+		Exec: func(w *Worktree, command string) error {
+			execs = append(execs, command)
+			return nil
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(execs, DeepEquals, []string{"test"})
+	c.Assert(rebaseLog(c, r), DeepEquals, []string{"reworded\n", "squashed\n", "commit", "commit"})
+
+	for name, exists := range map[string]bool{"b": true, "c": true, "d": true, "e": false, "f": true} {
+		_, err := w.Filesystem.Stat(name)
+		c.Assert(err == nil, Equals, exists, Commentf("file %s", name))
+	}
+}
+
+func (s *RebaseSuite) TestRebaseConflictContinue(c *C) {
+	dotgit := memfs.New()
+	r, err := Init(filesystem.NewStorage(dotgit, cache.NewObjectLRUDefault()), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, master := s.setupRebase(c, r,
+		map[string]*string{"a": str("1\n2\nfeature\n")},
+		map[string]*string{"b": str("b\n")},
+	)
+
+	err = w.Rebase(&RebaseOptions{Upstream: master, Committer: mergeSignature})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	// The state is stored like git does.
+	headName, err := util.ReadFile(dotgit, "rebase-merge/head-name")
+	c.Assert(err, IsNil)
+	c.Assert(string(headName), Equals, "refs/heads/feature\n")
+
+	onto, err := util.ReadFile(dotgit, "rebase-merge/onto")
+	c.Assert(err, IsNil)
+	c.Assert(string(onto), Equals, master.String()+"\n")
+
+	todo, err := util.ReadFile(dotgit, "rebase-merge/git-rebase-todo")
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(todo), "pick "), Equals, true)
+	c.Assert(strings.Count(string(todo), "\n"), Equals, 1)
+
+	rebaseHead, err := r.Reference(plumbing.RebaseHead, false)
+	c.Assert(err, IsNil)
+
+	stopped, err := util.ReadFile(dotgit, "rebase-merge/stopped-sha")
+	c.Assert(err, IsNil)
+	c.Assert(string(stopped), Equals, rebaseHead.Hash().String()+"\n")
+
+	err = w.Rebase(&RebaseOptions{Upstream: master, Committer: mergeSignature})
+	c.Assert(err, Equals, ErrRebaseInProgress)
+
+	err = w.Rebase(&RebaseOptions{Continue: true, Committer: mergeSignature})
+	c.Assert(err, Equals, ErrUnmergedPaths)
+
+	err = util.WriteFile(w.Filesystem, "a", []byte("1\n2\nresolved\n"), 0644)
+	c.Assert(err, IsNil)
+	_, err = w.Add("a")
+	c.Assert(err, IsNil)
+
+	err = w.Rebase(&RebaseOptions{Continue: true, Committer: mergeSignature})
+	c.Assert(err, IsNil)
+
+	head, err := r.Reference(plumbing.HEAD, false)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.NewBranchReferenceName("feature"))
+	c.Assert(rebaseLog(c, r), HasLen, 4)
+
+	_, err = dotgit.Stat("rebase-merge")
+	c.Assert(err, NotNil)
+
+	_, err = r.Reference(plumbing.RebaseHead, false)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *RebaseSuite) TestRebaseAbort(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, master := s.setupRebase(c, r,
+		map[string]*string{"a": str("1\n2\nfeature\n")},
+	)
+
+	orig, err := r.Head()
+	c.Assert(err, IsNil)
+
+	err = w.Rebase(&RebaseOptions{Upstream: master, Committer: mergeSignature})
+	c.Assert(errors.Is(err, ErrMergeConflict), Equals, true)
+
+	err = w.Rebase(&RebaseOptions{Abort: true})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, orig.Name())
+	c.Assert(head.Hash(), Equals, orig.Hash())
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	err = w.Rebase(&RebaseOptions{Abort: true})
+	c.Assert(err, Equals, ErrNoRebaseInProgress)
+}
+
+func (s *RebaseSuite) TestRebaseExecFailed(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, master := s.setupRebase(c, r,
+		map[string]*string{"b": str("b\n")},
+	)
+
+	fail := errors.New("failed")
+	err = w.Rebase(&RebaseOptions{
+		Upstream:  master,
+		Committer: mergeSignature,
+		Todo: func(todo []RebaseTodo) ([]RebaseTodo, error) {
+			return append([]RebaseTodo{{Action: RebaseExec, Command: "check"}}, todo...), nil
+		},
+		Exec: func(w *Worktree, command string) error { return fail },
+	})
+	c.Assert(errors.Is(err, ErrRebaseExecFailed), Equals, true)
+	c.Assert(errors.Is(err, fail), Equals, true)
+
+	err = w.Rebase(&RebaseOptions{Continue: true, Committer: mergeSignature})
+	c.Assert(err, IsNil)
+	c.Assert(rebaseLog(c, r), HasLen, 3)
+}
+
+func (s *RebaseSuite) TestRebaseInvalidTodo(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, master := s.setupRebase(c, r,
+		map[string]*string{"b": str("b\n")},
+	)
+
+	err = w.Rebase(&RebaseOptions{
+		Upstream:  master,
+		Committer: mergeSignature,
+		Todo: func(todo []RebaseTodo) ([]RebaseTodo, error) {
+			todo[0].Action = RebaseFixup
+			return todo, nil
+		},
+	})
+	c.Assert(errors.Is(err, ErrInvalidRebaseTodo), Equals, true)
+}
+
+func (s *RebaseSuite) TestParseTodoLine(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+	h := commitFiles(c, w, map[string]*string{"a": str("a\n")})
+
+	for line, expected := range map[string]RebaseTodo{
+		"pick " + h.String() + " subject": {Action: RebasePick, Commit: h},
+		"s " + h.String()[:7]:             {Action: RebaseSquash, Commit: h},
+		"fixup " + h.String():             {Action: RebaseFixup, Commit: h},
+		"exec make test":                  {Action: RebaseExec, Command: "make test"},
+	} {
+		t, ok, err := parseTodoLine(r, line)
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, true)
+		c.Assert(t, DeepEquals, expected)
+	}
+
+	_, ok, err := parseTodoLine(r, "# comment")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+
+	_, _, err = parseTodoLine(r, "edit "+h.String())
+	c.Assert(errors.Is(err, ErrInvalidRebaseTodo), Equals, true)
+}
+
+func mustHead(c *C, r *Repository) plumbing.Hash {
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	return head.Hash()
+}
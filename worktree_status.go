@@ -117,9 +117,33 @@ func (w *Worktree) status(ss StatusStrategy, commit plumbing.Hash) (Status, erro
 		}
 	}
 
+	if err := w.addUnmergedStatus(s); err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
 
+// addUnmergedStatus flags the paths with conflict stages in the index.
+func (w *Worktree) addUnmergedStatus(s Status) error {
+	idx, err := w.r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range idx.Entries {
+		if e.Stage == index.Merged {
+			continue
+		}
+
+		fs := s.File(e.Name)
+		fs.Staging = UpdatedButUnmerged
+		fs.Worktree = UpdatedButUnmerged
+	}
+
+	return nil
+}
+
 func nameFromAction(ch *merkletrie.Change) string {
 	name := ch.To.String()
 	if name == "" {
@@ -532,6 +556,9 @@ func (w *Worktree) fillEncodedObjectFromSymlink(dst io.Writer, path string, _ os
 }
 
 func (w *Worktree) addOrUpdateFileToIndex(idx *index.Index, filename string, h plumbing.Hash) error {
+	// Adding a conflicting path marks it as resolved.
+	removeUnmergedEntries(idx, filename)
+
 	e, err := idx.Entry(filename)
 	if err != nil && err != index.ErrEntryNotFound {
 		return err
@@ -649,6 +676,10 @@ func (w *Worktree) doRemoveFile(idx *index.Index, path string) (plumbing.Hash, e
 }
 
 func (w *Worktree) deleteFromIndex(idx *index.Index, path string) (plumbing.Hash, error) {
+	if removeUnmergedEntries(idx, path) {
+		return plumbing.ZeroHash, nil
+	}
+
 	e, err := idx.Remove(path)
 	if err != nil {
 		return plumbing.ZeroHash, err
@@ -657,6 +688,26 @@ func (w *Worktree) deleteFromIndex(idx *index.Index, path string) (plumbing.Hash
 	return e.Hash, nil
 }
 
+// removeUnmergedEntries removes the conflict stages of the given path from the
+// index, returning true if there was any.
+func removeUnmergedEntries(idx *index.Index, path string) bool {
+	path = filepath.ToSlash(path)
+
+	var removed bool
+	entries := idx.Entries[:0]
+	for _, e := range idx.Entries {
+		if e.Name == path && e.Stage != index.Merged {
+			removed = true
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	idx.Entries = entries
+	return removed
+}
+
 func (w *Worktree) deleteFromFilesystem(path string) error {
 	err := w.Filesystem.Remove(path)
 	if os.IsNotExist(err) {